@@ -75,6 +75,7 @@ func (a *App) Run() error {
 
 	dmetrics.Register(metrics.NodeosMetricset)
 	dmetrics.Register(metrics.Metricset)
+	metrics.RegisterHistograms()
 
 	a.OnTerminating(func(err error) {
 		a.modules.Operator.Shutdown(err)
@@ -72,6 +72,7 @@ func (a *App) Run() error {
 
 	dmetrics.Register(metrics.NodeosMetricset)
 	dmetrics.Register(metrics.Metricset)
+	metrics.RegisterHistograms()
 
 	err := mindreader.RunGRPCServer(a.modules.GrpcServer, a.config.GRPCAddr, a.zlogger)
 	if err != nil {
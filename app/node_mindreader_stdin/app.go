@@ -16,6 +16,7 @@ package node_mindreader_stdin
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -103,7 +104,12 @@ func (a *App) Run() error {
 	}
 
 	a.zlogger.Debug("configuring shutter")
-	mindreaderLogPlugin.OnTerminated(a.Shutdown)
+	mindreaderLogPlugin.OnTerminated(func(err error) {
+		if errors.Is(err, nodeManager.ErrCleanStop) {
+			err = nil
+		}
+		a.Shutdown(err)
+	})
 	a.OnTerminating(mindreaderLogPlugin.Shutdown)
 
 	if a.modules.RegisterGRPCService != nil {
@@ -0,0 +1,299 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dstorefault injects faults (errors and latency) in the operations of a `dstore.Store`,
+// to exercise how the mindreader and the operator react to a failing or slow archive store, in
+// tests and chaos drills. See `Wrap`.
+package dstorefault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dstore"
+)
+
+// Operation is a faultable operation of a `dstore.Store`
+type Operation string
+
+const (
+	OpenObject    Operation = "OpenObject"
+	FileExists    Operation = "FileExists"
+	WriteObject   Operation = "WriteObject"
+	PushLocalFile Operation = "PushLocalFile"
+	Walk          Operation = "Walk"
+	WalkFrom      Operation = "WalkFrom"
+	ListFiles     Operation = "ListFiles"
+	DeleteObject  Operation = "DeleteObject"
+)
+
+// ErrInjected is returned by a fault having neither an error nor a latency
+var ErrInjected = errors.New("injected store fault")
+
+// ErrForbidden mimics a store denying access, e.g. expired credentials answered by a 403
+var ErrForbidden = errors.New("injected store fault: 403 forbidden")
+
+// Fault is a scripted misbehavior of the store, it applies to the calls of its operations on
+// objects matching its names while it is active, the calls being counted from then. Its `Latency`
+// is waited, then its `Err` returned, instead of running the operation.
+type Fault struct {
+	Operations []Operation            // operations faulted, all of them when empty
+	Names      func(name string) bool // objects (or prefixes) faulted, all of them when nil
+
+	Err     error         // returned instead of running the operation, `ErrInjected` when no latency either
+	Latency time.Duration // waited before the operation, or before returning `Err`
+
+	Skip  int // first matching calls not faulted
+	Every int // faults every Nth matching call after the skipped ones, every call when 0 or 1
+	Times int // stops faulting after this many faulted calls, unlimited when 0
+
+	StartAfter time.Duration // faults nothing until this elapsed since the policy was set
+	Lasts      time.Duration // faults nothing once this elapsed since `StartAfter`, forever when 0
+}
+
+// FaultPolicy lists the faults of a store. A call waits the latency of every fault applying to
+// it, then returns the error of the first one having one.
+type FaultPolicy struct {
+	Faults []Fault
+}
+
+func (f *Fault) matches(op Operation, name string) bool {
+	if len(f.Operations) > 0 {
+		found := false
+		for _, candidate := range f.Operations {
+			if candidate == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.Names == nil || f.Names(name)
+}
+
+func (f *Fault) active(elapsed time.Duration) bool {
+	if elapsed < f.StartAfter {
+		return false
+	}
+	return f.Lasts == 0 || elapsed < f.StartAfter+f.Lasts
+}
+
+type faultState struct {
+	fault    Fault
+	matched  int
+	injected int
+}
+
+// applies counts the call matching the fault and reports if it is faulted
+func (s *faultState) applies(elapsed time.Duration) bool {
+	if !s.fault.active(elapsed) {
+		return false
+	}
+
+	s.matched++
+	if s.matched <= s.fault.Skip {
+		return false
+	}
+	if s.fault.Every > 1 && (s.matched-s.fault.Skip)%s.fault.Every != 0 {
+		return false
+	}
+	if s.fault.Times > 0 && s.injected >= s.fault.Times {
+		return false
+	}
+
+	s.injected++
+	return true
+}
+
+type policyState struct {
+	lock     sync.Mutex
+	now      func() time.Time
+	setAt    time.Time
+	faults   []*faultState
+	calls    map[Operation]int
+	injected map[Operation]int
+}
+
+func (p *policyState) set(policy FaultPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.setAt = p.now()
+	p.faults = make([]*faultState, len(policy.Faults))
+	for i, fault := range policy.Faults {
+		p.faults[i] = &faultState{fault: fault}
+	}
+	p.calls = map[Operation]int{}
+	p.injected = map[Operation]int{}
+}
+
+// fault returns the latency and error to inject in the call of `op` on `name`
+func (p *policyState) fault(op Operation, name string) (latency time.Duration, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.calls[op]++
+	elapsed := p.now().Sub(p.setAt)
+
+	faulted := false
+	for _, state := range p.faults {
+		if !state.fault.matches(op, name) || !state.applies(elapsed) {
+			continue
+		}
+
+		faulted = true
+		latency += state.fault.Latency
+		if err == nil {
+			err = state.fault.Err
+			if err == nil && state.fault.Latency == 0 {
+				err = ErrInjected
+			}
+		}
+	}
+
+	if faulted {
+		p.injected[op]++
+	}
+	return latency, err
+}
+
+// Store is a `dstore.Store` injecting the faults of its policy in the calls to the wrapped store,
+// see `Wrap`. The other methods (`ObjectPath`, `BaseURL`, ...) are never faulted.
+type Store struct {
+	dstore.Store
+
+	policy *policyState
+}
+
+// Wrap returns `store` injecting the faults of `policy`, which can be replaced at any time with
+// `SetPolicy`. For example, a store denying access for 10 minutes, failing every 50th write
+// and with uploads taking 30 seconds:
+//
+//	dstorefault.Wrap(store, dstorefault.FaultPolicy{Faults: []dstorefault.Fault{
+//		{Err: dstorefault.ErrForbidden, Lasts: 10 * time.Minute},
+//		{Operations: []dstorefault.Operation{dstorefault.WriteObject}, Every: 50},
+//		{Operations: []dstorefault.Operation{dstorefault.WriteObject, dstorefault.PushLocalFile}, Latency: 30 * time.Second},
+//	}})
+func Wrap(store dstore.Store, policy FaultPolicy) *Store {
+	s := &Store{Store: store, policy: &policyState{now: time.Now}}
+	s.policy.set(policy)
+	return s
+}
+
+// SetPolicy replaces the faults injected, resetting the counts and the time they are relative to
+func (s *Store) SetPolicy(policy FaultPolicy) {
+	s.policy.set(policy)
+}
+
+// Calls returns how many times `op` was called since the policy was set, faulted or not
+func (s *Store) Calls(op Operation) int {
+	s.policy.lock.Lock()
+	defer s.policy.lock.Unlock()
+
+	return s.policy.calls[op]
+}
+
+// Injected returns how many calls of `op` were faulted since the policy was set
+func (s *Store) Injected(op Operation) int {
+	s.policy.lock.Lock()
+	defer s.policy.lock.Unlock()
+
+	return s.policy.injected[op]
+}
+
+// inject waits the latency of the faults of the call of `op` on `name`, returning their error
+func (s *Store) inject(ctx context.Context, op Operation, name string) error {
+	latency, err := s.policy.fault(op, name)
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+func (s *Store) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := s.inject(ctx, OpenObject, name); err != nil {
+		return nil, err
+	}
+	return s.Store.OpenObject(ctx, name)
+}
+
+func (s *Store) FileExists(ctx context.Context, base string) (bool, error) {
+	if err := s.inject(ctx, FileExists, base); err != nil {
+		return false, err
+	}
+	return s.Store.FileExists(ctx, base)
+}
+
+func (s *Store) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	if err := s.inject(ctx, WriteObject, base); err != nil {
+		return err
+	}
+	return s.Store.WriteObject(ctx, base, f)
+}
+
+func (s *Store) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+	if err := s.inject(ctx, PushLocalFile, toBaseName); err != nil {
+		return err
+	}
+	return s.Store.PushLocalFile(ctx, localFile, toBaseName)
+}
+
+func (s *Store) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	if err := s.inject(ctx, Walk, prefix); err != nil {
+		return err
+	}
+	return s.Store.Walk(ctx, prefix, f)
+}
+
+func (s *Store) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) error) error {
+	if err := s.inject(ctx, WalkFrom, prefix); err != nil {
+		return err
+	}
+	return s.Store.WalkFrom(ctx, prefix, startingPoint, f)
+}
+
+func (s *Store) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	if err := s.inject(ctx, ListFiles, prefix); err != nil {
+		return nil, err
+	}
+	return s.Store.ListFiles(ctx, prefix, max)
+}
+
+func (s *Store) DeleteObject(ctx context.Context, base string) error {
+	if err := s.inject(ctx, DeleteObject, base); err != nil {
+		return err
+	}
+	return s.Store.DeleteObject(ctx, base)
+}
+
+// SubStore returns the sub store of the wrapped store sharing the policy, and its counts, of `s`
+func (s *Store) SubStore(subFolder string) (dstore.Store, error) {
+	sub, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Store: sub, policy: s.policy}, nil
+}
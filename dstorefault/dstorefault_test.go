@@ -0,0 +1,121 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dstorefault
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, policy FaultPolicy) (*Store, *time.Time) {
+	t.Helper()
+
+	local, err := dstore.NewStore(t.TempDir(), "txt", "", true)
+	require.NoError(t, err)
+
+	now := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	s := &Store{Store: local, policy: &policyState{now: func() time.Time { return now }}}
+	s.SetPolicy(policy)
+	return s, &now
+}
+
+func write(s *Store, name string) error {
+	return s.WriteObject(context.Background(), name, bytes.NewBufferString(name))
+}
+
+func TestStore_Counts(t *testing.T) {
+	failure := errors.New("write failed")
+	s, _ := newTestStore(t, FaultPolicy{Faults: []Fault{{Operations: []Operation{WriteObject}, Err: failure, Skip: 2, Every: 3, Times: 2}}})
+
+	var failed []int
+	for i := 1; i <= 15; i++ {
+		if err := write(s, "file"); err != nil {
+			assert.Equal(t, failure, err)
+			failed = append(failed, i)
+		}
+	}
+	assert.Equal(t, []int{5, 8}, failed, "skipping 2 calls, every 3rd call, twice")
+	assert.Equal(t, 15, s.Calls(WriteObject))
+	assert.Equal(t, 2, s.Injected(WriteObject))
+
+	exists, err := s.FileExists(context.Background(), "file")
+	require.NoError(t, err, "other operations not faulted")
+	assert.True(t, exists)
+}
+
+func TestStore_Durations(t *testing.T) {
+	s, now := newTestStore(t, FaultPolicy{Faults: []Fault{{Err: ErrForbidden, StartAfter: time.Minute, Lasts: 10 * time.Minute}}})
+
+	assert.NoError(t, write(s, "before"))
+
+	*now = now.Add(time.Minute)
+	assert.Equal(t, ErrForbidden, write(s, "during"))
+	_, err := s.ListFiles(context.Background(), "", 10)
+	assert.Equal(t, ErrForbidden, err)
+
+	*now = now.Add(10 * time.Minute)
+	assert.NoError(t, write(s, "after"))
+
+	s.SetPolicy(FaultPolicy{Faults: []Fault{{Err: ErrForbidden, Lasts: time.Minute}}})
+	assert.Equal(t, ErrForbidden, write(s, "reset"), "durations relative to the new policy")
+	assert.Equal(t, 1, s.Calls(WriteObject), "counts reset with the policy")
+}
+
+func TestStore_NamesAndDefaultError(t *testing.T) {
+	s, _ := newTestStore(t, FaultPolicy{Faults: []Fault{{Names: func(name string) bool { return strings.HasSuffix(name, "-bad") }}}})
+
+	assert.NoError(t, write(s, "0000000001-good"))
+	assert.Equal(t, ErrInjected, write(s, "0000000002-bad"))
+
+	files, err := s.ListFiles(context.Background(), "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000000001-good"}, files)
+}
+
+func TestStore_Latency(t *testing.T) {
+	s, _ := newTestStore(t, FaultPolicy{Faults: []Fault{
+		{Operations: []Operation{OpenObject}, Latency: 20 * time.Millisecond},
+		{Operations: []Operation{FileExists}, Latency: time.Hour},
+	}})
+	require.NoError(t, write(s, "file"))
+
+	start := time.Now()
+	reader, err := s.OpenObject(context.Background(), "file")
+	require.NoError(t, err, "latency alone does not fail the operation")
+	reader.Close()
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = s.FileExists(ctx, "file")
+	assert.Equal(t, context.DeadlineExceeded, err, "latency interrupted by the context")
+}
+
+func TestStore_SubStoreSharesPolicy(t *testing.T) {
+	s, _ := newTestStore(t, FaultPolicy{Faults: []Fault{{Operations: []Operation{DeleteObject}, Times: 1}}})
+
+	sub, err := s.SubStore("sub")
+	require.NoError(t, err)
+	assert.Equal(t, ErrInjected, sub.DeleteObject(context.Background(), "file"))
+	assert.Equal(t, 1, s.Injected(DeleteObject))
+}
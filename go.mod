@@ -4,10 +4,13 @@ go 1.16
 
 require (
 	github.com/ShinyTrinkets/overseer v0.3.0
-	github.com/abourget/llerrgroup v0.0.0-20161118145731-75f536392d17
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/golang/protobuf v1.5.2
 	github.com/google/renameio v0.1.0
 	github.com/gorilla/mux v1.8.0
+	github.com/klauspost/compress v1.10.2
+	github.com/prometheus/client_golang v1.12.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/streamingfast/bstream v0.0.2-0.20220607202937-611660228ea2
 	github.com/streamingfast/derr v0.0.0-20220301163149-de09cb18fc70
 	github.com/streamingfast/dgrpc v0.0.0-20220301153539-536adf71b594
@@ -0,0 +1,308 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal appends the key events of the node manager components to JSON lines files, for
+// post-mortems, see `New` and `ReadEvents`.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+)
+
+// Types of the events recorded
+const (
+	EventPluginStarted        = "plugin_started"
+	EventPluginStopped        = "plugin_stopped"
+	EventGatePassed           = "gate_passed"
+	EventStopBlockReached     = "stop_block_reached"
+	EventMaintenanceRequested = "maintenance_requested"
+	EventMaintenanceEntered   = "maintenance_entered"
+	EventMaintenanceResumed   = "maintenance_resumed"
+	EventContinuityFailure    = "continuity_failure"
+	EventArchiverStoreError   = "archiver_store_error"
+	EventUploadBatch          = "upload_batch"
+	EventBlocksDropped        = "blocks_dropped"
+)
+
+const fileSuffix = ".events.jsonl"
+
+// Event is a line of the journal, `Source` is the name of the journal that recorded it
+type Event struct {
+	Type     string                 `json:"type"`
+	Time     time.Time              `json:"time"`
+	Source   string                 `json:"source"`
+	BlockNum uint64                 `json:"block_num,omitempty"`
+	BlockID  string                 `json:"block_id,omitempty"`
+	Reason   string                 `json:"reason,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+type Option func(j *Journal)
+
+// MaxFileSize sets the size over which the journal file is rotated, 10 MiB by default
+func MaxFileSize(bytes int64) Option {
+	return func(j *Journal) {
+		if bytes > 0 {
+			j.maxFileSize = bytes
+		}
+	}
+}
+
+// MaxFiles sets the number of rotated files kept, in addition to the current one, 5 by default
+func MaxFiles(count int) Option {
+	return func(j *Journal) {
+		if count > 0 {
+			j.maxFiles = count
+		}
+	}
+}
+
+// QueueSize sets the number of events waiting to be written, 1000 by default, beyond which they
+// are dropped
+func QueueSize(size int) Option {
+	return func(j *Journal) {
+		if size > 0 {
+			j.queue = make(chan Event, size)
+		}
+	}
+}
+
+// Journal appends events, as JSON lines, to the `<name>.events.jsonl` file of its directory,
+// rotated to `<name>.events.jsonl.1`, `.2` and so on once over its max size, the oldest files
+// beyond the max files being removed. It is strictly best effort: `Record` never blocks, events
+// are dropped when the queue is full and write failures are only logged. A nil journal records
+// nothing.
+type Journal struct {
+	*shutter.Shutter
+
+	name        string
+	path        string
+	maxFileSize int64
+	maxFiles    int
+	queue       chan Event
+	done        chan struct{}
+	logger      *zap.Logger
+
+	file *os.File // only accessed by the writer goroutine
+	size int64
+}
+
+// New opens the journal `name` in `directory`, creating it if needed. Pending events are
+// written and the file closed on `Close`.
+func New(directory, name string, logger *zap.Logger, options ...Option) (*Journal, error) {
+	j := &Journal{
+		Shutter:     shutter.New(),
+		name:        name,
+		path:        filepath.Join(directory, name+fileSuffix),
+		maxFileSize: 10 * 1024 * 1024,
+		maxFiles:    5,
+		queue:       make(chan Event, 1000),
+		done:        make(chan struct{}),
+		logger:      logger,
+	}
+
+	for _, opt := range options {
+		opt(j)
+	}
+
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating journal directory: %w", err)
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	go j.run()
+	return j, nil
+}
+
+// Record queues `event` to be written, setting its source and, when zero, its time
+func (j *Journal) Record(event Event) {
+	if j == nil || j.IsTerminating() {
+		return
+	}
+
+	event.Source = j.name
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	select {
+	case j.queue <- event:
+	default:
+		metrics.JournalDroppedEvents.Inc()
+	}
+}
+
+// Close writes the events already recorded and closes the journal file
+func (j *Journal) Close() {
+	if j == nil {
+		return
+	}
+
+	j.Shutdown(nil)
+	<-j.done
+}
+
+func (j *Journal) run() {
+	defer close(j.done)
+	defer j.file.Close()
+
+	for {
+		select {
+		case event := <-j.queue:
+			j.write(event)
+		case <-j.Terminating():
+			for {
+				select {
+				case event := <-j.queue:
+					j.write(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (j *Journal) open() error {
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening journal file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat journal file: %w", err)
+	}
+
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+func (j *Journal) write(event Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		j.logger.Warn("unable to marshal journal event, dropping it", zap.String("type", event.Type), zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+
+	if j.size > 0 && j.size+int64(len(line)) > j.maxFileSize {
+		if err := j.rotate(); err != nil {
+			j.logger.Warn("unable to rotate journal file, appending to it", zap.String("path", j.path), zap.Error(err))
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		j.logger.Warn("unable to write journal event", zap.String("path", j.path), zap.String("type", event.Type), zap.Error(err))
+	}
+}
+
+// rotate shifts the rotated files by one, removing the oldest, and starts a new file
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("closing journal file: %w", err)
+	}
+
+	if err := os.Remove(rotatedPath(j.path, j.maxFiles)); err != nil && !os.IsNotExist(err) {
+		j.logger.Warn("unable to remove oldest journal file", zap.Error(err))
+	}
+	for i := j.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedPath(j.path, i), rotatedPath(j.path, i+1)); err != nil && !os.IsNotExist(err) {
+			j.logger.Warn("unable to shift journal file", zap.Int("index", i), zap.Error(err))
+		}
+	}
+
+	renameErr := os.Rename(j.path, rotatedPath(j.path, 1))
+	if err := j.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("renaming journal file: %w", renameErr)
+	}
+	j.size = 0
+	return nil
+}
+
+func rotatedPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+// ReadEvents returns the events of every journal of `directory`, rotated files included, at or
+// after `since`, all of them when zero, sorted by time. Lines that are not valid events, like a
+// line partially written on a crash, are skipped.
+func ReadEvents(directory string, since time.Time) ([]Event, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading journal directory: %w", err)
+	}
+
+	var events []Event
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.Contains(entry.Name(), fileSuffix) {
+			continue
+		}
+
+		fileEvents, err := readFile(filepath.Join(directory, entry.Name()), since)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, fileEvents...)
+	}
+
+	sort.SliceStable(events, func(i, k int) bool { return events[i].Time.Before(events[k].Time) })
+	return events, nil
+}
+
+func readFile(path string, since time.Time) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal file: %w", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Type == "" {
+			continue
+		}
+		if event.Time.Before(since) {
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal file %q: %w", path, err)
+	}
+	return events, nil
+}
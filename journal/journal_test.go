@@ -0,0 +1,130 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var testStart = time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+
+func recordEvents(j *Journal, from, count int) {
+	for i := from; i < from+count; i++ {
+		j.Record(Event{Type: EventUploadBatch, Time: testStart.Add(time.Duration(i) * time.Second), BlockNum: uint64(i)})
+	}
+}
+
+func blockNums(events []Event) (out []uint64) {
+	for _, event := range events {
+		out = append(out, event.BlockNum)
+	}
+	return out
+}
+
+func TestJournal_Rotation(t *testing.T) {
+	dir := t.TempDir()
+
+	// Each event line is a bit less than 100 bytes, a file holds 3 of them
+	j, err := New(dir, "mindreader", zap.NewNop(), MaxFileSize(300), MaxFiles(2))
+	require.NoError(t, err)
+	recordEvents(j, 1, 20)
+	j.Close()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+
+		info, err := entry.Info()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(300))
+	}
+	assert.Equal(t, []string{"mindreader.events.jsonl", "mindreader.events.jsonl.1", "mindreader.events.jsonl.2"}, names)
+
+	events, err := ReadEvents(dir, time.Time{})
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Equal(t, uint64(20), events[len(events)-1].BlockNum, "latest events kept")
+	assert.Greater(t, events[0].BlockNum, uint64(1), "oldest events rotated out")
+	for i, event := range events {
+		assert.Equal(t, events[0].BlockNum+uint64(i), event.BlockNum, "events in order across rotated files")
+		assert.Equal(t, "mindreader", event.Source)
+	}
+
+	// Reopening appends to the current file
+	j, err = New(dir, "mindreader", zap.NewNop(), MaxFileSize(300), MaxFiles(2))
+	require.NoError(t, err)
+	recordEvents(j, 21, 1)
+	j.Close()
+
+	events, err = ReadEvents(dir, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(21), events[len(events)-1].BlockNum)
+}
+
+func TestReadEvents_Since(t *testing.T) {
+	dir := t.TempDir()
+
+	mindreader, err := New(dir, "mindreader", zap.NewNop())
+	require.NoError(t, err)
+	operator, err := New(dir, "operator", zap.NewNop())
+	require.NoError(t, err)
+
+	recordEvents(mindreader, 1, 5)
+	operator.Record(Event{Type: EventMaintenanceEntered, Time: testStart.Add(3500 * time.Millisecond), Reason: "continuity check failed"})
+	mindreader.Close()
+	operator.Close()
+
+	// A line partially written on a crash is skipped
+	f, err := os.OpenFile(filepath.Join(dir, "mindreader.events.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = fmt.Fprint(f, `{"type":"upload_ba`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	events, err := ReadEvents(dir, testStart.Add(3*time.Second))
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, []uint64{3, 0, 4, 5}, blockNums(events))
+	assert.Equal(t, Event{Type: EventMaintenanceEntered, Time: testStart.Add(3500 * time.Millisecond), Source: "operator", Reason: "continuity check failed"}, events[1])
+
+	events, err = ReadEvents(dir, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, events, 6)
+
+	_, err = ReadEvents(filepath.Join(dir, "missing"), time.Time{})
+	assert.Error(t, err)
+}
+
+func TestJournal_BestEffort(t *testing.T) {
+	var nilJournal *Journal
+	nilJournal.Record(Event{Type: EventPluginStarted})
+	nilJournal.Close()
+
+	j, err := New(t.TempDir(), "mindreader", zap.NewNop())
+	require.NoError(t, err)
+	j.Close()
+	j.Record(Event{Type: EventPluginStopped})
+	j.Close()
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/streamingfast/bstream/blockstream"
+	"github.com/streamingfast/shutter"
+)
+
+// childPlugins are the plugins a log plugin forwards lines to (see `Multiplexer` and
+// `LineRouter`), shut down along with it
+type childPlugins struct {
+	plugins []LogPlugin
+
+	// ownErrorsOnly does not record the errors of the plugins shut down by the parent, they
+	// only get its error
+	ownErrorsOnly bool
+
+	errLock sync.Mutex
+	errs    []error
+}
+
+// add adds `plugin`, once
+func (c *childPlugins) add(plugin LogPlugin) {
+	for _, child := range c.plugins {
+		if child == plugin {
+			return
+		}
+	}
+	c.plugins = append(c.plugins, plugin)
+}
+
+// bindShutdown shuts down every plugin when `parent` shuts down, and `parent` when a plugin
+// shuts down on its own
+func (c *childPlugins) bindShutdown(parent *shutter.Shutter) {
+	for _, child := range c.plugins {
+		child := child
+		if shut, ok := child.(Shutter); ok {
+			shut.OnTerminating(func(err error) {
+				if c.ownErrorsOnly && parent.IsTerminating() {
+					return
+				}
+				if err != nil {
+					c.addErr(fmt.Errorf("log plugin %q: %w", child.Name(), err))
+				}
+				if !parent.IsTerminating() {
+					go parent.Shutdown(err)
+				}
+			})
+		}
+	}
+
+	parent.OnTerminating(func(err error) {
+		for _, child := range c.plugins {
+			if !child.IsTerminating() {
+				child.Shutdown(err)
+			}
+		}
+	})
+}
+
+// err returns the errors the plugins shut down with aggregated in a single error, nil if none did
+func (c *childPlugins) err() error {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	if len(c.errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(c.errs))
+	for i, err := range c.errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Errorf("%d log plugin(s) failed: %s", len(c.errs), strings.Join(messages, "; "))
+}
+
+func (c *childPlugins) addErr(err error) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	c.errs = append(c.errs, err)
+}
+
+func (c *childPlugins) debugDeepMind(enabled bool) {
+	for _, child := range c.plugins {
+		if v, ok := child.(interface{ DebugDeepMind(enabled bool) }); ok {
+			v.DebugDeepMind(enabled)
+		}
+	}
+}
+
+func (c *childPlugins) run(blockServer *blockstream.Server) {
+	for _, child := range c.plugins {
+		if v, ok := child.(BlockStreamer); ok {
+			v.Run(blockServer)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/streamingfast/bstream/blockstream"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// LineRoute sends the lines starting with `Prefix` to `Plugin`
+type LineRoute struct {
+	Prefix string
+	Plugin LogPlugin
+}
+
+type LineRouterOption func(r *LineRouter)
+
+// LineRouterDefaultSink sends the lines matching no route to `plugin`
+func LineRouterDefaultSink(plugin LogPlugin) LineRouterOption {
+	return func(r *LineRouter) {
+		r.defaultSink = plugin
+	}
+}
+
+// LineRouterUnmatchedHandler calls `f` with the lines matching no route, when there is no
+// default sink, instead of dropping them silently. They are counted either way, see
+// `LineRouter.UnmatchedLines`.
+func LineRouterUnmatchedHandler(f func(line string)) LineRouterOption {
+	return func(r *LineRouter) {
+		r.unmatchedHandler = f
+	}
+}
+
+// WarnUnmatchedLines is a `LineRouterUnmatchedHandler` logging the lines matching no route, at
+// most their first 256 bytes
+func WarnUnmatchedLines(zlogger *zap.Logger) func(line string) {
+	return func(line string) {
+		if len(line) > 256 {
+			line = line[:256]
+		}
+		zlogger.Warn("dropping log line matching no route", zap.String("line", line))
+	}
+}
+
+// LineRouter is a LogPlugin sending each line to the plugin of the route with the longest prefix
+// it starts with, so a single output can feed plugins reading different kind of lines (e.g.
+// `DMLOG` blocks and `FPROOF` finality proofs). Lines are delivered before `LogLine` returns, in
+// the order they were received. Launching, stopping and shutting down the router does the same,
+// once, on every plugin, and a plugin shutting down on its own shuts down the router.
+type LineRouter struct {
+	*shutter.Shutter
+
+	routes           []LineRoute // longest prefix first
+	defaultSink      LogPlugin
+	unmatchedHandler func(line string)
+	unmatched        *atomic.Uint64
+
+	children *childPlugins // distinct plugins of the routes followed by the default sink
+}
+
+func NewLineRouter(routes []LineRoute, options ...LineRouterOption) (*LineRouter, error) {
+	r := &LineRouter{
+		Shutter:   shutter.New(),
+		unmatched: atomic.NewUint64(0),
+		children:  &childPlugins{ownErrorsOnly: true},
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	prefixes := map[string]bool{}
+	for _, route := range routes {
+		if route.Prefix == "" {
+			return nil, fmt.Errorf("invalid route to log plugin %q, prefix cannot be empty", route.Plugin.Name())
+		}
+		if prefixes[route.Prefix] {
+			return nil, fmt.Errorf("invalid routes, prefix %q is routed more than once", route.Prefix)
+		}
+		prefixes[route.Prefix] = true
+		r.children.add(route.Plugin)
+	}
+	if r.defaultSink != nil {
+		r.children.add(r.defaultSink)
+	}
+
+	r.routes = append([]LineRoute(nil), routes...)
+	sort.SliceStable(r.routes, func(i, j int) bool { return len(r.routes[i].Prefix) > len(r.routes[j].Prefix) })
+
+	r.children.bindShutdown(r.Shutter)
+
+	return r, nil
+}
+
+func (r *LineRouter) Name() string {
+	names := make([]string, len(r.routes))
+	for i, route := range r.routes {
+		names[i] = fmt.Sprintf("%s=%s", route.Prefix, route.Plugin.Name())
+	}
+	if r.defaultSink != nil {
+		names = append(names, "*="+r.defaultSink.Name())
+	}
+
+	return fmt.Sprintf("LineRouter(%s)", strings.Join(names, ", "))
+}
+
+func (r *LineRouter) Launch() {
+	for _, child := range r.children.plugins {
+		child.Launch()
+	}
+}
+
+func (r *LineRouter) LogLine(in string) {
+	for _, route := range r.routes {
+		if strings.HasPrefix(in, route.Prefix) {
+			route.Plugin.LogLine(in)
+			return
+		}
+	}
+
+	if r.defaultSink != nil {
+		r.defaultSink.LogLine(in)
+		return
+	}
+
+	r.unmatched.Inc()
+	if r.unmatchedHandler != nil {
+		r.unmatchedHandler(in)
+	}
+}
+
+// Stop stops all plugins, in order
+func (r *LineRouter) Stop() {
+	for _, child := range r.children.plugins {
+		child.Stop()
+	}
+}
+
+// UnmatchedLines returns the number of lines matching no route, always 0 with a default sink
+func (r *LineRouter) UnmatchedLines() uint64 {
+	return r.unmatched.Load()
+}
+
+// ChildrenErr returns the errors of the plugins that shut down on their own with an error,
+// aggregated in a single error, nil if none did.
+func (r *LineRouter) ChildrenErr() error {
+	return r.children.err()
+}
+
+func (r *LineRouter) DebugDeepMind(enabled bool) {
+	r.children.debugDeepMind(enabled)
+}
+
+func (r *LineRouter) Run(blockServer *blockstream.Server) {
+	r.children.run(blockServer)
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// shutdownCountingLogPlugin counts the calls to `Shutdown`
+type shutdownCountingLogPlugin struct {
+	*recordingLogPlugin
+	launched  int
+	stopped   int
+	shutdowns *atomic.Int32
+}
+
+func newShutdownCountingLogPlugin(name string) *shutdownCountingLogPlugin {
+	return &shutdownCountingLogPlugin{recordingLogPlugin: newRecordingLogPlugin(name), shutdowns: atomic.NewInt32(0)}
+}
+
+func (p *shutdownCountingLogPlugin) Launch() { p.launched++ }
+func (p *shutdownCountingLogPlugin) Stop()   { p.stopped++ }
+func (p *shutdownCountingLogPlugin) Shutdown(err error) {
+	p.shutdowns.Inc()
+	p.recordingLogPlugin.Shutdown(err)
+}
+
+func TestLineRouter_Routing(t *testing.T) {
+	blocks := newShutdownCountingLogPlugin("blocks")
+	proofs := newShutdownCountingLogPlugin("proofs")
+	others := newShutdownCountingLogPlugin("others")
+
+	r, err := NewLineRouter([]LineRoute{
+		{Prefix: "DMLOG ", Plugin: blocks},
+		{Prefix: "FPROOF ", Plugin: proofs},
+		{Prefix: "DMLOG PROOF ", Plugin: proofs},
+	}, LineRouterDefaultSink(others))
+	require.NoError(t, err)
+	r.Launch()
+
+	var expectedBlocks, expectedProofs []string
+	for i := 0; i < 50; i++ {
+		block := fmt.Sprintf("DMLOG BLOCK %d", i)
+		proof := fmt.Sprintf("FPROOF %d", i)
+		expectedBlocks = append(expectedBlocks, block)
+		expectedProofs = append(expectedProofs, proof)
+
+		r.LogLine(block)
+		r.LogLine(proof)
+	}
+	r.LogLine("DMLOG PROOF longest prefix")
+	r.LogLine("human log")
+	r.Stop()
+
+	assert.Equal(t, expectedBlocks, blocks.Lines())
+	assert.Equal(t, append(expectedProofs, "DMLOG PROOF longest prefix"), proofs.Lines())
+	assert.Equal(t, []string{"human log"}, others.Lines())
+	assert.EqualValues(t, 0, r.UnmatchedLines())
+
+	for _, child := range []*shutdownCountingLogPlugin{blocks, proofs, others} {
+		assert.Equal(t, 1, child.launched, child.Name())
+		assert.Equal(t, 1, child.stopped, child.Name())
+	}
+	assert.Equal(t, "LineRouter(DMLOG PROOF =proofs, FPROOF =proofs, DMLOG =blocks, *=others)", r.Name())
+}
+
+func TestLineRouter_UnmatchedLines(t *testing.T) {
+	blocks := newRecordingLogPlugin("blocks")
+
+	var unmatched []string
+	r, err := NewLineRouter([]LineRoute{{Prefix: "DMLOG ", Plugin: blocks}}, LineRouterUnmatchedHandler(func(line string) {
+		unmatched = append(unmatched, line)
+	}))
+	require.NoError(t, err)
+
+	r.LogLine("first")
+	r.LogLine("DMLOG BLOCK 1")
+	r.LogLine("second")
+
+	assert.Equal(t, []string{"DMLOG BLOCK 1"}, blocks.Lines())
+	assert.Equal(t, []string{"first", "second"}, unmatched)
+	assert.EqualValues(t, 2, r.UnmatchedLines())
+}
+
+func TestLineRouter_InvalidRoutes(t *testing.T) {
+	plugin := newRecordingLogPlugin("plugin")
+
+	_, err := NewLineRouter([]LineRoute{{Prefix: "", Plugin: plugin}})
+	assert.Error(t, err)
+
+	_, err = NewLineRouter([]LineRoute{{Prefix: "DMLOG ", Plugin: plugin}, {Prefix: "DMLOG ", Plugin: newRecordingLogPlugin("other")}})
+	assert.Error(t, err)
+}
+
+func TestLineRouter_ShutdownEveryChildOnce(t *testing.T) {
+	newRouter := func() (*LineRouter, []*shutdownCountingLogPlugin) {
+		blocks := newShutdownCountingLogPlugin("blocks")
+		proofs := newShutdownCountingLogPlugin("proofs")
+		others := newShutdownCountingLogPlugin("others")
+
+		r, err := NewLineRouter([]LineRoute{
+			{Prefix: "DMLOG ", Plugin: blocks},
+			{Prefix: "FPROOF ", Plugin: proofs},
+			{Prefix: "PROOF ", Plugin: proofs},
+		}, LineRouterDefaultSink(others))
+		require.NoError(t, err)
+		return r, []*shutdownCountingLogPlugin{blocks, proofs, others}
+	}
+
+	t.Run("router shut down", func(t *testing.T) {
+		r, children := newRouter()
+
+		boom := errors.New("boom")
+		r.Shutdown(boom)
+		for _, child := range children {
+			assert.EqualValues(t, 1, child.shutdowns.Load(), child.Name())
+			assert.Equal(t, boom, child.Err(), child.Name())
+		}
+		assert.NoError(t, r.ChildrenErr(), "no plugin failed on its own")
+	})
+
+	t.Run("child shut down", func(t *testing.T) {
+		r, children := newRouter()
+
+		children[1].Shutdown(errors.New("proofs failed"))
+		select {
+		case <-r.Terminated():
+		case <-time.After(time.Second):
+			t.Fatal("router not shut down")
+		}
+
+		for _, child := range children {
+			assert.EqualValues(t, 1, child.shutdowns.Load(), child.Name())
+		}
+		assert.EqualError(t, r.ChildrenErr(), `1 log plugin(s) failed: log plugin "proofs": proofs failed`)
+	})
+}
@@ -26,6 +26,13 @@ type LogPlugin interface {
 	Stop()
 }
 
+// StderrLogPlugin is implemented by plugins wanting to receive the lines the process wrote to
+// stderr separately, plugins not implementing it receive them through `LogLine` like stdout lines.
+type StderrLogPlugin interface {
+	LogPlugin
+	LogLineStderr(in string)
+}
+
 type Shutter interface {
 	Terminated() <-chan struct{}
 	OnTerminating(f func(error))
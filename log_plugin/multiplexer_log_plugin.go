@@ -0,0 +1,213 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/streamingfast/bstream/blockstream"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
+)
+
+type MultiplexerOption interface {
+	apply(m *Multiplexer)
+}
+
+type multiplexerOptionFunc func(m *Multiplexer)
+
+func (s multiplexerOptionFunc) apply(m *Multiplexer) {
+	s(m)
+}
+
+// MultiplexerBestEffort is the option that makes the multiplexer deliver lines to each child
+// through its own queue of `queueSize` lines. When the queue of a child is full, lines are
+// dropped for that child only and counted (see `Multiplexer.DroppedLines`).
+//
+// Without this option, the multiplexer is in strict mode: each line is delivered to every
+// child before `LogLine` returns, so a blocking child blocks everyone.
+func MultiplexerBestEffort(queueSize int) MultiplexerOption {
+	return multiplexerOptionFunc(func(m *Multiplexer) {
+		m.bestEffort = true
+		m.queueSize = queueSize
+	})
+}
+
+// Multiplexer is a LogPlugin forwarding every line to each of its children, in the order
+// lines were received. Launching, stopping and shutting down the multiplexer does the same
+// on all children, and a child shutting down on its own shuts down the multiplexer.
+type Multiplexer struct {
+	*shutter.Shutter
+
+	children []*multiplexedPlugin
+	plugins  *childPlugins // the plugins of `children`
+
+	bestEffort bool
+	queueSize  int
+}
+
+type multiplexedPlugin struct {
+	LogPlugin
+
+	queueLock sync.Mutex // guards the queue against lines sent once it is stopped
+	queue     chan string
+	stopped   bool
+	done      chan struct{}
+	dropped   *atomic.Uint64
+}
+
+func NewMultiplexer(children []LogPlugin, options ...MultiplexerOption) *Multiplexer {
+	m := &Multiplexer{
+		Shutter: shutter.New(),
+		plugins: &childPlugins{},
+	}
+
+	for _, opt := range options {
+		opt.apply(m)
+	}
+
+	for _, child := range children {
+		m.children = append(m.children, &multiplexedPlugin{LogPlugin: child, dropped: atomic.NewUint64(0)})
+		m.plugins.plugins = append(m.plugins.plugins, child)
+	}
+	m.plugins.bindShutdown(m.Shutter)
+
+	return m
+}
+
+func (m *Multiplexer) Name() string {
+	names := make([]string, len(m.children))
+	for i, child := range m.children {
+		names[i] = child.Name()
+	}
+
+	return fmt.Sprintf("Multiplexer(%s)", strings.Join(names, ", "))
+}
+
+func (m *Multiplexer) Launch() {
+	for _, child := range m.children {
+		child.Launch()
+
+		if m.bestEffort {
+			child.startQueue(m.queueSize)
+		}
+	}
+}
+
+func (m *Multiplexer) LogLine(in string) {
+	for _, child := range m.children {
+		if !m.bestEffort {
+			child.LogLine(in)
+			continue
+		}
+		child.queueLine(in)
+	}
+}
+
+// Stop waits for the queued lines to be delivered then stops all children, in order
+func (m *Multiplexer) Stop() {
+	for _, child := range m.children {
+		child.stopQueue()
+		child.Stop()
+	}
+}
+
+// DroppedLines returns, for each child in the order they were given, the number of lines
+// that were dropped because the child was not keeping up, always 0 in strict mode.
+func (m *Multiplexer) DroppedLines() []uint64 {
+	out := make([]uint64, len(m.children))
+	for i, child := range m.children {
+		out[i] = child.dropped.Load()
+	}
+	return out
+}
+
+// ChildrenErr returns the errors of all children that shut down with an error,
+// aggregated in a single error, nil if none did.
+func (m *Multiplexer) ChildrenErr() error {
+	return m.plugins.err()
+}
+
+func (m *Multiplexer) DebugDeepMind(enabled bool) {
+	m.plugins.debugDeepMind(enabled)
+}
+
+func (m *Multiplexer) Run(blockServer *blockstream.Server) {
+	m.plugins.run(blockServer)
+}
+
+// startQueue starts delivering the queued lines. The superviser launching the plugins on every
+// node (re)start, a queue still running is kept, along with its lines and their order.
+func (p *multiplexedPlugin) startQueue(size int) {
+	p.queueLock.Lock()
+	defer p.queueLock.Unlock()
+
+	if p.queue != nil && !p.stopped {
+		return
+	}
+
+	p.queue = make(chan string, size)
+	p.done = make(chan struct{})
+	p.stopped = false
+	go p.consume(p.queue, p.done)
+}
+
+// queueLine queues `line` without waiting, dropping it when the queue is full. Lines received
+// before the multiplexer is launched are delivered right away, the ones received once it is
+// stopped are dropped without being counted.
+func (p *multiplexedPlugin) queueLine(line string) {
+	p.queueLock.Lock()
+	if p.queue == nil {
+		p.queueLock.Unlock()
+		p.LogLine(line)
+		return
+	}
+	defer p.queueLock.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	select {
+	case p.queue <- line:
+	default:
+		p.dropped.Inc()
+	}
+}
+
+func (p *multiplexedPlugin) consume(queue chan string, done chan struct{}) {
+	defer close(done)
+
+	for line := range queue {
+		p.LogLine(line)
+	}
+}
+
+// stopQueue stops accepting lines and waits until the queued ones are delivered
+func (p *multiplexedPlugin) stopQueue() {
+	p.queueLock.Lock()
+	if p.queue == nil || p.stopped {
+		p.queueLock.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.queue)
+	done := p.done
+	p.queueLock.Unlock()
+
+	<-done
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogPlugin struct {
+	*shutter.Shutter
+	name    string
+	blockCh chan struct{}
+	entered chan string
+
+	lock  sync.Mutex
+	lines []string
+}
+
+func newRecordingLogPlugin(name string) *recordingLogPlugin {
+	return &recordingLogPlugin{Shutter: shutter.New(), name: name}
+}
+
+func (p *recordingLogPlugin) Name() string { return p.name }
+func (p *recordingLogPlugin) Launch()      {}
+func (p *recordingLogPlugin) Stop()        {}
+func (p *recordingLogPlugin) LogLine(in string) {
+	if p.blockCh != nil {
+		p.entered <- in
+		<-p.blockCh
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lines = append(p.lines, in)
+}
+
+func (p *recordingLogPlugin) Lines() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.lines...)
+}
+
+func TestMultiplexer_StrictOrdering(t *testing.T) {
+	first := newRecordingLogPlugin("first")
+	second := newRecordingLogPlugin("second")
+
+	m := NewMultiplexer([]LogPlugin{first, second})
+	m.Launch()
+
+	var expected []string
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("line %d", i)
+		expected = append(expected, line)
+		m.LogLine(line)
+	}
+	m.Stop()
+
+	assert.Equal(t, expected, first.Lines())
+	assert.Equal(t, expected, second.Lines())
+	assert.Equal(t, []uint64{0, 0}, m.DroppedLines())
+	assert.Equal(t, "Multiplexer(first, second)", m.Name())
+}
+
+func TestMultiplexer_BestEffortDropsForBlockedChild(t *testing.T) {
+	fast := newRecordingLogPlugin("fast")
+	slow := newRecordingLogPlugin("slow")
+	slow.blockCh = make(chan struct{})
+	slow.entered = make(chan string, 10)
+
+	m := NewMultiplexer([]LogPlugin{fast, slow}, MultiplexerBestEffort(2))
+	m.Launch()
+
+	var expected []string
+	for i := 0; i < 10; i++ {
+		line := fmt.Sprintf("line %d", i)
+		expected = append(expected, line)
+		m.LogLine(line)
+		if i == 0 {
+			// Wait for the slow child to hold the first line so its queue state is deterministic
+			assert.Equal(t, "line 0", <-slow.entered)
+		}
+		require.Eventually(t, func() bool { return len(fast.Lines()) == i+1 }, time.Second, time.Millisecond)
+	}
+
+	close(slow.blockCh)
+	m.Stop()
+
+	assert.Equal(t, expected, fast.Lines())
+	assert.Equal(t, []string{"line 0", "line 1", "line 2"}, slow.Lines())
+	assert.Equal(t, []uint64{0, 7}, m.DroppedLines())
+}
+
+func TestMultiplexer_BestEffortStopWhileLogging(t *testing.T) {
+	child := newRecordingLogPlugin("child")
+
+	m := NewMultiplexer([]LogPlugin{child}, MultiplexerBestEffort(10))
+	m.Launch()
+
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		for i := 0; i < 1000; i++ {
+			m.LogLine(fmt.Sprintf("line %d", i))
+		}
+	}()
+
+	m.Stop() // lines logged concurrently are dropped, never sent to the closed queue
+	<-logged
+
+	lines := child.Lines()
+	m.LogLine("after stop")
+	assert.Equal(t, lines, child.Lines())
+}
+
+func TestMultiplexer_BestEffortRelaunchKeepsOrder(t *testing.T) {
+	child := newRecordingLogPlugin("child")
+	child.blockCh = make(chan struct{})
+	child.entered = make(chan string, 100)
+
+	m := NewMultiplexer([]LogPlugin{child}, MultiplexerBestEffort(100))
+	m.Launch()
+
+	var expected []string
+	for i := 0; i < 20; i++ {
+		line := fmt.Sprintf("line %d", i)
+		expected = append(expected, line)
+		m.LogLine(line)
+		if i == 9 {
+			// Node restarted by the superviser while lines are still queued
+			m.Launch()
+		}
+	}
+
+	close(child.blockCh)
+	m.Stop()
+	assert.Equal(t, expected, child.Lines())
+
+	// Launched again once stopped, lines are delivered by a new queue
+	m.Launch()
+	m.LogLine("after relaunch")
+	m.Stop()
+	assert.Equal(t, append(expected, "after relaunch"), child.Lines())
+}
+
+func TestMultiplexer_ShutdownPropagation(t *testing.T) {
+	first := newRecordingLogPlugin("first")
+	second := newRecordingLogPlugin("second")
+
+	m := NewMultiplexer([]LogPlugin{first, second})
+	m.Shutdown(fmt.Errorf("boom"))
+
+	assert.True(t, first.IsTerminating())
+	assert.True(t, second.IsTerminating())
+	assert.EqualError(t, m.ChildrenErr(), `2 log plugin(s) failed: log plugin "first": boom; log plugin "second": boom`)
+}
+
+func TestMultiplexer_ChildShutdownShutsDownAll(t *testing.T) {
+	first := newRecordingLogPlugin("first")
+	second := newRecordingLogPlugin("second")
+
+	m := NewMultiplexer([]LogPlugin{first, second, LogPluginFunc(func(_ string) {})})
+	first.Shutdown(fmt.Errorf("child failed"))
+
+	select {
+	case <-m.Terminated():
+	case <-time.After(time.Second):
+		t.Fatal("multiplexer should have been shut down")
+	}
+
+	assert.True(t, second.IsTerminating())
+	require.Error(t, m.Err())
+	assert.Contains(t, m.ChildrenErr().Error(), `log plugin "first": child failed`)
+}
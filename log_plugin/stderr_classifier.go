@@ -0,0 +1,166 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+)
+
+// maintenanceSourceStderrClassifier is `nodeManager.MaintenanceSourceStderrClassifier`, the
+// root package cannot be imported from here
+const maintenanceSourceStderrClassifier = "stderr_classifier"
+
+// UnclassifiedSeverity is the severity of stderr lines matching none of the patterns
+const UnclassifiedSeverity = "unclassified"
+
+type SeverityAction int
+
+const (
+	// SeverityActionNone only counts the line in the `stderr_lines` metric
+	SeverityActionNone SeverityAction = iota
+	// SeverityActionMaintenance requests a maintenance of the node, falling back to a shutdown
+	// when no maintenance requester is configured
+	SeverityActionMaintenance
+	// SeverityActionShutdown shuts the classifier down, which shuts down the superviser
+	SeverityActionShutdown
+)
+
+func (a SeverityAction) String() string {
+	switch a {
+	case SeverityActionNone:
+		return "none"
+	case SeverityActionMaintenance:
+		return "maintenance"
+	case SeverityActionShutdown:
+		return "shutdown"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+}
+
+// SeverityPattern assigns `Severity` to the stderr lines matching the `Pattern` regular expression
+type SeverityPattern struct {
+	Severity string
+	Pattern  string
+	Action   SeverityAction
+}
+
+type StderrClassifierOption interface {
+	apply(c *StderrClassifier)
+}
+
+type stderrClassifierOptionFunc func(c *StderrClassifier)
+
+func (s stderrClassifierOptionFunc) apply(c *StderrClassifier) {
+	s(c)
+}
+
+// StderrClassifierMaintenanceRequester is the option that defines the function called for lines
+// matching a pattern with `SeverityActionMaintenance`, usually `Operator.RequestMaintenance`.
+func StderrClassifierMaintenanceRequester(f func(reason, source string) error) StderrClassifierOption {
+	return stderrClassifierOptionFunc(func(c *StderrClassifier) {
+		c.maintenanceRequester = f
+	})
+}
+
+type classifiedPattern struct {
+	SeverityPattern
+	regexp *regexp.Regexp
+}
+
+// StderrClassifier is a LogPlugin classifying the lines the process writes to stderr (stdout
+// lines are ignored) using the first matching pattern, counting them per severity in the
+// `stderr_lines` metric and acting on the ones matching fatal patterns (e.g. "database dirty
+// flag set").
+type StderrClassifier struct {
+	*shutter.Shutter
+
+	patterns             []classifiedPattern
+	maintenanceRequester func(reason, source string) error
+
+	logger *zap.Logger
+}
+
+func NewStderrClassifier(patterns []SeverityPattern, logger *zap.Logger, options ...StderrClassifierOption) (*StderrClassifier, error) {
+	c := &StderrClassifier{
+		Shutter: shutter.New(),
+		logger:  logger,
+	}
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q for severity %q: %w", pattern.Pattern, pattern.Severity, err)
+		}
+		c.patterns = append(c.patterns, classifiedPattern{SeverityPattern: pattern, regexp: re})
+	}
+
+	for _, opt := range options {
+		opt.apply(c)
+	}
+
+	return c, nil
+}
+
+// Classify returns the first pattern matching the line, if any
+func (c *StderrClassifier) Classify(line string) (SeverityPattern, bool) {
+	for _, pattern := range c.patterns {
+		if pattern.regexp.MatchString(line) {
+			return pattern.SeverityPattern, true
+		}
+	}
+	return SeverityPattern{}, false
+}
+
+func (c *StderrClassifier) Name() string {
+	return "StderrClassifier"
+}
+
+func (c *StderrClassifier) Launch()        {}
+func (c *StderrClassifier) Stop()          {}
+func (c *StderrClassifier) LogLine(string) {}
+
+func (c *StderrClassifier) LogLineStderr(in string) {
+	pattern, found := c.Classify(in)
+	if !found {
+		metrics.StderrLines.Inc(UnclassifiedSeverity)
+		return
+	}
+
+	metrics.StderrLines.Inc(pattern.Severity)
+	if pattern.Action == SeverityActionNone || c.IsTerminating() {
+		return
+	}
+
+	reason := fmt.Sprintf("stderr line matched %s pattern %q: %s", pattern.Severity, pattern.Pattern, in)
+	c.logger.Error("fatal line written to stderr", zap.String("severity", pattern.Severity), zap.Stringer("action", pattern.Action), zap.String("line", in))
+
+	if pattern.Action == SeverityActionMaintenance && c.maintenanceRequester != nil {
+		go func() {
+			if err := c.maintenanceRequester(reason, maintenanceSourceStderrClassifier); err != nil {
+				c.logger.Error("unable to request maintenance, shutting down", zap.Error(err))
+				c.Shutdown(fmt.Errorf("maintenance request failed: %w", err))
+			}
+		}()
+		return
+	}
+
+	go c.Shutdown(fmt.Errorf("%s", reason))
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var testSeverityPatterns = []SeverityPattern{
+	{Severity: "fatal", Pattern: `database dirty flag set`, Action: SeverityActionShutdown},
+	{Severity: "corruption", Pattern: `(?i)corrupt(ed|ion)`, Action: SeverityActionMaintenance},
+	{Severity: "panic", Pattern: `^panic:`},
+	{Severity: "warning", Pattern: `warn`},
+}
+
+func TestStderrClassifier_Classify(t *testing.T) {
+	classifier, err := NewStderrClassifier(testSeverityPatterns, zap.NewNop())
+	require.NoError(t, err)
+
+	cases := []struct {
+		name             string
+		line             string
+		expectedSeverity string
+		expectedAction   SeverityAction
+		expectedFound    bool
+	}{
+		{"fatal", "error: database dirty flag set (likely due to unclean shutdown)", "fatal", SeverityActionShutdown, true},
+		{"case insensitive", "state DB is Corrupted", "corruption", SeverityActionMaintenance, true},
+		{"anchored", "panic: runtime error", "panic", SeverityActionNone, true},
+		{"anchored mismatch", "recovered from panic: runtime error", "", SeverityActionNone, false},
+		{"first match wins", "warn: database dirty flag set", "fatal", SeverityActionShutdown, true},
+		{"unclassified", "info: all good", "", SeverityActionNone, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pattern, found := classifier.Classify(tc.line)
+			assert.Equal(t, tc.expectedFound, found)
+			assert.Equal(t, tc.expectedSeverity, pattern.Severity)
+			assert.Equal(t, tc.expectedAction, pattern.Action)
+		})
+	}
+}
+
+func TestStderrClassifier_InvalidPattern(t *testing.T) {
+	_, err := NewStderrClassifier([]SeverityPattern{{Severity: "fatal", Pattern: `dirty(`}}, zap.NewNop())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid pattern "dirty(" for severity "fatal"`)
+}
+
+func TestStderrClassifier_Actions(t *testing.T) {
+	var lock sync.Mutex
+	var reasons, sources []string
+	classifier, err := NewStderrClassifier(testSeverityPatterns, zap.NewNop(), StderrClassifierMaintenanceRequester(func(reason, source string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		reasons = append(reasons, reason)
+		sources = append(sources, source)
+		return nil
+	}))
+	require.NoError(t, err)
+
+	classifier.LogLine("database dirty flag set, on stdout")
+	classifier.LogLineStderr("panic: runtime error")
+	classifier.LogLineStderr("state DB is corrupted")
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(reasons) == 1
+	}, time.Second, time.Millisecond)
+	assert.Contains(t, reasons[0], "state DB is corrupted")
+	assert.Equal(t, []string{"stderr_classifier"}, sources)
+	assert.False(t, classifier.IsTerminating())
+
+	classifier.LogLineStderr("database dirty flag set")
+	select {
+	case <-classifier.Terminated():
+		assert.Contains(t, classifier.Err().Error(), "database dirty flag set")
+	case <-time.After(time.Second):
+		t.Error("classifier should have shut down")
+	}
+}
+
+func TestStderrClassifier_MaintenanceWithoutRequesterShutsDown(t *testing.T) {
+	classifier, err := NewStderrClassifier(testSeverityPatterns, zap.NewNop())
+	require.NoError(t, err)
+
+	classifier.LogLineStderr("corruption detected")
+	select {
+	case <-classifier.Terminated():
+	case <-time.After(time.Second):
+		t.Error("classifier should have shut down")
+	}
+}
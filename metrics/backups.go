@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BackupScheduleManual is the schedule label of the backups not triggered by a schedule
+const BackupScheduleManual = "manual"
+
+// DefaultBackupDurationBuckets go from 1 second to about 9 hours, doubling at each bucket
+var DefaultBackupDurationBuckets = prometheus.ExponentialBuckets(1, 2, 16)
+
+var BackupRuns = Metricset.NewCounterVec("backup_runs_total", []string{"module", "schedule", "outcome"}, "This counter increments every time a backup module completes a backup, labeled by module, schedule (manual when not scheduled) and outcome (success or failure)")
+var BackupHookFailures = Metricset.NewCounterVec("backup_hook_failures_total", []string{"hook", "phase"}, "This counter increments every time a backup hook fails, labeled by hook and phase (pre or post)")
+var LastSuccessfulBackupTimestamp = Metricset.NewGaugeVec("last_successful_backup_timestamp_seconds", []string{"module", "schedule"}, "Unix timestamp, in seconds, of the last successful backup of each module and schedule (manual when not scheduled)")
+
+// BackupDuration needs buckets, which the dmetrics histograms do not support, it is registered
+// by `RegisterHistograms`
+var BackupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "backup_duration_seconds",
+	Help:    "Duration, in seconds, of each backup run by a backup module, labeled by module and schedule (manual when not scheduled), whatever its outcome",
+	Buckets: DefaultBackupDurationBuckets,
+}, []string{"module", "schedule"})
+
+// ObserveBackup records a backup of `module` for `schedule` that took `duration` and completed at
+// `completedAt`, failed when `err` is not nil
+func ObserveBackup(module, schedule string, duration time.Duration, completedAt time.Time, err error) {
+	BackupDuration.WithLabelValues(module, schedule).Observe(duration.Seconds())
+	if err != nil {
+		BackupRuns.Inc(module, schedule, "failure")
+		return
+	}
+
+	BackupRuns.Inc(module, schedule, "success")
+	LastSuccessfulBackupTimestamp.SetFloat64(float64(completedAt.UnixNano())/float64(time.Second), module, schedule)
+	SuccessfulBackups.Inc()
+}
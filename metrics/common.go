@@ -20,8 +20,51 @@ import (
 
 var Metricset = dmetrics.NewSet()
 
-//FIXME this may be covered by another metric's registration in dmetrics. Minor Race condition alert
+// FIXME this may be covered by another metric's registration in dmetrics. Minor Race condition alert
+//
+// Deprecated: use `BackupRuns` with the success outcome, labeled by module and schedule, still
+// incremented for backward compatibility
 var SuccessfulBackups = Metricset.NewCounter("successful_backups", "This counter increments every time that a backup is completed successfully")
+var SupervisedProcessRunning = Metricset.NewGaugeVec("supervised_process_running", []string{"process"}, "Whether each process supervised by the operator (main node and sidecars) is running (1) or not (0)")
+var MaintenanceRequests = Metricset.NewCounterVec("maintenance_requests", []string{"source"}, "This counter increments every time the operator enters maintenance, labeled by the requesting source")
+var PushRateLimit = Metricset.NewGaugeVec("push_rate_limit", []string{"unit"}, "Current limit, per second, of blocks and bytes pushed to the block stream server while catching up (0 is unlimited)")
+var PushedRate = Metricset.NewGaugeVec("pushed_rate", []string{"unit"}, "Actual rate, per second, of blocks and bytes pushed to the block stream server (bytes are only measured when limited)")
+var PushRateLimitedBlocks = Metricset.NewCounter("push_rate_limited_blocks", "This counter increments every time the push of a block to the block stream server is delayed because of the push rate limit")
+var PushRateDroppedBlocks = Metricset.NewCounter("push_rate_dropped_blocks", "This counter increments every time a block is not pushed to the block stream server because the queue of pushes delayed by the push rate limit is full (the block is still archived)")
+var LiveStreamHealthy = Metricset.NewGauge("live_stream_healthy", "Whether blocks are published to the block stream server (1) or publishing was dropped after repeated failures and blocks are only archived (0)")
+var BlocksChannelHighWaterMark = Metricset.NewGauge("blocks_channel_high_water_mark", "Highest number of blocks seen waiting in the mindreader blocks channel, reaching its capacity means the node is slowed down by archiving")
+var BlocksChannelSendWait = Metricset.NewCounter("blocks_channel_send_wait_seconds", "Cumulative time, in seconds, the mindreader spent blocked sending blocks to its full blocks channel, or waiting for the blocks in it to be under the max buffered bytes")
+var BufferedBlockBytes = Metricset.NewGauge("buffered_block_bytes", "Payload bytes of the blocks read by the mindreader and not yet stored by the archiver, only tracked with a max buffered bytes")
+var StderrLines = Metricset.NewCounterVec("stderr_lines", []string{"severity"}, "This counter increments for every line the supervised process writes to stderr, labeled by the severity assigned by the stderr classifier")
+var ConsoleReadDuration = Metricset.NewHistogram("mindreader_console_read_seconds", "Time spent by the console reader to read and decode each block, including the time waiting for the node to produce it")
+var TransformDuration = Metricset.NewHistogram("mindreader_transform_seconds", "Time spent processing each block read from the console reader (block filter) before it is sent to the archiver")
+var UploadQueueDepth = Metricset.NewGauge("upload_queue_depth", "Number of files listed for upload by the mindreader file uploaders and not yet uploaded (or given up on)")
+var AutoRestoreSteps = Metricset.NewCounterVec("auto_restore_steps", []string{"step"}, "This counter increments at each step of the automatic restore on dirty start (dirty_start_detected, restore_requested, restored, restarted) and when it is not possible (max_attempts_reached, no_restore_module, restore_failed)")
+var OversizedBlocks = Metricset.NewCounter("oversized_blocks", "This counter increments every time the mindreader reads a block whose payload exceeds the maximum payload size")
+var DroppedLogLines = Metricset.NewCounter("dropped_log_lines", "This counter increments for every log line dropped by the log line prefilter before reaching the mindreader console reader")
+var DroppedEvents = Metricset.NewCounterVec("dropped_mindreader_events", []string{"event"}, "This counter increments for every mindreader event not delivered to its subscribers because they are too slow to consume the events queue")
+var NodeExits = Metricset.NewCounterVec("node_exits", []string{"class"}, "This counter increments every time the supervised process exits, labeled by exit class (requested, clean, killed, signaled, failure)")
+var NodeRestarts = Metricset.NewCounterVec("node_restarts", []string{"class"}, "This counter increments every time the operator relaunches the node after it stopped on its own, labeled by the exit class of the stop")
+var ReplayedBlocks = Metricset.NewCounter("replayed_blocks", "This counter increments every time the mindreader drops a block with the same number and ID as a recently read block, replayed by the node, before archiving it or pushing it to the block stream server")
+var DeduplicatedBlocks = Metricset.NewCounter("deduplicated_blocks", "This counter increments every time the mindreader skips a block with the same number and ID as an already archived block, usually replayed by the node after a restart")
+var PartialBundleFlushes = Metricset.NewCounter("partial_bundle_flushes", "This counter increments every time the archiver sends the blocks of an incomplete bundle as one block files, because the bundle is older than the max bundle age, the stop block was reached or the mindreader shut down with flush on shutdown")
+var ContinuityCheckFailures = Metricset.NewCounter("continuity_check_failures", "This counter increments every time the mindreader continuity checker detects a hole in the blocks read from the node")
+var InMaintenanceMode = Metricset.NewGauge("in_maintenance_mode", "Whether the operator is in maintenance (1) or not (0)")
+var StoreUploads = Metricset.NewCounterVec("store_uploads", []string{"store", "result"}, "This counter increments every time the mindreader uploads a file to a store, labeled by the store URL and the result (success or failure), secondary archive stores included")
+var UploadNotificationFailures = Metricset.NewCounter("upload_notification_failures", "This counter increments every time the mindreader fails to notify the upload notifier of an uploaded one block file, the notification being dropped")
+var QuarantinedUploadFiles = Metricset.NewCounterVec("quarantined_upload_files", []string{"file_type"}, "This counter increments every time a file failing to upload too many times is moved to the quarantine directory of the mindreader working directory, labeled by file type (oneblock or merged)")
+var JournalDroppedEvents = Metricset.NewCounter("journal_dropped_events", "This counter increments for every event not written to the event journal because its queue is full")
+var LineBufferLines = Metricset.NewGauge("line_buffer_lines", "Number of lines received from the node and waiting in the mindreader line buffer to be read by the console reader")
+var LineBufferBytes = Metricset.NewGauge("line_buffer_bytes", "Number of bytes of the lines received from the node and waiting in the mindreader line buffer to be read by the console reader")
+var LineWriteTimeouts = Metricset.NewCounter("line_write_timeouts", "This counter increments every time the mindreader declares itself stuck because a line from the node was not accepted in its line buffer within the write timeout")
+var FutureBlockCount = Metricset.NewCounter("future_block_count", "This counter increments every time the mindreader reads a block whose time is further into the future than the allowed skew, compared to the local clock")
+var FreeBytes = Metricset.NewGaugeVec("free_bytes", []string{"role"}, "Space available on the filesystem of each directory monitored by the operator, labeled by the directory role (data or working)")
+var FreePercent = Metricset.NewGaugeVec("free_percent", []string{"role"}, "Percentage of space available on the filesystem of each directory monitored by the operator, labeled by the directory role (data or working)")
+var ResumePointMismatches = Metricset.NewCounterVec("resume_point_mismatches", []string{"status"}, "Number of times the first block of the node did not follow the highest archived block, labeled by status (overlap or gap)")
+var ResumePointDiscardedBlocks = Metricset.NewCounter("resume_point_discarded_blocks", "Number of blocks discarded because the resume point check refused archiving")
+var NodeShutdownEscalations = Metricset.NewCounterVec("node_shutdown_escalations", []string{"level"}, "This counter increments every time the operator stops the node process with the shutdown escalation policy, labeled by the level needed for it to exit (sigterm, second_signal or sigkill)")
+var ShutdownDroppedBlocks = Metricset.NewCounter("shutdown_dropped_blocks", "Number of blocks dropped because the mindreader read flow did not drain within the shutdown drain timeout")
+var PayloadChecksums = Metricset.NewCounterVec("payload_checksums", []string{"result"}, "This counter increments every time the archiver verifies, before storing a block, the checksum of its payload computed when it was read, labeled by result (verified or mismatch)")
 
 func NewHeadBlockTimeDrift(serviceName string) *dmetrics.HeadTimeDrift {
 	return Metricset.NewHeadTimeDrift(serviceName)
@@ -30,3 +73,17 @@ func NewHeadBlockTimeDrift(serviceName string) *dmetrics.HeadTimeDrift {
 func NewHeadBlockNumber(serviceName string) *dmetrics.HeadBlockNum {
 	return Metricset.NewHeadBlockNumber(serviceName)
 }
+
+var headBlockLibNumber = Metricset.NewGaugeVec("head_block_lib_number", []string{"app"}, "Last irreversible block number of the head block")
+
+type HeadBlockLibNum struct {
+	service string
+}
+
+func NewHeadBlockLibNum(serviceName string) *HeadBlockLibNum {
+	return &HeadBlockLibNum{service: serviceName}
+}
+
+func (h *HeadBlockLibNum) SetUint64(libNum uint64) {
+	headBlockLibNumber.SetUint64(libNum, h.service)
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	FileTypeOneBlock = "oneblock"
+	FileTypeMerged   = "merged"
+)
+
+const (
+	oneBlockFileBytesHelp = "Size, in bytes, of each one block file produced by the mindreader, observed when written to the working directory before its upload"
+	mergedBundleBytesHelp = "Size, in bytes, of each merged blocks bundle produced by the mindreader, observed when written to the working directory before its upload"
+)
+
+// DefaultFileSizeBuckets go from 1KiB to 1GiB, multiplying by 4 at each bucket
+var DefaultFileSizeBuckets = prometheus.ExponentialBuckets(1024, 4, 11)
+
+// The file size histograms need buckets, which the dmetrics histograms do not support, they are
+// registered by `RegisterHistograms`
+var (
+	OneBlockFileBytes = newFileSizeHistogram("oneblock_file_bytes", oneBlockFileBytesHelp, DefaultFileSizeBuckets)
+	MergedBundleBytes = newFileSizeHistogram("merged_bundle_bytes", mergedBundleBytesHelp, DefaultFileSizeBuckets)
+)
+
+var FilesProduced = Metricset.NewCounterVec("files_produced", []string{"type"}, "This counter increments for every block file produced by the mindreader, labeled by type (oneblock or merged), whether its upload succeeds or not")
+
+func newFileSizeHistogram(name, help string, buckets []float64) prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets})
+}
+
+// SetFileSizeBuckets replaces the buckets of the file size histograms, it must be called before
+// `RegisterHistograms` and before the mindreader produces any file
+func SetFileSizeBuckets(buckets []float64) {
+	OneBlockFileBytes = newFileSizeHistogram("oneblock_file_bytes", oneBlockFileBytesHelp, buckets)
+	MergedBundleBytes = newFileSizeHistogram("merged_bundle_bytes", mergedBundleBytesHelp, buckets)
+}
+
+// ObserveFileProduced records a block file of `fileType` (`FileTypeOneBlock` or
+// `FileTypeMerged`) of `size` bytes
+func ObserveFileProduced(fileType string, size int64) {
+	FilesProduced.Inc(fileType)
+	switch fileType {
+	case FileTypeOneBlock:
+		OneBlockFileBytes.Observe(float64(size))
+	case FileTypeMerged:
+		MergedBundleBytes.Observe(float64(size))
+	}
+}
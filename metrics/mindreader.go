@@ -0,0 +1,74 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+)
+
+var (
+	BlocksChannelDepth = Metricset.NewGaugeVec("mindreader_blocks_channel_depth", []string{"app"}, "Number of blocks waiting in the mindreader blocks channel to be archived, labeled by app")
+	StoreBlockDuration = Metricset.NewHistogramVec("mindreader_store_block_seconds", []string{"app"}, "Time spent by the mindreader archiver to store each block, merging or writing it as a one block file, labeled by app")
+	FileUploadDuration = Metricset.NewHistogramVec("mindreader_file_upload_seconds", []string{"app", "type"}, "Time spent uploading each block file to its archive store, labeled by app and type (oneblock or merged), retries of a failed upload excluded")
+	GateDroppedBlocks  = Metricset.NewCounterVec("mindreader_gate_dropped_blocks", []string{"app"}, "This counter increments for every block read before the mindreader start gate opened, not archived, labeled by app")
+	TransformerErrors  = Metricset.NewCounterVec("mindreader_transformer_errors", []string{"app"}, "This counter increments every time the mindreader block transformer chain fails on a block, labeled by app")
+)
+
+// MindreaderMetrics records the metrics of the read flow of a mindreader, labeled by service so
+// multiple mindreaders in one binary are told apart. A nil `*MindreaderMetrics` records nothing.
+type MindreaderMetrics struct {
+	service string
+}
+
+func NewMindreaderMetrics(serviceName string) *MindreaderMetrics {
+	return &MindreaderMetrics{service: serviceName}
+}
+
+func (m *MindreaderMetrics) SetBlocksChannelDepth(depth int) {
+	if m == nil {
+		return
+	}
+	BlocksChannelDepth.SetInt(depth, m.service)
+}
+
+func (m *MindreaderMetrics) ObserveStoreBlock(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	StoreBlockDuration.ObserveDuration(duration, m.service)
+}
+
+// ObserveFileUpload records the upload of a block file of `fileType` (`FileTypeOneBlock` or
+// `FileTypeMerged`)
+func (m *MindreaderMetrics) ObserveFileUpload(fileType string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	FileUploadDuration.ObserveDuration(duration, m.service, fileType)
+}
+
+func (m *MindreaderMetrics) IncGateDroppedBlocks() {
+	if m == nil {
+		return
+	}
+	GateDroppedBlocks.Inc(m.service)
+}
+
+func (m *MindreaderMetrics) IncTransformerErrors() {
+	if m == nil {
+		return
+	}
+	TransformerErrors.Inc(m.service)
+}
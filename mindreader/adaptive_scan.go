@@ -0,0 +1,59 @@
+package mindreader
+
+import "time"
+
+// FileUploaderAdaptiveScan doubles the scan interval, up to `maxInterval`, on every scan finding
+// nothing to upload once `idleScans` scans in a row found nothing. It goes back to the scan
+// interval as soon as a file is uploaded. The uploads of the files notified as stored and the
+// final upload on shutdown are not delayed.
+func FileUploaderAdaptiveScan(maxInterval time.Duration, idleScans int) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.scanBackoff = scanBackoff{max: maxInterval, idleScans: idleScans}
+	}
+}
+
+// WithAdaptiveUploadScan makes both uploaders scan the working directory less often while it
+// stays empty, see `FileUploaderAdaptiveScan`
+func WithAdaptiveUploadScan(maxInterval time.Duration, idleScans int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadScanBackoffMax = maxInterval
+		p.uploadScanIdleScans = idleScans
+	}
+}
+
+type scanBackoff struct {
+	base      time.Duration // the scan interval
+	max       time.Duration // no backoff when not above `base`
+	idleScans int
+
+	idle    int // scans in a row that found nothing
+	current time.Duration
+}
+
+// next returns the delay until the next scan, after a scan that uploaded files or not
+func (b *scanBackoff) next(uploaded bool) time.Duration {
+	if uploaded || b.max <= b.base {
+		b.reset()
+		return b.base
+	}
+
+	b.idle++
+	if b.current < b.base {
+		b.current = b.base
+	}
+	if b.idle >= b.idleScans {
+		b.current *= 2
+		if b.current > b.max {
+			b.current = b.max
+		}
+	}
+	return b.current
+}
+
+// reset goes back to the base interval, reporting if the scans were backing off
+func (b *scanBackoff) reset() bool {
+	backingOff := b.current > b.base
+	b.idle = 0
+	b.current = b.base
+	return backingOff
+}
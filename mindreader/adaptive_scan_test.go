@@ -0,0 +1,137 @@
+package mindreader
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanBackoff(t *testing.T) {
+	b := scanBackoff{base: 10 * time.Millisecond, max: 80 * time.Millisecond, idleScans: 2}
+
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		delays = append(delays, b.next(false))
+	}
+	assert.Equal(t, []time.Duration{10, 20, 40, 80, 80, 80}, millis(delays))
+
+	assert.Equal(t, 10*time.Millisecond, b.next(true), "reset on upload")
+	assert.Equal(t, 10*time.Millisecond, b.next(false))
+	assert.Equal(t, 20*time.Millisecond, b.next(false))
+	assert.True(t, b.reset())
+	assert.False(t, b.reset(), "already at the base interval")
+
+	fixed := scanBackoff{base: 10 * time.Millisecond}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, 10*time.Millisecond, fixed.next(false))
+	}
+}
+
+func millis(delays []time.Duration) (out []time.Duration) {
+	for _, delay := range delays {
+		out = append(out, delay/time.Millisecond)
+	}
+	return
+}
+
+// walkRecordingStore records when the uploader scans it
+type walkRecordingStore struct {
+	dstore.Store
+
+	lock  sync.Mutex
+	walks []time.Time
+}
+
+func (s *walkRecordingStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	s.lock.Lock()
+	s.walks = append(s.walks, time.Now())
+	s.lock.Unlock()
+
+	return s.Store.Walk(ctx, prefix, f)
+}
+
+// gaps returns the delays between the scans, from the `from`th one
+func (s *walkRecordingStore) gaps(from int) (out []time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := from + 1; i < len(s.walks); i++ {
+		out = append(out, s.walks[i].Sub(s.walks[i-1]))
+	}
+	return
+}
+
+func (s *walkRecordingStore) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.walks)
+}
+
+func newScanTestUploader(t *testing.T, options ...FileUploaderOption) (*FileUploader, *walkRecordingStore, dstore.Store) {
+	t.Helper()
+
+	local, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	destination, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+
+	recording := &walkRecordingStore{Store: local}
+	uploader := NewFileUploader(recording, destination, testLogger, append([]FileUploaderOption{FileUploaderPollInterval(time.Hour)}, options...)...)
+	go uploader.Start(context.Background())
+	t.Cleanup(func() { uploader.Shutdown(nil) })
+	return uploader, recording, destination
+}
+
+func TestFileUploader_FixedScanInterval(t *testing.T) {
+	_, local, _ := newScanTestUploader(t, FileUploaderScanInterval(20*time.Millisecond))
+
+	time.Sleep(210 * time.Millisecond)
+	for _, gap := range local.gaps(0) {
+		assert.InDelta(t, float64(20*time.Millisecond), float64(gap), float64(15*time.Millisecond))
+	}
+	assert.GreaterOrEqual(t, local.count(), 6)
+}
+
+func TestFileUploader_AdaptiveScan(t *testing.T) {
+	ctx := context.Background()
+	uploader, local, destination := newScanTestUploader(t, FileUploaderScanInterval(10*time.Millisecond), FileUploaderAdaptiveScan(80*time.Millisecond, 2))
+
+	require.Eventually(t, func() bool {
+		gaps := local.gaps(0)
+		return len(gaps) > 0 && gaps[len(gaps)-1] >= 80*time.Millisecond
+	}, 2*time.Second, 5*time.Millisecond, "backing off up to the max interval")
+	gaps := local.gaps(0)
+	assert.Less(t, int64(gaps[0]), int64(40*time.Millisecond), "first scans at the base interval")
+	for _, gap := range gaps {
+		assert.Less(t, int64(gap), int64(120*time.Millisecond), "never above the max interval")
+	}
+
+	// An upload goes back to the base interval
+	require.NoError(t, uploader.localStore.WriteObject(ctx, "0000000001", strings.NewReader("block")))
+	uploader.fileStored("0000000001")
+	require.Eventually(t, func() bool {
+		found, err := destination.FileExists(ctx, "0000000001")
+		return err == nil && found
+	}, 100*time.Millisecond, time.Millisecond)
+
+	scansBefore := local.count()
+	require.Eventually(t, func() bool { return local.count() >= scansBefore+2 }, time.Second, time.Millisecond)
+	assert.Less(t, int64(local.gaps(scansBefore)[0]), int64(40*time.Millisecond), "scanning at the base interval again")
+
+	// The final upload does not wait for the next scan
+	require.Eventually(t, func() bool {
+		gaps := local.gaps(0)
+		return gaps[len(gaps)-1] >= 80*time.Millisecond
+	}, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, uploader.localStore.WriteObject(ctx, "0000000002", strings.NewReader("block")))
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond) // below the 80ms scan interval
+	defer cancel()
+	require.NoError(t, uploader.WaitForAllFilesToUpload(waitCtx))
+}
@@ -18,30 +18,112 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/logging"
 	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/metrics"
 	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
+type ArchiverMode int32
+
+const (
+	// ModeAuto merges blocks while they are older than the merge threshold block age
+	ModeAuto ArchiverMode = iota
+	// ModeMergeOnly always merges blocks, starting a bundle on the next boundary
+	ModeMergeOnly
+	// ModeOneBlockOnly never merges blocks, partial bundles are flushed as one block files
+	ModeOneBlockOnly
+)
+
+func (m ArchiverMode) String() string {
+	switch m {
+	case ModeAuto:
+		return "auto"
+	case ModeMergeOnly:
+		return "merge-only"
+	case ModeOneBlockOnly:
+		return "one-block-only"
+	default:
+		return "unknown"
+	}
+}
+
+type ArchiverOption func(a *Archiver)
+
+// WithArchiverMode sets the initial mode of the archiver, `ModeAuto` by default
+func WithArchiverMode(mode ArchiverMode) ArchiverOption {
+	return func(a *Archiver) {
+		a.mode.Store(int32(mode))
+	}
+}
+
+// WithArchiverClock replaces `time.Now` when computing the age of blocks against the merge
+// threshold block age, see `mindreadertest.Clock` for a deterministic clock
+func WithArchiverClock(now func() time.Time) ArchiverOption {
+	return func(a *Archiver) {
+		a.now = now
+	}
+}
+
+// WithExcludeForkedBlocks leaves the blocks that are not part of the canonical chain out of the
+// merged bundles, they are still written as mergeable one block files. By default forked blocks are
+// kept in the bundle, after the canonical block of the same height.
+func WithExcludeForkedBlocks(exclude bool) ArchiverOption {
+	return func(a *Archiver) {
+		a.excludeForkedBlocks = exclude
+	}
+}
+
+// WithMaxBundleAge flushes the current bundle once its first block was buffered for longer than
+// `age`: the buffered blocks are sent as one block files, and blocks are archived as one block files
+// until the next boundary, where merging starts again. The age is checked when a block is stored,
+// 0 (the default) waits for the bundle to complete, however long it takes.
+func WithMaxBundleAge(age time.Duration) ArchiverOption {
+	return func(a *Archiver) {
+		a.maxBundleAge = age
+	}
+}
+
+// WithFlushOnShutdown sends the blocks of the partial bundle as one block files when the
+// mindreader shuts down gracefully, instead of leaving them in the working directory to be
+// resumed by the next run. Use it when the working directory does not survive a restart.
+func WithFlushOnShutdown() ArchiverOption {
+	return func(a *Archiver) {
+		a.flushOnShutdown = true
+	}
+}
+
 type Archiver struct {
 	*shutter.Shutter
 
 	bundler *bundle.Bundler
 	io      ArchiverIO
 
+	mode                *atomic.Int32
 	currentlyMerging    bool
+	lastBlockMerged     bool
 	firstBlockSeen      bool
 	firstBoundaryTarget uint64
 
-	mergeThresholdBlockAge time.Duration
+	mergeThresholdBlockAge        time.Duration
+	pendingMergeThresholdBlockAge *time.Duration // applied on the next bundle boundary, see `SetMergeThresholdBlockAge`
+	pendingMergeThresholdLock     sync.Mutex
 
-	bundleSize     uint64
-	oneblockSuffix string
+	bundleSize          uint64
+	oneblockSuffix      string
+	excludeForkedBlocks bool
+	maxBundleAge        time.Duration
+	flushOnShutdown     bool
+	bundleOpenedAt      time.Time // when the first block of the current bundle was buffered, zero without bundle
+	payloadChecksums    *payloadChecksums
 
+	now    func() time.Time
 	logger *zap.Logger
 	tracer logging.Tracer
 }
@@ -53,21 +135,83 @@ func NewArchiver(
 	mergeThresholdBlockAge time.Duration,
 	logger *zap.Logger,
 	tracer logging.Tracer,
+	options ...ArchiverOption,
 ) *Archiver {
 	a := &Archiver{
 		Shutter:                shutter.New(),
+		mode:                   atomic.NewInt32(int32(ModeAuto)),
 		bundleSize:             bundleSize,
 		io:                     io,
 		oneblockSuffix:         oneblockSuffix,
-		mergeThresholdBlockAge: mergeThresholdBlockAge,
+		mergeThresholdBlockAge: normalizeMergeThresholdBlockAge(mergeThresholdBlockAge),
 		currentlyMerging:       true,
+		now:                    time.Now,
 		logger:                 logger,
 		tracer:                 tracer,
 	}
 
+	for _, opt := range options {
+		opt(a)
+	}
+
 	return a
 }
 
+// SetMode overrides the way the archiver decides to merge blocks or not, it is safe to call
+// while blocks are being stored and takes effect on the next block.
+func (a *Archiver) SetMode(mode ArchiverMode) {
+	previous := ArchiverMode(a.mode.Swap(int32(mode)))
+	if previous != mode {
+		a.logger.Info("archiver mode changed", zap.Stringer("previous", previous), zap.Stringer("mode", mode))
+	}
+}
+
+func (a *Archiver) Mode() ArchiverMode {
+	return ArchiverMode(a.mode.Load())
+}
+
+// SetMergeThresholdBlockAge changes, at runtime, the age over which blocks are merged. It is safe to
+// call while blocks are being stored and takes effect on the next bundle boundary, so a bundle is
+// never split. A zero `threshold` never merges, a negative one always merges.
+func (a *Archiver) SetMergeThresholdBlockAge(threshold time.Duration) {
+	threshold = normalizeMergeThresholdBlockAge(threshold)
+
+	a.pendingMergeThresholdLock.Lock()
+	defer a.pendingMergeThresholdLock.Unlock()
+	a.pendingMergeThresholdBlockAge = &threshold
+
+	a.logger.Info("merge threshold block age will change on next bundle boundary", zap.Duration("threshold", threshold))
+}
+
+// normalizeMergeThresholdBlockAge maps negative thresholds to 1 (always merge), 0 never merges
+func normalizeMergeThresholdBlockAge(threshold time.Duration) time.Duration {
+	if threshold < 0 {
+		return 1
+	}
+	return threshold
+}
+
+// applyPendingMergeThresholdBlockAge switches to the threshold set by `SetMergeThresholdBlockAge`
+// when `block` is the first one or on a bundle boundary
+func (a *Archiver) applyPendingMergeThresholdBlockAge(block *bstream.Block) {
+	if a.firstBlockSeen && !isBoundary(block.Number, a.bundleSize) {
+		return
+	}
+
+	a.pendingMergeThresholdLock.Lock()
+	pending := a.pendingMergeThresholdBlockAge
+	a.pendingMergeThresholdBlockAge = nil
+	a.pendingMergeThresholdLock.Unlock()
+
+	if pending == nil {
+		return
+	}
+
+	a.logger.Info("merge threshold block age changed", zap.Stringer("block", block), zap.Duration("previous", a.mergeThresholdBlockAge), zap.Duration("threshold", *pending))
+	a.mergeThresholdBlockAge = *pending
+	a.currentlyMerging = true // merging is decided again with the new threshold
+}
+
 func (a *Archiver) Start(ctx context.Context) {
 	a.OnTerminating(func(err error) {
 		a.logger.Info("archiver selector is terminating", zap.Error(err))
@@ -79,6 +223,13 @@ func (a *Archiver) Start(ctx context.Context) {
 }
 
 func (a *Archiver) shouldMerge(block *bstream.Block) bool {
+	switch a.Mode() {
+	case ModeMergeOnly:
+		return true
+	case ModeOneBlockOnly:
+		return false
+	}
+
 	// Be default currently merging is set to true
 	if !a.currentlyMerging {
 		if a.tracer.Enabled() {
@@ -105,7 +256,7 @@ func (a *Archiver) shouldMerge(block *bstream.Block) bool {
 		return true
 	}
 
-	blockAge := time.Since(block.Time())
+	blockAge := a.now().Sub(block.Time())
 	if blockAge > a.mergeThresholdBlockAge {
 		if a.tracer.Enabled() {
 			a.logger.Debug("merging on block because merge threshold block age is > block age", zap.Stringer("block", block), zap.Duration("block_age", blockAge), zap.Duration("threshold", a.mergeThresholdBlockAge))
@@ -184,7 +335,15 @@ func initializeBundlerFromFirstBlock(ctx context.Context, block *bstream.Block,
 
 	bundleLowBoundary, err := validatePartialBlocks(ctx, logger, partialBlocks, block, bundleSize)
 	if err != nil {
-		return nil, fmt.Errorf("validating partial blocks on disk: %w", err)
+		// The partial bundle left by a previous run cannot be resumed, its blocks are still
+		// valid blocks that would be lost if not sent as one block files
+		logger.Warn("partial blocks on disk do not connect to first seen block, sending them as one block files", zap.Int("len_partial_blocks", len(partialBlocks)), zap.Error(err))
+		if err := io.SendMergeableAsOneBlockFiles(ctx); err != nil {
+			return nil, fmt.Errorf("sending orphaned partial blocks as one block files: %w", err)
+		}
+
+		partialBlocks = nil
+		bundleLowBoundary = lowBoundary(block.Number, bundleSize)
 	}
 
 	bundler := bundle.NewBundler(logger, bundleLowBoundary, bstream.GetProtocolFirstStreamableBlock, bundleSize)
@@ -246,11 +405,25 @@ func initializeBundlerFromFirstBlock(ctx context.Context, block *bstream.Block,
 }
 
 func (a *Archiver) storeBlock(ctx context.Context, block *bstream.Block) error {
+	a.applyPendingMergeThresholdBlockAge(block)
 	if !a.firstBlockSeen {
 		defer func() { a.firstBlockSeen = true }()
 	}
 
 	merging := a.shouldMerge(block)
+	if merging && !a.lastBlockMerged && a.firstBlockSeen {
+		// Switching back to merging mid-stream (mode override), we cannot start a bundle
+		// in the middle of a range so we wait for the next boundary
+		a.bundler = nil
+		a.bundleOpenedAt = time.Time{}
+		a.firstBoundaryTarget = highBoundary(block.Number, a.bundleSize)
+		if block.Number%a.bundleSize == 0 {
+			a.firstBoundaryTarget = block.Number
+		}
+		a.logger.Info("merging again, waiting for next boundary", zap.Stringer("block", block), zap.Uint64("first_boundary_target", a.firstBoundaryTarget))
+	}
+	a.lastBlockMerged = merging
+
 	if !merging {
 		if !a.firstBlockSeen || a.bundler != nil {
 			err := a.io.SendMergeableAsOneBlockFiles(ctx)
@@ -259,6 +432,7 @@ func (a *Archiver) storeBlock(ctx context.Context, block *bstream.Block) error {
 			}
 		}
 		a.bundler = nil
+		a.bundleOpenedAt = time.Time{}
 
 		return a.io.StoreOneBlockFile(ctx, bundle.BlockFileNameWithSuffix(block, a.oneblockSuffix), block)
 	}
@@ -311,6 +485,9 @@ func (a *Archiver) storeBlock(ctx context.Context, block *bstream.Block) error {
 		return fmt.Errorf("storing one block to be merged: %w", err)
 	}
 	a.bundler.AddOneBlockFile(oneBlockFile)
+	if a.bundleOpenedAt.IsZero() {
+		a.bundleOpenedAt = a.now()
+	}
 
 	bundleCompleted, highestBlockLimit, err := a.bundler.BundleCompleted()
 	if err != nil {
@@ -318,7 +495,7 @@ func (a *Archiver) storeBlock(ctx context.Context, block *bstream.Block) error {
 	}
 	if bundleCompleted {
 		a.logger.Info("bundle completed, will merge and store it", zap.String("details", a.bundler.String()))
-		oneBlockFiles := a.bundler.ToBundle(highestBlockLimit)
+		oneBlockFiles := a.canonicalBundle(a.bundler.ToBundle(highestBlockLimit), a.bundler.LongestOneBlockFileChain())
 
 		err := a.io.MergeAndStore(a.bundler.BundleInclusiveLowerBlock(), oneBlockFiles)
 		if err != nil {
@@ -329,12 +506,70 @@ func (a *Archiver) storeBlock(ctx context.Context, block *bstream.Block) error {
 		a.bundler.Purge(func(toDelete []*bundle.OneBlockFile) {
 			a.io.Delete(toDelete)
 		})
+
+		// the block completing a bundle is the first of the next one
+		a.bundleOpenedAt = a.now()
+		return nil
 	}
 
+	return a.flushStaleBundle(ctx, block)
+}
+
+// flushStaleBundle sends the blocks of the current bundle as one block files when it is older
+// than the max bundle age, blocks are then stored as one block files until the next boundary
+func (a *Archiver) flushStaleBundle(ctx context.Context, block *bstream.Block) error {
+	if a.maxBundleAge == 0 {
+		return nil
+	}
+
+	bundleAge := a.now().Sub(a.bundleOpenedAt)
+	if bundleAge <= a.maxBundleAge {
+		return nil
+	}
+
+	a.firstBoundaryTarget = highBoundary(block.Number, a.bundleSize)
+	a.logger.Info("bundle older than max bundle age, sending its blocks as one block files until next boundary",
+		zap.Stringer("block", block),
+		zap.Duration("bundle_age", bundleAge),
+		zap.Duration("max_bundle_age", a.maxBundleAge),
+		zap.Uint64("next_boundary", a.firstBoundaryTarget),
+	)
+
+	if err := a.io.SendMergeableAsOneBlockFiles(ctx); err != nil {
+		return fmt.Errorf("sending partial bundle as one block files: %w", err)
+	}
+	metrics.PartialBundleFlushes.Inc()
+
+	a.bundler = nil
+	a.bundleOpenedAt = time.Time{}
+	return nil
+}
+
+// flushPartialBundle sends the blocks of the current bundle as one block files. It is called once
+// the stop block is reached, no block will complete the bundle and its mergeable blocks would
+// otherwise stay in the working directory, and on graceful shutdown with `WithFlushOnShutdown`.
+func (a *Archiver) flushPartialBundle(ctx context.Context) error {
+	if a.bundler == nil {
+		return nil
+	}
+
+	a.logger.Info("no more block will complete the current bundle, sending its blocks as one block files", zap.String("details", a.bundler.String()))
+	if err := a.io.SendMergeableAsOneBlockFiles(ctx); err != nil {
+		return fmt.Errorf("sending partial bundle as one block files: %w", err)
+	}
+	metrics.PartialBundleFlushes.Inc()
+
+	a.bundler = nil
+	a.bundleOpenedAt = time.Time{}
 	return nil
 }
 
 func (a *Archiver) StoreBlock(ctx context.Context, block *bstream.Block) error {
+	if a.payloadChecksums != nil {
+		if err := a.payloadChecksums.verify(block); err != nil {
+			return err
+		}
+	}
 	return a.storeBlock(ctx, block)
 }
 
@@ -18,12 +18,13 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -31,13 +32,6 @@ import (
 var superLongTimeAgo = time.Since(time.Date(2000, 1, 1, 1, 1, 1, 1, time.UTC))
 var alwaysMergeThreshold = time.Duration(1)
 
-func newArchiver(t *testing.T, mergeAgeThreshold time.Duration) (io *TestArchiverIO, archiver *Archiver) {
-	t.Helper()
-
-	io = &TestArchiverIO{}
-	return io, newArchiverWithIO(t, io, mergeAgeThreshold)
-}
-
 func newArchiverWithIO(t *testing.T, io ArchiverIO, mergeAgeThreshold time.Duration) (archiver *Archiver) {
 	t.Helper()
 
@@ -46,62 +40,27 @@ func newArchiverWithIO(t *testing.T, io ArchiverIO, mergeAgeThreshold time.Durat
 }
 
 func TestArchiver_StoreBlockNewBlocks(t *testing.T) {
-	io, archiver := newArchiver(t, superLongTimeAgo)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, superLongTimeAgo)
 
-	srcOneBlockFiles := []*bundle.OneBlockFile{
-		bundle.MustNewOneBlockFile("0000000001-20210728T105016.01-00000001a-00000000a-0-suffix"),
-		bundle.MustNewOneBlockFile("0000000002-20210728T105016.02-00000002a-00000001a-0-suffix"),
-		bundle.MustNewOneBlockFile("0000000003-20210728T105016.03-00000003a-00000002a-0-suffix"),
-		bundle.MustNewOneBlockFile("0000000004-20210728T105016.06-00000004a-00000003a-2-suffix"),
-		bundle.MustNewOneBlockFile("0000000006-20210728T105016.08-00000006a-00000004a-2-suffix"),
-	}
-
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
-
-	storedUploadableOneBlockfiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockfiles++
-		return nil
-	}
-
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
-
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
+	blocks := mindreadertest.BlocksFromOneBlockFileNames(
+		"0000000001-20210728T105016.01-00000001a-00000000a-0-suffix",
+		"0000000002-20210728T105016.02-00000002a-00000001a-0-suffix",
+		"0000000003-20210728T105016.03-00000003a-00000002a-0-suffix",
+		"0000000004-20210728T105016.06-00000004a-00000003a-2-suffix",
+		"0000000006-20210728T105016.08-00000006a-00000004a-2-suffix",
+	)
 
-	var sentOneblockfilesFromMergeable bool
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		sentOneblockfilesFromMergeable = true
-		return nil
-	}
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, blocks))
 
-	ctx := context.Background()
-	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
-		require.NoError(t, err)
-	}
-
-	assert.True(t, sentOneblockfilesFromMergeable) // called at the beginning, but will not match anything
-	assert.Equal(t, 0, storedMergedFiles)
-	assert.Equal(t, 0, deletedFiles)
-	assert.Equal(t, 0, storedMergableOneBlockFiles)
-	assert.Equal(t, 5, storedUploadableOneBlockfiles)
+	mindreadertest.AssertGolden(t, "testdata/archiver_store_block_new_blocks.golden.json", io.Result())
 }
 
 func TestArchiver_StoreBlock_FirstIsTriggeringValideMerge(t *testing.T) {
-	io, archiver := newArchiver(t, time.Hour)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, time.Hour)
 
-	srcOneBlockFiles := []*bundle.OneBlockFile{
+	io.PartialMergeableFiles = []*bundle.OneBlockFile{
 		bundle.MustNewOneBlockFile("0000000000-20210728T105016.00-00000000a-000000000-0-suffix"),
 		bundle.MustNewOneBlockFile("0000000001-20210728T105016.01-00000001a-00000000a-0-suffix"),
 		bundle.MustNewOneBlockFile("0000000002-20210728T105016.02-00000002a-00000001a-1-suffix"),
@@ -109,18 +68,15 @@ func TestArchiver_StoreBlock_FirstIsTriggeringValideMerge(t *testing.T) {
 		bundle.MustNewOneBlockFile("0000000004-20210728T105016.04-00000004a-00000003a-3-suffix"),
 	}
 
-	io.WalkMergeableOneBlockFilesFunc = func(ctx context.Context) ([]*bundle.OneBlockFile, error) {
-		return srcOneBlockFiles, nil
-	}
-
 	block := bundle.MustNewOneBlockFile("0000000005-20210728T105016.05-00000005a-00000004a-4-suffix")
-	require.NoError(t, archiver.storeBlock(context.Background(), oneBlockFileToBlock(block)))
+	require.NoError(t, archiver.storeBlock(context.Background(), mindreadertest.BlockFromOneBlockFile(block)))
 }
 
 func TestArchiver_StoreBlock_FirstIsTriggeringValideMerge_OnChainWithBlockNumSkip(t *testing.T) {
-	io, archiver := newArchiver(t, time.Hour)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, time.Hour)
 
-	srcOneBlockFiles := []*bundle.OneBlockFile{
+	io.PartialMergeableFiles = []*bundle.OneBlockFile{
 		bundle.MustNewOneBlockFile("0000000000-20210728T105016.00-00000000a-000000000-0-suffix"),
 		bundle.MustNewOneBlockFile("0000000001-20210728T105016.01-00000001a-00000000a-0-suffix"),
 		bundle.MustNewOneBlockFile("0000000002-20210728T105016.02-00000002a-00000001a-1-suffix"),
@@ -128,18 +84,15 @@ func TestArchiver_StoreBlock_FirstIsTriggeringValideMerge_OnChainWithBlockNumSki
 		bundle.MustNewOneBlockFile("0000000004-20210728T105016.04-00000004a-00000003a-3-suffix"),
 	}
 
-	io.WalkMergeableOneBlockFilesFunc = func(ctx context.Context) ([]*bundle.OneBlockFile, error) {
-		return srcOneBlockFiles, nil
-	}
-
 	block := bundle.MustNewOneBlockFile("0000000006-20210728T105016.06-00000006a-00000004a-4-suffix")
-	require.NoError(t, archiver.storeBlock(context.Background(), oneBlockFileToBlock(block)))
+	require.NoError(t, archiver.storeBlock(context.Background(), mindreadertest.BlockFromOneBlockFile(block)))
 }
 
-func TestArchiver_StoreBlock_FirstFailsBecauseMissingBlock(t *testing.T) {
-	io, archiver := newArchiver(t, time.Hour)
+func TestArchiver_StoreBlock_OrphanedPartialSentAsOneBlocks(t *testing.T) {
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, time.Hour)
 
-	srcOneBlockFiles := []*bundle.OneBlockFile{
+	io.PartialMergeableFiles = []*bundle.OneBlockFile{
 		bundle.MustNewOneBlockFile("0000000000-20210728T105016.00-00000000a-000000000-0-suffix"),
 		bundle.MustNewOneBlockFile("0000000001-20210728T105016.01-00000001a-00000000a-0-suffix"),
 		bundle.MustNewOneBlockFile("0000000002-20210728T105016.02-00000002a-00000001a-1-suffix"),
@@ -147,66 +100,105 @@ func TestArchiver_StoreBlock_FirstFailsBecauseMissingBlock(t *testing.T) {
 		bundle.MustNewOneBlockFile("0000000004-20210728T105016.04-00000004a-00000003a-3-suffix"),
 	}
 
-	io.WalkMergeableOneBlockFilesFunc = func(ctx context.Context) ([]*bundle.OneBlockFile, error) {
-		return srcOneBlockFiles, nil
-	}
-
 	block := bundle.MustNewOneBlockFile("0000000006-20210728T105016.06-00000006a-00000005a-4-suffix")
-	require.EqualError(t, archiver.storeBlock(context.Background(), oneBlockFileToBlock(block)), "initializing bundler: validating partial blocks on disk: highest mergeable block on disk #4 (ID 00000004a, ParentID 00000003a) is not the parent of first seen block #6 (00000006a), expecting 00000005a")
+	require.NoError(t, archiver.storeBlock(context.Background(), mindreadertest.BlockFromOneBlockFile(block)))
+
+	result := io.Result()
+	assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles, "orphaned partial blocks are sent as one block files")
+	assert.Len(t, result.OneBlockFiles, 1, "block is stored as one block file until next boundary")
+	assert.Equal(t, uint64(10), archiver.firstBoundaryTarget)
 }
 
-func TestArchiver_InitLIBOnBoundary(t *testing.T) {
-	io, archiver := newArchiver(t, alwaysMergeThreshold)
+func TestArchiver_ResumePartialBundleAfterCrash(t *testing.T) {
+	generator := mindreadertest.NewBlockGenerator("crash", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
 
-	srcOneBlockFiles := []*bundle.OneBlockFile{
-		bundle.MustNewOneBlockFile("0000000005-20210728T105016.01-0000005a-0000000a-0-suffix"),
-		bundle.MustNewOneBlockFile("0000000006-20210728T105016.02-0000006a-0000005a-0-suffix"),
-		bundle.MustNewOneBlockFile("0000000007-20210728T105016.03-0000007a-0000006a-0-suffix"),
-		bundle.MustNewOneBlockFile("0000000008-20210728T105016.06-0000008a-0000007a-2-suffix"),
-		bundle.MustNewOneBlockFile("0000000009-20210728T105016.08-0000009a-0000008a-2-suffix"),
-		bundle.MustNewOneBlockFile("0000000010-20210728T105016.08-0000010a-0000009a-2-suffix"),
-	}
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := NewArchiver(100, io, "suffix", alwaysMergeThreshold, testLogger, testTracer)
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(100, 51)))
+	require.Empty(t, io.Result().MergedBundles)
 
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
+	// Crash between blocks 150 and 151, only the mergeable files written so far are left on disk
+	for _, fileName := range io.Result().MergeableOneBlockFiles {
+		io.PartialMergeableFiles = append(io.PartialMergeableFiles, bundle.MustNewOneBlockFile(fileName))
 	}
 
-	storedUploadableOneBlockfiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockfiles++
-		return nil
+	restarted := NewArchiver(100, io, "suffix", alwaysMergeThreshold, testLogger, testTracer)
+	for num := uint64(151); num <= 201; num++ {
+		require.NoError(t, restarted.StoreBlock(context.Background(), generator.Block(num, 100)))
 	}
 
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
+	result := io.Result()
+	require.Len(t, result.MergedBundles, 1)
+	assert.Equal(t, uint64(100), result.MergedBundles[0].InclusiveLowerBlock)
+	require.Len(t, result.MergedBundles[0].OneBlockFiles, 100)
+	assert.True(t, strings.HasPrefix(result.MergedBundles[0].OneBlockFiles[0], "0000000100-"))
+	assert.True(t, strings.HasPrefix(result.MergedBundles[0].OneBlockFiles[99], "0000000199-"))
+	assert.Equal(t, 0, result.SentMergeableAsOneBlockFiles)
+}
 
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
+func TestArchiver_InitLIBOnBoundary(t *testing.T) {
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, alwaysMergeThreshold)
 
-	var sentOneblockfilesFromMergeable bool
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		sentOneblockfilesFromMergeable = true
-		return nil
-	}
+	blocks := mindreadertest.BlocksFromOneBlockFileNames(
+		"0000000005-20210728T105016.01-0000005a-0000000a-0-suffix",
+		"0000000006-20210728T105016.02-0000006a-0000005a-0-suffix",
+		"0000000007-20210728T105016.03-0000007a-0000006a-0-suffix",
+		"0000000008-20210728T105016.06-0000008a-0000007a-2-suffix",
+		"0000000009-20210728T105016.08-0000009a-0000008a-2-suffix",
+		"0000000010-20210728T105016.08-0000010a-0000009a-2-suffix",
+	)
 
-	ctx := context.Background()
-	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
-		require.NoError(t, err)
-	}
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, blocks))
+
+	mindreadertest.AssertGolden(t, "testdata/archiver_init_lib_on_boundary.golden.json", io.Result())
+}
+
+func TestArchiver_GeneratedBlocksAlwaysMerge(t *testing.T) {
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, alwaysMergeThreshold)
+
+	generator := mindreadertest.NewBlockGenerator("archiver", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
+
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(10, 12)))
+
+	mindreadertest.AssertGolden(t, "testdata/archiver_generated_blocks_always_merge.golden.json", io.Result())
+}
 
-	assert.False(t, sentOneblockfilesFromMergeable)
-	assert.Equal(t, 1, storedMergedFiles)
-	assert.Equal(t, 5, deletedFiles)
-	assert.Equal(t, 6, storedMergableOneBlockFiles)
-	assert.Equal(t, 0, storedUploadableOneBlockfiles)
+func TestArchiver_Clock(t *testing.T) {
+	baseTime := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	generator := mindreadertest.NewBlockGenerator("archiver", baseTime)
+
+	tests := []struct {
+		name          string
+		now           time.Time
+		expectMerging bool
+	}{
+		{"blocks older than threshold", baseTime.Add(2 * time.Hour), true},
+		{"blocks younger than threshold", baseTime, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			clock := mindreadertest.NewClock(test.now)
+			archiver := NewArchiver(5, io, "suffix", time.Hour, testLogger, testTracer, WithArchiverClock(clock.Now))
+
+			require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(10, 12)))
+
+			result := io.Result()
+			if test.expectMerging {
+				assert.Len(t, result.MergedBundles, 2)
+				assert.Empty(t, result.OneBlockFiles)
+			} else {
+				assert.Empty(t, result.MergedBundles)
+				assert.Len(t, result.OneBlockFiles, 12)
+			}
+			mindreadertest.RequireBlocksArchived(t, io, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21)
+		})
+	}
 }
 
 func TestArchiver_StoreBlockNewBlocksWithExistingBundlerBlocks(t *testing.T) {
@@ -216,7 +208,8 @@ func TestArchiver_StoreBlockNewBlocksWithExistingBundlerBlocks(t *testing.T) {
 		bstream.GetBlockPayloadSetter = setter
 	}()
 
-	io, archiver := newArchiver(t, superLongTimeAgo)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, superLongTimeAgo)
 
 	bundlerOneBlockFiles := []*bundle.OneBlockFile{
 		bundle.MustNewOneBlockFile("0000000001-20210728T105016.01-00000001a-00000000a-0-suffix"),
@@ -235,64 +228,23 @@ func TestArchiver_StoreBlockNewBlocksWithExistingBundlerBlocks(t *testing.T) {
 		bundle.MustNewOneBlockFile("0000000006-20210728T105016.08-00000006a-00000004a-2-suffix"),
 	}
 
-	io.DownloadOneBlockFileFunc = func(ctx context.Context, oneBlockFile *bundle.OneBlockFile) (data []byte, err error) {
-		blk := oneBlockFileToBlock(oneBlockFile)
-		blk, err = bstream.MemoryBlockPayloadSetter(blk, []byte(oneBlockFile.CanonicalName))
-		if err != nil {
-			return nil, err
-		}
-
-		pblk, err := blk.ToProto()
-		if err != nil {
-			return nil, err
-		}
-		return proto.Marshal(pblk)
-	}
-
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
-
-	storedUploadableOneBlockfiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockfiles++
-		return nil
-	}
-
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
-
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
-
-	var sentOneblockfilesFromMergeable bool
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		sentOneblockfilesFromMergeable = true
-		return nil
-	}
-
 	ctx := context.Background()
 	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
+		err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(oneBlockFile))
 		require.NoError(t, err)
 	}
 
-	assert.True(t, sentOneblockfilesFromMergeable)
-	assert.Equal(t, 0, storedMergedFiles)
-	assert.Equal(t, 0, deletedFiles)
-	assert.Equal(t, 0, storedMergableOneBlockFiles)
-	assert.Equal(t, 3, storedUploadableOneBlockfiles)
+	result := io.Result()
+	assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles)
+	assert.Empty(t, result.MergedBundles)
+	assert.Empty(t, result.DeletedOneBlockFiles)
+	assert.Empty(t, result.MergeableOneBlockFiles)
+	assert.Len(t, result.OneBlockFiles, 3)
 }
 
 func TestArchiver_StoreBlock_OldBlocksPassThroughBoundary(t *testing.T) {
-	io, archiver := newArchiver(t, time.Hour)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, time.Hour)
 
 	bstream.GetProtocolFirstStreamableBlock = 1
 	srcOneBlockFiles := []*bundle.OneBlockFile{
@@ -303,48 +255,23 @@ func TestArchiver_StoreBlock_OldBlocksPassThroughBoundary(t *testing.T) {
 		bundle.MustNewOneBlockFile("0000000006-20210728T105016.08-00000006a-00000004a-2-suffix"),
 	}
 
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
-
-	storedUploadableOneBlockfiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockfiles++
-		return nil
-	}
-
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
-
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
-
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		t.Error()
-		return nil
-	}
-
 	ctx := context.Background()
 	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
+		err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(oneBlockFile))
 		require.NoError(t, err)
 	}
 
-	assert.Equal(t, 1, storedMergedFiles)
-	assert.Equal(t, 4, deletedFiles)
-	assert.Equal(t, 5, storedMergableOneBlockFiles)
-	assert.Equal(t, 0, storedUploadableOneBlockfiles)
+	result := io.Result()
+	assert.Equal(t, 0, result.SentMergeableAsOneBlockFiles)
+	assert.Len(t, result.MergedBundles, 1)
+	assert.Len(t, result.DeletedOneBlockFiles, 4)
+	assert.Len(t, result.MergeableOneBlockFiles, 5)
+	assert.Empty(t, result.OneBlockFiles)
 }
 
 func TestArchiver_StoreBlock_BundleInclusiveLowerBlock(t *testing.T) {
-	io, archiver := newArchiver(t, time.Hour)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, time.Hour)
 
 	srcOneBlockFiles := []*bundle.OneBlockFile{
 		bundle.MustNewOneBlockFile("00000000011-20210728T105016.01-000000011a-000000010a-10-suffix"),
@@ -357,50 +284,25 @@ func TestArchiver_StoreBlock_BundleInclusiveLowerBlock(t *testing.T) {
 		bundle.MustNewOneBlockFile("00000000016-20210728T105016.08-000000016a-000000014a-12-suffix"),
 	}
 
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
-
-	storedUploadableOneBlockfiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockfiles++
-		return nil
-	}
-
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
-
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
-
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		t.Error()
-		return nil
-	}
-
 	ctx := context.Background()
 	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
+		err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(oneBlockFile))
 		require.NoError(t, err)
 	}
 
-	assert.Equal(t, 0, storedMergedFiles)
-	assert.Equal(t, 0, deletedFiles)
-	assert.Equal(t, 1, storedMergableOneBlockFiles)   // 16
-	assert.Equal(t, 6, storedUploadableOneBlockfiles) // 11, 5, 12 13, 14, 16 (16 is sent so a merger instance will 'close' the [10-15] range)
+	result := io.Result()
+	assert.Equal(t, 0, result.SentMergeableAsOneBlockFiles)
+	assert.Empty(t, result.MergedBundles)
+	assert.Empty(t, result.DeletedOneBlockFiles)
+	assert.Len(t, result.MergeableOneBlockFiles, 1) // 16
+	assert.Len(t, result.OneBlockFiles, 6)          // 11, 5, 12 13, 14, 16 (16 is sent so a merger instance will 'close' the [10-15] range)
 }
 
 func TestArchiver_Store_OneBlock_after_last_merge(t *testing.T) {
 	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
 
-	io, archiver := newArchiver(t, time.Hour)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, time.Hour)
 
 	srcOneBlockFiles := []*bundle.OneBlockFile{
 		bundle.MustNewOneBlockFile("00000000010-20210728T105016.00-000000010a-000000009a-9-suffix"),
@@ -411,81 +313,35 @@ func TestArchiver_Store_OneBlock_after_last_merge(t *testing.T) {
 		bundle.MustNewOneBlockFile("00000000016-20210728T105016.08-000000016a-000000014a-12-suffix"),
 		bundle.MustNewOneBlockFile("00000000017-20210728T105016.08-000000017a-000000016a-12-suffix"),
 	}
-	io.DownloadOneBlockFileFunc = func(ctx context.Context, oneBlockFile *bundle.OneBlockFile) (data []byte, err error) {
-		block := oneBlockFileToBlock(oneBlockFile)
-		block, err = bstream.MemoryBlockPayloadSetter(block, []byte(oneBlockFile.CanonicalName))
-		if err != nil {
-			return nil, err
-		}
-
-		pbBlock, err := block.ToProto()
-		if err != nil {
-			return nil, err
-		}
-		return proto.Marshal(pbBlock)
-
-	}
-
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
-
-	storedUploadableOneBlockFiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockFiles++
-		return nil
-	}
-
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
-
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
-	var sentOneblockfilesFromMergeable bool
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		sentOneblockfilesFromMergeable = true
-		return nil
-	}
 
 	ctx := context.Background()
 	for i, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
+		err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(oneBlockFile))
 		if i == 5 {
 			archiver.currentlyMerging = false //force the end off merging state.
 		}
 		require.NoError(t, err)
 	}
-	assert.True(t, sentOneblockfilesFromMergeable)
-	assert.Equal(t, 1, storedMergedFiles)             //10->14
-	assert.Equal(t, 5, deletedFiles)                  // 10->14 (16 is sent from SendMergeableAsOneBlockFiles)
-	assert.Equal(t, 6, storedMergableOneBlockFiles)   // the same that were deleted after
-	assert.Equal(t, 1, storedUploadableOneBlockFiles) // 17 (16 is sent from SendMergeableAsOneBlockFiles)
+
+	result := io.Result()
+	assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles)
+	assert.Len(t, result.MergedBundles, 1)          //10->14
+	assert.Len(t, result.DeletedOneBlockFiles, 5)   // 10->14 (16 is sent from SendMergeableAsOneBlockFiles)
+	assert.Len(t, result.MergeableOneBlockFiles, 6) // the same that were deleted after
+	assert.Len(t, result.OneBlockFiles, 1)          // 17 (16 is sent from SendMergeableAsOneBlockFiles)
 }
 
 func TestArchiver_StoreBlock_NewBlocksBatchMode(t *testing.T) {
-	io, archiver := newArchiver(t, alwaysMergeThreshold)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, alwaysMergeThreshold)
 
 	srcExistingMergeableOneBlockFiles := []string{
 		"0000000001-20210728T105016.01-0000001a-0000000a-0-suffix",
 		"0000000002-20210728T105016.02-0000002a-0000001a-1-suffix",
 	}
 
-	io.WalkMergeableOneBlockFilesFunc = func(ctx context.Context) ([]*bundle.OneBlockFile, error) {
-		result := []*bundle.OneBlockFile{}
-		for _, filename := range srcExistingMergeableOneBlockFiles {
-			obf := bundle.MustNewOneBlockFile(filename)
-			_, _, _, _, libNumPtr, _, _ := bundle.ParseFilename(filename)
-			obf.InnerLibNum = libNumPtr
-			result = append(result, obf)
-		}
-		return result, nil
+	for _, filename := range srcExistingMergeableOneBlockFiles {
+		io.PartialMergeableFiles = append(io.PartialMergeableFiles, bundle.MustNewOneBlockFile(filename))
 	}
 
 	srcOneBlockFiles := []*bundle.OneBlockFile{
@@ -494,43 +350,22 @@ func TestArchiver_StoreBlock_NewBlocksBatchMode(t *testing.T) {
 		bundle.MustNewOneBlockFile("0000000006-20210728T105016.08-0000006a-0000004a-2-suffix"),
 	}
 
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
-
-	storedUploadableOneBlockFiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockFiles++
-		return nil
-	}
-
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
-	}
-
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
-	}
-
 	ctx := context.Background()
 	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
+		err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(oneBlockFile))
 		require.NoError(t, err)
 	}
 
-	assert.Equal(t, 1, storedMergedFiles)
-	assert.Equal(t, 4, deletedFiles)
-	assert.Equal(t, 3, storedMergableOneBlockFiles)
-	assert.Equal(t, 0, storedUploadableOneBlockFiles)
+	result := io.Result()
+	assert.Len(t, result.MergedBundles, 1)
+	assert.Len(t, result.DeletedOneBlockFiles, 4)
+	assert.Len(t, result.MergeableOneBlockFiles, 3)
+	assert.Empty(t, result.OneBlockFiles)
 }
 
 func TestArchiver_StoreBlock_NewBlocksBatchNonConnectedPartial(t *testing.T) {
-	io, archiver := newArchiver(t, alwaysMergeThreshold)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, alwaysMergeThreshold)
 
 	bstream.GetProtocolFirstStreamableBlock = 1
 	srcExistingMergeableOneBlockFiles := []string{
@@ -538,24 +373,18 @@ func TestArchiver_StoreBlock_NewBlocksBatchNonConnectedPartial(t *testing.T) {
 		"0000000002-20210728T105016.02-00000002a-00000001a-1-suffix",
 	}
 
-	io.WalkMergeableOneBlockFilesFunc = func(ctx context.Context) ([]*bundle.OneBlockFile, error) {
-		result := []*bundle.OneBlockFile{}
-		for _, filename := range srcExistingMergeableOneBlockFiles {
-			obf := bundle.MustNewOneBlockFile(filename)
-			_, _, _, _, libNumPtr, _, _ := bundle.ParseFilename(filename)
-			obf.InnerLibNum = libNumPtr
-			result = append(result, obf)
-		}
-		return result, nil
+	for _, filename := range srcExistingMergeableOneBlockFiles {
+		io.PartialMergeableFiles = append(io.PartialMergeableFiles, bundle.MustNewOneBlockFile(filename))
 	}
 
 	//bundle.MustNewOneBlockFile("0000000003-20210728T105016.03-00000003a-00000002a-1-suffix"),
 	srcOneBlockFile := bundle.MustNewOneBlockFile("0000000004-20210728T105016.06-00000004a-00000003a-1-suffix")
 
 	ctx := context.Background()
-	err := archiver.storeBlock(ctx, oneBlockFileToBlock(srcOneBlockFile))
-	require.Error(t, err)
-
+	err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(srcOneBlockFile))
+	require.NoError(t, err)
+	result := io.Result()
+	assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles, "non connected partial blocks are sent as one block files")
 }
 
 func TestArchiver_OldBlockToNewBlocksPassThrough(t *testing.T) {
@@ -565,7 +394,8 @@ func TestArchiver_OldBlockToNewBlocksPassThrough(t *testing.T) {
 		bstream.GetBlockPayloadSetter = setter
 	}()
 
-	io, archiver := newArchiver(t, 24*time.Hour)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, 24*time.Hour)
 
 	time.Now().Year()
 	yearstr := fmt.Sprintf("%0*d", 4, time.Now().Year())
@@ -588,71 +418,267 @@ func TestArchiver_OldBlockToNewBlocksPassThrough(t *testing.T) {
 		bundle.MustNewOneBlockFile(fmt.Sprintf("0000000006-%s.11-00000009a-00000008a-2-suffix", nowstr)),
 	}
 
-	io.DownloadOneBlockFileFunc = func(ctx context.Context, oneBlockFile *bundle.OneBlockFile) (data []byte, err error) {
-		blk := oneBlockFileToBlock(oneBlockFile)
-		blk, err = bstream.MemoryBlockPayloadSetter(blk, []byte(oneBlockFile.CanonicalName))
-		if err != nil {
-			return nil, err
-		}
-
-		pblk, err := blk.ToProto()
-		if err != nil {
-			return nil, err
-		}
-		return proto.Marshal(pblk)
+	ctx := context.Background()
+	for _, oneBlockFile := range srcOneBlockFiles {
+		err := archiver.storeBlock(ctx, mindreadertest.BlockFromOneBlockFile(oneBlockFile))
+		require.NoError(t, err)
 	}
 
-	storedMergableOneBlockFiles := 0
-	io.StoreMergeableOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedMergableOneBlockFiles++
-		return nil
-	}
+	result := io.Result()
+	assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles)
+	assert.Empty(t, result.MergedBundles)
+	assert.Empty(t, result.DeletedOneBlockFiles)
+	assert.Len(t, result.MergeableOneBlockFiles, 1)
+	assert.Len(t, result.OneBlockFiles, 7)
+}
 
-	storedUploadableOneBlockfiles := 0
-	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
-		storedUploadableOneBlockfiles++
-		return nil
-	}
+func TestArchiver_SetModeMergeToOneBlockMidBundle(t *testing.T) {
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := NewArchiver(5, io, "suffix", alwaysMergeThreshold, testLogger, testTracer, WithArchiverMode(ModeMergeOnly))
+
+	generator := mindreadertest.NewBlockGenerator("archiver", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
 
-	storedMergedFiles := 0
-	io.MergeAndStoreFunc = func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-		storedMergedFiles++
-		return nil
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(10, 8)))
+
+	archiver.SetMode(ModeOneBlockOnly)
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, []*bstream.Block{generator.Block(18, 10), generator.Block(19, 10)}))
+
+	result := io.Result()
+	assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles, "partial bundle should have been flushed as one block files")
+	mindreadertest.AssertGolden(t, "testdata/archiver_set_mode_merge_to_one_block.golden.json", result)
+}
+
+func TestArchiver_SetModeOneBlockToMergeMidStream(t *testing.T) {
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := NewArchiver(5, io, "suffix", alwaysMergeThreshold, testLogger, testTracer, WithArchiverMode(ModeOneBlockOnly))
+
+	generator := mindreadertest.NewBlockGenerator("archiver", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
+
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(10, 3)))
+
+	archiver.SetMode(ModeMergeOnly)
+	var blocks []*bstream.Block
+	for num := uint64(13); num <= 22; num++ {
+		blocks = append(blocks, generator.Block(num, 10))
 	}
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, blocks))
+
+	result := io.Result()
+	require.Len(t, result.MergedBundles, 1)
+	assert.Equal(t, uint64(15), result.MergedBundles[0].InclusiveLowerBlock, "bundle should start on the next boundary")
+	mindreadertest.AssertGolden(t, "testdata/archiver_set_mode_one_block_to_merge.golden.json", result)
+}
 
-	deletedFiles := 0
-	io.DeleteOneBlockFilesFunc = func(oneBlockFiles []*bundle.OneBlockFile) {
-		deletedFiles += len(oneBlockFiles)
+func TestArchiver_SetMergeThresholdBlockAge(t *testing.T) {
+	// Blocks are a few years old
+	cases := []struct {
+		name          string
+		initial       time.Duration
+		updated       time.Duration
+		mergingBefore bool
+		mergingAfter  bool
+	}{
+		{"never to always", 0, -1, false, true},
+		{"always to never", -1, 0, true, false},
+		{"old blocks merged to not merged", time.Hour, 100 * 365 * 24 * time.Hour, true, false},
+		{"old blocks not merged to merged", 100 * 365 * 24 * time.Hour, time.Hour, false, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			archiver := NewArchiver(100, io, "suffix", c.initial, testLogger, testTracer)
+
+			generator := mindreadertest.NewBlockGenerator("archiver", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+			generator.LIBLag = 2
+
+			require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(100, 51)))
+			archiver.SetMergeThresholdBlockAge(c.updated)
+			require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, generator.Blocks(151, 100)))
+
+			mergeable := map[uint64]bool{}
+			for _, fileName := range io.Result().MergeableOneBlockFiles {
+				mergeable[bundle.MustNewOneBlockFile(fileName).Num] = true
+			}
+
+			for num := uint64(151); num <= 250; num++ {
+				expected := c.mergingBefore
+				if num >= 200 {
+					expected = c.mergingAfter
+				}
+				assert.Equal(t, expected, mergeable[num], "block %d merged", num)
+			}
+		})
 	}
+}
 
-	var sentOneblockfilesFromMergeable bool
-	io.SendMergeableAsOneBlockFilesFunc = func(context.Context) error {
-		sentOneblockfilesFromMergeable = true
-		return nil
+func TestArchiver_MaxBundleAge(t *testing.T) {
+	baseTime := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	generator := mindreadertest.NewBlockGenerator("archiver", baseTime)
+	generator.LIBLag = 2
+
+	tests := []struct {
+		name             string
+		maxBundleAge     time.Duration
+		expectedFlushes  int
+		expectedBundles  []uint64
+		expectedOneBlock []uint64
+	}{
+		// a block every 30s, the bundle [10, 15) is flushed when 13 arrives (90s after 10), blocks are
+		// then one block files until the next boundary where bundles complete again in time
+		{"flushed when older than max age", time.Minute, 1, []uint64{15}, []uint64{14, 15}},
+		{"completed before max age", 5 * time.Minute, 0, []uint64{10, 15}, nil},
+		{"disabled", 0, 0, []uint64{10, 15}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			clock := mindreadertest.NewClock(baseTime)
+			archiver := NewArchiver(5, io, "suffix", alwaysMergeThreshold, testLogger, testTracer, WithArchiverClock(clock.Now), WithMaxBundleAge(test.maxBundleAge))
+
+			for num := uint64(10); num <= 21; num++ {
+				if num > 10 && num <= 14 {
+					clock.Advance(30 * time.Second)
+				}
+				require.NoError(t, archiver.StoreBlock(context.Background(), generator.Block(num, 10)))
+			}
+
+			result := io.Result()
+			assert.Equal(t, test.expectedFlushes, result.SentMergeableAsOneBlockFiles)
+
+			var bundles []uint64
+			for _, merged := range result.MergedBundles {
+				bundles = append(bundles, merged.InclusiveLowerBlock)
+			}
+			assert.Equal(t, test.expectedBundles, bundles)
+
+			var oneBlocks []uint64
+			for _, blk := range mindreadertest.BlocksFromOneBlockFileNames(result.OneBlockFiles...) {
+				oneBlocks = append(oneBlocks, blk.Number)
+			}
+			assert.Equal(t, test.expectedOneBlock, oneBlocks)
+		})
 	}
+}
 
-	ctx := context.Background()
-	for _, oneBlockFile := range srcOneBlockFiles {
-		err := archiver.storeBlock(ctx, oneBlockFileToBlock(oneBlockFile))
-		require.NoError(t, err)
+func TestArchiver_BundleSize(t *testing.T) {
+	generator := mindreadertest.NewBlockGenerator("bundle-size", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
+
+	storeRange := func(t *testing.T, archiver *Archiver, from, to, lowestBlockNum uint64) {
+		t.Helper()
+		for num := from; num <= to; num++ {
+			require.NoError(t, archiver.StoreBlock(context.Background(), generator.Block(num, lowestBlockNum)))
+		}
 	}
 
-	assert.True(t, sentOneblockfilesFromMergeable)
-	assert.Equal(t, 0, storedMergedFiles)
-	assert.Equal(t, 0, deletedFiles)
-	assert.Equal(t, 1, storedMergableOneBlockFiles)
-	assert.Equal(t, 7, storedUploadableOneBlockfiles)
-}
+	partialFiles := func(t *testing.T, bundleSize, from, to uint64) []*bundle.OneBlockFile {
+		t.Helper()
+		io := mindreadertest.NewRecordingArchiverIO()
+		storeRange(t, NewArchiver(bundleSize, io, "suffix", alwaysMergeThreshold, testLogger, testTracer), from, to, from)
 
-func oneBlockFileToBlock(oneBlockFile *bundle.OneBlockFile) *bstream.Block {
-	return &bstream.Block{
-		Id:             oneBlockFile.ID,
-		Number:         oneBlockFile.Num,
-		PreviousId:     oneBlockFile.PreviousID,
-		Timestamp:      oneBlockFile.BlockTime,
-		LibNum:         oneBlockFile.LibNum(),
-		PayloadKind:    0,
-		PayloadVersion: 0,
-		Payload:        nil,
+		var out []*bundle.OneBlockFile
+		for _, fileName := range io.Result().MergeableOneBlockFiles {
+			out = append(out, bundle.MustNewOneBlockFile(fileName))
+		}
+		require.Len(t, out, int(to-from+1))
+		return out
+	}
+
+	blockNumPrefix := func(num uint64) string {
+		return fmt.Sprintf("%010d-", num)
+	}
+
+	// Completing a bundle of 1000 blocks takes a while, only the boundaries are checked with it
+	tests := []struct {
+		bundleSize     uint64
+		completeBundle bool
+	}{
+		{100, true},
+		{1000, false},
+	}
+
+	for _, test := range tests {
+		size := test.bundleSize
+
+		t.Run(fmt.Sprintf("traverse boundary/%d", size), func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			archiver := NewArchiver(size, io, "suffix", alwaysMergeThreshold, testLogger, testTracer)
+			storeRange(t, archiver, size-50, size+50, size-50)
+
+			result := io.Result()
+			require.Len(t, result.OneBlockFiles, 51, "blocks up to the first boundary are sent as one block files")
+			assert.True(t, strings.HasPrefix(result.OneBlockFiles[0], blockNumPrefix(size-50)))
+			assert.True(t, strings.HasPrefix(result.OneBlockFiles[50], blockNumPrefix(size)))
+			require.Len(t, result.MergeableOneBlockFiles, 51, "blocks are merged from the first boundary")
+			assert.True(t, strings.HasPrefix(result.MergeableOneBlockFiles[0], blockNumPrefix(size)))
+			assert.Equal(t, size, archiver.bundler.BundleInclusiveLowerBlock())
+			assert.Equal(t, 2*size, archiver.bundler.ExclusiveHighestBlockLimit())
+			assert.Empty(t, result.MergedBundles)
+
+			if !test.completeBundle {
+				return
+			}
+			storeRange(t, archiver, size+51, 2*size, size-50)
+
+			result = io.Result()
+			require.Len(t, result.MergedBundles, 1)
+			assert.Equal(t, size, result.MergedBundles[0].InclusiveLowerBlock)
+			require.Len(t, result.MergedBundles[0].OneBlockFiles, int(size))
+			assert.True(t, strings.HasPrefix(result.MergedBundles[0].OneBlockFiles[0], blockNumPrefix(size)))
+			assert.True(t, strings.HasPrefix(result.MergedBundles[0].OneBlockFiles[size-1], blockNumPrefix(2*size-1)))
+			assert.Equal(t, 2*size, archiver.bundler.BundleInclusiveLowerBlock())
+		})
+
+		t.Run(fmt.Sprintf("resume partial bundle/%d", size), func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			io.PartialMergeableFiles = partialFiles(t, size, size, size+49)
+
+			archiver := NewArchiver(size, io, "suffix", alwaysMergeThreshold, testLogger, testTracer)
+			storeRange(t, archiver, size+50, size+60, size)
+
+			result := io.Result()
+			assert.Empty(t, result.OneBlockFiles)
+			assert.Equal(t, 0, result.SentMergeableAsOneBlockFiles)
+			assert.Equal(t, size, archiver.bundler.BundleInclusiveLowerBlock())
+
+			if !test.completeBundle {
+				return
+			}
+			storeRange(t, archiver, size+61, 2*size, size)
+
+			result = io.Result()
+			require.Len(t, result.MergedBundles, 1)
+			assert.Equal(t, size, result.MergedBundles[0].InclusiveLowerBlock)
+			assert.Len(t, result.MergedBundles[0].OneBlockFiles, int(size))
+		})
+
+		t.Run(fmt.Sprintf("holes/%d", size), func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			io.PartialMergeableFiles = partialFiles(t, size, size, size+9)
+
+			// Blocks `size+10` to `size+19` are missing, the partial bundle cannot be resumed
+			archiver := NewArchiver(size, io, "suffix", alwaysMergeThreshold, testLogger, testTracer)
+			storeRange(t, archiver, size+20, size+30, size+20)
+
+			result := io.Result()
+			assert.Equal(t, 1, result.SentMergeableAsOneBlockFiles, "orphaned partial blocks are sent as one block files")
+			assert.Len(t, result.OneBlockFiles, 11, "blocks are sent as one block files until the next boundary")
+			assert.Empty(t, result.MergeableOneBlockFiles)
+			assert.Equal(t, 2*size, archiver.firstBoundaryTarget)
+
+			storeRange(t, archiver, size+31, 2*size+10, size+20)
+
+			result = io.Result()
+			require.Len(t, result.OneBlockFiles, int(size-19))
+			assert.True(t, strings.HasPrefix(result.OneBlockFiles[size-21], blockNumPrefix(2*size-1)))
+			assert.True(t, strings.HasPrefix(result.OneBlockFiles[size-20], blockNumPrefix(2*size)))
+			require.Len(t, result.MergeableOneBlockFiles, 11)
+			assert.True(t, strings.HasPrefix(result.MergeableOneBlockFiles[0], blockNumPrefix(2*size)))
+			assert.Equal(t, 2*size, archiver.bundler.BundleInclusiveLowerBlock())
+		})
 	}
 }
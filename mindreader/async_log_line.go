@@ -0,0 +1,219 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// LineOverflowPolicy tells what `LogLine` does with a line when the queue of
+// `WithAsyncLogLine` is full
+type LineOverflowPolicy int
+
+const (
+	// LineOverflowBlock waits for room in the queue, never dropping a line, like the synchronous
+	// `LogLine`
+	LineOverflowBlock LineOverflowPolicy = iota
+
+	// LineOverflowDropOldest drops the oldest queued line to make room for the new one
+	LineOverflowDropOldest
+
+	// LineOverflowDropNewest drops the new line, keeping the queued ones
+	LineOverflowDropNewest
+)
+
+func (p LineOverflowPolicy) String() string {
+	switch p {
+	case LineOverflowBlock:
+		return "block"
+	case LineOverflowDropOldest:
+		return "drop-oldest"
+	case LineOverflowDropNewest:
+		return "drop-newest"
+	}
+	return fmt.Sprintf("LineOverflowPolicy(%d)", int(p))
+}
+
+// ParseLineOverflowPolicy parses the name of a policy, as returned by its `String` method
+func ParseLineOverflowPolicy(name string) (LineOverflowPolicy, error) {
+	for _, policy := range []LineOverflowPolicy{LineOverflowBlock, LineOverflowDropOldest, LineOverflowDropNewest} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid line overflow policy %q, must be one of block, drop-oldest or drop-newest", name)
+}
+
+// WithAsyncLogLine makes `LogLine` queue the lines in a ring buffer of `capacity` lines, a
+// dedicated goroutine writing them to the line buffer, so a stalled console reader does not block
+// the superviser reading the node's output. When the queue is full, `policy` tells if `LogLine`
+// waits or which line is dropped, dropped lines are counted in the `DroppedLines` of the plugin's
+// status. The queued lines are flushed to the console reader when the plugin stops.
+func WithAsyncLogLine(capacity int, policy LineOverflowPolicy) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if capacity <= 0 {
+			capacity = defaultLineBufferLines
+		}
+		p.lineQueue = newLineQueue(capacity, policy)
+	}
+}
+
+// lineQueue is a bounded FIFO of lines applying an overflow policy when full
+type lineQueue struct {
+	policy LineOverflowPolicy
+
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	ring     []string
+	head     int // index of the oldest line
+	count    int
+	closed   bool
+
+	dropped      atomic.Uint64
+	drainerDone  chan struct{}
+	drainerStart sync.Once
+}
+
+func newLineQueue(capacity int, policy LineOverflowPolicy) *lineQueue {
+	q := &lineQueue{
+		policy:      policy,
+		ring:        make([]string, capacity),
+		drainerDone: make(chan struct{}),
+	}
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+	return q
+}
+
+// push queues `line`, returning false when a line, `line` or the oldest queued one, was dropped.
+// Lines pushed once the queue is closed are dropped without being counted.
+func (q *lineQueue) push(line string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.policy == LineOverflowBlock && q.count == len(q.ring) && !q.closed {
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return true
+	}
+
+	dropped := false
+	if q.count == len(q.ring) {
+		if q.policy == LineOverflowDropNewest {
+			q.dropped.Inc()
+			return false
+		}
+
+		q.head = (q.head + 1) % len(q.ring)
+		q.count--
+		q.dropped.Inc()
+		dropped = true
+	}
+
+	q.ring[(q.head+q.count)%len(q.ring)] = line
+	q.count++
+	q.notEmpty.Signal()
+	return !dropped
+}
+
+// pop returns the oldest queued line, waiting for one, false once the queue is closed and empty
+func (q *lineQueue) pop() (string, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.count == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.count == 0 {
+		return "", false
+	}
+
+	line := q.ring[q.head]
+	q.ring[q.head] = ""
+	q.head = (q.head + 1) % len(q.ring)
+	q.count--
+	q.notFull.Signal()
+	return line, true
+}
+
+// close stops accepting lines, the lines already queued are still returned by `pop`
+func (q *lineQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// startLineQueueDrainer writes the queued lines to the line buffer until the queue is closed
+// and empty. Once the console reader is done, nothing reads the lines anymore, they are discarded.
+func (p *MindReaderPlugin) startLineQueueDrainer() {
+	q := p.lineQueue
+	q.drainerStart.Do(func() {
+		go func() {
+			defer close(q.drainerDone)
+			for {
+				line, ok := q.pop()
+				if !ok {
+					return
+				}
+				if p.consoleReaderDone.Load() {
+					continue
+				}
+				p.writeLine(line)
+			}
+		}()
+	})
+}
+
+// flushLineQueue closes the queue of `WithAsyncLogLine`, if any, and waits until its lines are
+// written to the line buffer. The lines channel must not be closed before.
+func (p *MindReaderPlugin) flushLineQueue() {
+	if p.lineQueue == nil {
+		return
+	}
+
+	p.lineQueue.close()
+	p.startLineQueueDrainer()
+	<-p.lineQueue.drainerDone
+}
+
+// queueLine queues `line` for the drainer goroutine, warning the first time a line is dropped
+func (p *MindReaderPlugin) queueLine(line string) {
+	if p.lineQueue.push(line) {
+		return
+	}
+	if p.lineDropWarned.CAS(false, true) {
+		p.zlogger.Warn("async log line queue is full, dropping lines, the console reader does not keep up with the node's output",
+			zap.Int("queue_capacity", len(p.lineQueue.ring)),
+			zap.Stringer("overflow_policy", p.lineQueue.policy),
+		)
+	}
+}
+
+// droppedLines returns the number of lines dropped by the queue of `WithAsyncLogLine`
+func (p *MindReaderPlugin) droppedLines() uint64 {
+	if p.lineQueue == nil {
+		return 0
+	}
+	return p.lineQueue.dropped.Load()
+}
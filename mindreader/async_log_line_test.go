@@ -0,0 +1,195 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func popAll(q *lineQueue) []string {
+	q.close()
+
+	var out []string
+	for {
+		line, ok := q.pop()
+		if !ok {
+			return out
+		}
+		out = append(out, line)
+	}
+}
+
+func TestLineQueue_DropOldest(t *testing.T) {
+	q := newLineQueue(2, LineOverflowDropOldest)
+
+	assert.True(t, q.push("a"))
+	assert.True(t, q.push("b"))
+	assert.False(t, q.push("c"))
+	assert.False(t, q.push("d"))
+
+	assert.Equal(t, []string{"c", "d"}, popAll(q))
+	assert.Equal(t, uint64(2), q.dropped.Load())
+}
+
+func TestLineQueue_DropNewest(t *testing.T) {
+	q := newLineQueue(2, LineOverflowDropNewest)
+
+	assert.True(t, q.push("a"))
+	assert.True(t, q.push("b"))
+	assert.False(t, q.push("c"))
+	assert.False(t, q.push("d"))
+
+	assert.Equal(t, []string{"a", "b"}, popAll(q))
+	assert.Equal(t, uint64(2), q.dropped.Load())
+}
+
+func TestLineQueue_Block(t *testing.T) {
+	q := newLineQueue(2, LineOverflowBlock)
+	require.True(t, q.push("a"))
+	require.True(t, q.push("b"))
+
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.push("c")
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push should wait for room in the queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	line, ok := q.pop()
+	require.True(t, ok)
+	assert.Equal(t, "a", line)
+
+	select {
+	case ok := <-pushed:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("push should complete once a line was popped")
+	}
+
+	assert.Equal(t, []string{"b", "c"}, popAll(q))
+	assert.Zero(t, q.dropped.Load())
+}
+
+func TestLineQueue_CloseReleasesBlockedPush(t *testing.T) {
+	q := newLineQueue(1, LineOverflowBlock)
+	require.True(t, q.push("a"))
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push("b")
+		close(pushed)
+	}()
+
+	q.close()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push should return once the queue is closed")
+	}
+	assert.Equal(t, []string{"a"}, popAll(q))
+}
+
+func newAsyncLogLineTestPlugin(linesCapacity, queueCapacity int, policy LineOverflowPolicy) *MindReaderPlugin {
+	p := &MindReaderPlugin{
+		Shutter: shutter.New(),
+		lines:   make(chan string, linesCapacity),
+		zlogger: testLogger,
+	}
+	WithAsyncLogLine(queueCapacity, policy)(p)
+	return p
+}
+
+func TestMindReaderPlugin_AsyncLogLine_FlushOnStop(t *testing.T) {
+	p := newAsyncLogLineTestPlugin(1, 10, LineOverflowBlock)
+	p.startLineQueueDrainer()
+
+	received := make(chan []string)
+	go func() {
+		var out []string
+		for line := range p.lines {
+			time.Sleep(100 * time.Microsecond)
+			out = append(out, line)
+		}
+		received <- out
+	}()
+
+	var expected []string
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("line %d", i)
+		expected = append(expected, line)
+		p.LogLine(line)
+	}
+
+	p.Shutdown(nil)
+	p.LogLine("after shutdown")
+	p.flushLineQueue()
+	p.closeLines()
+
+	assert.Equal(t, expected, <-received)
+	assert.Zero(t, p.Status().DroppedLines)
+}
+
+func TestMindReaderPlugin_AsyncLogLine_DroppedLines(t *testing.T) {
+	tests := []struct {
+		policy        LineOverflowPolicy
+		expectedLines []string
+	}{
+		{LineOverflowDropOldest, []string{"line 3", "line 4"}},
+		{LineOverflowDropNewest, []string{"line 0", "line 1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			p := newAsyncLogLineTestPlugin(10, 2, test.policy)
+
+			// The drainer is not started, the queue fills up
+			for i := 0; i < 5; i++ {
+				p.LogLine(fmt.Sprintf("line %d", i))
+			}
+			assert.Equal(t, uint64(3), p.Status().DroppedLines)
+			assert.True(t, p.lineDropWarned.Load())
+
+			p.flushLineQueue()
+			p.closeLines()
+
+			var received []string
+			for line := range p.lines {
+				received = append(received, line)
+			}
+			assert.Equal(t, test.expectedLines, received)
+		})
+	}
+}
+
+func TestParseLineOverflowPolicy(t *testing.T) {
+	for _, policy := range []LineOverflowPolicy{LineOverflowBlock, LineOverflowDropOldest, LineOverflowDropNewest} {
+		parsed, err := ParseLineOverflowPolicy(policy.String())
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseLineOverflowPolicy("drop-all")
+	assert.Error(t, err)
+}
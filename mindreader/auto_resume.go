@@ -0,0 +1,78 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// WithAutoResume starts after the highest one block file already written, instead of the
+// configured start block, so a restarted mindreader does not write again the one block files it
+// produced before stopping. The files waiting in the working directory and the ones of the one
+// block store are looked at, the highest block wins. The configured start block is kept when no
+// file is found or when it is higher. See `ResumeBlock`.
+func WithAutoResume() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.autoResume = true
+	}
+}
+
+// ResumeBlock returns the first block archived, blocks before it being discarded: the
+// configured start block, or the block following the highest one block file found with
+// `WithAutoResume`
+func (p *MindReaderPlugin) ResumeBlock() uint64 {
+	return p.resumeBlock
+}
+
+// resolveAutoResume moves the start gate after the highest one block file of `stores`
+func (p *MindReaderPlugin) resolveAutoResume(ctx context.Context, stores ...dstore.Store) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultResumePointCheckTimeout)
+	defer cancel()
+
+	var highestFile string
+	var highest uint64
+	for _, store := range stores {
+		filename, err := highestBlockFile(ctx, store)
+		if err != nil {
+			return fmt.Errorf("listing store %q: %w", storeLabel(store), err)
+		}
+		if filename == "" {
+			continue
+		}
+
+		num, err := blockFileNum(filename)
+		if err != nil {
+			return err
+		}
+		if highestFile == "" || num > highest {
+			highestFile, highest = filename, num
+		}
+	}
+
+	configured := p.resumeBlock
+	if highestFile == "" || highest+1 <= configured {
+		p.zlogger.Info("auto resume found no one block file after the start block, starting at the configured start block", zap.Uint64("resume_block", configured), zap.String("highest_one_block_file", highestFile))
+		return nil
+	}
+
+	p.resumeBlock = highest + 1
+	p.startGate = NewAndGate(p.startGate, NewBlockNumberGate(p.resumeBlock))
+	p.zlogger.Info("auto resuming after the highest one block file", zap.Uint64("resume_block", p.resumeBlock), zap.Uint64("configured_start_block", configured), zap.String("highest_one_block_file", highestFile))
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMindReaderPlugin_AutoResume(t *testing.T) {
+	tests := []struct {
+		name             string
+		startBlock       uint64
+		localFiles       []string
+		storeFiles       []string
+		expectResume     uint64
+		expectGateBefore uint64
+	}{
+		{"empty", 10, nil, nil, 10, 9},
+		{"local files only", 10, []string{
+			"0000000120-20210101T000000.0-00000120a-00000119a-suffix",
+			"0000000121-20210101T000000.0-00000121a-00000120a-suffix",
+		}, nil, 122, 121},
+		{"store files only", 10, nil, []string{
+			"0000000098-20210101T000000.0-00000098a-00000097a-suffix",
+			"0000000099-20210101T000000.0-00000099a-00000098a-suffix",
+		}, 100, 99},
+		{"local files ahead of store", 10, []string{
+			"0000000130-20210101T000000.0-00000130a-00000129a-suffix",
+		}, []string{
+			"0000000129-20210101T000000.0-00000129a-00000128a-suffix",
+		}, 131, 130},
+		{"store ahead of local files", 10, []string{
+			"0000000130-20210101T000000.0-00000130a-00000129a-suffix",
+		}, []string{
+			"0000000200-20210101T000000.0-00000200a-00000199a-suffix",
+		}, 201, 200},
+		{"start block higher than files", 500, nil, []string{
+			"0000000200-20210101T000000.0-00000200a-00000199a-suffix",
+		}, 500, 499},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := newMindReaderPlugin(nil, test.startBlock, 0, 10, nil, nil, zap.NewNop())
+			require.NoError(t, err)
+			WithAutoResume()(p)
+
+			stores := []dstore.Store{newResumePointTestStore(t, test.localFiles...), newResumePointTestStore(t), newResumePointTestStore(t, test.storeFiles...)}
+			require.NoError(t, p.resolveAutoResume(context.Background(), stores...))
+
+			assert.Equal(t, test.expectResume, p.ResumeBlock())
+			assert.False(t, p.startGate.pass(&bstream.Block{Number: test.expectGateBefore}))
+			assert.True(t, p.startGate.pass(&bstream.Block{Number: test.expectResume}))
+		})
+	}
+}
+
+func TestMindReaderPlugin_AutoResumePartitionedStore(t *testing.T) {
+	p, err := newMindReaderPlugin(nil, 0, 0, 10, nil, nil, zap.NewNop())
+	require.NoError(t, err)
+
+	store := newResumePointTestStore(t,
+		"0000000000/0000000099-20210101T000000.0-00000099a-00000098a-suffix",
+		"0000001000/0000001042-20210101T000000.0-00001042a-00001041a-suffix",
+	)
+	require.NoError(t, p.resolveAutoResume(context.Background(), newResumePointTestStore(t), store))
+	assert.Equal(t, uint64(1043), p.ResumeBlock())
+}
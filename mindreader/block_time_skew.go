@@ -0,0 +1,94 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// DefaultFutureBlockSkew is how far into the future, compared to the local clock, a block time
+// can be before the block is reported, see `WithFutureBlockSkew`
+const DefaultFutureBlockSkew = 2 * time.Minute
+
+// futureBlockWarningInterval is the minimum time between two future block warnings
+var futureBlockWarningInterval = 30 * time.Second
+
+// FutureBlockHandler is called with every block whose time is more than the allowed skew into the
+// future, and by how much its time is ahead of the local clock
+type FutureBlockHandler func(block *bstream.Block, ahead time.Duration)
+
+// WithFutureBlockSkew reports the blocks whose time is more than `skew` ahead of the local clock,
+// usually a sign of a clock skewed node or peer: they are counted in the `future_block_count`
+// metric and logged, at most once every 30 seconds. `DefaultFutureBlockSkew` by default, a zero
+// or negative `skew` disables the check. Blocks behind the local clock are reported by the head
+// block time drift metric.
+func WithFutureBlockSkew(skew time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.futureBlockSkew = skew
+	}
+}
+
+// WithFutureBlockHandler calls `f`, from the read flow, with every block reported by the future
+// block check, see `WithFutureBlockSkew`. It must not block.
+func WithFutureBlockHandler(f FutureBlockHandler) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.futureBlockHandler = f
+	}
+}
+
+func (p *MindReaderPlugin) currentTime() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// checkBlockTime reports `block` when its time is too far into the future, see `WithFutureBlockSkew`
+func (p *MindReaderPlugin) checkBlockTime(block *bstream.Block) {
+	if p.futureBlockSkew <= 0 {
+		return
+	}
+
+	now := p.currentTime()
+	ahead := block.Time().Sub(now)
+	if ahead <= p.futureBlockSkew {
+		return
+	}
+
+	metrics.FutureBlockCount.Inc()
+	p.futureBlocksSinceWarning++
+	if p.futureBlockHandler != nil {
+		p.futureBlockHandler(block, ahead)
+	}
+
+	if now.Sub(p.lastFutureBlockWarning) < futureBlockWarningInterval {
+		return
+	}
+
+	p.zlogger.Warn("block time is in the future, the node or one of its peers may have a skewed clock",
+		zap.Uint64("block_num", block.Number),
+		zap.Time("block_time", block.Time()),
+		zap.Time("local_time", now),
+		zap.Duration("ahead", ahead),
+		zap.Duration("max_skew", p.futureBlockSkew),
+		zap.Uint64("future_blocks_since_last_warning", p.futureBlocksSinceWarning),
+	)
+	p.lastFutureBlockWarning = now
+	p.futureBlocksSinceWarning = 0
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMindReaderPlugin_FutureBlockSkew(t *testing.T) {
+	clock := mindreadertest.NewClock(time.Date(2021, 8, 2, 12, 0, 0, 0, time.UTC))
+	core, logs := observer.New(zap.WarnLevel)
+
+	type futureBlock struct {
+		num   uint64
+		ahead time.Duration
+	}
+	var reported []futureBlock
+
+	p := &MindReaderPlugin{
+		Shutter:         shutter.New(),
+		zlogger:         zap.New(core),
+		futureBlockSkew: DefaultFutureBlockSkew,
+		now:             clock.Now,
+	}
+	WithFutureBlockHandler(func(block *bstream.Block, ahead time.Duration) {
+		reported = append(reported, futureBlock{block.Number, ahead})
+	})(p)
+
+	blockAt := func(num uint64, offset time.Duration) *bstream.Block {
+		return &bstream.Block{Number: num, Timestamp: clock.Now().Add(offset)}
+	}
+
+	p.checkBlockTime(blockAt(1, time.Minute))
+	p.checkBlockTime(blockAt(2, -time.Hour))
+	assert.Empty(t, reported, "blocks within the skew, or stale, are not reported")
+
+	p.checkBlockTime(blockAt(3, 10*time.Minute))
+	p.checkBlockTime(blockAt(4, 10*time.Minute))
+	assert.Equal(t, []futureBlock{{3, 10 * time.Minute}, {4, 10 * time.Minute}}, reported)
+	require.Equal(t, 1, logs.Len(), "warnings are rate limited")
+	assert.Equal(t, uint64(3), logs.All()[0].ContextMap()["block_num"])
+
+	clock.Advance(time.Minute)
+	p.checkBlockTime(blockAt(5, 5*time.Minute))
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, uint64(2), logs.All()[1].ContextMap()["future_blocks_since_last_warning"])
+
+	WithFutureBlockSkew(0)(p)
+	p.checkBlockTime(blockAt(6, time.Hour))
+	assert.Len(t, reported, 3, "check disabled")
+}
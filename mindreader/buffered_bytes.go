@@ -0,0 +1,137 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// WithMaxBufferedBytes bounds the payload bytes of the blocks read from the node and not yet
+// stored by the archiver, reading the node waits once they are over `n`, like it waits on a full
+// blocks channel. Both limits apply, whichever is hit first. A block larger than `n` is let
+// through alone, once every block before it was stored. The blocks are only bounded by the
+// capacity of the blocks channel by default.
+func WithMaxBufferedBytes(n int64) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if n > 0 {
+			p.bufferedBytes = newByteBudget(n)
+		}
+	}
+}
+
+// byteBudget counts the payload bytes of the blocks between the produce and consume read flows,
+// the consume read flow releasing them in the order they were acquired
+type byteBudget struct {
+	max int64
+
+	lock     sync.Mutex
+	current  int64
+	sizes    []int64       // of the blocks acquired and not released yet, in order
+	released chan struct{} // closed, and replaced, every time bytes are released
+	closed   bool          // acquiring no longer waits, see `close`
+}
+
+func newByteBudget(max int64) *byteBudget {
+	return &byteBudget{max: max, released: make(chan struct{})}
+}
+
+// acquire waits for `size` bytes to fit in the budget, returning how long it waited
+func (b *byteBudget) acquire(size int64) (waited time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	start := time.Now()
+	for !b.closed && b.current > 0 && b.current+size > b.max {
+		released := b.released
+		b.lock.Unlock()
+		<-released
+		b.lock.Lock()
+		waited = time.Since(start)
+	}
+
+	b.current += size
+	b.sizes = append(b.sizes, size)
+	metrics.BufferedBlockBytes.SetUint64(uint64(b.current))
+	return waited
+}
+
+// release gives back the bytes of the oldest block acquired
+func (b *byteBudget) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.sizes) == 0 {
+		return
+	}
+	b.current -= b.sizes[0]
+	b.sizes = b.sizes[1:]
+	metrics.BufferedBlockBytes.SetUint64(uint64(b.current))
+
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// close stops `acquire` from waiting, the consume read flow no longer releasing bytes
+func (b *byteBudget) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.released)
+	}
+}
+
+func (b *byteBudget) buffered() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.current
+}
+
+// acquireBufferedBytes waits for the payload of the block to fit in `WithMaxBufferedBytes`
+func (p *MindReaderPlugin) acquireBufferedBytes(block *bstream.Block) error {
+	if p.bufferedBytes == nil {
+		return nil
+	}
+
+	size, err := blockPayloadSize(block)
+	if err != nil {
+		return fmt.Errorf("getting block %s payload: %w", block, err)
+	}
+
+	if waited := p.bufferedBytes.acquire(int64(size)); waited > 0 {
+		p.stats.sendWait.Add(waited)
+		metrics.BlocksChannelSendWait.AddFloat64(waited.Seconds())
+		if traceEnabled {
+			p.zlogger.Debug("waited for buffered bytes to be stored", zap.Uint64("block_num", block.Number), zap.Int("payload_size", size), zap.Duration("wait", waited))
+		}
+	}
+	return nil
+}
+
+// releaseBufferedBytes releases the payload bytes of the oldest block not stored yet, once
+// stored by the consume read flow
+func (p *MindReaderPlugin) releaseBufferedBytes() {
+	if p.bufferedBytes != nil {
+		p.bufferedBytes.release()
+	}
+}
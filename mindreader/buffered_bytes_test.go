@@ -0,0 +1,173 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBufferedBytesTestPlugin(t *testing.T, maxBufferedBytes int64, sizes ...int) *MindReaderPlugin {
+	t.Helper()
+
+	var blocks []*bstream.Block
+	for i, size := range sizes {
+		blocks = append(blocks, newPayloadTestBlock(t, uint64(i+1), size))
+	}
+
+	p := newReadTestPlugin(nil, &fixedBlocksConsoleReader{blocks: blocks})
+	WithMaxBufferedBytes(maxBufferedBytes)(p)
+	return p
+}
+
+// readBlocksAsync reads `count` blocks into `blocks`, the returned channel is closed once done
+func readBlocksAsync(t *testing.T, p *MindReaderPlugin, blocks chan *bstream.Block, count int) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			assert.NoError(t, p.readOneMessage(blocks))
+		}
+	}()
+	return done
+}
+
+func TestMindReaderPlugin_MaxBufferedBytesStallsProducer(t *testing.T) {
+	p := newBufferedBytesTestPlugin(t, 100, 40, 40, 40, 40)
+	blocks := make(chan *bstream.Block, 10)
+	done := readBlocksAsync(t, p, blocks, 4)
+
+	require.Eventually(t, func() bool { return len(blocks) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, blocks, 2, "producer stalled at the byte budget, well below the channel capacity")
+	assert.Equal(t, int64(80), p.Status().BufferedBytes)
+	assert.Equal(t, int64(100), p.Status().MaxBufferedBytes)
+
+	// The consumer storing a block makes room for the next one
+	<-blocks
+	p.releaseBufferedBytes()
+	require.Eventually(t, func() bool { return len(blocks) == 2 && p.Status().BufferedBytes == 80 }, time.Second, time.Millisecond)
+
+	<-blocks
+	p.releaseBufferedBytes()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("producer not resumed")
+	}
+	assert.Len(t, blocks, 2)
+
+	for len(blocks) > 0 {
+		<-blocks
+		p.releaseBufferedBytes()
+	}
+	assert.Zero(t, p.Status().BufferedBytes)
+}
+
+func TestMindReaderPlugin_MaxBufferedBytesOversizedBlock(t *testing.T) {
+	p := newBufferedBytesTestPlugin(t, 100, 150, 10)
+	blocks := make(chan *bstream.Block, 10)
+	done := readBlocksAsync(t, p, blocks, 2)
+
+	require.Eventually(t, func() bool { return len(blocks) == 1 }, time.Second, time.Millisecond, "block over the budget let through alone")
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, int64(150), p.Status().BufferedBytes)
+
+	<-blocks
+	p.releaseBufferedBytes()
+	<-done
+	assert.Equal(t, int64(10), p.Status().BufferedBytes)
+}
+
+func TestMindReaderPlugin_MaxBufferedBytesChannelCapacity(t *testing.T) {
+	p := newBufferedBytesTestPlugin(t, 1000, 10, 10, 10)
+	blocks := make(chan *bstream.Block, 2)
+	done := readBlocksAsync(t, p, blocks, 3)
+
+	require.Eventually(t, func() bool { return len(blocks) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, blocks, 2, "producer stalled on the channel capacity, well below the byte budget")
+
+	<-blocks
+	p.releaseBufferedBytes()
+	<-done
+	assert.Equal(t, int64(20), p.Status().BufferedBytes)
+}
+
+func TestByteBudget_CloseStopsWaiting(t *testing.T) {
+	budget := newByteBudget(10)
+	budget.acquire(10)
+
+	acquired := make(chan struct{})
+	go func() {
+		budget.acquire(5)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired over the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	budget.close()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("still waiting once closed")
+	}
+	assert.Equal(t, int64(15), budget.buffered())
+}
+
+func TestMindReaderPlugin_MaxBufferedBytesReleasedOnceStored(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	archiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(archiveStore, mergeArchiveStore, "never", t.TempDir(), consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer,
+		WithMaxBufferedBytes(1),
+	)
+	require.NoError(t, err)
+
+	p.Launch()
+	defer p.Stop()
+
+	generator := mindreadertest.NewBlockGenerator("buffered", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 5)) {
+		p.LogLine(line)
+	}
+
+	require.Eventually(t, func() bool {
+		status := p.Status()
+		return status.LastStoredBlockNum == 104 && status.BufferedBytes == 0
+	}, 5*time.Second, 5*time.Millisecond, "every block stored, one at a time, with a budget below their payload size")
+}
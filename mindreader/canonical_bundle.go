@@ -0,0 +1,80 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"sort"
+
+	"github.com/streamingfast/merger/bundle"
+	"go.uber.org/zap"
+)
+
+// canonicalBundle orders the one block files of a completed bundle by height, the block of the
+// canonical chain first at each height. The canonical chain is found by following the previous
+// ID links from the last block of the longest chain, which is past the bundle, so a fork crossing
+// the upper boundary is resolved by the block that completed the bundle. Forked blocks are dropped
+// when excluding them.
+func (a *Archiver) canonicalBundle(oneBlockFiles []*bundle.OneBlockFile, longestChain []*bundle.OneBlockFile) []*bundle.OneBlockFile {
+	if len(longestChain) == 0 || len(oneBlockFiles) == 0 {
+		return oneBlockFiles
+	}
+
+	last := longestChain[0]
+	previousIDs := map[string]string{}
+	for _, oneBlockFile := range longestChain {
+		previousIDs[oneBlockFile.ID] = oneBlockFile.PreviousID
+		if oneBlockFile.Num > last.Num {
+			last = oneBlockFile
+		}
+	}
+	for _, oneBlockFile := range oneBlockFiles {
+		previousIDs[oneBlockFile.ID] = oneBlockFile.PreviousID
+	}
+
+	canonical := map[string]bool{}
+	for id, known := last.ID, true; known && !canonical[id]; {
+		canonical[id] = true
+		id, known = previousIDs[id]
+	}
+
+	out := make([]*bundle.OneBlockFile, 0, len(oneBlockFiles))
+	var forked []*bundle.OneBlockFile
+	for _, oneBlockFile := range oneBlockFiles {
+		if !canonical[oneBlockFile.ID] {
+			forked = append(forked, oneBlockFile)
+			if a.excludeForkedBlocks {
+				continue
+			}
+		}
+		out = append(out, oneBlockFile)
+	}
+
+	if len(forked) == len(oneBlockFiles) {
+		a.logger.Warn("canonical chain does not link to any block of the bundle, keeping the bundle as is", zap.Stringer("last_block", last))
+		return oneBlockFiles
+	}
+
+	if len(forked) > 0 {
+		a.logger.Info("bundle contains forked blocks", zap.Int("forked_block_count", len(forked)), zap.Bool("excluded", a.excludeForkedBlocks), zap.Stringer("last_block", last))
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Num != out[j].Num {
+			return out[i].Num < out[j].Num
+		}
+		return canonical[out[i].ID] && !canonical[out[j].ID]
+	})
+	return out
+}
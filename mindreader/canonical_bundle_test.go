@@ -0,0 +1,115 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiver_CanonicalBundle(t *testing.T) {
+	generator := mindreadertest.NewBlockGenerator("canonical", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
+	fork := generator.Fork("fork")
+
+	forkedInside := func() []*bstream.Block {
+		// two blocks fork at 12 and 13, abandoned for the canonical 12, 13 and 14
+		return []*bstream.Block{
+			generator.Block(10, 10), generator.Block(11, 10),
+			generator.ForkBlock(fork, 12, 10), fork.Block(13, 10),
+			generator.Block(12, 10), generator.Block(13, 10), generator.Block(14, 10),
+			generator.Block(15, 10),
+		}
+	}
+
+	forkedAcrossBoundary := func() []*bstream.Block {
+		// 14 is seen first, but 15 completing the bundle builds on top of the fork's 14
+		return []*bstream.Block{
+			generator.Block(10, 10), generator.Block(11, 10), generator.Block(12, 10), generator.Block(13, 10),
+			generator.Block(14, 10),
+			generator.ForkBlock(fork, 14, 10), fork.Block(15, 10),
+		}
+	}
+
+	tests := []struct {
+		name          string
+		blocks        []*bstream.Block
+		excludeForked bool
+		expected      []*bstream.Block
+	}{
+		{
+			name:   "fork inside bundle",
+			blocks: forkedInside(),
+			expected: []*bstream.Block{
+				generator.Block(10, 10), generator.Block(11, 10),
+				generator.Block(12, 10), generator.ForkBlock(fork, 12, 10),
+				generator.Block(13, 10), fork.Block(13, 10),
+				generator.Block(14, 10),
+			},
+		},
+		{
+			name:          "fork inside bundle excluded",
+			blocks:        forkedInside(),
+			excludeForked: true,
+			expected:      generator.Blocks(10, 5),
+		},
+		{
+			name:   "fork across bundle boundary",
+			blocks: forkedAcrossBoundary(),
+			expected: []*bstream.Block{
+				generator.Block(10, 10), generator.Block(11, 10), generator.Block(12, 10), generator.Block(13, 10),
+				generator.ForkBlock(fork, 14, 10), generator.Block(14, 10),
+			},
+		},
+		{
+			name:          "fork across bundle boundary excluded",
+			blocks:        forkedAcrossBoundary(),
+			excludeForked: true,
+			expected: []*bstream.Block{
+				generator.Block(10, 10), generator.Block(11, 10), generator.Block(12, 10), generator.Block(13, 10),
+				generator.ForkBlock(fork, 14, 10),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			io := mindreadertest.NewRecordingArchiverIO()
+			archiver := NewArchiver(5, io, "suffix", alwaysMergeThreshold, testLogger, testTracer, WithExcludeForkedBlocks(test.excludeForked))
+
+			require.NoError(t, mindreadertest.StoreBlocks(context.Background(), archiver, test.blocks))
+
+			result := io.Result()
+			require.Len(t, result.MergedBundles, 1)
+			assert.Equal(t, uint64(10), result.MergedBundles[0].InclusiveLowerBlock)
+			assert.Equal(t, blockFileNames(test.expected), result.MergedBundles[0].OneBlockFiles)
+			assert.Len(t, result.MergeableOneBlockFiles, len(test.blocks), "forked blocks are still written as mergeable one block files")
+		})
+	}
+}
+
+func blockFileNames(blocks []*bstream.Block) (out []string) {
+	for _, blk := range blocks {
+		out = append(out, bundle.MustNewOneBlockFile(bundle.BlockFileNameWithSuffix(blk, "suffix")).CanonicalName)
+	}
+	return
+}
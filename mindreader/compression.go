@@ -0,0 +1,75 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+
+	"github.com/streamingfast/dstore"
+)
+
+// DefaultOneBlockFileCompression is the compression of the one block files when none is set
+// with `WithOneBlockFileCompression`
+const DefaultOneBlockFileCompression = "zstd"
+
+// blockFileFormat is how block files are stored: the extension of their name and the dstore
+// compression of their content
+type blockFileFormat struct {
+	extension       string
+	compressionType string
+}
+
+var blockFileFormats = map[string]blockFileFormat{
+	"zstd": {extension: "dbin.zst", compressionType: "zstd"},
+	"gzip": {extension: "dbin.gz", compressionType: "gzip"},
+	"none": {extension: "dbin", compressionType: ""},
+}
+
+// mergedBlocksFileFormat is the format of the merged blocks bundles, always zstd
+var mergedBlocksFileFormat = blockFileFormats["zstd"]
+
+func blockFileFormatOf(compression string) (blockFileFormat, error) {
+	format, found := blockFileFormats[compression]
+	if !found {
+		return blockFileFormat{}, fmt.Errorf("invalid one block file compression %q, must be one of zstd, gzip or none", compression)
+	}
+	return format, nil
+}
+
+// localStore returns the store of the working directory `dir`, files are compressed when written
+func (f blockFileFormat) localStore(dir string) (dstore.Store, error) {
+	return dstore.NewStore(dir, f.extension, f.compressionType, false)
+}
+
+// archiveStore returns the archive store of `url`, files are uploaded as compressed in the
+// working directory
+func (f blockFileFormat) archiveStore(url string) (dstore.Store, error) {
+	return dstore.NewStore(url, f.extension, "", false)
+}
+
+// WithOneBlockFileCompression sets the compression of the one block files, written compressed
+// to the working directory and uploaded as is, with the extension of the compression: `zstd`
+// (`.dbin.zst`, the default), `gzip` (`.dbin.gz`) or `none` (`.dbin`). The mergeable one block
+// files sent as one block files when not merging are compressed the same way, merged blocks
+// bundles are always zstd. An archive store given to `NewMindReaderPluginWithStores` must use the
+// extension of the compression. An invalid compression fails the creation of the plugin.
+//
+// The one block files of the working directory written with another compression are
+// quarantined on start, upload them before changing the compression.
+func WithOneBlockFileCompression(compression string) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.oneBlockFileCompression = compression
+	}
+}
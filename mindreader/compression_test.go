@@ -0,0 +1,144 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readCompressedBlockFiles decompresses the files with `extension` of `dir` and returns the
+// numbers of the blocks they hold
+func readCompressedBlockFiles(t *testing.T, dir, extension, compression string) (out []uint64) {
+	t.Helper()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		require.True(t, strings.HasSuffix(path, "."+extension), "file %q has extension %q", path, extension)
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		var reader io.Reader = f
+		switch compression {
+		case "gzip":
+			gz, err := gzip.NewReader(f)
+			require.NoError(t, err)
+			defer gz.Close()
+			reader = gz
+		case "zstd":
+			zst, err := zstd.NewReader(f)
+			require.NoError(t, err)
+			defer zst.Close()
+			reader = zst
+		}
+
+		blockReader, err := dbinReaderFactory.New(reader)
+		require.NoError(t, err)
+		for {
+			block, err := blockReader.Read()
+			if block != nil {
+				out = append(out, block.Number)
+			}
+			if err == io.EOF {
+				return nil
+			}
+			require.NoError(t, err)
+		}
+	})
+	require.NoError(t, err)
+
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func blockNums(from, to uint64) (out []uint64) {
+	for num := from; num <= to; num++ {
+		out = append(out, num)
+	}
+	return
+}
+
+func TestMindReaderPlugin_OneBlockFileCompression(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+	defer func(setter bstream.BlockPayloadSetter) { bstream.GetBlockPayloadSetter = setter }(bstream.GetBlockPayloadSetter)
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+
+	tests := []struct {
+		compression    string
+		extension      string
+		mergeThreshold string
+	}{
+		{"zstd", "dbin.zst", "never"},
+		{"gzip", "dbin.gz", "never"},
+		{"none", "dbin", "never"},
+		{"gzip", "dbin.gz", "always"}, // the partial bundle is sent as one block files on the stop block
+	}
+
+	for _, test := range tests {
+		t.Run(test.compression+" merge "+test.mergeThreshold, func(t *testing.T) {
+			oneBlocksDir, mergedDir := t.TempDir(), t.TempDir()
+			oneBlocks, err := dstore.NewStore(oneBlocksDir, test.extension, "", false)
+			require.NoError(t, err)
+			mergedBlocks, err := dstore.NewStore(mergedDir, "dbin.zst", "", false)
+			require.NoError(t, err)
+
+			consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+				return mindreadertest.NewConsoleReader(ctx.Lines), nil
+			}
+			p, err := NewMindReaderPluginWithStores(oneBlocks, mergedBlocks, test.mergeThreshold, t.TempDir(), consoleReaderFactory, 0, 150, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer, WithOneBlockFileCompression(test.compression))
+			require.NoError(t, err)
+
+			p.Launch()
+			generator := mindreadertest.NewBlockGenerator("compression", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+			for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 160)) {
+				p.LogLine(line)
+			}
+			select {
+			case <-p.Terminating():
+			case <-time.After(5 * time.Second):
+				t.Fatal("plugin not shut down")
+			}
+			p.Stop()
+
+			assert.Equal(t, blockNums(100, 150), readCompressedBlockFiles(t, oneBlocksDir, test.extension, test.compression))
+			assert.Empty(t, readCompressedBlockFiles(t, mergedDir, "dbin.zst", "zstd"))
+		})
+	}
+}
+
+func TestMindReaderPlugin_InvalidOneBlockFileCompression(t *testing.T) {
+	_, err := NewMindReaderPluginWithStores(dstore.NewMockStore(nil), dstore.NewMockStore(nil), "never", t.TempDir(), nil, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer, WithOneBlockFileCompression("lz4"))
+	assert.EqualError(t, err, `invalid one block file compression "lz4", must be one of zstd, gzip or none`)
+}
@@ -17,8 +17,11 @@ package mindreader
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/google/renameio"
 	"go.uber.org/zap"
@@ -28,13 +31,57 @@ type ContinuityChecker interface {
 	IsLocked() bool
 	Reset()
 	Write(lastSeenBlockNum uint64) error
+	Flush() error
 }
 
-func NewContinuityChecker(filePath string, zlogger *zap.Logger) (*continuityChecker, error) {
+type ContinuityCheckerOption func(cc *continuityChecker)
+
+// WithFlushEveryBlocks persists the highest seen block to disk at most every `count` blocks
+// instead of on every block (the default). After a crash, up to `count - 1` blocks may
+// have been seen without being persisted, the checker accepts seeing them again and also
+// accepts resuming right after them, meaning a hole of at most `count - 1` blocks right
+// after a crash cannot be detected.
+func WithFlushEveryBlocks(count uint64) ContinuityCheckerOption {
+	return func(cc *continuityChecker) {
+		if count == 0 {
+			count = 1
+		}
+		cc.flushEveryBlocks = count
+	}
+}
+
+// WithFlushInterval additionally persists the highest seen block when `interval` elapsed
+// since the last flush, it does not widen the crash window defined by `WithFlushEveryBlocks`.
+func WithFlushInterval(interval time.Duration) ContinuityCheckerOption {
+	return func(cc *continuityChecker) {
+		cc.flushInterval = interval
+	}
+}
+
+// WithSeenBlocksWindow tracks which of the last `blocks` block numbers, up to the highest seen
+// block, were written, persisting them with the highest seen block (`blocks / 8` bytes written on
+// every flush). Writing a block number of the window again fails with `ErrDuplicate`, instead of
+// being accepted like any block below the highest seen one, catching a node replaying a range it
+// already produced. Blocks below the window are still accepted. Chains emitting a block number
+// again on forks cannot use it.
+func WithSeenBlocksWindow(blocks uint64) ContinuityCheckerOption {
+	return func(cc *continuityChecker) {
+		if blocks > 0 {
+			cc.seen = newSeenBlocks(blocks)
+		}
+	}
+}
+
+func NewContinuityChecker(filePath string, zlogger *zap.Logger, options ...ContinuityCheckerOption) (*continuityChecker, error) {
 	cc := &continuityChecker{
-		filePath: filePath,
-		zlogger:  zlogger,
+		filePath:         filePath,
+		zlogger:          zlogger,
+		flushEveryBlocks: 1,
 	}
+	for _, opt := range options {
+		opt(cc)
+	}
+
 	err := cc.load()
 	if err != nil {
 		return nil, err
@@ -44,20 +91,101 @@ func NewContinuityChecker(filePath string, zlogger *zap.Logger) (*continuityChec
 }
 
 type continuityChecker struct {
+	lock sync.Mutex
+
 	highestSeenBlock uint64
 	locked           bool
 	filePath         string
 	zlogger          *zap.Logger
+
+	flushEveryBlocks uint64
+	flushInterval    time.Duration
+	unflushedBlocks  uint64
+	lastFlush        time.Time
+
+	// recovering is true until the first block is written after loading a non-zero
+	// highest seen block from disk, the allowed gap accounts for unflushed blocks
+	recovering bool
+
+	seen *seenBlocks // nil unless `WithSeenBlocksWindow`
+}
+
+// seenBlocks is a ring of the last `window` block numbers up to the highest seen block, the bit
+// of block `num` being `num % window`
+type seenBlocks struct {
+	window uint64
+	bits   []byte
+}
+
+func newSeenBlocks(window uint64) *seenBlocks {
+	return &seenBlocks{window: window, bits: make([]byte, (window+7)/8)}
+}
+
+func (s *seenBlocks) has(num uint64) bool {
+	bit := num % s.window
+	return s.bits[bit/8]&(1<<(bit%8)) != 0
+}
+
+func (s *seenBlocks) set(num uint64) {
+	bit := num % s.window
+	s.bits[bit/8] |= 1 << (bit % 8)
+}
+
+func (s *seenBlocks) unset(num uint64) {
+	bit := num % s.window
+	s.bits[bit/8] &^= 1 << (bit % 8)
+}
+
+// advance moves the window from `highest` to `num`, the blocks skipped in between being unknown
+func (s *seenBlocks) advance(highest, num uint64) {
+	if num-highest >= s.window {
+		s.reset()
+	} else {
+		for skipped := highest + 1; skipped < num; skipped++ {
+			s.unset(skipped)
+		}
+	}
+	s.set(num)
+}
+
+// inWindow reports if `num`, at most `highest`, is one of the block numbers tracked
+func (s *seenBlocks) inWindow(highest, num uint64) bool {
+	return highest-num < s.window
+}
+
+func (s *seenBlocks) reset() {
+	for i := range s.bits {
+		s.bits[i] = 0
+	}
 }
 
 func (cc *continuityChecker) IsLocked() bool {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
 	return cc.locked
 }
 
+// HighestSeenBlock returns the highest block number written to the checker, 0 if none
+func (cc *continuityChecker) HighestSeenBlock() uint64 {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	return cc.highestSeenBlock
+}
+
 func (cc *continuityChecker) Reset() {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
 	cc.zlogger.Info("resetting continuity checker")
 	cc.highestSeenBlock = 0
 	cc.locked = false
+	cc.unflushedBlocks = 0
+	cc.recovering = false
+	if cc.seen != nil {
+		cc.seen.reset()
+	}
 
 	err := os.Remove(cc.filePath)
 	if err != nil && !os.IsNotExist(err) {
@@ -84,7 +212,69 @@ func (cc *continuityChecker) load() error {
 		}
 		return nil
 	}
-	cc.highestSeenBlock = binary.LittleEndian.Uint64(b)
+	if err := cc.decode(b); err != nil {
+		return fmt.Errorf("continuity checker file %q is corrupt, remove it to start over: %w", cc.filePath, err)
+	}
+	cc.recovering = cc.highestSeenBlock != 0
+	cc.lastFlush = time.Now()
+	return nil
+}
+
+// The continuity file holds the highest seen block, followed, with a seen blocks window, by the
+// window size, its bitmap and a CRC-32 of everything before it, all little endian
+const (
+	continuityFileHighestSize = 8
+	continuityFileHeaderSize  = 16
+	continuityFileCRCSize     = 4
+)
+
+func (cc *continuityChecker) encode() []byte {
+	if cc.seen == nil {
+		b := make([]byte, continuityFileHighestSize)
+		binary.LittleEndian.PutUint64(b, cc.highestSeenBlock)
+		return b
+	}
+
+	b := make([]byte, continuityFileHeaderSize, continuityFileHeaderSize+len(cc.seen.bits)+continuityFileCRCSize)
+	binary.LittleEndian.PutUint64(b, cc.highestSeenBlock)
+	binary.LittleEndian.PutUint64(b[8:], cc.seen.window)
+	b = append(b, cc.seen.bits...)
+	checksum := make([]byte, continuityFileCRCSize)
+	binary.LittleEndian.PutUint32(checksum, crc32.ChecksumIEEE(b))
+	return append(b, checksum...)
+}
+
+func (cc *continuityChecker) decode(b []byte) error {
+	if len(b) == continuityFileHighestSize {
+		cc.highestSeenBlock = binary.LittleEndian.Uint64(b)
+		if cc.seen != nil && cc.highestSeenBlock != 0 {
+			cc.zlogger.Info("continuity file has no seen blocks window, tracking seen blocks from now on", zap.Uint64("highest_seen_block", cc.highestSeenBlock))
+		}
+		return nil
+	}
+
+	if len(b) < continuityFileHeaderSize+continuityFileCRCSize {
+		return fmt.Errorf("unexpected size of %d bytes", len(b))
+	}
+	content, checksum := b[:len(b)-continuityFileCRCSize], binary.LittleEndian.Uint32(b[len(b)-continuityFileCRCSize:])
+	if crc32.ChecksumIEEE(content) != checksum {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	window := binary.LittleEndian.Uint64(content[8:])
+	bits := content[continuityFileHeaderSize:]
+	if window == 0 || uint64(len(bits)) != (window+7)/8 {
+		return fmt.Errorf("seen blocks window of %d blocks does not match its bitmap of %d bytes", window, len(bits))
+	}
+
+	cc.highestSeenBlock = binary.LittleEndian.Uint64(content)
+	switch {
+	case cc.seen == nil:
+	case cc.seen.window != window:
+		cc.zlogger.Warn("seen blocks window changed, tracking seen blocks from now on", zap.Uint64("previous_window", window), zap.Uint64("window", cc.seen.window))
+	default:
+		copy(cc.seen.bits, bits)
+	}
 	return nil
 }
 
@@ -101,24 +291,91 @@ func (cc *continuityChecker) setLock() {
 
 // Write checks that the either:
 // val =< highestSeenBlock OR val == highestSeenBlock+1 OR highestSeenBlock == 0
-// it then updates the highestSeenBlock value if it needs to changed (on the cc and on disk)
+// it then updates the highestSeenBlock value if it needs to changed (on the cc and, when
+// a flush is due, on disk)
 // In case the value does not match these 3 conditions, (that block would create a hole
 // in the continuity), the checker becomes locked, a lock file is written to disk, and an error
-// is returned.
+// wrapping `ErrHole` is returned. With a seen blocks window, a block of the window written again
+// locks the checker the same way, returning an error wrapping `ErrDuplicate`.
 func (cc *continuityChecker) Write(val uint64) error {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
 	if cc.locked {
 		return fmt.Errorf("ontinuity checker already locked")
 	}
 	if val <= cc.highestSeenBlock {
-		return nil
+		return cc.writeSeen(val)
 	}
-	if cc.highestSeenBlock != 0 && val > cc.highestSeenBlock+1 {
+
+	allowedGap := uint64(1)
+	if cc.recovering {
+		allowedGap = cc.flushEveryBlocks
+	}
+	if cc.highestSeenBlock != 0 && val > cc.highestSeenBlock+allowedGap {
+		if err := cc.flush(); err != nil {
+			cc.zlogger.Error("cannot flush continuity file", zap.String("file_path", cc.filePath), zap.Error(err))
+		}
 		cc.setLock()
-		return fmt.Errorf("ontinuity checker failed: block %d would creates a hole after highest seen block: %d", val, cc.highestSeenBlock)
+		return fmt.Errorf("ontinuity checker failed: block %d would creates a hole after highest seen block: %d: %w", val, cc.highestSeenBlock, ErrHole)
 	}
+	if cc.seen != nil {
+		cc.seen.advance(cc.highestSeenBlock, val)
+	}
+	cc.recovering = false
 	cc.highestSeenBlock = val
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, uint64(val))
-	cc.zlogger.Debug("writing through continuity checker", zap.Uint64("highest_seen_block", cc.highestSeenBlock))
-	return renameio.WriteFile(cc.filePath, b, os.FileMode(0644))
+	return cc.blockWritten()
+}
+
+// writeSeen records `val`, not above the highest seen block, in the seen blocks window, failing
+// if it was already written
+func (cc *continuityChecker) writeSeen(val uint64) error {
+	if cc.seen == nil || cc.highestSeenBlock == 0 || !cc.seen.inWindow(cc.highestSeenBlock, val) {
+		return nil
+	}
+
+	if cc.seen.has(val) {
+		if err := cc.flush(); err != nil {
+			cc.zlogger.Error("cannot flush continuity file", zap.String("file_path", cc.filePath), zap.Error(err))
+		}
+		cc.setLock()
+		return fmt.Errorf("ontinuity checker failed: block %d was already written, highest seen block: %d: %w", val, cc.highestSeenBlock, ErrDuplicate)
+	}
+
+	// an unknown block, the node was restarted before it was flushed
+	cc.seen.set(val)
+	return cc.blockWritten()
+}
+
+func (cc *continuityChecker) blockWritten() error {
+	cc.unflushedBlocks++
+
+	if cc.unflushedBlocks >= cc.flushEveryBlocks || (cc.flushInterval > 0 && time.Since(cc.lastFlush) >= cc.flushInterval) {
+		return cc.flush()
+	}
+	return nil
+}
+
+// Flush persists the highest seen block to disk if it changed since the last flush
+func (cc *continuityChecker) Flush() error {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	return cc.flush()
+}
+
+func (cc *continuityChecker) flush() error {
+	if cc.unflushedBlocks == 0 {
+		return nil
+	}
+
+	b := cc.encode()
+	cc.zlogger.Debug("writing through continuity checker", zap.Uint64("highest_seen_block", cc.highestSeenBlock), zap.Uint64("unflushed_blocks", cc.unflushedBlocks))
+	if err := renameio.WriteFile(cc.filePath, b, os.FileMode(0644)); err != nil {
+		return fmt.Errorf("writing continuity file: %w", err)
+	}
+
+	cc.unflushedBlocks = 0
+	cc.lastFlush = time.Now()
+	return nil
 }
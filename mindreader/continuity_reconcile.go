@@ -0,0 +1,141 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger/bundle"
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+// Reconcile rewrites the state of the checker from the one block files of `store` named with
+// `suffix`: the highest seen block becomes the highest block contiguous to the lowest one found,
+// 0 without any, and the checker is unlocked. The report lists the holes found after the lowest
+// block. Every file of the store is listed, it is meant to repair the checker in maintenance,
+// after a continuity failure.
+func (cc *continuityChecker) Reconcile(ctx context.Context, store dstore.Store, suffix string) (*nodeManager.ContinuityReconciliation, error) {
+	seen := map[uint64]bool{}
+	err := store.Walk(ctx, "", func(filename string) error {
+		num, _, _, _, _, _, err := bundle.ParseFilename(path.Base(filename))
+		if err != nil || oneBlockFileSuffix(path.Base(filename)) != suffix {
+			return nil
+		}
+		seen[num] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing one block files: %w", err)
+	}
+
+	nums := make([]uint64, 0, len(seen))
+	for num := range seen {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	report := &nodeManager.ContinuityReconciliation{Time: time.Now(), Suffix: suffix, BlockCount: len(nums)}
+	if len(nums) > 0 {
+		report.LowestBlock, report.HighestBlock = nums[0], nums[len(nums)-1]
+		report.HighestContiguousBlock = nums[0]
+		for i, num := range nums[1:] {
+			previous := nums[i]
+			if num != previous+1 {
+				report.Holes = append(report.Holes, nodeManager.BlockHole{Start: previous + 1, Stop: num - 1})
+			}
+			if len(report.Holes) == 0 {
+				report.HighestContiguousBlock = num
+			}
+		}
+	}
+
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	report.PreviousHighestBlock = cc.highestSeenBlock
+	cc.highestSeenBlock = report.HighestContiguousBlock
+	cc.locked = false
+	cc.recovering = false
+	if cc.seen != nil {
+		cc.seen.reset()
+		for _, num := range nums {
+			if num <= cc.highestSeenBlock && cc.seen.inWindow(cc.highestSeenBlock, num) {
+				cc.seen.set(num)
+			}
+		}
+	}
+
+	if err := os.Remove(cc.lockFilePath()); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing lock file: %w", err)
+	}
+	if cc.highestSeenBlock == 0 {
+		if err := os.Remove(cc.filePath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("removing continuity file: %w", err)
+		}
+		cc.unflushedBlocks = 0
+	} else {
+		cc.unflushedBlocks = 1 // forces the flush of the new state
+		if err := cc.flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	cc.zlogger.Info("reconciled continuity checker with one block files",
+		zap.Uint64("previous_highest_block", report.PreviousHighestBlock),
+		zap.Uint64("highest_contiguous_block", report.HighestContiguousBlock),
+		zap.Int("holes", len(report.Holes)),
+	)
+	return report, nil
+}
+
+// oneBlockFileSuffix returns the suffix ending a one block file name, empty for names without one
+func oneBlockFileSuffix(filename string) string {
+	parts := strings.Split(filename, "-")
+	if len(parts) != 6 {
+		return ""
+	}
+	return parts[5]
+}
+
+// ReconcileContinuityChecker reconciles the continuity checker with the one block files of the
+// archive store written with the plugin's suffix, see `continuityChecker.Reconcile`. Blocks are
+// accepted again afterwards, from the block following the highest contiguous one.
+func (p *MindReaderPlugin) ReconcileContinuityChecker(ctx context.Context) (*nodeManager.ContinuityReconciliation, error) {
+	if p.continuityChecker == nil {
+		return nil, fmt.Errorf("no continuity checker")
+	}
+
+	reconciler, ok := p.continuityChecker.(interface {
+		Reconcile(ctx context.Context, store dstore.Store, suffix string) (*nodeManager.ContinuityReconciliation, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("continuity checker cannot be reconciled")
+	}
+
+	report, err := reconciler.Reconcile(ctx, p.oneBlockFileUploader.destinationStore, p.archiver.oneblockSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("reconciling continuity checker: %w", err)
+	}
+	p.continuityFailed.Store(false)
+	return report, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger/bundle"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContinuityChecker_Reconcile(t *testing.T) {
+	tests := []struct {
+		name                   string
+		blocks                 []uint64
+		expectedHighest        uint64
+		expectedHoles          []nodeManager.BlockHole
+		expectedNextAcceptable uint64
+	}{
+		{"contiguous", []uint64{10, 11, 12, 13, 14}, 14, nil, 15},
+		{"one hole", []uint64{10, 11, 14, 15}, 11, []nodeManager.BlockHole{{Start: 12, Stop: 13}}, 12},
+		{"empty store", nil, 0, nil, 42},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := dstore.NewDBinStore(t.TempDir())
+			require.NoError(t, err)
+
+			generator := mindreadertest.NewBlockGenerator("reconcile", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+			for _, num := range test.blocks {
+				block := generator.Block(num, num-1)
+				require.NoError(t, store.WriteObject(context.Background(), bundle.BlockFileNameWithSuffix(block, "suffix"), bytes.NewReader(nil)))
+			}
+			// Written by another mindreader
+			require.NoError(t, store.WriteObject(context.Background(), bundle.BlockFileNameWithSuffix(generator.Block(100, 99), "other"), bytes.NewReader(nil)))
+
+			filePath := filepath.Join(t.TempDir(), "continuity")
+			cc, err := NewContinuityChecker(filePath, testLogger)
+			require.NoError(t, err)
+			require.NoError(t, cc.Write(20))
+			require.Error(t, cc.Write(30))
+			require.True(t, cc.IsLocked())
+
+			report, err := cc.Reconcile(context.Background(), store, "suffix")
+			require.NoError(t, err)
+			assert.Equal(t, "suffix", report.Suffix)
+			assert.EqualValues(t, 20, report.PreviousHighestBlock)
+			assert.Equal(t, test.expectedHighest, report.HighestContiguousBlock)
+			assert.Equal(t, len(test.blocks), report.BlockCount)
+			assert.Equal(t, test.expectedHoles, report.Holes)
+			assert.False(t, cc.IsLocked())
+			assert.Equal(t, test.expectedHighest, cc.HighestSeenBlock())
+
+			// The reconciled state is persisted
+			reloaded, err := NewContinuityChecker(filePath, testLogger)
+			require.NoError(t, err)
+			assert.False(t, reloaded.IsLocked())
+			assert.Equal(t, test.expectedHighest, reloaded.HighestSeenBlock())
+			assert.NoError(t, reloaded.Write(test.expectedNextAcceptable))
+		})
+	}
+}
+
+func TestMindReaderPlugin_ReconcileContinuityChecker(t *testing.T) {
+	store, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+
+	generator := mindreadertest.NewBlockGenerator("reconcile", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, num := range []uint64{5, 6, 7} {
+		require.NoError(t, store.WriteObject(context.Background(), bundle.BlockFileNameWithSuffix(generator.Block(num, num-1), "suffix"), bytes.NewReader(nil)))
+	}
+
+	withoutChecker, err := NewMindReaderPluginWithStores(store, dstore.NewMockStore(nil), "never", t.TempDir(), nil, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer)
+	require.NoError(t, err)
+	_, err = withoutChecker.ReconcileContinuityChecker(context.Background())
+	assert.Error(t, err)
+
+	cc, err := NewContinuityChecker(filepath.Join(t.TempDir(), "continuity"), testLogger)
+	require.NoError(t, err)
+	p, err := NewMindReaderPluginWithStores(store, dstore.NewMockStore(nil), "never", t.TempDir(), nil, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer, WithContinuityChecker(cc))
+	require.NoError(t, err)
+	p.continuityFailed.Store(true)
+
+	report, err := p.ReconcileContinuityChecker(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, report.HighestContiguousBlock)
+	assert.EqualValues(t, 7, p.HighestContinuousBlockNum())
+	assert.False(t, p.continuityFailed.Load())
+}
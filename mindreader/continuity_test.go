@@ -18,8 +18,12 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/shutter"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -55,3 +59,268 @@ func TestContinuityChecker(t *testing.T) {
 	assert.Error(t, cc2.Write(10))
 
 }
+
+func TestContinuityChecker_BatchedCrashWindow(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(100))
+	require.NoError(t, err)
+	for num := uint64(1); num <= 250; num++ {
+		require.NoError(t, cc.Write(num))
+	}
+
+	// Crash: blocks 201 to 250 were never flushed
+	restarted, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(100))
+	require.NoError(t, err)
+	assert.EqualValues(t, 200, restarted.highestSeenBlock)
+	assert.NoError(t, restarted.Write(251), "resuming right after the last block seen before the crash")
+	assert.NoError(t, restarted.Write(252))
+	assert.Error(t, restarted.Write(254), "window only applies to the first block after restart")
+	assert.True(t, restarted.IsLocked())
+
+	restarted.Reset()
+	require.NoError(t, restarted.Write(10))
+	require.NoError(t, restarted.Write(11))
+	require.NoError(t, restarted.Flush())
+
+	restartedAgain, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(100))
+	require.NoError(t, err)
+	assert.Error(t, restartedAgain.Write(112), "a hole larger than the window is detected after restart")
+}
+
+func TestContinuityChecker_Flush(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(100))
+	require.NoError(t, err)
+	require.NoError(t, cc.Write(10))
+	require.NoError(t, cc.Write(11))
+	require.NoError(t, cc.Flush())
+
+	restarted, err := NewContinuityChecker(tmp, testLogger)
+	require.NoError(t, err)
+	assert.EqualValues(t, 11, restarted.highestSeenBlock)
+}
+
+func TestContinuityChecker_FlushInterval(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(100), WithFlushInterval(time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, cc.Write(10))
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, cc.Write(11))
+
+	restarted, err := NewContinuityChecker(tmp, testLogger)
+	require.NoError(t, err)
+	assert.EqualValues(t, 11, restarted.highestSeenBlock)
+}
+
+func TestContinuityChecker_ErrorTypes(t *testing.T) {
+	cc, err := NewContinuityChecker(filepath.Join(t.TempDir(), "continuity"), testLogger)
+	require.NoError(t, err)
+	require.NoError(t, cc.Write(10))
+	require.NoError(t, cc.Write(9), "blocks below the highest seen block are accepted without a window")
+	assert.ErrorIs(t, cc.Write(12), ErrHole)
+
+	windowed, err := NewContinuityChecker(filepath.Join(t.TempDir(), "continuity"), testLogger, WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	require.NoError(t, windowed.Write(10))
+	require.NoError(t, windowed.Write(11))
+	err = windowed.Write(10)
+	assert.ErrorIs(t, err, ErrDuplicate)
+	assert.NotErrorIs(t, err, ErrHole)
+	assert.True(t, windowed.IsLocked())
+}
+
+func TestContinuityChecker_SeenBlocksWindow(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	for num := uint64(1000); num <= 1200; num++ {
+		require.NoError(t, cc.Write(num))
+	}
+	require.NoError(t, cc.Write(1050), "below the window")
+
+	restarted, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1200, restarted.highestSeenBlock)
+	assert.ErrorIs(t, restarted.Write(1150), ErrDuplicate, "replayed block detected after a restart")
+
+	restarted.Reset()
+	require.NoError(t, restarted.Write(1150), "reset clears the seen blocks")
+	require.NoError(t, restarted.Write(1151))
+	require.NoError(t, restarted.Write(1152))
+
+	reloaded, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	assert.NoError(t, reloaded.Write(1149), "not written since the reset")
+	assert.ErrorIs(t, reloaded.Write(1151), ErrDuplicate)
+}
+
+func TestContinuityChecker_SeenBlocksWindowHoleAcrossRestart(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(1000))
+	require.NoError(t, err)
+	for num := uint64(1); num <= 20; num++ {
+		require.NoError(t, cc.Write(num))
+	}
+
+	restarted, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(1000))
+	require.NoError(t, err)
+	require.NoError(t, restarted.Write(21))
+	assert.ErrorIs(t, restarted.Write(23), ErrHole)
+	assert.True(t, restarted.IsLocked())
+
+	restartedAgain, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(1000))
+	require.NoError(t, err)
+	assert.True(t, restartedAgain.IsLocked())
+	assert.EqualValues(t, 21, restartedAgain.highestSeenBlock)
+}
+
+func TestContinuityChecker_SeenBlocksWindowCrashWindow(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(10), WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	for num := uint64(1); num <= 25; num++ {
+		require.NoError(t, cc.Write(num))
+	}
+
+	// Crash: blocks 21 to 25 were never flushed, the node replays them
+	restarted, err := NewContinuityChecker(tmp, testLogger, WithFlushEveryBlocks(10), WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	for num := uint64(21); num <= 25; num++ {
+		require.NoError(t, restarted.Write(num))
+	}
+	assert.ErrorIs(t, restarted.Write(20), ErrDuplicate, "flushed before the crash")
+}
+
+func TestContinuityChecker_FileFormats(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	legacy, err := NewContinuityChecker(tmp, testLogger)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Write(10))
+
+	windowed, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, windowed.highestSeenBlock, "file without window")
+	require.NoError(t, windowed.Write(10), "seen blocks unknown before the window was enabled")
+	require.NoError(t, windowed.Write(11))
+
+	resized, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(200))
+	require.NoError(t, err)
+	assert.EqualValues(t, 11, resized.highestSeenBlock)
+	assert.NoError(t, resized.Write(11), "seen blocks discarded when the window changed")
+
+	withoutWindow, err := NewContinuityChecker(tmp, testLogger)
+	require.NoError(t, err)
+	assert.EqualValues(t, 11, withoutWindow.highestSeenBlock, "file with a window")
+}
+
+func TestContinuityChecker_CorruptFile(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "continuity")
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(100))
+	require.NoError(t, err)
+	require.NoError(t, cc.Write(10))
+
+	content, err := os.ReadFile(tmp)
+	require.NoError(t, err)
+
+	flipped := append([]byte{}, content...)
+	flipped[20] ^= 0xff
+	truncated := content[:len(content)-1]
+
+	for name, corrupt := range map[string][]byte{
+		"flipped bit": flipped,
+		"truncated":   truncated,
+		"too short":   content[:5],
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(tmp, corrupt, 0644))
+
+			_, err := NewContinuityChecker(tmp, testLogger, WithSeenBlocksWindow(100))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "is corrupt")
+
+			_, err = NewContinuityChecker(tmp, testLogger)
+			assert.Error(t, err, "corrupt without a window too")
+		})
+	}
+}
+
+func TestMindReaderPlugin_ContinuityBreakHandler(t *testing.T) {
+	dir := t.TempDir()
+	cc, err := NewContinuityChecker(filepath.Join(dir, "continuity"), testLogger)
+	require.NoError(t, err)
+
+	type continuityBreak struct {
+		expected, got uint64
+		dir           string
+	}
+	breaks := make(chan continuityBreak, 10)
+	maintenances := make(chan string, 10)
+
+	p := &MindReaderPlugin{
+		Shutter:           shutter.New(),
+		zlogger:           testLogger,
+		continuityChecker: cc,
+		workingDirectory:  dir,
+		maintenanceRequester: func(reason string, source string) error {
+			maintenances <- source
+			return nil
+		},
+	}
+	WithContinuityBreakHandler(func(expected, got uint64, workingDirectory string) {
+		breaks <- continuityBreak{expected, got, workingDirectory}
+	})(p)
+
+	for _, num := range []uint64{10, 11, 12, 14} {
+		p.checkContinuity(&bstream.Block{Number: num})
+	}
+	for i := uint64(0); i < 500; i++ {
+		p.checkContinuity(&bstream.Block{Number: 20 + 2*i})
+	}
+
+	select {
+	case b := <-breaks:
+		assert.Equal(t, continuityBreak{13, 14, dir}, b)
+	case <-time.After(time.Second):
+		t.Fatal("continuity break handler not called")
+	}
+	<-maintenances
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, breaks, "handler called once per break")
+	assert.Empty(t, maintenances)
+
+	p.ResetContinuityChecker()
+	p.checkContinuity(&bstream.Block{Number: 100})
+	p.checkContinuity(&bstream.Block{Number: 102})
+	select {
+	case b := <-breaks:
+		assert.Equal(t, continuityBreak{101, 102, dir}, b, "handler called again after a reset")
+	case <-time.After(time.Second):
+		t.Fatal("continuity break handler not called after reset")
+	}
+}
+
+func BenchmarkContinuityChecker_Write(b *testing.B) {
+	for _, flushEveryBlocks := range []uint64{1, 100} {
+		b.Run(fmt.Sprintf("flush_every_%d", flushEveryBlocks), func(b *testing.B) {
+			cc, err := NewContinuityChecker(filepath.Join(b.TempDir(), "continuity"), testLogger, WithFlushEveryBlocks(flushEveryBlocks))
+			require.NoError(b, err)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := cc.Write(uint64(i + 1)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
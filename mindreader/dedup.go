@@ -0,0 +1,201 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// DefaultDedupWindow is the number of archived blocks remembered to skip blocks re-read after a
+// restart of the node, see `WithDedupWindow`
+const DefaultDedupWindow = 200
+
+const dedupFilename = "archived-blocks.log"
+
+// WithDedupWindow changes the number of archived blocks remembered, in the working directory, to
+// skip blocks the node replays when restarted instead of archiving them twice, 0 disables it.
+// A block is skipped when a block with the same number and ID was archived, a different ID at the
+// same height (a fork) is archived. Skipped blocks are counted in the `deduplicated_blocks` metric,
+// they still reach the block stream server.
+func WithDedupWindow(size int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.dedupWindow = size
+	}
+}
+
+type dedupEntry struct {
+	num uint64
+	id  string
+}
+
+// blockDedup remembers the last archived blocks. They are appended to a file, rewritten with
+// only the remembered blocks once it holds twice as many, so a crash loses at most the block
+// being written. They are only remembered in memory without a file.
+type blockDedup struct {
+	size    int
+	file    string
+	entries []dedupEntry // oldest first
+	known   map[dedupEntry]int
+
+	writer      *os.File
+	fileEntries int
+	logger      *zap.Logger
+}
+
+func newBlockDedup(file string, size int, logger *zap.Logger) (*blockDedup, error) {
+	d := &blockDedup{
+		size:   size,
+		file:   file,
+		known:  make(map[dedupEntry]int),
+		logger: logger,
+	}
+	if file == "" {
+		return d, nil
+	}
+
+	if err := d.load(); err != nil {
+		return nil, fmt.Errorf("loading archived blocks %q: %w", file, err)
+	}
+	if err := d.compact(); err != nil {
+		return nil, fmt.Errorf("writing archived blocks %q: %w", file, err)
+	}
+	return d, nil
+}
+
+func (d *blockDedup) load() error {
+	f, err := os.Open(d.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, ok := parseDedupEntry(scanner.Text())
+		if !ok {
+			// the last line is partial when the node manager crashed while writing it
+			d.logger.Warn("ignoring invalid archived block entry", zap.String("file", d.file), zap.String("line", scanner.Text()))
+			continue
+		}
+		d.remember(entry)
+	}
+	return scanner.Err()
+}
+
+func parseDedupEntry(line string) (dedupEntry, bool) {
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return dedupEntry{}, false
+	}
+
+	num, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return dedupEntry{}, false
+	}
+	return dedupEntry{num: num, id: parts[1]}, true
+}
+
+// seen reports if the block with this exact number and ID was archived
+func (d *blockDedup) seen(block *bstream.Block) bool {
+	_, found := d.known[dedupEntry{num: block.Number, id: block.Id}]
+	return found
+}
+
+// record remembers an archived block, failing to persist it is logged
+func (d *blockDedup) record(block *bstream.Block) {
+	entry := dedupEntry{num: block.Number, id: block.Id}
+	d.remember(entry)
+
+	if d.fileEntries >= 2*d.size {
+		if err := d.compact(); err != nil {
+			d.logger.Warn("unable to rewrite archived blocks, blocks replayed after a restart may be archived twice", zap.String("file", d.file), zap.Error(err))
+		}
+		return
+	}
+
+	if d.writer == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(d.writer, "%d %s\n", entry.num, entry.id); err != nil {
+		d.logger.Warn("unable to append archived block, blocks replayed after a restart may be archived twice", zap.String("file", d.file), zap.Error(err))
+		return
+	}
+	d.fileEntries++
+}
+
+func (d *blockDedup) remember(entry dedupEntry) {
+	d.entries = append(d.entries, entry)
+	d.known[entry]++
+
+	for len(d.entries) > d.size {
+		oldest := d.entries[0]
+		d.entries = d.entries[1:]
+		if d.known[oldest]--; d.known[oldest] <= 0 {
+			delete(d.known, oldest)
+		}
+	}
+}
+
+// compact rewrites the file with the remembered blocks only, then appends to it
+func (d *blockDedup) compact() error {
+	d.close()
+
+	content := &strings.Builder{}
+	for _, entry := range d.entries {
+		fmt.Fprintf(content, "%d %s\n", entry.num, entry.id)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.file), os.ModePerm); err != nil {
+		return err
+	}
+	tempFile := d.file + ".tmp"
+	if err := ioutil.WriteFile(tempFile, []byte(content.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, d.file); err != nil {
+		return err
+	}
+
+	writer, err := os.OpenFile(d.file, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	d.writer = writer
+	d.fileEntries = len(d.entries)
+	return nil
+}
+
+func (d *blockDedup) close() {
+	if d.writer == nil {
+		return
+	}
+
+	if err := d.writer.Close(); err != nil {
+		d.logger.Warn("unable to close archived blocks", zap.String("file", d.file), zap.Error(err))
+	}
+	d.writer = nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDedupTestPlugin(t *testing.T, file string) (*MindReaderPlugin, *mindreadertest.RecordingArchiverIO) {
+	t.Helper()
+
+	p, _ := newReplayTestPlugin(t, 0, 0)
+	io := mindreadertest.NewRecordingArchiverIO()
+	p.archiver = newArchiverWithIO(t, io, 0)
+
+	var err error
+	p.dedup, err = newBlockDedup(file, DefaultDedupWindow, testLogger)
+	require.NoError(t, err)
+
+	return p, io
+}
+
+func feedBlocks(p *MindReaderPlugin, blocks []*bstream.Block) {
+	p.Launch()
+	for _, line := range mindreadertest.FormatLines(blocks) {
+		p.LogLine(line)
+	}
+	p.Stop()
+}
+
+func TestMindReaderPlugin_DedupRestartReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), dedupFilename)
+	generator := mindreadertest.NewBlockGenerator("dedup", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	p, io := newDedupTestPlugin(t, file)
+	feedBlocks(p, generator.Blocks(1, 5))
+	mindreadertest.RequireBlocksArchived(t, io, 1, 2, 3, 4, 5)
+
+	// The restarted node replays blocks 4 and 5
+	p, io = newDedupTestPlugin(t, file)
+	feedBlocks(p, generator.Blocks(4, 5))
+	mindreadertest.RequireBlocksArchived(t, io, 6, 7, 8)
+}
+
+func TestMindReaderPlugin_DedupForkAtSameHeight(t *testing.T) {
+	file := filepath.Join(t.TempDir(), dedupFilename)
+	generator := mindreadertest.NewBlockGenerator("dedup", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	fork := generator.Fork("a")
+
+	p, io := newDedupTestPlugin(t, file)
+	feedBlocks(p, []*bstream.Block{
+		generator.Block(1, 1),
+		generator.Block(2, 1),
+		generator.ForkBlock(fork, 2, 1),
+		generator.Block(2, 1),
+		fork.Block(3, 1),
+	})
+	mindreadertest.RequireBlocksArchived(t, io, 1, 2, 2, 3)
+}
+
+func TestBlockDedup_Window(t *testing.T) {
+	file := filepath.Join(t.TempDir(), dedupFilename)
+	generator := mindreadertest.NewBlockGenerator("dedup", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	dedup, err := newBlockDedup(file, 3, testLogger)
+	require.NoError(t, err)
+	for _, blk := range generator.Blocks(1, 10) {
+		require.False(t, dedup.seen(blk))
+		dedup.record(blk)
+	}
+	dedup.close()
+
+	// A partial line is left by a crash while writing
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("11")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	dedup, err = newBlockDedup(file, 3, testLogger)
+	require.NoError(t, err)
+	defer dedup.close()
+
+	for num := uint64(1); num <= 10; num++ {
+		assert.Equal(t, num >= 8, dedup.seen(generator.Block(num, 1)), "block %d", num)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "8 "+generator.ID(8)+"\n9 "+generator.ID(9)+"\n10 "+generator.ID(10)+"\n", string(content), "compacted on load")
+}
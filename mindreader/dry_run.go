@@ -0,0 +1,68 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"github.com/streamingfast/bstream"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// WithDryRun archives one block and merged blocks files in `localDir` (under `one-blocks` and
+// `merged-blocks`) instead of the archive stores, nothing is uploaded remotely. A summary of
+// the blocks processed is logged on shutdown, useful to validate a new console reader or
+// block filter against a real node.
+func WithDryRun(localDir string) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.dryRun = &dryRunSummary{localDir: localDir}
+	}
+}
+
+// dryRunSummary is only updated from the consume read flow, except for `transformErrors`
+type dryRunSummary struct {
+	localDir string
+
+	blocks              uint64
+	firstBlockNum       uint64
+	lastBlockNum        uint64
+	largestPayload      int
+	largestPayloadBlock uint64
+	transformErrors     atomic.Uint64
+}
+
+func (s *dryRunSummary) record(block *bstream.Block) {
+	if s.blocks == 0 {
+		s.firstBlockNum = block.Number
+	}
+	s.blocks++
+	s.lastBlockNum = block.Number
+
+	if size, err := blockPayloadSize(block); err == nil && size > s.largestPayload {
+		s.largestPayload = size
+		s.largestPayloadBlock = block.Number
+	}
+}
+
+func (s *dryRunSummary) log(zlogger *zap.Logger) {
+	zlogger.Info("dry run summary",
+		zap.String("local_dir", s.localDir),
+		zap.Uint64("blocks_processed", s.blocks),
+		zap.Uint64("first_block_num", s.firstBlockNum),
+		zap.Uint64("last_block_num", s.lastBlockNum),
+		zap.Uint64("transform_errors", s.transformErrors.Load()),
+		zap.Int("largest_payload_size", s.largestPayload),
+		zap.Uint64("largest_payload_block_num", s.largestPayloadBlock),
+	)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewMindReaderPlugin_DryRunUsesLocalStores(t *testing.T) {
+	localDir := t.TempDir()
+
+	p, err := NewMindReaderPlugin(
+		"gs://example/one-blocks",
+		"gs://example/merged-blocks",
+		"always",
+		t.TempDir(),
+		nil,
+		0,
+		0,
+		10,
+		nil,
+		func(error) {},
+		time.Second,
+		"suffix",
+		nil,
+		testLogger,
+		testTracer,
+		WithDryRun(localDir),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, path.Join(localDir, "one-blocks"), p.oneBlockFileUploader.destinationStore.BaseURL().Path)
+	assert.Equal(t, path.Join(localDir, "merged-blocks"), p.mergedBlocksFileUploader.destinationStore.BaseURL().Path)
+}
+
+func TestDryRunSummary(t *testing.T) {
+	summary := &dryRunSummary{localDir: "/tmp/dry-run"}
+
+	for i, size := range []int{3, 8, 5} {
+		blk, err := bstream.MemoryBlockPayloadSetter(&bstream.Block{Number: uint64(10 + i)}, make([]byte, size))
+		require.NoError(t, err)
+		summary.record(blk)
+	}
+	summary.transformErrors.Inc()
+
+	core, logs := observer.New(zap.InfoLevel)
+	summary.log(zap.New(core))
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.EqualValues(t, 3, fields["blocks_processed"])
+	assert.EqualValues(t, 10, fields["first_block_num"])
+	assert.EqualValues(t, 12, fields["last_block_num"])
+	assert.EqualValues(t, 1, fields["transform_errors"])
+	assert.EqualValues(t, 8, fields["largest_payload_size"])
+	assert.EqualValues(t, 11, fields["largest_payload_block_num"])
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+)
+
+// The errors below are the ones the plugin shuts down with, see `OnTerminating`, use `errors.As`
+// and `errors.Is` to tell them apart.
+
+// ErrStopBlockReached is the error the plugin shuts down with once the stop block (or stop
+// condition) is reached. It wraps `nodeManager.ErrCleanStop`, the superviser shuts down with
+// a nil error.
+var ErrStopBlockReached error = stopBlockReachedError{}
+
+type stopBlockReachedError struct{}
+
+func (stopBlockReachedError) Error() string { return "stop block reached" }
+func (stopBlockReachedError) Unwrap() error { return nodeManager.ErrCleanStop }
+
+// StopBlockAlreadyReachedError is the error the plugin shuts down with on launch when the stop
+// marker in the working directory shows a previous run already reached the same stop block,
+// see `WithStopMarkerOverride`
+type StopBlockAlreadyReachedError struct {
+	Marker StopMarker
+	Path   string // of the stop marker
+}
+
+func (e *StopBlockAlreadyReachedError) Error() string {
+	return fmt.Sprintf("stop block %d already reached at %s, last archived block %d (%s), refusing to read blocks again: remove %q or override the stop marker",
+		e.Marker.StopBlockNum, e.Marker.ReachedAt.Format(time.RFC3339), e.Marker.LastArchivedBlockNum, e.Marker.LastArchivedBlockID, e.Path)
+}
+
+// BlocksDroppedError reports the blocks not archived because the read flow did not drain within
+// the shutdown drain timeout, see `WithShutdownDrainTimeout`
+type BlocksDroppedError struct {
+	Timeout       time.Duration
+	Count         uint64
+	FirstBlockNum uint64
+	LastBlockNum  uint64
+}
+
+func (e *BlocksDroppedError) Error() string {
+	return fmt.Sprintf("read flow not drained within %s of shutdown, dropped %d block(s) from %d to %d, you will need to reprocess over this range", e.Timeout, e.Count, e.FirstBlockNum, e.LastBlockNum)
+}
+
+// TransformError is a failure turning the console logs of the node into a block, either reading
+// the block from the console reader or running the transformer chain on it
+type TransformError struct {
+	Block string // the block being transformed, empty when the console reader failed
+	Err   error
+}
+
+func (e *TransformError) Error() string {
+	if e.Block == "" {
+		return fmt.Sprintf("reading block from console logs: %s", e.Err)
+	}
+	return fmt.Sprintf("transforming block %s: %s", e.Block, e.Err)
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// ArchiverStoreError is a failure of the archiver storing a block file in the working directory
+type ArchiverStoreError struct {
+	Filename string // name of the one block file of the block
+	Err      error
+}
+
+func (e *ArchiverStoreError) Error() string {
+	return fmt.Sprintf("archiver store block failed on %q: %s", e.Filename, e.Err)
+}
+
+func (e *ArchiverStoreError) Unwrap() error {
+	return e.Err
+}
+
+// ErrHole and ErrDuplicate are wrapped by the errors of the continuity checker's `Write`, for a
+// block leaving a hole after the highest seen block and for a block of the seen blocks window
+// written again, see `WithSeenBlocksWindow`
+var (
+	ErrHole      = errors.New("hole in blocks")
+	ErrDuplicate = errors.New("block already written")
+)
+
+// ErrPayloadChecksumMismatch is wrapped by the error of the archiver's `StoreBlock` for a block
+// whose payload changed since it was read, see `WithPayloadChecksum`
+var ErrPayloadChecksumMismatch = errors.New("block payload checksum mismatch")
+
+// ContinuityBrokenError is returned when the continuity checker detected a hole in the blocks read
+// from the node, `Expected` being the first block missing, or a block written again, see
+// `WithSeenBlocksWindow`
+type ContinuityBrokenError struct {
+	Expected uint64
+	Got      uint64
+	Err      error
+}
+
+func (e *ContinuityBrokenError) Error() string {
+	return fmt.Sprintf("continuity check failed, expected block %d, got %d: %s", e.Expected, e.Got, e.Err)
+}
+
+func (e *ContinuityBrokenError) Unwrap() error {
+	return e.Err
+}
+
+// UnexpectedEOFError is the error the plugin shuts down with when the console reader stream
+// ended before the stop block was reached, usually because the node process died, see
+// `WithRestartableSource` to wait for a new source instead. It wraps `io.ErrUnexpectedEOF`.
+type UnexpectedEOFError struct {
+	LastBlockNum uint64 // of the last block read, 0 if none
+}
+
+func (e *UnexpectedEOFError) Error() string {
+	return fmt.Sprintf("console reader stream ended before the stop block, last block read %d", e.LastBlockNum)
+}
+
+func (e *UnexpectedEOFError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// SuffixClaimedError is the error the plugin shuts down with on launch when another host holds
+// a live claim of its one block suffix, see `WithOneBlockSuffixClaim`
+type SuffixClaimedError struct {
+	Claim SuffixClaim
+}
+
+func (e *SuffixClaimedError) Error() string {
+	return fmt.Sprintf("one block suffix %q already claimed by %s (pid %d), last refreshed at %s: use another suffix, stop the other mindreader or force the claim",
+		e.Claim.Suffix, e.Claim.Hostname, e.Claim.PID, e.Claim.RefreshedAt.Format(time.RFC3339))
+}
+
+// DestinationFileExistsError is the error the upload of a file already in the destination store
+// fails with, and the plugin shuts down with for one block files, with the
+// `OneBlockOverwriteFail` policy
+type DestinationFileExistsError struct {
+	Filename string
+	Store    string
+}
+
+func (e *DestinationFileExistsError) Error() string {
+	return fmt.Sprintf("file %q already exists in store %s", e.Filename, e.Store)
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/bundle"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runUntilShutdown launches the plugin, logs `lines` and returns the error the plugin shut down
+// with, as seen by `OnTerminating`
+func runUntilShutdown(t *testing.T, p *MindReaderPlugin, lines ...string) error {
+	t.Helper()
+
+	terminatingErr := make(chan error, 1)
+	p.OnTerminating(func(err error) { terminatingErr <- err })
+
+	p.Launch()
+	for _, line := range lines {
+		p.LogLine(line)
+	}
+
+	var err error
+	select {
+	case err = <-terminatingErr:
+	case <-time.After(time.Second):
+		t.Fatal("plugin not shut down")
+	}
+	p.Stop()
+
+	assert.Equal(t, err, p.Err(), "same error once terminated")
+	return err
+}
+
+func TestMindReaderPlugin_ShutdownErrors(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	t.Run("transform error on block", func(t *testing.T) {
+		p, _ := newReplayTestPlugin(t, 0, 0)
+		WithBlockFilter(func(block *bstream.Block) (bool, error) { return false, errBoom })(p)
+
+		err := runUntilShutdown(t, p, `DMLOG {"id":"00000001a"}`)
+
+		var transformErr *TransformError
+		require.True(t, errors.As(err, &transformErr), "got %v", err)
+		assert.Equal(t, "#1 (00000001a)", transformErr.Block)
+		assert.True(t, errors.Is(err, errBoom))
+	})
+
+	t.Run("transform error on line", func(t *testing.T) {
+		p, _ := newReplayTestPlugin(t, 0, 0)
+
+		err := runUntilShutdown(t, p, `DMLOG {"id":`)
+
+		var transformErr *TransformError
+		require.True(t, errors.As(err, &transformErr), "got %v", err)
+		assert.Empty(t, transformErr.Block)
+	})
+
+	t.Run("archiver store", func(t *testing.T) {
+		p, _ := newReplayTestPlugin(t, 0, 0)
+		archiverIO := mindreadertest.NewRecordingArchiverIO()
+		archiverIO.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error { return errBoom }
+		p.archiver = newArchiverWithIO(t, archiverIO, 0)
+
+		err := runUntilShutdown(t, p, `DMLOG {"id":"00000001a"}`)
+
+		var storeErr *ArchiverStoreError
+		require.True(t, errors.As(err, &storeErr), "got %v", err)
+		assert.Equal(t, uint64(1), bundle.MustNewOneBlockFile(storeErr.Filename).Num)
+		assert.True(t, errors.Is(err, errBoom))
+	})
+
+	t.Run("continuity broken", func(t *testing.T) {
+		p, _ := newReplayTestPlugin(t, 0, 0)
+		checker, err := NewContinuityChecker(filepath.Join(t.TempDir(), "continuity"), testLogger)
+		require.NoError(t, err)
+		p.continuityChecker = checker
+
+		err = runUntilShutdown(t, p, `DMLOG {"id":"00000001a"}`, `DMLOG {"id":"00000002a"}`, `DMLOG {"id":"00000004a"}`)
+
+		var continuityErr *ContinuityBrokenError
+		require.True(t, errors.As(err, &continuityErr), "got %v", err)
+		assert.Equal(t, uint64(3), continuityErr.Expected)
+		assert.Equal(t, uint64(4), continuityErr.Got)
+	})
+
+	t.Run("stop block reached", func(t *testing.T) {
+		p, _ := newReplayTestPlugin(t, 0, 2)
+
+		err := runUntilShutdown(t, p, mindreadertest.FormatLines(mindreadertest.NewBlockGenerator("stop", time.Now()).Blocks(1, 3))...)
+
+		assert.True(t, errors.Is(err, ErrStopBlockReached), "got %v", err)
+		assert.True(t, errors.Is(err, nodeManager.ErrCleanStop))
+	})
+
+	t.Run("console reader stream ended before stop block", func(t *testing.T) {
+		p, _ := newReplayTestPlugin(t, 0, 10)
+		p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+			return mindreadertest.NewScriptedConsoleReader(nil, mindreadertest.Step{Line: `DMLOG {"id":"00000001a"}`}, mindreadertest.Step{Line: `DMLOG {"id":"00000002a"}`}), nil
+		}
+
+		err := runUntilShutdown(t, p)
+
+		var eofErr *UnexpectedEOFError
+		require.True(t, errors.As(err, &eofErr), "got %v", err)
+		assert.Equal(t, uint64(2), eofErr.LastBlockNum)
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+}
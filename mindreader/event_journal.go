@@ -0,0 +1,58 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/journal"
+)
+
+// WithEventJournal records the key events of the plugin in the `mindreader` journal of
+// `directory`, for post-mortems: its start and stop, the start gate passing, the stop block
+// being reached, maintenance requests, continuity failures, archiver store errors and the
+// summary of each upload batch. See `journal.Journal` and `journal.ReadEvents`.
+func WithEventJournal(directory string) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.journalDirectory = directory
+	}
+}
+
+// FileUploaderEventJournal records a summary of each upload batch in `j`
+func FileUploaderEventJournal(j *journal.Journal) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.journal = j
+	}
+}
+
+func (fu *FileUploader) recordUploadBatch(uploaded, failed, waiting int, duration time.Duration) {
+	fu.journal.Record(journal.Event{Type: journal.EventUploadBatch, Fields: map[string]interface{}{
+		"file_type":   fu.fileType,
+		"uploaded":    uploaded,
+		"failed":      failed,
+		"waiting":     waiting,
+		"duration_ms": duration.Milliseconds(),
+	}})
+}
+
+// recordGatePassed records the first block passing the start gate
+func (p *MindReaderPlugin) recordGatePassed(block *bstream.Block) {
+	if p.gatePassed {
+		return
+	}
+	p.gatePassed = true
+	p.journal.Record(journal.Event{Type: journal.EventGatePassed, BlockNum: block.Num(), BlockID: block.ID()})
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/journal"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_EventJournal(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	journalDir := t.TempDir()
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(oneBlocks, mergeArchiveStore, "never", t.TempDir(), consoleReaderFactory, 102, 104, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer,
+		WithEventJournal(journalDir),
+		WithUploadPollInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	p.Launch()
+	generator := mindreadertest.NewBlockGenerator("journal", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 10)) {
+		p.LogLine(line)
+	}
+
+	select {
+	case <-p.Terminating():
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not shut down after stop block")
+	}
+	p.Stop()
+
+	events, err := journal.ReadEvents(journalDir, time.Time{})
+	require.NoError(t, err)
+
+	var types []string
+	uploadBatches := 0
+	for _, event := range events {
+		if event.Type == journal.EventUploadBatch {
+			uploadBatches++ // interleaved with the other events, as uploads complete
+		} else {
+			types = append(types, event.Type)
+		}
+		assert.Equal(t, "mindreader", event.Source)
+
+		switch event.Type {
+		case journal.EventGatePassed:
+			assert.Equal(t, uint64(102), event.BlockNum)
+		case journal.EventStopBlockReached:
+			assert.Equal(t, uint64(104), event.BlockNum)
+		case journal.EventPluginStopped:
+			assert.Equal(t, ErrStopBlockReached.Error(), event.Reason)
+		}
+	}
+	assert.Equal(t, []string{journal.EventPluginStarted, journal.EventGatePassed, journal.EventStopBlockReached, journal.EventPluginStopped}, types)
+	assert.Greater(t, uploadBatches, 0)
+}
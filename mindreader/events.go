@@ -0,0 +1,134 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/streamingfast/node-manager/metrics"
+)
+
+// eventsQueueSize bounds the events waiting for the subscribers, events are dropped once it is full
+const eventsQueueSize = 1000
+
+// eventDispatcher calls the subscribers of the plugin's events, in order, from its own goroutine so
+// a slow subscriber never stalls archiving or uploads. Its zero value has no subscribers and is ready to use.
+type eventDispatcher struct {
+	lock                 sync.RWMutex
+	blockArchived        []func(num uint64, id string)
+	mergedBundleUploaded []func(baseBlockNum uint64, objectName string)
+	uploadError          []func(filename string, err error)
+
+	queue     chan func()
+	startOnce sync.Once
+}
+
+func (d *eventDispatcher) start() {
+	d.startOnce.Do(func() {
+		d.queue = make(chan func(), eventsQueueSize)
+		go func() {
+			for call := range d.queue {
+				call()
+			}
+		}()
+	})
+}
+
+// dispatch queues `call` for the dispatcher goroutine, without blocking
+func (d *eventDispatcher) dispatch(event string, call func()) {
+	select {
+	case d.queue <- call:
+	default:
+		metrics.DroppedEvents.Inc(event)
+	}
+}
+
+func (d *eventDispatcher) emitBlockArchived(num uint64, id string) {
+	d.lock.RLock()
+	subscribers := d.blockArchived
+	d.lock.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	d.dispatch("block_archived", func() {
+		for _, f := range subscribers {
+			f(num, id)
+		}
+	})
+}
+
+func (d *eventDispatcher) emitMergedBundleUploaded(objectName string) {
+	d.lock.RLock()
+	subscribers := d.mergedBundleUploaded
+	d.lock.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	baseBlockNum, err := strconv.ParseUint(objectName, 10, 64)
+	if err != nil {
+		return // not a merged blocks file
+	}
+
+	d.dispatch("merged_bundle_uploaded", func() {
+		for _, f := range subscribers {
+			f(baseBlockNum, objectName)
+		}
+	})
+}
+
+func (d *eventDispatcher) emitUploadError(filename string, err error) {
+	d.lock.RLock()
+	subscribers := d.uploadError
+	d.lock.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	d.dispatch("upload_error", func() {
+		for _, f := range subscribers {
+			f(filename, err)
+		}
+	})
+}
+
+// OnBlockArchived subscribes `f` to every block successfully stored by the archiver
+func (p *MindReaderPlugin) OnBlockArchived(f func(num uint64, id string)) {
+	p.events.start()
+	p.events.lock.Lock()
+	defer p.events.lock.Unlock()
+	p.events.blockArchived = append(p.events.blockArchived, f)
+}
+
+// OnMergedBundleUploaded subscribes `f` to every merged blocks file uploaded to the merged blocks
+// store, once the upload completed
+func (p *MindReaderPlugin) OnMergedBundleUploaded(f func(baseBlockNum uint64, objectName string)) {
+	p.events.start()
+	p.events.lock.Lock()
+	defer p.events.lock.Unlock()
+	p.events.mergedBundleUploaded = append(p.events.mergedBundleUploaded, f)
+}
+
+// OnUploadError subscribes `f` to every file, one block or merged blocks, that could not be
+// uploaded after its retries. The file stays in the working directory and is uploaded again later.
+func (p *MindReaderPlugin) OnUploadError(f func(filename string, err error)) {
+	p.events.start()
+	p.events.lock.Lock()
+	defer p.events.lock.Unlock()
+	p.events.uploadError = append(p.events.uploadError, f)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_UploadEventsAfterUploadCompletion(t *testing.T) {
+	local, destination := newSlowUploadTestStores(0, 0)
+	local.SetFile("0000000100", nil)
+	local.SetFile("0000000200", nil)
+	local.SetFile("0000000300", nil)
+
+	var lock sync.Mutex
+	var log []string
+	record := func(entry string) {
+		lock.Lock()
+		defer lock.Unlock()
+		log = append(log, entry)
+	}
+
+	destination.PushLocalFileFunc = func(_ context.Context, _, toBaseName string) error {
+		if toBaseName == "0000000300" {
+			return fmt.Errorf("unavailable")
+		}
+		time.Sleep(5 * time.Millisecond)
+		record("pushed " + toBaseName)
+		return nil
+	}
+
+	p := &MindReaderPlugin{}
+	events := make(chan string, 3)
+	p.OnMergedBundleUploaded(func(baseBlockNum uint64, objectName string) {
+		record(fmt.Sprintf("uploaded %d %s", baseBlockNum, objectName))
+		events <- objectName
+	})
+	p.OnUploadError(func(filename string, err error) {
+		record("error " + filename)
+		events <- filename
+	})
+
+	uploader := NewFileUploader(local, destination, testLogger,
+		FileUploaderOnUploaded(p.events.emitMergedBundleUploaded),
+		FileUploaderOnUploadError(p.events.emitUploadError),
+	)
+	uploader.retries = 0
+
+	_, err := uploader.uploadAllFiles(context.Background())
+	require.Error(t, err)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatal("events not dispatched")
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	indexOf := func(entry string) int {
+		for i, e := range log {
+			if e == entry {
+				return i
+			}
+		}
+		return -1
+	}
+
+	require.Len(t, log, 5)
+	assert.NotEqual(t, -1, indexOf("error 0000000300"))
+	for file, baseBlockNum := range map[string]uint64{"0000000100": 100, "0000000200": 200} {
+		pushed := indexOf("pushed " + file)
+		require.NotEqual(t, -1, pushed)
+		assert.Greater(t, indexOf(fmt.Sprintf("uploaded %d %s", baseBlockNum, file)), pushed, "event after upload of %s", file)
+	}
+}
+
+func TestMindReaderPlugin_SlowSubscriberDoesNotStallArchiving(t *testing.T) {
+	p := &MindReaderPlugin{}
+
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var received []uint64
+	p.OnBlockArchived(func(num uint64, id string) {
+		<-release
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, num)
+	})
+
+	emitted := 2 * eventsQueueSize
+	start := time.Now()
+	for i := 1; i <= emitted; i++ {
+		p.events.emitBlockArchived(uint64(i), "")
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	close(release)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) >= eventsQueueSize
+	}, time.Second, 5*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Less(t, len(received), emitted, "events are dropped once the queue is full")
+	for i := 1; i < len(received); i++ {
+		assert.Less(t, received[i-1], received[i], "events are delivered in order")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// BlockArchiver stores the blocks produced by the mindreader, it is implemented by `Archiver`,
+// `FileSinkArchiver` and `TeeArchiver`. Archivers also implementing `WaitForAllFilesToUpload(ctx)`
+// are flushed by the mindreader once all blocks are stored.
+type BlockArchiver interface {
+	StoreBlock(ctx context.Context, block *bstream.Block) error
+}
+
+type blockArchiverFlusher interface {
+	WaitForAllFilesToUpload(ctx context.Context) error
+}
+
+// WithBlockSink stores every block archived by the mindreader in `sink` as well, use a
+// `TeeArchiver` for multiple sinks. Failing to store a block in the sink is logged, it does
+// not stop the mindreader. Combined with `WithDryRun`, blocks only end up on local disk.
+func WithBlockSink(sink BlockArchiver) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.blockSink = sink
+	}
+}
+
+// FileSinkArchiver appends blocks, in dbin format, to local files named after the first block
+// they contain (`<block num>.dbin`), starting a new file every `rotateEveryBlocks` blocks (never when
+// 0). Blocks are written as they come, a file cut short by a crash is readable up to its last
+// complete block. Files are fsynced when rotated and by `WaitForAllFilesToUpload`, which closes
+// the current file (the next block starts a new one).
+type FileSinkArchiver struct {
+	lock sync.Mutex
+
+	dir                string
+	rotateEveryBlocks  uint64
+	blockWriterFactory bstream.BlockWriterFactory
+	logger             *zap.Logger
+
+	file        *os.File
+	writer      bstream.BlockWriter
+	blocksCount uint64 // blocks written to the current file
+}
+
+func NewFileSinkArchiver(path string, rotateEveryBlocks uint64, zlogger *zap.Logger) (*FileSinkArchiver, error) {
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating file sink directory %q: %w", path, err)
+	}
+
+	return &FileSinkArchiver{
+		dir:                path,
+		rotateEveryBlocks:  rotateEveryBlocks,
+		blockWriterFactory: bstream.GetBlockWriterFactory,
+		logger:             zlogger,
+	}, nil
+}
+
+func (s *FileSinkArchiver) StoreBlock(ctx context.Context, block *bstream.Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file != nil && s.rotateEveryBlocks != 0 && s.blocksCount >= s.rotateEveryBlocks {
+		if err := s.closeFile(); err != nil {
+			return fmt.Errorf("rotating file sink: %w", err)
+		}
+	}
+
+	if s.file == nil {
+		if err := s.openFile(block.Number); err != nil {
+			return err
+		}
+	}
+
+	if err := s.writer.Write(block); err != nil {
+		return fmt.Errorf("writing block %s to file sink %q: %w", block, s.file.Name(), err)
+	}
+	s.blocksCount++
+	return nil
+}
+
+// WaitForAllFilesToUpload flushes and closes the current file
+func (s *FileSinkArchiver) WaitForAllFilesToUpload(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	return s.closeFile()
+}
+
+func (s *FileSinkArchiver) openFile(firstBlockNum uint64) error {
+	name := filepath.Join(s.dir, fmt.Sprintf("%010d.dbin", firstBlockNum))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("creating file sink %q: %w", name, err)
+	}
+
+	writer, err := s.blockWriterFactory.New(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("creating block writer for file sink %q: %w", name, err)
+	}
+
+	s.logger.Debug("file sink writing to new file", zap.String("file", name))
+	s.file = file
+	s.writer = writer
+	s.blocksCount = 0
+	return nil
+}
+
+func (s *FileSinkArchiver) closeFile() error {
+	file := s.file
+	s.file = nil
+	s.writer = nil
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("syncing file sink %q: %w", file.Name(), err)
+	}
+	return file.Close()
+}
+
+// TeeArchiver stores each block in all of its archivers, in order
+type TeeArchiver struct {
+	archivers []BlockArchiver
+}
+
+func NewTeeArchiver(archivers ...BlockArchiver) *TeeArchiver {
+	return &TeeArchiver{archivers: archivers}
+}
+
+// StoreBlock sends the block to every archiver, even when some of them fail, the errors of
+// the failing archivers are returned together
+func (t *TeeArchiver) StoreBlock(ctx context.Context, block *bstream.Block) error {
+	var errs []error
+	for _, archiver := range t.archivers {
+		if err := archiver.StoreBlock(ctx, block); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// WaitForAllFilesToUpload flushes every archiver implementing it, even when some of them fail
+func (t *TeeArchiver) WaitForAllFilesToUpload(ctx context.Context) error {
+	var errs []error
+	for _, archiver := range t.archivers {
+		if flusher, ok := archiver.(blockArchiverFlusher); ok {
+			if err := flusher.WaitForAllFilesToUpload(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors returns nil without errors, the error itself when alone so it can be unwrapped
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Errorf("%d archivers failed: %s", len(errs), strings.Join(messages, "; "))
+}
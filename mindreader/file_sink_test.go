@@ -0,0 +1,161 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileSinkArchiver(t *testing.T, rotateEveryBlocks uint64) (*FileSinkArchiver, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sink, err := NewFileSinkArchiver(dir, rotateEveryBlocks, testLogger)
+	require.NoError(t, err)
+	sink.blockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "tst", 1)
+	})
+	return sink, dir
+}
+
+// readFileSink returns the block numbers read from `file`, stopping at the first error
+func readFileSink(t *testing.T, file string) (nums []uint64, err error) {
+	t.Helper()
+	setter := bstream.GetBlockPayloadSetter
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+	defer func() {
+		bstream.GetBlockPayloadSetter = setter
+	}()
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reader, err := bstream.NewDBinBlockReader(f, nil)
+	require.NoError(t, err)
+	for {
+		block, err := reader.Read()
+		if err != nil {
+			return nums, err
+		}
+		nums = append(nums, block.Number)
+	}
+}
+
+func fileSinkTestBlocks(startBlockNum uint64, count int) []*bstream.Block {
+	generator := mindreadertest.NewBlockGenerator("sink", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	return generator.Blocks(startBlockNum, count)
+}
+
+func TestFileSinkArchiver_Rotation(t *testing.T) {
+	sink, dir := newTestFileSinkArchiver(t, 3)
+
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), sink, fileSinkTestBlocks(1, 8)))
+	require.NoError(t, sink.WaitForAllFilesToUpload(context.Background()))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Equal(t, []string{"0000000001.dbin", "0000000004.dbin", "0000000007.dbin"}, names)
+
+	for name, expected := range map[string][]uint64{
+		"0000000001.dbin": {1, 2, 3},
+		"0000000004.dbin": {4, 5, 6},
+		"0000000007.dbin": {7, 8},
+	} {
+		nums, err := readFileSink(t, filepath.Join(dir, name))
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, expected, nums, name)
+	}
+
+	// The next block after a flush starts a new file
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), sink, fileSinkTestBlocks(9, 1)))
+	require.NoError(t, sink.WaitForAllFilesToUpload(context.Background()))
+	nums, _ := readFileSink(t, filepath.Join(dir, "0000000009.dbin"))
+	assert.Equal(t, []uint64{9}, nums)
+}
+
+func TestFileSinkArchiver_ReadableAfterCrash(t *testing.T) {
+	sink, dir := newTestFileSinkArchiver(t, 0)
+	blocks := fileSinkTestBlocks(1, 5)
+	require.NoError(t, mindreadertest.StoreBlocks(context.Background(), sink, blocks[:4]))
+
+	file := filepath.Join(dir, "0000000001.dbin")
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	completeSize := info.Size()
+
+	// Crash while writing the last block, the file is never closed
+	require.NoError(t, sink.StoreBlock(context.Background(), blocks[4]))
+	require.NoError(t, os.Truncate(file, completeSize+2))
+
+	nums, err := readFileSink(t, file)
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4}, nums)
+}
+
+type failingBlockArchiver struct{}
+
+func (failingBlockArchiver) StoreBlock(ctx context.Context, block *bstream.Block) error {
+	return fmt.Errorf("archiver unavailable")
+}
+
+func TestTeeArchiver(t *testing.T) {
+	sink, dir := newTestFileSinkArchiver(t, 0)
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := newArchiverWithIO(t, io, 0)
+
+	tee := NewTeeArchiver(failingBlockArchiver{}, archiver, sink, failingBlockArchiver{})
+	err := mindreadertest.StoreBlocks(context.Background(), tee, fileSinkTestBlocks(1, 1))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 archivers failed: archiver unavailable; archiver unavailable")
+
+	require.NoError(t, tee.WaitForAllFilesToUpload(context.Background()))
+	assert.Len(t, io.Result().OneBlockFiles, 1, "archivers after a failing one still get the block")
+	nums, _ := readFileSink(t, filepath.Join(dir, "0000000001.dbin"))
+	assert.Equal(t, []uint64{1}, nums)
+}
+
+func TestMindReaderPlugin_BlockSink(t *testing.T) {
+	p, _ := newReplayTestPlugin(t, 0, 0)
+	sink := mindreadertest.NewMemoryArchiver()
+	WithBlockSink(sink)(p)
+
+	generator := mindreadertest.NewBlockGenerator("sink", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	p.Launch()
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(1, 5)) {
+		p.LogLine(line)
+	}
+	p.Stop()
+
+	mindreadertest.RequireBlocksArchived(t, sink, 1, 2, 3, 4, 5)
+	mindreadertest.RequireBlocksUploaded(t, sink, 1, 2, 3, 4, 5)
+}
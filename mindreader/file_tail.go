@@ -0,0 +1,271 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const defaultFileTailPollInterval = 100 * time.Millisecond
+
+type FileTailOption func(s *FileTailSource)
+
+// FileTailFromStart reads the file from its start instead of its end, the default
+func FileTailFromStart() FileTailOption {
+	return func(s *FileTailSource) {
+		s.fromStart = true
+	}
+}
+
+// FileTailPollInterval sets how often the file is checked for new lines, rotation and
+// truncation once its end is reached, 100ms by default
+func FileTailPollInterval(interval time.Duration) FileTailOption {
+	return func(s *FileTailSource) {
+		s.pollInterval = interval
+	}
+}
+
+// WithFileTailSource reads the node's output from the file tailed by `source` instead of the
+// lines of the superviser, for nodes writing their deep mind log to a file, their stdout mixing
+// it with other logs. The lines passed to `LogLine` are ignored. The source is run once the
+// plugin is launched and shut down when the plugin terminates, the plugin is shut down when
+// tailing fails. Tailing goes on when the node is relaunched, the lines read while the pipe of
+// the previous node process is replaced wait for the new one (see `reattachPipe`).
+func WithFileTailSource(source *FileTailSource) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.fileTail = source
+	}
+}
+
+// FileTailSource feeds the complete lines appended to a file, following it when it is rotated
+// (a new file created at its path) or truncated. A partially written last line is kept until
+// its end is written, it is dropped when the file is rotated or truncated before that. A
+// truncation is noticed when the file is found shorter than what was read of it. When the file
+// does not exist yet, it is read from its start once created.
+type FileTailSource struct {
+	*shutter.Shutter
+
+	path         string
+	fromStart    bool
+	pollInterval time.Duration
+
+	linesRead *atomic.Uint64
+
+	zlogger *zap.Logger
+}
+
+func NewFileTailSource(path string, zlogger *zap.Logger, options ...FileTailOption) *FileTailSource {
+	s := &FileTailSource{
+		Shutter:      shutter.New(),
+		path:         path,
+		pollInterval: defaultFileTailPollInterval,
+		linesRead:    atomic.NewUint64(0),
+		zlogger:      zlogger,
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	return s
+}
+
+// LinesRead returns the number of lines fed so far
+func (s *FileTailSource) LinesRead() uint64 {
+	return s.linesRead.Load()
+}
+
+// Run feeds the lines of the file to `feed` until the source is shut down, returning nil, or
+// reading the file fails. It blocks until then.
+func (s *FileTailSource) Run(feed func(line string)) error {
+	file, offset, err := s.waitForFile()
+	if file == nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	s.zlogger.Info("tailing node output file", zap.String("path", s.path), zap.Int64("offset", offset))
+	reader := bufio.NewReader(file)
+	var partial []byte
+	for {
+		if err := s.readLines(reader, &partial, &offset, feed); err != nil {
+			return err
+		}
+
+		rotated, truncated, err := s.checkFile(file, offset)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case rotated:
+			next, err := os.Open(s.path)
+			if err != nil {
+				return fmt.Errorf("opening rotated file %q: %w", s.path, err)
+			}
+
+			// Lines written before the rotation was noticed
+			if err := s.readLines(reader, &partial, &offset, feed); err != nil {
+				next.Close()
+				return err
+			}
+			s.dropPartialLine(partial, "rotated")
+			s.zlogger.Info("node output file rotated, tailing the new file", zap.String("path", s.path))
+
+			file.Close()
+			file = next
+			reader.Reset(file)
+			partial, offset = nil, 0
+			continue
+
+		case truncated:
+			s.dropPartialLine(partial, "truncated")
+			s.zlogger.Info("node output file truncated, tailing it from its start", zap.String("path", s.path))
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("seeking truncated file %q: %w", s.path, err)
+			}
+			reader.Reset(file)
+			partial, offset = nil, 0
+			continue
+		}
+
+		select {
+		case <-s.Terminating():
+			return nil
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// waitForFile opens the file, positioned at its end unless `FileTailFromStart`, waiting for it
+// to be created. The file is nil when the source was shut down meanwhile.
+func (s *FileTailSource) waitForFile() (*os.File, int64, error) {
+	fromStart := s.fromStart
+	for {
+		file, err := os.Open(s.path)
+		if err == nil {
+			if fromStart {
+				return file, 0, nil
+			}
+
+			offset, err := file.Seek(0, io.SeekEnd)
+			if err != nil {
+				file.Close()
+				return nil, 0, fmt.Errorf("seeking end of file %q: %w", s.path, err)
+			}
+			return file, offset, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, 0, fmt.Errorf("opening file %q: %w", s.path, err)
+		}
+
+		if !fromStart {
+			s.zlogger.Info("node output file does not exist yet, waiting for it", zap.String("path", s.path))
+		}
+		fromStart = true // all of its lines are new
+
+		select {
+		case <-s.Terminating():
+			return nil, 0, nil
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// readLines feeds the complete lines available, up to the end of the file, `partial` keeping
+// the start of a line not completely written yet
+func (s *FileTailSource) readLines(reader *bufio.Reader, partial *[]byte, offset *int64, feed func(line string)) error {
+	for {
+		chunk, err := reader.ReadBytes('\n')
+		*offset += int64(len(chunk))
+		if err == io.EOF {
+			*partial = append(*partial, chunk...)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading file %q: %w", s.path, err)
+		}
+
+		line := bytes.TrimSuffix(append(*partial, chunk[:len(chunk)-1]...), []byte("\r"))
+		*partial = nil
+
+		s.linesRead.Inc()
+		feed(string(line))
+	}
+}
+
+// checkFile reports if the file at the path is no longer `file`, or if it is shorter than
+// `offset`. A missing file, between the rotation and the creation of the new one, is neither.
+func (s *FileTailSource) checkFile(file *os.File, offset int64) (rotated bool, truncated bool, err error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("checking file %q: %w", s.path, err)
+	}
+
+	current, err := file.Stat()
+	if err != nil {
+		return false, false, fmt.Errorf("checking file %q: %w", s.path, err)
+	}
+
+	if !os.SameFile(info, current) {
+		return true, false, nil
+	}
+	return false, info.Size() < offset, nil
+}
+
+func (s *FileTailSource) dropPartialLine(partial []byte, reason string) {
+	if len(partial) == 0 {
+		return
+	}
+	s.zlogger.Warn("dropping partially written last line of node output file", zap.String("path", s.path), zap.String("reason", reason), zap.Int("bytes", len(partial)))
+}
+
+// runFileTail runs the file tail source, shutting it down when the plugin terminates and the
+// plugin down when tailing fails
+func (p *MindReaderPlugin) runFileTail() {
+	p.OnTerminating(func(_ error) {
+		p.fileTail.Shutdown(nil)
+	})
+
+	done := make(chan struct{})
+	p.fileTailDone = done
+	go func() {
+		defer close(done)
+		if err := p.fileTail.Run(p.handleLine); err != nil && !p.IsTerminating() {
+			p.zlogger.Error("tailing node output file failed, shutting down", zap.Error(err))
+			p.Shutdown(fmt.Errorf("tailing node output file: %w", err))
+		}
+	}()
+}
+
+// waitForFileTail returns once the file tail source no longer feeds lines, the plugin being
+// terminating
+func (p *MindReaderPlugin) waitForFileTail() {
+	if p.fileTailDone != nil {
+		<-p.fileTailDone
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tailedLines struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (l *tailedLines) feed(line string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func (l *tailedLines) get() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+func appendToFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func runFileTail(t *testing.T, source *FileTailSource, feed func(string)) {
+	t.Helper()
+
+	done := make(chan error)
+	go func() { done <- source.Run(feed) }()
+	t.Cleanup(func() {
+		source.Shutdown(nil)
+		assert.NoError(t, <-done)
+	})
+}
+
+func TestFileTailSource_RotationAndTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deepmind.log")
+	appendToFile(t, path, "a\nb\r\n")
+
+	lines := &tailedLines{}
+	source := NewFileTailSource(path, testLogger, FileTailFromStart(), FileTailPollInterval(5*time.Millisecond))
+	runFileTail(t, source, lines.feed)
+
+	appendToFile(t, path, "c\npart")
+	require.Eventually(t, func() bool { return len(lines.get()) == 3 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, lines.get(), "partially written line is not fed")
+
+	appendToFile(t, path, "ial\n")
+	require.Eventually(t, func() bool { return len(lines.get()) == 4 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendToFile(t, path, "d\n")
+	require.Eventually(t, func() bool { return len(lines.get()) == 5 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, os.Truncate(path, 0))
+	time.Sleep(50 * time.Millisecond)
+	appendToFile(t, path, "e\n")
+	require.Eventually(t, func() bool { return len(lines.get()) == 6 }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, []string{"a", "b", "c", "partial", "d", "e"}, lines.get())
+	assert.EqualValues(t, 6, source.LinesRead())
+}
+
+func TestFileTailSource_StartsAtEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deepmind.log")
+	appendToFile(t, path, "old\n")
+
+	lines := &tailedLines{}
+	runFileTail(t, NewFileTailSource(path, testLogger, FileTailPollInterval(5*time.Millisecond)), lines.feed)
+
+	time.Sleep(20 * time.Millisecond)
+	appendToFile(t, path, "new\n")
+	require.Eventually(t, func() bool { return len(lines.get()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"new"}, lines.get())
+}
+
+func TestFileTailSource_WaitsForFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deepmind.log")
+
+	lines := &tailedLines{}
+	runFileTail(t, NewFileTailSource(path, testLogger, FileTailPollInterval(5*time.Millisecond)), lines.feed)
+
+	time.Sleep(20 * time.Millisecond)
+	appendToFile(t, path, "first\n")
+	require.Eventually(t, func() bool { return len(lines.get()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"first"}, lines.get(), "a file created after the source started is read from its start")
+}
+
+func TestMindReaderPlugin_FileTailSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deepmind.log")
+	generator := mindreadertest.NewBlockGenerator("tail", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+	WithFileTailSource(NewFileTailSource(path, testLogger, FileTailFromStart(), FileTailPollInterval(5*time.Millisecond)))(p)
+	p.Launch()
+
+	appendToFile(t, path, strings.Join(mindreadertest.FormatLines(generator.Blocks(1, 3)), "\n")+"\n")
+	require.Eventually(t, func() bool { return len(headBlocks()) == 3 }, time.Second, 5*time.Millisecond)
+
+	// Lines of the superviser are ignored
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(10, 1)) {
+		p.LogLine(line)
+	}
+
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendToFile(t, path, strings.Join(mindreadertest.FormatLines(generator.Blocks(4, 3)), "\n")+"\n")
+	require.Eventually(t, func() bool { return len(headBlocks()) == 6 }, time.Second, 5*time.Millisecond)
+
+	p.Stop()
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, headBlocks())
+	assert.True(t, p.fileTail.IsTerminating())
+}
+
+func TestMindReaderPlugin_FileTailSourceRelaunch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deepmind.log")
+	generator := mindreadertest.NewBlockGenerator("tail", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+	p.lineBufferLines = 1
+	WithFileTailSource(NewFileTailSource(path, testLogger, FileTailFromStart(), FileTailPollInterval(time.Millisecond)))(p)
+	p.Launch()
+
+	var expected []uint64
+	for i := uint64(1); i <= 300; i++ {
+		expected = append(expected, i)
+	}
+	appendToFile(t, path, strings.Join(mindreadertest.FormatLines(generator.Blocks(1, 300)), "\n")+"\n")
+
+	// The tail keeps feeding lines while the node is relaunched
+	for i := 0; i < 20 && len(headBlocks()) < 300; i++ {
+		p.Launch()
+	}
+	require.Eventually(t, func() bool { return len(headBlocks()) == 300 }, 5*time.Second, 5*time.Millisecond)
+
+	p.Stop()
+	assert.NoError(t, p.Err())
+	assert.Equal(t, expected, headBlocks())
+}
@@ -2,13 +2,17 @@ package mindreader
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/abourget/llerrgroup"
 	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/journal"
+	"github.com/streamingfast/node-manager/metrics"
 	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -18,62 +22,582 @@ type FileUploader struct {
 	localStore       dstore.Store
 	destinationStore dstore.Store
 	logger           *zap.Logger
+
+	concurrency  int
+	retries      int // attempts of a file in a single pass, after the first one
+	retryPolicy  UploadRetryPolicy
+	pollInterval time.Duration
+	scanInterval time.Duration
+	scanBackoff  scanBackoff // see `FileUploaderAdaptiveScan`
+
+	storedLock sync.Mutex
+	stored     map[string]struct{} // files notified as stored since the last pass, see `fileStored`
+	wake       chan struct{}       // wakes the upload loop up, never blocks a sender
+
+	retryLock   sync.Mutex
+	retryStates map[string]*uploadRetryState // of the files that failed to upload, by name
+
+	secondaryStores []dstore.Store
+	secondaryGiveUp time.Duration
+	replicationLock sync.Mutex
+	replications    map[string]*replicationState // of the files uploaded to the destination store only, by name
+
+	partitionWidth uint64 // see `FileUploaderPartitioning`
+	failOnExisting bool   // see `FileUploaderFailOnExistingFile`
+
+	metrics  *metrics.MindreaderMetrics // see `FileUploaderMetrics`
+	fileType string
+
+	pauseLock sync.RWMutex // held for reading by the passes of the upload and replication loops, see `Pause`
+	paused    bool
+
+	journal *journal.Journal // see `FileUploaderEventJournal`
+
+	quarantineDirectory   string // see `FileUploaderQuarantine`
+	quarantineMaxAttempts int
+
+	onUploaded    func(filename string)
+	onUploadError func(filename string, err error)
+
+	lastUpload   atomic.Int64 // unix nanoseconds of the last successful upload, 0 if none
+	failingSince atomic.Int64 // unix nanoseconds of the first failure since the last successful upload, 0 if not failing
+}
+
+type FileUploaderOption func(fu *FileUploader)
+
+// FileUploaderConcurrency sets the number of files uploaded in parallel, 5 by default
+func FileUploaderConcurrency(n int) FileUploaderOption {
+	return func(fu *FileUploader) {
+		if n > 0 {
+			fu.concurrency = n
+		}
+	}
+}
+
+// FileUploaderOnUploaded calls `f` with the name of every file once uploaded, from the upload
+// workers, `f` must not block
+func FileUploaderOnUploaded(f func(filename string)) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.onUploaded = f
+	}
+}
+
+// FileUploaderOnUploadError calls `f` for every file not uploaded after its retries, from the upload
+// workers, `f` must not block
+func FileUploaderOnUploadError(f func(filename string, err error)) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.onUploadError = f
+	}
 }
 
-func NewFileUploader(localStore dstore.Store, destinationStore dstore.Store, logger *zap.Logger) *FileUploader {
-	return &FileUploader{
+// FileUploaderMetrics records the latency of the uploads of files of `fileType`
+// (`metrics.FileTypeOneBlock` or `metrics.FileTypeMerged`) in `m`
+func FileUploaderMetrics(m *metrics.MindreaderMetrics, fileType string) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.metrics = m
+		fu.fileType = fileType
+	}
+}
+
+func NewFileUploader(localStore dstore.Store, destinationStore dstore.Store, logger *zap.Logger, options ...FileUploaderOption) *FileUploader {
+	fu := &FileUploader{
 		Shutter:          shutter.New(),
 		localStore:       localStore,
 		destinationStore: destinationStore,
 		logger:           logger,
+		concurrency:      5,
+		retries:          3,
+		retryPolicy:      DefaultUploadRetryPolicy,
+		pollInterval:     500 * time.Millisecond,
+		scanInterval:     5 * time.Second,
+		stored:           map[string]struct{}{},
+		wake:             make(chan struct{}, 1),
+		retryStates:      map[string]*uploadRetryState{},
+		replications:     map[string]*replicationState{},
 	}
+
+	for _, opt := range options {
+		opt(fu)
+	}
+
+	return fu
 }
 
+// Start runs the upload loop: files notified as stored (see `fileStored`) are uploaded right
+// away, failed uploads are retried every poll interval and the whole local store is scanned
+// every scan interval, and once on start, for the files never notified (e.g. left by a crash).
 func (fu *FileUploader) Start(ctx context.Context) {
 	if fu.IsTerminating() {
 		return
 	}
 
+	if len(fu.secondaryStores) > 0 {
+		go fu.runReplication(ctx)
+	}
+
+	poll := time.NewTicker(fu.pollInterval)
+	defer poll.Stop()
+
+	fu.scanBackoff.base = fu.scanInterval
+	var scan <-chan time.Time
+	fullScan := true
 	for {
-		err := fu.uploadFiles(ctx)
+		ran := false
+		var uploaded []string
+		err := fu.unlessPaused(func() (err error) {
+			ran = true
+			if fullScan {
+				uploaded, err = fu.uploadAllFiles(ctx)
+				return err
+			}
+			uploaded, err = fu.uploadStoredFiles(ctx)
+			return err
+		})
 		if err != nil {
 			fu.logger.Warn("failed to upload file", zap.Error(err))
 		}
 
+		switch {
+		case ran && fullScan:
+			fullScan = false
+			scan = time.After(fu.scanBackoff.next(len(uploaded) > 0))
+		case len(uploaded) > 0 && fu.scanBackoff.reset():
+			scan = time.After(fu.scanInterval)
+		case scan == nil:
+			scan = time.After(fu.scanInterval)
+		}
+
 		select {
 		case <-fu.Terminating():
 			fu.logger.Info("terminating upload loop")
 			return
-		case <-time.After(500 * time.Millisecond):
+		case <-fu.wake:
+		case <-poll.C:
+		case <-scan:
+			fullScan = true
+		}
+	}
+}
+
+// fileStored notifies the upload loop that `filename` was written to the local store, it is
+// uploaded without waiting for the next scan. It never blocks.
+func (fu *FileUploader) fileStored(filename string) {
+	fu.storedLock.Lock()
+	fu.stored[filename] = struct{}{}
+	fu.storedLock.Unlock()
+
+	fu.wakeUp()
+}
+
+func (fu *FileUploader) wakeUp() {
+	select {
+	case fu.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeStoredFiles returns, and forgets, the files notified as stored and the files of failed
+// uploads, sorted
+func (fu *FileUploader) takeStoredFiles() []string {
+	fu.storedLock.Lock()
+	filenames := make([]string, 0, len(fu.stored))
+	for filename := range fu.stored {
+		filenames = append(filenames, filename)
+	}
+	fu.stored = map[string]struct{}{}
+	fu.storedLock.Unlock()
+
+	fu.retryLock.Lock()
+	for filename := range fu.retryStates {
+		filenames = append(filenames, filename)
+	}
+	fu.retryLock.Unlock()
+
+	sort.Strings(filenames)
+	return dedupSorted(filenames)
+}
+
+// forgetStoredFiles drops the notifications of `filenames`, uploaded by a scan of the local store
+func (fu *FileUploader) forgetStoredFiles(filenames []string) {
+	fu.storedLock.Lock()
+	defer fu.storedLock.Unlock()
+
+	for _, filename := range filenames {
+		delete(fu.stored, filename)
+	}
+}
+
+func dedupSorted(values []string) []string {
+	out := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			out = append(out, value)
 		}
 	}
+	return out
+}
+
+// Pause holds the uploads of the upload loop, and the replication to the secondary stores, until
+// `Resume` is called. It returns once their in-flight pass completed, the local store is then
+// only written to. Uploads requested explicitly (e.g. `WaitForAllFilesToUpload` on shutdown) are
+// not held.
+func (fu *FileUploader) Pause() {
+	fu.pauseLock.Lock()
+	defer fu.pauseLock.Unlock()
+
+	if !fu.paused {
+		fu.logger.Info("pausing uploads")
+	}
+	fu.paused = true
+}
+
+func (fu *FileUploader) Resume() {
+	fu.pauseLock.Lock()
+	defer fu.pauseLock.Unlock()
+
+	if fu.paused {
+		fu.logger.Info("resuming uploads")
+	}
+	fu.paused = false
+	fu.wakeUp()
+}
+
+// unlessPaused runs `pass` of the upload or replication loop, unless the uploads are paused
+func (fu *FileUploader) unlessPaused(pass func() error) error {
+	fu.pauseLock.RLock()
+	defer fu.pauseLock.RUnlock()
+
+	if fu.paused {
+		return nil
+	}
+	return pass()
 }
 
 func (fu *FileUploader) uploadFiles(ctx context.Context) error {
+	_, err := fu.uploadAllFiles(ctx)
+	return err
+}
+
+// uploadAllFiles uploads every file currently in the local store, returning the name of the
+// files that were successfully uploaded, see `upload`.
+func (fu *FileUploader) uploadAllFiles(ctx context.Context) (uploaded []string, err error) {
 	fu.mutex.Lock()
 	defer fu.mutex.Unlock()
 
-	eg := llerrgroup.New(5)
-	_ = fu.localStore.Walk(ctx, "", func(filename string) (err error) {
-		if eg.Stop() {
-			return nil
+	var filenames []string
+	_ = fu.localStore.Walk(ctx, "", func(filename string) error {
+		if !fu.replicating(filename) {
+			filenames = append(filenames, filename)
+		}
+		return nil
+	})
+	fu.forgetStoredFiles(filenames)
+
+	return fu.upload(ctx, filenames)
+}
+
+// pendingFiles returns the number of files of the local store waiting to be uploaded to the
+// destination store, the files uploaded and waiting to be replicated are not counted
+func (fu *FileUploader) pendingFiles(ctx context.Context) (count int) {
+	_ = fu.localStore.Walk(ctx, "", func(filename string) error {
+		if !fu.replicating(filename) {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// uploadStoredFiles uploads the files notified as stored, and the files of failed uploads,
+// without scanning the local store. Files gone from it, uploaded by a scan in the meantime, are
+// skipped.
+func (fu *FileUploader) uploadStoredFiles(ctx context.Context) (uploaded []string, err error) {
+	fu.mutex.Lock()
+	defer fu.mutex.Unlock()
+
+	var filenames []string
+	for _, filename := range fu.takeStoredFiles() {
+		if fu.replicating(filename) {
+			continue
+		}
+		if exists, err := fu.localStore.FileExists(ctx, filename); err == nil && !exists {
+			fu.clearRetryState(filename)
+			continue
 		}
-		eg.Go(func() error {
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
-			defer cancel()
+		filenames = append(filenames, filename)
+	}
+
+	return fu.upload(ctx, filenames)
+}
+
+// upload uploads `filenames` of the local store in parallel, by the configured number of
+// workers, a file failing (after its retries) does not prevent the others from being uploaded.
+// Files waiting for the backoff of their retry policy are skipped and reported as not uploaded.
+// It returns once every worker is done, no new upload is started once `ctx` is done.
+func (fu *FileUploader) upload(ctx context.Context, filenames []string) (uploaded []string, err error) {
+	if len(filenames) == 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	total := len(filenames)
+	filenames = fu.dueFiles(filenames, time.Now())
+	waiting := total - len(filenames)
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("%d file(s) waiting to be retried", waiting)
+	}
+
+	metrics.UploadQueueDepth.Native().Add(float64(len(filenames)))
+
+	var lock sync.Mutex
+	var failed int
+	var firstErr error
+
+	queue := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < fu.concurrency && i < len(filenames); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range queue {
+				err := fu.uploadFile(ctx, filename)
+				metrics.UploadQueueDepth.Dec()
+				fu.recordUploadResult(err, time.Now())
+				fu.notifyUploadResult(filename, err)
+
+				lock.Lock()
+				if err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					uploaded = append(uploaded, filename)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
 
-			if traceEnabled {
-				fu.logger.Debug("uploading file to storage", zap.String("local_file", filename))
+	for i, filename := range filenames {
+		if ctx.Err() != nil {
+			metrics.UploadQueueDepth.Native().Sub(float64(len(filenames) - i))
+			lock.Lock()
+			failed += len(filenames) - i
+			if firstErr == nil {
+				firstErr = ctx.Err()
 			}
+			lock.Unlock()
+			break
+		}
+		queue <- filename
+	}
+	close(queue)
+	wg.Wait()
+	fu.recordUploadBatch(len(uploaded), failed, waiting, time.Since(start))
 
-			if err = fu.destinationStore.PushLocalFile(ctx, fu.localStore.ObjectPath(filename), filename); err != nil {
-				return fmt.Errorf("moving file %q to storage: %w", filename, err)
+	if failed > 0 {
+		return uploaded, fmt.Errorf("%d of %d file(s) not uploaded, first error: %w", failed+waiting, total, firstErr)
+	}
+	if waiting > 0 {
+		return uploaded, fmt.Errorf("%d of %d file(s) waiting to be retried", waiting, total)
+	}
+	return uploaded, nil
+}
+
+// WaitForAllFilesToUpload uploads the files of the local store, retrying until a pass uploads every
+// one of them, or fails on a file already in the destination store (see
+// `FileUploaderFailOnExistingFile`). It returns the context's error as soon as `ctx` is done, even when uploads are stuck,
+// files not uploaded stay in the local store.
+func (fu *FileUploader) WaitForAllFilesToUpload(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		for {
+			err := fu.uploadFiles(ctx)
+			var existsErr *DestinationFileExistsError
+			if err == nil || errors.As(err, &existsErr) {
+				done <- err
+				return
 			}
+
+			fu.logger.Debug("not all files uploaded, retrying", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fu.pollInterval):
+			}
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// uploadFile retries, with the backoff of the retry policy, to push the file to the destination
+// store. The local file is only deleted by `PushLocalFile` once it was successfully written to
+// the destination, or once replicated when there are secondary stores. After its retries, the
+// file is skipped until its backoff elapsed, or quarantined once it failed the max attempts of
+// `FileUploaderQuarantine`.
+func (fu *FileUploader) uploadFile(ctx context.Context, filename string) error {
+	for attempt := 0; ; attempt++ {
+		pushStart := time.Now()
+		err := fu.pushFile(ctx, filename)
+		if err == nil {
+			fu.metrics.ObserveFileUpload(fu.fileType, time.Since(pushStart))
+			fu.clearRetryState(filename)
 			return nil
-		})
+		}
+
+		var existsErr *DestinationFileExistsError
+		if errors.As(err, &existsErr) {
+			return fmt.Errorf("moving file %q to storage: %w", filename, err)
+		}
+
+		delay, state := fu.recordFailedAttempt(filename, err, time.Now())
+		if fu.quarantineMaxAttempts > 0 && state.attempts >= fu.quarantineMaxAttempts {
+			return fu.quarantine(filename, state, err)
+		}
+		if attempt >= fu.retries {
+			return fmt.Errorf("moving file %q to storage: %w", filename, err)
+		}
+
+		fu.logger.Debug("failed to upload file, retrying", zap.String("local_file", filename), zap.Int("attempt", attempt+1), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("moving file %q to storage: %w", filename, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (fu *FileUploader) pushFile(ctx context.Context, filename string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	if traceEnabled {
+		fu.logger.Debug("uploading file to storage", zap.String("local_file", filename))
+	}
+
+	if fu.failOnExisting {
+		if err := fu.checkDestinationFileAbsent(ctx, filename); err != nil {
+			return err
+		}
+	}
 
+	if len(fu.secondaryStores) > 0 {
+		if err := fu.writeLocalFile(ctx, fu.destinationStore, filename); err != nil {
+			return err
+		}
+		fu.startReplication(filename, time.Now())
 		return nil
-	})
+	}
+
+	err := fu.destinationStore.PushLocalFile(ctx, fu.localStore.ObjectPath(filename), fu.destinationName(filename))
+	recordStoreUpload(fu.destinationStore, err)
+	return err
+}
+
+// destinationName returns the name of the local file `filename` in the destination stores
+func (fu *FileUploader) destinationName(filename string) string {
+	return partitionedFileName(filename, fu.partitionWidth)
+}
+
+func (fu *FileUploader) recordUploadResult(err error, now time.Time) {
+	if err != nil {
+		fu.failingSince.CAS(0, now.UnixNano())
+		return
+	}
+
+	fu.lastUpload.Store(now.UnixNano())
+	fu.failingSince.Store(0)
+}
+
+func (fu *FileUploader) notifyUploadResult(filename string, err error) {
+	if err != nil {
+		if fu.onUploadError != nil {
+			fu.onUploadError(filename, err)
+		}
+		return
+	}
+
+	if fu.onUploaded != nil {
+		fu.onUploaded(filename)
+	}
+}
+
+func (fu *FileUploader) hasUploaded() bool {
+	return fu.lastUpload.Load() != 0
+}
 
-	return eg.Wait()
+// failingFor returns for how long uploads have been failing without any success, 0 if not failing
+func (fu *FileUploader) failingFor(now time.Time) time.Duration {
+	since := fu.failingSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, since))
+}
+
+// waitForVisibility checks, with backoff, that every one of `files` exists in the destination
+// store, stores with eventual consistency may not show an uploaded file right away. The files
+// are checked in parallel, as many at a time as files are uploaded.
+func (fu *FileUploader) waitForVisibility(ctx context.Context, files []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := 100 * time.Millisecond
+	pending := files
+	for {
+		pending = fu.notVisible(ctx, pending)
+		if len(pending) == 0 {
+			return nil
+		}
+
+		fu.logger.Debug("waiting for uploaded files to be visible", zap.Int("not_visible", len(pending)), zap.Duration("backoff", backoff))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d uploaded file(s) still not visible (first is %q): %w", len(pending), pending[0], ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// notVisible returns the files of `files` not existing in the destination store, in order
+func (fu *FileUploader) notVisible(ctx context.Context, files []string) (out []string) {
+	visible := make([]bool, len(files))
+	queue := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < fu.concurrency && i < len(files); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				exists, err := fu.destinationStore.FileExists(ctx, fu.destinationName(files[index]))
+				if err != nil {
+					fu.logger.Debug("unable to check if file exists, will retry", zap.String("file", files[index]), zap.Error(err))
+				}
+				visible[index] = exists
+			}
+		}()
+	}
+	for index := range files {
+		queue <- index
+	}
+	close(queue)
+	wg.Wait()
+
+	for index, file := range files {
+		if !visible[index] {
+			out = append(out, file)
+		}
+	}
+	return
 }
@@ -2,10 +2,16 @@ package mindreader
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/dstorefault"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -41,3 +47,263 @@ func TestFileUploader(t *testing.T) {
 		t.Error("took took long")
 	}
 }
+
+func newSlowUploadTestStores(fileCount int, latency time.Duration) (local, destination *dstore.MockStore) {
+	local = dstore.NewMockStore(nil)
+	for i := 0; i < fileCount; i++ {
+		local.SetFile(fmt.Sprintf("%010d", i), nil)
+	}
+
+	destination = dstore.NewMockStore(nil)
+	destination.PushLocalFileFunc = func(_ context.Context, _, _ string) error {
+		time.Sleep(latency)
+		return nil
+	}
+	return local, destination
+}
+
+func TestFileUploader_ConcurrencyImprovesDrainTime(t *testing.T) {
+	drainTime := func(concurrency int) time.Duration {
+		local, destination := newSlowUploadTestStores(20, 20*time.Millisecond)
+		uploader := NewFileUploader(local, destination, testLogger, FileUploaderConcurrency(concurrency))
+
+		start := time.Now()
+		uploaded, err := uploader.uploadAllFiles(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, uploaded, 20)
+		return time.Since(start)
+	}
+
+	sequential := drainTime(1)
+	parallel := drainTime(10)
+
+	assert.GreaterOrEqual(t, int64(sequential), int64(20*20*time.Millisecond))
+	assert.Less(t, int64(parallel), int64(sequential/4), "parallel %s, sequential %s", parallel, sequential)
+}
+
+func TestFileUploader_FailedFileDoesNotBlockOthers(t *testing.T) {
+	local, destination := newSlowUploadTestStores(5, 0)
+
+	var lock sync.Mutex
+	attempts := map[string]int{}
+	destination.PushLocalFileFunc = func(_ context.Context, _, toBaseName string) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		attempts[toBaseName]++
+		switch {
+		case toBaseName == "0000000001":
+			return fmt.Errorf("permanent failure")
+		case toBaseName == "0000000003" && attempts[toBaseName] == 1:
+			return fmt.Errorf("transient failure")
+		}
+		return nil
+	}
+
+	uploader := NewFileUploader(local, destination, testLogger, FileUploaderConcurrency(2), FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond}))
+
+	uploaded, err := uploader.uploadAllFiles(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `1 of 5 file(s) not uploaded`)
+	assert.Contains(t, err.Error(), `"0000000001"`)
+
+	sort.Strings(uploaded)
+	assert.Equal(t, []string{"0000000000", "0000000002", "0000000003", "0000000004"}, uploaded)
+	assert.Equal(t, 4, attempts["0000000001"], "initial attempt and 3 retries")
+	assert.Equal(t, 2, attempts["0000000003"])
+}
+
+func TestFileUploader_LocalFileKeptUntilUploaded(t *testing.T) {
+	localDir := t.TempDir()
+	localStore, err := dstore.NewDBinStore(localDir)
+	require.NoError(t, err)
+	require.NoError(t, localStore.WriteObject(context.Background(), "0000000001", strings.NewReader("block")))
+
+	failing := dstore.NewMockStore(nil)
+	failing.PushLocalFileFunc = func(_ context.Context, _, _ string) error {
+		return fmt.Errorf("unavailable")
+	}
+
+	uploader := NewFileUploader(localStore, failing, testLogger)
+	uploader.retries = 0
+	_, err = uploader.uploadAllFiles(context.Background())
+	require.Error(t, err)
+
+	exists, err := localStore.FileExists(context.Background(), "0000000001")
+	require.NoError(t, err)
+	assert.True(t, exists, "local file kept after failed upload")
+
+	destinationDir := t.TempDir()
+	destinationStore, err := dstore.NewDBinStore(destinationDir)
+	require.NoError(t, err)
+
+	uploader = NewFileUploader(localStore, destinationStore, testLogger)
+	uploaded, err := uploader.uploadAllFiles(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000000001"}, uploaded)
+
+	exists, err = localStore.FileExists(context.Background(), "0000000001")
+	require.NoError(t, err)
+	assert.False(t, exists, "local file deleted once uploaded")
+}
+
+func TestFileUploader_WaitForAllFilesToUpload(t *testing.T) {
+	local, destination := newSlowUploadTestStores(3, 0)
+
+	var lock sync.Mutex
+	failures := 2
+	destination.PushLocalFileFunc = func(_ context.Context, _, _ string) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("transient failure")
+		}
+		return nil
+	}
+
+	uploader := NewFileUploader(local, destination, testLogger, FileUploaderPollInterval(time.Millisecond), FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond}))
+	uploader.retries = 0
+
+	require.NoError(t, uploader.WaitForAllFilesToUpload(context.Background()))
+	assert.Equal(t, 0, failures)
+}
+
+func TestFileUploader_WaitForAllFilesToUploadStuck(t *testing.T) {
+	local, destination := newSlowUploadTestStores(1, 0)
+
+	release := make(chan struct{})
+	defer close(release)
+	destination.PushLocalFileFunc = func(_ context.Context, _, _ string) error {
+		<-release // ignores the context, like a hung store
+		return nil
+	}
+
+	uploader := NewFileUploader(local, destination, testLogger)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, uploader.WaitForAllFilesToUpload(ctx))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestFileUploader_Pause(t *testing.T) {
+	ctx := context.Background()
+	localStore, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	destination, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	slowDestination := dstorefault.Wrap(destination, dstorefault.FaultPolicy{Faults: []dstorefault.Fault{
+		{Operations: []dstorefault.Operation{dstorefault.PushLocalFile}, Latency: 100 * time.Millisecond},
+	}})
+
+	uploader := NewFileUploader(localStore, slowDestination, testLogger, FileUploaderPollInterval(5*time.Millisecond))
+	go uploader.Start(ctx)
+	defer uploader.Shutdown(nil)
+
+	exists := func(store dstore.Store, name string) bool {
+		found, err := store.FileExists(ctx, name)
+		require.NoError(t, err)
+		return found
+	}
+
+	require.NoError(t, localStore.WriteObject(ctx, "0000000001", strings.NewReader("block")))
+	uploader.fileStored("0000000001")
+	require.Eventually(t, func() bool { return slowDestination.Calls(dstorefault.PushLocalFile) == 1 }, time.Second, time.Millisecond)
+
+	uploader.Pause()
+	assert.True(t, exists(destination, "0000000001"), "in-flight upload completed before pausing")
+
+	require.NoError(t, localStore.WriteObject(ctx, "0000000002", strings.NewReader("block")))
+	uploader.fileStored("0000000002")
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, exists(localStore, "0000000002"), "not uploaded while paused")
+	assert.Equal(t, 1, slowDestination.Calls(dstorefault.PushLocalFile))
+
+	uploader.Resume()
+	assert.Eventually(t, func() bool { return exists(destination, "0000000002") }, time.Second, 5*time.Millisecond)
+}
+
+// newStartedUploader starts an uploader between two local stores, writes to the local store
+// must be notified with `fileStored` to be uploaded before the next scan
+func newStartedUploader(t *testing.T, options ...FileUploaderOption) (uploader *FileUploader, local, destination dstore.Store) {
+	t.Helper()
+
+	local, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	destination, err = dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+
+	uploader = NewFileUploader(local, destination, testLogger, options...)
+	go uploader.Start(context.Background())
+	t.Cleanup(func() { uploader.Shutdown(nil) })
+	return uploader, local, destination
+}
+
+func TestFileUploader_StoredFileUploadedRightAway(t *testing.T) {
+	ctx := context.Background()
+	uploader, local, destination := newStartedUploader(t, FileUploaderPollInterval(time.Hour), FileUploaderScanInterval(time.Hour))
+
+	for i := 1; i <= 3; i++ {
+		filename := fmt.Sprintf("%010d", i)
+		require.NoError(t, local.WriteObject(ctx, filename, strings.NewReader("block")))
+
+		stored := time.Now()
+		uploader.fileStored(filename)
+		require.Eventually(t, func() bool {
+			found, err := destination.FileExists(ctx, filename)
+			return err == nil && found
+		}, 100*time.Millisecond, time.Millisecond, "uploaded without waiting for a scan")
+		t.Logf("file %s uploaded %s after being stored", filename, time.Since(stored))
+	}
+}
+
+func TestFileUploader_ScanUploadsFilesNotNotified(t *testing.T) {
+	ctx := context.Background()
+	local, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, local.WriteObject(ctx, "0000000001", strings.NewReader("block")))
+
+	uploader, _, destination := newStartedUploader(t, FileUploaderPollInterval(time.Hour), FileUploaderScanInterval(20*time.Millisecond))
+	uploaded := func(filename string) func() bool {
+		return func() bool {
+			found, err := destination.FileExists(ctx, filename)
+			return err == nil && found
+		}
+	}
+
+	require.NoError(t, uploader.localStore.WriteObject(ctx, "0000000002", strings.NewReader("block")))
+	assert.Eventually(t, uploaded("0000000002"), time.Second, 5*time.Millisecond, "found by the periodic scan")
+
+	// A file notified, then uploaded by a scan before the notified pass, is skipped by it
+	require.NoError(t, uploader.localStore.WriteObject(ctx, "0000000003", strings.NewReader("block")))
+	_, err = uploader.uploadAllFiles(ctx)
+	require.NoError(t, err)
+	uploader.fileStored("0000000003")
+	_, err = uploader.uploadStoredFiles(ctx)
+	assert.NoError(t, err)
+	assert.True(t, uploaded("0000000003")())
+}
+
+func BenchmarkFileUploader_StoredFileUploadLatency(b *testing.B) {
+	ctx := context.Background()
+	local, err := dstore.NewDBinStore(b.TempDir())
+	require.NoError(b, err)
+	destination, err := dstore.NewDBinStore(b.TempDir())
+	require.NoError(b, err)
+
+	uploaded := make(chan struct{}, 1)
+	uploader := NewFileUploader(local, destination, testLogger, FileUploaderOnUploaded(func(string) { uploaded <- struct{}{} }))
+	go uploader.Start(ctx)
+	defer uploader.Shutdown(nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filename := fmt.Sprintf("%010d", i)
+		require.NoError(b, local.WriteObject(ctx, filename, strings.NewReader("block")))
+		uploader.fileStored(filename)
+		<-uploaded
+	}
+}
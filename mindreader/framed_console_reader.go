@@ -0,0 +1,214 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultFramePrefix is the prefix of the lines framed by the nodes' deep mind instrumentation
+const DefaultFramePrefix = "DMLOG "
+
+// framedReaderRetainedBuffer is the largest line buffer kept between two lines, a larger one
+// (of an exceptionally long line) is released once the line is handled
+const framedReaderRetainedBuffer = 4 * 1024 * 1024
+
+// FrameHandler parses the frame of a `FramedConsoleReader` starting with its registered token,
+// `payload` is what follows the token and its separating space. The object returned, when not
+// nil, is returned by `Read`.
+type FrameHandler func(token string, payload string) (interface{}, error)
+
+type FramedConsoleReaderOption func(r *FramedConsoleReader)
+
+// FramedPrefix sets the prefix framed lines start with, `DefaultFramePrefix` by default
+func FramedPrefix(prefix string) FramedConsoleReaderOption {
+	return func(r *FramedConsoleReader) {
+		r.prefix = prefix
+	}
+}
+
+// FramedRawLineHandler calls `f` with every line not handled, either not starting with the
+// prefix or with a token without handler, the trailing line break removed
+func FramedRawLineHandler(f func(line string)) FramedConsoleReaderOption {
+	return func(r *FramedConsoleReader) {
+		r.onRawLine = f
+	}
+}
+
+// FramedConsoleReaderStats counts the lines read by a `FramedConsoleReader`
+type FramedConsoleReaderStats struct {
+	Lines         uint64 // every line read
+	Frames        uint64 // lines given to a handler
+	UnframedLines uint64 // lines without the prefix
+	UnknownFrames uint64 // lines with the prefix but a token without handler
+}
+
+// FramedConsoleReader does the chain-agnostic part of a console reader: it reads lines of any
+// length from a reader, keeps those starting with the frame prefix (`DMLOG ` by default) and
+// routes each of them, by the token following the prefix (e.g. `BLOCK` in `DMLOG BLOCK ...`), to
+// the handler registered for it. Chain integrations only write the handlers of their frames,
+// see `RegisterFrameHandler`. It is not safe for concurrent use.
+type FramedConsoleReader struct {
+	reader    *bufio.Reader
+	prefix    string
+	handlers  map[string]FrameHandler
+	onRawLine func(line string)
+
+	buf   []byte
+	stats FramedConsoleReaderStats
+}
+
+func NewFramedConsoleReader(reader io.Reader, options ...FramedConsoleReaderOption) *FramedConsoleReader {
+	r := &FramedConsoleReader{
+		reader:   bufio.NewReaderSize(reader, 64*1024),
+		prefix:   DefaultFramePrefix,
+		handlers: map[string]FrameHandler{},
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// RegisterFrameHandler routes the frames starting with `token` to `handler`
+func (r *FramedConsoleReader) RegisterFrameHandler(token string, handler FrameHandler) error {
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return fmt.Errorf("invalid frame token %q, must be a non-empty word", token)
+	}
+	if _, found := r.handlers[token]; found {
+		return fmt.Errorf("frame token %q already has a handler", token)
+	}
+
+	r.handlers[token] = handler
+	return nil
+}
+
+// Read handles lines until a handler returns an object, which is returned. It returns `io.EOF`
+// once the reader is exhausted, or the error of a handler, naming its token and line.
+func (r *FramedConsoleReader) Read() (interface{}, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := r.handleLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			return obj, nil
+		}
+	}
+}
+
+// Stats returns the counts of the lines read so far
+func (r *FramedConsoleReader) Stats() FramedConsoleReaderStats {
+	return r.stats
+}
+
+func (r *FramedConsoleReader) handleLine(line string) (interface{}, error) {
+	r.stats.Lines++
+	if !strings.HasPrefix(line, r.prefix) {
+		r.stats.UnframedLines++
+		r.rawLine(line)
+		return nil, nil
+	}
+
+	frame := line[len(r.prefix):]
+	token, payload := frame, ""
+	if i := strings.IndexByte(frame, ' '); i >= 0 {
+		token, payload = frame[:i], frame[i+1:]
+	}
+
+	handler, found := r.handlers[token]
+	if !found {
+		r.stats.UnknownFrames++
+		r.rawLine(line)
+		return nil, nil
+	}
+
+	r.stats.Frames++
+	obj, err := handler(token, payload)
+	if err != nil {
+		return nil, fmt.Errorf("handling %s frame at line %d: %w", token, r.stats.Lines, err)
+	}
+	return obj, nil
+}
+
+func (r *FramedConsoleReader) rawLine(line string) {
+	if r.onRawLine != nil {
+		r.onRawLine(line)
+	}
+}
+
+// readLine returns the next line, without its line break, accumulating the chunks of lines longer
+// than the reader's buffer. The last line does not need a line break.
+func (r *FramedConsoleReader) readLine() (string, error) {
+	r.buf = r.buf[:0]
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		r.buf = append(r.buf, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(r.buf) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	line := string(bytes.TrimRight(r.buf, "\r\n"))
+	if cap(r.buf) > framedReaderRetainedBuffer {
+		r.buf = nil
+	}
+	return line, nil
+}
+
+// LinesReader returns a reader of `lines`, each followed by a line break, for a
+// `FramedConsoleReader` reading the lines given to a `ConsolerReaderFactory`. The reader returns
+// `io.EOF` once `lines` is closed.
+func LinesReader(lines <-chan string) io.Reader {
+	return &linesReader{lines: lines}
+}
+
+type linesReader struct {
+	lines   <-chan string
+	pending []byte
+}
+
+func (r *linesReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		line, ok := <-r.lines
+		if !ok {
+			return 0, io.EOF
+		}
+		r.pending = append(append(r.pending[:0], line...), '\n')
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
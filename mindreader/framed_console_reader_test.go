@@ -0,0 +1,132 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFrame struct {
+	token   string
+	payload string
+}
+
+func newTestFramedConsoleReader(t *testing.T, input io.Reader, options ...FramedConsoleReaderOption) *FramedConsoleReader {
+	t.Helper()
+
+	r := NewFramedConsoleReader(input, options...)
+	for _, token := range []string{"BLOCK", "TRX_BEGIN"} {
+		require.NoError(t, r.RegisterFrameHandler(token, func(token string, payload string) (interface{}, error) {
+			return testFrame{token, payload}, nil
+		}))
+	}
+	return r
+}
+
+func readFrames(t *testing.T, r *FramedConsoleReader) (out []interface{}) {
+	t.Helper()
+
+	for {
+		obj, err := r.Read()
+		if err == io.EOF {
+			return
+		}
+		require.NoError(t, err)
+		out = append(out, obj)
+	}
+}
+
+func TestFramedConsoleReader(t *testing.T) {
+	input := strings.Join([]string{
+		"starting node",
+		"DMLOG BLOCK 1 aa",
+		"DMLOG UNKNOWN 12",
+		"INFO DMLOG BLOCK not at the start",
+		"DMLOG TRX_BEGIN",
+		"DMLOG BLOCK 2 bb\r",
+		"DMLOG BLOCK 3 cc", // no trailing line break
+	}, "\n")
+
+	var raw []string
+	r := newTestFramedConsoleReader(t, strings.NewReader(input), FramedRawLineHandler(func(line string) {
+		raw = append(raw, line)
+	}))
+
+	assert.Equal(t, []interface{}{
+		testFrame{"BLOCK", "1 aa"},
+		testFrame{"TRX_BEGIN", ""},
+		testFrame{"BLOCK", "2 bb"},
+		testFrame{"BLOCK", "3 cc"},
+	}, readFrames(t, r))
+
+	assert.Equal(t, []string{"starting node", "DMLOG UNKNOWN 12", "INFO DMLOG BLOCK not at the start"}, raw)
+	assert.Equal(t, FramedConsoleReaderStats{Lines: 7, Frames: 4, UnframedLines: 2, UnknownFrames: 1}, r.Stats())
+}
+
+func TestFramedConsoleReader_LongLines(t *testing.T) {
+	payload := strings.Repeat("ab", 5*1024*1024) // 10MB
+	input := "DMLOG BLOCK " + payload + "\nnoise\nDMLOG OTHER " + payload + "\nDMLOG BLOCK small\n"
+
+	r := newTestFramedConsoleReader(t, strings.NewReader(input))
+	frames := readFrames(t, r)
+	require.Len(t, frames, 2)
+	assert.Equal(t, len(payload), len(frames[0].(testFrame).payload))
+	assert.True(t, frames[0].(testFrame).payload == payload)
+	assert.Equal(t, testFrame{"BLOCK", "small"}, frames[1])
+	assert.LessOrEqual(t, cap(r.buf), framedReaderRetainedBuffer, "buffer of the long lines released")
+}
+
+func TestFramedConsoleReader_Prefix(t *testing.T) {
+	var handled []string
+	r := NewFramedConsoleReader(strings.NewReader("DMLOG BLOCK 1\nFIRE BLOCK 2\nFIRE BLOCKX 3\n"), FramedPrefix("FIRE "))
+	require.NoError(t, r.RegisterFrameHandler("BLOCK", func(_ string, payload string) (interface{}, error) {
+		handled = append(handled, payload)
+		return nil, nil // no object, reading continues
+	}))
+
+	_, err := r.Read()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"2"}, handled)
+	assert.Equal(t, FramedConsoleReaderStats{Lines: 3, Frames: 1, UnframedLines: 1, UnknownFrames: 1}, r.Stats())
+}
+
+func TestFramedConsoleReader_HandlerError(t *testing.T) {
+	r := NewFramedConsoleReader(strings.NewReader("noise\nDMLOG BLOCK bad\n"))
+	require.NoError(t, r.RegisterFrameHandler("BLOCK", func(_ string, _ string) (interface{}, error) {
+		return nil, errors.New("invalid block")
+	}))
+	assert.Error(t, r.RegisterFrameHandler("BLOCK", nil), "already registered")
+	assert.Error(t, r.RegisterFrameHandler("TWO WORDS", nil))
+
+	_, err := r.Read()
+	assert.EqualError(t, err, "handling BLOCK frame at line 2: invalid block")
+}
+
+func TestFramedConsoleReader_LinesReader(t *testing.T) {
+	lines := make(chan string, 3)
+	lines <- "DMLOG BLOCK 1"
+	lines <- "noise"
+	lines <- "DMLOG TRX_BEGIN 2"
+	close(lines)
+
+	r := newTestFramedConsoleReader(t, LinesReader(lines))
+	assert.Equal(t, []interface{}{testFrame{"BLOCK", "1"}, testFrame{"TRX_BEGIN", "2"}}, readFrames(t, r))
+}
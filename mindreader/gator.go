@@ -15,9 +15,17 @@
 package mindreader
 
 import (
+	"time"
+
 	"github.com/streamingfast/bstream"
 )
 
+// Gate decides from which block on the mindreader processes blocks, discarding the ones before.
+// Once passed, a gate stays open for every following block.
+type Gate interface {
+	pass(block *bstream.Block) bool
+}
+
 type BlockNumberGate struct {
 	passed   bool
 	blockNum uint64
@@ -37,3 +45,83 @@ func (g *BlockNumberGate) pass(block *bstream.Block) bool {
 	g.passed = block.Num() >= g.blockNum
 	return g.passed
 }
+
+// BlockTimestampGate passes from the first block whose time is at or after its time. A block
+// without a timestamp never opens it.
+type BlockTimestampGate struct {
+	passed    bool
+	timestamp time.Time
+}
+
+func NewBlockTimestampGate(timestamp time.Time) *BlockTimestampGate {
+	return &BlockTimestampGate{
+		timestamp: timestamp,
+	}
+}
+
+func (g *BlockTimestampGate) pass(block *bstream.Block) bool {
+	if g.passed {
+		return true
+	}
+
+	blockTime := block.Time()
+	g.passed = !blockTime.IsZero() && !blockTime.Before(g.timestamp)
+	return g.passed
+}
+
+// AndGate passes from the first block for which all of its gates passed
+type AndGate struct {
+	passed bool
+	gates  []Gate
+}
+
+func NewAndGate(gates ...Gate) *AndGate {
+	return &AndGate{
+		gates: gates,
+	}
+}
+
+func (g *AndGate) pass(block *bstream.Block) bool {
+	if g.passed {
+		return true
+	}
+
+	// Every gate sees the block, so each one latches on its own
+	passed := true
+	for _, gate := range g.gates {
+		if !gate.pass(block) {
+			passed = false
+		}
+	}
+
+	g.passed = passed
+	return g.passed
+}
+
+// OrGate passes from the first block for which one of its gates passed
+type OrGate struct {
+	passed bool
+	gates  []Gate
+}
+
+func NewOrGate(gates ...Gate) *OrGate {
+	return &OrGate{
+		gates: gates,
+	}
+}
+
+func (g *OrGate) pass(block *bstream.Block) bool {
+	if g.passed {
+		return true
+	}
+
+	passed := false
+	for _, gate := range g.gates {
+		if gate.pass(block) {
+			passed = true
+		}
+	}
+
+	g.passed = passed
+	return g.passed
+}
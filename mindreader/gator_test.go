@@ -0,0 +1,83 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func gateBlock(num uint64, timestamp time.Time) *bstream.Block {
+	return &bstream.Block{Number: num, Timestamp: timestamp}
+}
+
+func TestBlockNumberGate(t *testing.T) {
+	g := NewBlockNumberGate(10)
+
+	assert.False(t, g.pass(gateBlock(9, time.Time{})))
+	assert.True(t, g.pass(gateBlock(10, time.Time{})))
+	assert.True(t, g.pass(gateBlock(5, time.Time{})), "stays open once passed")
+}
+
+func TestBlockTimestampGate(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("passes at or after timestamp", func(t *testing.T) {
+		g := NewBlockTimestampGate(start)
+
+		assert.False(t, g.pass(gateBlock(1, start.Add(-time.Second))))
+		assert.True(t, g.pass(gateBlock(2, start)))
+		assert.True(t, g.pass(gateBlock(3, start.Add(-time.Hour))), "stays open once passed")
+	})
+
+	t.Run("zero timestamp never passes", func(t *testing.T) {
+		g := NewBlockTimestampGate(time.Time{})
+
+		assert.False(t, g.pass(gateBlock(1, time.Time{})))
+		assert.True(t, g.pass(gateBlock(2, start)))
+	})
+}
+
+func TestAndGate(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := NewAndGate(NewBlockNumberGate(10), NewBlockTimestampGate(start))
+
+	assert.False(t, g.pass(gateBlock(10, start.Add(-time.Second))), "timestamp gate closed")
+	assert.False(t, g.pass(gateBlock(8, start.Add(-time.Second))), "both gates closed, number gate stays open")
+	assert.True(t, g.pass(gateBlock(9, start)), "number gate latched on block 10")
+	assert.True(t, g.pass(gateBlock(1, time.Time{})), "stays open once passed")
+}
+
+func TestOrGate(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("passes on block number", func(t *testing.T) {
+		g := NewOrGate(NewBlockNumberGate(10), NewBlockTimestampGate(start))
+
+		assert.False(t, g.pass(gateBlock(9, start.Add(-time.Second))))
+		assert.True(t, g.pass(gateBlock(10, start.Add(-time.Second))))
+		assert.True(t, g.pass(gateBlock(1, time.Time{})), "stays open once passed")
+	})
+
+	t.Run("passes on timestamp", func(t *testing.T) {
+		g := NewOrGate(NewBlockNumberGate(10), NewBlockTimestampGate(start))
+
+		assert.False(t, g.pass(gateBlock(1, time.Time{})))
+		assert.True(t, g.pass(gateBlock(2, start)))
+	})
+}
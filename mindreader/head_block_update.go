@@ -0,0 +1,56 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+// OnHeadBlockUpdate subscribes `f` to every block read from the node, after transformation and
+// before it is sent to the archiver, like the `headBlockUpdateFunc` of the constructor which is the
+// first subscriber. Subscribers are called in order, synchronously on the read flow, and must be
+// registered before the plugin is launched.
+func (p *MindReaderPlugin) OnHeadBlockUpdate(f nodeManager.HeadBlockUpdater) {
+	if f == nil {
+		return
+	}
+	p.headBlockUpdaters = append(p.headBlockUpdaters, f)
+}
+
+// updateHeadBlock calls every head block subscriber, a panicking one is logged and skipped
+func (p *MindReaderPlugin) updateHeadBlock(num uint64, id string, t time.Time, libNum uint64) {
+	for i, f := range p.headBlockUpdaters {
+		p.callHeadBlockUpdater(i, f, num, id, t, libNum)
+	}
+}
+
+func (p *MindReaderPlugin) callHeadBlockUpdater(index int, f nodeManager.HeadBlockUpdater, num uint64, id string, t time.Time, libNum uint64) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.zlogger.Error("head block update subscriber panicked, skipping it for this block",
+				zap.Int("subscriber_index", index),
+				zap.Uint64("block_num", num),
+				zap.String("block_id", id),
+				zap.String("panic", fmt.Sprint(r)),
+			)
+		}
+	}()
+
+	f(num, id, t, libNum)
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type headBlockUpdate struct {
+	num    uint64
+	id     string
+	time   time.Time
+	libNum uint64
+}
+
+func newHeadBlockUpdateTestPlugin(lines chan string) *MindReaderPlugin {
+	return newReadTestPlugin(lines, newTestConsoleReader(lines))
+}
+
+func recordHeadBlockUpdates(updates *[]headBlockUpdate) func(num uint64, id string, t time.Time, libNum uint64) {
+	return func(num uint64, id string, t time.Time, libNum uint64) {
+		*updates = append(*updates, headBlockUpdate{num, id, t, libNum})
+	}
+}
+
+func TestMindReaderPlugin_OnHeadBlockUpdate(t *testing.T) {
+	lines := make(chan string, 2)
+	blocks := make(chan *bstream.Block, 2)
+
+	var order []string
+	var first, second, third []headBlockUpdate
+	p := newHeadBlockUpdateTestPlugin(lines)
+	p.OnHeadBlockUpdate(func(num uint64, id string, t time.Time, libNum uint64) {
+		order = append(order, "first")
+		recordHeadBlockUpdates(&first)(num, id, t, libNum)
+	})
+	p.OnHeadBlockUpdate(func(num uint64, id string, t time.Time, libNum uint64) {
+		order = append(order, "second")
+		recordHeadBlockUpdates(&second)(num, id, t, libNum)
+	})
+	p.OnHeadBlockUpdate(nil)
+	p.OnHeadBlockUpdate(func(num uint64, id string, t time.Time, libNum uint64) {
+		order = append(order, "third")
+		recordHeadBlockUpdates(&third)(num, id, t, libNum)
+	})
+
+	p.LogLine(`DMLOG {"id":"00000001a"}`)
+	p.LogLine(`DMLOG {"id":"00000002a"}`)
+	require.NoError(t, p.readOneMessage(blocks))
+	require.NoError(t, p.readOneMessage(blocks))
+
+	require.Len(t, first, 2)
+	assert.Equal(t, uint64(1), first[0].num)
+	assert.Equal(t, "00000001a", first[0].id)
+	assert.Equal(t, uint64(2), first[1].num)
+	assert.Equal(t, "00000002a", first[1].id)
+	assert.Equal(t, first, second)
+	assert.Equal(t, first, third)
+	assert.Equal(t, []string{"first", "second", "third", "first", "second", "third"}, order)
+}
+
+func TestMindReaderPlugin_OnHeadBlockUpdate_ConstructorSubscriberFirst(t *testing.T) {
+	var order []string
+	p, err := newMindReaderPlugin(nil, 0, 0, 0, func(num uint64, id string, t time.Time, libNum uint64) {
+		order = append(order, "constructor")
+	}, nil, testLogger)
+	require.NoError(t, err)
+	p.OnHeadBlockUpdate(func(num uint64, id string, t time.Time, libNum uint64) {
+		order = append(order, "registered")
+	})
+
+	p.updateHeadBlock(1, "00000001a", time.Time{}, 0)
+	assert.Equal(t, []string{"constructor", "registered"}, order)
+}
+
+func TestMindReaderPlugin_OnHeadBlockUpdate_PanickingSubscriber(t *testing.T) {
+	lines := make(chan string, 2)
+	blocks := make(chan *bstream.Block, 2)
+
+	var before, after []headBlockUpdate
+	p := newHeadBlockUpdateTestPlugin(lines)
+	p.OnHeadBlockUpdate(recordHeadBlockUpdates(&before))
+	p.OnHeadBlockUpdate(func(num uint64, id string, t time.Time, libNum uint64) {
+		panic("subscriber failure")
+	})
+	p.OnHeadBlockUpdate(recordHeadBlockUpdates(&after))
+
+	p.LogLine(`DMLOG {"id":"00000001a"}`)
+	p.LogLine(`DMLOG {"id":"00000002a"}`)
+	require.NoError(t, p.readOneMessage(blocks))
+	require.NoError(t, p.readOneMessage(blocks))
+	close(blocks)
+
+	var received []uint64
+	for block := range blocks {
+		received = append(received, block.Number)
+	}
+
+	assert.Equal(t, []uint64{1, 2}, received)
+	require.Len(t, after, 2)
+	assert.Equal(t, before, after)
+	assert.False(t, p.IsTerminating())
+}
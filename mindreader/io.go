@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/streamingfast/bstream"
@@ -11,6 +13,7 @@ import (
 	"github.com/streamingfast/logging"
 	"github.com/streamingfast/merger"
 	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/metrics"
 	"go.uber.org/zap"
 )
 
@@ -41,6 +44,12 @@ type ArchiverDStoreIO struct {
 	uploadableOneBlockStore     dstore.Store
 	uploadableMergedBlocksStore dstore.Store
 	logger                      *zap.Logger
+
+	oneBlockPartitionWidth uint64                // see `WithOneBlockFilePartitioning`
+	failOnExistingOneBlock bool                  // see `OneBlockOverwriteFail`
+	onOneBlockUploaded     func(filename string) // of the mergeable files sent as one block files, see `WithWatermark`
+	onOneBlockFileStored   func(filename string) // of the files written to the uploadable one block store
+	mergedBundleRecorder   *mergedBundleSizeRecorder
 }
 
 func NewArchiverDStoreIO(
@@ -61,6 +70,7 @@ func NewArchiverDStoreIO(
 ) *ArchiverDStoreIO {
 	deleter := merger.NewOneBlockFilesDeleter(logger, mergeableOneBlockStore)
 	deleter.Start(2, maxOneBlockOperationsBatchSize)
+	mergedBundleRecorder := &mergedBundleSizeRecorder{Store: uploadableMergedBlocksStore}
 
 	return &ArchiverDStoreIO{
 		blockWriterFactory:          blockWriterFactory,
@@ -71,35 +81,62 @@ func NewArchiverDStoreIO(
 		oneBlockStore:               oneBlocksStore,
 		mergedBlocksStore:           mergedBlocksStore,
 		OneBlockFilesDeleter:        deleter,
-		DStoreIO:                    merger.NewDStoreIO(logger, tracer, mergeableOneBlockStore, uploadableMergedBlocksStore, retryAttempts, retryCooldown, lowestPossibleBlock, bundleSize),
+		DStoreIO:                    merger.NewDStoreIO(logger, tracer, mergeableOneBlockStore, mergedBundleRecorder, retryAttempts, retryCooldown, lowestPossibleBlock, bundleSize),
+		mergedBundleRecorder:        mergedBundleRecorder,
 		logger:                      logger,
 	}
 }
 
+// notifyStoredFiles notifies the uploaders of the one block files and merged blocks bundles
+// written to their local store, so they upload them right away
+func (m *ArchiverDStoreIO) notifyStoredFiles(oneBlocks, mergedBlocks *FileUploader) {
+	m.onOneBlockFileStored = oneBlocks.fileStored
+	m.mergedBundleRecorder.onStored = mergedBlocks.fileStored
+}
+
 func (m *ArchiverDStoreIO) StoreOneBlockFile(ctx context.Context, fileName string, block *bstream.Block) error {
-	return m.storeOneBlockFile(ctx, fileName, block, m.uploadableOneBlockStore)
+	fileName = partitionedFileName(fileName, m.oneBlockPartitionWidth)
+	size, err := m.storeOneBlockFile(ctx, fileName, block, m.uploadableOneBlockStore)
+	if err != nil {
+		return err
+	}
+
+	metrics.ObserveFileProduced(metrics.FileTypeOneBlock, size)
+	if m.onOneBlockFileStored != nil {
+		m.onOneBlockFileStored(fileName)
+	}
+	return nil
 }
 
 func (m *ArchiverDStoreIO) StoreMergeableOneBlockFile(ctx context.Context, fileName string, block *bstream.Block) error {
-	return m.storeOneBlockFile(ctx, fileName, block, m.mergeableOneBlockStore)
+	_, err := m.storeOneBlockFile(ctx, fileName, block, m.mergeableOneBlockStore)
+	return err
 }
 
-func (m *ArchiverDStoreIO) storeOneBlockFile(ctx context.Context, fileName string, block *bstream.Block, store dstore.Store) error {
+func (m *ArchiverDStoreIO) storeOneBlockFile(ctx context.Context, fileName string, block *bstream.Block, store dstore.Store) (size int64, err error) {
 	buffer := bytes.NewBuffer(nil)
 	blockWriter, err := m.blockWriterFactory.New(buffer)
 	if err != nil {
-		return fmt.Errorf("write block factory: %w", err)
+		return 0, fmt.Errorf("write block factory: %w", err)
 	}
 
 	if err := blockWriter.Write(block); err != nil {
-		return fmt.Errorf("write block: %w", err)
+		return 0, fmt.Errorf("write block: %w", err)
 	}
 
-	return store.WriteObject(ctx, fileName, buffer)
+	written := int64(buffer.Len())
+	if err := store.WriteObject(ctx, fileName, buffer); err != nil {
+		return 0, err
+	}
+	return writtenFileSize(store, fileName, written), nil
 }
 
 func (m *ArchiverDStoreIO) SendMergeableAsOneBlockFiles(ctx context.Context) error {
-	uploader := NewFileUploader(m.mergeableOneBlockStore, m.oneBlockStore, m.logger)
+	options := []FileUploaderOption{FileUploaderPartitioning(m.oneBlockPartitionWidth), FileUploaderOnUploaded(m.onOneBlockUploaded)}
+	if m.failOnExistingOneBlock {
+		options = append(options, FileUploaderFailOnExistingFile())
+	}
+	uploader := NewFileUploader(m.mergeableOneBlockStore, m.oneBlockStore, m.logger, options...)
 	return uploader.uploadFiles(ctx)
 }
 
@@ -136,3 +173,44 @@ func (m *ArchiverDStoreIO) WalkMergeableOneBlockFiles(ctx context.Context) (out
 
 	return
 }
+
+// mergedBundleSizeRecorder records the size of the merged blocks bundles written by the merger
+// to the uploadable merged blocks store, and notifies their uploader
+type mergedBundleSizeRecorder struct {
+	dstore.Store
+	onStored func(filename string)
+}
+
+func (r *mergedBundleSizeRecorder) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	counter := &countingReader{Reader: f}
+	if err := r.Store.WriteObject(ctx, base, counter); err != nil {
+		return err
+	}
+
+	metrics.ObserveFileProduced(metrics.FileTypeMerged, writtenFileSize(r.Store, base, counter.count))
+	if r.onStored != nil {
+		r.onStored(base)
+	}
+	return nil
+}
+
+// writtenFileSize returns the size of the file `name` of the local `store`, compressed, or
+// `written`, the bytes written to the store, when it cannot be known
+func writtenFileSize(store dstore.Store, name string, written int64) int64 {
+	info, err := os.Stat(store.ObjectPath(name))
+	if err != nil {
+		return written
+	}
+	return info.Size()
+}
+
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.count += int64(n)
+	return
+}
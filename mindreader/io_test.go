@@ -1,84 +1,135 @@
 package mindreader
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
 	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
-type TestArchiverIO struct {
-	MergeAndStoreFunc            func(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error)
-	FetchMergedOneBlockFilesFunc func(lowBlockNum uint64) ([]*bundle.OneBlockFile, error)
-	WalkOneBlockFilesFunc        func(ctx context.Context, callback func(*bundle.OneBlockFile) error) (err error)
-	DownloadOneBlockFileFunc     func(ctx context.Context, oneBlockFile *bundle.OneBlockFile) (data []byte, err error)
-
-	StoreOneBlockFileFunc            func(ctx context.Context, fileName string, block *bstream.Block) error
-	StoreMergeableOneBlockFileFunc   func(ctx context.Context, fileName string, block *bstream.Block) error
-	DeleteOneBlockFilesFunc          func(oneBlockFiles []*bundle.OneBlockFile)
-	WalkMergeableOneBlockFilesFunc   func(ctx context.Context) ([]*bundle.OneBlockFile, error)
-	SendMergeableAsOneBlockFilesFunc func(ctx context.Context) error
-}
+func histogramSnapshot(t *testing.T, h prometheus.Histogram) *dto.Histogram {
+	t.Helper()
 
-func (io *TestArchiverIO) SendMergeableAsOneBlockFiles(ctx context.Context) error {
-	if io.SendMergeableAsOneBlockFilesFunc == nil {
-		return nil
-	}
-	return io.SendMergeableAsOneBlockFilesFunc(ctx)
+	out := &dto.Metric{}
+	require.NoError(t, h.Write(out))
+	return out.Histogram
 }
 
-func (io *TestArchiverIO) MergeAndStore(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) (err error) {
-	if io.MergeAndStoreFunc == nil {
-		return nil
+func bucketCount(h *dto.Histogram, upperBound float64) uint64 {
+	for _, b := range h.Bucket {
+		if b.GetUpperBound() == upperBound {
+			return b.GetCumulativeCount()
+		}
 	}
-	return io.MergeAndStoreFunc(inclusiveLowerBlock, oneBlockFiles)
+	return 0
 }
 
-func (io *TestArchiverIO) FetchMergedOneBlockFiles(lowBlockNum uint64) ([]*bundle.OneBlockFile, error) {
-	if io.FetchMergedOneBlockFilesFunc == nil {
-		return nil, nil
-	}
-	return io.FetchMergedOneBlockFilesFunc(lowBlockNum)
-}
+func fileSize(t *testing.T, store dstore.Store, name string) int64 {
+	t.Helper()
 
-func (io *TestArchiverIO) WalkOneBlockFiles(ctx context.Context, callback func(*bundle.OneBlockFile) error) (err error) {
-	if io.WalkOneBlockFilesFunc == nil {
-		return nil
-	}
-	return io.WalkOneBlockFilesFunc(ctx, callback)
+	info, err := os.Stat(store.ObjectPath(name))
+	require.NoError(t, err)
+	return info.Size()
 }
 
-func (io *TestArchiverIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bundle.OneBlockFile) (data []byte, err error) {
-	if io.DownloadOneBlockFileFunc == nil {
-		return nil, nil
-	}
-	return io.DownloadOneBlockFileFunc(ctx, oneBlockFile)
-}
+// randomPayloadBlock returns a block with a random payload of `size` bytes, which does not
+// compress, its block file is a bit larger than the payload
+func randomPayloadBlock(t *testing.T, num uint64, size int) *bstream.Block {
+	t.Helper()
 
-func (io *TestArchiverIO) StoreOneBlockFile(ctx context.Context, fileName string, block *bstream.Block) error {
-	if io.StoreOneBlockFileFunc == nil {
-		return nil
-	}
-	return io.StoreOneBlockFileFunc(ctx, fileName, block)
+	payload := make([]byte, size)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	block, err := bstream.MemoryBlockPayloadSetter(&bstream.Block{Number: num, Id: fmt.Sprintf("%08da", num), PreviousId: fmt.Sprintf("%08da", num-1)}, payload)
+	require.NoError(t, err)
+	return block
 }
-func (io *TestArchiverIO) StoreMergeableOneBlockFile(ctx context.Context, fileName string, block *bstream.Block) error {
-	if io.StoreMergeableOneBlockFileFunc == nil {
-		return nil
+
+func TestArchiverDStoreIO_RecordsFileSizes(t *testing.T) {
+	newStore := func() dstore.Store {
+		store, err := dstore.NewDBinStore(t.TempDir())
+		require.NoError(t, err)
+		return store
 	}
-	return io.StoreMergeableOneBlockFileFunc(ctx, fileName, block)
-}
+	uploadableOneBlocks, uploadableMerged := newStore(), newStore()
+
+	writerFactory := bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+	archiverIO := NewArchiverDStoreIO(writerFactory, dbinReaderFactory, newStore(), uploadableOneBlocks, newStore(), uploadableMerged, newStore(), 250, 1, time.Millisecond, 0, 100, zap.NewNop(), nil)
+
+	ctx := context.Background()
+	before := histogramSnapshot(t, metrics.OneBlockFileBytes)
 
-func (io *TestArchiverIO) Delete(oneBlockFiles []*bundle.OneBlockFile) {
-	if io.DeleteOneBlockFilesFunc == nil {
-		return
+	small, large := randomPayloadBlock(t, 1, 2000), randomPayloadBlock(t, 2, 100000)
+	for _, block := range []*bstream.Block{small, large} {
+		require.NoError(t, archiverIO.StoreOneBlockFile(ctx, bundle.BlockFileNameWithSuffix(block, "suffix"), block))
 	}
-	io.DeleteOneBlockFilesFunc(oneBlockFiles)
+	mergeable := randomPayloadBlock(t, 3, 2000)
+	require.NoError(t, archiverIO.StoreMergeableOneBlockFile(ctx, bundle.BlockFileNameWithSuffix(mergeable, "suffix"), mergeable))
+
+	after := histogramSnapshot(t, metrics.OneBlockFileBytes)
+	smallSize := fileSize(t, uploadableOneBlocks, bundle.BlockFileNameWithSuffix(small, "suffix"))
+	largeSize := fileSize(t, uploadableOneBlocks, bundle.BlockFileNameWithSuffix(large, "suffix"))
+	assert.Greater(t, smallSize, int64(2000))
+	assert.Greater(t, largeSize, int64(100000))
+
+	assert.Equal(t, uint64(2), after.GetSampleCount()-before.GetSampleCount(), "mergeable one block files are not produced files")
+	assert.Equal(t, float64(smallSize+largeSize), after.GetSampleSum()-before.GetSampleSum())
+	assert.Equal(t, uint64(1), bucketCount(after, 4096)-bucketCount(before, 4096), "small file in the 4KiB bucket")
+	assert.Equal(t, uint64(1), bucketCount(after, 65536)-bucketCount(before, 65536), "large file above the 64KiB bucket")
+	assert.Equal(t, uint64(2), bucketCount(after, 262144)-bucketCount(before, 262144), "large file in the 256KiB bucket")
+
+	before = histogramSnapshot(t, metrics.MergedBundleBytes)
+	bundleContent := make([]byte, 300000)
+	_, err := rand.Read(bundleContent)
+	require.NoError(t, err)
+	require.NoError(t, (&mergedBundleSizeRecorder{Store: uploadableMerged}).WriteObject(ctx, "0000000000", bytes.NewReader(bundleContent)))
+
+	after = histogramSnapshot(t, metrics.MergedBundleBytes)
+	assert.Equal(t, uint64(1), after.GetSampleCount()-before.GetSampleCount())
+	assert.Equal(t, float64(fileSize(t, uploadableMerged, "0000000000")), after.GetSampleSum()-before.GetSampleSum())
 }
 
-func (io *TestArchiverIO) WalkMergeableOneBlockFiles(ctx context.Context) ([]*bundle.OneBlockFile, error) {
-	if io.WalkMergeableOneBlockFilesFunc == nil {
-		return nil, nil
+func TestArchiverDStoreIO_NotifiesStoredFiles(t *testing.T) {
+	newStore := func() dstore.Store {
+		store, err := dstore.NewDBinStore(t.TempDir())
+		require.NoError(t, err)
+		return store
 	}
+	uploadableOneBlocks, uploadableMerged := newStore(), newStore()
+
+	writerFactory := bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+	archiverIO := NewArchiverDStoreIO(writerFactory, dbinReaderFactory, newStore(), uploadableOneBlocks, newStore(), uploadableMerged, newStore(), 250, 1, time.Millisecond, 0, 100, zap.NewNop(), nil)
+
+	oneBlocksUploader := NewFileUploader(uploadableOneBlocks, newStore(), testLogger)
+	mergedUploader := NewFileUploader(uploadableMerged, newStore(), testLogger)
+	archiverIO.notifyStoredFiles(oneBlocksUploader, mergedUploader)
+
+	ctx := context.Background()
+	block := randomPayloadBlock(t, 1, 10)
+	require.NoError(t, archiverIO.StoreOneBlockFile(ctx, bundle.BlockFileNameWithSuffix(block, "suffix"), block))
+	mergeable := randomPayloadBlock(t, 2, 10)
+	require.NoError(t, archiverIO.StoreMergeableOneBlockFile(ctx, bundle.BlockFileNameWithSuffix(mergeable, "suffix"), mergeable))
+	require.NoError(t, archiverIO.mergedBundleRecorder.WriteObject(ctx, "0000000000", bytes.NewReader([]byte("bundle"))))
 
-	return io.WalkMergeableOneBlockFilesFunc(ctx)
+	assert.Equal(t, []string{bundle.BlockFileNameWithSuffix(block, "suffix")}, oneBlocksUploader.takeStoredFiles(), "mergeable one block files are not uploaded")
+	assert.Equal(t, []string{"0000000000"}, mergedUploader.takeStoredFiles())
 }
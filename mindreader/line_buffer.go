@@ -0,0 +1,217 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// defaultLineBufferLines is the number of lines buffered between `LogLine` and the console reader
+const defaultLineBufferLines = 10000
+
+// WithLineBufferCapacity bounds the lines received from the node and not yet read by the console
+// reader to `lines` lines (10000 by default) and, when non-zero, `bytes` bytes (unbounded by
+// default). A line larger than `bytes` is still accepted when nothing is buffered. `LogLine`
+// waits for room in the buffer, see `WithLineWriteTimeout`.
+func WithLineBufferCapacity(lines int, bytes int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if lines > 0 {
+			p.lineBufferLines = lines
+		}
+		p.lineBuffer.maxBytes = bytes
+	}
+}
+
+// WithLineWriteTimeout makes the plugin declare itself stuck when a line waits for room in the
+// line buffer for longer than `timeout`: it logs where the pipeline is blocked, with a dump of
+// every goroutine, and shuts down with an error instead of blocking the superviser reading the
+// node's output, which would freeze the node. `LogLine` waits forever by default, never dropping
+// a line.
+func WithLineWriteTimeout(timeout time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.lineBuffer.writeTimeout = timeout
+	}
+}
+
+// lineBuffer accounts for the lines sent to the console reader and not yet read from the lines
+// channel. The channel being FIFO, the lines still in it are the last `len(lines)` ones written,
+// their sizes are kept in the same order to know how many bytes are buffered.
+type lineBuffer struct {
+	maxBytes     int
+	writeTimeout time.Duration
+
+	writeLock sync.Mutex // serializes the writers, held while waiting for room
+
+	lock  sync.Mutex // never held while waiting for room, see `usage`
+	sizes []int      // of the lines written and possibly still in the channel, oldest first
+	bytes int
+	stuck bool
+}
+
+// reconcile forgets the sizes of the lines already read from `lines` and updates the gauges of
+// the line buffer, must be called with the lock held
+func (b *lineBuffer) reconcile(lines chan string) {
+	read := len(b.sizes) - len(lines)
+	for i := 0; i < read; i++ {
+		b.bytes -= b.sizes[i]
+	}
+	if read > 0 {
+		b.sizes = b.sizes[read:]
+	}
+
+	metrics.LineBufferLines.SetUint64(uint64(len(lines)))
+	metrics.LineBufferBytes.SetUint64(uint64(b.bytes))
+}
+
+// write sends `line` to `lines`, waiting for room in the buffer up to the write timeout or until
+// `closing` is closed. It returns false, dropping the line, once the buffer is stuck or when
+// `closing` was closed.
+func (b *lineBuffer) write(lines chan string, closing <-chan struct{}, line string) (ok bool, waited time.Duration) {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	if b.isStuck() {
+		return false, 0
+	}
+
+	var timeout <-chan time.Time
+	start := time.Now()
+	if b.writeTimeout > 0 {
+		timer := time.NewTimer(b.writeTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	if b.maxBytes > 0 {
+		backoff := time.Millisecond
+		for buffered := b.bufferedBytes(lines); buffered > 0 && buffered+len(line) > b.maxBytes; buffered = b.bufferedBytes(lines) {
+			select {
+			case <-timeout:
+				b.setStuck()
+				return false, time.Since(start)
+			case <-closing:
+				return false, 0
+			case <-time.After(backoff):
+			}
+
+			if backoff < 50*time.Millisecond {
+				backoff *= 2
+			}
+		}
+	}
+
+	select {
+	case lines <- line:
+	case <-timeout:
+		b.setStuck()
+		return false, time.Since(start)
+	case <-closing:
+		return false, 0
+	}
+
+	// A `reconcile` between the send and here counts the line as already read, it then stays
+	// the oldest size kept and is forgotten by the next one
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sizes = append(b.sizes, len(line))
+	b.bytes += len(line)
+	b.reconcile(lines)
+	return true, 0
+}
+
+func (b *lineBuffer) bufferedBytes(lines chan string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.reconcile(lines)
+	return b.bytes
+}
+
+func (b *lineBuffer) setStuck() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stuck = true
+}
+
+func (b *lineBuffer) isStuck() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.stuck
+}
+
+// usage returns the lines and bytes buffered in `lines`, it does not wait for a writer waiting
+// for room in the buffer
+func (b *lineBuffer) usage(lines chan string) (int, int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.reconcile(lines)
+	return len(lines), b.bytes
+}
+
+// goroutineDump returns the stack traces of every goroutine
+func goroutineDump() string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// declareStuck shuts the plugin down once a line could not be written to the line buffer within
+// the write timeout, logging the state of the pipeline to find out which stage is blocked
+func (p *MindReaderPlugin) declareStuck(waited time.Duration) {
+	p.linesLock.RLock()
+	bufferedLines, bufferedBytes := p.lineBuffer.usage(p.lines)
+	p.linesLock.RUnlock()
+
+	var bufferedBlocks, blocksCapacity int
+	if p.blocks != nil {
+		bufferedBlocks, blocksCapacity = len(p.blocks), cap(p.blocks)
+	}
+
+	stage := "console reader (reading blocks from lines)"
+	if blocksCapacity > 0 && bufferedBlocks >= blocksCapacity {
+		stage = "read flow (archiving or publishing blocks)"
+	}
+
+	metrics.LineWriteTimeouts.Inc()
+	err := fmt.Errorf("mindreader pipeline stuck: line not accepted within %s, %d line(s) and %d byte(s) waiting for the console reader, %d/%d block(s) waiting for the read flow, blocked stage is likely the %s", waited, bufferedLines, bufferedBytes, bufferedBlocks, blocksCapacity, stage)
+
+	p.zlogger.Error("mindreader pipeline stuck, shutting down instead of blocking the node's output",
+		zap.Duration("waited", waited),
+		zap.Int("buffered_lines", bufferedLines),
+		zap.Int("buffered_bytes", bufferedBytes),
+		zap.Int("buffered_blocks", bufferedBlocks),
+		zap.Int("blocks_capacity", blocksCapacity),
+		zap.String("likely_blocked_stage", stage),
+		zap.Bool("console_reader_done", p.consoleReaderDone.Load()),
+		zap.String("goroutines", goroutineDump()),
+	)
+
+	go p.Shutdown(err)
+}
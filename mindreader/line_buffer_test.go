@@ -0,0 +1,173 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineBuffer_BytesCapacity(t *testing.T) {
+	lines := make(chan string, 10)
+	buffer := &lineBuffer{maxBytes: 10, writeTimeout: 20 * time.Millisecond}
+
+	for _, line := range []string{"aaaa", "bbbb", "cc"} {
+		ok, _ := buffer.write(lines, nil, line)
+		require.True(t, ok)
+	}
+	bufferedLines, bufferedBytes := buffer.usage(lines)
+	assert.Equal(t, 3, bufferedLines)
+	assert.Equal(t, 10, bufferedBytes)
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-lines
+	}()
+	ok, _ := buffer.write(lines, nil, "dddd")
+	require.True(t, ok, "written once the console reader read a line")
+	_, bufferedBytes = buffer.usage(lines)
+	assert.Equal(t, 10, bufferedBytes)
+
+	ok, waited := buffer.write(lines, nil, "e")
+	assert.False(t, ok)
+	assert.GreaterOrEqual(t, int64(waited), int64(20*time.Millisecond))
+
+	<-lines
+	ok, waited = buffer.write(lines, nil, "e")
+	assert.False(t, ok, "lines dropped once stuck")
+	assert.Zero(t, waited)
+}
+
+func TestLineBuffer_UsageWhileWriterWaits(t *testing.T) {
+	lines := make(chan string, 1)
+	buffer := &lineBuffer{}
+
+	ok, _ := buffer.write(lines, nil, "aaaa")
+	require.True(t, ok)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.LineBufferLines.Native()))
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.LineBufferBytes.Native()))
+
+	written := make(chan bool)
+	go func() {
+		ok, _ := buffer.write(lines, nil, "bb")
+		written <- ok
+	}()
+
+	usage := make(chan int)
+	go func() {
+		_, bytes := buffer.usage(lines)
+		usage <- bytes
+	}()
+	select {
+	case bytes := <-usage:
+		assert.Equal(t, 4, bytes)
+	case <-time.After(time.Second):
+		t.Fatal("usage should not wait for the writer waiting for room")
+	}
+
+	<-lines
+	require.True(t, <-written)
+	<-lines
+
+	bufferedLines, bufferedBytes := buffer.usage(lines)
+	assert.Zero(t, bufferedLines)
+	assert.Zero(t, bufferedBytes)
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.LineBufferLines.Native()), "gauges follow the lines read")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.LineBufferBytes.Native()))
+}
+
+func TestLineBuffer_ClosingReleasesWriter(t *testing.T) {
+	lines := make(chan string, 1)
+	closing := make(chan struct{})
+	buffer := &lineBuffer{}
+
+	ok, _ := buffer.write(lines, closing, "a")
+	require.True(t, ok)
+
+	close(closing)
+	ok, waited := buffer.write(lines, closing, "b")
+	assert.False(t, ok)
+	assert.Zero(t, waited)
+	assert.False(t, buffer.isStuck(), "a closing pipe does not make the buffer stuck")
+}
+
+func TestLineBuffer_OversizedLine(t *testing.T) {
+	lines := make(chan string, 10)
+	buffer := &lineBuffer{maxBytes: 4}
+
+	ok, _ := buffer.write(lines, nil, "larger than the capacity")
+	assert.True(t, ok, "accepted when nothing is buffered")
+}
+
+func TestLineBuffer_LosslessByDefault(t *testing.T) {
+	lines := make(chan string, 2)
+	buffer := &lineBuffer{}
+
+	received := make(chan []string)
+	go func() {
+		var out []string
+		for line := range lines {
+			time.Sleep(100 * time.Microsecond)
+			out = append(out, line)
+		}
+		received <- out
+	}()
+
+	var expected []string
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("line %d", i)
+		expected = append(expected, line)
+		ok, _ := buffer.write(lines, nil, line)
+		require.True(t, ok)
+	}
+	close(lines)
+
+	assert.Equal(t, expected, <-received)
+}
+
+func TestMindReaderPlugin_LineWriteTimeout(t *testing.T) {
+	p := &MindReaderPlugin{
+		Shutter: shutter.New(),
+		zlogger: testLogger,
+		lines:   make(chan string, 1),
+	}
+	WithLineWriteTimeout(20 * time.Millisecond)(p)
+
+	p.LogLine(`DMLOG {"id":"00000001a"}`)
+
+	start := time.Now()
+	p.LogLine(`DMLOG {"id":"00000002a"}`)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "no console reader, LogLine gives up instead of blocking")
+
+	select {
+	case <-p.Terminated():
+	case <-time.After(time.Second):
+		t.Fatal("plugin not shut down once stuck")
+	}
+	require.Error(t, p.Err())
+	assert.Contains(t, p.Err().Error(), "mindreader pipeline stuck")
+	assert.Contains(t, p.Err().Error(), "1 line(s)")
+
+	start = time.Now()
+	p.LogLine(`DMLOG {"id":"00000003a"}`)
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Millisecond))
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+type blockPusher interface {
+	PushBlock(blk *bstream.Block) error
+}
+
+// liveStream publishes blocks to the block stream server without ever failing the plugin: a
+// push is retried `retries` times and, if it still fails, publishing is dropped (blocks are
+// still archived) until a push succeeds again, attempted every `reestablishInterval`.
+type liveStream struct {
+	lock sync.Mutex
+
+	pusher              blockPusher
+	retries             int
+	retryDelay          time.Duration
+	reestablishInterval time.Duration
+
+	healthy     bool
+	lastAttempt time.Time
+
+	now     func() time.Time
+	zlogger *zap.Logger
+}
+
+func newLiveStream(pusher blockPusher, retries int, retryDelay, reestablishInterval time.Duration, zlogger *zap.Logger) *liveStream {
+	metrics.LiveStreamHealthy.SetUint64(1)
+	return &liveStream{
+		pusher:              pusher,
+		retries:             retries,
+		retryDelay:          retryDelay,
+		reestablishInterval: reestablishInterval,
+		healthy:             true,
+		now:                 time.Now,
+		zlogger:             zlogger,
+	}
+}
+
+func (s *liveStream) isHealthy() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.healthy
+}
+
+func (s *liveStream) push(block *bstream.Block) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.healthy {
+		if s.now().Sub(s.lastAttempt) < s.reestablishInterval {
+			return
+		}
+
+		s.lastAttempt = s.now()
+		if err := s.pusher.PushBlock(block); err != nil {
+			s.zlogger.Warn("live stream still failing, blocks are only archived", zap.Stringer("block", block), zap.Error(err))
+			return
+		}
+
+		s.zlogger.Info("live stream re-established", zap.Stringer("block", block))
+		s.setHealthy(true)
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.retryDelay)
+		}
+
+		if err = s.pusher.PushBlock(block); err == nil {
+			return
+		}
+		s.zlogger.Debug("failed passing block to block stream server", zap.Stringer("block", block), zap.Int("attempt", attempt), zap.Error(err))
+	}
+
+	s.zlogger.Error("failed passing block to block stream server, dropping live stream publishing, blocks are only archived until it is re-established",
+		zap.Stringer("block", block),
+		zap.Int("retries", s.retries),
+		zap.Duration("reestablish_interval", s.reestablishInterval),
+		zap.Error(err),
+	)
+	s.lastAttempt = s.now()
+	s.setHealthy(false)
+}
+
+func (s *liveStream) setHealthy(healthy bool) {
+	s.healthy = healthy
+	if healthy {
+		metrics.LiveStreamHealthy.SetUint64(1)
+	} else {
+		metrics.LiveStreamHealthy.SetUint64(0)
+	}
+}
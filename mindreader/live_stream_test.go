@@ -0,0 +1,94 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingPusher struct {
+	failing bool
+	pushed  []uint64
+	calls   int
+}
+
+func (p *failingPusher) PushBlock(blk *bstream.Block) error {
+	p.calls++
+	if p.failing {
+		return fmt.Errorf("subscriber stalled")
+	}
+	p.pushed = append(p.pushed, blk.Number)
+	return nil
+}
+
+func TestLiveStream_DropsAndReestablishes(t *testing.T) {
+	pusher := &failingPusher{failing: true}
+	now := time.Unix(0, 0)
+
+	s := newLiveStream(pusher, 2, 0, 10*time.Second, testLogger)
+	s.now = func() time.Time { return now }
+
+	s.push(&bstream.Block{Number: 1})
+	assert.False(t, s.isHealthy())
+	assert.Equal(t, 3, pusher.calls, "initial push and 2 retries")
+
+	// Not retried before the re-establish interval
+	now = now.Add(5 * time.Second)
+	s.push(&bstream.Block{Number: 2})
+	assert.Equal(t, 3, pusher.calls)
+
+	now = now.Add(5 * time.Second)
+	s.push(&bstream.Block{Number: 3})
+	assert.Equal(t, 4, pusher.calls)
+	assert.False(t, s.isHealthy())
+
+	pusher.failing = false
+	s.push(&bstream.Block{Number: 4})
+	assert.Equal(t, 4, pusher.calls)
+
+	now = now.Add(10 * time.Second)
+	s.push(&bstream.Block{Number: 5})
+	s.push(&bstream.Block{Number: 6})
+	assert.True(t, s.isHealthy())
+	assert.Equal(t, []uint64{5, 6}, pusher.pushed)
+}
+
+func TestLiveStream_RetrySucceeds(t *testing.T) {
+	pusher := &flakyPusher{failures: 2}
+
+	s := newLiveStream(pusher, 2, 0, time.Second, testLogger)
+	s.push(&bstream.Block{Number: 1})
+
+	assert.True(t, s.isHealthy())
+	assert.Equal(t, 3, pusher.calls)
+}
+
+type flakyPusher struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyPusher) PushBlock(blk *bstream.Block) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return fmt.Errorf("transient")
+	}
+	return nil
+}
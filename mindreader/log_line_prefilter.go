@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"strings"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// droppedLogLineSampleInterval is the number of dropped lines between two logged examples
+const droppedLogLineSampleInterval = 10000
+
+// WithLogLinePrefilter drops, in `LogLine`, the lines for which `f` returns false before they
+// reach the console reader, sparing it the cost of rejecting them. See `NewPrefixLogLinePrefilter`.
+func WithLogLinePrefilter(f func(line string) bool) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.logLinePrefilter = f
+	}
+}
+
+// NewPrefixLogLinePrefilter returns a log line prefilter admitting only the lines starting with
+// `prefix` (e.g. "DMLOG "), usable with `WithLogLinePrefilter` or directly by a superviser.
+// Dropped lines are counted in the `dropped_log_lines` metric, the first one and then one every
+// 10000 are logged as an example.
+func NewPrefixLogLinePrefilter(prefix string, logger *zap.Logger) func(line string) bool {
+	var dropped atomic.Uint64
+	return func(line string) bool {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+
+		metrics.DroppedLogLines.Inc()
+		if count := dropped.Inc(); count%droppedLogLineSampleInterval == 1 {
+			logger.Info("dropping log line not matching prefix", zap.String("prefix", prefix), zap.Uint64("dropped_count", count), zap.String("example", line))
+		}
+		return false
+	}
+}
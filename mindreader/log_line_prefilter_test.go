@@ -0,0 +1,61 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPrefixLogLinePrefilter(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	prefilter := NewPrefixLogLinePrefilter("DMLOG ", zap.New(core))
+
+	assert.True(t, prefilter(`DMLOG {"id":"00000001a"}`))
+	for i := 0; i < 2*droppedLogLineSampleInterval+1; i++ {
+		assert.False(t, prefilter("WARN same warning again"))
+	}
+	assert.False(t, prefilter("DMLOG")) // prefix includes the space
+
+	entries := logs.All()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "WARN same warning again", entries[0].ContextMap()["example"])
+		assert.Equal(t, uint64(1), entries[0].ContextMap()["dropped_count"])
+		assert.Equal(t, uint64(droppedLogLineSampleInterval+1), entries[1].ContextMap()["dropped_count"])
+	}
+}
+
+func TestMindReaderPlugin_LogLinePrefilter(t *testing.T) {
+	p := &MindReaderPlugin{
+		Shutter:          shutter.New(),
+		lines:            make(chan string, 10),
+		logLinePrefilter: NewPrefixLogLinePrefilter("DMLOG ", zap.NewNop()),
+	}
+
+	p.LogLine("some node warning")
+	p.LogLine(`DMLOG {"id":"00000001a"}`)
+	p.LogLine("another node warning")
+
+	close(p.lines)
+	var lines []string
+	for line := range p.lines {
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{`DMLOG {"id":"00000001a"}`}, lines)
+}
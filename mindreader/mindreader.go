@@ -21,14 +21,19 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/bstream/blockstream"
 	"github.com/streamingfast/dstore"
 	"github.com/streamingfast/logging"
+	"github.com/streamingfast/merger/bundle"
 	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/journal"
+	"github.com/streamingfast/node-manager/metrics"
 	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -36,36 +41,318 @@ var (
 	oneblockSuffixRegexp = regexp.MustCompile(`^[\w\-]+$`)
 )
 
+// ConsolerReader decodes blocks from the lines it receives. `ReadBlock` returns `io.EOF` once
+// the lines channel is closed and every line was consumed. A console reader can close the `Done`
+// channel (nil if it never does) when it knows no more blocks will come, for example when the node
+// printed its shutdown marker: the plugin then stops receiving lines and shuts down cleanly once
+// the lines already received were read (see `WithDiscardBufferedLinesOnReaderDone`).
 type ConsolerReader interface {
 	ReadBlock() (obj *bstream.Block, err error)
 	Done() <-chan interface{}
 }
 
-type ConsolerReaderFactory func(lines chan string) (ConsolerReader, error)
+// ConsoleReaderContext is given to the `ConsolerReaderFactory` for each console reader created,
+// once per pipe of the node
+type ConsoleReaderContext struct {
+	// Lines received from the node, closed once the pipe is done, see `LinesReader` to read them
+	// as an `io.Reader`
+	Lines chan string
+
+	// Logger of the plugin
+	Logger *zap.Logger
+
+	// WorkingDirectory of the plugin, for the console readers keeping state on disk
+	WorkingDirectory string
+
+	// Context is canceled, and Terminating closed, once the plugin shuts down, console readers
+	// doing background work (e.g. caching ABIs) must stop it then
+	Context     context.Context
+	Terminating <-chan struct{}
+}
+
+type ConsolerReaderFactory func(ctx ConsoleReaderContext) (ConsolerReader, error)
+
+// LegacyConsolerReaderFactory is the previous shape of `ConsolerReaderFactory`, receiving the
+// lines only, see `AdaptLegacyFactory`
+type LegacyConsolerReaderFactory func(lines chan string) (ConsolerReader, error)
+
+// AdaptLegacyFactory returns a `ConsolerReaderFactory` calling `f` with the lines of the
+// console reader context
+func AdaptLegacyFactory(f LegacyConsolerReaderFactory) ConsolerReaderFactory {
+	return func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return f(ctx.Lines)
+	}
+}
+
+type MindReaderPluginOption func(p *MindReaderPlugin)
+
+// WithMaintenanceRequester makes the plugin request a maintenance of the node through
+// the given function, instead of shutting itself down, when reading from the console
+// reader fails.
+func WithMaintenanceRequester(f nodeManager.MaintenanceRequester) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.maintenanceRequester = f
+	}
+}
+
+// WithContinuityChecker verifies that every block read follows the previous ones, a hole
+// in the chain is treated like a read error (maintenance when a requester is set, shutdown
+// otherwise). The checker is flushed on termination and before requesting a maintenance.
+func WithContinuityChecker(cc ContinuityChecker) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.continuityChecker = cc
+	}
+}
+
+// ContinuityBreakHandler is called when the continuity checker detects a hole, with the block
+// number that was expected next, the one received instead and the plugin's working directory
+type ContinuityBreakHandler func(expected, got uint64, workingDirectory string)
+
+// WithContinuityBreakHandler calls `f`, in its own goroutine, once per hole detected by the
+// continuity checker, blocks received until the checker is reset do not call it again. It is
+// meant to alert or file a ticket, the node being put in maintenance (or shut down) regardless.
+func WithContinuityBreakHandler(f ContinuityBreakHandler) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.continuityBreakHandler = f
+	}
+}
+
+// WithStartGate replaces the gate of the start block by `gate`, blocks are discarded until it
+// passes (e.g. `NewBlockTimestampGate` to start at a given time, composed with the start block
+// through `NewAndGate` or `NewOrGate`). With `WithAutoResume`, blocks are also discarded until
+// the resume block.
+func WithStartGate(gate Gate) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.startGate = gate
+	}
+}
+
+// WithPushRateLimit limits the rate at which blocks are pushed to the block stream server
+// while blocks are older than `catchUpBlockAge`, see `PushRateLimiter`. Blocks over the limit
+// are pushed later, in order, from a queue of 10000 blocks, the blocks arriving while it is full
+// are only archived (see `PushRateLimiter.DroppedBlocks`): archiving always goes on at full
+// speed. Limits can be changed at runtime through `SetPushRateLimit`.
+func WithPushRateLimit(blocksPerSecond, bytesPerSecond float64, catchUpBlockAge time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.pushRateLimiter = NewPushRateLimiter(blocksPerSecond, bytesPerSecond, catchUpBlockAge)
+		p.pushQueueSize = defaultPushQueueSize
+	}
+}
+
+// BlockFilter decides if a block read from the console reader is kept, filtered out blocks
+// are neither archived nor pushed to the block stream server and do not update the head block.
+type BlockFilter func(block *bstream.Block) (keep bool, err error)
+
+// WithBlockFilter appends `f`, dropping the blocks it does not keep, to the transformer chain,
+// see `WithBlockTransformers`.
+func WithBlockFilter(f BlockFilter) MindReaderPluginOption {
+	return WithBlockTransformers(f.Transformer())
+}
+
+// WithLiveStreamRetry configures how failures to push blocks to the block stream server are
+// handled: a push is retried `retries` times, `retryDelay` apart, after which publishing is
+// dropped (blocks are still archived) and re-established by trying to push a block every
+// `reestablishInterval`. Defaults to 3 retries, 50ms apart, and 30s.
+func WithLiveStreamRetry(retries int, retryDelay, reestablishInterval time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.liveStreamRetries = retries
+		p.liveStreamRetryDelay = retryDelay
+		p.liveStreamReconnect = reestablishInterval
+	}
+}
+
+// slowProcessingWarningInterval is the minimum time between two slow processing warnings
+var slowProcessingWarningInterval = 30 * time.Second
+
+// WithSlowProcessingWarningThreshold logs a warning when reading a block from the console reader,
+// or processing it before archiving, takes longer than `threshold`. Reading includes the time
+// waiting for the node to produce the block, so the threshold should be above the chain's block
+// interval. Warnings are logged at most once every 30 seconds.
+func WithSlowProcessingWarningThreshold(threshold time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.slowProcessingThreshold = threshold
+	}
+}
+
+// WithUploadConcurrency sets the number of files uploaded in parallel to the archive stores by
+// each of the one block and merged blocks uploaders, 5 by default
+func WithUploadConcurrency(n int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadConcurrency = n
+	}
+}
+
+// WithDiscardBufferedLinesOnReaderDone stops reading right away when the console reader closes
+// its `Done` channel, instead of reading the lines already received from the node first
+func WithDiscardBufferedLinesOnReaderDone() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.discardLinesOnReaderDone = true
+	}
+}
+
+// WithArchiverOptions configures the archiver created by `NewMindReaderPlugin`, like
+// `WithArchiverMode` or `WithExcludeForkedBlocks`
+func WithArchiverOptions(options ...ArchiverOption) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.archiverOptions = append(p.archiverOptions, options...)
+	}
+}
+
+// DefaultBundleSize is the number of blocks of a merged blocks file
+const DefaultBundleSize uint64 = 100
+
+// WithBundleSize changes the number of blocks of a merged blocks file, `DefaultBundleSize` by
+// default, it must be greater than 1. Bundles start on multiples of `size` and merged files are
+// named after their first block, the blocks of a partial bundle are sent as one block files until
+// the next multiple. It must match the bundle size of the merger and of the downstream readers.
+func WithBundleSize(size uint64) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.bundleSize = size
+	}
+}
 
 type MindReaderPlugin struct {
 	*shutter.Shutter
 	zlogger *zap.Logger
 
-	startGate *BlockNumberGate // if set, discard blocks before this
-	stopBlock uint64           // if set, call shutdownFunc(nil) when we hit this number
+	// ctx is the root context of the plugin's background work (uploads, readiness), canceled on Shutdown
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	startGate     Gate          // if set, discard blocks until it passes
+	resumeBlock   uint64        // block of the start gate, see `WithAutoResume`
+	autoResume    bool          // resolve resumeBlock from the block files already written
+	stopLock      sync.Mutex    // protects stopBlock and the last block sent, see `SetStopBlock`
+	stopBlock     uint64        // if set, call shutdownFunc(nil) when we hit this number
+	stopCondition StopCondition // replaces stopBlock when set
+
+	lastSentBlockNum uint64
+	blockSent        bool
+	stopReached      atomic.Bool
+	stoppedAt        atomic.Uint64 // block for which the stop condition fired
+
+	stopMarkerOverride bool
 
 	waitUploadCompleteOnShutdown time.Duration // if non-zero, will try to upload files for this amount of time. Failed uploads will stay in workingDir
+	shutdownDrainTimeout         time.Duration // if non-zero, blocks not archived this long after termination began are dropped
+	shutdownDrainErr             atomic.Error
+	blocksDroppedLock            sync.Mutex
+	onBlocksDropped              []func(err error) // see `OnBlocksDropped`
+
+	lines           chan string
+	linesClosing    chan struct{}  // closed right before `lines`, releasing the writers waiting for room
+	linesClosed     bool           // `lines` is closed, until it is replaced by the next pipe
+	linesLock       sync.RWMutex   // held for reading by the writers of `lines`, for writing to close or replace it
+	pipeLock        sync.Mutex     // held while closing `lines` and across the handoff to a new pipe, see `writeLine`
+	consoleReader   ConsolerReader // contains the 'reader' part of the pipe
+	lineBuffer      lineBuffer     // accounts for the lines written to `lines`, see `WithLineBufferCapacity`
+	lineBufferLines int            // capacity of `lines`
+	lineQueue       *lineQueue     // lines waiting to be written to `lines`, see `WithAsyncLogLine`
+	lineDropWarned  atomic.Bool
+	fileTail        *FileTailSource // reads the lines instead of `LogLine`, see `WithFileTailSource`
+	fileTailDone    chan struct{}   // closed once the file tail source returned
 
-	lines         chan string
-	consoleReader ConsolerReader // contains the 'reader' part of the pipe
+	blocks       chan *bstream.Block // read flow input, kept when the node is relaunched
+	pipeDetached chan struct{}       // closed when the current pipe is replaced on relaunch
+	readLoopDone chan struct{}       // closed when the read loop of the current pipe returns
+
+	restartableSource bool        // see `WithRestartableSource`
+	awaitingSource    atomic.Bool // the source ended, the read loop waits for `Reattach`
 
 	channelCapacity int // transformed blocks are buffered in a channel
 
 	archiver                 *Archiver // transformed blocks are sent to Archiver
+	archiverOptions          []ArchiverOption
 	oneBlockFileUploader     *FileUploader
 	mergedBlocksFileUploader *FileUploader
 
 	consumeReadFlowDone chan interface{}
 
-	blockStreamServer    *blockstream.Server
-	headBlockUpdateFunc  nodeManager.HeadBlockUpdater
-	consoleReaderFactory ConsolerReaderFactory
+	blockStreamServer      *blockstream.Server
+	headBlockUpdaters      []nodeManager.HeadBlockUpdater
+	maintenanceRequester   nodeManager.MaintenanceRequester
+	continuityChecker      ContinuityChecker
+	continuityFailed       atomic.Bool
+	continuityBreakHandler ContinuityBreakHandler
+	workingDirectory       string
+
+	secondaryArchiveStoreURLs []string
+	secondaryArchiveGiveUp    time.Duration
+	pushRateLimiter           *PushRateLimiter
+	pushQueue                 *pushQueue // only accessed by the consume read flow
+	pushQueueSize             int
+	transformers              *TransformerChain
+	liveStream                *liveStream
+	liveStreamRetries         int
+	liveStreamRetryDelay      time.Duration
+	liveStreamReconnect       time.Duration
+	consoleReaderFactory      ConsolerReaderFactory
+
+	stats readFlowStats
+
+	slowProcessingThreshold  time.Duration
+	dryRun                   *dryRunSummary
+	uploadConcurrency        int
+	uploadRetryPolicy        UploadRetryPolicy
+	uploadPollInterval       time.Duration
+	uploadScanInterval       time.Duration
+	uploadScanBackoffMax     time.Duration
+	uploadScanIdleScans      int
+	discardLinesOnReaderDone bool
+	maxBlockPayloadBytes     int
+	logLinePrefilter         func(line string) bool
+	events                   eventDispatcher
+	blockSink                BlockArchiver
+	dedupWindow              int
+	reconcileKeepFiles       []string
+	reconciliation           *ReconciliationReport
+	dedup                    *blockDedup // only accessed by the consume read flow
+	readDedupWindow          int
+	readDedupPersisted       bool
+	readDedup                *blockDedup // only accessed by the produce read flow, see `WithReadDedupWindow`
+	oversizedBlockPolicy     OversizedBlockPolicy
+
+	uploadQuarantineMaxAttempts   int
+	bufferedBytes                 *byteBudget // see `WithMaxBufferedBytes`
+	uploadFailureReadinessTimeout time.Duration
+	readyCh                       chan struct{}
+	readyChOnce                   sync.Once
+	readyCloseOnce                sync.Once
+	consoleReaderDone             atomic.Bool
+	lastSlowProcessingWarning     time.Time // only accessed by the reading goroutine
+
+	stopBlockReachFunc      func()
+	stopBlockBarrierOptions StopBlockBarrierOptions
+	barrierBundles          *uploadedBundles // merged bundles uploaded, checked by the stop block barrier
+
+	futureBlockSkew          time.Duration
+	futureBlockHandler       FutureBlockHandler
+	lastFutureBlockWarning   time.Time // only accessed by the reading goroutine, like the count below
+	futureBlocksSinceWarning uint64
+	now                      func() time.Time // local clock, `time.Now` when nil
+
+	bundleSize              uint64
+	payloadChecksums        *payloadChecksums // see `WithPayloadChecksum`
+	oneBlockPartitionWidth  uint64
+	oneBlockFileCompression string // see `WithOneBlockFileCompression`
+
+	watermarkOptions *WatermarkOptions
+	watermark        *watermarkWriter
+	uploadNotifier   UploadNotifier // see `WithUploadNotifier`
+
+	journalDirectory string           // see `WithEventJournal`
+	journal          *journal.Journal // nil without journal, recording nothing
+	gatePassed       bool             // only accessed by the read loop
+
+	suffixClaimOptions *SuffixClaimOptions
+	suffixClaimer      *suffixClaimer
+
+	oneBlockOverwrite OneBlockOverwritePolicy // see `WithOneBlockFileOverwrite`
+
+	metrics *metrics.MindreaderMetrics // see `WithMetrics`, nil records nothing
+
+	resumePointCheck *ResumePointCheckOptions
+	resumePoint      resumePointState
 }
 
 // NewMindReaderPlugin initiates its own:
@@ -89,6 +376,71 @@ func NewMindReaderPlugin(
 	blockStreamServer *blockstream.Server,
 	zlogger *zap.Logger,
 	tracer logging.Tracer,
+	options ...MindReaderPluginOption,
+) (*MindReaderPlugin, error) {
+	return newMindReaderPluginWithArchiveStores(archiveStores{oneBlocksURL: archiveStoreURL, mergedURL: mergeArchiveStoreURL}, mergeThresholdBlockAge, workingDirectory, consoleReaderFactory, startBlockNum, stopBlockNum, channelCapacity, headBlockUpdateFunc, shutdownFunc, waitUploadCompleteOnShutdown, oneblockSuffix, blockStreamServer, zlogger, tracer, options...)
+}
+
+// NewMindReaderPluginWithStores is `NewMindReaderPlugin` archiving the one block files to
+// `archiveStore` and the merged blocks files to `mergeArchiveStore`, already constructed
+// (e.g. wrapped by `dstorefault.Wrap`) instead of created from URLs. Dry run archives to its local
+// directory regardless.
+func NewMindReaderPluginWithStores(
+	archiveStore dstore.Store,
+	mergeArchiveStore dstore.Store,
+	mergeThresholdBlockAge string,
+	workingDirectory string,
+	consoleReaderFactory ConsolerReaderFactory,
+	startBlockNum uint64,
+	stopBlockNum uint64,
+	channelCapacity int,
+	headBlockUpdateFunc nodeManager.HeadBlockUpdater,
+	shutdownFunc func(error),
+	waitUploadCompleteOnShutdown time.Duration,
+	oneblockSuffix string,
+	blockStreamServer *blockstream.Server,
+	zlogger *zap.Logger,
+	tracer logging.Tracer,
+	options ...MindReaderPluginOption,
+) (*MindReaderPlugin, error) {
+	return newMindReaderPluginWithArchiveStores(archiveStores{oneBlocks: archiveStore, mergedBlocks: mergeArchiveStore}, mergeThresholdBlockAge, workingDirectory, consoleReaderFactory, startBlockNum, stopBlockNum, channelCapacity, headBlockUpdateFunc, shutdownFunc, waitUploadCompleteOnShutdown, oneblockSuffix, blockStreamServer, zlogger, tracer, options...)
+}
+
+// archiveStores are the remote stores of the archiver, created from their URL when not set
+type archiveStores struct {
+	oneBlocksURL string
+	mergedURL    string
+	oneBlocks    dstore.Store
+	mergedBlocks dstore.Store
+}
+
+func (s archiveStores) urls() (oneBlocks, merged string) {
+	oneBlocks, merged = s.oneBlocksURL, s.mergedURL
+	if s.oneBlocks != nil {
+		oneBlocks = s.oneBlocks.BaseURL().String()
+	}
+	if s.mergedBlocks != nil {
+		merged = s.mergedBlocks.BaseURL().String()
+	}
+	return
+}
+
+func newMindReaderPluginWithArchiveStores(
+	stores archiveStores,
+	mergeThresholdBlockAge string,
+	workingDirectory string,
+	consoleReaderFactory ConsolerReaderFactory,
+	startBlockNum uint64,
+	stopBlockNum uint64,
+	channelCapacity int,
+	headBlockUpdateFunc nodeManager.HeadBlockUpdater,
+	shutdownFunc func(error),
+	waitUploadCompleteOnShutdown time.Duration,
+	oneblockSuffix string,
+	blockStreamServer *blockstream.Server,
+	zlogger *zap.Logger,
+	tracer logging.Tracer,
+	options ...MindReaderPluginOption,
 ) (*MindReaderPlugin, error) {
 	err := validateOneBlockSuffix(oneblockSuffix)
 	if err != nil {
@@ -107,6 +459,7 @@ func NewMindReaderPlugin(
 			return nil, fmt.Errorf("cannot parse merge-threshold-duration. Should be one of 'never', 'always', or a valid golang duration string (ex: 1h)")
 		}
 	}
+	archiveStoreURL, mergeArchiveStoreURL := stores.urls()
 	zlogger.Info("creating mindreader plugin",
 		zap.String("archive_store_url", archiveStoreURL),
 		zap.String("merge_archive_store_url", mergeArchiveStoreURL),
@@ -121,44 +474,115 @@ func NewMindReaderPlugin(
 		zap.Duration("wait_upload_complete_on_shutdown", waitUploadCompleteOnShutdown),
 	)
 
+	mindReaderPlugin, err := newMindReaderPlugin(
+		consoleReaderFactory,
+		startBlockNum,
+		stopBlockNum,
+		channelCapacity,
+		headBlockUpdateFunc,
+		blockStreamServer,
+		zlogger,
+	)
+	if err != nil {
+		return nil, err
+	}
+	mindReaderPlugin.waitUploadCompleteOnShutdown = waitUploadCompleteOnShutdown
+	mindReaderPlugin.workingDirectory = workingDirectory
+
+	for _, opt := range options {
+		opt(mindReaderPlugin)
+	}
+
+	if mindReaderPlugin.bundleSize <= 1 {
+		return nil, fmt.Errorf("invalid bundle size %d, must be greater than 1", mindReaderPlugin.bundleSize)
+	}
+
+	if mindReaderPlugin.dryRun != nil {
+		stores = archiveStores{
+			oneBlocksURL: path.Join(mindReaderPlugin.dryRun.localDir, "one-blocks"),
+			mergedURL:    path.Join(mindReaderPlugin.dryRun.localDir, "merged-blocks"),
+		}
+		archiveStoreURL, mergeArchiveStoreURL = stores.urls()
+		zlogger.Info("dry run, archiving blocks to local directory instead of archive stores",
+			zap.String("archive_store_url", archiveStoreURL),
+			zap.String("merge_archive_store_url", mergeArchiveStoreURL),
+		)
+	}
+
 	// Create directory and its parent(s), it's a no-op if everything already exists
 	err = os.MkdirAll(workingDirectory, os.ModePerm)
 	if err != nil {
 		return nil, fmt.Errorf("create working directory: %w", err)
 	}
 
+	oneBlockFileFormat, err := blockFileFormatOf(mindReaderPlugin.oneBlockFileCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	mindReaderPlugin.reconciliation, err = reconcileWorkingDirectory(mindReaderPlugin.ctx, workingDirectory, bstream.GetBlockReaderFactory, oneBlockFileFormat, mindReaderPlugin.reconcileKeptFiles(workingDirectory), zlogger)
+	if err != nil {
+		return nil, fmt.Errorf("reconciling working directory: %w", err)
+	}
+	if quarantined := mindReaderPlugin.reconciliation.Quarantined(); len(quarantined) > 0 {
+		zlogger.Warn("working directory reconciled, corrupt and unknown files quarantined", zap.Object("report", mindReaderPlugin.reconciliation), zap.String("quarantine_dir", path.Join(workingDirectory, quarantineDirName)))
+	} else {
+		zlogger.Info("working directory reconciled", zap.Object("report", mindReaderPlugin.reconciliation))
+	}
+
+	if mindReaderPlugin.dedupWindow > 0 {
+		mindReaderPlugin.dedup, err = newBlockDedup(path.Join(workingDirectory, dedupFilename), mindReaderPlugin.dedupWindow, zlogger)
+		if err != nil {
+			return nil, fmt.Errorf("dedup window: %w", err)
+		}
+	}
+	if mindReaderPlugin.readDedupWindow > 0 {
+		file := ""
+		if mindReaderPlugin.readDedupPersisted {
+			file = path.Join(workingDirectory, readDedupFilename)
+		}
+		mindReaderPlugin.readDedup, err = newBlockDedup(file, mindReaderPlugin.readDedupWindow, zlogger)
+		if err != nil {
+			return nil, fmt.Errorf("read dedup window: %w", err)
+		}
+	}
+
 	mergeableOneBlockDir := path.Join(workingDirectory, "mergeable")
 	uploadableOneBlocksDir := path.Join(workingDirectory, "uploadable-oneblock")
 	uploadableMergedBlocksDir := path.Join(workingDirectory, "uploadable-merged")
 
 	// remote stores
-	newDBinStoreNoCompress := func(s string) (dstore.Store, error) {
-		return dstore.NewStore(s, "dbin.zst", "", false)
+	oneBlocksStore := stores.oneBlocks
+	if oneBlocksStore == nil {
+		if oneBlocksStore, err = oneBlockFileFormat.archiveStore(archiveStoreURL); err != nil {
+			return nil, fmt.Errorf("new one block store: %w", err)
+		}
 	}
-	oneBlocksStore, err := newDBinStoreNoCompress(archiveStoreURL)
-	if err != nil {
-		return nil, fmt.Errorf("new one block store: %w", err)
+	if mindReaderPlugin.oneBlockOverwrite == OneBlockOverwriteAlways {
+		oneBlocksStore.SetOverwrite(true)
 	}
-	mergedBlocksStore, err := newDBinStoreNoCompress(mergeArchiveStoreURL)
-	if err != nil {
-		return nil, fmt.Errorf("new merge blocks store: %w", err)
+	mergedBlocksStore := stores.mergedBlocks
+	if mergedBlocksStore == nil {
+		if mergedBlocksStore, err = mergedBlocksFileFormat.archiveStore(mergeArchiveStoreURL); err != nil {
+			return nil, fmt.Errorf("new merge blocks store: %w", err)
+		}
 	}
 
 	// local stores
-	mergeableOneBlocksStore, err := dstore.NewDBinStore(mergeableOneBlockDir)
+	mergeableOneBlocksStore, err := oneBlockFileFormat.localStore(mergeableOneBlockDir)
 	if err != nil {
 		return nil, fmt.Errorf("new mergeableOneBlocksStore: %w", err)
 	}
-	uploadableMergedBlocksStore, err := dstore.NewDBinStore(uploadableMergedBlocksDir)
+	uploadableMergedBlocksStore, err := mergedBlocksFileFormat.localStore(uploadableMergedBlocksDir)
 	if err != nil {
 		return nil, fmt.Errorf("new uploadableMergedBlocksStore: %w", err)
 	}
-	uploadableOneBlocksStore, err := dstore.NewDBinStore(uploadableOneBlocksDir)
+	uploadableOneBlocksStore, err := oneBlockFileFormat.localStore(uploadableOneBlocksDir)
 	if err != nil {
 		return nil, fmt.Errorf("new uploadableOneBlocksStore: %w", err)
 	}
 
-	bundleSize := uint64(100) //todo: replace this with parameter
+	bundleSize := mindReaderPlugin.bundleSize
 	lowestPossibleBlock := bstream.GetProtocolFirstStreamableBlock
 
 	archiverIO := NewArchiverDStoreIO(
@@ -177,6 +601,31 @@ func NewMindReaderPlugin(
 		zlogger,
 		tracer,
 	)
+	archiverIO.oneBlockPartitionWidth = mindReaderPlugin.oneBlockPartitionWidth
+	archiverIO.failOnExistingOneBlock = mindReaderPlugin.oneBlockOverwrite == OneBlockOverwriteFail
+
+	if options := mindReaderPlugin.watermarkOptions; options != nil {
+		watermarkStore := options.Store
+		if watermarkStore == nil {
+			if watermarkStore, err = WatermarkStore(oneBlocksStore); err != nil {
+				return nil, fmt.Errorf("new watermark store: %w", err)
+			}
+		}
+		mindReaderPlugin.watermark = newWatermarkWriter(watermarkStore, oneblockSuffix, bundleSize, *options, zlogger)
+	}
+	archiverIO.onOneBlockUploaded = mindReaderPlugin.onOneBlockUploaded()
+
+	if options := mindReaderPlugin.suffixClaimOptions; options != nil {
+		claimStore := options.Store
+		if claimStore == nil {
+			if claimStore, err = SuffixClaimStore(oneBlocksStore); err != nil {
+				return nil, fmt.Errorf("new suffix claim store: %w", err)
+			}
+		}
+		if mindReaderPlugin.suffixClaimer, err = newSuffixClaimer(claimStore, oneblockSuffix, *options, mindReaderPlugin.currentTime, zlogger); err != nil {
+			return nil, fmt.Errorf("one block suffix claim: %w", err)
+		}
+	}
 
 	archiver := NewArchiver(
 		bundleSize,
@@ -185,27 +634,84 @@ func NewMindReaderPlugin(
 		parsedMergeThresholdBlockAge,
 		zlogger,
 		tracer,
+		mindReaderPlugin.archiverOptions...,
 	)
 
-	oneBlockFileUploader := NewFileUploader(uploadableOneBlocksStore, oneBlocksStore, zlogger)
-	mergedBlocksFileUploader := NewFileUploader(uploadableMergedBlocksStore, mergedBlocksStore, zlogger)
-
-	mindReaderPlugin, err := newMindReaderPlugin(
-		archiver,
-		oneBlockFileUploader,
-		mergedBlocksFileUploader,
-		consoleReaderFactory,
-		startBlockNum,
-		stopBlockNum,
-		channelCapacity,
-		headBlockUpdateFunc,
-		blockStreamServer,
-		zlogger,
+	archiver.payloadChecksums = mindReaderPlugin.payloadChecksums
+	if mindReaderPlugin.journalDirectory != "" {
+		if mindReaderPlugin.journal, err = journal.New(mindReaderPlugin.journalDirectory, "mindreader", zlogger); err != nil {
+			return nil, fmt.Errorf("event journal: %w", err)
+		}
+	}
+	mindReaderPlugin.archiver = archiver
+	uploadConcurrency := FileUploaderConcurrency(mindReaderPlugin.uploadConcurrency)
+	onUploadError := FileUploaderOnUploadError(mindReaderPlugin.events.emitUploadError)
+	oneBlockOnUploadError := onUploadError
+	if mindReaderPlugin.oneBlockOverwrite == OneBlockOverwriteFail {
+		oneBlockOnUploadError = FileUploaderOnUploadError(func(filename string, err error) {
+			mindReaderPlugin.shutdownOnExistingOneBlockFile(filename, err)
+			mindReaderPlugin.events.emitUploadError(filename, err)
+		})
+	}
+	retryPolicy := FileUploaderRetryPolicy(mindReaderPlugin.uploadRetryPolicy)
+	pollInterval := FileUploaderPollInterval(mindReaderPlugin.uploadPollInterval)
+	scanInterval := FileUploaderScanInterval(mindReaderPlugin.uploadScanInterval)
+	adaptiveScan := FileUploaderAdaptiveScan(mindReaderPlugin.uploadScanBackoffMax, mindReaderPlugin.uploadScanIdleScans)
+	eventJournal := FileUploaderEventJournal(mindReaderPlugin.journal)
+	oneBlockQuarantine := FileUploaderQuarantine(uploadQuarantineDirectory(workingDirectory, "uploadable-oneblock"), mindReaderPlugin.uploadQuarantineMaxAttempts)
+	mergedQuarantine := FileUploaderQuarantine(uploadQuarantineDirectory(workingDirectory, "uploadable-merged"), mindReaderPlugin.uploadQuarantineMaxAttempts)
+	oneBlockUploaderOptions := []FileUploaderOption{uploadConcurrency, oneBlockOnUploadError, retryPolicy, pollInterval, scanInterval, adaptiveScan, eventJournal, oneBlockQuarantine, FileUploaderPartitioning(mindReaderPlugin.oneBlockPartitionWidth), FileUploaderMetrics(mindReaderPlugin.metrics, metrics.FileTypeOneBlock)}
+	if mindReaderPlugin.oneBlockOverwrite == OneBlockOverwriteFail {
+		oneBlockUploaderOptions = append(oneBlockUploaderOptions, FileUploaderFailOnExistingFile())
+	}
+	onMergedUploaded := mindReaderPlugin.events.emitMergedBundleUploaded
+	if onOneBlockUploaded := mindReaderPlugin.onOneBlockUploaded(); onOneBlockUploaded != nil {
+		oneBlockUploaderOptions = append(oneBlockUploaderOptions, FileUploaderOnUploaded(onOneBlockUploaded))
+	}
+	if watermark := mindReaderPlugin.watermark; watermark != nil {
+		onMergedUploaded = func(filename string) {
+			watermark.uploaded(filename)
+			mindReaderPlugin.events.emitMergedBundleUploaded(filename)
+		}
+	}
+	if len(mindReaderPlugin.secondaryArchiveStoreURLs) > 0 {
+		if mindReaderPlugin.dryRun != nil {
+			zlogger.Info("dry run, not replicating one block files to secondary archive stores", zap.Strings("secondary_archive_store_urls", mindReaderPlugin.secondaryArchiveStoreURLs))
+		} else {
+			var secondaryStores []dstore.Store
+			for _, storeURL := range mindReaderPlugin.secondaryArchiveStoreURLs {
+				store, err := oneBlockFileFormat.archiveStore(storeURL)
+				if err != nil {
+					return nil, fmt.Errorf("new secondary one block store %q: %w", storeURL, err)
+				}
+				secondaryStores = append(secondaryStores, store)
+			}
+			oneBlockUploaderOptions = append(oneBlockUploaderOptions, FileUploaderSecondaryStores(mindReaderPlugin.secondaryArchiveGiveUp, secondaryStores...))
+		}
+	}
+	if mindReaderPlugin.stopBlockReachFunc != nil {
+		mindReaderPlugin.barrierBundles = &uploadedBundles{names: map[string]struct{}{}}
+		emitMergedUploaded := onMergedUploaded
+		onMergedUploaded = func(filename string) {
+			mindReaderPlugin.barrierBundles.add(filename)
+			emitMergedUploaded(filename)
+		}
+	}
+	mindReaderPlugin.oneBlockFileUploader = NewFileUploader(uploadableOneBlocksStore, oneBlocksStore, zlogger, oneBlockUploaderOptions...)
+	mindReaderPlugin.mergedBlocksFileUploader = NewFileUploader(uploadableMergedBlocksStore, mergedBlocksStore, zlogger, uploadConcurrency, onUploadError, retryPolicy, pollInterval, scanInterval, adaptiveScan, eventJournal, mergedQuarantine,
+		FileUploaderOnUploaded(onMergedUploaded), FileUploaderMetrics(mindReaderPlugin.metrics, metrics.FileTypeMerged),
 	)
-	if err != nil {
-		return nil, err
+	archiverIO.notifyStoredFiles(mindReaderPlugin.oneBlockFileUploader, mindReaderPlugin.mergedBlocksFileUploader)
+
+	if mindReaderPlugin.autoResume {
+		if err := mindReaderPlugin.resolveAutoResume(mindReaderPlugin.ctx, uploadableOneBlocksStore, mergeableOneBlocksStore, oneBlocksStore); err != nil {
+			return nil, fmt.Errorf("auto resume: %w", err)
+		}
+	}
+
+	if blockStreamServer != nil {
+		mindReaderPlugin.liveStream = newLiveStream(blockStreamServer, mindReaderPlugin.liveStreamRetries, mindReaderPlugin.liveStreamRetryDelay, mindReaderPlugin.liveStreamReconnect, zlogger)
 	}
-	mindReaderPlugin.waitUploadCompleteOnShutdown = waitUploadCompleteOnShutdown
 
 	return mindReaderPlugin, nil
 }
@@ -223,9 +729,6 @@ func validateOneBlockSuffix(suffix string) error {
 // Other components may have issues finding the one block files if suffix is invalid
 
 func newMindReaderPlugin(
-	archiver *Archiver,
-	oneBlockFileUploader *FileUploader,
-	mergedBlocksFileUploader *FileUploader,
 	consoleReaderFactory ConsolerReaderFactory,
 	startBlock uint64,
 	stopBlock uint64,
@@ -235,43 +738,139 @@ func newMindReaderPlugin(
 	zlogger *zap.Logger,
 ) (*MindReaderPlugin, error) {
 	zlogger.Info("creating new mindreader plugin")
-	return &MindReaderPlugin{
-		Shutter:                  shutter.New(),
-		consoleReaderFactory:     consoleReaderFactory,
-		archiver:                 archiver,
-		oneBlockFileUploader:     oneBlockFileUploader,
-		mergedBlocksFileUploader: mergedBlocksFileUploader,
-		startGate:                NewBlockNumberGate(startBlock),
-		stopBlock:                stopBlock,
-		channelCapacity:          channelCapacity,
-		headBlockUpdateFunc:      headBlockUpdateFunc,
-		zlogger:                  zlogger,
-		blockStreamServer:        blockStreamServer,
-	}, nil
+	p := &MindReaderPlugin{
+		Shutter:              shutter.New(),
+		consoleReaderFactory: consoleReaderFactory,
+		startGate:            NewBlockNumberGate(startBlock),
+		resumeBlock:          startBlock,
+		stopBlock:            stopBlock,
+		channelCapacity:      channelCapacity,
+		lineBufferLines:      defaultLineBufferLines,
+		futureBlockSkew:      DefaultFutureBlockSkew,
+		zlogger:              zlogger,
+		blockStreamServer:    blockStreamServer,
+		liveStreamRetries:    3,
+		liveStreamRetryDelay: 50 * time.Millisecond,
+		liveStreamReconnect:  30 * time.Second,
+		dedupWindow:          DefaultDedupWindow,
+		readDedupWindow:      DefaultReadDedupWindow,
+
+		bundleSize:              DefaultBundleSize,
+		oneBlockFileCompression: DefaultOneBlockFileCompression,
+
+		uploadFailureReadinessTimeout: 5 * time.Minute,
+	}
+	p.OnHeadBlockUpdate(headBlockUpdateFunc)
+
+	p.ctx, p.cancelCtx = context.WithCancel(context.Background())
+	p.OnTerminating(func(_ error) {
+		p.cancelCtx()
+	})
+
+	return p, nil
+}
+
+// SetArchiverMode overrides, at runtime, how the archiver decides to produce merged
+// blocks files or one block files.
+func (p *MindReaderPlugin) SetArchiverMode(mode ArchiverMode) {
+	p.archiver.SetMode(mode)
+}
+
+// SetMergeThresholdBlockAge changes, at runtime, the age over which blocks are merged, effective on
+// the next bundle boundary. A zero `threshold` never merges, a negative one always merges.
+func (p *MindReaderPlugin) SetMergeThresholdBlockAge(threshold time.Duration) {
+	p.archiver.SetMergeThresholdBlockAge(threshold)
+}
+
+// SetPushRateLimit changes the limits of the push rate limiter, the plugin must have been
+// created with `WithPushRateLimit`.
+func (p *MindReaderPlugin) SetPushRateLimit(blocksPerSecond, bytesPerSecond float64) error {
+	if p.pushRateLimiter == nil {
+		return fmt.Errorf("mindreader was not configured with a push rate limiter")
+	}
+
+	p.zlogger.Info("setting push rate limit", zap.Float64("blocks_per_second", blocksPerSecond), zap.Float64("bytes_per_second", bytesPerSecond))
+	return p.pushRateLimiter.SetPushRateLimit(blocksPerSecond, bytesPerSecond)
+}
+
+// IsLiveStreamHealthy returns false when publishing blocks to the block stream server was
+// dropped after repeated failures, blocks are then only archived until it is re-established.
+// Always true without a block stream server.
+func (p *MindReaderPlugin) IsLiveStreamHealthy() bool {
+	if p.liveStream == nil {
+		return true
+	}
+	return p.liveStream.isHealthy()
+}
+
+// PauseUploads holds the uploads of the one block and merged blocks files, once the in-flight
+// ones completed, so the working directory is only written to, e.g. while it is backed up. Blocks
+// keep being archived and pushed to the live block stream. It implements `nodeManager.UploadPauser`.
+func (p *MindReaderPlugin) PauseUploads() {
+	p.oneBlockFileUploader.Pause()
+	p.mergedBlocksFileUploader.Pause()
+}
+
+func (p *MindReaderPlugin) ResumeUploads() {
+	p.oneBlockFileUploader.Resume()
+	p.mergedBlocksFileUploader.Resume()
 }
 
 func (p *MindReaderPlugin) Name() string {
 	return "MindReaderPlugin"
 }
 
+// Launch is called by the superviser on every start of the node. The first call starts the
+// archiver, the uploaders and the read flow, the next ones attach a new pipe, see `reattachPipe`.
 func (p *MindReaderPlugin) Launch() {
-	ctx, cancel := context.WithCancel(context.Background())
+	if p.blocks != nil {
+		p.reattachPipe()
+		return
+	}
+
+	if err := p.checkStopMarker(); err != nil {
+		p.zlogger.Error("not launching mindreader", zap.Error(err))
+		p.Shutdown(err)
+		return
+	}
+
+	if p.suffixClaimer != nil {
+		if err := p.suffixClaimer.acquire(p.ctx); err != nil {
+			p.zlogger.Error("not launching mindreader", zap.Error(err))
+			p.Shutdown(err)
+			return
+		}
+		go p.suffixClaimer.run(p.ctx, p.Terminating())
+	}
+
+	ctx := p.ctx
 	p.OnTerminating(func(err error) {
-		cancel()
+		p.flushContinuityChecker()
 	})
 
 	p.zlogger.Info("starting mindreader")
+	p.journal.Record(journal.Event{Type: journal.EventPluginStarted, Fields: map[string]interface{}{"start_block": p.resumeBlock, "stop_block": p.currentStopBlock()}})
+
+	lines := make(chan string, p.lineBufferLines)
+	consoleReader, err := p.consoleReaderFactory(p.consoleReaderContext(lines))
+	if err != nil {
+		// Nothing launched, `Stop` does not wait for the read flow
+		p.zlogger.Error("not launching mindreader, unable to create console reader", zap.Error(err))
+		p.Shutdown(err)
+		return
+	}
 
 	p.consumeReadFlowDone = make(chan interface{})
 
-	lines := make(chan string, 10000) //need a config here?
+	p.linesLock.Lock()
 	p.lines = lines
+	p.linesClosing = make(chan struct{})
+	p.linesLock.Unlock()
 
-	consoleReader, err := p.consoleReaderFactory(lines)
-	if err != nil {
-		p.Shutdown(err)
-	}
 	p.consoleReader = consoleReader
+	if p.lineQueue != nil {
+		p.startLineQueueDrainer()
+	}
 
 	p.zlogger.Debug("starting archiver")
 	p.archiver.Start(ctx)
@@ -279,49 +878,226 @@ func (p *MindReaderPlugin) Launch() {
 	go p.oneBlockFileUploader.Start(ctx)
 	p.zlogger.Debug("starting file uploader")
 	go p.mergedBlocksFileUploader.Start(ctx)
+	go p.watchReadiness(ctx)
+	if p.watermark != nil {
+		go p.watermark.run(ctx, p.Terminating())
+	}
 
 	p.launch()
-
+	if p.fileTail != nil {
+		p.runFileTail()
+	}
 }
 func (p *MindReaderPlugin) launch() {
-	blocks := make(chan *bstream.Block, p.channelCapacity)
+	p.blocks = make(chan *bstream.Block, p.channelCapacity)
+	p.stats.blocksChannel.Store(p.blocks)
 	p.zlogger.Debug("launching consume read flow", zap.Int("capacity", p.channelCapacity))
-	go p.consumeReadFlow(blocks)
+	go p.consumeReadFlow(p.blocks)
+	p.startReadLoop()
+}
+
+// startReadLoop reads the blocks of the current pipe until it is closed. The blocks channel is
+// closed when done, unless the pipe was detached because the node was relaunched.
+func (p *MindReaderPlugin) startReadLoop() {
+	blocks := p.blocks
+	lines := p.lines
+	detached := make(chan struct{})
+	readLoopDone := make(chan struct{})
+	p.pipeDetached = detached
+	p.readLoopDone = readLoopDone
+
+	go p.watchConsoleReaderDone(p.consoleReader, detached)
 
 	go func() {
+		defer close(readLoopDone)
 		for {
+			if p.discardLinesOnReaderDone && p.consoleReaderDone.Load() {
+				p.zlogger.Info("console reader is done, discarding buffered lines", zap.Int("buffered_lines", len(lines)))
+				drainMessages(lines)
+				close(blocks)
+				return
+			}
+
 			err := p.readOneMessage(blocks)
 			if err != nil {
 				if err == io.EOF {
-					p.zlogger.Info("reached end of console reader stream, nothing more to do")
+					if isClosed(detached) {
+						p.zlogger.Info("reached end of detached console reader stream")
+						return
+					}
+					if p.IsTerminating() || p.stopReached.Load() {
+						p.zlogger.Info("reached end of console reader stream, nothing more to do")
+						close(blocks)
+						return
+					}
+					if p.restartableSource {
+						p.waitForReattach(blocks, detached)
+						return
+					}
+
+					eofErr := &UnexpectedEOFError{LastBlockNum: p.stats.lastBlockNum.Load()}
+					p.zlogger.Error("console reader stream ended before the stop block, shutting down", zap.Error(eofErr))
+					p.Shutdown(eofErr)
 					close(blocks)
 					return
 				}
 				p.zlogger.Error("reading from console logs", zap.Error(err))
-				p.Shutdown(err)
+				if p.maintenanceRequester != nil {
+					go p.requestMaintenance(fmt.Sprintf("reading from console logs: %s", err), nodeManager.MaintenanceSourceMindreaderReadError)
+				} else {
+					p.Shutdown(err)
+				}
 				// Always read messages otherwise you'll stall the shutdown lifecycle of the managed process, leading to corrupted database if exit uncleanly afterward
-				p.drainMessages()
-				close(blocks)
+				drainMessages(lines)
+				if !isClosed(detached) {
+					close(blocks)
+				}
 				return
 			}
+
+			// Lines were consumed, the line buffer gauges follow
+			p.lineBuffer.usage(lines)
 		}
 	}()
 }
 
-func (p MindReaderPlugin) Stop() {
+// reattachPipe gives a new pipe to the relaunched node, the previous node process being done
+// writing to it. The lines left in the previous pipe are read first, blocks keep flowing through
+// the same read flow. Lines logged during the handoff wait for the new pipe, see `writeLine`.
+func (p *MindReaderPlugin) reattachPipe() {
+	if p.IsTerminating() {
+		return
+	}
+
+	p.pipeLock.Lock()
+	defer p.pipeLock.Unlock()
+
+	p.zlogger.Info("node relaunched, attaching mindreader to a new pipe", zap.Int("buffered_lines", len(p.lines)))
+	close(p.pipeDetached)
+	p.closeLinesLocked()
+	<-p.readLoopDone
+
+	if _, err := p.attachPipe(); err != nil {
+		p.Shutdown(fmt.Errorf("creating console reader for relaunched node: %w", err))
+	}
+}
+
+// attachPipe starts reading from a new pipe, the read loop of the previous one being done. It
+// must be called with the pipe lock held.
+func (p *MindReaderPlugin) attachPipe() (chan string, error) {
+	lines := make(chan string, p.lineBufferLines)
+	consoleReader, err := p.consoleReaderFactory(p.consoleReaderContext(lines))
+	if err != nil {
+		return nil, err
+	}
+
+	p.linesLock.Lock()
+	p.lines = lines
+	p.linesClosing = make(chan struct{})
+	p.linesClosed = false
+	p.linesLock.Unlock()
+
+	p.consoleReader = consoleReader
+	p.startReadLoop()
+	return lines, nil
+}
+
+func (p *MindReaderPlugin) consoleReaderContext(lines chan string) ConsoleReaderContext {
+	return ConsoleReaderContext{
+		Lines:            lines,
+		Logger:           p.zlogger,
+		WorkingDirectory: p.workingDirectory,
+		Context:          p.ctx,
+		Terminating:      p.Terminating(),
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *MindReaderPlugin) Stop() {
 	p.zlogger.Info("mindreader is stopping")
-	if p.lines == nil {
+	p.linesLock.RLock()
+	launched := p.lines != nil
+	p.linesLock.RUnlock()
+	if !launched {
 		// If the `lines` channel was not created yet, it means everything was shut down very rapidly
 		// and means MindreaderPlugin has not launched yet. Since it has not launched yet, there is
 		// no point in waiting for the read flow to complete since the read flow never started. So
 		// we exit right now.
+		p.journal.Close()
 		return
 	}
 
 	p.Shutdown(nil)
 
-	close(p.lines)
+	p.waitForFileTail()
+	p.flushLineQueue()
+	p.closeLines()
 	p.waitForReadFlowToComplete()
+
+	if p.suffixClaimer != nil {
+		p.suffixClaimer.release(context.Background())
+	}
+
+	stopped := journal.Event{Type: journal.EventPluginStopped}
+	if err := p.Err(); err != nil {
+		stopped.Reason = err.Error()
+	}
+	p.journal.Record(stopped)
+	p.journal.Close()
+}
+
+// closeLines closes the lines of the current pipe, once. The writers waiting for room in it are
+// released first, their line being dropped unless the pipe is replaced, see `writeLine`.
+func (p *MindReaderPlugin) closeLines() {
+	p.pipeLock.Lock()
+	defer p.pipeLock.Unlock()
+
+	p.closeLinesLocked()
+}
+
+// closeLinesLocked is `closeLines` with the pipe lock held
+func (p *MindReaderPlugin) closeLinesLocked() {
+	if p.linesClosed {
+		return
+	}
+	if p.linesClosing != nil {
+		close(p.linesClosing)
+	}
+
+	p.linesLock.Lock()
+	defer p.linesLock.Unlock()
+
+	p.linesClosed = true
+	close(p.lines)
+}
+
+// watchConsoleReaderDone shuts the plugin down, with a nil error, once the console reader is
+// done. Closing the lines makes `ReadBlock` return `io.EOF` after the lines already received, a
+// line being written concurrently is dropped, see `closeLines`.
+func (p *MindReaderPlugin) watchConsoleReaderDone(consoleReader ConsolerReader, detached <-chan struct{}) {
+	select {
+	case <-consoleReader.Done():
+	case <-p.Terminating():
+		return
+	case <-detached:
+		return
+	}
+	if isClosed(detached) {
+		return
+	}
+
+	p.zlogger.Info("console reader is done, shutting down", zap.Bool("discard_buffered_lines", p.discardLinesOnReaderDone), zap.Int("buffered_lines", len(p.lines)))
+	p.consoleReaderDone.Store(true)
+	p.Shutdown(nil)
+	p.closeLines()
 }
 
 func (p *MindReaderPlugin) waitForReadFlowToComplete() {
@@ -335,12 +1111,37 @@ func (p *MindReaderPlugin) consumeReadFlow(blocks <-chan *bstream.Block) {
 	p.zlogger.Info("starting consume flow")
 	defer close(p.consumeReadFlowDone)
 
+	// Blocks drained after Shutdown must still be stored, the plugin's context is canceled by then
 	ctx := context.Background()
+	var firstBlockNum, lastBlockNum, lastArchivedBlockNum uint64
+	var lastArchivedBlockID string
+	blockSeen := false
+	drainExpired := p.drainExpired(p.consumeReadFlowDone)
+	if p.liveStream != nil && p.pushRateLimiter != nil {
+		p.pushQueue = newPushQueue(p.pushRateLimiter, p.liveStream.push, p.pushQueueSize)
+		p.pushQueue.start()
+		defer p.pushQueue.close()
+	}
 	for {
 		p.zlogger.Debug("waiting to consume next block.")
-		block, ok := <-blocks
+		var block *bstream.Block
+		ok := true
+		select {
+		case block, ok = <-blocks:
+		case <-drainExpired:
+			p.abortReadFlow(blocks, nil)
+			return
+		}
 		if !ok {
 			p.zlogger.Info("all blocks in channel were drained, exiting read flow")
+			p.flushContinuityChecker()
+			// Without flush, the mergeable blocks of the partial bundle stay in the working directory,
+			// the next run resumes the bundle with them
+			if p.stopReached.Load() || p.archiver.flushOnShutdown {
+				if err := p.archiver.flushPartialBundle(ctx); err != nil {
+					p.zlogger.Error("failed flushing partial bundle, its blocks stay in the working directory", zap.Error(err))
+				}
+			}
 			p.archiver.Shutdown(nil)
 			select {
 			case <-time.After(p.waitUploadCompleteOnShutdown):
@@ -349,71 +1150,357 @@ func (p *MindReaderPlugin) consumeReadFlow(blocks <-chan *bstream.Block) {
 				p.zlogger.Info("archiver Terminate done")
 			}
 
+			if p.dryRun != nil {
+				p.dryRun.log(p.zlogger)
+			}
+			p.flushBlockSink(ctx)
+			if p.dedup != nil {
+				p.dedup.close()
+			}
+			if p.readDedup != nil {
+				// The produce read flow closed the blocks channel, it is done with it
+				p.readDedup.close()
+			}
+			p.recordStopMarker(lastArchivedBlockNum, lastArchivedBlockID)
+
+			if p.stopBlockReachFunc != nil && p.stopReached.Load() && lastBlockNum >= p.stoppedAt.Load() {
+				if err := p.stopBlockBarrier(ctx, firstBlockNum, p.stoppedAt.Load()); err != nil {
+					p.zlogger.Error("stop block reached but files are not all visible, not calling stop block reach function", zap.Error(err))
+				}
+			} else if p.waitUploadCompleteOnShutdown != 0 {
+				p.uploadRemainingFiles(p.waitUploadCompleteOnShutdown)
+			}
+			if p.watermark != nil {
+				p.watermark.write(context.Background())
+			}
+
 			return
 		}
 
-		p.zlogger.Debug("got one block", zap.Uint64("block_num", block.Number))
+		if !blockSeen {
+			firstBlockNum = block.Number
+			blockSeen = true
+		}
+		lastBlockNum = block.Number
+		p.metrics.SetBlocksChannelDepth(len(blocks))
+		if p.dryRun != nil {
+			p.dryRun.record(block)
+		}
 
-		err := p.archiver.StoreBlock(ctx, block)
-		if err != nil {
-			p.zlogger.Error("failed storing block in archiver, shutting down and trying to send next blocks individually. You will need to reprocess over this range.", zap.Error(err), zap.Stringer("received_block", block))
+		p.zlogger.Debug("got one block", zap.Uint64("block_num", block.Number))
 
-			if !p.IsTerminating() {
-				p.archiver.currentlyMerging = false // no more merging when broken
-				go p.Shutdown(fmt.Errorf("archiver store block failed: %w", err))
-				continue
+		if p.dedup != nil && p.dedup.seen(block) {
+			p.zlogger.Debug("skipping block already archived", zap.Stringer("received_block", block))
+			metrics.DeduplicatedBlocks.Inc()
+			if p.payloadChecksums != nil {
+				p.payloadChecksums.forget(block)
 			}
-		}
-		if p.blockStreamServer != nil {
-			err = p.blockStreamServer.PushBlock(block)
+			p.releaseBufferedBytes()
+			lastArchivedBlockNum, lastArchivedBlockID = block.Number, block.Id
+		} else {
+			storeStart := time.Now()
+			stored, err := p.storeBlockBeforeDrainExpired(ctx, block, drainExpired)
+			p.metrics.ObserveStoreBlock(time.Since(storeStart))
+			if !stored {
+				p.abortReadFlow(blocks, block)
+				return
+			}
+			p.releaseBufferedBytes()
 			if err != nil {
-				p.zlogger.Error("failed passing block to blockStreamServer (this should not happen, shutting down)", zap.Error(err))
+				p.zlogger.Error("failed storing block in archiver, shutting down and trying to send next blocks individually. You will need to reprocess over this range.", zap.Error(err), zap.Stringer("received_block", block))
+				p.journal.Record(journal.Event{Type: journal.EventArchiverStoreError, BlockNum: block.Number, BlockID: block.Id, Reason: err.Error()})
+
 				if !p.IsTerminating() {
-					go p.Shutdown(fmt.Errorf("blockstreamserver failed: %w", err))
+					p.archiver.currentlyMerging = false // no more merging when broken
+					go p.Shutdown(&ArchiverStoreError{Filename: bundle.BlockFileNameWithSuffix(block, p.archiver.oneblockSuffix), Err: err})
+					continue
+				}
+			} else {
+				p.stats.recordArchived(block)
+				p.events.emitBlockArchived(block.Number, block.Id)
+				lastArchivedBlockNum, lastArchivedBlockID = block.Number, block.Id
+				if p.watermark != nil {
+					p.watermark.archivedBlock(block)
 				}
+				if p.dedup != nil {
+					p.dedup.record(block)
+				}
+			}
+
+			if p.blockSink != nil {
+				if err := p.blockSink.StoreBlock(ctx, block); err != nil {
+					p.zlogger.Error("failed storing block in block sink", zap.Error(err), zap.Stringer("received_block", block))
+				}
+			}
+		}
+
+		p.publish(block)
+	}
+}
+
+// publish pushes `block` to the live stream, through the push queue when the push rate is
+// limited, see `WithPushRateLimit`
+func (p *MindReaderPlugin) publish(block *bstream.Block) {
+	if p.liveStream == nil {
+		return
+	}
+	if p.pushQueue != nil {
+		p.pushQueue.enqueue(block)
+		return
+	}
+	p.liveStream.push(block)
+}
+
+func (p *MindReaderPlugin) flushBlockSink(ctx context.Context) {
+	flusher, ok := p.blockSink.(blockArchiverFlusher)
+	if !ok {
+		return
+	}
+
+	if err := flusher.WaitForAllFilesToUpload(ctx); err != nil {
+		p.zlogger.Error("failed flushing block sink", zap.Error(err))
+	}
+}
+
+// uploadRemainingFiles makes a final upload of the files left by the archiver, retrying failed
+// uploads for at most `timeout`. Files not uploaded stay in the working directory and are uploaded
+// on next start.
+func (p *MindReaderPlugin) uploadRemainingFiles(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := p.WaitForAllFilesToUpload(ctx); err != nil {
+		p.zlogger.Warn("upload may not be complete: final upload on shutdown failed", zap.Error(err))
+		return
+	}
+	p.zlogger.Info("final upload on shutdown done")
+}
 
-				continue
+// WaitForAllFilesToUpload uploads the one block and merged blocks files left in the working
+// directory, returning once they are all uploaded or as soon as `ctx` is done, even if uploads
+// are stuck.
+func (p *MindReaderPlugin) WaitForAllFilesToUpload(ctx context.Context) error {
+	for _, uploader := range []*FileUploader{p.oneBlockFileUploader, p.mergedBlocksFileUploader} {
+		if err := uploader.WaitForAllFilesToUpload(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkContinuity is called on every block read, filtered or not, so a filtered block
+// never looks like a hole to the continuity checker.
+func (p *MindReaderPlugin) checkContinuity(block *bstream.Block) {
+	if p.continuityChecker == nil || p.continuityFailed.Load() {
+		return
+	}
+
+	if err := p.continuityChecker.Write(block.Number); err != nil {
+		p.zlogger.Error("continuity check failed", zap.Error(err), zap.Stringer("received_block", block))
+		p.continuityFailed.Store(true)
+		metrics.ContinuityCheckFailures.Inc()
+		p.journal.Record(journal.Event{Type: journal.EventContinuityFailure, BlockNum: block.Number, BlockID: block.Id, Reason: err.Error()})
+		if p.continuityBreakHandler != nil {
+			go p.continuityBreakHandler(p.HighestContinuousBlockNum()+1, block.Number, p.workingDirectory)
+		}
+		if !p.IsTerminating() {
+			if p.maintenanceRequester != nil {
+				go p.requestMaintenance(fmt.Sprintf("continuity check failed: %s", err), nodeManager.MaintenanceSourceContinuityCheck)
+			} else {
+				go p.Shutdown(&ContinuityBrokenError{Expected: p.HighestContinuousBlockNum() + 1, Got: block.Number, Err: err})
 			}
 		}
+	}
+}
+
+// ResetContinuityChecker resets the continuity checker, if any, so blocks are accepted again
+// from any block number, the node data having been restored
+func (p *MindReaderPlugin) ResetContinuityChecker() {
+	if p.continuityChecker == nil {
+		return
+	}
+
+	p.continuityChecker.Reset()
+	p.continuityFailed.Store(false)
+}
 
+// HighestContinuousBlockNum returns the highest block number written to the continuity checker,
+// 0 if there is none or if it cannot report it
+func (p *MindReaderPlugin) HighestContinuousBlockNum() uint64 {
+	if checker, ok := p.continuityChecker.(interface{ HighestSeenBlock() uint64 }); ok {
+		return checker.HighestSeenBlock()
 	}
+	return 0
 }
 
-func (p *MindReaderPlugin) drainMessages() {
-	for line := range p.lines {
+func (p *MindReaderPlugin) requestMaintenance(reason string, source string) {
+	p.flushContinuityChecker()
+	p.journal.Record(journal.Event{Type: journal.EventMaintenanceRequested, Reason: reason, Fields: map[string]interface{}{"source": source}})
+	if err := p.maintenanceRequester(reason, source); err != nil {
+		p.zlogger.Error("unable to request maintenance, shutting down", zap.String("reason", reason), zap.Error(err))
+		p.Shutdown(fmt.Errorf("maintenance request failed: %w", err))
+	}
+}
+
+func (p *MindReaderPlugin) flushContinuityChecker() {
+	if p.continuityChecker == nil {
+		return
+	}
+
+	if err := p.continuityChecker.Flush(); err != nil {
+		p.zlogger.Error("unable to flush continuity checker", zap.Error(err))
+	}
+}
+
+func drainMessages(lines chan string) {
+	for line := range lines {
 		_ = line
 	}
-	return
 }
 
 func (p *MindReaderPlugin) readOneMessage(blocks chan<- *bstream.Block) error {
+	readStart := time.Now()
 	block, err := p.consoleReader.ReadBlock()
 	if err != nil {
-		return err
+		if err == io.EOF {
+			return err
+		}
+		return &TransformError{Err: err}
 	}
+	readDuration := time.Since(readStart)
+	metrics.ConsoleReadDuration.ObserveDuration(readDuration)
+	p.stats.blocksRead.Inc()
+	p.stats.lastReadTime.Store(p.currentTime().UnixNano())
 
 	if !p.startGate.pass(block) {
+		p.metrics.IncGateDroppedBlocks()
+		return nil
+	}
+	p.recordGatePassed(block)
+
+	if p.stopReached.Load() {
+		p.zlogger.Debug("discarding block read after stop block", zap.Stringer("block", block))
+		return nil
+	}
+
+	if p.replayed(block) {
+		return nil
+	}
+
+	if p.archivingRefused(block) {
+		return nil
+	}
+
+	p.checkContinuity(block)
+
+	transformStart := time.Now()
+	transformed, err := p.transformers.Transform(block)
+	if err != nil {
+		p.metrics.IncTransformerErrors()
+		if p.dryRun != nil {
+			p.dryRun.transformErrors.Inc()
+		}
+		return &TransformError{Block: block.String(), Err: err}
+	}
+	transformDuration := time.Since(transformStart)
+	metrics.TransformDuration.ObserveDuration(transformDuration)
+	p.warnOnSlowProcessing(block, readDuration, transformDuration)
+
+	if transformed == nil {
+		p.zlogger.Debug("block dropped by transformer chain", zap.Stringer("block", block))
 		return nil
 	}
+	block = transformed
 
-	if p.headBlockUpdateFunc != nil {
-		p.headBlockUpdateFunc(block.Num(), block.ID(), block.Time())
+	if err := p.checkPayloadSize(block); err != nil {
+		return err
 	}
 
-	blocks <- block
+	if p.payloadChecksums != nil {
+		if err := p.payloadChecksums.attach(block); err != nil {
+			return err
+		}
+	}
+
+	p.checkBlockTime(block)
+	p.updateHeadBlock(block.Num(), block.ID(), block.Time(), block.LIBNum())
 
-	if p.stopBlock != 0 && block.Num() >= p.stopBlock && !p.IsTerminating() {
-		p.zlogger.Info("shutting down because requested end block reached", zap.Uint64("block_num", block.Num()))
-		go p.Shutdown(nil)
+	if err := p.acquireBufferedBytes(block); err != nil {
+		return err
 	}
+	p.sendBlock(blocks, block)
+	p.checkStop(block)
 
 	return nil
 }
 
-// LogLine receives log line and write it to "pipe" of the local console reader
+func (p *MindReaderPlugin) warnOnSlowProcessing(block *bstream.Block, readDuration, transformDuration time.Duration) {
+	if p.slowProcessingThreshold == 0 || (readDuration < p.slowProcessingThreshold && transformDuration < p.slowProcessingThreshold) {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(p.lastSlowProcessingWarning) < slowProcessingWarningInterval {
+		return
+	}
+	p.lastSlowProcessingWarning = now
+
+	p.zlogger.Warn("slow block processing, reading from the node may be backing up",
+		zap.Uint64("block_num", block.Number),
+		zap.Duration("console_read", readDuration),
+		zap.Duration("transform", transformDuration),
+		zap.Duration("threshold", p.slowProcessingThreshold),
+	)
+}
+
+// LogLine receives log line and write it to "pipe" of the local console reader, through the
+// line buffer (see `WithLineBufferCapacity` and `WithLineWriteTimeout`)
 func (p *MindReaderPlugin) LogLine(in string) {
+	if p.fileTail != nil {
+		return
+	}
+	p.handleLine(in)
+}
+
+func (p *MindReaderPlugin) handleLine(in string) {
 	if p.IsTerminating() {
 		return
 	}
-	p.lines <- in
+	if p.logLinePrefilter != nil && !p.logLinePrefilter(in) {
+		return
+	}
+
+	if p.lineQueue != nil {
+		p.queueLine(in)
+		return
+	}
+	p.writeLine(in)
+}
+
+// writeLine writes `in` to the lines of the current pipe. A line written while the pipe is
+// replaced, the node being relaunched, waits for the new pipe (see `reattachPipe`), lines written
+// once the lines are closed for good are dropped.
+func (p *MindReaderPlugin) writeLine(in string) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			// The lines were closed, wait for the handoff to a new pipe to complete, if any
+			p.pipeLock.Lock()
+			p.pipeLock.Unlock()
+		}
+
+		p.linesLock.RLock()
+		if p.linesClosed {
+			p.linesLock.RUnlock()
+			continue
+		}
+		ok, waited := p.lineBuffer.write(p.lines, p.linesClosing, in)
+		p.linesLock.RUnlock()
+
+		if waited > 0 {
+			p.declareStuck(waited)
+		}
+		if ok || waited > 0 || p.lineBuffer.isStuck() {
+			return
+		}
+	}
 }
@@ -1,10 +1,14 @@
 package mindreader
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -13,6 +17,11 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger/bundle"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/dstorefault"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
 	"github.com/streamingfast/shutter"
 )
 
@@ -104,7 +113,7 @@ func TestMindReaderPlugin_StopAtBlockNumReached(t *testing.T) {
 		zlogger:       testLogger,
 	}
 	mindReader.OnTerminating(func(err error) {
-		if err == nil {
+		if errors.Is(err, ErrStopBlockReached) {
 			close(done)
 		} else {
 			t.Error("should not be called")
@@ -141,6 +150,158 @@ func TestMindReaderPlugin_StopAtBlockNumReached(t *testing.T) {
 	assert.Equal(t, numOfLines, len(blocks)) // moderate requirement, race condition can make it pass more blocks
 }
 
+func TestMindReaderPlugin_BlockFilter(t *testing.T) {
+	numOfLines := 3
+	lines := make(chan string, numOfLines)
+	blocks := make(chan *bstream.Block, numOfLines)
+
+	var headBlocks []uint64
+	mindReader := &MindReaderPlugin{
+		Shutter:           shutter.New(),
+		lines:             lines,
+		consoleReader:     newTestConsoleReader(lines),
+		startGate:         NewBlockNumberGate(0),
+		continuityChecker: &recordingContinuityChecker{},
+		headBlockUpdaters: []nodeManager.HeadBlockUpdater{func(blockNum uint64, blockID string, blockTime time.Time, libNum uint64) {
+			headBlocks = append(headBlocks, blockNum)
+		}},
+		transformers: NewTransformerChain(BlockFilter(func(block *bstream.Block) (bool, error) {
+			return block.Number != 2, nil
+		}).Transformer()),
+		zlogger: testLogger,
+	}
+
+	mindReader.LogLine(`DMLOG {"id":"00000001a"}`)
+	mindReader.LogLine(`DMLOG {"id":"00000002a"}`)
+	mindReader.LogLine(`DMLOG {"id":"00000003a"}`)
+
+	for i := 0; i < numOfLines; i++ {
+		require.NoError(t, mindReader.readOneMessage(blocks))
+	}
+	close(blocks)
+
+	var received []uint64
+	for block := range blocks {
+		received = append(received, block.Number)
+	}
+
+	assert.Equal(t, []uint64{1, 3}, received)
+	assert.Equal(t, []uint64{1, 3}, headBlocks)
+	assert.Equal(t, []uint64{1, 2, 3}, mindReader.continuityChecker.(*recordingContinuityChecker).written)
+	assert.False(t, mindReader.continuityFailed.Load())
+}
+
+func TestMindReaderPlugin_BlockFilterError(t *testing.T) {
+	lines := make(chan string, 1)
+	blocks := make(chan *bstream.Block, 1)
+
+	mindReader := &MindReaderPlugin{
+		Shutter:       shutter.New(),
+		lines:         lines,
+		consoleReader: newTestConsoleReader(lines),
+		startGate:     NewBlockNumberGate(0),
+		transformers: NewTransformerChain(BlockFilter(func(block *bstream.Block) (bool, error) {
+			return false, fmt.Errorf("boom")
+		}).Transformer()),
+		zlogger: testLogger,
+	}
+
+	mindReader.LogLine(`DMLOG {"id":"00000001a"}`)
+
+	err := mindReader.readOneMessage(blocks)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+	assert.Len(t, blocks, 0)
+}
+
+// backgroundConsoleReader runs background work, like caching ABIs, until the plugin terminates
+type backgroundConsoleReader struct {
+	*testConsoleReader
+	stopped chan struct{}
+}
+
+func newBackgroundConsoleReader(ctx ConsoleReaderContext) *backgroundConsoleReader {
+	reader := &backgroundConsoleReader{testConsoleReader: newTestConsoleReader(ctx.Lines), stopped: make(chan struct{})}
+	go func() {
+		defer close(reader.stopped)
+		<-ctx.Terminating
+		<-ctx.Context.Done()
+		ctx.Logger.Debug("console reader background work stopped")
+	}()
+	return reader
+}
+
+func TestMindReaderPlugin_ConsoleReaderObservesTermination(t *testing.T) {
+	workingDirectory := t.TempDir()
+	p, err := NewMindReaderPlugin(t.TempDir(), t.TempDir(), "never", workingDirectory, nil, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer)
+	require.NoError(t, err)
+
+	var readers []*backgroundConsoleReader
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		assert.Equal(t, workingDirectory, ctx.WorkingDirectory)
+		assert.Same(t, testLogger, ctx.Logger)
+
+		reader := newBackgroundConsoleReader(ctx)
+		readers = append(readers, reader)
+		return reader, nil
+	}
+
+	p.Launch()
+	require.Len(t, readers, 1)
+
+	select {
+	case <-readers[0].stopped:
+		t.Fatal("background work stopped before the plugin terminated")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	p.Stop()
+	select {
+	case <-readers[0].stopped:
+	case <-time.After(time.Second):
+		t.Fatal("background work not stopped once the plugin terminated")
+	}
+}
+
+func TestMindReaderPlugin_ConsoleReaderFactoryError(t *testing.T) {
+	p, err := NewMindReaderPlugin(t.TempDir(), t.TempDir(), "never", t.TempDir(), nil, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer)
+	require.NoError(t, err)
+
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return nil, fmt.Errorf("unsupported node version")
+	}
+
+	p.Launch()
+	require.True(t, p.IsTerminating())
+	assert.EqualError(t, p.Err(), "unsupported node version")
+
+	p.LogLine("line after failed launch")
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("plugin not stopped")
+	}
+}
+
+func TestAdaptLegacyFactory(t *testing.T) {
+	var received chan string
+	factory := AdaptLegacyFactory(func(lines chan string) (ConsolerReader, error) {
+		received = lines
+		return newTestConsoleReader(lines), nil
+	})
+
+	lines := make(chan string)
+	reader, err := factory(ConsoleReaderContext{Lines: lines, Logger: testLogger})
+	require.NoError(t, err)
+	assert.Equal(t, lines, received)
+	assert.Equal(t, lines, reader.(*testConsoleReader).lines)
+}
+
 func TestMindReaderPlugin_OneBlockSuffixFormat(t *testing.T) {
 	assert.Error(t, validateOneBlockSuffix(""))
 	assert.NoError(t, validateOneBlockSuffix("example"))
@@ -149,6 +310,18 @@ func TestMindReaderPlugin_OneBlockSuffixFormat(t *testing.T) {
 	assert.Equal(t, `oneblock_suffix contains invalid characters: "example.lan"`, validateOneBlockSuffix("example.lan").Error())
 }
 
+// newReadTestPlugin returns a plugin reading blocks from `consoleReader`, fed with `lines`
+// (nil when it does not read lines), to test the read flow without launching it
+func newReadTestPlugin(lines chan string, consoleReader ConsolerReader) *MindReaderPlugin {
+	return &MindReaderPlugin{
+		Shutter:       shutter.New(),
+		lines:         lines,
+		consoleReader: consoleReader,
+		startGate:     NewBlockNumberGate(0),
+		zlogger:       testLogger,
+	}
+}
+
 type testConsoleReader struct {
 	lines chan string
 	done  chan interface{}
@@ -165,7 +338,10 @@ func (c *testConsoleReader) Done() <-chan interface{} {
 }
 
 func (c *testConsoleReader) ReadBlock() (*bstream.Block, error) {
-	line, _ := <-c.lines
+	line, ok := <-c.lines
+	if !ok {
+		return nil, io.EOF
+	}
 	formatedLine := line[6:]
 
 	type block struct {
@@ -192,3 +368,435 @@ func toBlockNum(blockID string) uint64 {
 	}
 	return uint64(binary.BigEndian.Uint32(bin))
 }
+
+type recordingContinuityChecker struct {
+	written []uint64
+}
+
+func (c *recordingContinuityChecker) IsLocked() bool { return false }
+func (c *recordingContinuityChecker) Reset()         {}
+func (c *recordingContinuityChecker) Flush() error   { return nil }
+func (c *recordingContinuityChecker) Write(lastSeenBlockNum uint64) error {
+	c.written = append(c.written, lastSeenBlockNum)
+	return nil
+}
+
+type gatedConsoleReader struct {
+	*testConsoleReader
+	release chan struct{}
+}
+
+func (c *gatedConsoleReader) ReadBlock() (*bstream.Block, error) {
+	<-c.release
+	return c.testConsoleReader.ReadBlock()
+}
+
+func TestMindReaderPlugin_ConsoleReaderDone(t *testing.T) {
+	tests := []struct {
+		name          string
+		discard       bool
+		expectedCount int
+	}{
+		{"buffered lines read", false, 3},
+		{"buffered lines discarded", true, 1}, // the read in progress when done fires completes
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, headBlocks := newReplayTestPlugin(t, 0, 0)
+			p.discardLinesOnReaderDone = test.discard
+
+			done := make(chan interface{})
+			release := make(chan struct{})
+			p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+				reader := newTestConsoleReader(ctx.Lines)
+				reader.done = done
+				return &gatedConsoleReader{testConsoleReader: reader, release: release}, nil
+			}
+
+			var shutdownErr error
+			p.OnTerminating(func(err error) { shutdownErr = err })
+
+			p.Launch()
+			p.LogLine(`DMLOG {"id":"00000001a"}`)
+			p.LogLine(`DMLOG {"id":"00000002a"}`)
+			p.LogLine(`DMLOG {"id":"00000003a"}`)
+
+			close(done)
+			select {
+			case <-p.Terminating():
+			case <-time.After(time.Second):
+				t.Fatal("plugin not shut down after console reader done")
+			}
+
+			p.LogLine(`DMLOG {"id":"00000004a"}`) // dropped, plugin is terminating
+			close(release)
+
+			select {
+			case <-p.consumeReadFlowDone:
+			case <-time.After(time.Second):
+				t.Fatal("read flow not completed after console reader done")
+			}
+
+			assert.NoError(t, shutdownErr)
+			if test.discard {
+				assert.LessOrEqual(t, len(headBlocks()), test.expectedCount)
+			} else {
+				assert.Equal(t, []uint64{1, 2, 3}, headBlocks())
+			}
+
+			p.Stop() // lines already closed, must not panic
+		})
+	}
+}
+
+func TestMindReaderPlugin_ConsoleReaderDoneWhileLogging(t *testing.T) {
+	p, _ := newReplayTestPlugin(t, 0, 0)
+	p.lineBufferLines = 1
+
+	done := make(chan interface{})
+	release := make(chan struct{})
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		reader := newTestConsoleReader(ctx.Lines)
+		reader.done = done
+		return &gatedConsoleReader{testConsoleReader: reader, release: release}, nil
+	}
+
+	p.Launch()
+
+	// The console reader does not read, the writer waits for room in the lines when done fires
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		for i := uint64(1); i <= 100; i++ {
+			p.LogLine(fmt.Sprintf(`DMLOG {"id":"%08xa"}`, i))
+		}
+	}()
+
+	close(done)
+	select {
+	case <-logged:
+	case <-time.After(time.Second):
+		t.Fatal("writer not released once the console reader is done")
+	}
+	close(release)
+
+	select {
+	case <-p.consumeReadFlowDone:
+	case <-time.After(time.Second):
+		t.Fatal("read flow not completed after console reader done")
+	}
+	assert.NoError(t, p.Err())
+	p.Stop()
+}
+
+func TestMindReaderPlugin_RelaunchReattachesPipe(t *testing.T) {
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+
+	var pipes []chan string
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		pipes = append(pipes, ctx.Lines)
+		return newTestConsoleReader(ctx.Lines), nil
+	}
+
+	p.Launch()
+	p.LogLine(`DMLOG {"id":"00000001a"}`)
+	p.LogLine(`DMLOG {"id":"00000002a"}`)
+
+	// The node exited and is started again, lines left in the previous pipe are still read
+	p.Launch()
+	require.Len(t, pipes, 2)
+	assert.NotEqual(t, pipes[0], pipes[1])
+	_, open := <-pipes[0]
+	assert.False(t, open, "previous pipe closed")
+
+	p.LogLine(`DMLOG {"id":"00000003a"}`)
+	p.Launch()
+	p.LogLine(`DMLOG {"id":"00000004a"}`)
+	require.Len(t, pipes, 3)
+
+	p.Stop()
+	select {
+	case <-p.consumeReadFlowDone:
+	case <-time.After(time.Second):
+		t.Fatal("read flow not completed")
+	}
+
+	assert.NoError(t, p.Err())
+	assert.Equal(t, []uint64{1, 2, 3, 4}, headBlocks())
+}
+
+func TestMindReaderPlugin_RelaunchWhileLogging(t *testing.T) {
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+	p.lineBufferLines = 1
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return newTestConsoleReader(ctx.Lines), nil
+	}
+
+	p.Launch()
+
+	var expected []uint64
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		for i := uint64(1); i <= 500; i++ {
+			p.LogLine(fmt.Sprintf(`DMLOG {"id":"%08xa"}`, i))
+		}
+	}()
+	for i := uint64(1); i <= 500; i++ {
+		expected = append(expected, i)
+	}
+
+	// Lines logged while the pipe is replaced wait for the new one, none is lost
+	for i := 0; i < 20; i++ {
+		p.Launch()
+	}
+	<-logged
+
+	p.Stop()
+	select {
+	case <-p.consumeReadFlowDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("read flow not completed")
+	}
+
+	assert.NoError(t, p.Err())
+	assert.Equal(t, expected, headBlocks())
+}
+
+func TestMindReaderPlugin_StopBlockFlushesPartialBundle(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPlugin(t.TempDir(), t.TempDir(), "always", t.TempDir(), consoleReaderFactory, 0, 350, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer)
+	require.NoError(t, err)
+
+	p.Launch()
+	generator := mindreadertest.NewBlockGenerator("stop", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(300, 51)) {
+		p.LogLine(line)
+	}
+
+	select {
+	case <-p.Terminating():
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not shut down after stop block")
+	}
+	p.Stop()
+
+	var archived []uint64
+	require.NoError(t, p.oneBlockFileUploader.destinationStore.Walk(context.Background(), "", func(filename string) error {
+		archived = append(archived, bundle.MustNewOneBlockFile(filename).Num)
+		return nil
+	}))
+
+	var expected []uint64
+	for num := uint64(300); num <= 350; num++ {
+		expected = append(expected, num)
+	}
+	assert.Equal(t, expected, archived, "blocks of the partial bundle sent as one block files")
+}
+
+func TestMindReaderPlugin_PartialBundleOnShutdown(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	generator := mindreadertest.NewBlockGenerator("partial", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
+	blocks := generator.Blocks(100, 101)
+
+	// run reads `lines` then stops gracefully, every line read being stored
+	run := func(t *testing.T, workDir, oneBlocksDir, mergedDir string, lines []string, options ...MindReaderPluginOption) {
+		t.Helper()
+
+		p, err := NewMindReaderPlugin(oneBlocksDir, mergedDir, "always", workDir, consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer, options...)
+		require.NoError(t, err)
+
+		p.Launch()
+		for _, line := range lines {
+			p.LogLine(line)
+		}
+		p.Stop()
+	}
+
+	walk := func(t *testing.T, dir string) (nums []uint64) {
+		t.Helper()
+
+		store, err := dstore.NewDBinStore(dir)
+		require.NoError(t, err)
+		require.NoError(t, store.Walk(context.Background(), "", func(filename string) error {
+			if num, err := strconv.ParseUint(filename, 10, 64); err == nil {
+				nums = append(nums, num)
+				return nil
+			}
+			nums = append(nums, bundle.MustNewOneBlockFile(filename).Num)
+			return nil
+		}))
+		return nums
+	}
+
+	t.Run("resumed by the next run", func(t *testing.T) {
+		workDir, oneBlocksDir, mergedDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+		run(t, workDir, oneBlocksDir, mergedDir, mindreadertest.FormatLines(blocks[:51]))
+		assert.Empty(t, walk(t, oneBlocksDir), "blocks 100 to 150 kept in the working directory")
+
+		run(t, workDir, oneBlocksDir, mergedDir, mindreadertest.FormatLines(blocks[51:]))
+		assert.Equal(t, []uint64{100}, walk(t, mergedDir), "one complete bundle from blocks of both runs")
+		assert.Empty(t, walk(t, oneBlocksDir))
+	})
+
+	t.Run("flushed on shutdown", func(t *testing.T) {
+		workDir, oneBlocksDir, mergedDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+		run(t, workDir, oneBlocksDir, mergedDir, mindreadertest.FormatLines(blocks[:51]), WithArchiverOptions(WithFlushOnShutdown()))
+
+		var expected []uint64
+		for num := uint64(100); num <= 150; num++ {
+			expected = append(expected, num)
+		}
+		assert.Equal(t, expected, walk(t, oneBlocksDir), "blocks of the partial bundle sent as one block files")
+		assert.Empty(t, walk(t, mergedDir))
+	})
+}
+
+func TestMindReaderPluginWithStores_FlakyArchiveStore(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	localArchive, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	archiveStore := dstorefault.Wrap(localArchive, dstorefault.FaultPolicy{Faults: []dstorefault.Fault{
+		{Operations: []dstorefault.Operation{dstorefault.PushLocalFile, dstorefault.WriteObject}, Err: dstorefault.ErrForbidden, Every: 7},
+		{Operations: []dstorefault.Operation{dstorefault.PushLocalFile, dstorefault.WriteObject}, Latency: time.Millisecond},
+	}})
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(archiveStore, mergeArchiveStore, "always", t.TempDir(), consoleReaderFactory, 0, 350, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer,
+		WithUploadRetryPolicy(UploadRetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}),
+		WithUploadPollInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	p.Launch()
+	generator := mindreadertest.NewBlockGenerator("flaky", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(300, 51)) {
+		p.LogLine(line)
+	}
+
+	select {
+	case <-p.Terminating():
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not shut down after stop block")
+	}
+	p.Stop()
+
+	var archived []uint64
+	require.NoError(t, localArchive.Walk(context.Background(), "", func(filename string) error {
+		archived = append(archived, bundle.MustNewOneBlockFile(filename).Num)
+		return nil
+	}))
+	assert.Len(t, archived, 51, "failed uploads retried")
+
+	injected := archiveStore.Injected(dstorefault.PushLocalFile) + archiveStore.Injected(dstorefault.WriteObject)
+	assert.Greater(t, injected, 0)
+}
+
+type recordingPusher struct {
+	lock   sync.Mutex
+	pushed []uint64
+}
+
+func (p *recordingPusher) PushBlock(blk *bstream.Block) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pushed = append(p.pushed, blk.Number)
+	return nil
+}
+
+func (p *recordingPusher) pushedCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.pushed)
+}
+
+func TestMindReaderPlugin_PausedUploadsKeepLiveStream(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	archiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(archiveStore, mergeArchiveStore, "never", t.TempDir(), consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer,
+		WithUploadPollInterval(5*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer p.Stop()
+
+	pusher := &recordingPusher{}
+	p.liveStream = newLiveStream(pusher, 0, 0, time.Second, testLogger)
+
+	archivedCount := func() int {
+		files, err := archiveStore.ListFiles(context.Background(), "", 100)
+		require.NoError(t, err)
+		return len(files)
+	}
+
+	p.PauseUploads()
+	p.Launch()
+	generator := mindreadertest.NewBlockGenerator("paused", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(1, 20)) {
+		p.LogLine(line)
+	}
+
+	require.Eventually(t, func() bool { return pusher.pushedCount() == 20 }, 5*time.Second, 5*time.Millisecond, "blocks pushed to the live stream while uploads are paused")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, archivedCount(), "no upload while paused")
+
+	p.ResumeUploads()
+	assert.Eventually(t, func() bool { return archivedCount() == 20 }, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestMindReaderPlugin_BundleSize(t *testing.T) {
+	newPlugin := func(options ...MindReaderPluginOption) (*MindReaderPlugin, error) {
+		return NewMindReaderPluginWithStores(dstore.NewMockStore(nil), dstore.NewMockStore(nil), "never", t.TempDir(), nil, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer, options...)
+	}
+
+	p, err := newPlugin()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBundleSize, p.archiver.bundleSize)
+
+	p, err = newPlugin(WithBundleSize(1000))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), p.archiver.bundleSize)
+
+	for _, size := range []uint64{0, 1} {
+		_, err = newPlugin(WithBundleSize(size))
+		assert.EqualError(t, err, fmt.Sprintf("invalid bundle size %d, must be greater than 1", size))
+	}
+}
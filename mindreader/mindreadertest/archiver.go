@@ -0,0 +1,128 @@
+package mindreadertest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// MemoryArchiver is an in-memory `mindreader.BlockArchiver` (see `mindreader.WithBlockSink`)
+// recording the blocks it stores. Stored blocks are uploaded by `WaitForAllFilesToUpload`,
+// latency and failures of both operations can be simulated.
+type MemoryArchiver struct {
+	// StoreLatency and UploadLatency delay each `StoreBlock` and `WaitForAllFilesToUpload` call
+	StoreLatency  time.Duration
+	UploadLatency time.Duration
+
+	lock          sync.Mutex
+	stored        []*bstream.Block
+	uploaded      []*bstream.Block
+	pending       []*bstream.Block
+	storeFailures []error
+	uploadFailure error
+}
+
+func NewMemoryArchiver() *MemoryArchiver {
+	return &MemoryArchiver{}
+}
+
+// FailNextStores makes the next `count` calls to `StoreBlock` return `err`, the blocks are not stored
+func (a *MemoryArchiver) FailNextStores(count int, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for i := 0; i < count; i++ {
+		a.storeFailures = append(a.storeFailures, err)
+	}
+}
+
+// FailUploads makes `WaitForAllFilesToUpload` return `err`, until called with a nil error
+func (a *MemoryArchiver) FailUploads(err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.uploadFailure = err
+}
+
+func (a *MemoryArchiver) StoreBlock(ctx context.Context, block *bstream.Block) error {
+	if err := sleep(ctx, a.StoreLatency); err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.storeFailures) > 0 {
+		err := a.storeFailures[0]
+		a.storeFailures = a.storeFailures[1:]
+		return err
+	}
+
+	a.stored = append(a.stored, block)
+	a.pending = append(a.pending, block)
+	return nil
+}
+
+// WaitForAllFilesToUpload uploads every block stored since the last successful call
+func (a *MemoryArchiver) WaitForAllFilesToUpload(ctx context.Context) error {
+	if err := sleep(ctx, a.UploadLatency); err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.uploadFailure != nil {
+		return a.uploadFailure
+	}
+
+	a.uploaded = append(a.uploaded, a.pending...)
+	a.pending = nil
+	return nil
+}
+
+// Blocks returns the stored blocks, in order
+func (a *MemoryArchiver) Blocks() []*bstream.Block {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return append([]*bstream.Block(nil), a.stored...)
+}
+
+// ArchivedBlockNums returns the numbers of the stored blocks, in order
+func (a *MemoryArchiver) ArchivedBlockNums() []uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return blockNums(a.stored)
+}
+
+// UploadedBlockNums returns the numbers of the uploaded blocks, in order
+func (a *MemoryArchiver) UploadedBlockNums() []uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return blockNums(a.uploaded)
+}
+
+func blockNums(blocks []*bstream.Block) (out []uint64) {
+	for _, blk := range blocks {
+		out = append(out, blk.Number)
+	}
+	return
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d == 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,59 @@
+package mindreadertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryArchiver(t *testing.T) {
+	generator := NewBlockGenerator("archiver", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	archiver := NewMemoryArchiver()
+	ctx := context.Background()
+
+	failure := errors.New("disk full")
+	archiver.FailNextStores(1, failure)
+
+	blocks := generator.Blocks(1, 3)
+	assert.Equal(t, failure, archiver.StoreBlock(ctx, blocks[0]))
+	require.NoError(t, StoreBlocks(ctx, archiver, blocks[1:]))
+	RequireBlocksArchived(t, archiver, 2, 3)
+	RequireBlocksUploaded(t, archiver)
+
+	archiver.FailUploads(failure)
+	assert.Equal(t, failure, archiver.WaitForAllFilesToUpload(ctx))
+	RequireBlocksUploaded(t, archiver)
+
+	archiver.FailUploads(nil)
+	require.NoError(t, archiver.WaitForAllFilesToUpload(ctx))
+	RequireBlocksUploaded(t, archiver, 2, 3)
+	assert.Equal(t, blocks[1:], archiver.Blocks())
+}
+
+func TestMemoryArchiver_Latency(t *testing.T) {
+	generator := NewBlockGenerator("archiver", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	archiver := NewMemoryArchiver()
+	archiver.UploadLatency = time.Hour
+
+	require.NoError(t, archiver.StoreBlock(context.Background(), generator.Block(1, 1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, archiver.WaitForAllFilesToUpload(ctx))
+	RequireBlocksUploaded(t, archiver)
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	clock := NewClock(start)
+
+	assert.Equal(t, start, clock.Now())
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
@@ -0,0 +1,40 @@
+package mindreadertest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ArchivedBlocksReporter is implemented by `MemoryArchiver` and `RecordingArchiverIO`
+type ArchivedBlocksReporter interface {
+	ArchivedBlockNums() []uint64
+}
+
+// UploadedBlocksReporter is implemented by `MemoryArchiver`
+type UploadedBlocksReporter interface {
+	UploadedBlockNums() []uint64
+}
+
+// RequireBlocksArchived fails the test unless exactly the blocks `nums` were archived, in order.
+// With a plugin, call it once its read flow completed (after `Stop`).
+func RequireBlocksArchived(t testing.TB, archiver ArchivedBlocksReporter, nums ...uint64) {
+	t.Helper()
+
+	require.Equal(t, normalizeBlockNums(nums), normalizeBlockNums(archiver.ArchivedBlockNums()), "archived blocks")
+}
+
+// RequireBlocksUploaded fails the test unless exactly the blocks `nums` were uploaded, in order
+func RequireBlocksUploaded(t testing.TB, archiver UploadedBlocksReporter, nums ...uint64) {
+	t.Helper()
+
+	require.Equal(t, normalizeBlockNums(nums), normalizeBlockNums(archiver.UploadedBlockNums()), "uploaded blocks")
+}
+
+// normalizeBlockNums so that no blocks compares equal whether nil or empty
+func normalizeBlockNums(nums []uint64) []uint64 {
+	if len(nums) == 0 {
+		return nil
+	}
+	return nums
+}
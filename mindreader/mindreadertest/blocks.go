@@ -0,0 +1,132 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mindreadertest contains helpers to test code built on top of the
+// mindreader package: a deterministic block generator, a recording ArchiverIO,
+// golden files comparison of the archiver outputs, an in-memory archiver, a
+// scriptable console reader, a deterministic clock and assertions on archived blocks.
+package mindreadertest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/bundle"
+)
+
+// BlockGenerator generates linked blocks whose IDs are derived from a seed, two generators
+// with the same seed and base time always produce the exact same blocks.
+type BlockGenerator struct {
+	Seed     string
+	BaseTime time.Time
+
+	// Interval is the time between two consecutive blocks, defaults to 1s
+	Interval time.Duration
+
+	// LIBLag is the distance between a block and its LIB, LIB never goes below the first block generated
+	LIBLag uint64
+}
+
+func NewBlockGenerator(seed string, baseTime time.Time) *BlockGenerator {
+	return &BlockGenerator{
+		Seed:     seed,
+		BaseTime: baseTime,
+		Interval: time.Second,
+	}
+}
+
+// ID returns the deterministic ID of block `num`. The block number is encoded in
+// hexadecimal in the first 8 characters, the rest is derived from the seed.
+func (g *BlockGenerator) ID(num uint64) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", g.Seed, num)))
+	return fmt.Sprintf("%08x%s", num, hex.EncodeToString(hash[:12]))
+}
+
+// Block returns block `num`, linked to block `num - 1` and with `lowestBlockNum` as
+// the lowest possible LIB.
+func (g *BlockGenerator) Block(num uint64, lowestBlockNum uint64) *bstream.Block {
+	libNum := lowestBlockNum
+	if num > lowestBlockNum+g.LIBLag {
+		libNum = num - g.LIBLag
+	}
+
+	previousID := ""
+	if num > 0 {
+		previousID = g.ID(num - 1)
+	}
+
+	blk := &bstream.Block{
+		Id:         g.ID(num),
+		Number:     num,
+		PreviousId: previousID,
+		Timestamp:  g.BaseTime.Add(time.Duration(num) * g.Interval).UTC(),
+		LibNum:     libNum,
+	}
+
+	blk, _ = bstream.MemoryBlockPayloadSetter(blk, []byte(blk.Id))
+	return blk
+}
+
+// Blocks returns `count` consecutive blocks starting at `startBlockNum`
+func (g *BlockGenerator) Blocks(startBlockNum uint64, count int) (out []*bstream.Block) {
+	for i := 0; i < count; i++ {
+		out = append(out, g.Block(startBlockNum+uint64(i), startBlockNum))
+	}
+	return
+}
+
+// Fork returns a generator producing blocks that are different from this generator's blocks
+// but share the exact same parameters, blocks of the fork can be linked back to the canonical
+// chain by using `ForkBlock`.
+func (g *BlockGenerator) Fork(name string) *BlockGenerator {
+	out := *g
+	out.Seed = g.Seed + "/" + name
+	return &out
+}
+
+// ForkBlock returns block `num` of the `fork` generator with its parent being block `num - 1`
+// of this generator.
+func (g *BlockGenerator) ForkBlock(fork *BlockGenerator, num uint64, lowestBlockNum uint64) *bstream.Block {
+	blk := fork.Block(num, lowestBlockNum)
+	if num > 0 {
+		blk.PreviousId = g.ID(num - 1)
+	}
+	return blk
+}
+
+// BlockFromOneBlockFile turns a one block file (usually created from a file name through
+// `bundle.MustNewOneBlockFile`) into the block it represents, payload is left empty.
+func BlockFromOneBlockFile(oneBlockFile *bundle.OneBlockFile) *bstream.Block {
+	return &bstream.Block{
+		Id:             oneBlockFile.ID,
+		Number:         oneBlockFile.Num,
+		PreviousId:     oneBlockFile.PreviousID,
+		Timestamp:      oneBlockFile.BlockTime,
+		LibNum:         oneBlockFile.LibNum(),
+		PayloadKind:    0,
+		PayloadVersion: 0,
+		Payload:        nil,
+	}
+}
+
+// BlocksFromOneBlockFileNames is a shortcut to turn a list of one block file names into blocks
+func BlocksFromOneBlockFileNames(fileNames ...string) (out []*bstream.Block) {
+	for _, fileName := range fileNames {
+		out = append(out, BlockFromOneBlockFile(bundle.MustNewOneBlockFile(fileName)))
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package mindreadertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockGenerator(t *testing.T) {
+	baseTime := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	generator := NewBlockGenerator("seed", baseTime)
+	generator.LIBLag = 2
+
+	blocks := generator.Blocks(10, 4)
+	require.Len(t, blocks, 4)
+
+	assert.Equal(t, NewBlockGenerator("seed", baseTime).ID(11), blocks[1].Id)
+	assert.NotEqual(t, NewBlockGenerator("other", baseTime).ID(11), blocks[1].Id)
+	assert.Equal(t, "0000000b", blocks[1].Id[:8])
+
+	for i := 1; i < len(blocks); i++ {
+		assert.Equal(t, blocks[i-1].Id, blocks[i].PreviousId)
+		assert.Equal(t, blocks[i-1].Time().Add(time.Second), blocks[i].Time())
+	}
+
+	assert.Equal(t, []uint64{10, 10, 10, 11}, []uint64{blocks[0].LibNum, blocks[1].LibNum, blocks[2].LibNum, blocks[3].LibNum})
+
+	fork := generator.Fork("a")
+	forked := generator.ForkBlock(fork, 12, 10)
+	assert.NotEqual(t, blocks[2].Id, forked.Id)
+	assert.Equal(t, blocks[1].Id, forked.PreviousId)
+}
+
+func TestNormalizeFileName(t *testing.T) {
+	assert.Equal(t, "0000000001-<time>-0000001a-0000000a-0-suffix", NormalizeFileName("0000000001-20210728T105016.01-0000001a-0000000a-0-suffix"))
+	assert.Equal(t, "0000000001-<time>-0000001a-0000000a-0", NormalizeFileName("0000000001-20210728T105016-0000001a-0000000a-0"))
+}
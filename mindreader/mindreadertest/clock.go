@@ -0,0 +1,41 @@
+package mindreadertest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a deterministic clock only moving when told to, pass `clock.Now` where a
+// `func() time.Time` is expected, e.g. `mindreader.WithArchiverClock(clock.Now)` so the
+// age of blocks against the merge threshold block age does not depend on the wall clock.
+type Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by `d`
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to `now`, possibly backward
+func (c *Clock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = now
+}
@@ -0,0 +1,201 @@
+package mindreadertest
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// LinePrefix is the prefix of the lines decoded by `DecodeLine` and produced by `FormatLine`
+const LinePrefix = "DMLOG "
+
+// LineDecoder turns a line received by a `ConsoleReader` into a block, a nil block skips the line
+type LineDecoder func(line string) (*bstream.Block, error)
+
+type jsonBlock struct {
+	ID         string    `json:"id"`
+	Num        *uint64   `json:"num,omitempty"`
+	PreviousID string    `json:"previous_id,omitempty"`
+	LIBNum     uint64    `json:"lib_num,omitempty"`
+	Time       time.Time `json:"time,omitempty"`
+}
+
+// DecodeLine is the default `LineDecoder`, it decodes lines like `DMLOG {"id":"0000000aabc"}` into
+// blocks whose payload is their ID. All fields (`id`, `num`, `previous_id`, `lib_num` and `time`)
+// but `id` are optional, the block number defaults to the first 8 characters of the ID read as
+// hexadecimal, like the IDs of `BlockGenerator`. Lines without the `DMLOG ` prefix are skipped.
+func DecodeLine(line string) (*bstream.Block, error) {
+	if !strings.HasPrefix(line, LinePrefix) {
+		return nil, nil
+	}
+
+	data := &jsonBlock{}
+	if err := json.Unmarshal([]byte(line[len(LinePrefix):]), data); err != nil {
+		return nil, fmt.Errorf("decoding line %q: %w", line, err)
+	}
+
+	var num uint64
+	if data.Num != nil {
+		num = *data.Num
+	} else {
+		var err error
+		if num, err = blockNumFromID(data.ID); err != nil {
+			return nil, fmt.Errorf("decoding line %q: %w", line, err)
+		}
+	}
+
+	blk := &bstream.Block{
+		Id:         data.ID,
+		Number:     num,
+		PreviousId: data.PreviousID,
+		Timestamp:  data.Time.UTC(),
+		LibNum:     data.LIBNum,
+	}
+	return bstream.MemoryBlockPayloadSetter(blk, []byte(blk.Id))
+}
+
+// FormatLine returns the line decoded by `DecodeLine` into `block`, payload aside
+func FormatLine(block *bstream.Block) string {
+	num := block.Number
+	content, _ := json.Marshal(&jsonBlock{
+		ID:         block.Id,
+		Num:        &num,
+		PreviousID: block.PreviousId,
+		LIBNum:     block.LibNum,
+		Time:       block.Timestamp,
+	})
+	return LinePrefix + string(content)
+}
+
+// FormatLines returns the lines of `blocks`, see `FormatLine`
+func FormatLines(blocks []*bstream.Block) (out []string) {
+	for _, blk := range blocks {
+		out = append(out, FormatLine(blk))
+	}
+	return
+}
+
+func blockNumFromID(id string) (uint64, error) {
+	if len(id) < 8 {
+		return 0, fmt.Errorf("block id %q too short to hold a block number", id)
+	}
+
+	bin, err := hex.DecodeString(id[:8])
+	if err != nil {
+		return 0, fmt.Errorf("block id %q does not start with an hexadecimal block number: %w", id, err)
+	}
+	return uint64(binary.BigEndian.Uint32(bin)), nil
+}
+
+// Step is one read of a scripted `ConsoleReader`: `Err` is returned when set, `Block` otherwise
+// or, when nil, `Line` decoded
+type Step struct {
+	Block *bstream.Block
+	Line  string
+	Err   error
+}
+
+// BlockSteps returns a step for each of `blocks`
+func BlockSteps(blocks ...*bstream.Block) (out []Step) {
+	for _, blk := range blocks {
+		out = append(out, Step{Block: blk})
+	}
+	return
+}
+
+// LineSteps returns a step for each of `lines`
+func LineSteps(lines ...string) (out []Step) {
+	for _, line := range lines {
+		out = append(out, Step{Line: line})
+	}
+	return
+}
+
+// ConsoleReader is a `mindreader.ConsolerReader` returning the steps of its script first, then
+// decoding the lines it receives until they are closed. Give it to a plugin with a factory like
+// `func(ctx mindreader.ConsoleReaderContext) (mindreader.ConsolerReader, error) { return mindreadertest.NewConsoleReader(ctx.Lines), nil }`.
+type ConsoleReader struct {
+	// Decode turns received lines and line steps into blocks, `DecodeLine` by default
+	Decode LineDecoder
+
+	lines <-chan string
+
+	lock     sync.Mutex
+	script   []Step
+	read     int
+	done     chan interface{}
+	doneOnce sync.Once
+}
+
+// NewConsoleReader decodes the blocks from the lines received by the plugin
+func NewConsoleReader(lines chan string) *ConsoleReader {
+	return NewScriptedConsoleReader(lines)
+}
+
+// NewScriptedConsoleReader returns the steps of `script` before reading `lines`. When `lines`
+// is nil, `ReadBlock` returns `io.EOF` once the script is exhausted.
+func NewScriptedConsoleReader(lines chan string, script ...Step) *ConsoleReader {
+	return &ConsoleReader{
+		Decode: DecodeLine,
+		lines:  lines,
+		script: script,
+		done:   make(chan interface{}),
+	}
+}
+
+func (r *ConsoleReader) ReadBlock() (*bstream.Block, error) {
+	if step, ok := r.nextStep(); ok {
+		if step.Err != nil {
+			return nil, step.Err
+		}
+		if step.Block != nil {
+			return step.Block, nil
+		}
+		if blk, err := r.Decode(step.Line); blk != nil || err != nil {
+			return blk, err
+		}
+		return r.ReadBlock()
+	}
+
+	if r.lines == nil {
+		return nil, io.EOF
+	}
+
+	for line := range r.lines {
+		blk, err := r.Decode(line)
+		if blk != nil || err != nil {
+			return blk, err
+		}
+	}
+	return nil, io.EOF
+}
+
+func (r *ConsoleReader) nextStep() (Step, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.read >= len(r.script) {
+		return Step{}, false
+	}
+	r.read++
+	return r.script[r.read-1], true
+}
+
+// Done is closed by `Finish`, like a console reader seeing the node's shutdown marker
+func (r *ConsoleReader) Done() <-chan interface{} {
+	return r.done
+}
+
+// Finish closes the `Done` channel, it can be called multiple times
+func (r *ConsoleReader) Finish() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+}
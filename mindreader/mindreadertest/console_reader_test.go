@@ -0,0 +1,85 @@
+package mindreadertest
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeLine(t *testing.T) {
+	generator := NewBlockGenerator("reader", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	generator.LIBLag = 2
+	expected := generator.Block(12, 10)
+
+	blk, err := DecodeLine(FormatLine(expected))
+	require.NoError(t, err)
+	assert.Equal(t, expected.Id, blk.Id)
+	assert.Equal(t, expected.Number, blk.Number)
+	assert.Equal(t, expected.PreviousId, blk.PreviousId)
+	assert.Equal(t, expected.LibNum, blk.LibNum)
+	assert.Equal(t, expected.Timestamp, blk.Timestamp)
+
+	blk, err = DecodeLine(`DMLOG {"id":"0000001aabc"}`)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(26), blk.Number, "block number from id")
+
+	blk, err = DecodeLine("some node log")
+	require.NoError(t, err)
+	assert.Nil(t, blk)
+
+	_, err = DecodeLine(`DMLOG {"id":"zz"}`)
+	assert.Error(t, err)
+}
+
+func TestConsoleReader_Script(t *testing.T) {
+	generator := NewBlockGenerator("reader", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	failure := errors.New("broken line")
+
+	lines := make(chan string, 2)
+	script := append(BlockSteps(generator.Blocks(1, 2)...), LineSteps("skipped", `DMLOG {"id":"00000003a"}`)...)
+	script = append(script, Step{Err: failure})
+	reader := NewScriptedConsoleReader(lines, script...)
+
+	var nums []uint64
+	for i := 0; i < 3; i++ {
+		blk, err := reader.ReadBlock()
+		require.NoError(t, err)
+		nums = append(nums, blk.Number)
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, nums)
+
+	_, err := reader.ReadBlock()
+	assert.Equal(t, failure, err)
+
+	lines <- `DMLOG {"id":"00000004a"}`
+	close(lines)
+	blk, err := reader.ReadBlock()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), blk.Number)
+
+	_, err = reader.ReadBlock()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestConsoleReader_NoLines(t *testing.T) {
+	reader := NewScriptedConsoleReader(nil, LineSteps(`DMLOG {"id":"00000001a"}`)...)
+
+	blk, err := reader.ReadBlock()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), blk.Number)
+
+	_, err = reader.ReadBlock()
+	assert.Equal(t, io.EOF, err)
+
+	reader.Finish()
+	reader.Finish()
+	select {
+	case <-reader.Done():
+	default:
+		t.Fatal("done not closed")
+	}
+}
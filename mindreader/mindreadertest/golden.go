@@ -0,0 +1,76 @@
+package mindreadertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// UpdateGoldenFiles makes `AssertGolden` rewrite golden files instead of comparing against them,
+// enabled with `GOLDEN_UPDATE=true go test ./...`
+var UpdateGoldenFiles = os.Getenv("GOLDEN_UPDATE") == "true"
+
+var oneBlockFileTimeRegexp = regexp.MustCompile(`^(\d+)-\d{8}T\d{6}(\.\d+)?-`)
+
+// NormalizeFileName replaces the block time part of a one block file name with a fixed
+// value so that outputs do not depend on the time blocks were generated at.
+func NormalizeFileName(fileName string) string {
+	return oneBlockFileTimeRegexp.ReplaceAllString(fileName, "$1-<time>-")
+}
+
+// Normalized returns a copy of the result with all file names normalized
+func (r ArchiverResult) Normalized() ArchiverResult {
+	out := ArchiverResult{
+		OneBlockFiles:                normalizeFileNames(r.OneBlockFiles),
+		MergeableOneBlockFiles:       normalizeFileNames(r.MergeableOneBlockFiles),
+		DeletedOneBlockFiles:         normalizeFileNames(r.DeletedOneBlockFiles),
+		SentMergeableAsOneBlockFiles: r.SentMergeableAsOneBlockFiles,
+	}
+	// deletion happens in batches whose order is not deterministic
+	sort.Strings(out.DeletedOneBlockFiles)
+
+	for _, merged := range r.MergedBundles {
+		out.MergedBundles = append(out.MergedBundles, MergedBundle{
+			InclusiveLowerBlock: merged.InclusiveLowerBlock,
+			OneBlockFiles:       normalizeFileNames(merged.OneBlockFiles),
+		})
+	}
+	return out
+}
+
+// AssertGolden compares the normalized result against the JSON golden file at `goldenFile`
+func AssertGolden(t testing.TB, goldenFile string, result ArchiverResult) {
+	t.Helper()
+
+	buffer := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	require.NoError(t, encoder.Encode(result.Normalized()))
+	actual := buffer.Bytes()
+
+	if UpdateGoldenFiles {
+		require.NoError(t, os.MkdirAll(filepath.Dir(goldenFile), os.ModePerm))
+		require.NoError(t, ioutil.WriteFile(goldenFile, actual, 0644))
+		return
+	}
+
+	expected, err := ioutil.ReadFile(goldenFile)
+	require.NoError(t, err, "reading golden file, use GOLDEN_UPDATE=true to create it")
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+func normalizeFileNames(in []string) (out []string) {
+	for _, fileName := range in {
+		out = append(out, NormalizeFileName(fileName))
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package mindreadertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/bundle"
+)
+
+// BlockStorer is implemented by `mindreader.Archiver` and anything accepting blocks the same way
+type BlockStorer interface {
+	StoreBlock(ctx context.Context, block *bstream.Block) error
+}
+
+// MergedBundle is a merged blocks file produced through `MergeAndStore`
+type MergedBundle struct {
+	InclusiveLowerBlock uint64   `json:"inclusive_lower_block"`
+	OneBlockFiles       []string `json:"one_block_files"`
+}
+
+// ArchiverResult lists, in order, everything an archiver did on its IO
+type ArchiverResult struct {
+	OneBlockFiles                []string       `json:"one_block_files"`
+	MergeableOneBlockFiles       []string       `json:"mergeable_one_block_files"`
+	MergedBundles                []MergedBundle `json:"merged_bundles"`
+	DeletedOneBlockFiles         []string       `json:"deleted_one_block_files"`
+	SentMergeableAsOneBlockFiles int            `json:"sent_mergeable_as_one_block_files"`
+}
+
+// RecordingArchiverIO is an in-memory `mindreader.ArchiverIO` recording every file
+// written by the archiver. Blocks written through it can be downloaded back.
+type RecordingArchiverIO struct {
+	// PartialMergeableFiles is returned by `WalkMergeableOneBlockFiles`, representing
+	// files left on disk by a previous run
+	PartialMergeableFiles []*bundle.OneBlockFile
+
+	// FetchMergedOneBlockFilesFunc is called by `FetchMergedOneBlockFiles` when set,
+	// no merged files exist otherwise
+	FetchMergedOneBlockFilesFunc func(lowBlockNum uint64) ([]*bundle.OneBlockFile, error)
+
+	// StoreOneBlockFileFunc is called by `StoreOneBlockFile` before recording the file when set,
+	// the file is not recorded if it returns an error
+	StoreOneBlockFileFunc func(ctx context.Context, fileName string, block *bstream.Block) error
+
+	lock      sync.Mutex
+	result    ArchiverResult
+	blocks    map[string]*bstream.Block
+	blockNums []uint64 // of the one block and mergeable one block files, in order
+}
+
+func NewRecordingArchiverIO() *RecordingArchiverIO {
+	return &RecordingArchiverIO{
+		blocks: make(map[string]*bstream.Block),
+	}
+}
+
+// Result returns a copy of what was recorded so far
+func (io *RecordingArchiverIO) Result() ArchiverResult {
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	out := io.result
+	out.OneBlockFiles = append([]string(nil), io.result.OneBlockFiles...)
+	out.MergeableOneBlockFiles = append([]string(nil), io.result.MergeableOneBlockFiles...)
+	out.MergedBundles = append([]MergedBundle(nil), io.result.MergedBundles...)
+	out.DeletedOneBlockFiles = append([]string(nil), io.result.DeletedOneBlockFiles...)
+	return out
+}
+
+// ArchivedBlockNums returns the numbers of the blocks written as one block files or mergeable one
+// block files, in order. Blocks re-sent as one block files by `SendMergeableAsOneBlockFiles` are
+// not repeated.
+func (io *RecordingArchiverIO) ArchivedBlockNums() []uint64 {
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	return append([]uint64(nil), io.blockNums...)
+}
+
+func (io *RecordingArchiverIO) StoreOneBlockFile(ctx context.Context, fileName string, block *bstream.Block) error {
+	if io.StoreOneBlockFileFunc != nil {
+		if err := io.StoreOneBlockFileFunc(ctx, fileName, block); err != nil {
+			return err
+		}
+	}
+
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	io.blocks[fileName] = block
+	io.blockNums = append(io.blockNums, block.Number)
+	io.result.OneBlockFiles = append(io.result.OneBlockFiles, fileName)
+	return nil
+}
+
+func (io *RecordingArchiverIO) StoreMergeableOneBlockFile(ctx context.Context, fileName string, block *bstream.Block) error {
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	io.blocks[fileName] = block
+	io.blockNums = append(io.blockNums, block.Number)
+	io.result.MergeableOneBlockFiles = append(io.result.MergeableOneBlockFiles, fileName)
+	return nil
+}
+
+func (io *RecordingArchiverIO) SendMergeableAsOneBlockFiles(ctx context.Context) error {
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	io.result.SentMergeableAsOneBlockFiles++
+	return nil
+}
+
+func (io *RecordingArchiverIO) WalkMergeableOneBlockFiles(ctx context.Context) ([]*bundle.OneBlockFile, error) {
+	return io.PartialMergeableFiles, nil
+}
+
+func (io *RecordingArchiverIO) MergeAndStore(inclusiveLowerBlock uint64, oneBlockFiles []*bundle.OneBlockFile) error {
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	io.result.MergedBundles = append(io.result.MergedBundles, MergedBundle{
+		InclusiveLowerBlock: inclusiveLowerBlock,
+		OneBlockFiles:       canonicalNames(oneBlockFiles),
+	})
+	return nil
+}
+
+func (io *RecordingArchiverIO) FetchMergedOneBlockFiles(lowBlockNum uint64) ([]*bundle.OneBlockFile, error) {
+	if io.FetchMergedOneBlockFilesFunc == nil {
+		return nil, nil
+	}
+	return io.FetchMergedOneBlockFilesFunc(lowBlockNum)
+}
+
+func (io *RecordingArchiverIO) WalkOneBlockFiles(ctx context.Context, callback func(*bundle.OneBlockFile) error) error {
+	return nil
+}
+
+// DownloadOneBlockFile returns the encoded block previously stored under any of the file's names,
+// unknown files are synthesized from their name with the canonical name as payload.
+func (io *RecordingArchiverIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bundle.OneBlockFile) ([]byte, error) {
+	io.lock.Lock()
+	var blk *bstream.Block
+	for fileName := range oneBlockFile.Filenames {
+		if stored, found := io.blocks[fileName]; found {
+			blk = stored
+			break
+		}
+	}
+	io.lock.Unlock()
+
+	if blk == nil {
+		blk, _ = bstream.MemoryBlockPayloadSetter(BlockFromOneBlockFile(oneBlockFile), []byte(oneBlockFile.CanonicalName))
+	}
+
+	pbBlock, err := blk.ToProto()
+	if err != nil {
+		return nil, fmt.Errorf("block to proto: %w", err)
+	}
+	return proto.Marshal(pbBlock)
+}
+
+func (io *RecordingArchiverIO) Delete(oneBlockFiles []*bundle.OneBlockFile) {
+	io.lock.Lock()
+	defer io.lock.Unlock()
+
+	io.result.DeletedOneBlockFiles = append(io.result.DeletedOneBlockFiles, canonicalNames(oneBlockFiles)...)
+}
+
+// StoreBlocks sends each block to the storer, stopping at the first error
+func StoreBlocks(ctx context.Context, storer BlockStorer, blocks []*bstream.Block) error {
+	for _, blk := range blocks {
+		if err := storer.StoreBlock(ctx, blk); err != nil {
+			return fmt.Errorf("storing block %s: %w", blk, err)
+		}
+	}
+	return nil
+}
+
+func canonicalNames(oneBlockFiles []*bundle.OneBlockFile) (out []string) {
+	for _, oneBlockFile := range oneBlockFiles {
+		out = append(out, oneBlockFile.CanonicalName)
+	}
+	return
+}
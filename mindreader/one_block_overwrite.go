@@ -0,0 +1,104 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// OneBlockOverwritePolicy tells what happens when a one block file being uploaded already exists
+// in the one block files store, see `WithOneBlockFileOverwrite`
+type OneBlockOverwritePolicy int
+
+const (
+	// OneBlockOverwriteStoreDefault leaves it to the store: S3 and Google Storage skip the upload,
+	// keeping the existing file, the local filesystem store overwrites it
+	OneBlockOverwriteStoreDefault OneBlockOverwritePolicy = iota
+
+	// OneBlockOverwriteAlways overwrites the existing file, on every store
+	OneBlockOverwriteAlways
+
+	// OneBlockOverwriteFail keeps the existing file and shuts the plugin down with a
+	// `DestinationFileExistsError`, the file stays in the working directory. An existing file
+	// usually means another mindreader uploads with the same one block suffix.
+	OneBlockOverwriteFail
+)
+
+func (p OneBlockOverwritePolicy) String() string {
+	switch p {
+	case OneBlockOverwriteStoreDefault:
+		return "store-default"
+	case OneBlockOverwriteAlways:
+		return "always"
+	case OneBlockOverwriteFail:
+		return "fail"
+	}
+	return fmt.Sprintf("OneBlockOverwritePolicy(%d)", int(p))
+}
+
+// ParseOneBlockOverwritePolicy parses the name of a policy, as returned by its `String` method
+func ParseOneBlockOverwritePolicy(name string) (OneBlockOverwritePolicy, error) {
+	for _, policy := range []OneBlockOverwritePolicy{OneBlockOverwriteStoreDefault, OneBlockOverwriteAlways, OneBlockOverwriteFail} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid one block overwrite policy %q, must be one of store-default, always or fail", name)
+}
+
+// WithOneBlockFileOverwrite sets what happens when a one block file being uploaded already exists
+// in the one block files store, `OneBlockOverwriteStoreDefault` by default. It applies to the
+// mergeable files sent as one block files too. Merged files are not affected.
+func WithOneBlockFileOverwrite(policy OneBlockOverwritePolicy) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.oneBlockOverwrite = policy
+	}
+}
+
+// FileUploaderFailOnExistingFile makes the upload of a file already in the destination store fail
+// with a `DestinationFileExistsError`, without retrying it, keeping the local file
+func FileUploaderFailOnExistingFile() FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.failOnExisting = true
+	}
+}
+
+func (fu *FileUploader) checkDestinationFileAbsent(ctx context.Context, filename string) error {
+	name := fu.destinationName(filename)
+	exists, err := fu.destinationStore.FileExists(ctx, name)
+	if err != nil {
+		return fmt.Errorf("checking if file exists in destination store: %w", err)
+	}
+	if exists {
+		return &DestinationFileExistsError{Filename: name, Store: storeLabel(fu.destinationStore)}
+	}
+	return nil
+}
+
+// shutdownOnExistingOneBlockFile shuts the plugin down when a one block file was not uploaded
+// because it already exists, see `OneBlockOverwriteFail`
+func (p *MindReaderPlugin) shutdownOnExistingOneBlockFile(filename string, err error) {
+	var existsErr *DestinationFileExistsError
+	if !errors.As(err, &existsErr) {
+		return
+	}
+
+	p.zlogger.Error("one block file already exists in the one block store, is another mindreader using the same one block suffix?", zap.String("local_file", filename), zap.Error(err))
+	go p.Shutdown(existsErr)
+}
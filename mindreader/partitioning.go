@@ -0,0 +1,67 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithOneBlockFilePartitioning names the one block files under the prefix of the range of
+// `width` blocks they belong to, in the archive store and in the working directory, e.g.
+// `0001234500/0001234567-...` with a width of 100, so the archive store is not one huge flat
+// listing. One block files left in the working directory with flat names, from before the
+// partitioning was enabled, are uploaded under their prefix. Disabled, the default, when 0.
+func WithOneBlockFilePartitioning(width uint64) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.oneBlockPartitionWidth = width
+	}
+}
+
+// FileUploaderPartitioning uploads the files with flat names under the prefix of their block
+// range of `width` blocks, see `WithOneBlockFilePartitioning`
+func FileUploaderPartitioning(width uint64) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.partitionWidth = width
+	}
+}
+
+// partitionedFileName returns `filename` under the prefix of the range of `width` blocks it
+// belongs to, `filename` as is when `width` is 0, when it is already partitioned or when it does
+// not start with a block number
+func partitionedFileName(filename string, width uint64) string {
+	if width == 0 || strings.Contains(filename, "/") {
+		return filename
+	}
+
+	num, err := blockFileNum(filename)
+	if err != nil {
+		return filename
+	}
+	return fmt.Sprintf("%0*d/%s", blockFileNumDigits, lowBoundary(num, width), filename)
+}
+
+// isPartitionPrefix reports if `dir` is the prefix of a block range, see `partitionedFileName`
+func isPartitionPrefix(dir string) bool {
+	if len(dir) != blockFileNumDigits {
+		return false
+	}
+	for _, c := range dir {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionedFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		width    uint64
+		expected string
+	}{
+		{"disabled", "0001234567-20210728T105016.0-00a-99a-suffix", 0, "0001234567-20210728T105016.0-00a-99a-suffix"},
+		{"first block of range", "0001234500-20210728T105016.0-00a-99a-suffix", 100, "0001234500/0001234500-20210728T105016.0-00a-99a-suffix"},
+		{"last block of range", "0001234599-20210728T105016.0-00a-99a-suffix", 100, "0001234500/0001234599-20210728T105016.0-00a-99a-suffix"},
+		{"first block of next range", "0001234600-20210728T105016.0-00a-99a-suffix", 100, "0001234600/0001234600-20210728T105016.0-00a-99a-suffix"},
+		{"last block of wide range", "0001239999-20210728T105016.0-00a-99a-suffix", 10000, "0001230000/0001239999-20210728T105016.0-00a-99a-suffix"},
+		{"block zero", "0000000000-20210728T105016.0-00a-99a-suffix", 1000, "0000000000/0000000000-20210728T105016.0-00a-99a-suffix"},
+		{"already partitioned", "0001234500/0001234567-20210728T105016.0-00a-99a-suffix", 100, "0001234500/0001234567-20210728T105016.0-00a-99a-suffix"},
+		{"not a block file", "state.json", 100, "state.json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, partitionedFileName(test.filename, test.width))
+		})
+	}
+}
+
+func TestFileUploader_Partitioning(t *testing.T) {
+	localStore := newResumePointTestStore(t,
+		"0000000199-20210728T105016.0-00a-99a-suffix", // legacy flat file, from before partitioning
+		"0000000200/0000000200-20210728T105016.0-00a-99a-suffix",
+	)
+	destinationStore := newResumePointTestStore(t)
+
+	uploader := NewFileUploader(localStore, destinationStore, testLogger, FileUploaderPartitioning(100))
+	uploaded, err := uploader.uploadAllFiles(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, uploaded, 2)
+
+	var destinationFiles []string
+	require.NoError(t, destinationStore.Walk(context.Background(), "", func(filename string) error {
+		destinationFiles = append(destinationFiles, filename)
+		return nil
+	}))
+	sort.Strings(destinationFiles)
+	assert.Equal(t, []string{
+		"0000000100/0000000199-20210728T105016.0-00a-99a-suffix",
+		"0000000200/0000000200-20210728T105016.0-00a-99a-suffix",
+	}, destinationFiles)
+
+	require.NoError(t, uploader.waitForVisibility(context.Background(), uploaded, time.Second))
+}
+
+func TestArchiverDStoreIO_PartitionedOneBlockFiles(t *testing.T) {
+	uploadableOneBlocks := newResumePointTestStore(t)
+	archiverIO := &ArchiverDStoreIO{
+		blockWriterFactory: bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+			return bstream.NewDBinBlockWriter(writer, "TST", 1)
+		}),
+		uploadableOneBlockStore: uploadableOneBlocks,
+		oneBlockPartitionWidth:  1000,
+	}
+
+	block := randomPayloadBlock(t, 1999, 10)
+	require.NoError(t, archiverIO.StoreOneBlockFile(context.Background(), "0000001999-20210728T105016.0-00a-99a-suffix", block))
+
+	exists, err := uploadableOneBlocks.FileExists(context.Background(), "0000001000/0000001999-20210728T105016.0-00a-99a-suffix")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestReconcileWorkingDirectory_Partitioned(t *testing.T) {
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+
+	dir := t.TempDir()
+	generator := mindreadertest.NewBlockGenerator("reconcile", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	writeBlockFile(t, filepath.Join(dir, "uploadable-oneblock"), "0000000100/0000000110-valid", generator.Block(110, 100))
+	writeBlockFile(t, filepath.Join(dir, "uploadable-oneblock"), "0000000111-legacy", generator.Block(111, 100))
+	writeBlockFile(t, filepath.Join(dir, "uploadable-oneblock"), "other/0000000112-valid", generator.Block(112, 100))
+	writeBlockFile(t, filepath.Join(dir, "mergeable"), "0000000100/0000000113-valid", generator.Block(113, 100))
+
+	report, err := reconcileWorkingDirectory(context.Background(), dir, dbinReaderFactory, blockFileFormats[DefaultOneBlockFileCompression], nil, testLogger)
+	require.NoError(t, err)
+
+	classes := map[string]WorkingFileClass{}
+	for _, file := range report.Files {
+		classes[file.Path] = file.Class
+	}
+	assert.Equal(t, map[string]WorkingFileClass{
+		"uploadable-oneblock/0000000100/0000000110-valid.dbin.zst": WorkingFilePendingUpload,
+		"uploadable-oneblock/0000000111-legacy.dbin.zst":           WorkingFilePendingUpload,
+		"uploadable-oneblock/other/0000000112-valid.dbin.zst":      WorkingFileUnknown,
+		"mergeable/0000000100/0000000113-valid.dbin.zst":           WorkingFileUnknown,
+	}, classes)
+}
+
+func TestHighestBlockFile_Partitioned(t *testing.T) {
+	store := newResumePointTestStore(t,
+		"0000000099-20210728T105016.0-00a-99a-suffix",
+		"0000000100-20210728T105016.0-00a-99a-suffix",
+		"0000000100/0000000100-20210728T105016.0-00a-99a-suffix",
+		"0000000100/0000000199-20210728T105016.0-00a-99a-suffix",
+		"0000000100/0000000150-20210728T105016.0-00a-99a-suffix",
+	)
+
+	highest, err := highestBlockFile(context.Background(), store)
+	require.NoError(t, err)
+	assert.Equal(t, "0000000100/0000000199-20210728T105016.0-00a-99a-suffix", highest)
+
+	num, err := blockFileNum(highest)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(199), num)
+}
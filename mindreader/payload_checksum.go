@@ -0,0 +1,154 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+)
+
+// PayloadChecksumAlgorithm is the hash of the block payloads verified with `WithPayloadChecksum`
+type PayloadChecksumAlgorithm int
+
+const (
+	// PayloadChecksumXXHash is the 64 bits xxHash of the payload, fast but not cryptographic
+	PayloadChecksumXXHash PayloadChecksumAlgorithm = iota
+
+	// PayloadChecksumSHA256 is the SHA-256 of the payload
+	PayloadChecksumSHA256
+)
+
+func (a PayloadChecksumAlgorithm) String() string {
+	switch a {
+	case PayloadChecksumXXHash:
+		return "xxhash"
+	case PayloadChecksumSHA256:
+		return "sha256"
+	}
+	return fmt.Sprintf("PayloadChecksumAlgorithm(%d)", int(a))
+}
+
+// ParsePayloadChecksumAlgorithm parses the name of an algorithm, as returned by its `String` method
+func ParsePayloadChecksumAlgorithm(name string) (PayloadChecksumAlgorithm, error) {
+	for _, algorithm := range []PayloadChecksumAlgorithm{PayloadChecksumXXHash, PayloadChecksumSHA256} {
+		if algorithm.String() == name {
+			return algorithm, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid payload checksum algorithm %q, must be one of xxhash or sha256", name)
+}
+
+func (a PayloadChecksumAlgorithm) sum(data []byte) []byte {
+	if a == PayloadChecksumSHA256 {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+
+	sum := make([]byte, 8)
+	binary.BigEndian.PutUint64(sum, xxhash.Sum64(data))
+	return sum
+}
+
+// WithPayloadChecksum verifies that the payload of every block is stored as it was read: its
+// checksum is computed right after the block transformers and computed again by the archiver
+// before storing the block, a different one fails the store with an error wrapping
+// `ErrPayloadChecksumMismatch`, handled like any archiver store error. Verifications are counted
+// in the `payload_checksums` metric. It is off by default, hashing every payload twice has a CPU
+// cost on high throughput chains.
+func WithPayloadChecksum(algorithm PayloadChecksumAlgorithm) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.payloadChecksums = newPayloadChecksums(algorithm)
+	}
+}
+
+// payloadChecksums keeps the checksum of the payload of the blocks read and not yet stored
+type payloadChecksums struct {
+	algorithm PayloadChecksumAlgorithm
+
+	lock sync.Mutex
+	sums map[*bstream.Block][]byte
+}
+
+func newPayloadChecksums(algorithm PayloadChecksumAlgorithm) *payloadChecksums {
+	return &payloadChecksums{
+		algorithm: algorithm,
+		sums:      make(map[*bstream.Block][]byte),
+	}
+}
+
+func (c *payloadChecksums) checksum(block *bstream.Block) ([]byte, error) {
+	var data []byte
+	if block.Payload != nil {
+		var err error
+		if data, err = block.Payload.Get(); err != nil {
+			return nil, fmt.Errorf("getting block %s payload: %w", block, err)
+		}
+	}
+	return c.algorithm.sum(data), nil
+}
+
+// attach computes the checksum of the payload of `block`, verified by `verify`
+func (c *payloadChecksums) attach(block *bstream.Block) error {
+	sum, err := c.checksum(block)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sums[block] = sum
+	return nil
+}
+
+// forget drops the checksum of a block that is not stored
+func (c *payloadChecksums) forget(block *bstream.Block) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.sums, block)
+}
+
+// verify compares the checksum of the payload of `block` to the attached one, blocks without
+// one are not verified
+func (c *payloadChecksums) verify(block *bstream.Block) error {
+	c.lock.Lock()
+	expected, found := c.sums[block]
+	delete(c.sums, block)
+	c.lock.Unlock()
+
+	if !found {
+		return nil
+	}
+
+	actual, err := c.checksum(block)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(expected, actual) {
+		metrics.PayloadChecksums.Inc("mismatch")
+		return fmt.Errorf("block %s: %w, %s checksum %x when read, %x when stored", block, ErrPayloadChecksumMismatch, c.algorithm, expected, actual)
+	}
+	metrics.PayloadChecksums.Inc("verified")
+	return nil
+}
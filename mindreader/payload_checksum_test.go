@@ -0,0 +1,112 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corruptingTransformer flips a bit of the payload of the previous block it transformed, like a
+// transformer wrongly reusing its buffers would, once the previous block was already sent
+type corruptingTransformer struct {
+	previous *bstream.Block
+}
+
+func (c *corruptingTransformer) transform(block *bstream.Block) (*bstream.Block, error) {
+	if c.previous != nil {
+		data, err := c.previous.Payload.Get()
+		if err != nil {
+			return nil, err
+		}
+		data[0] ^= 0x01
+	}
+	c.previous = block
+	return block, nil
+}
+
+func newPayloadChecksumTestPlugin(t *testing.T, options ...MindReaderPluginOption) (*MindReaderPlugin, chan *bstream.Block) {
+	t.Helper()
+
+	lines := make(chan string, 10)
+	p := newReadTestPlugin(lines, mindreadertest.NewConsoleReader(lines))
+	WithBlockTransformers((&corruptingTransformer{}).transform)(p)
+	for _, opt := range options {
+		opt(p)
+	}
+
+	p.archiver = newArchiverWithIO(t, mindreadertest.NewRecordingArchiverIO(), 0)
+	p.archiver.payloadChecksums = p.payloadChecksums
+
+	generator := mindreadertest.NewBlockGenerator("checksum", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(1, 2)) {
+		p.LogLine(line)
+	}
+
+	blocks := make(chan *bstream.Block, 2)
+	require.NoError(t, p.readOneMessage(blocks))
+	require.NoError(t, p.readOneMessage(blocks))
+	return p, blocks
+}
+
+func TestMindReaderPlugin_PayloadChecksum(t *testing.T) {
+	for _, algorithm := range []PayloadChecksumAlgorithm{PayloadChecksumXXHash, PayloadChecksumSHA256} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			verified := testutil.ToFloat64(metrics.PayloadChecksums.Native().WithLabelValues("verified"))
+			mismatches := testutil.ToFloat64(metrics.PayloadChecksums.Native().WithLabelValues("mismatch"))
+
+			p, blocks := newPayloadChecksumTestPlugin(t, WithPayloadChecksum(algorithm))
+
+			corrupted := <-blocks
+			err := p.archiver.StoreBlock(context.Background(), corrupted)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrPayloadChecksumMismatch), "got %s", err)
+
+			intact := <-blocks
+			require.NoError(t, p.archiver.StoreBlock(context.Background(), intact))
+
+			assert.Equal(t, verified+1, testutil.ToFloat64(metrics.PayloadChecksums.Native().WithLabelValues("verified")))
+			assert.Equal(t, mismatches+1, testutil.ToFloat64(metrics.PayloadChecksums.Native().WithLabelValues("mismatch")))
+			assert.Empty(t, p.payloadChecksums.sums, "checksums are dropped once verified")
+		})
+	}
+}
+
+func TestMindReaderPlugin_PayloadChecksumOffByDefault(t *testing.T) {
+	p, blocks := newPayloadChecksumTestPlugin(t)
+	assert.Nil(t, p.payloadChecksums)
+
+	require.NoError(t, p.archiver.StoreBlock(context.Background(), <-blocks), "corruption goes unnoticed")
+}
+
+func TestParsePayloadChecksumAlgorithm(t *testing.T) {
+	for _, algorithm := range []PayloadChecksumAlgorithm{PayloadChecksumXXHash, PayloadChecksumSHA256} {
+		parsed, err := ParsePayloadChecksumAlgorithm(algorithm.String())
+		require.NoError(t, err)
+		assert.Equal(t, algorithm, parsed)
+	}
+
+	_, err := ParsePayloadChecksumAlgorithm("md5")
+	assert.EqualError(t, err, `invalid payload checksum algorithm "md5", must be one of xxhash or sha256`)
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+type OversizedBlockPolicy int
+
+const (
+	// OversizedBlockReject fails reading the block, handled like a console read error (maintenance
+	// when a maintenance requester is set, shutdown otherwise)
+	OversizedBlockReject OversizedBlockPolicy = iota
+
+	// OversizedBlockWarn archives the block anyway, logging a warning
+	OversizedBlockWarn
+)
+
+// WithMaxBlockPayloadBytes checks the payload size of every block kept by the block filter,
+// blocks over `n` bytes are handled according to the `WithOversizedBlockPolicy` option
+// (rejected by default) and counted in the `oversized_blocks` metric.
+func WithMaxBlockPayloadBytes(n int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.maxBlockPayloadBytes = n
+	}
+}
+
+// WithOversizedBlockPolicy sets how blocks over `WithMaxBlockPayloadBytes` are handled
+func WithOversizedBlockPolicy(policy OversizedBlockPolicy) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.oversizedBlockPolicy = policy
+	}
+}
+
+// blockPayloadSize returns the size of the block payload, 0 if it has none. It is cheap for
+// in-memory payloads, other payloads may need to be loaded.
+func blockPayloadSize(block *bstream.Block) (int, error) {
+	if block.Payload == nil {
+		return 0, nil
+	}
+
+	data, err := block.Payload.Get()
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (p *MindReaderPlugin) checkPayloadSize(block *bstream.Block) error {
+	if p.maxBlockPayloadBytes <= 0 {
+		return nil
+	}
+
+	size, err := blockPayloadSize(block)
+	if err != nil {
+		return fmt.Errorf("getting block %s payload: %w", block, err)
+	}
+
+	if size <= p.maxBlockPayloadBytes {
+		return nil
+	}
+	metrics.OversizedBlocks.Inc()
+
+	if p.oversizedBlockPolicy == OversizedBlockReject {
+		return fmt.Errorf("block %s payload of %d bytes exceeds the maximum of %d bytes", block, size, p.maxBlockPayloadBytes)
+	}
+
+	p.zlogger.Warn("block payload exceeds the maximum size, archiving it anyway",
+		zap.Uint64("block_num", block.Number),
+		zap.String("block_id", block.Id),
+		zap.Int("payload_size", size),
+		zap.Int("max_payload_size", p.maxBlockPayloadBytes),
+	)
+	return nil
+}
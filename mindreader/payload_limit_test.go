@@ -0,0 +1,76 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newPayloadTestBlock(t *testing.T, num uint64, size int) *bstream.Block {
+	t.Helper()
+	block, err := bstream.MemoryBlockPayloadSetter(&bstream.Block{Number: num, Id: "00000001a"}, make([]byte, size))
+	require.NoError(t, err)
+	return block
+}
+
+type fixedBlocksConsoleReader struct {
+	blocks []*bstream.Block
+}
+
+func (r *fixedBlocksConsoleReader) Done() <-chan interface{} { return nil }
+func (r *fixedBlocksConsoleReader) ReadBlock() (*bstream.Block, error) {
+	block := r.blocks[0]
+	r.blocks = r.blocks[1:]
+	return block, nil
+}
+
+func TestMindReaderPlugin_MaxBlockPayloadBytes_Reject(t *testing.T) {
+	blocks := make(chan *bstream.Block, 2)
+	p := newReadTestPlugin(nil, &fixedBlocksConsoleReader{blocks: []*bstream.Block{
+		newPayloadTestBlock(t, 1, 10),
+		newPayloadTestBlock(t, 2, 11),
+	}})
+	WithMaxBlockPayloadBytes(10)(p)
+
+	require.NoError(t, p.readOneMessage(blocks))
+
+	err := p.readOneMessage(blocks)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "payload of 11 bytes exceeds the maximum of 10 bytes")
+	assert.Len(t, blocks, 1, "oversized block not archived")
+}
+
+func TestMindReaderPlugin_MaxBlockPayloadBytes_Warn(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	p := &MindReaderPlugin{zlogger: zap.New(core)}
+	WithMaxBlockPayloadBytes(10)(p)
+	WithOversizedBlockPolicy(OversizedBlockWarn)(p)
+
+	require.NoError(t, p.checkPayloadSize(newPayloadTestBlock(t, 1, 10)))
+	assert.Equal(t, 0, logs.Len())
+
+	require.NoError(t, p.checkPayloadSize(newPayloadTestBlock(t, 2, 11)))
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.EqualValues(t, 2, fields["block_num"])
+	assert.Equal(t, "00000001a", fields["block_id"])
+	assert.EqualValues(t, 11, fields["payload_size"])
+}
@@ -0,0 +1,270 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+)
+
+// PushRateLimiter caps the rate at which blocks are pushed to the block stream server while
+// the mindreader is catching up, i.e. while blocks are older than the catch up block age.
+// It is a token bucket holding at most one second worth of blocks (and bytes): blocks going
+// over the limit are pushed later, once the bucket refilled, every block being pushed in
+// order. Blocks arriving while the push queue is full are not pushed at all, archiving never
+// waits on the limit. A limit of 0 means unlimited.
+type PushRateLimiter struct {
+	lock sync.Mutex
+
+	catchUpBlockAge time.Duration
+	blocksPerSecond float64
+	bytesPerSecond  float64
+
+	blockTokens float64
+	byteTokens  float64
+	lastRefill  time.Time
+
+	windowStart   time.Time
+	windowBlocks  uint64
+	windowBytes   uint64
+	limitedBlocks uint64
+	droppedBlocks uint64
+
+	now func() time.Time
+}
+
+func NewPushRateLimiter(blocksPerSecond, bytesPerSecond float64, catchUpBlockAge time.Duration) *PushRateLimiter {
+	l := &PushRateLimiter{
+		catchUpBlockAge: catchUpBlockAge,
+		now:             time.Now,
+	}
+	l.setPushRateLimit(blocksPerSecond, bytesPerSecond)
+	return l
+}
+
+// SetPushRateLimit changes the limits at runtime, the bucket starts full with the new limits
+func (l *PushRateLimiter) SetPushRateLimit(blocksPerSecond, bytesPerSecond float64) error {
+	if blocksPerSecond < 0 || bytesPerSecond < 0 {
+		return fmt.Errorf("push rate limits cannot be negative, got %f blocks/s and %f bytes/s", blocksPerSecond, bytesPerSecond)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.setPushRateLimit(blocksPerSecond, bytesPerSecond)
+	return nil
+}
+
+func (l *PushRateLimiter) setPushRateLimit(blocksPerSecond, bytesPerSecond float64) {
+	l.blocksPerSecond = blocksPerSecond
+	l.bytesPerSecond = bytesPerSecond
+	l.blockTokens = blocksPerSecond
+	l.byteTokens = bytesPerSecond
+	l.lastRefill = l.now()
+
+	metrics.PushRateLimit.SetFloat64(blocksPerSecond, "blocks")
+	metrics.PushRateLimit.SetFloat64(bytesPerSecond, "bytes")
+}
+
+// Limits returns the current blocks per second and bytes per second limits
+func (l *PushRateLimiter) Limits() (blocksPerSecond, bytesPerSecond float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.blocksPerSecond, l.bytesPerSecond
+}
+
+// LimitedBlocks returns the number of blocks whose push was delayed because of the limit
+func (l *PushRateLimiter) LimitedBlocks() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.limitedBlocks
+}
+
+// DroppedBlocks returns the number of blocks not pushed because the push queue was full
+func (l *PushRateLimiter) DroppedBlocks() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.droppedBlocks
+}
+
+func (l *PushRateLimiter) recordDropped() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.droppedBlocks++
+	metrics.PushRateDroppedBlocks.Inc()
+}
+
+// Reserve consumes the tokens of the block, returning how long to wait before pushing it. The
+// tokens of the blocks reserved but not pushed yet are owed by the bucket, delaying the next ones.
+func (l *PushRateLimiter) Reserve(block *bstream.Block) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	size := 0
+	if l.bytesPerSecond > 0 {
+		if payloadSize, err := blockPayloadSize(block); err == nil {
+			size = payloadSize
+		}
+	}
+
+	var delay time.Duration
+	if l.isCatchingUp(block, now) {
+		l.refill(now)
+		if l.blocksPerSecond > 0 && l.blockTokens < 1 {
+			delay = maxDuration(delay, secondsDuration((1-l.blockTokens)/l.blocksPerSecond))
+		}
+		// A block bigger than the bucket goes through once the bucket is full, leaving it in debt
+		if l.bytesPerSecond > 0 {
+			if missing := minFloat64(float64(size), l.bytesPerSecond) - l.byteTokens; missing > 0 {
+				delay = maxDuration(delay, secondsDuration(missing/l.bytesPerSecond))
+			}
+		}
+		if delay > 0 {
+			l.limitedBlocks++
+			metrics.PushRateLimitedBlocks.Inc()
+		}
+
+		l.blockTokens--
+		l.byteTokens -= float64(size)
+	}
+
+	l.recordPushed(now.Add(delay), size)
+	return delay
+}
+
+func (l *PushRateLimiter) isCatchingUp(block *bstream.Block, now time.Time) bool {
+	if l.blocksPerSecond <= 0 && l.bytesPerSecond <= 0 {
+		return false
+	}
+
+	return now.Sub(block.Time()) > l.catchUpBlockAge
+}
+
+func (l *PushRateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.lastRefill).Seconds()
+	l.lastRefill = now
+	if elapsed <= 0 {
+		return
+	}
+
+	l.blockTokens = minFloat64(l.blocksPerSecond, l.blockTokens+elapsed*l.blocksPerSecond)
+	l.byteTokens = minFloat64(l.bytesPerSecond, l.byteTokens+elapsed*l.bytesPerSecond)
+}
+
+func (l *PushRateLimiter) recordPushed(now time.Time, size int) {
+	if l.windowStart.IsZero() {
+		l.windowStart = now
+	}
+
+	l.windowBlocks++
+	l.windowBytes += uint64(size)
+
+	if elapsed := now.Sub(l.windowStart); elapsed >= time.Second {
+		metrics.PushedRate.SetFloat64(float64(l.windowBlocks)/elapsed.Seconds(), "blocks")
+		metrics.PushedRate.SetFloat64(float64(l.windowBytes)/elapsed.Seconds(), "bytes")
+		l.windowStart = now
+		l.windowBlocks = 0
+		l.windowBytes = 0
+	}
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat64(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// defaultPushQueueSize is the number of blocks waiting for the push rate limiter above which
+// blocks are dropped from the push path, still being archived
+const defaultPushQueueSize = 10000
+
+// pushQueue pushes the blocks to the live stream from its own goroutine, in order, waiting for
+// the push rate limiter, so archiving is never delayed by the limit. Blocks enqueued while it is
+// full are dropped. The blocks still queued when it is closed are pushed right away.
+type pushQueue struct {
+	limiter *PushRateLimiter
+	push    func(block *bstream.Block)
+	after   func(d time.Duration) <-chan time.Time
+
+	queue   chan *bstream.Block
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func newPushQueue(limiter *PushRateLimiter, push func(block *bstream.Block), size int) *pushQueue {
+	return &pushQueue{
+		limiter: limiter,
+		push:    push,
+		after:   time.After,
+		queue:   make(chan *bstream.Block, size),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (q *pushQueue) start() {
+	go func() {
+		defer close(q.done)
+		for block := range q.queue {
+			if delay := q.limiter.Reserve(block); delay > 0 {
+				select {
+				case <-q.after(delay):
+				case <-q.closing:
+				}
+			}
+			q.push(block)
+		}
+	}()
+}
+
+// enqueue queues `block` to be pushed, dropping it when the queue is full
+func (q *pushQueue) enqueue(block *bstream.Block) bool {
+	select {
+	case q.queue <- block:
+		return true
+	default:
+		q.limiter.recordDropped()
+		return false
+	}
+}
+
+// close pushes the blocks left without waiting for the limit, returning once they are pushed.
+// It must not be called concurrently with `enqueue`.
+func (q *pushQueue) close() {
+	close(q.closing)
+	close(q.queue)
+	<-q.done
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pushSimulation struct {
+	pushed  []uint64
+	delayed time.Duration // total wait of the pushes because of the limit
+}
+
+// simulatePush pushes `blocks` through a push queue the same way `consumeReadFlow` does, the
+// simulated clock advancing by the waits of the limiter
+func simulatePush(t *testing.T, limiter *PushRateLimiter, clock *time.Time, blocks []*bstream.Block) (out pushSimulation) {
+	t.Helper()
+
+	queue := newPushQueue(limiter, func(block *bstream.Block) { out.pushed = append(out.pushed, block.Number) }, len(blocks))
+	queue.after = func(d time.Duration) <-chan time.Time {
+		*clock = clock.Add(d)
+		out.delayed += d
+		ch := make(chan time.Time, 1)
+		ch <- *clock
+		return ch
+	}
+	queue.start()
+	for _, block := range blocks {
+		queue.enqueue(block)
+	}
+
+	// The blocks left are still pushed after their wait, unlike with `close`
+	close(queue.queue)
+	<-queue.done
+	return
+}
+
+func blocksNums(blocks []*bstream.Block) (out []uint64) {
+	for _, block := range blocks {
+		out = append(out, block.Number)
+	}
+	return
+}
+
+func newTestPushRateLimiter(blocksPerSecond, bytesPerSecond float64, start time.Time) (*PushRateLimiter, *time.Time) {
+	clock := start
+	limiter := NewPushRateLimiter(blocksPerSecond, bytesPerSecond, time.Minute)
+	limiter.now = func() time.Time { return clock }
+	limiter.lastRefill = clock
+	return limiter, &clock
+}
+
+func TestPushRateLimiter_CapsWhileCatchingUp(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter, clock := newTestPushRateLimiter(100, 0, now)
+
+	// blocks are a day old, all of them are pushed at 100 blocks/sec after an initial burst of 100
+	generator := mindreadertest.NewBlockGenerator("push", now.Add(-24*time.Hour))
+	blocks := generator.Blocks(1, 10000)
+	result := simulatePush(t, limiter, clock, blocks)
+
+	assert.Equal(t, blocksNums(blocks), result.pushed, "every block pushed, in order")
+	assert.InDelta(t, 99, result.delayed.Seconds(), 0.1)
+	assert.EqualValues(t, 9900, limiter.LimitedBlocks())
+}
+
+func TestPushRateLimiter_CapLiftsWhenCaughtUp(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter, clock := newTestPushRateLimiter(100, 0, now)
+
+	// blocks are produced live
+	generator := mindreadertest.NewBlockGenerator("push", now)
+	blocks := generator.Blocks(1, 500)
+	result := simulatePush(t, limiter, clock, blocks)
+
+	assert.Equal(t, blocksNums(blocks), result.pushed)
+	assert.Zero(t, result.delayed)
+	assert.EqualValues(t, 0, limiter.LimitedBlocks())
+}
+
+func TestPushRateLimiter_BytesLimit(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter, clock := newTestPushRateLimiter(0, 64*10, now)
+
+	// generated payloads are 32 bytes (the block ID), 20 blocks/sec fit in 640 bytes/sec
+	generator := mindreadertest.NewBlockGenerator("push", now.Add(-24*time.Hour))
+	blocks := generator.Blocks(1, 1000)
+	result := simulatePush(t, limiter, clock, blocks)
+
+	assert.Equal(t, blocksNums(blocks), result.pushed)
+	assert.InDelta(t, 49, result.delayed.Seconds(), 0.1, "initial burst of 20 then 20 blocks/sec")
+}
+
+func TestPushRateLimiter_SetPushRateLimit(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter, clock := newTestPushRateLimiter(100, 0, now)
+	generator := mindreadertest.NewBlockGenerator("push", now.Add(-24*time.Hour))
+
+	require.NoError(t, limiter.SetPushRateLimit(0, 0))
+	result := simulatePush(t, limiter, clock, generator.Blocks(1, 1000))
+	assert.Len(t, result.pushed, 1000)
+	assert.Zero(t, result.delayed, "0 is unlimited")
+
+	require.NoError(t, limiter.SetPushRateLimit(10, 0))
+	result = simulatePush(t, limiter, clock, generator.Blocks(1001, 100))
+	assert.Len(t, result.pushed, 100)
+	assert.InDelta(t, 9, result.delayed.Seconds(), 0.1)
+
+	assert.Error(t, limiter.SetPushRateLimit(-1, 0))
+	blocksPerSecond, bytesPerSecond := limiter.Limits()
+	assert.Equal(t, float64(10), blocksPerSecond)
+	assert.Equal(t, float64(0), bytesPerSecond)
+}
+
+func TestPushQueue_ArchivingNotSlowedByLimit(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter, clock := newTestPushRateLimiter(100, 0, now)
+
+	io := mindreadertest.NewRecordingArchiverIO()
+	archiver := NewArchiver(5, io, "suffix", alwaysMergeThreshold, testLogger, testTracer, WithArchiverMode(ModeOneBlockOnly))
+
+	// The pushes are stuck on the limit: the queue is never consumed
+	queue := newPushQueue(limiter, func(block *bstream.Block) {}, 100)
+
+	// blocks are a day old, mindreader catches up at 2000 blocks/sec during 5 seconds
+	generator := mindreadertest.NewBlockGenerator("push", now.Add(-24*time.Hour))
+	start := time.Now()
+	for _, block := range generator.Blocks(1, 10000) {
+		*clock = clock.Add(time.Second / 2000)
+		require.NoError(t, archiver.StoreBlock(context.Background(), block))
+		queue.enqueue(block)
+	}
+
+	assert.Len(t, io.Result().OneBlockFiles, 10000, "archive path must not be limited")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second), "archiving must not wait for the pushes")
+	assert.Len(t, queue.queue, 100)
+	assert.EqualValues(t, 9900, limiter.DroppedBlocks())
+}
+
+func TestPushQueue_CloseFlushesWithoutWaiting(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter, _ := newTestPushRateLimiter(1, 0, now)
+	generator := mindreadertest.NewBlockGenerator("push", now.Add(-24*time.Hour))
+	blocks := generator.Blocks(1, 50)
+
+	var pushed []uint64
+	queue := newPushQueue(limiter, func(block *bstream.Block) { pushed = append(pushed, block.Number) }, len(blocks))
+	queue.start()
+	for _, block := range blocks {
+		queue.enqueue(block)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		queue.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close should push the queued blocks without waiting for the limit")
+	}
+	assert.Equal(t, blocksNums(blocks), pushed)
+}
+
+func TestMindReaderPlugin_PushRateLimitKeepsEveryBlock(t *testing.T) {
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+	WithPushRateLimit(100, 0, time.Minute)(p)
+	pusher := &recordingPusher{}
+	p.liveStream = newLiveStream(pusher, 0, 0, time.Second, testLogger)
+
+	generator := mindreadertest.NewBlockGenerator("push", time.Now().Add(-24*time.Hour))
+	blocks := generator.Blocks(1, 300)
+
+	p.Launch()
+	for _, line := range mindreadertest.FormatLines(blocks) {
+		p.LogLine(line)
+	}
+
+	// Archiving is not held back by the limit, the pushes follow at 100 blocks/sec
+	require.Eventually(t, func() bool { return len(headBlocks()) == 300 }, 5*time.Second, time.Millisecond)
+	assert.Less(t, pusher.pushedCount(), 300)
+	require.Eventually(t, func() bool { return pusher.pushedCount() == 300 }, 5*time.Second, 5*time.Millisecond)
+
+	p.Stop()
+	assert.Equal(t, blocksNums(blocks), pusher.pushed)
+	assert.NotZero(t, p.pushRateLimiter.LimitedBlocks())
+}
+
+func TestMindReaderPlugin_PushRateLimitDoesNotSlowArchiving(t *testing.T) {
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+	WithPushRateLimit(1, 0, time.Minute)(p)
+	p.pushQueueSize = 10
+	pusher := &recordingPusher{}
+	p.liveStream = newLiveStream(pusher, 0, 0, time.Second, testLogger)
+
+	generator := mindreadertest.NewBlockGenerator("push", time.Now().Add(-24*time.Hour))
+	blocks := generator.Blocks(1, 300)
+
+	p.Launch()
+	for _, line := range mindreadertest.FormatLines(blocks) {
+		p.LogLine(line)
+	}
+
+	// At 1 block/sec, pushing them all would take minutes
+	require.Eventually(t, func() bool { return len(headBlocks()) == 300 }, 5*time.Second, time.Millisecond)
+	assert.Less(t, pusher.pushedCount(), 300)
+
+	p.Stop()
+	assert.EqualValues(t, 300, uint64(pusher.pushedCount())+p.pushRateLimiter.DroppedBlocks(), "blocks are either pushed or dropped")
+	assert.NotZero(t, p.pushRateLimiter.DroppedBlocks())
+}
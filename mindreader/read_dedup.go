@@ -0,0 +1,65 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// DefaultReadDedupWindow is the number of blocks read from the node remembered to drop the blocks
+// it replays, see `WithReadDedupWindow`
+const DefaultReadDedupWindow = 1000
+
+const readDedupFilename = "read-blocks.log"
+
+// WithReadDedupWindow changes the number of blocks read from the node remembered to drop, as soon
+// as read, the blocks it replays (e.g. the last few blocks re-emitted by some nodes when they
+// restart), 0 disables it. A block is dropped when a block with the same number and ID was read,
+// a different ID at the same height (a fork sibling) passes. Dropped blocks are counted in the
+// `replayed_blocks` metric, unlike the blocks skipped by `WithDedupWindow` they are neither
+// archived nor pushed to the block stream server. The window only lives in memory unless
+// persisted with `WithReadDedupPersistence`.
+func WithReadDedupWindow(size int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.readDedupWindow = size
+	}
+}
+
+// WithReadDedupPersistence keeps the window of `WithReadDedupWindow` in the working directory, so
+// the blocks replayed by the node after a restart of the mindreader are dropped too
+func WithReadDedupPersistence() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.readDedupPersisted = true
+	}
+}
+
+// replayed reports if the block was already read, see `WithReadDedupWindow`, remembering it
+// otherwise. It is only called by the produce read flow.
+func (p *MindReaderPlugin) replayed(block *bstream.Block) bool {
+	if p.readDedup == nil {
+		return false
+	}
+
+	if p.readDedup.seen(block) {
+		p.zlogger.Debug("dropping block already read, replayed by the node", zap.Stringer("block", block))
+		metrics.ReplayedBlocks.Inc()
+		return true
+	}
+
+	p.readDedup.record(block)
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_ReadDedup(t *testing.T) {
+	generator := mindreadertest.NewBlockGenerator("read-dedup", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	fork := generator.Fork("a")
+	read := []*bstream.Block{
+		generator.Block(1, 1),
+		generator.Block(2, 1),
+		generator.Block(2, 1),
+		generator.ForkBlock(fork, 2, 1),
+		generator.Block(1, 1),
+		fork.Block(3, 1),
+	}
+
+	readDedup, err := newBlockDedup("", DefaultReadDedupWindow, testLogger)
+	require.NoError(t, err)
+	p := newReadTestPlugin(nil, &fixedBlocksConsoleReader{blocks: read})
+	p.readDedup = readDedup
+
+	replayedBefore := testutil.ToFloat64(metrics.ReplayedBlocks.Native())
+	blocks := make(chan *bstream.Block, len(read))
+	for range read {
+		require.NoError(t, p.readOneMessage(blocks))
+	}
+	close(blocks)
+
+	var ids []string
+	for block := range blocks {
+		ids = append(ids, block.Id)
+	}
+	assert.Equal(t, []string{read[0].Id, read[1].Id, read[3].Id, read[5].Id}, ids, "replayed blocks dropped, fork sibling passed")
+	assert.Equal(t, replayedBefore+2, testutil.ToFloat64(metrics.ReplayedBlocks.Native()))
+}
+
+func TestMindReaderPlugin_ReadDedupPersistedAcrossRestart(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	workingDirectory := t.TempDir()
+	archiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	generator := mindreadertest.NewBlockGenerator("read-dedup", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	run := func(blocks []*bstream.Block, options ...MindReaderPluginOption) (headBlocks []uint64) {
+		var lock sync.Mutex
+		headBlockUpdateFunc := func(num uint64, _ string, _ time.Time, _ uint64) {
+			lock.Lock()
+			defer lock.Unlock()
+			headBlocks = append(headBlocks, num)
+		}
+		consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+			return mindreadertest.NewConsoleReader(ctx.Lines), nil
+		}
+
+		// The archive dedup would skip the replayed blocks as well, only the read one is checked
+		options = append(options, WithDedupWindow(0))
+		p, err := NewMindReaderPluginWithStores(archiveStore, mergeArchiveStore, "never", workingDirectory, consoleReaderFactory, 0, 0, 10, headBlockUpdateFunc, func(error) {}, 0, "suffix", nil, testLogger, testTracer, options...)
+		require.NoError(t, err)
+
+		p.Launch()
+		for _, line := range mindreadertest.FormatLines(blocks) {
+			p.LogLine(line)
+		}
+		p.Stop()
+
+		lock.Lock()
+		defer lock.Unlock()
+		return headBlocks
+	}
+
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, run(generator.Blocks(1, 5), WithReadDedupPersistence()))
+
+	// The node replays blocks 4 and 5 after the restart of the mindreader
+	assert.Equal(t, []uint64{6, 7, 8}, run(generator.Blocks(4, 5), WithReadDedupPersistence()))
+
+	// Without persistence, the window starts empty
+	assert.Equal(t, []uint64{7, 8, 9}, run(generator.Blocks(7, 3)))
+}
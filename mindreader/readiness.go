@@ -0,0 +1,96 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// WithUploadFailureReadinessTimeout makes `Ready` return false once uploads have been failing,
+// without any success, for longer than `timeout` (5 minutes by default)
+func WithUploadFailureReadinessTimeout(timeout time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadFailureReadinessTimeout = timeout
+	}
+}
+
+// Ready reports if the mindreader is archiving blocks: true once a block was stored by the
+// archiver and a file was uploaded, false again while uploads have been failing for longer
+// than the upload failure readiness timeout (see `WithUploadFailureReadinessTimeout`) or a file
+// failed to upload for longer than the `MaxElapsed` of the upload retry policy.
+func (p *MindReaderPlugin) Ready() bool {
+	if p.stats.blocksArchived.Load() == 0 {
+		return false
+	}
+
+	uploaded := false
+	now := time.Now()
+	for _, uploader := range []*FileUploader{p.oneBlockFileUploader, p.mergedBlocksFileUploader} {
+		if uploader.failingFor(now) > p.uploadFailureReadinessTimeout || uploader.retriesExhausted() {
+			return false
+		}
+		uploaded = uploaded || uploader.hasUploaded()
+	}
+	return uploaded
+}
+
+// ReadyCh is closed the first time the mindreader becomes ready (see `Ready`), it is not
+// affected by the mindreader becoming unready afterward.
+func (p *MindReaderPlugin) ReadyCh() <-chan struct{} {
+	p.readyChOnce.Do(func() {
+		p.readyCh = make(chan struct{})
+	})
+	return p.readyCh
+}
+
+// HealthzHandler responds 200 when the mindreader is ready (see `Ready`), 503 otherwise
+func (p *MindReaderPlugin) HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !p.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ready\n"))
+	})
+}
+
+// watchReadiness closes the ready channel once the mindreader is ready
+func (p *MindReaderPlugin) watchReadiness(ctx context.Context) {
+	readyCh := p.ReadyCh()
+	for {
+		select {
+		case <-readyCh:
+			return
+		default:
+		}
+
+		if p.Ready() {
+			p.readyCloseOnce.Do(func() {
+				p.zlogger.Info("mindreader is ready, blocks are archived and uploaded")
+				close(p.readyCh)
+			})
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReadinessTestPlugin() *MindReaderPlugin {
+	return &MindReaderPlugin{
+		zlogger:                       testLogger,
+		oneBlockFileUploader:          NewFileUploader(dstore.NewMockStore(nil), dstore.NewMockStore(nil), testLogger),
+		mergedBlocksFileUploader:      NewFileUploader(dstore.NewMockStore(nil), dstore.NewMockStore(nil), testLogger),
+		uploadFailureReadinessTimeout: 5 * time.Minute,
+	}
+}
+
+func healthzStatus(p *MindReaderPlugin) int {
+	recorder := httptest.NewRecorder()
+	p.HealthzHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	return recorder.Code
+}
+
+func TestMindReaderPlugin_Ready(t *testing.T) {
+	p := newReadinessTestPlugin()
+	assert.False(t, p.Ready())
+
+	p.stats.blocksArchived.Inc()
+	assert.False(t, p.Ready(), "nothing uploaded yet")
+
+	p.mergedBlocksFileUploader.recordUploadResult(nil, time.Now())
+	assert.True(t, p.Ready())
+	assert.Equal(t, http.StatusOK, healthzStatus(p))
+
+	p.oneBlockFileUploader.recordUploadResult(errors.New("bad credentials"), time.Now().Add(-time.Minute))
+	assert.True(t, p.Ready(), "failing for less than the timeout")
+
+	p.oneBlockFileUploader.failingSince.Store(0)
+	p.oneBlockFileUploader.recordUploadResult(errors.New("bad credentials"), time.Now().Add(-6*time.Minute))
+	p.oneBlockFileUploader.recordUploadResult(errors.New("bad credentials"), time.Now())
+	assert.False(t, p.Ready(), "failing for more than the timeout")
+	assert.Equal(t, http.StatusServiceUnavailable, healthzStatus(p))
+
+	p.oneBlockFileUploader.recordUploadResult(nil, time.Now())
+	assert.True(t, p.Ready(), "upload succeeded again")
+}
+
+func TestMindReaderPlugin_ReadyCh(t *testing.T) {
+	p := newReadinessTestPlugin()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.watchReadiness(ctx)
+
+	select {
+	case <-p.ReadyCh():
+		t.Fatal("ready before any block archived")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	p.stats.blocksArchived.Inc()
+	p.oneBlockFileUploader.recordUploadResult(nil, time.Now())
+
+	select {
+	case <-p.ReadyCh():
+	case <-time.After(2 * time.Second):
+		t.Fatal("ready channel not closed")
+	}
+
+	// A relaunch of the plugin must not close the channel again
+	p.watchReadiness(ctx)
+}
@@ -0,0 +1,270 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const quarantineDirName = "quarantine"
+
+type WorkingFileClass string
+
+const (
+	// WorkingFilePendingUpload is a valid block file waiting to be uploaded to an archive store
+	WorkingFilePendingUpload WorkingFileClass = "pending_upload"
+	// WorkingFilePendingMerge is a valid one block file waiting to be merged in a bundle
+	WorkingFilePendingMerge WorkingFileClass = "pending_merge"
+	// WorkingFileState is a file holding the plugin's own state, like the dedup window
+	WorkingFileState WorkingFileClass = "state"
+	// WorkingFileCorrupt is a block file that cannot be read or a leftover temporary file
+	WorkingFileCorrupt WorkingFileClass = "corrupt"
+	// WorkingFileUnknown is a file the plugin does not know about
+	WorkingFileUnknown WorkingFileClass = "unknown"
+)
+
+var blockFileDirClasses = map[string]WorkingFileClass{
+	"mergeable":           WorkingFilePendingMerge,
+	"uploadable-oneblock": WorkingFilePendingUpload,
+	"uploadable-merged":   WorkingFilePendingUpload,
+}
+
+// WithReconcileKeepFiles marks files, or directories, of the working directory (relative to it)
+// as known to the startup reconciliation, they are never quarantined. Use it when other
+// components, like the continuity checker, keep their state in the working directory.
+func WithReconcileKeepFiles(names ...string) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.reconcileKeepFiles = append(p.reconcileKeepFiles, names...)
+	}
+}
+
+type ReconciledFile struct {
+	Path  string           `json:"path"` // relative to the working directory
+	Class WorkingFileClass `json:"class"`
+	Size  int64            `json:"size"`
+
+	// Reason a file is corrupt, and where it was quarantined (relative to the working directory)
+	Reason        string `json:"reason,omitempty"`
+	QuarantinedTo string `json:"quarantined_to,omitempty"`
+}
+
+type WorkingFileClassSummary struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ReconciliationReport describes the working directory as found when the plugin was created
+type ReconciliationReport struct {
+	Time    time.Time                                    `json:"time"`
+	Files   []ReconciledFile                             `json:"files"`
+	Classes map[WorkingFileClass]WorkingFileClassSummary `json:"classes"`
+
+	// QuarantineErrors lists the files that could not be moved to quarantine, they are left in place
+	QuarantineErrors []string `json:"quarantine_errors,omitempty"`
+}
+
+func (r *ReconciliationReport) add(file ReconciledFile) {
+	r.Files = append(r.Files, file)
+
+	summary := r.Classes[file.Class]
+	summary.Count++
+	summary.Bytes += file.Size
+	r.Classes[file.Class] = summary
+}
+
+// Quarantined returns the files moved to quarantine
+func (r *ReconciliationReport) Quarantined() (out []ReconciledFile) {
+	for _, file := range r.Files {
+		if file.QuarantinedTo != "" {
+			out = append(out, file)
+		}
+	}
+	return
+}
+
+func (r *ReconciliationReport) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	for _, class := range []WorkingFileClass{WorkingFilePendingUpload, WorkingFilePendingMerge, WorkingFileState, WorkingFileCorrupt, WorkingFileUnknown} {
+		summary := r.Classes[class]
+		encoder.AddInt(string(class)+"_count", summary.Count)
+		encoder.AddInt64(string(class)+"_bytes", summary.Bytes)
+	}
+	encoder.AddInt("quarantined", len(r.Quarantined()))
+	encoder.AddInt("quarantine_errors", len(r.QuarantineErrors))
+	return nil
+}
+
+// ReconciliationReport returns the report of the working directory reconciliation done when the
+// plugin was created: valid pending files are uploaded once the plugin is launched, corrupt and
+// unknown files were moved to the `quarantine` subdirectory.
+func (p *MindReaderPlugin) ReconciliationReport() *ReconciliationReport {
+	return p.reconciliation
+}
+
+// reconcileKeptFiles returns the files never quarantined, including the dry run directory when
+// it is within the working directory
+func (p *MindReaderPlugin) reconcileKeptFiles(workingDirectory string) []string {
+	keep := append([]string(nil), p.reconcileKeepFiles...)
+	if p.dryRun != nil {
+		if rel, err := filepath.Rel(workingDirectory, p.dryRun.localDir); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			keep = append(keep, rel)
+		}
+	}
+	return keep
+}
+
+// reconcileWorkingDirectory classifies every file of the working directory, block files being
+// checked by reading their header and first block with `readerFactory` (not checked when nil),
+// and moves the corrupt and unknown ones to quarantine. Files in `keep` are left untouched.
+func reconcileWorkingDirectory(ctx context.Context, workingDirectory string, readerFactory bstream.BlockReaderFactory, oneBlockFiles blockFileFormat, keep []string, logger *zap.Logger) (*ReconciliationReport, error) {
+	now := time.Now()
+	report := &ReconciliationReport{Time: now, Classes: map[WorkingFileClass]WorkingFileClassSummary{}}
+
+	if readerFactory == nil {
+		logger.Warn("no block reader factory set, block files of the working directory are not checked for corruption")
+	}
+
+	stores := map[string]dstore.Store{}
+	err := filepath.Walk(workingDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(workingDirectory, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if rel == quarantineDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		file := ReconciledFile{Path: rel, Size: info.Size()}
+		file.Class, file.Reason = classifyWorkingFile(ctx, workingDirectory, rel, keep, readerFactory, oneBlockFiles, stores)
+		report.add(file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking working directory %q: %w", workingDirectory, err)
+	}
+
+	quarantineDir := filepath.Join(quarantineDirName, now.UTC().Format("20060102T150405"))
+	for i, file := range report.Files {
+		if file.Class != WorkingFileCorrupt && file.Class != WorkingFileUnknown {
+			continue
+		}
+
+		destination := filepath.ToSlash(filepath.Join(quarantineDir, file.Path))
+		if err := moveToQuarantine(workingDirectory, file.Path, destination); err != nil {
+			logger.Error("unable to quarantine working directory file, leaving it in place", zap.String("file", file.Path), zap.Error(err))
+			report.QuarantineErrors = append(report.QuarantineErrors, fmt.Sprintf("%s: %s", file.Path, err))
+			continue
+		}
+		report.Files[i].QuarantinedTo = destination
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	return report, nil
+}
+
+func classifyWorkingFile(ctx context.Context, workingDirectory, rel string, keep []string, readerFactory bstream.BlockReaderFactory, oneBlockFiles blockFileFormat, stores map[string]dstore.Store) (WorkingFileClass, string) {
+	for _, kept := range keep {
+		kept = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(kept)), "/")
+		if kept == "." || rel == kept || strings.HasPrefix(rel, kept+"/") {
+			return WorkingFileState, ""
+		}
+	}
+
+	if strings.HasSuffix(rel, ".tmp") {
+		return WorkingFileCorrupt, "leftover temporary file"
+	}
+	if rel == dedupFilename || rel == readDedupFilename || rel == stopMarkerFilename {
+		return WorkingFileState, ""
+	}
+
+	parts := strings.SplitN(rel, "/", 2)
+	class, found := blockFileDirClasses[parts[0]]
+	format := oneBlockFiles
+	if parts[0] == "uploadable-merged" {
+		format = mergedBlocksFileFormat
+	}
+	if !found || len(parts) != 2 || !strings.HasSuffix(parts[1], "."+format.extension) {
+		return WorkingFileUnknown, ""
+	}
+	if dir, _ := path.Split(parts[1]); dir != "" && (parts[0] != "uploadable-oneblock" || !isPartitionPrefix(strings.TrimSuffix(dir, "/"))) {
+		// Only the one block files are partitioned, see `WithOneBlockFilePartitioning`
+		return WorkingFileUnknown, ""
+	}
+
+	if readerFactory == nil {
+		return class, ""
+	}
+
+	store, found := stores[parts[0]]
+	if !found {
+		var err error
+		if store, err = format.localStore(filepath.Join(workingDirectory, parts[0])); err != nil {
+			return WorkingFileCorrupt, fmt.Sprintf("opening store: %s", err)
+		}
+		stores[parts[0]] = store
+	}
+
+	if err := checkBlockFile(ctx, store, strings.TrimSuffix(parts[1], "."+format.extension), readerFactory); err != nil {
+		return WorkingFileCorrupt, err.Error()
+	}
+	return class, ""
+}
+
+// checkBlockFile reads the header and the first block of a block file
+func checkBlockFile(ctx context.Context, store dstore.Store, name string, readerFactory bstream.BlockReaderFactory) error {
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return fmt.Errorf("opening block file: %w", err)
+	}
+	defer reader.Close()
+
+	blockReader, err := readerFactory.New(reader)
+	if err != nil {
+		return fmt.Errorf("reading block file header: %w", err)
+	}
+
+	if _, err := blockReader.Read(); err != nil {
+		return fmt.Errorf("reading first block: %w", err)
+	}
+	return nil
+}
+
+func moveToQuarantine(workingDirectory, rel, destination string) error {
+	target := filepath.Join(workingDirectory, destination)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return fmt.Errorf("creating quarantine directory: %w", err)
+	}
+	return os.Rename(filepath.Join(workingDirectory, rel), target)
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var dbinReaderFactory = bstream.BlockReaderFactoryFunc(func(reader io.Reader) (bstream.BlockReader, error) {
+	return bstream.NewDBinBlockReader(reader, nil)
+})
+
+func writeBlockFile(t *testing.T, dir, name string, blocks ...*bstream.Block) {
+	t.Helper()
+
+	buffer := &bytes.Buffer{}
+	writer, err := bstream.NewDBinBlockWriter(buffer, "TST", 1)
+	require.NoError(t, err)
+	for _, blk := range blocks {
+		require.NoError(t, writer.Write(blk))
+	}
+
+	store, err := dstore.NewDBinStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.WriteObject(context.Background(), name, buffer))
+}
+
+func writeWorkingFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestReconcileWorkingDirectory(t *testing.T) {
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+
+	dir := t.TempDir()
+	generator := mindreadertest.NewBlockGenerator("reconcile", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	writeBlockFile(t, filepath.Join(dir, "uploadable-oneblock"), "0000000010-valid", generator.Block(10, 10))
+	writeBlockFile(t, filepath.Join(dir, "uploadable-merged"), "0000000000", generator.Blocks(0, 3)...)
+	writeBlockFile(t, filepath.Join(dir, "mergeable"), "0000000011-valid", generator.Block(11, 10))
+	writeBlockFile(t, filepath.Join(dir, "mergeable"), "0000000012-header-only")
+	writeWorkingFile(t, filepath.Join(dir, "uploadable-oneblock", "0000000013-garbage.dbin.zst"), "not a block file")
+	writeWorkingFile(t, filepath.Join(dir, "mergeable", "0000000014-half-written.dbin.zst.tmp"), "half")
+	writeWorkingFile(t, filepath.Join(dir, dedupFilename), "10 abc\n")
+	writeWorkingFile(t, filepath.Join(dir, "core.1234"), "dump")
+	writeWorkingFile(t, filepath.Join(dir, "continuity", "state.json"), "{}")
+
+	report, err := reconcileWorkingDirectory(context.Background(), dir, dbinReaderFactory, blockFileFormats[DefaultOneBlockFileCompression], []string{"continuity"}, testLogger)
+	require.NoError(t, err)
+
+	classes := map[string]WorkingFileClass{}
+	for _, file := range report.Files {
+		classes[file.Path] = file.Class
+	}
+	assert.Equal(t, map[string]WorkingFileClass{
+		"uploadable-oneblock/0000000010-valid.dbin.zst":   WorkingFilePendingUpload,
+		"uploadable-merged/0000000000.dbin.zst":           WorkingFilePendingUpload,
+		"mergeable/0000000011-valid.dbin.zst":             WorkingFilePendingMerge,
+		"mergeable/0000000012-header-only.dbin.zst":       WorkingFileCorrupt,
+		"uploadable-oneblock/0000000013-garbage.dbin.zst": WorkingFileCorrupt,
+		"mergeable/0000000014-half-written.dbin.zst.tmp":  WorkingFileCorrupt,
+		dedupFilename:           WorkingFileState,
+		"core.1234":             WorkingFileUnknown,
+		"continuity/state.json": WorkingFileState,
+	}, classes)
+
+	assert.Equal(t, 3, report.Classes[WorkingFileCorrupt].Count)
+	assert.Equal(t, WorkingFileClassSummary{Count: 1, Bytes: int64(len("dump"))}, report.Classes[WorkingFileUnknown])
+	assert.Equal(t, 2, report.Classes[WorkingFilePendingUpload].Count)
+
+	quarantined := report.Quarantined()
+	require.Len(t, quarantined, 4)
+	for _, file := range quarantined {
+		assert.NoFileExists(t, filepath.Join(dir, file.Path))
+		assert.FileExists(t, filepath.Join(dir, file.QuarantinedTo))
+	}
+	assert.FileExists(t, filepath.Join(dir, "continuity", "state.json"))
+	assert.FileExists(t, filepath.Join(dir, "uploadable-merged", "0000000000.dbin.zst"))
+
+	again, err := reconcileWorkingDirectory(context.Background(), dir, dbinReaderFactory, blockFileFormats[DefaultOneBlockFileCompression], []string{"continuity"}, testLogger)
+	require.NoError(t, err)
+	assert.Empty(t, again.Quarantined(), "quarantine is not reconciled")
+	assert.Len(t, again.Files, 5)
+}
+
+func TestReconcileWorkingDirectory_NoReaderFactory(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkingFile(t, filepath.Join(dir, "uploadable-oneblock", "0000000013-garbage.dbin.zst"), "not a block file")
+
+	report, err := reconcileWorkingDirectory(context.Background(), dir, nil, blockFileFormats[DefaultOneBlockFileCompression], nil, testLogger)
+	require.NoError(t, err)
+	require.Len(t, report.Files, 1)
+	assert.Equal(t, WorkingFilePendingUpload, report.Files[0].Class, "block files not checked")
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var replayProgressInterval = 10 * time.Second
+
+// ReplayFromFiles launches `plugin` and feeds it the console logs read from the files matching
+// `patterns` (see `filepath.Glob`), in lexical order, instead of the output of a running node.
+// Files ending with `.gz` are decompressed. The start gate and stop block of the plugin are
+// respected, the replay ends once the stop block is reached or at the end of the last file,
+// after the plugin is stopped and every produced file is uploaded.
+func ReplayFromFiles(ctx context.Context, patterns []string, plugin *MindReaderPlugin) error {
+	files, err := replayFiles(patterns)
+	if err != nil {
+		return err
+	}
+
+	plugin.zlogger.Info("replaying console logs from files", zap.Int("file_count", len(files)), zap.Strings("patterns", patterns))
+	plugin.Launch()
+
+	progress := &replayProgress{start: time.Now(), lastReport: time.Now(), zlogger: plugin.zlogger}
+	for _, file := range files {
+		if ctx.Err() != nil || plugin.IsTerminating() {
+			break
+		}
+
+		if err = replayFile(ctx, file, plugin, progress); err != nil {
+			err = fmt.Errorf("replaying %q: %w", file, err)
+			break
+		}
+	}
+
+	plugin.Stop()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil && !errors.Is(plugin.Err(), ErrStopBlockReached) {
+		err = plugin.Err()
+	}
+
+	// Files still not uploaded when the plugin was stopped
+	uploadCtx := ctx
+	if uploadCtx.Err() != nil {
+		uploadCtx = context.Background()
+	}
+	for _, uploader := range []*FileUploader{plugin.oneBlockFileUploader, plugin.mergedBlocksFileUploader} {
+		if _, uploadErr := uploader.uploadAllFiles(uploadCtx); uploadErr != nil && err == nil {
+			err = fmt.Errorf("final upload: %w", uploadErr)
+		}
+	}
+
+	plugin.zlogger.Info("replay completed", zap.Uint64("lines", progress.lines), zap.Duration("elapsed", time.Since(progress.start)), zap.Error(err))
+	return err
+}
+
+// replayFiles returns the files matching any of the patterns, sorted lexically without duplicates
+func replayFiles(patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replay pattern %q: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files matching replay patterns %q", patterns)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func replayFile(ctx context.Context, file string, plugin *MindReaderPlugin, progress *replayProgress) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	plugin.zlogger.Info("replaying file", zap.String("file", file))
+	progress.file = file
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), defaultFeederMaxLineSize)
+	for scanner.Scan() {
+		if ctx.Err() != nil || plugin.IsTerminating() {
+			return nil
+		}
+
+		plugin.LogLine(scanner.Text())
+		progress.lineRead()
+	}
+
+	return scanner.Err()
+}
+
+type replayProgress struct {
+	file       string
+	lines      uint64
+	start      time.Time
+	lastReport time.Time
+	lastLines  uint64
+	zlogger    *zap.Logger
+}
+
+func (p *replayProgress) lineRead() {
+	p.lines++
+
+	elapsed := time.Since(p.lastReport)
+	if elapsed < replayProgressInterval {
+		return
+	}
+
+	p.zlogger.Info("replay progress",
+		zap.String("file", p.file),
+		zap.Uint64("lines", p.lines),
+		zap.Float64("lines_per_second", float64(p.lines-p.lastLines)/elapsed.Seconds()),
+	)
+	p.lastReport = time.Now()
+	p.lastLines = p.lines
+}
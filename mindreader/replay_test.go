@@ -0,0 +1,108 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplayTestPlugin(t *testing.T, startBlock, stopBlock uint64) (*MindReaderPlugin, func() []uint64) {
+	t.Helper()
+
+	var lock sync.Mutex
+	var headBlocks []uint64
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	headBlockUpdateFunc := func(blockNum uint64, blockID string, blockTime time.Time, libNum uint64) {
+		lock.Lock()
+		defer lock.Unlock()
+		headBlocks = append(headBlocks, blockNum)
+	}
+
+	p, err := newMindReaderPlugin(consoleReaderFactory, startBlock, stopBlock, 0, headBlockUpdateFunc, nil, testLogger)
+	require.NoError(t, err)
+	p.archiver = newArchiverWithIO(t, mindreadertest.NewRecordingArchiverIO(), 0)
+	p.oneBlockFileUploader = NewFileUploader(dstore.NewMockStore(nil), dstore.NewMockStore(nil), testLogger)
+	p.mergedBlocksFileUploader = NewFileUploader(dstore.NewMockStore(nil), dstore.NewMockStore(nil), testLogger)
+
+	return p, func() []uint64 {
+		lock.Lock()
+		defer lock.Unlock()
+		return headBlocks
+	}
+}
+
+func writeReplayFile(t *testing.T, path string, lines ...string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var writer io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		gzipWriter := gzip.NewWriter(f)
+		defer gzipWriter.Close()
+		writer = gzipWriter
+	}
+
+	_, err = io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	require.NoError(t, err)
+}
+
+func TestReplayFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeReplayFile(t, filepath.Join(dir, "nodeos.log.2"), `DMLOG {"id":"00000004a"}`, `DMLOG {"id":"00000005a"}`)
+	writeReplayFile(t, filepath.Join(dir, "nodeos.log.1.gz"), `DMLOG {"id":"00000001a"}`, `DMLOG {"id":"00000002a"}`, `DMLOG {"id":"00000003a"}`)
+
+	plugin, headBlocks := newReplayTestPlugin(t, 2, 0)
+
+	err := ReplayFromFiles(context.Background(), []string{filepath.Join(dir, "*.gz"), filepath.Join(dir, "nodeos.log.*")}, plugin)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint64{2, 3, 4, 5}, headBlocks())
+}
+
+func TestReplayFromFiles_StopBlock(t *testing.T) {
+	dir := t.TempDir()
+	writeReplayFile(t, filepath.Join(dir, "nodeos.log"), `DMLOG {"id":"00000001a"}`, `DMLOG {"id":"00000002a"}`, `DMLOG {"id":"00000003a"}`)
+
+	plugin, headBlocks := newReplayTestPlugin(t, 0, 2)
+
+	require.NoError(t, ReplayFromFiles(context.Background(), []string{filepath.Join(dir, "*")}, plugin))
+	assert.Equal(t, []uint64{1, 2}, headBlocks()[0:2])
+}
+
+func TestReplayFromFiles_NoMatch(t *testing.T) {
+	plugin, _ := newReplayTestPlugin(t, 0, 0)
+
+	err := ReplayFromFiles(context.Background(), []string{filepath.Join(t.TempDir(), "*.log")}, plugin)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no files matching replay patterns")
+}
@@ -0,0 +1,168 @@
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// FileUploaderSecondaryStores replicates every uploaded file to `stores`. A file counts as
+// uploaded once written to the destination store, the primary, it is then written to the
+// secondary stores in the background, each failing one being retried with the backoff of the
+// retry policy. The local file is deleted once every store has it or, when `giveUpTimeout` is
+// non-zero, once it elapsed since the primary upload, logging the stores missing it. Files
+// still replicating when the mindreader stops are uploaded to every store again on restart.
+func FileUploaderSecondaryStores(giveUpTimeout time.Duration, stores ...dstore.Store) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.secondaryStores = stores
+		fu.secondaryGiveUp = giveUpTimeout
+	}
+}
+
+// WithSecondaryArchiveStores replicates the one block files to the stores of `urls`, in addition
+// to the archive store, see `FileUploaderSecondaryStores`. Ignored in dry run.
+func WithSecondaryArchiveStores(urls []string, giveUpTimeout time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.secondaryArchiveStoreURLs = urls
+		p.secondaryArchiveGiveUp = giveUpTimeout
+	}
+}
+
+type replicationState struct {
+	primaryUploadedAt time.Time
+	missing           map[int]*uploadRetryState // by index of the secondary store
+}
+
+func storeLabel(store dstore.Store) string {
+	return store.BaseURL().String()
+}
+
+// replicating reports if `filename` was uploaded to the primary store and is waiting to be
+// replicated to the secondary stores
+func (fu *FileUploader) replicating(filename string) bool {
+	fu.replicationLock.Lock()
+	defer fu.replicationLock.Unlock()
+
+	_, found := fu.replications[filename]
+	return found
+}
+
+func (fu *FileUploader) startReplication(filename string, now time.Time) {
+	state := &replicationState{primaryUploadedAt: now, missing: map[int]*uploadRetryState{}}
+	for i := range fu.secondaryStores {
+		state.missing[i] = &uploadRetryState{}
+	}
+
+	fu.replicationLock.Lock()
+	defer fu.replicationLock.Unlock()
+
+	fu.replications[filename] = state
+}
+
+// writeLocalFile writes the local file to `store`, keeping it, unlike `PushLocalFile`
+func (fu *FileUploader) writeLocalFile(ctx context.Context, store dstore.Store, filename string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	f, err := os.Open(fu.localStore.ObjectPath(filename))
+	if err != nil {
+		return fmt.Errorf("open local file: %w", err)
+	}
+	defer f.Close()
+
+	err = store.WriteObject(ctx, fu.destinationName(filename), f)
+	recordStoreUpload(store, err)
+	return err
+}
+
+func recordStoreUpload(store dstore.Store, err error) {
+	if err != nil {
+		metrics.StoreUploads.Inc(storeLabel(store), "failure")
+		return
+	}
+	metrics.StoreUploads.Inc(storeLabel(store), "success")
+}
+
+func (fu *FileUploader) runReplication(ctx context.Context) {
+	for {
+		_ = fu.unlessPaused(func() error {
+			fu.replicateFiles(ctx, time.Now())
+			return nil
+		})
+
+		select {
+		case <-fu.Terminating():
+			return
+		case <-time.After(fu.pollInterval):
+		}
+	}
+}
+
+// replicateFiles writes the files waiting for replication to the secondary stores missing them,
+// when their backoff elapsed, and deletes the local files once replicated or given up on
+func (fu *FileUploader) replicateFiles(ctx context.Context, now time.Time) {
+	fu.replicationLock.Lock()
+	filenames := make([]string, 0, len(fu.replications))
+	for filename := range fu.replications {
+		filenames = append(filenames, filename)
+	}
+	fu.replicationLock.Unlock()
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		if ctx.Err() != nil {
+			return
+		}
+
+		fu.replicationLock.Lock()
+		state := fu.replications[filename]
+		fu.replicationLock.Unlock()
+
+		for i, retry := range state.missing {
+			if now.Before(retry.nextAttempt) {
+				continue
+			}
+
+			store := fu.secondaryStores[i]
+			if err := fu.writeLocalFile(ctx, store, filename); err != nil {
+				retry.attempts++
+				retry.nextAttempt = now.Add(fu.retryPolicy.backoff(retry.attempts))
+				fu.logger.Debug("failed to replicate file to secondary store, will retry", zap.String("local_file", filename), zap.String("store", storeLabel(store)), zap.Int("attempts", retry.attempts), zap.Error(err))
+				continue
+			}
+			delete(state.missing, i)
+		}
+
+		if len(state.missing) > 0 {
+			if fu.secondaryGiveUp == 0 || now.Sub(state.primaryUploadedAt) < fu.secondaryGiveUp {
+				continue
+			}
+
+			var missingFrom []string
+			for i := range state.missing {
+				missingFrom = append(missingFrom, storeLabel(fu.secondaryStores[i]))
+			}
+			sort.Strings(missingFrom)
+			fu.logger.Warn("giving up on replicating file to secondary stores, deleting local file",
+				zap.String("local_file", filename),
+				zap.Strings("missing_from_stores", missingFrom),
+				zap.Duration("give_up_timeout", fu.secondaryGiveUp),
+			)
+		}
+
+		if err := fu.localStore.DeleteObject(ctx, filename); err != nil {
+			fu.logger.Warn("unable to delete replicated local file, will retry", zap.String("local_file", filename), zap.Error(err))
+			continue
+		}
+
+		fu.replicationLock.Lock()
+		delete(fu.replications, filename)
+		fu.replicationLock.Unlock()
+	}
+}
@@ -0,0 +1,171 @@
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFlappingStore returns a store failing the first `failures` writes of each file
+func newFlappingStore(failures int) (*dstore.MockStore, map[string]int) {
+	var lock sync.Mutex
+	attempts := map[string]int{}
+
+	store := dstore.NewMockStore(nil)
+	store.WriteObjectFunc = func(_ context.Context, base string, f io.Reader) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		attempts[base]++
+		if attempts[base] <= failures {
+			return fmt.Errorf("store unavailable")
+		}
+
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		store.SetFile(base, content)
+		return nil
+	}
+	return store, attempts
+}
+
+func newReplicatedUploadTestStores(t *testing.T, files ...string) dstore.Store {
+	t.Helper()
+
+	local, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	for _, file := range files {
+		require.NoError(t, local.WriteObject(context.Background(), file, strings.NewReader("block "+file)))
+	}
+	return local
+}
+
+func localFiles(t *testing.T, store dstore.Store) (out []string) {
+	t.Helper()
+
+	require.NoError(t, store.Walk(context.Background(), "", func(filename string) error {
+		out = append(out, filename)
+		return nil
+	}))
+	return
+}
+
+func assertStoreHas(t *testing.T, store dstore.Store, files ...string) {
+	t.Helper()
+
+	for _, file := range files {
+		exists, err := store.FileExists(context.Background(), file)
+		require.NoError(t, err)
+		assert.True(t, exists, "store has %q", file)
+	}
+}
+
+func TestFileUploader_SecondaryStores(t *testing.T) {
+	ctx := context.Background()
+	local := newReplicatedUploadTestStores(t, "0000000001", "0000000002")
+
+	primary, primaryAttempts := newFlappingStore(0)
+	stable, _ := newFlappingStore(0)
+	flapping, flappingAttempts := newFlappingStore(2)
+
+	uploader := NewFileUploader(local, primary, testLogger,
+		FileUploaderConcurrency(1),
+		FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		FileUploaderSecondaryStores(time.Hour, stable, flapping),
+	)
+
+	uploaded, err := uploader.uploadAllFiles(ctx)
+	require.NoError(t, err)
+	sort.Strings(uploaded)
+	assert.Equal(t, []string{"0000000001", "0000000002"}, uploaded, "uploaded once in the primary store")
+	assertStoreHas(t, primary, "0000000001", "0000000002")
+	assert.Equal(t, []string{"0000000001", "0000000002"}, localFiles(t, local), "local files kept until replicated")
+
+	uploaded, err = uploader.uploadAllFiles(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, uploaded, "files waiting for replication are not uploaded again")
+	assert.Equal(t, 1, primaryAttempts["0000000001"])
+
+	now := time.Now()
+	uploader.replicateFiles(ctx, now)
+	assertStoreHas(t, stable, "0000000001", "0000000002")
+	assert.Equal(t, []string{"0000000001", "0000000002"}, localFiles(t, local), "flapping store still missing the files")
+
+	uploader.replicateFiles(ctx, now)
+	assert.Equal(t, 1, flappingAttempts["0000000001"], "retried after its backoff")
+
+	uploader.replicateFiles(ctx, now.Add(time.Second))
+	uploader.replicateFiles(ctx, now.Add(2*time.Second))
+	assert.Equal(t, 3, flappingAttempts["0000000001"])
+	assertStoreHas(t, flapping, "0000000001", "0000000002")
+	assert.Empty(t, localFiles(t, local), "local files deleted once every store has them")
+	assert.False(t, uploader.replicating("0000000001"))
+}
+
+func TestFileUploader_SecondaryStoresGiveUp(t *testing.T) {
+	ctx := context.Background()
+	local := newReplicatedUploadTestStores(t, "0000000001")
+
+	primary, _ := newFlappingStore(0)
+	down, _ := newFlappingStore(1000)
+
+	uploader := NewFileUploader(local, primary, testLogger,
+		FileUploaderConcurrency(1),
+		FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		FileUploaderSecondaryStores(time.Minute, down),
+	)
+
+	_, err := uploader.uploadAllFiles(ctx)
+	require.NoError(t, err)
+
+	now := time.Now()
+	uploader.replicateFiles(ctx, now.Add(30*time.Second))
+	assert.Equal(t, []string{"0000000001"}, localFiles(t, local), "kept before the give up timeout")
+
+	uploader.replicateFiles(ctx, now.Add(2*time.Minute))
+	assert.Empty(t, localFiles(t, local), "deleted once the give up timeout elapsed")
+	assert.False(t, uploader.replicating("0000000001"))
+}
+
+func TestFileUploader_SecondaryStoresPrimaryFailure(t *testing.T) {
+	ctx := context.Background()
+	local := newReplicatedUploadTestStores(t, "0000000001")
+
+	primary, _ := newFlappingStore(1)
+	secondary, secondaryAttempts := newFlappingStore(0)
+
+	uploader := NewFileUploader(local, primary, testLogger,
+		FileUploaderConcurrency(1),
+		FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		FileUploaderSecondaryStores(time.Hour, secondary),
+	)
+	uploader.retries = 0
+
+	uploaded, err := uploader.uploadAllFiles(ctx)
+	require.Error(t, err)
+	assert.Empty(t, uploaded, "not uploaded until the primary store has it")
+
+	uploader.replicateFiles(ctx, time.Now())
+	assert.Equal(t, 0, secondaryAttempts["0000000001"], "not replicated before the primary upload")
+
+	time.Sleep(5 * time.Millisecond)
+	uploaded, err = uploader.uploadAllFiles(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000000001"}, uploaded)
+
+	uploader.replicateFiles(ctx, time.Now())
+	assertStoreHas(t, secondary, "0000000001")
+	assert.Empty(t, localFiles(t, local))
+}
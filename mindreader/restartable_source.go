@@ -0,0 +1,120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// ErrSourceAttached is returned by `Reattach` while the current source did not end yet
+var ErrSourceAttached = errors.New("console reader source still attached")
+
+// WithRestartableSource keeps the plugin alive when the console reader stream ends before the
+// stop block, the read loop waiting for a new source given to `Reattach` instead of shutting the
+// plugin down with an `UnexpectedEOFError`. The start gate, the stop block and the read flow are
+// kept, blocks read from the new source continue the ones read before.
+func WithRestartableSource() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.restartableSource = true
+	}
+}
+
+// waitForReattach holds the read flow open until `Reattach` detaches the ended pipe, closing the
+// blocks channel if the plugin terminates first
+func (p *MindReaderPlugin) waitForReattach(blocks chan *bstream.Block, detached <-chan struct{}) {
+	p.zlogger.Warn("console reader stream ended before the stop block, waiting for a new source to be reattached", zap.Uint64("last_block_num", p.stats.lastBlockNum.Load()))
+	p.awaitingSource.Store(true)
+
+	select {
+	case <-detached:
+	case <-p.Terminating():
+		if p.awaitingSource.CAS(true, false) {
+			close(blocks)
+			return
+		}
+		// `Reattach` won the race, it detaches the pipe right away
+		<-detached
+	}
+}
+
+// Reattach resumes reading, from the lines of `r`, once the previous console reader stream ended,
+// see `WithRestartableSource`. It returns `ErrSourceAttached` while the previous stream did not
+// end yet. The lines of `r` go through `LogLine`, the stream ends again once `r` reaches EOF
+// (or fails), `r` being closed when the plugin terminates if it is an `io.Closer`.
+func (p *MindReaderPlugin) Reattach(r io.Reader) error {
+	if !p.restartableSource {
+		return fmt.Errorf("plugin was not created with a restartable source")
+	}
+	if p.IsTerminating() {
+		return fmt.Errorf("plugin is terminating")
+	}
+	if !p.awaitingSource.CAS(true, false) {
+		return ErrSourceAttached
+	}
+
+	p.zlogger.Info("reattaching console reader to a new source")
+	p.pipeLock.Lock()
+	close(p.pipeDetached)
+	<-p.readLoopDone
+
+	lines, err := p.attachPipe()
+	p.pipeLock.Unlock()
+	if err != nil {
+		err = fmt.Errorf("creating console reader for reattached source: %w", err)
+		p.Shutdown(err)
+		close(p.blocks)
+		return err
+	}
+
+	go p.feedSource(r, lines)
+	return nil
+}
+
+// feedSource logs the lines of `r` until it ends, closing `lines` then
+func (p *MindReaderPlugin) feedSource(r io.Reader, lines chan string) {
+	if closer, ok := r.(io.Closer); ok {
+		// Closing the reader is the only way to unblock a pending read
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-p.Terminating():
+				closer.Close()
+			case <-done:
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), defaultFeederMaxLineSize)
+	for scanner.Scan() {
+		p.LogLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && !p.IsTerminating() {
+		p.zlogger.Warn("reading reattached source failed, ending its stream", zap.Error(err))
+	}
+
+	p.pipeLock.Lock()
+	defer p.pipeLock.Unlock()
+	if p.lines == lines {
+		p.closeLinesLocked()
+	}
+}
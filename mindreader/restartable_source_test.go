@@ -0,0 +1,86 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_Reattach(t *testing.T) {
+	p, headBlocks := newReplayTestPlugin(t, 0, 6)
+	WithRestartableSource()(p)
+
+	attached := 0
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		attached++
+		if attached == 1 {
+			// the first source ends on its own, like the output of a node process that died
+			return mindreadertest.NewScriptedConsoleReader(nil, mindreadertest.Step{Line: `DMLOG {"id":"00000001a"}`}, mindreadertest.Step{Line: `DMLOG {"id":"00000002a"}`}, mindreadertest.Step{Line: `DMLOG {"id":"00000003a"}`}), nil
+		}
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+
+	assert.EqualError(t, (&MindReaderPlugin{}).Reattach(strings.NewReader("")), "plugin was not created with a restartable source")
+
+	terminatingErr := make(chan error, 1)
+	p.OnTerminating(func(err error) { terminatingErr <- err })
+	p.Launch()
+	defer p.Stop()
+
+	require.Eventually(t, func() bool { return p.awaitingSource.Load() }, time.Second, 5*time.Millisecond)
+	assert.False(t, p.IsTerminating(), "waits for a new source instead of shutting down")
+
+	require.NoError(t, p.Reattach(strings.NewReader(strings.Join([]string{`DMLOG {"id":"00000004a"}`, `DMLOG {"id":"00000005a"}`, `DMLOG {"id":"00000006a"}`}, "\n"))))
+	assert.Equal(t, ErrSourceAttached, p.Reattach(strings.NewReader("")))
+
+	select {
+	case err := <-terminatingErr:
+		assert.True(t, errors.Is(err, ErrStopBlockReached), "got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("plugin not shut down after stop block")
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6}, headBlocks())
+}
+
+func TestMindReaderPlugin_ReattachWaitEndsOnShutdown(t *testing.T) {
+	p, _ := newReplayTestPlugin(t, 0, 10)
+	WithRestartableSource()(p)
+	p.consoleReaderFactory = func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewScriptedConsoleReader(nil, mindreadertest.Step{Line: `DMLOG {"id":"00000001a"}`}), nil
+	}
+
+	p.Launch()
+	require.Eventually(t, func() bool { return p.awaitingSource.Load() }, time.Second, 5*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("read flow not completed while waiting for a new source")
+	}
+	assert.NoError(t, p.Err())
+}
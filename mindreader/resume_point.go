@@ -0,0 +1,309 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// blockFileNumDigits is the width of the zero padded block number starting the name of one
+// block and merged blocks files
+const blockFileNumDigits = 10
+
+const defaultResumePointCheckTimeout = 30 * time.Second
+
+// ResumePointCheckOptions configures the check done on the first block read after the start
+// block, comparing it to the highest block archived, see `WithResumePointCheck`.
+type ResumePointCheckOptions struct {
+	// MaxOverlap is how many already archived blocks the node may produce again, a restarted
+	// node commonly resumes a few blocks behind what it archived
+	MaxOverlap uint64
+
+	// MaxGap is how many blocks may be missing between the highest block archived and the
+	// first block of the node
+	MaxGap uint64
+
+	// RefuseArchiving discards the blocks read, and puts the node in maintenance when a
+	// maintenance requester is set, until `OverrideResumePointCheck` is called
+	RefuseArchiving bool
+
+	// Timeout of the listing of the stores, defaults to 30 seconds. A listing failure is
+	// logged and the check skipped.
+	Timeout time.Duration
+}
+
+// WithResumePointCheck checks that the node resumes where the archive stopped: the first block
+// read after the start block is compared to the highest block found in the archive store and in
+// the merged blocks store. A node behind the archive by more than `MaxOverlap` blocks, or ahead
+// of it by more than `MaxGap` blocks, is reported. The listing is bounded, it looks for the
+// highest block number one digit at a time, never listing more than one file per prefix.
+func WithResumePointCheck(options ResumePointCheckOptions) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.resumePointCheck = &options
+	}
+}
+
+type ResumePointStatus string
+
+const (
+	ResumePointOK           ResumePointStatus = "ok"
+	ResumePointEmptyArchive ResumePointStatus = "empty_archive"
+	ResumePointOverlap      ResumePointStatus = "overlap"
+	ResumePointGap          ResumePointStatus = "gap"
+)
+
+// ResumePointReport is the result of the resume point check
+type ResumePointReport struct {
+	Time     time.Time         `json:"time"`
+	Status   ResumePointStatus `json:"status"`
+	NodeHead uint64            `json:"node_head"`
+
+	// ArchiveHead is the highest block of the highest one block or merged blocks file found
+	ArchiveHead         uint64 `json:"archive_head"`
+	HighestOneBlockFile string `json:"highest_one_block_file,omitempty"`
+	HighestMergedFile   string `json:"highest_merged_file,omitempty"`
+
+	// Distance is the number of blocks the node produces again on overlap, or skips on gap
+	Distance uint64 `json:"distance"`
+
+	// Refused is set when the blocks are discarded until the check is overridden
+	Refused bool `json:"refused"`
+}
+
+func (r *ResumePointReport) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddString("status", string(r.Status))
+	encoder.AddUint64("node_head", r.NodeHead)
+	encoder.AddUint64("archive_head", r.ArchiveHead)
+	encoder.AddString("highest_one_block_file", r.HighestOneBlockFile)
+	encoder.AddString("highest_merged_file", r.HighestMergedFile)
+	encoder.AddUint64("distance", r.Distance)
+	encoder.AddBool("refused", r.Refused)
+	return nil
+}
+
+type resumePointState struct {
+	lock    sync.Mutex
+	checked bool // only accessed by the reading goroutine
+	report  *ResumePointReport
+	refused bool
+}
+
+// ResumePointReport returns the report of the resume point check, nil until the first block was
+// read or when the check is not enabled
+func (p *MindReaderPlugin) ResumePointReport() *ResumePointReport {
+	p.resumePoint.lock.Lock()
+	defer p.resumePoint.lock.Unlock()
+
+	return p.resumePoint.report
+}
+
+// OverrideResumePointCheck archives the blocks read again after the resume point check refused
+// to, the operator having accepted the overlap or the gap
+func (p *MindReaderPlugin) OverrideResumePointCheck() {
+	p.resumePoint.lock.Lock()
+	defer p.resumePoint.lock.Unlock()
+
+	if p.resumePoint.refused {
+		p.zlogger.Info("resume point check overridden, archiving blocks again")
+	}
+	p.resumePoint.refused = false
+}
+
+// archivingRefused runs the resume point check on the first block and reports if `block` must
+// be discarded
+func (p *MindReaderPlugin) archivingRefused(block *bstream.Block) bool {
+	if p.resumePointCheck == nil {
+		return false
+	}
+
+	if !p.resumePoint.checked {
+		p.resumePoint.checked = true
+		p.checkResumePoint(block.Num())
+	}
+
+	p.resumePoint.lock.Lock()
+	defer p.resumePoint.lock.Unlock()
+
+	if p.resumePoint.refused {
+		metrics.ResumePointDiscardedBlocks.Inc()
+		p.zlogger.Debug("discarding block, resume point check refused archiving", zap.Stringer("block", block))
+	}
+	return p.resumePoint.refused
+}
+
+func (p *MindReaderPlugin) checkResumePoint(nodeHead uint64) {
+	options := p.resumePointCheck
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = defaultResumePointCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+
+	report, err := p.resumePointReport(ctx, nodeHead)
+	if err != nil {
+		p.zlogger.Warn("unable to check resume point against archive, skipping check", zap.Uint64("node_head", nodeHead), zap.Error(err))
+		return
+	}
+
+	switch report.Status {
+	case ResumePointOK:
+		p.zlogger.Info("node resumes where archive stopped", zap.Object("report", report))
+	case ResumePointEmptyArchive:
+		p.zlogger.Info("archive is empty, not checking resume point", zap.Object("report", report))
+	default:
+		metrics.ResumePointMismatches.Inc(string(report.Status))
+		report.Refused = options.RefuseArchiving
+		p.zlogger.Error("node does not resume where archive stopped", zap.Object("report", report), zap.Uint64("max_overlap", options.MaxOverlap), zap.Uint64("max_gap", options.MaxGap))
+	}
+
+	p.resumePoint.lock.Lock()
+	p.resumePoint.report = report
+	p.resumePoint.refused = report.Refused
+	p.resumePoint.lock.Unlock()
+
+	if report.Refused && p.maintenanceRequester != nil && !p.IsTerminating() {
+		reason := fmt.Sprintf("resume point check failed: node head %d, archive head %d (%s of %d blocks)", report.NodeHead, report.ArchiveHead, report.Status, report.Distance)
+		go p.requestMaintenance(reason, nodeManager.MaintenanceSourceResumePointCheck)
+	}
+}
+
+func (p *MindReaderPlugin) resumePointReport(ctx context.Context, nodeHead uint64) (*ResumePointReport, error) {
+	report := &ResumePointReport{Time: time.Now(), NodeHead: nodeHead}
+
+	found := false
+	oneBlockFile, err := highestBlockFile(ctx, p.oneBlockFileUploader.destinationStore)
+	if err != nil {
+		return nil, fmt.Errorf("listing archive store: %w", err)
+	}
+	if oneBlockFile != "" {
+		num, err := blockFileNum(oneBlockFile)
+		if err != nil {
+			return nil, err
+		}
+		report.HighestOneBlockFile, report.ArchiveHead, found = oneBlockFile, num, true
+	}
+
+	mergedFile, err := highestBlockFile(ctx, p.mergedBlocksFileUploader.destinationStore)
+	if err != nil {
+		return nil, fmt.Errorf("listing merged blocks store: %w", err)
+	}
+	if mergedFile != "" {
+		base, err := blockFileNum(mergedFile)
+		if err != nil {
+			return nil, err
+		}
+		report.HighestMergedFile = mergedFile
+		if last := base + p.archiver.bundleSize - 1; !found || last > report.ArchiveHead {
+			report.ArchiveHead = last
+		}
+		found = true
+	}
+
+	options := p.resumePointCheck
+	switch {
+	case !found:
+		report.Status = ResumePointEmptyArchive
+	case nodeHead <= report.ArchiveHead:
+		report.Distance = report.ArchiveHead - nodeHead + 1
+		report.Status = ResumePointOK
+		if report.Distance > options.MaxOverlap {
+			report.Status = ResumePointOverlap
+		}
+	default:
+		report.Distance = nodeHead - report.ArchiveHead - 1
+		report.Status = ResumePointOK
+		if report.Distance > options.MaxGap {
+			report.Status = ResumePointGap
+		}
+	}
+	return report, nil
+}
+
+// highestBlockFile returns the name of the file of the highest block of `store`, empty when it
+// has none. The block number is looked for one digit at a time, from the highest, each prefix
+// listing at most one file, so no more than a hundred listings are done whatever the store size
+// (two hundred when files are partitioned, see `WithOneBlockFilePartitioning`).
+func highestBlockFile(ctx context.Context, store dstore.Store) (string, error) {
+	highest, err := highestBlockFileUnder(ctx, store, "")
+	if err != nil || len(highest) < blockFileNumDigits {
+		return highest, err
+	}
+
+	// The highest prefix may be a partition, holding higher blocks than its own number
+	partition := highest[:blockFileNumDigits] + "/"
+	partitioned, err := store.ListFiles(ctx, partition, 1)
+	if err != nil {
+		return "", err
+	}
+	if len(partitioned) == 0 {
+		return highest, nil
+	}
+
+	inPartition, err := highestBlockFileUnder(ctx, store, partition)
+	if err != nil || inPartition == "" {
+		return highest, err
+	}
+	return inPartition, nil
+}
+
+func highestBlockFileUnder(ctx context.Context, store dstore.Store, prefix string) (string, error) {
+	var highest string
+	for position := 0; position < blockFileNumDigits; position++ {
+		found := false
+		for digit := 9; digit >= 0; digit-- {
+			files, err := store.ListFiles(ctx, prefix+strconv.Itoa(digit), 1)
+			if err != nil {
+				return "", err
+			}
+			if len(files) > 0 {
+				prefix += strconv.Itoa(digit)
+				highest = files[0]
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return highest, nil
+}
+
+// blockFileNum returns the block number starting the name of a block file, partitioned or not
+func blockFileNum(filename string) (uint64, error) {
+	filename = path.Base(filename)
+	if len(filename) < blockFileNumDigits {
+		return 0, fmt.Errorf("invalid block file name %q", filename)
+	}
+	num, err := strconv.ParseUint(filename[:blockFileNumDigits], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block file name %q: %w", filename, err)
+	}
+	return num, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newResumePointTestStore(t *testing.T, filenames ...string) dstore.Store {
+	t.Helper()
+
+	store, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	for _, filename := range filenames {
+		require.NoError(t, store.WriteObject(context.Background(), filename, bytes.NewReader(nil)))
+	}
+	return store
+}
+
+func TestHighestBlockFile(t *testing.T) {
+	store := newResumePointTestStore(t,
+		"0000000098-20210101T000000.0-00000098a-00000097a-suffix",
+		"0000000199-20210101T000000.0-00000199a-00000198a-suffix",
+		"0000001000-20210101T000000.0-00001000a-00000999a-suffix",
+		"0000000980-20210101T000000.0-00000980a-00000979a-suffix",
+	)
+
+	highest, err := highestBlockFile(context.Background(), store)
+	require.NoError(t, err)
+	assert.Equal(t, "0000001000-20210101T000000.0-00001000a-00000999a-suffix", highest)
+
+	highest, err = highestBlockFile(context.Background(), newResumePointTestStore(t))
+	require.NoError(t, err)
+	assert.Equal(t, "", highest)
+}
+
+func newResumePointTestPlugin(t *testing.T, options ResumePointCheckOptions, oneBlockStore, mergedStore dstore.Store) *MindReaderPlugin {
+	t.Helper()
+
+	p, err := newMindReaderPlugin(nil, 0, 0, 10, nil, nil, zap.NewNop())
+	require.NoError(t, err)
+	WithResumePointCheck(options)(p)
+	p.archiver = &Archiver{bundleSize: 100}
+	p.oneBlockFileUploader = &FileUploader{destinationStore: oneBlockStore}
+	p.mergedBlocksFileUploader = &FileUploader{destinationStore: mergedStore}
+	return p
+}
+
+func TestMindReaderPlugin_ResumePointReport(t *testing.T) {
+	oneBlockStore := newResumePointTestStore(t, "0000000350-20210101T000000.0-00000350a-00000349a-suffix")
+	mergedStore := newResumePointTestStore(t, "0000000100", "0000000200")
+
+	tests := []struct {
+		name           string
+		nodeHead       uint64
+		oneBlockStore  dstore.Store
+		expectStatus   ResumePointStatus
+		expectHead     uint64
+		expectDistance uint64
+	}{
+		{"follows one block files", 351, oneBlockStore, ResumePointOK, 350, 0},
+		{"follows merged files", 300, nil, ResumePointOK, 299, 0},
+		{"overlap within tolerance", 295, nil, ResumePointOK, 299, 5},
+		{"overlap", 250, nil, ResumePointOverlap, 299, 50},
+		{"gap within tolerance", 310, nil, ResumePointOK, 299, 10},
+		{"gap", 311, nil, ResumePointGap, 299, 11},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oneBlocks := test.oneBlockStore
+			if oneBlocks == nil {
+				oneBlocks = newResumePointTestStore(t)
+			}
+			p := newResumePointTestPlugin(t, ResumePointCheckOptions{MaxOverlap: 5, MaxGap: 10}, oneBlocks, mergedStore)
+
+			report, err := p.resumePointReport(context.Background(), test.nodeHead)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectStatus, report.Status)
+			assert.Equal(t, test.expectHead, report.ArchiveHead)
+			assert.Equal(t, test.expectDistance, report.Distance)
+		})
+	}
+
+	p := newResumePointTestPlugin(t, ResumePointCheckOptions{}, newResumePointTestStore(t), newResumePointTestStore(t))
+	report, err := p.resumePointReport(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, ResumePointEmptyArchive, report.Status)
+}
+
+func TestMindReaderPlugin_ResumePointRefusesArchiving(t *testing.T) {
+	p := newResumePointTestPlugin(t, ResumePointCheckOptions{RefuseArchiving: true}, newResumePointTestStore(t), newResumePointTestStore(t, "0000000100"))
+
+	maintenance := make(chan string, 1)
+	WithMaintenanceRequester(func(reason, source string) error {
+		maintenance <- source
+		return nil
+	})(p)
+
+	assert.Nil(t, p.ResumePointReport())
+	assert.True(t, p.archivingRefused(&bstream.Block{Number: 150}))
+	assert.Equal(t, nodeManager.MaintenanceSourceResumePointCheck, <-maintenance)
+	require.NotNil(t, p.ResumePointReport())
+	assert.Equal(t, ResumePointOverlap, p.ResumePointReport().Status)
+	assert.True(t, p.ResumePointReport().Refused)
+	assert.True(t, p.archivingRefused(&bstream.Block{Number: 151}))
+
+	p.OverrideResumePointCheck()
+	assert.False(t, p.archivingRefused(&bstream.Block{Number: 152}))
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// WithShutdownDrainTimeout bounds how long the read flow keeps archiving the blocks left once
+// the plugin is terminating, so an archiver stuck on its store (e.g. a network partition) does
+// not hang the shutdown. The timeout starts when termination begins. Once elapsed, the blocks
+// not archived are dropped and reported in a `BlocksDroppedError`, logged and returned by
+// `ShutdownDrainError` and `Err`. The store still running is then canceled and waited for, at
+// most another timeout, so the shutdown can take up to twice `timeout`. Zero, the default,
+// waits for the archiver forever.
+func WithShutdownDrainTimeout(timeout time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.shutdownDrainTimeout = timeout
+	}
+}
+
+// ShutdownDrainError returns the `BlocksDroppedError` of the blocks dropped on shutdown, nil when
+// the read flow drained within the shutdown drain timeout, see `OnBlocksDropped`.
+func (p *MindReaderPlugin) ShutdownDrainError() error {
+	return p.shutdownDrainErr.Load()
+}
+
+// Err returns the error the plugin was shut down with. Once blocks were dropped on shutdown, it
+// is the `BlocksDroppedError` instead, mentioning the error it was shut down with if any, so the
+// operator knows blocks were lost.
+func (p *MindReaderPlugin) Err() error {
+	err := p.Shutter.Err()
+	dropped := p.ShutdownDrainError()
+	if dropped == nil {
+		return err
+	}
+	if err == nil {
+		return dropped
+	}
+	return fmt.Errorf("%w, after shutting down on error: %s", dropped, err)
+}
+
+// OnBlocksDropped calls `f` with the `BlocksDroppedError` of the blocks dropped on shutdown, once
+// the shutdown drain timeout elapsed, see `WithShutdownDrainTimeout`. It is how the operator
+// learns blocks were lost (see `Operator.RegisterBlocksDroppedNotifier`), the plugin already
+// terminating with another error by then.
+func (p *MindReaderPlugin) OnBlocksDropped(f func(err error)) {
+	p.blocksDroppedLock.Lock()
+	defer p.blocksDroppedLock.Unlock()
+
+	p.onBlocksDropped = append(p.onBlocksDropped, f)
+}
+
+func (p *MindReaderPlugin) notifyBlocksDropped(err error) {
+	p.blocksDroppedLock.Lock()
+	callbacks := p.onBlocksDropped
+	p.blocksDroppedLock.Unlock()
+
+	for _, f := range callbacks {
+		f(err)
+	}
+}
+
+// drainExpired returns a channel closed once the shutdown drain timeout elapsed after
+// termination began, nil, never ready, without a timeout
+func (p *MindReaderPlugin) drainExpired(flowDone <-chan interface{}) <-chan struct{} {
+	if p.shutdownDrainTimeout <= 0 {
+		return nil
+	}
+
+	expired := make(chan struct{})
+	go func() {
+		select {
+		case <-p.Terminating():
+		case <-flowDone:
+			return
+		}
+
+		timer := time.NewTimer(p.shutdownDrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			close(expired)
+		case <-flowDone:
+		}
+	}()
+	return expired
+}
+
+// storeBlockBeforeDrainExpired stores `block` in the archiver, giving up when `expired` is closed
+// first. The store is then canceled and waited for, at most another shutdown drain timeout, so it
+// does not run concurrently with the shutdown of the archiver.
+func (p *MindReaderPlugin) storeBlockBeforeDrainExpired(ctx context.Context, block *bstream.Block, expired <-chan struct{}) (stored bool, err error) {
+	if expired == nil {
+		return true, p.archiver.StoreBlock(ctx, block)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.archiver.StoreBlock(ctx, block)
+	}()
+
+	select {
+	case err := <-done:
+		return true, err
+	case <-expired:
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(p.shutdownDrainTimeout):
+		p.zlogger.Warn("archiver still storing block once canceled, shutting it down anyway", zap.Stringer("block", block))
+	}
+	return false, nil
+}
+
+// dropUndrainedBlocks counts `stuck`, when not nil, and the blocks buffered in `blocks` as dropped.
+// Blocks keep being read from `blocks` in the background so the read loop never blocks sending
+// them.
+func (p *MindReaderPlugin) dropUndrainedBlocks(blocks <-chan *bstream.Block, stuck *bstream.Block) *BlocksDroppedError {
+	dropped := &BlocksDroppedError{Timeout: p.shutdownDrainTimeout}
+	drop := func(block *bstream.Block) {
+		if dropped.Count == 0 {
+			dropped.FirstBlockNum = block.Number
+		}
+		dropped.Count++
+		dropped.LastBlockNum = block.Number
+	}
+
+	if stuck != nil {
+		drop(stuck)
+	}
+
+buffered:
+	for {
+		select {
+		case block, ok := <-blocks:
+			if !ok {
+				break buffered
+			}
+			drop(block)
+		default:
+			go func() {
+				for block := range blocks {
+					metrics.ShutdownDroppedBlocks.Inc()
+					p.zlogger.Warn("dropping block read after shutdown drain timeout", zap.Stringer("block", block))
+				}
+			}()
+			break buffered
+		}
+	}
+
+	metrics.ShutdownDroppedBlocks.AddUint64(dropped.Count)
+	return dropped
+}
+
+// abortReadFlow ends the read flow once the shutdown drain timeout elapsed, `stuck` being the
+// block the archiver is still storing, if any
+func (p *MindReaderPlugin) abortReadFlow(blocks <-chan *bstream.Block, stuck *bstream.Block) {
+	if p.bufferedBytes != nil {
+		// Blocks are no longer stored, reading the node must not wait on them
+		p.bufferedBytes.close()
+	}
+	dropped := p.dropUndrainedBlocks(blocks, stuck)
+	if dropped.Count > 0 {
+		p.shutdownDrainErr.Store(dropped)
+		p.zlogger.Error("read flow not drained within shutdown drain timeout, dropping blocks not archived",
+			zap.Duration("shutdown_drain_timeout", p.shutdownDrainTimeout),
+			zap.Uint64("dropped_blocks", dropped.Count),
+			zap.Uint64("first_dropped_block_num", dropped.FirstBlockNum),
+			zap.Uint64("last_dropped_block_num", dropped.LastBlockNum),
+		)
+	} else {
+		p.zlogger.Warn("blocks channel not closed within shutdown drain timeout, no block dropped", zap.Duration("shutdown_drain_timeout", p.shutdownDrainTimeout))
+	}
+
+	// The archiver may be stuck, only the local state is flushed
+	p.flushContinuityChecker()
+	if p.dedup != nil {
+		p.dedup.close()
+	}
+	p.archiver.Shutdown(p.ShutdownDrainError())
+	if dropped.Count > 0 {
+		p.notifyBlocksDropped(dropped)
+	}
+}
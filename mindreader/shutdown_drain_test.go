@@ -0,0 +1,110 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stopWithin(t *testing.T, p *MindReaderPlugin, timeout time.Duration) {
+	t.Helper()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Fatal("plugin not stopped")
+	}
+}
+
+func TestMindReaderPlugin_ShutdownDrainTimeoutDropsBlocks(t *testing.T) {
+	p, _ := newReplayTestPlugin(t, 0, 0)
+	p.channelCapacity = 10
+	WithShutdownDrainTimeout(100 * time.Millisecond)(p)
+
+	storing := make(chan uint64, 10)
+	release := make(chan struct{})
+	defer close(release)
+	io := mindreadertest.NewRecordingArchiverIO()
+	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
+		storing <- block.Number
+		<-release // object store hanging on a network partition
+		return nil
+	}
+	p.archiver = newArchiverWithIO(t, io, 0)
+	notified := make(chan error, 1)
+	p.OnBlocksDropped(func(err error) { notified <- err })
+
+	p.Launch()
+	for _, line := range []string{`DMLOG {"id":"00000001a"}`, `DMLOG {"id":"00000002a"}`, `DMLOG {"id":"00000003a"}`, `DMLOG {"id":"00000004a"}`} {
+		p.LogLine(line)
+	}
+	assert.Equal(t, uint64(1), <-storing)
+
+	start := time.Now()
+	stopWithin(t, p, 2*time.Second)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond), "timeout starts on termination")
+
+	var dropped *BlocksDroppedError
+	require.True(t, errors.As(p.ShutdownDrainError(), &dropped), "got %v", p.ShutdownDrainError())
+	assert.Equal(t, &BlocksDroppedError{Timeout: 100 * time.Millisecond, Count: 4, FirstBlockNum: 1, LastBlockNum: 4}, dropped)
+	assert.Len(t, storing, 0, "no block stored after the one stuck")
+	assert.Equal(t, dropped, p.Err(), "plugin error reports the blocks lost")
+	select {
+	case err := <-notified:
+		assert.Equal(t, dropped, err)
+	default:
+		t.Fatal("blocks dropped not notified")
+	}
+}
+
+func TestMindReaderPlugin_ShutdownDrainTimeoutNotBeforeTermination(t *testing.T) {
+	p, headBlocks := newReplayTestPlugin(t, 0, 0)
+	p.channelCapacity = 10
+	WithShutdownDrainTimeout(50 * time.Millisecond)(p)
+
+	io := mindreadertest.NewRecordingArchiverIO()
+	io.StoreOneBlockFileFunc = func(ctx context.Context, fileName string, block *bstream.Block) error {
+		if block.Number == 1 {
+			time.Sleep(200 * time.Millisecond) // slow store while running
+		}
+		return nil
+	}
+	p.archiver = newArchiverWithIO(t, io, 0)
+
+	p.Launch()
+	p.LogLine(`DMLOG {"id":"00000001a"}`)
+	p.LogLine(`DMLOG {"id":"00000002a"}`)
+	require.Eventually(t, func() bool { return len(io.ArchivedBlockNums()) == 2 }, time.Second, 10*time.Millisecond)
+
+	p.OnBlocksDropped(func(err error) { t.Errorf("unexpected blocks dropped: %s", err) })
+	stopWithin(t, p, time.Second)
+	assert.NoError(t, p.ShutdownDrainError())
+	assert.NoError(t, p.Err())
+	assert.Equal(t, []uint64{1, 2}, headBlocks())
+}
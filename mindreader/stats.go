@@ -0,0 +1,174 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/atomic"
+)
+
+// statusPendingUploadsTimeout bounds the walk of the working directory counting the files waiting
+// to be uploaded, see `Status`
+const statusPendingUploadsTimeout = 5 * time.Second
+
+// MindReaderStats helps sizing the blocks channel (see `channelCapacity`): a high water mark
+// reaching the capacity, or a growing send wait, means the archiving side cannot keep up and
+// the node is slowed down.
+type MindReaderStats struct {
+	BlocksRead           uint64        // blocks read from the console reader, including the ones discarded by the start gate or filter
+	BlocksArchived       uint64        // blocks successfully stored by the archiver
+	ChannelCapacity      int           // capacity of the blocks channel
+	ChannelHighWaterMark int           // highest number of blocks seen waiting in the blocks channel
+	BlockSendWait        time.Duration // cumulative time spent blocked sending blocks to a full channel
+	LastBlockNum         uint64        // last block sent to the blocks channel
+	LastBlockTime        time.Time     // time of the last block sent to the blocks channel, zero if none
+}
+
+type readFlowStats struct {
+	blocksRead     atomic.Uint64
+	blocksArchived atomic.Uint64
+	highWaterMark  atomic.Int64
+	sendWait       atomic.Duration
+	lastBlockNum   atomic.Uint64
+	lastBlockTime  atomic.Int64 // unix nanoseconds, 0 if none
+	lastReadTime   atomic.Int64 // unix nanoseconds of the last block read from the console reader, 0 if none
+
+	lastArchivedBlockNum  atomic.Uint64
+	lastArchivedBlockID   atomic.String
+	lastArchivedBlockTime atomic.Int64 // unix nanoseconds, 0 if none or unknown
+
+	blocksChannel atomic.Value // the `chan *bstream.Block` of the read flow, once launched
+}
+
+func (s *readFlowStats) recordArchived(block *bstream.Block) {
+	s.blocksArchived.Inc()
+	s.lastArchivedBlockNum.Store(block.Number)
+	s.lastArchivedBlockID.Store(block.Id)
+	if blockTime := block.Time(); !blockTime.IsZero() {
+		s.lastArchivedBlockTime.Store(blockTime.UnixNano())
+	} else {
+		s.lastArchivedBlockTime.Store(0)
+	}
+}
+
+func (s *readFlowStats) bufferedBlocks() int {
+	if blocks, ok := s.blocksChannel.Load().(chan *bstream.Block); ok {
+		return len(blocks)
+	}
+	return 0
+}
+
+func unixNanoTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Stats returns statistics about the blocks read and archived, it's safe to call at any time
+func (p *MindReaderPlugin) Stats() MindReaderStats {
+	stats := MindReaderStats{
+		BlocksRead:           p.stats.blocksRead.Load(),
+		BlocksArchived:       p.stats.blocksArchived.Load(),
+		ChannelCapacity:      p.channelCapacity,
+		ChannelHighWaterMark: int(p.stats.highWaterMark.Load()),
+		BlockSendWait:        p.stats.sendWait.Load(),
+		LastBlockNum:         p.stats.lastBlockNum.Load(),
+	}
+
+	if nanos := p.stats.lastBlockTime.Load(); nanos != 0 {
+		stats.LastBlockTime = time.Unix(0, nanos)
+	}
+	return stats
+}
+
+// Status returns a snapshot of the state of the plugin, see `nodeManager.MindreaderStatus`, it's
+// safe to call at any time. Counting the files waiting to be uploaded walks the working directory.
+func (p *MindReaderPlugin) Status() nodeManager.MindreaderStatus {
+	status := nodeManager.MindreaderStatus{
+		LastStoredBlockNum:      p.stats.lastArchivedBlockNum.Load(),
+		LastStoredBlockID:       p.stats.lastArchivedBlockID.Load(),
+		LastStoredBlockTime:     unixNanoTime(p.stats.lastArchivedBlockTime.Load()),
+		BufferedBlocks:          p.stats.bufferedBlocks(),
+		ChannelCapacity:         p.channelCapacity,
+		ContinuityCheckerActive: p.continuityChecker != nil && !p.continuityFailed.Load(),
+		ContinuityHighWatermark: p.HighestContinuousBlockNum(),
+		LastBlockReadTime:       unixNanoTime(p.stats.lastReadTime.Load()),
+		DroppedLines:            p.droppedLines(),
+	}
+
+	if p.bufferedBytes != nil {
+		status.BufferedBytes = p.bufferedBytes.buffered()
+		status.MaxBufferedBytes = p.bufferedBytes.max
+	}
+
+	if p.oneBlockFileUploader != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), statusPendingUploadsTimeout)
+		defer cancel()
+		status.PendingUploads = p.oneBlockFileUploader.pendingFiles(ctx)
+		status.QuarantinedUploads += p.oneBlockFileUploader.quarantinedFiles()
+	}
+	if p.mergedBlocksFileUploader != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), statusPendingUploadsTimeout)
+		defer cancel()
+		status.PendingMergedUploads = p.mergedBlocksFileUploader.pendingFiles(ctx)
+		status.QuarantinedUploads += p.mergedBlocksFileUploader.quarantinedFiles()
+	}
+
+	if !status.LastBlockReadTime.IsZero() {
+		status.SinceLastBlockRead = p.currentTime().Sub(status.LastBlockReadTime)
+	}
+	return status
+}
+
+// WithMetrics records the read flow metrics of the plugin (blocks channel depth, archiver store
+// and file upload latencies, blocks dropped by the start gate and transformer errors) in `m`,
+// see `metrics.NewMindreaderMetrics`. They are not recorded by default.
+func WithMetrics(m *metrics.MindreaderMetrics) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.metrics = m
+	}
+}
+
+// sendBlock sends the block to the channel, measuring the time spent waiting when the
+// channel is full and the channel high water mark
+func (p *MindReaderPlugin) sendBlock(blocks chan<- *bstream.Block, block *bstream.Block) {
+	select {
+	case blocks <- block:
+	default:
+		start := time.Now()
+		blocks <- block
+		wait := time.Since(start)
+
+		p.stats.sendWait.Add(wait)
+		metrics.BlocksChannelSendWait.AddFloat64(wait.Seconds())
+	}
+
+	p.metrics.SetBlocksChannelDepth(len(blocks))
+	if length := int64(len(blocks)); length > p.stats.highWaterMark.Load() {
+		p.stats.highWaterMark.Store(length)
+		metrics.BlocksChannelHighWaterMark.SetUint64(uint64(length))
+	}
+
+	p.stats.lastBlockNum.Store(block.Number)
+	if blockTime := block.Time(); !blockTime.IsZero() {
+		p.stats.lastBlockTime.Store(blockTime.UnixNano())
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/dstorefault"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMindReaderPlugin_Stats(t *testing.T) {
+	blocks := make(chan *bstream.Block, 2)
+	p := &MindReaderPlugin{channelCapacity: 2}
+
+	blockTime := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	p.sendBlock(blocks, &bstream.Block{Number: 1, Timestamp: blockTime})
+	p.sendBlock(blocks, &bstream.Block{Number: 2, Timestamp: blockTime.Add(time.Second)})
+
+	sent := make(chan struct{})
+	go func() {
+		p.sendBlock(blocks, &bstream.Block{Number: 3})
+		close(sent)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	<-blocks
+	<-sent
+
+	stats := p.Stats()
+	assert.Equal(t, 2, stats.ChannelCapacity)
+	assert.Equal(t, 2, stats.ChannelHighWaterMark)
+	assert.Greater(t, int64(stats.BlockSendWait), int64(0))
+	assert.Equal(t, uint64(3), stats.LastBlockNum)
+	assert.Equal(t, blockTime.Add(time.Second), stats.LastBlockTime.UTC(), "block without time does not reset last block time")
+}
+
+func TestMindReaderPlugin_StatsReadFlow(t *testing.T) {
+	lines := make(chan string, 2)
+	blocks := make(chan *bstream.Block, 2)
+
+	p := &MindReaderPlugin{
+		lines:         lines,
+		consoleReader: newTestConsoleReader(lines),
+		startGate:     NewBlockNumberGate(2),
+		zlogger:       testLogger,
+	}
+	p.lines <- `DMLOG {"id":"00000001a"}`
+	p.lines <- `DMLOG {"id":"00000002a"}`
+
+	require.NoError(t, p.readOneMessage(blocks))
+	require.NoError(t, p.readOneMessage(blocks))
+
+	stats := p.Stats()
+	assert.Equal(t, uint64(2), stats.BlocksRead)
+	assert.Equal(t, uint64(2), stats.LastBlockNum)
+	assert.Equal(t, 1, stats.ChannelHighWaterMark)
+	assert.True(t, stats.LastBlockTime.IsZero())
+}
+
+func TestMindReaderPlugin_Status(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	localArchive, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	archiveStore := dstorefault.Wrap(localArchive, dstorefault.FaultPolicy{Faults: []dstorefault.Fault{
+		{Operations: []dstorefault.Operation{dstorefault.PushLocalFile, dstorefault.WriteObject}, Err: dstorefault.ErrForbidden},
+	}})
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	checker, err := NewContinuityChecker(filepath.Join(t.TempDir(), "continuity.json"), testLogger)
+	require.NoError(t, err)
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(archiveStore, mergeArchiveStore, "never", t.TempDir(), consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer,
+		WithContinuityChecker(checker),
+		WithUploadRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Hour, MaxBackoff: time.Hour}),
+	)
+	require.NoError(t, err)
+
+	status := p.Status()
+	assert.Zero(t, status.LastStoredBlockNum)
+	assert.True(t, status.LastBlockReadTime.IsZero())
+	assert.Zero(t, status.SinceLastBlockRead)
+	assert.True(t, status.ContinuityCheckerActive)
+
+	p.Launch()
+	defer p.Stop()
+
+	generator := mindreadertest.NewBlockGenerator("status", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	blocks := generator.Blocks(100, 5)
+	for _, line := range mindreadertest.FormatLines(blocks) {
+		p.LogLine(line)
+	}
+
+	require.Eventually(t, func() bool { return p.Status().LastStoredBlockNum == 104 }, 5*time.Second, 5*time.Millisecond)
+	require.Eventually(t, func() bool { return p.Status().PendingUploads == 5 }, 5*time.Second, 5*time.Millisecond, "uploads to the archive store fail")
+
+	status = p.Status()
+	assert.Equal(t, blocks[4].Id, status.LastStoredBlockID)
+	assert.Equal(t, blocks[4].Time(), status.LastStoredBlockTime.UTC())
+	assert.Equal(t, 0, status.BufferedBlocks)
+	assert.Equal(t, 10, status.ChannelCapacity)
+	assert.True(t, status.ContinuityCheckerActive)
+	assert.Equal(t, uint64(104), status.ContinuityHighWatermark)
+	assert.False(t, status.LastBlockReadTime.IsZero())
+	assert.GreaterOrEqual(t, int64(status.SinceLastBlockRead), int64(0))
+}
+
+func TestMindReaderPlugin_StatusBufferedBlocks(t *testing.T) {
+	blocks := make(chan *bstream.Block, 3)
+	p := &MindReaderPlugin{channelCapacity: 3}
+	p.stats.blocksChannel.Store(blocks)
+
+	p.sendBlock(blocks, &bstream.Block{Number: 1})
+	p.sendBlock(blocks, &bstream.Block{Number: 2})
+
+	status := p.Status()
+	assert.Equal(t, 2, status.BufferedBlocks)
+	assert.Equal(t, 3, status.ChannelCapacity)
+	assert.False(t, status.ContinuityCheckerActive, "no continuity checker")
+	assert.Zero(t, status.PendingUploads)
+}
+
+func TestMindReaderPlugin_SlowProcessingWarningRateLimited(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	p := &MindReaderPlugin{zlogger: zap.New(core)}
+	WithSlowProcessingWarningThreshold(100 * time.Millisecond)(p)
+
+	p.warnOnSlowProcessing(&bstream.Block{Number: 1}, 10*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, 0, logs.Len(), "under threshold")
+
+	p.warnOnSlowProcessing(&bstream.Block{Number: 2}, 10*time.Millisecond, 200*time.Millisecond)
+	p.warnOnSlowProcessing(&bstream.Block{Number: 3}, 200*time.Millisecond, 10*time.Millisecond)
+	require.Equal(t, 1, logs.Len(), "rate limited")
+	assert.EqualValues(t, 2, logs.All()[0].ContextMap()["block_num"])
+
+	p.lastSlowProcessingWarning = time.Now().Add(-slowProcessingWarningInterval)
+	p.warnOnSlowProcessing(&bstream.Block{Number: 4}, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, 2, logs.Len())
+}
+
+func TestMindReaderPlugin_Metrics(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	// run feeds blocks 100 to 150 to a plugin starting at block 105, recording its metrics for
+	// `service`, and returns the error it shut down with
+	run := func(t *testing.T, service string, options ...MindReaderPluginOption) error {
+		t.Helper()
+
+		oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+		require.NoError(t, err)
+		mergedBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+		require.NoError(t, err)
+
+		consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+			return mindreadertest.NewConsoleReader(ctx.Lines), nil
+		}
+		options = append(options, WithMetrics(metrics.NewMindreaderMetrics(service)))
+		p, err := NewMindReaderPluginWithStores(oneBlocks, mergedBlocks, "never", t.TempDir(), consoleReaderFactory, 105, 150, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer, options...)
+		require.NoError(t, err)
+
+		p.Launch()
+		generator := mindreadertest.NewBlockGenerator("plugin", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+		for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 51)) {
+			p.LogLine(line)
+		}
+
+		select {
+		case <-p.Terminating():
+		case <-time.After(5 * time.Second):
+			t.Fatal("plugin not shut down")
+		}
+		p.Stop()
+		return p.Err()
+	}
+
+	// Metrics are global, only their change during a run is checked
+	type serviceMetrics struct {
+		gateDropped, transformerErrors float64
+		stores, oneBlockUploads        uint64
+	}
+	snapshot := func(service string) serviceMetrics {
+		return serviceMetrics{
+			gateDropped:       testutil.ToFloat64(metrics.GateDroppedBlocks.Native().WithLabelValues(service)),
+			transformerErrors: testutil.ToFloat64(metrics.TransformerErrors.Native().WithLabelValues(service)),
+			stores:            histogramSnapshot(t, metrics.StoreBlockDuration.Native().WithLabelValues(service).(prometheus.Histogram)).GetSampleCount(),
+			oneBlockUploads:   histogramSnapshot(t, metrics.FileUploadDuration.Native().WithLabelValues(service, metrics.FileTypeOneBlock).(prometheus.Histogram)).GetSampleCount(),
+		}
+	}
+	delta := func(before, after serviceMetrics) serviceMetrics {
+		return serviceMetrics{
+			gateDropped:       after.gateDropped - before.gateDropped,
+			transformerErrors: after.transformerErrors - before.transformerErrors,
+			stores:            after.stores - before.stores,
+			oneBlockUploads:   after.oneBlockUploads - before.oneBlockUploads,
+		}
+	}
+
+	before := snapshot("metrics-test")
+	err := run(t, "metrics-test")
+	require.True(t, errors.Is(err, ErrStopBlockReached), "got %v", err)
+
+	assert.Equal(t, serviceMetrics{gateDropped: 5, stores: 46, oneBlockUploads: 46}, delta(before, snapshot("metrics-test")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.BlocksChannelDepth.Native().WithLabelValues("metrics-test")), "channel drained")
+
+	t.Run("transformer error", func(t *testing.T) {
+		failing := WithBlockTransformers(func(block *bstream.Block) (*bstream.Block, error) {
+			return nil, errors.New("transform failed")
+		})
+		before := snapshot("metrics-test-transformer")
+		err := run(t, "metrics-test-transformer", failing)
+
+		var transformErr *TransformError
+		require.True(t, errors.As(err, &transformErr), "got %v", err)
+		changed := delta(before, snapshot("metrics-test-transformer"))
+		assert.Equal(t, 1.0, changed.transformerErrors)
+		assert.Equal(t, uint64(0), changed.stores, "other service not affected")
+	})
+
+	var nilMetrics *metrics.MindreaderMetrics
+	assert.NotPanics(t, func() {
+		nilMetrics.SetBlocksChannelDepth(1)
+		nilMetrics.ObserveStoreBlock(time.Second)
+		nilMetrics.ObserveFileUpload(metrics.FileTypeMerged, time.Second)
+		nilMetrics.IncGateDroppedBlocks()
+		nilMetrics.IncTransformerErrors()
+	}, "nil metrics record nothing")
+}
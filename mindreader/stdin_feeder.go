@@ -0,0 +1,160 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// defaultFeederMaxLineSize is large enough for deep mind lines of very big blocks
+const defaultFeederMaxLineSize = 100 * 1024 * 1024
+
+type StdinFeederOption func(f *StdinFeeder)
+
+// WithFeederReconnect is called when reading fails or reaches EOF, the feeder continues with the
+// returned reader (for example a named pipe re-opened after being recreated). Returning an error
+// ends the feeder, the plugin being shut down with that error.
+func WithFeederReconnect(reconnect func(lastErr error) (io.Reader, error)) StdinFeederOption {
+	return func(f *StdinFeeder) {
+		f.reconnect = reconnect
+	}
+}
+
+// WithFeederMaxLineSize sets the longest line that can be read, 100MiB by default
+func WithFeederMaxLineSize(size int) StdinFeederOption {
+	return func(f *StdinFeeder) {
+		f.maxLineSize = size
+	}
+}
+
+// StdinFeeder feeds a LogPlugin (usually the MindReaderPlugin) with the lines read from an
+// arbitrary reader, it's meant for nodes not managed by the superviser whose output is piped
+// to the process. Once reading ends (EOF or error, and no reconnection), the plugin is shut down.
+type StdinFeeder struct {
+	*shutter.Shutter
+
+	reader      io.Reader
+	plugin      logplugin.LogPlugin
+	reconnect   func(lastErr error) (io.Reader, error)
+	maxLineSize int
+
+	linesRead *atomic.Uint64
+	bytesRead *atomic.Uint64
+
+	zlogger *zap.Logger
+}
+
+func NewStdinFeeder(r io.Reader, plugin logplugin.LogPlugin, zlogger *zap.Logger, options ...StdinFeederOption) *StdinFeeder {
+	f := &StdinFeeder{
+		Shutter:     shutter.New(),
+		reader:      r,
+		plugin:      plugin,
+		maxLineSize: defaultFeederMaxLineSize,
+		linesRead:   atomic.NewUint64(0),
+		bytesRead:   atomic.NewUint64(0),
+		zlogger:     zlogger,
+	}
+
+	for _, opt := range options {
+		opt(f)
+	}
+
+	return f
+}
+
+// LinesRead returns the number of lines forwarded to the plugin so far
+func (f *StdinFeeder) LinesRead() uint64 {
+	return f.linesRead.Load()
+}
+
+// BytesRead returns the number of bytes read so far, line terminators included
+func (f *StdinFeeder) BytesRead() uint64 {
+	return f.bytesRead.Load()
+}
+
+// Run reads and forwards lines until the reader ends without being reconnected or the
+// feeder is shut down, it blocks until then.
+func (f *StdinFeeder) Run() {
+	reader := f.reader
+	for {
+		err := f.feed(reader)
+		if f.IsTerminating() {
+			return
+		}
+
+		if f.reconnect == nil {
+			f.end(err)
+			return
+		}
+
+		f.zlogger.Info("log source ended, reconnecting", zap.Error(err))
+		reader, err = f.reconnect(err)
+		if err != nil {
+			f.end(fmt.Errorf("reconnecting log source: %w", err))
+			return
+		}
+	}
+}
+
+// feed returns nil when the reader reached EOF
+func (f *StdinFeeder) feed(reader io.Reader) error {
+	if closer, ok := reader.(io.Closer); ok {
+		// Closing the reader is the only way to unblock a pending read
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-f.Terminating():
+				closer.Close()
+			case <-done:
+			}
+		}()
+	}
+
+	initialBufferSize := 64 * 1024
+	if f.maxLineSize < initialBufferSize {
+		initialBufferSize = f.maxLineSize
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, initialBufferSize), f.maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		f.linesRead.Inc()
+		f.bytesRead.Add(uint64(len(line) + 1))
+
+		f.plugin.LogLine(line)
+	}
+
+	return scanner.Err()
+}
+
+func (f *StdinFeeder) end(err error) {
+	if err != nil {
+		f.zlogger.Error("reading log source failed, shutting down plugin", zap.Error(err), zap.Uint64("lines_read", f.LinesRead()))
+	} else {
+		f.zlogger.Info("log source reached EOF, shutting down plugin", zap.Uint64("lines_read", f.LinesRead()))
+	}
+
+	f.plugin.Shutdown(err)
+	f.Shutdown(err)
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type linesLogPlugin struct {
+	*shutter.Shutter
+	lines []string
+}
+
+func newLinesLogPlugin() *linesLogPlugin {
+	return &linesLogPlugin{Shutter: shutter.New()}
+}
+
+func (p *linesLogPlugin) Name() string      { return "lines" }
+func (p *linesLogPlugin) Launch()           {}
+func (p *linesLogPlugin) Stop()             {}
+func (p *linesLogPlugin) LogLine(in string) { p.lines = append(p.lines, in) }
+
+func TestStdinFeeder_LongLinesAndCounters(t *testing.T) {
+	longLine := "DMLOG " + strings.Repeat("a", 200*1024)
+	plugin := newLinesLogPlugin()
+
+	feeder := NewStdinFeeder(strings.NewReader("first\n"+longLine+"\nlast"), plugin, testLogger)
+	feeder.Run()
+
+	assert.Equal(t, []string{"first", longLine, "last"}, plugin.lines)
+	assert.EqualValues(t, 3, feeder.LinesRead())
+	assert.EqualValues(t, len("first")+len(longLine)+len("last")+3, feeder.BytesRead())
+	assert.True(t, plugin.IsTerminating())
+	assert.NoError(t, plugin.Err(), "EOF is a clean end")
+}
+
+func TestStdinFeeder_LineTooLong(t *testing.T) {
+	plugin := newLinesLogPlugin()
+
+	feeder := NewStdinFeeder(strings.NewReader("first\n"+strings.Repeat("a", 1024)+"\n"), plugin, testLogger, WithFeederMaxLineSize(512))
+	feeder.Run()
+
+	assert.Equal(t, []string{"first"}, plugin.lines)
+	assert.Error(t, plugin.Err())
+}
+
+func TestStdinFeeder_Reconnect(t *testing.T) {
+	plugin := newLinesLogPlugin()
+
+	var reconnectErrs []error
+	sources := []io.Reader{strings.NewReader("second\n"), strings.NewReader("third\n")}
+	reconnect := func(lastErr error) (io.Reader, error) {
+		reconnectErrs = append(reconnectErrs, lastErr)
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("pipe is gone")
+		}
+
+		next := sources[0]
+		sources = sources[1:]
+		return next, nil
+	}
+
+	feeder := NewStdinFeeder(strings.NewReader("first\n"), plugin, testLogger, WithFeederReconnect(reconnect))
+	feeder.Run()
+
+	assert.Equal(t, []string{"first", "second", "third"}, plugin.lines)
+	assert.Equal(t, []error{nil, nil, nil}, reconnectErrs)
+	assert.EqualError(t, plugin.Err(), "reconnecting log source: pipe is gone")
+}
+
+func TestStdinFeeder_ShutdownUnblocksRead(t *testing.T) {
+	plugin := newLinesLogPlugin()
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	feeder := NewStdinFeeder(reader, plugin, testLogger)
+	done := make(chan struct{})
+	go func() {
+		feeder.Run()
+		close(done)
+	}()
+
+	_, err := writer.Write([]byte("first\n"))
+	require.NoError(t, err)
+	feeder.Shutdown(nil)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("feeder should have stopped")
+	}
+	assert.Equal(t, []string{"first"}, plugin.lines)
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/journal"
+	"go.uber.org/zap"
+)
+
+// SetStopBlock changes, at runtime, the stop block given to `NewMindReaderPlugin`, 0 clearing
+// it. A stop block at or below the last block read stops the plugin right away, after that
+// block, like reaching it. It fails once the stop block was reached, and with a stop condition
+// set by `WithStopCondition`. It is safe for concurrent use while blocks are read.
+func (p *MindReaderPlugin) SetStopBlock(num uint64) error {
+	if p.stopCondition != nil {
+		return fmt.Errorf("stop condition set, the stop block cannot be changed")
+	}
+
+	p.stopLock.Lock()
+	defer p.stopLock.Unlock()
+
+	if p.stopReached.Load() {
+		return fmt.Errorf("stop block already reached at block %d", p.stoppedAt.Load())
+	}
+
+	previous := p.stopBlock
+	p.stopBlock = num
+	p.zlogger.Info("stop block changed", zap.Uint64("stop_block_num", num), zap.Uint64("previous_stop_block_num", previous))
+
+	if num != 0 && p.blockSent && p.lastSentBlockNum >= num && !p.IsTerminating() {
+		p.reachStop(p.lastSentBlockNum)
+	}
+	return nil
+}
+
+func (p *MindReaderPlugin) currentStopBlock() uint64 {
+	p.stopLock.Lock()
+	defer p.stopLock.Unlock()
+
+	return p.stopBlock
+}
+
+// checkStop records `block` as the last one sent to the archiver and stops the plugin when it
+// reached the stop block or stop condition
+func (p *MindReaderPlugin) checkStop(block *bstream.Block) {
+	p.stopLock.Lock()
+	defer p.stopLock.Unlock()
+
+	p.lastSentBlockNum = block.Num()
+	p.blockSent = true
+
+	if p.shouldStop(block) && !p.IsTerminating() {
+		p.reachStop(block.Num())
+	}
+}
+
+func (p *MindReaderPlugin) shouldStop(block *bstream.Block) bool {
+	if p.stopCondition != nil {
+		return p.stopCondition.ShouldStop(block)
+	}
+	return p.stopBlock != 0 && block.Num() >= p.stopBlock
+}
+
+// reachStop shuts the plugin down with `blockNum` as the stop block, the caller holds the stop lock
+func (p *MindReaderPlugin) reachStop(blockNum uint64) {
+	p.stoppedAt.Store(blockNum)
+	p.stopReached.Store(true)
+	p.zlogger.Info("shutting down because requested end block reached", zap.Uint64("block_num", blockNum))
+	p.journal.Record(journal.Event{Type: journal.EventStopBlockReached, BlockNum: blockNum})
+	go p.Shutdown(ErrStopBlockReached)
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const rangeCompleteMarkerFormat = "range-complete-%010d-%010d"
+
+// RangeCompleteMarkerName returns the name of the marker object written in the merged blocks
+// store once every file of the `[startBlock, stopBlock]` range is visible in the stores, for
+// example `range-complete-0000001000-0000002000`. Both block numbers are inclusive and zero
+// padded to 10 digits.
+func RangeCompleteMarkerName(startBlock, stopBlock uint64) string {
+	return fmt.Sprintf(rangeCompleteMarkerFormat, startBlock, stopBlock)
+}
+
+// ParseRangeCompleteMarkerName is the inverse of `RangeCompleteMarkerName`
+func ParseRangeCompleteMarkerName(name string) (startBlock, stopBlock uint64, err error) {
+	if _, err := fmt.Sscanf(name, rangeCompleteMarkerFormat, &startBlock, &stopBlock); err != nil {
+		return 0, 0, fmt.Errorf("invalid range complete marker name %q: %w", name, err)
+	}
+
+	if name != RangeCompleteMarkerName(startBlock, stopBlock) {
+		return 0, 0, fmt.Errorf("invalid range complete marker name %q", name)
+	}
+	return startBlock, stopBlock, nil
+}
+
+type StopBlockBarrierOptions struct {
+	// VisibilityTimeout bounds the time spent waiting for the files uploaded in the final flush
+	// to be visible in the destination stores, defaults to 5 minutes
+	VisibilityTimeout time.Duration
+
+	// RangeCompleteMarker writes a marker object (see `RangeCompleteMarkerName`) in the merged
+	// blocks store once every file is visible, it is always the last object written
+	RangeCompleteMarker bool
+}
+
+// WithStopBlockReachFunc calls `f` once the stop block was reached and every file produced
+// was uploaded and is visible in the destination stores. If files are still not visible
+// after the visibility timeout, `f` is not called and the error is logged.
+func WithStopBlockReachFunc(f func(), options StopBlockBarrierOptions) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if options.VisibilityTimeout == 0 {
+			options.VisibilityTimeout = 5 * time.Minute
+		}
+
+		p.stopBlockReachFunc = f
+		p.stopBlockBarrierOptions = options
+	}
+}
+
+// uploadedBundles records the names of the merged bundles uploaded by the upload loop, so the
+// stop block barrier also checks the ones of its range uploaded before the final upload
+type uploadedBundles struct {
+	lock  sync.Mutex
+	names map[string]struct{}
+}
+
+func (b *uploadedBundles) add(filename string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.names[filename] = struct{}{}
+}
+
+// covering returns, sorted, the bundles containing blocks of `[startBlock, stopBlock]`, the
+// others are forgotten
+func (b *uploadedBundles) covering(startBlock, stopBlock, bundleSize uint64) (out []string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for name := range b.names {
+		baseBlockNum, err := strconv.ParseUint(name, 10, 64)
+		if err != nil || baseBlockNum+bundleSize <= startBlock || baseBlockNum > stopBlock {
+			delete(b.names, name)
+			continue
+		}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return
+}
+
+// stopBlockBarrier makes a final upload of all files then waits for them, and for the merged
+// bundles of the range uploaded before, to be visible before writing the range complete marker
+// (if enabled) and calling the stop block reach function. One block files uploaded before are
+// not checked, they may already have been merged and deleted.
+func (p *MindReaderPlugin) stopBlockBarrier(ctx context.Context, startBlock, stopBlock uint64) error {
+	for _, uploader := range []*FileUploader{p.oneBlockFileUploader, p.mergedBlocksFileUploader} {
+		uploaded, err := uploader.uploadAllFiles(ctx)
+		if err != nil {
+			return fmt.Errorf("final upload: %w", err)
+		}
+		if uploader == p.mergedBlocksFileUploader && p.barrierBundles != nil {
+			uploaded = appendMissing(uploaded, p.barrierBundles.covering(startBlock, stopBlock, p.bundleSize))
+		}
+
+		p.zlogger.Info("waiting for uploaded files to be visible", zap.Int("file_count", len(uploaded)))
+		if err := uploader.waitForVisibility(ctx, uploaded, p.stopBlockBarrierOptions.VisibilityTimeout); err != nil {
+			return fmt.Errorf("final upload visibility: %w", err)
+		}
+	}
+
+	if p.stopBlockBarrierOptions.RangeCompleteMarker {
+		marker := RangeCompleteMarkerName(startBlock, stopBlock)
+		p.zlogger.Info("writing range complete marker", zap.String("marker", marker))
+
+		store := p.mergedBlocksFileUploader.destinationStore
+		if err := store.WriteObject(ctx, marker, bytes.NewReader(nil)); err != nil {
+			return fmt.Errorf("writing range complete marker %q: %w", marker, err)
+		}
+	}
+
+	p.zlogger.Info("all files visible, calling stop block reach function", zap.Uint64("stop_block", stopBlock))
+	p.stopBlockReachFunc()
+	return nil
+}
+
+func appendMissing(files []string, others []string) []string {
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		seen[file] = true
+	}
+	for _, other := range others {
+		if !seen[other] {
+			files = append(files, other)
+		}
+	}
+	return files
+}
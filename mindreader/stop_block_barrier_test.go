@@ -0,0 +1,160 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// delayedVisibilityStore is a destination store where pushed files only become visible
+// after `visibleAfterChecks` calls to `FileExists`
+type delayedVisibilityStore struct {
+	*dstore.MockStore
+
+	lock    sync.Mutex
+	checks  map[string]int
+	events  []string
+	visible int
+}
+
+func newDelayedVisibilityStore(visibleAfterChecks int) *delayedVisibilityStore {
+	s := &delayedVisibilityStore{MockStore: dstore.NewMockStore(nil), checks: map[string]int{}, visible: visibleAfterChecks}
+	s.PushLocalFileFunc = func(_ context.Context, _, name string) error {
+		s.addEvent("push " + name)
+		return nil
+	}
+	s.FileExistsFunc = func(_ context.Context, name string) (bool, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		s.checks[name]++
+		return s.visible >= 0 && s.checks[name] > s.visible, nil
+	}
+	s.WriteObjectFunc = func(_ context.Context, name string, _ io.Reader) error {
+		s.addEvent("write " + name)
+		return nil
+	}
+	return s
+}
+
+func (s *delayedVisibilityStore) addEvent(event string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, event)
+}
+
+func newStopBlockBarrierTestPlugin(visibleAfterChecks int, options StopBlockBarrierOptions, onStopBlockReach func()) (*MindReaderPlugin, *delayedVisibilityStore, *delayedVisibilityStore) {
+	oneBlocksLocal := dstore.NewMockStore(nil)
+	oneBlocksLocal.SetFile("0000000099-one-block", nil)
+	mergedLocal := dstore.NewMockStore(nil)
+	mergedLocal.SetFile("0000000000", nil)
+
+	oneBlocksDestination := newDelayedVisibilityStore(visibleAfterChecks)
+	mergedDestination := newDelayedVisibilityStore(visibleAfterChecks)
+
+	p := &MindReaderPlugin{
+		zlogger:                  testLogger,
+		oneBlockFileUploader:     NewFileUploader(oneBlocksLocal, oneBlocksDestination, testLogger),
+		mergedBlocksFileUploader: NewFileUploader(mergedLocal, mergedDestination, testLogger),
+		bundleSize:               DefaultBundleSize,
+	}
+	WithStopBlockReachFunc(onStopBlockReach, options)(p)
+
+	return p, oneBlocksDestination, mergedDestination
+}
+
+func TestStopBlockBarrier_WaitsForVisibility(t *testing.T) {
+	var called bool
+	var mergedDestination *delayedVisibilityStore
+	p, oneBlocksDestination, mergedDestination := newStopBlockBarrierTestPlugin(2, StopBlockBarrierOptions{RangeCompleteMarker: true}, func() {
+		called = true
+		mergedDestination.addEvent("stop block reached")
+	})
+
+	require.NoError(t, p.stopBlockBarrier(context.Background(), 0, 99))
+
+	assert.True(t, called)
+	assert.Equal(t, 3, oneBlocksDestination.checks["0000000099-one-block"], "file checked until visible")
+	assert.Equal(t, 3, mergedDestination.checks["0000000000"], "file checked until visible")
+	assert.Equal(t, []string{"push 0000000000", "write range-complete-0000000000-0000000099", "stop block reached"}, mergedDestination.events)
+}
+
+func TestStopBlockBarrier_VisibilityTimeout(t *testing.T) {
+	called := false
+	p, _, mergedDestination := newStopBlockBarrierTestPlugin(-1, StopBlockBarrierOptions{RangeCompleteMarker: true, VisibilityTimeout: 50 * time.Millisecond}, func() {
+		called = true
+	})
+
+	err := p.stopBlockBarrier(context.Background(), 0, 99)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `still not visible (first is "0000000099-one-block")`)
+	assert.False(t, called)
+	assert.Empty(t, mergedDestination.events, "nothing written to merged blocks store")
+}
+
+func TestStopBlockBarrier_ChecksBundlesOfRangeUploadedBefore(t *testing.T) {
+	called := false
+	p, oneBlocksDestination, mergedDestination := newStopBlockBarrierTestPlugin(1, StopBlockBarrierOptions{}, func() {
+		called = true
+	})
+	p.barrierBundles = &uploadedBundles{names: map[string]struct{}{}}
+	p.oneBlockFileUploader = NewFileUploader(dstore.NewMockStore(nil), oneBlocksDestination, testLogger)
+	p.mergedBlocksFileUploader = NewFileUploader(dstore.NewMockStore(nil), mergedDestination, testLogger)
+
+	// Uploaded by the upload loop before the final upload, nothing left to upload
+	for _, name := range []string{"0000000000", "0000000100", "0000000200", "0000000300"} {
+		p.barrierBundles.add(name)
+	}
+
+	require.NoError(t, p.stopBlockBarrier(context.Background(), 150, 250))
+
+	assert.True(t, called)
+	assert.Equal(t, map[string]int{"0000000100": 2, "0000000200": 2}, mergedDestination.checks, "only the bundles of the range are checked")
+	assert.Empty(t, oneBlocksDestination.checks)
+	assert.Equal(t, []string{"0000000100", "0000000200"}, p.barrierBundles.covering(0, 1000, 100), "bundles outside the range are forgotten")
+}
+
+func TestUploadedBundles_Covering(t *testing.T) {
+	bundles := &uploadedBundles{names: map[string]struct{}{}}
+	for _, name := range []string{"0000000100", "0000000000", "0000000200", "not-a-bundle"} {
+		bundles.add(name)
+	}
+
+	assert.Equal(t, []string{"0000000000", "0000000100"}, bundles.covering(99, 100, 100))
+	assert.Empty(t, bundles.covering(300, 400, 100))
+}
+
+func TestRangeCompleteMarkerName(t *testing.T) {
+	name := RangeCompleteMarkerName(1000, 1999)
+	assert.Equal(t, "range-complete-0000001000-0000001999", name)
+
+	start, stop, err := ParseRangeCompleteMarkerName(name)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), start)
+	assert.Equal(t, uint64(1999), stop)
+
+	_, _, err = ParseRangeCompleteMarkerName("range-complete-1000-1999")
+	assert.Error(t, err)
+	_, _, err = ParseRangeCompleteMarkerName("0000001000")
+	assert.Error(t, err)
+}
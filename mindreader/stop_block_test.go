@@ -0,0 +1,104 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStopBlockTestPlugin(stopBlock uint64) (*MindReaderPlugin, chan *bstream.Block) {
+	lines := make(chan string, 5)
+	for _, id := range []string{"00000001a", "00000002a", "00000003a", "00000004a", "00000005a"} {
+		lines <- `DMLOG {"id":"` + id + `"}`
+	}
+
+	p := newReadTestPlugin(lines, newTestConsoleReader(lines))
+	p.stopBlock = stopBlock
+	return p, make(chan *bstream.Block, 5)
+}
+
+func readStopBlockTestBlocks(t *testing.T, p *MindReaderPlugin, blocks chan *bstream.Block, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		require.NoError(t, p.readOneMessage(blocks))
+	}
+}
+
+func receivedBlockNums(blocks chan *bstream.Block) (out []uint64) {
+	close(blocks)
+	for block := range blocks {
+		out = append(out, block.Number)
+	}
+	return out
+}
+
+func TestMindReaderPlugin_SetStopBlock(t *testing.T) {
+	t.Run("future stop block mid-stream", func(t *testing.T) {
+		p, blocks := newStopBlockTestPlugin(0)
+
+		readStopBlockTestBlocks(t, p, blocks, 2)
+		require.NoError(t, p.SetStopBlock(4))
+		assert.False(t, p.stopReached.Load())
+
+		readStopBlockTestBlocks(t, p, blocks, 3)
+		assert.Equal(t, []uint64{1, 2, 3, 4}, receivedBlockNums(blocks))
+		assert.True(t, p.stopReached.Load())
+		assert.Equal(t, uint64(4), p.stoppedAt.Load())
+	})
+
+	t.Run("stop block already passed", func(t *testing.T) {
+		p, blocks := newStopBlockTestPlugin(0)
+
+		readStopBlockTestBlocks(t, p, blocks, 3)
+		require.NoError(t, p.SetStopBlock(2))
+		assert.True(t, p.stopReached.Load())
+		assert.Equal(t, uint64(3), p.stoppedAt.Load(), "stops after the last block read")
+
+		select {
+		case <-p.Terminated():
+			assert.Equal(t, ErrStopBlockReached, p.Err())
+		case <-time.After(time.Second):
+			t.Fatal("plugin not shut down")
+		}
+
+		readStopBlockTestBlocks(t, p, blocks, 2)
+		assert.Equal(t, []uint64{1, 2, 3}, receivedBlockNums(blocks))
+		assert.Error(t, p.SetStopBlock(10), "stop block already reached")
+	})
+
+	t.Run("cleared before reached", func(t *testing.T) {
+		p, blocks := newStopBlockTestPlugin(3)
+
+		readStopBlockTestBlocks(t, p, blocks, 2)
+		require.NoError(t, p.SetStopBlock(0))
+
+		readStopBlockTestBlocks(t, p, blocks, 3)
+		assert.Equal(t, []uint64{1, 2, 3, 4, 5}, receivedBlockNums(blocks))
+		assert.False(t, p.stopReached.Load())
+	})
+
+	t.Run("stop condition set", func(t *testing.T) {
+		p, _ := newStopBlockTestPlugin(0)
+		p.stopCondition = StopAfterBlocks(2)
+
+		assert.Error(t, p.SetStopBlock(4))
+	})
+}
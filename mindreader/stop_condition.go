@@ -0,0 +1,74 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// StopCondition decides when the mindreader stops, it's asked once for every block passing
+// the start gate, in order, until it returns true. The block for which it returns true is the
+// last one archived and becomes the stop block (see `WithStopBlockReachFunc`).
+type StopCondition interface {
+	ShouldStop(blk *bstream.Block) bool
+}
+
+// WithStopCondition replaces the `stopBlockNum` given to `NewMindReaderPlugin`
+func WithStopCondition(condition StopCondition) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.stopCondition = condition
+	}
+}
+
+// StopAtBlockNum stops at the first block whose number is greater or equal to `blockNum`,
+// it's the condition used when a `stopBlockNum` is given to `NewMindReaderPlugin`
+func StopAtBlockNum(blockNum uint64) StopCondition {
+	return stopAtBlockNum(blockNum)
+}
+
+type stopAtBlockNum uint64
+
+func (s stopAtBlockNum) ShouldStop(blk *bstream.Block) bool {
+	return blk.Number >= uint64(s)
+}
+
+// StopAfterBlocks stops once `count` blocks passed the start gate, the last of them being
+// the stop block. It's not safe for concurrent use, each plugin needs its own instance.
+func StopAfterBlocks(count uint64) StopCondition {
+	return &stopAfterBlocks{count: count}
+}
+
+type stopAfterBlocks struct {
+	count uint64
+	seen  uint64
+}
+
+func (s *stopAfterBlocks) ShouldStop(blk *bstream.Block) bool {
+	s.seen++
+	return s.seen >= s.count
+}
+
+// StopAtBlockTime stops at the first block whose timestamp is at or after `t`
+func StopAtBlockTime(t time.Time) StopCondition {
+	return stopAtBlockTime(t)
+}
+
+type stopAtBlockTime time.Time
+
+func (s stopAtBlockTime) ShouldStop(blk *bstream.Block) bool {
+	return !blk.Time().Before(time.Time(s))
+}
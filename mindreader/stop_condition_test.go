@@ -0,0 +1,89 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopConditions(t *testing.T) {
+	blockTime := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	block := func(num uint64) *bstream.Block {
+		return &bstream.Block{Number: num, Timestamp: blockTime.Add(time.Duration(num) * time.Second)}
+	}
+
+	tests := []struct {
+		name      string
+		condition StopCondition
+		expected  uint64
+	}{
+		{"absolute", StopAtBlockNum(12), 12},
+		{"relative", StopAfterBlocks(3), 12},
+		{"relative single block", StopAfterBlocks(1), 10},
+		{"block time", StopAtBlockTime(blockTime.Add(13 * time.Second)), 13},
+		{"block time between blocks", StopAtBlockTime(blockTime.Add(13500 * time.Millisecond)), 14},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for num := uint64(10); num < 20; num++ {
+				if test.condition.ShouldStop(block(num)) {
+					assert.Equal(t, test.expected, num)
+					return
+				}
+			}
+			t.Error("stop condition never fired")
+		})
+	}
+}
+
+func TestMindReaderPlugin_StopConditionDiscardsFollowingBlocks(t *testing.T) {
+	numOfLines := 5
+	lines := make(chan string, numOfLines)
+	blocks := make(chan *bstream.Block, numOfLines)
+
+	mindReader := &MindReaderPlugin{
+		Shutter:       shutter.New(),
+		lines:         lines,
+		consoleReader: newTestConsoleReader(lines),
+		startGate:     NewBlockNumberGate(2),
+		stopCondition: StopAfterBlocks(2),
+		zlogger:       testLogger,
+	}
+
+	for _, id := range []string{"00000001a", "00000002a", "00000003a", "00000004a", "00000005a"} {
+		lines <- `DMLOG {"id":"` + id + `"}`
+	}
+
+	for i := 0; i < numOfLines; i++ {
+		require.NoError(t, mindReader.readOneMessage(blocks))
+	}
+	close(blocks)
+
+	var received []uint64
+	for block := range blocks {
+		received = append(received, block.Number)
+	}
+
+	assert.Equal(t, []uint64{2, 3}, received)
+	assert.True(t, mindReader.stopReached.Load())
+	assert.Equal(t, uint64(3), mindReader.stoppedAt.Load())
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const stopMarkerFilename = "stop-block-reached.json"
+
+// StopMarker is written to the working directory once the stop block given to
+// `NewMindReaderPlugin` was reached, so a restarted plugin with the same stop block does not
+// read, and discard or archive again, the blocks of a node that went past it, see
+// `WithStopMarkerOverride`. Stop conditions set with `WithStopCondition` write no marker.
+type StopMarker struct {
+	StopBlockNum uint64    `json:"stop_block_num"`
+	ReachedAt    time.Time `json:"reached_at"`
+
+	// LastArchivedBlockNum and LastArchivedBlockID are the last block archived, or skipped as
+	// already archived (see `WithDedupWindow`), by the run that reached the stop block, zero if
+	// there was none
+	LastArchivedBlockNum uint64 `json:"last_archived_block_num"`
+	LastArchivedBlockID  string `json:"last_archived_block_id,omitempty"`
+}
+
+func (m *StopMarker) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddUint64("stop_block_num", m.StopBlockNum)
+	encoder.AddTime("reached_at", m.ReachedAt)
+	encoder.AddUint64("last_archived_block_num", m.LastArchivedBlockNum)
+	encoder.AddString("last_archived_block_id", m.LastArchivedBlockID)
+	return nil
+}
+
+// WithStopMarkerOverride launches the read flow even though the stop marker of the same stop
+// block is found in the working directory, the marker is removed.
+func WithStopMarkerOverride() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.stopMarkerOverride = true
+	}
+}
+
+// StopMarkerPath returns the path of the stop marker in `workingDirectory`
+func StopMarkerPath(workingDirectory string) string {
+	return filepath.Join(workingDirectory, stopMarkerFilename)
+}
+
+// ReadStopMarker reads the stop marker of `workingDirectory`, nil when there is none
+func ReadStopMarker(workingDirectory string) (*StopMarker, error) {
+	content, err := ioutil.ReadFile(StopMarkerPath(workingDirectory))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading stop marker: %w", err)
+	}
+
+	marker := &StopMarker{}
+	if err := json.Unmarshal(content, marker); err != nil {
+		return nil, fmt.Errorf("decoding stop marker %q: %w", StopMarkerPath(workingDirectory), err)
+	}
+	return marker, nil
+}
+
+// writeStopMarker replaces the stop marker of `workingDirectory`, through a temporary file so
+// a crash never leaves a partial marker
+func writeStopMarker(workingDirectory string, marker *StopMarker) error {
+	content, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("encoding stop marker: %w", err)
+	}
+
+	tmpFile := StopMarkerPath(workingDirectory) + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, content, 0644); err != nil {
+		return fmt.Errorf("writing stop marker: %w", err)
+	}
+	if err := os.Rename(tmpFile, StopMarkerPath(workingDirectory)); err != nil {
+		return fmt.Errorf("renaming stop marker: %w", err)
+	}
+	return nil
+}
+
+func (p *MindReaderPlugin) writesStopMarker() bool {
+	return p.currentStopBlock() != 0 && p.stopCondition == nil && p.workingDirectory != ""
+}
+
+// checkStopMarker returns the error the plugin shuts down with on launch when the stop block
+// was already reached by a previous run. A marker of another stop block is left in place.
+func (p *MindReaderPlugin) checkStopMarker() error {
+	if !p.writesStopMarker() {
+		return nil
+	}
+
+	marker, err := ReadStopMarker(p.workingDirectory)
+	if err != nil {
+		return err
+	}
+	if marker == nil {
+		return nil
+	}
+
+	if stopBlock := p.currentStopBlock(); marker.StopBlockNum != stopBlock {
+		p.zlogger.Info("ignoring stop marker of another stop block", zap.Uint64("marker_stop_block_num", marker.StopBlockNum), zap.Uint64("stop_block_num", stopBlock))
+		return nil
+	}
+
+	if p.stopMarkerOverride {
+		p.zlogger.Warn("stop block already reached by a previous run, overridden, removing stop marker", zap.Object("marker", marker))
+		if err := os.Remove(StopMarkerPath(p.workingDirectory)); err != nil {
+			return fmt.Errorf("removing stop marker: %w", err)
+		}
+		return nil
+	}
+
+	return &StopBlockAlreadyReachedError{Marker: *marker, Path: StopMarkerPath(p.workingDirectory)}
+}
+
+// recordStopMarker writes the stop marker once the stop block was reached, on failure the
+// next run reads the blocks again
+func (p *MindReaderPlugin) recordStopMarker(lastArchivedBlockNum uint64, lastArchivedBlockID string) {
+	if !p.writesStopMarker() || !p.stopReached.Load() {
+		return
+	}
+
+	marker := &StopMarker{
+		StopBlockNum:         p.currentStopBlock(),
+		ReachedAt:            p.currentTime(),
+		LastArchivedBlockNum: lastArchivedBlockNum,
+		LastArchivedBlockID:  lastArchivedBlockID,
+	}
+	if err := writeStopMarker(p.workingDirectory, marker); err != nil {
+		p.zlogger.Error("unable to write stop marker, a restart reads blocks again", zap.Error(err))
+		return
+	}
+	p.zlogger.Info("stop marker written", zap.Object("marker", marker))
+}
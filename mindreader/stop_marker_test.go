@@ -0,0 +1,111 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_StopMarker(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	workingDirectory := t.TempDir()
+	oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergedBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	// run launches a plugin stopping at `stopBlockNum`, feeding it blocks 100 to 250 unless it
+	// shuts down on launch, and returns the error it shut down with
+	run := func(t *testing.T, stopBlockNum uint64, options ...MindReaderPluginOption) error {
+		t.Helper()
+
+		consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+			return mindreadertest.NewConsoleReader(ctx.Lines), nil
+		}
+		p, err := NewMindReaderPluginWithStores(oneBlocks, mergedBlocks, "never", workingDirectory, consoleReaderFactory, 0, stopBlockNum, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer, options...)
+		require.NoError(t, err)
+
+		p.Launch()
+		if !p.IsTerminating() {
+			generator := mindreadertest.NewBlockGenerator("plugin", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+			for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 151)) {
+				p.LogLine(line)
+			}
+		}
+
+		select {
+		case <-p.Terminating():
+		case <-time.After(5 * time.Second):
+			t.Fatal("plugin not shut down")
+		}
+		p.Stop()
+		return p.Err()
+	}
+
+	err = run(t, 200)
+	require.True(t, errors.Is(err, ErrStopBlockReached), "got %v", err)
+
+	marker, err := ReadStopMarker(workingDirectory)
+	require.NoError(t, err)
+	require.NotNil(t, marker)
+	assert.Equal(t, uint64(200), marker.StopBlockNum)
+	assert.Equal(t, uint64(200), marker.LastArchivedBlockNum)
+	assert.NotEmpty(t, marker.LastArchivedBlockID)
+	assert.False(t, marker.ReachedAt.IsZero())
+
+	t.Run("restart refused", func(t *testing.T) {
+		err := run(t, 200)
+
+		var reachedErr *StopBlockAlreadyReachedError
+		require.True(t, errors.As(err, &reachedErr), "got %v", err)
+		assert.Equal(t, *marker, reachedErr.Marker)
+		assert.Equal(t, StopMarkerPath(workingDirectory), reachedErr.Path)
+	})
+
+	t.Run("restart with another stop block", func(t *testing.T) {
+		err := run(t, 220)
+		require.True(t, errors.Is(err, ErrStopBlockReached), "got %v", err)
+
+		marker, err := ReadStopMarker(workingDirectory)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(220), marker.StopBlockNum)
+	})
+
+	t.Run("restart overridden", func(t *testing.T) {
+		err := run(t, 220, WithStopMarkerOverride())
+		require.True(t, errors.Is(err, ErrStopBlockReached), "got %v", err)
+
+		marker, err := ReadStopMarker(workingDirectory)
+		require.NoError(t, err)
+		require.NotNil(t, marker, "written again once the stop block is reached")
+		assert.Equal(t, uint64(220), marker.LastArchivedBlockNum)
+	})
+
+	var reachedErr *StopBlockAlreadyReachedError
+	assert.True(t, errors.As(run(t, 220), &reachedErr), "marker of the overridden run refuses again")
+}
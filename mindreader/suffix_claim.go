@@ -0,0 +1,247 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ClaimDirName is the directory, in the one block files store, of the one block suffix claims
+const ClaimDirName = "_claims"
+
+const (
+	defaultSuffixClaimRefreshInterval = time.Minute
+	suffixClaimTimeout                = 30 * time.Second
+)
+
+// SuffixClaim is the marker a mindreader writes, as JSON to `_claims/.claim-<oneblock_suffix>`,
+// to own its one block suffix in the one block files store, see `WithOneBlockSuffixClaim`
+type SuffixClaim struct {
+	Suffix      string    `json:"suffix"`
+	Hostname    string    `json:"hostname"`
+	PID         int       `json:"pid"`
+	ClaimedAt   time.Time `json:"claimed_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// SuffixClaimOptions configures the one block suffix claim. The claim is refreshed on every
+// `RefreshInterval`, one minute when 0, and is live until `StaleAfter`, three refresh intervals
+// when 0, elapsed since its last refresh.
+type SuffixClaimOptions struct {
+	RefreshInterval time.Duration
+	StaleAfter      time.Duration
+	Hostname        string       // identifies the mindreader in the claim, `os.Hostname()` when empty
+	Force           bool         // takes over the live claim of another host
+	Store           dstore.Store // root store of the claims, `<one block store>/_claims` when nil
+}
+
+// WithOneBlockSuffixClaim makes the mindreader claim its one block suffix in the one block files
+// store when launched, so two replicas configured with the same suffix do not overwrite each
+// other's files. A live claim of another host makes the launch fail with a `SuffixClaimedError`,
+// unless `Force` is set, a claim of the same host (e.g. left by a crash) is taken over. The claim
+// is refreshed while running and released once the mindreader stopped. Stores do not offer
+// conditional writes, two replicas launched at the same time may both get the claim.
+func WithOneBlockSuffixClaim(options SuffixClaimOptions) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if options.RefreshInterval == 0 {
+			options.RefreshInterval = defaultSuffixClaimRefreshInterval
+		}
+		if options.StaleAfter == 0 {
+			options.StaleAfter = 3 * options.RefreshInterval
+		}
+		p.suffixClaimOptions = &options
+	}
+}
+
+// SuffixClaimStore returns the store of the one block suffix claims of the mindreaders archiving
+// to `oneBlockStore`
+func SuffixClaimStore(oneBlockStore dstore.Store) (dstore.Store, error) {
+	base := oneBlockStore.BaseURL()
+	if base == nil {
+		return nil, fmt.Errorf("one block store has no base URL")
+	}
+
+	claimURL := *base
+	claimURL.Path = path.Join(claimURL.Path, ClaimDirName)
+	return dstore.NewStore(claimURL.String(), "", "", true)
+}
+
+func suffixClaimObjectName(suffix string) string {
+	return ".claim-" + suffix
+}
+
+// ReadSuffixClaim reads the claim of the one block `suffix` from `store`, the store of the claims
+// (see `SuffixClaimStore`), nil if it is not claimed
+func ReadSuffixClaim(ctx context.Context, store dstore.Store, suffix string) (*SuffixClaim, error) {
+	reader, err := store.OpenObject(ctx, suffixClaimObjectName(suffix))
+	if err != nil {
+		if errors.Is(err, dstore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open suffix claim %q: %w", suffix, err)
+	}
+	defer reader.Close()
+
+	claim := &SuffixClaim{}
+	if err := json.NewDecoder(reader).Decode(claim); err != nil {
+		return nil, fmt.Errorf("decode suffix claim %q: %w", suffix, err)
+	}
+	return claim, nil
+}
+
+type suffixClaimer struct {
+	store   dstore.Store
+	options SuffixClaimOptions
+	now     func() time.Time
+	logger  *zap.Logger
+
+	lock  sync.Mutex
+	claim *SuffixClaim // nil until acquired and once released
+}
+
+func newSuffixClaimer(store dstore.Store, suffix string, options SuffixClaimOptions, now func() time.Time, logger *zap.Logger) (*suffixClaimer, error) {
+	hostname := options.Hostname
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("hostname: %w", err)
+		}
+	}
+
+	options.Hostname = hostname
+	return &suffixClaimer{
+		store:   store,
+		options: options,
+		now:     now,
+		logger:  logger.With(zap.String("suffix", suffix), zap.String("hostname", hostname)),
+		claim:   &SuffixClaim{Suffix: suffix, Hostname: hostname, PID: os.Getpid()},
+	}, nil
+}
+
+// acquire writes the claim, unless another host holds a live one
+func (c *suffixClaimer) acquire(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, suffixClaimTimeout)
+	defer cancel()
+
+	existing, err := ReadSuffixClaim(ctx, c.store, c.claim.Suffix)
+	if err != nil {
+		return fmt.Errorf("reading one block suffix claim: %w", err)
+	}
+
+	now := c.now()
+	if existing != nil && existing.Hostname != c.claim.Hostname {
+		live := now.Sub(existing.RefreshedAt) < c.options.StaleAfter
+		switch {
+		case live && !c.options.Force:
+			return &SuffixClaimedError{Claim: *existing}
+		case live:
+			c.logger.Warn("taking over live one block suffix claim of another host, forced", zap.String("claimed_by", existing.Hostname), zap.Int("claimed_by_pid", existing.PID))
+		default:
+			c.logger.Info("taking over stale one block suffix claim", zap.String("claimed_by", existing.Hostname), zap.Time("refreshed_at", existing.RefreshedAt))
+		}
+	}
+
+	c.claim.ClaimedAt = now
+	if err := c.write(ctx, now); err != nil {
+		return fmt.Errorf("writing one block suffix claim: %w", err)
+	}
+
+	c.logger.Info("claimed one block suffix")
+	return nil
+}
+
+func (c *suffixClaimer) write(ctx context.Context, now time.Time) error {
+	c.claim.RefreshedAt = now
+	content, err := json.Marshal(c.claim)
+	if err != nil {
+		return err
+	}
+	return c.store.WriteObject(ctx, suffixClaimObjectName(c.claim.Suffix), bytes.NewReader(content))
+}
+
+// refresh rewrites the claim, failures are only logged, the claim going stale if they persist
+func (c *suffixClaimer) refresh(ctx context.Context) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.claim == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, suffixClaimTimeout)
+	defer cancel()
+	if err := c.write(ctx, c.now()); err != nil {
+		c.logger.Warn("unable to refresh one block suffix claim", zap.Error(err))
+	}
+}
+
+func (c *suffixClaimer) run(ctx context.Context, terminating <-chan struct{}) {
+	ticker := time.NewTicker(c.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-terminating:
+			return
+		case <-ticker.C:
+			c.refresh(ctx)
+		}
+	}
+}
+
+// release deletes the claim, unless it was taken over by another mindreader in the meantime
+func (c *suffixClaimer) release(ctx context.Context) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.claim == nil || c.claim.ClaimedAt.IsZero() {
+		return
+	}
+	claim := c.claim
+	c.claim = nil
+
+	ctx, cancel := context.WithTimeout(ctx, suffixClaimTimeout)
+	defer cancel()
+
+	existing, err := ReadSuffixClaim(ctx, c.store, claim.Suffix)
+	if err != nil {
+		c.logger.Warn("unable to read one block suffix claim, not releasing it", zap.Error(err))
+		return
+	}
+	if existing == nil || existing.Hostname != claim.Hostname || existing.PID != claim.PID {
+		c.logger.Warn("one block suffix claim was taken over, not releasing it")
+		return
+	}
+
+	if err := c.store.DeleteObject(ctx, suffixClaimObjectName(claim.Suffix)); err != nil {
+		c.logger.Warn("unable to release one block suffix claim", zap.Error(err))
+		return
+	}
+	c.logger.Info("released one block suffix claim")
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_OneBlockSuffixClaim(t *testing.T) {
+	ctx := context.Background()
+	oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergedBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	claims, err := SuffixClaimStore(oneBlocks)
+	require.NoError(t, err)
+
+	newPlugin := func(t *testing.T, options SuffixClaimOptions) *MindReaderPlugin {
+		t.Helper()
+
+		consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+			return mindreadertest.NewConsoleReader(ctx.Lines), nil
+		}
+		p, err := NewMindReaderPluginWithStores(oneBlocks, mergedBlocks, "never", t.TempDir(), consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer, WithOneBlockSuffixClaim(options))
+		require.NoError(t, err)
+		return p
+	}
+
+	first := newPlugin(t, SuffixClaimOptions{Hostname: "host-a"})
+	first.Launch()
+	require.False(t, first.IsTerminating())
+
+	claim, err := ReadSuffixClaim(ctx, claims, "suffix")
+	require.NoError(t, err)
+	require.NotNil(t, claim)
+	assert.Equal(t, "host-a", claim.Hostname)
+	assert.NotZero(t, claim.PID)
+
+	second := newPlugin(t, SuffixClaimOptions{Hostname: "host-b"})
+	second.Launch()
+	require.True(t, second.IsTerminating(), "second plugin with the same suffix fails fast")
+	var claimedErr *SuffixClaimedError
+	require.True(t, errors.As(second.Err(), &claimedErr), "got %v", second.Err())
+	assert.Equal(t, "host-a", claimedErr.Claim.Hostname)
+	second.Stop()
+
+	first.Stop()
+	claim, err = ReadSuffixClaim(ctx, claims, "suffix")
+	require.NoError(t, err)
+	assert.Nil(t, claim, "released on stop")
+
+	writeClaim := func(t *testing.T, claim SuffixClaim) {
+		t.Helper()
+
+		content, err := json.Marshal(claim)
+		require.NoError(t, err)
+		require.NoError(t, claims.WriteObject(ctx, suffixClaimObjectName("suffix"), bytes.NewReader(content)))
+	}
+
+	t.Run("same host", func(t *testing.T) {
+		writeClaim(t, SuffixClaim{Suffix: "suffix", Hostname: "host-a", PID: 1, RefreshedAt: time.Now()})
+
+		p := newPlugin(t, SuffixClaimOptions{Hostname: "host-a"})
+		p.Launch()
+		require.False(t, p.IsTerminating(), "claim left by a crash of the same host is taken over")
+		p.Stop()
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		writeClaim(t, SuffixClaim{Suffix: "suffix", Hostname: "host-a", PID: 1, RefreshedAt: time.Now()})
+
+		p := newPlugin(t, SuffixClaimOptions{Hostname: "host-b", Force: true})
+		p.Launch()
+		require.False(t, p.IsTerminating())
+		p.Stop()
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		writeClaim(t, SuffixClaim{Suffix: "suffix", Hostname: "host-a", PID: 1, RefreshedAt: time.Now().Add(-time.Hour)})
+
+		p := newPlugin(t, SuffixClaimOptions{Hostname: "host-b", StaleAfter: time.Minute})
+		p.Launch()
+		require.False(t, p.IsTerminating())
+
+		claim, err := ReadSuffixClaim(ctx, claims, "suffix")
+		require.NoError(t, err)
+		assert.Equal(t, "host-b", claim.Hostname)
+		p.Stop()
+	})
+
+	t.Run("taken over", func(t *testing.T) {
+		p := newPlugin(t, SuffixClaimOptions{Hostname: "host-b"})
+		p.Launch()
+		require.False(t, p.IsTerminating())
+
+		writeClaim(t, SuffixClaim{Suffix: "suffix", Hostname: "host-c", PID: 1, RefreshedAt: time.Now()})
+		p.Stop()
+
+		claim, err := ReadSuffixClaim(ctx, claims, "suffix")
+		require.NoError(t, err)
+		assert.Equal(t, "host-c", claim.Hostname, "claim of another host not released")
+	})
+}
+
+func TestSuffixClaimer_Refresh(t *testing.T) {
+	store, err := dstore.NewStore(t.TempDir(), "", "", true)
+	require.NoError(t, err)
+	now := time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)
+	claimer, err := newSuffixClaimer(store, "suffix", SuffixClaimOptions{Hostname: "host-a", RefreshInterval: time.Millisecond}, func() time.Time { return now }, testLogger)
+	require.NoError(t, err)
+	require.NoError(t, claimer.acquire(context.Background()))
+
+	now = now.Add(time.Minute)
+	claimer.refresh(context.Background())
+
+	claim, err := ReadSuffixClaim(context.Background(), store, "suffix")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-time.Minute), claim.ClaimedAt.UTC())
+	assert.Equal(t, now, claim.RefreshedAt.UTC())
+}
+
+func TestMindReaderPlugin_OneBlockOverwriteFail(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergedBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	generator := mindreadertest.NewBlockGenerator("plugin", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	blocks := generator.Blocks(100, 10)
+	existing := bundle.BlockFileNameWithSuffix(blocks[5], "suffix")
+	require.NoError(t, oneBlocks.WriteObject(context.Background(), existing, bytes.NewReader([]byte("other mindreader"))))
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(oneBlocks, mergedBlocks, "never", t.TempDir(), consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer, WithOneBlockFileOverwrite(OneBlockOverwriteFail))
+	require.NoError(t, err)
+
+	p.Launch()
+	for _, line := range mindreadertest.FormatLines(blocks) {
+		p.LogLine(line)
+	}
+
+	select {
+	case <-p.Terminating():
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not shut down")
+	}
+	p.Stop()
+
+	var existsErr *DestinationFileExistsError
+	require.True(t, errors.As(p.Err(), &existsErr), "got %v", p.Err())
+	assert.Equal(t, existing, existsErr.Filename)
+
+	reader, err := oneBlocks.OpenObject(context.Background(), existing)
+	require.NoError(t, err)
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "other mindreader", string(content), "existing file kept")
+}
+
+func TestParseOneBlockOverwritePolicy(t *testing.T) {
+	for _, policy := range []OneBlockOverwritePolicy{OneBlockOverwriteStoreDefault, OneBlockOverwriteAlways, OneBlockOverwriteFail} {
+		parsed, err := ParseOneBlockOverwritePolicy(policy.String())
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseOneBlockOverwritePolicy("sometimes")
+	assert.Error(t, err)
+}
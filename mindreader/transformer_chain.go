@@ -0,0 +1,102 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+)
+
+// BlockTransformer is a step of a `TransformerChain`, it returns the block to keep, `block` itself,
+// modified or not, or another one, and nil to drop it. An error aborts the chain.
+type BlockTransformer func(block *bstream.Block) (*bstream.Block, error)
+
+// TransformerChain runs its transformers in order on every block read from the console reader,
+// each one receiving the block returned by the previous one, see `WithBlockTransformers`. The zero
+// value, like a nil chain, keeps every block as is.
+type TransformerChain struct {
+	transformers []BlockTransformer
+}
+
+func NewTransformerChain(transformers ...BlockTransformer) *TransformerChain {
+	return (&TransformerChain{}).Append(transformers...)
+}
+
+// Append adds `transformers` at the end of the chain, returning the chain
+func (c *TransformerChain) Append(transformers ...BlockTransformer) *TransformerChain {
+	for _, transformer := range transformers {
+		if transformer != nil {
+			c.transformers = append(c.transformers, transformer)
+		}
+	}
+	return c
+}
+
+func (c *TransformerChain) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.transformers)
+}
+
+// Transform runs the chain on `block`, returning nil when a transformer dropped it, its
+// following transformers are then not run
+func (c *TransformerChain) Transform(block *bstream.Block) (*bstream.Block, error) {
+	for i, transformer := range c.transformersOrNil() {
+		transformed, err := transformer(block)
+		if err != nil {
+			return nil, fmt.Errorf("transformer %d of %d: %w", i+1, len(c.transformers), err)
+		}
+		if transformed == nil {
+			return nil, nil
+		}
+		block = transformed
+	}
+	return block, nil
+}
+
+func (c *TransformerChain) transformersOrNil() []BlockTransformer {
+	if c == nil {
+		return nil
+	}
+	return c.transformers
+}
+
+// Transformer returns a transformer dropping the blocks the filter does not keep
+func (f BlockFilter) Transformer() BlockTransformer {
+	return func(block *bstream.Block) (*bstream.Block, error) {
+		keep, err := f(block)
+		if err != nil || !keep {
+			return nil, err
+		}
+		return block, nil
+	}
+}
+
+// WithBlockTransformers appends `transformers` to the transformer chain run on every block after
+// the start gate, before the head block update, the stop block check and the block being sent to
+// the archiver. A dropped block is neither archived nor pushed to the block stream server, does
+// not update the head block and does not reach the stop block. The continuity checker still sees
+// every block (it validates what the node produced, not what is archived). An error is treated
+// like a read error (maintenance when a requester is set, shutdown otherwise).
+func WithBlockTransformers(transformers ...BlockTransformer) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if p.transformers == nil {
+			p.transformers = NewTransformerChain()
+		}
+		p.transformers.Append(transformers...)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordingTransformer(name string, calls *[]string) BlockTransformer {
+	return func(block *bstream.Block) (*bstream.Block, error) {
+		*calls = append(*calls, name)
+		return block, nil
+	}
+}
+
+func TestTransformerChain_Order(t *testing.T) {
+	var calls []string
+	chain := NewTransformerChain(recordingTransformer("first", &calls))
+	chain.Append(recordingTransformer("second", &calls), nil).Append(func(block *bstream.Block) (*bstream.Block, error) {
+		calls = append(calls, "replace")
+		return &bstream.Block{Number: block.Number, Id: block.Id + "-replaced"}, nil
+	}, recordingTransformer("last", &calls))
+	assert.Equal(t, 4, chain.Len(), "nil transformers are skipped")
+
+	out, err := chain.Transform(&bstream.Block{Number: 1, Id: "00000001a"})
+	require.NoError(t, err)
+	assert.Equal(t, "00000001a-replaced", out.Id)
+	assert.Equal(t, []string{"first", "second", "replace", "last"}, calls)
+
+	var nilChain *TransformerChain
+	block := &bstream.Block{Number: 1}
+	out, err = nilChain.Transform(block)
+	require.NoError(t, err)
+	assert.Same(t, block, out, "nil chain keeps the block")
+}
+
+func TestTransformerChain_DropAndError(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	var calls []string
+	chain := NewTransformerChain(
+		recordingTransformer("first", &calls),
+		func(block *bstream.Block) (*bstream.Block, error) {
+			switch block.Number {
+			case 2:
+				return nil, nil
+			case 3:
+				return nil, errBoom
+			}
+			return block, nil
+		},
+		recordingTransformer("last", &calls),
+	)
+
+	out, err := chain.Transform(&bstream.Block{Number: 2})
+	require.NoError(t, err)
+	assert.Nil(t, out)
+	assert.Equal(t, []string{"first"}, calls, "steps after a drop are not run")
+
+	calls = nil
+	_, err = chain.Transform(&bstream.Block{Number: 3})
+	assert.EqualError(t, err, "transformer 2 of 3: boom")
+	assert.True(t, errors.Is(err, errBoom))
+	assert.Equal(t, []string{"first"}, calls, "steps after an error are not run")
+}
+
+func TestMindReaderPlugin_TransformerChain(t *testing.T) {
+	lines := make(chan string, 5)
+	blocks := make(chan *bstream.Block, 5)
+
+	var headBlocks []uint64
+	p := &MindReaderPlugin{
+		Shutter:       shutter.New(),
+		lines:         lines,
+		consoleReader: newTestConsoleReader(lines),
+		startGate:     NewBlockNumberGate(0),
+		stopBlock:     4,
+		headBlockUpdaters: []nodeManager.HeadBlockUpdater{func(blockNum uint64, blockID string, blockTime time.Time, libNum uint64) {
+			headBlocks = append(headBlocks, blockNum)
+		}},
+		zlogger: testLogger,
+	}
+	WithBlockTransformers(func(block *bstream.Block) (*bstream.Block, error) {
+		if block.Number == 2 || block.Number == 4 {
+			return nil, nil
+		}
+		return block, nil
+	})(p)
+	WithBlockTransformers(func(block *bstream.Block) (*bstream.Block, error) {
+		block.Id += "-annotated"
+		return block, nil
+	})(p)
+
+	for _, line := range []string{`DMLOG {"id":"00000001a"}`, `DMLOG {"id":"00000002a"}`, `DMLOG {"id":"00000003a"}`, `DMLOG {"id":"00000004a"}`} {
+		p.LogLine(line)
+		require.NoError(t, p.readOneMessage(blocks))
+	}
+	assert.False(t, p.stopReached.Load(), "dropped stop block does not stop the plugin")
+
+	p.LogLine(`DMLOG {"id":"00000005a"}`)
+	require.NoError(t, p.readOneMessage(blocks))
+	assert.True(t, p.stopReached.Load(), "first kept block past the stop block stops the plugin")
+
+	close(blocks)
+	var received []string
+	for block := range blocks {
+		received = append(received, block.Id)
+	}
+	assert.Equal(t, []string{"00000001a-annotated", "00000003a-annotated", "00000005a-annotated"}, received)
+	assert.Equal(t, []uint64{1, 3, 5}, headBlocks)
+}
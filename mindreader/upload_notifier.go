@@ -0,0 +1,244 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+)
+
+// UploadNotifier is told about every one block file once uploaded to the one block store, with
+// its name in the store, in the order the uploads completed. It is called from the upload
+// workers and must not block, a failure is logged and counted and never fails the upload.
+type UploadNotifier interface {
+	Notify(filename string, blockNum uint64) error
+}
+
+// WithUploadNotifier notifies `notifier` of the one block files uploaded, see `UploadNotifier`.
+// With an upload concurrency above 1, files may complete out of block order.
+func WithUploadNotifier(notifier UploadNotifier) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadNotifier = notifier
+	}
+}
+
+// onOneBlockUploaded returns the `FileUploaderOnUploaded` of the one block files, nil when
+// neither a watermark nor an upload notifier follows them
+func (p *MindReaderPlugin) onOneBlockUploaded() func(filename string) {
+	watermark, notifier := p.watermark, p.uploadNotifier
+	if watermark == nil && notifier == nil {
+		return nil
+	}
+
+	return func(filename string) {
+		if watermark != nil {
+			watermark.uploaded(filename)
+		}
+		if notifier != nil {
+			p.notifyUploaded(notifier, filename)
+		}
+	}
+}
+
+func (p *MindReaderPlugin) notifyUploaded(notifier UploadNotifier, filename string) {
+	blockNum, _, _, _, _, _, err := bundle.ParseFilename(path.Base(filename))
+	if err != nil {
+		p.zlogger.Warn("unable to parse uploaded one block file name, not notifying it", zap.String("filename", filename), zap.Error(err))
+		return
+	}
+
+	objectName := partitionedFileName(filename, p.oneBlockPartitionWidth)
+	if err := notifier.Notify(objectName, blockNum); err != nil {
+		metrics.UploadNotificationFailures.Inc()
+		p.zlogger.Warn("failed to notify uploaded one block file", zap.String("filename", objectName), zap.Uint64("block_num", blockNum), zap.Error(err))
+	}
+}
+
+// UploadNotification is a one block file uploaded, see `UploadNotifier`
+type UploadNotification struct {
+	Filename string `json:"filename"`
+	BlockNum uint64 `json:"block_num"`
+}
+
+// ChannelUploadNotifier publishes the uploaded files on a buffered channel, for in-process
+// consumers. A notification not fitting in the buffer is dropped, failing `Notify`.
+type ChannelUploadNotifier struct {
+	notifications chan UploadNotification
+}
+
+func NewChannelUploadNotifier(bufferSize int) *ChannelUploadNotifier {
+	return &ChannelUploadNotifier{
+		notifications: make(chan UploadNotification, bufferSize),
+	}
+}
+
+// C returns the channel the notifications are published on
+func (n *ChannelUploadNotifier) C() <-chan UploadNotification {
+	return n.notifications
+}
+
+func (n *ChannelUploadNotifier) Notify(filename string, blockNum uint64) error {
+	select {
+	case n.notifications <- UploadNotification{Filename: filename, BlockNum: blockNum}:
+		return nil
+	default:
+		return fmt.Errorf("notification channel full (%d notifications), dropping notification", cap(n.notifications))
+	}
+}
+
+type HTTPUploadNotifierOption func(n *HTTPUploadNotifier)
+
+// HTTPUploadNotifierQueueSize sets the number of notifications waiting to be posted, 1000 by
+// default, beyond which they are dropped
+func HTTPUploadNotifierQueueSize(size int) HTTPUploadNotifierOption {
+	return func(n *HTTPUploadNotifier) {
+		if size > 0 {
+			n.queue = make(chan UploadNotification, size)
+		}
+	}
+}
+
+// HTTPUploadNotifierRetries sets how many times a failed post is retried, `delay` apart, before
+// the notification is dropped, 3 times 500ms apart by default
+func HTTPUploadNotifierRetries(retries int, delay time.Duration) HTTPUploadNotifierOption {
+	return func(n *HTTPUploadNotifier) {
+		n.retries = retries
+		n.retryDelay = delay
+	}
+}
+
+// HTTPUploadNotifierClient replaces the HTTP client posting the notifications, which times out
+// after 5s by default
+func HTTPUploadNotifierClient(client *http.Client) HTTPUploadNotifierOption {
+	return func(n *HTTPUploadNotifier) {
+		n.client = client
+	}
+}
+
+// HTTPUploadNotifier posts every notification, as an `UploadNotification` JSON object, to an
+// HTTP endpoint. `Notify` only queues it, the notifications being posted one at a time, in
+// order, from a bounded queue, so a slow or failing endpoint never slows the uploads down: a
+// notification is dropped when the queue is full or once its retries failed. Every status
+// other than 2xx is a failure. Pending notifications are dropped on `Shutdown`.
+type HTTPUploadNotifier struct {
+	*shutter.Shutter
+
+	url        string
+	client     *http.Client
+	queue      chan UploadNotification
+	retries    int
+	retryDelay time.Duration
+	logger     *zap.Logger
+}
+
+func NewHTTPUploadNotifier(url string, logger *zap.Logger, options ...HTTPUploadNotifierOption) *HTTPUploadNotifier {
+	n := &HTTPUploadNotifier{
+		Shutter:    shutter.New(),
+		url:        url,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		queue:      make(chan UploadNotification, 1000),
+		retries:    3,
+		retryDelay: 500 * time.Millisecond,
+		logger:     logger,
+	}
+
+	for _, opt := range options {
+		opt(n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.OnTerminating(func(_ error) { cancel() })
+	go n.run(ctx)
+
+	return n
+}
+
+func (n *HTTPUploadNotifier) Notify(filename string, blockNum uint64) error {
+	if n.IsTerminating() {
+		return fmt.Errorf("http upload notifier is shut down")
+	}
+
+	select {
+	case n.queue <- UploadNotification{Filename: filename, BlockNum: blockNum}:
+		return nil
+	default:
+		return fmt.Errorf("http upload notifier queue full (%d notifications), dropping notification", cap(n.queue))
+	}
+}
+
+func (n *HTTPUploadNotifier) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-n.queue:
+			if err := n.deliver(ctx, notification); err != nil && ctx.Err() == nil {
+				metrics.UploadNotificationFailures.Inc()
+				n.logger.Warn("failed to post upload notification, dropping it", zap.String("url", n.url), zap.String("filename", notification.Filename), zap.Uint64("block_num", notification.BlockNum), zap.Error(err))
+			}
+		}
+	}
+}
+
+// deliver posts `notification`, retrying on failure
+func (n *HTTPUploadNotifier) deliver(ctx context.Context, notification UploadNotification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = n.post(ctx, payload)
+		if err == nil || attempt >= n.retries {
+			return err
+		}
+
+		n.logger.Debug("failed to post upload notification, retrying", zap.String("filename", notification.Filename), zap.Int("attempt", attempt+1), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.retryDelay):
+		}
+	}
+}
+
+func (n *HTTPUploadNotifier) post(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
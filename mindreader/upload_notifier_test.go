@@ -0,0 +1,171 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runUploadNotifierPlugin(t *testing.T, notifier UploadNotifier) dstore.Store {
+	t.Helper()
+
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	p, err := NewMindReaderPluginWithStores(oneBlocks, mergeArchiveStore, "never", t.TempDir(), consoleReaderFactory, 0, 149, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer,
+		WithUploadNotifier(notifier),
+		WithUploadConcurrency(1),
+		WithUploadPollInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	p.Launch()
+	generator := mindreadertest.NewBlockGenerator("notifier", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 50)) {
+		p.LogLine(line)
+	}
+
+	select {
+	case <-p.Terminating():
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin not shut down after stop block")
+	}
+	p.Stop()
+
+	return oneBlocks
+}
+
+func TestMindReaderPlugin_UploadNotifier(t *testing.T) {
+	notifier := NewChannelUploadNotifier(100)
+	oneBlocks := runUploadNotifierPlugin(t, notifier)
+
+	var notified []uint64
+	for len(notifier.C()) > 0 {
+		notification := <-notifier.C()
+		notified = append(notified, notification.BlockNum)
+
+		exists, err := oneBlocks.FileExists(context.Background(), notification.Filename)
+		require.NoError(t, err)
+		assert.True(t, exists, "notified file %q not in one block store", notification.Filename)
+	}
+
+	var expected []uint64
+	for num := uint64(100); num < 150; num++ {
+		expected = append(expected, num)
+	}
+	assert.Equal(t, expected, notified, "notified in upload order")
+}
+
+func TestMindReaderPlugin_FailingHTTPUploadNotifier(t *testing.T) {
+	var lock sync.Mutex
+	var posts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		posts++
+		lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := NewHTTPUploadNotifier(server.URL, testLogger, HTTPUploadNotifierQueueSize(2), HTTPUploadNotifierRetries(100, 10*time.Millisecond))
+	defer notifier.Shutdown(nil)
+
+	oneBlocks := runUploadNotifierPlugin(t, notifier)
+
+	var files int
+	require.NoError(t, oneBlocks.Walk(context.Background(), "", func(filename string) error {
+		files++
+		return nil
+	}))
+	assert.Equal(t, 50, files, "every block uploaded despite the failing notifier")
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Greater(t, posts, 0)
+}
+
+func TestHTTPUploadNotifier(t *testing.T) {
+	received := make(chan UploadNotification, 10)
+	var lock sync.Mutex
+	failuresLeft := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		lock.Lock()
+		fail := failuresLeft > 0
+		failuresLeft--
+		lock.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var notification UploadNotification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&notification))
+		received <- notification
+	}))
+	defer server.Close()
+
+	notifier := NewHTTPUploadNotifier(server.URL, testLogger, HTTPUploadNotifierRetries(3, time.Millisecond))
+	defer notifier.Shutdown(nil)
+
+	require.NoError(t, notifier.Notify("0000000100-a", 100))
+	require.NoError(t, notifier.Notify("0000000101-b", 101))
+
+	for _, expected := range []UploadNotification{{Filename: "0000000100-a", BlockNum: 100}, {Filename: "0000000101-b", BlockNum: 101}} {
+		select {
+		case notification := <-received:
+			assert.Equal(t, expected, notification)
+		case <-time.After(5 * time.Second):
+			t.Fatal("notification not posted")
+		}
+	}
+
+	notifier.Shutdown(nil)
+	assert.Error(t, notifier.Notify("0000000102-c", 102))
+}
+
+func TestChannelUploadNotifier_Full(t *testing.T) {
+	notifier := NewChannelUploadNotifier(1)
+
+	require.NoError(t, notifier.Notify("0000000100-a", 100))
+	assert.Error(t, notifier.Notify("0000000101-b", 101))
+	assert.Equal(t, UploadNotification{Filename: "0000000100-a", BlockNum: 100}, <-notifier.C())
+}
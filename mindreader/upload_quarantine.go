@@ -0,0 +1,128 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// quarantineReasonSuffix is appended to the name of a quarantined file for its sidecar file
+// explaining why it was quarantined, see `quarantineReason`
+const quarantineReasonSuffix = ".reason.json"
+
+// quarantineReason is the content of the sidecar file of a file quarantined by the uploader
+type quarantineReason struct {
+	File          string    `json:"file"`
+	Attempts      int       `json:"attempts"`
+	FirstFailure  time.Time `json:"first_failure"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	LastError     string    `json:"last_error"`
+}
+
+// FileUploaderQuarantine moves a file failing to upload `maxAttempts` times, over all passes, to
+// `directory`, along with a `<file>.reason.json` sidecar file explaining why, so it is no longer
+// retried. Files are never quarantined when `maxAttempts` is 0, the default.
+func FileUploaderQuarantine(directory string, maxAttempts int) FileUploaderOption {
+	return func(fu *FileUploader) {
+		if maxAttempts > 0 {
+			fu.quarantineDirectory = directory
+			fu.quarantineMaxAttempts = maxAttempts
+		}
+	}
+}
+
+// WithUploadQuarantine moves the one block and merged blocks files failing to upload
+// `maxAttempts` times to the `quarantine/uploads` directory of the working directory, see
+// `FileUploaderQuarantine`. Their number is reported by `Status`.
+func WithUploadQuarantine(maxAttempts int) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadQuarantineMaxAttempts = maxAttempts
+	}
+}
+
+// uploadQuarantineDirectory returns the quarantine directory of the uploader of the files of
+// `localDirectory`, a directory of the working directory
+func uploadQuarantineDirectory(workingDirectory, localDirectory string) string {
+	return filepath.Join(workingDirectory, quarantineDirName, "uploads", localDirectory)
+}
+
+// quarantine moves the file out of the local store, once it failed `state.attempts` times, the
+// last one with `err`. The file stays in the local store, and keeps being retried, when it
+// cannot be moved.
+func (fu *FileUploader) quarantine(filename string, state uploadRetryState, err error) error {
+	now := time.Now()
+	target := filepath.Join(fu.quarantineDirectory, filepath.FromSlash(path.Dir(filename)), filepath.Base(fu.localStore.ObjectPath(filename)))
+	if moveErr := fu.moveToQuarantine(filename, target); moveErr != nil {
+		fu.logger.Error("unable to quarantine file failing to upload, leaving it in place", zap.String("local_file", filename), zap.Int("attempts", state.attempts), zap.NamedError("quarantine_error", moveErr), zap.Error(err))
+		return fmt.Errorf("moving file %q to storage: %w", filename, err)
+	}
+	fu.clearRetryState(filename)
+	metrics.QuarantinedUploadFiles.Inc(fu.fileType)
+
+	reason, _ := json.MarshalIndent(quarantineReason{
+		File:          filename,
+		Attempts:      state.attempts,
+		FirstFailure:  state.firstFailure,
+		QuarantinedAt: now,
+		LastError:     err.Error(),
+	}, "", "  ")
+	if writeErr := os.WriteFile(target+quarantineReasonSuffix, reason, 0644); writeErr != nil {
+		fu.logger.Warn("unable to write reason of quarantined file", zap.String("quarantined_file", target), zap.Error(writeErr))
+	}
+
+	fu.logger.Error("file failed to upload too many times, quarantined",
+		zap.String("local_file", filename),
+		zap.String("quarantined_file", target),
+		zap.Int("attempts", state.attempts),
+		zap.Time("first_failure", state.firstFailure),
+		zap.Error(err),
+	)
+	return fmt.Errorf("file %q quarantined after %d failed upload attempts: %w", filename, state.attempts, err)
+}
+
+func (fu *FileUploader) moveToQuarantine(filename, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return fmt.Errorf("creating quarantine directory: %w", err)
+	}
+	return os.Rename(fu.localStore.ObjectPath(filename), target)
+}
+
+// quarantinedFiles returns the number of files in the quarantine directory, sidecar files
+// excluded
+func (fu *FileUploader) quarantinedFiles() (count int) {
+	if fu.quarantineDirectory == "" {
+		return 0
+	}
+
+	_ = filepath.Walk(fu.quarantineDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() && !strings.HasSuffix(path, quarantineReasonSuffix) {
+			count++
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/dstorefault"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rejectingStore wraps a local store rejecting the uploads of the files named with `prefix`
+func rejectingStore(t *testing.T, prefix string) (*dstorefault.Store, dstore.Store) {
+	t.Helper()
+
+	store, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+	return dstorefault.Wrap(store, dstorefault.FaultPolicy{Faults: []dstorefault.Fault{
+		{Operations: []dstorefault.Operation{dstorefault.PushLocalFile}, Names: func(name string) bool { return strings.HasPrefix(name, prefix) }, Err: dstorefault.ErrForbidden},
+	}}), store
+}
+
+func TestFileUploader_QuarantineAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	local, err := dstore.NewDBinStore(localDir)
+	require.NoError(t, err)
+	for _, name := range []string{"0000000000", "0000000001", "0000000002", "0000000003"} {
+		require.NoError(t, local.WriteObject(ctx, name, strings.NewReader("block")))
+	}
+	destination, _ := rejectingStore(t, "0000000002")
+
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	quarantinedBefore := testutil.ToFloat64(metrics.QuarantinedUploadFiles.Native().WithLabelValues("quarantine-test"))
+	uploader := NewFileUploader(local, destination, testLogger,
+		FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		FileUploaderQuarantine(quarantineDir, 6),
+		FileUploaderMetrics(nil, "quarantine-test"),
+	)
+
+	uploaded, err := uploader.uploadAllFiles(ctx)
+	require.Error(t, err)
+	sort.Strings(uploaded)
+	assert.Equal(t, []string{"0000000000", "0000000001", "0000000003"}, uploaded, "healthy files uploaded while one is failing")
+	assert.Equal(t, 1, uploader.pendingFiles(ctx))
+	assert.Zero(t, uploader.quarantinedFiles(), "4 attempts of 6")
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = uploader.uploadAllFiles(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `file "0000000002" quarantined after 6 failed upload attempts`)
+	assert.Equal(t, 6, destination.Calls(dstorefault.PushLocalFile)-3)
+
+	assert.Zero(t, uploader.pendingFiles(ctx))
+	assert.Equal(t, 1, uploader.quarantinedFiles())
+	assert.Equal(t, quarantinedBefore+1, testutil.ToFloat64(metrics.QuarantinedUploadFiles.Native().WithLabelValues("quarantine-test")))
+
+	_, err = os.Stat(filepath.Join(quarantineDir, "0000000002.dbin.zst"))
+	require.NoError(t, err)
+
+	var reason quarantineReason
+	content, err := os.ReadFile(filepath.Join(quarantineDir, "0000000002.dbin.zst.reason.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(content, &reason))
+	assert.Equal(t, "0000000002", reason.File)
+	assert.Equal(t, 6, reason.Attempts)
+	assert.Contains(t, reason.LastError, "403 forbidden")
+	assert.False(t, reason.FirstFailure.After(reason.QuarantinedAt))
+
+	uploaded, err = uploader.uploadAllFiles(ctx)
+	assert.NoError(t, err, "quarantined file no longer retried")
+	assert.Empty(t, uploaded)
+}
+
+func TestMindReaderPlugin_StatusQuarantinedUploads(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	archiveStore, store := rejectingStore(t, "0000000102")
+	mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+
+	consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+		return mindreadertest.NewConsoleReader(ctx.Lines), nil
+	}
+	workingDirectory := t.TempDir()
+	p, err := NewMindReaderPluginWithStores(archiveStore, mergeArchiveStore, "never", workingDirectory, consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer,
+		WithUploadRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		WithUploadPollInterval(5*time.Millisecond),
+		WithUploadQuarantine(2),
+	)
+	require.NoError(t, err)
+
+	p.Launch()
+	defer p.Stop()
+
+	generator := mindreadertest.NewBlockGenerator("quarantine", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+	for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 5)) {
+		p.LogLine(line)
+	}
+
+	require.Eventually(t, func() bool {
+		status := p.Status()
+		return status.LastStoredBlockNum == 104 && status.PendingUploads == 0 && status.QuarantinedUploads == 1
+	}, 5*time.Second, 5*time.Millisecond, "healthy files uploaded, the rejected one quarantined")
+	assert.Zero(t, p.Status().PendingMergedUploads)
+
+	var archived []string
+	require.NoError(t, store.Walk(context.Background(), "", func(filename string) error {
+		archived = append(archived, filename[:10])
+		return nil
+	}))
+	assert.Equal(t, []string{"0000000100", "0000000101", "0000000103", "0000000104"}, archived)
+
+	quarantined, err := filepath.Glob(filepath.Join(workingDirectory, "quarantine", "uploads", "uploadable-oneblock", "0000000102-*"))
+	require.NoError(t, err)
+	assert.Len(t, quarantined, 2, "quarantined file and its reason")
+}
@@ -0,0 +1,185 @@
+package mindreader
+
+import (
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// UploadRetryPolicy governs how a file failing to upload is retried by the one block and merged
+// blocks uploaders. Each file is retried on its own, after an exponential backoff with jitter,
+// and makes the mindreader unready (see `MindReaderPlugin.Ready`) once it has been failing for
+// longer than `MaxElapsed`, it keeps being retried every `MaxBackoff` afterward. Zero fields take
+// their value from `DefaultUploadRetryPolicy`.
+type UploadRetryPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	MaxElapsed     time.Duration
+}
+
+var DefaultUploadRetryPolicy = UploadRetryPolicy{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	MaxElapsed:     10 * time.Minute,
+}
+
+func (p UploadRetryPolicy) normalized() UploadRetryPolicy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultUploadRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultUploadRetryPolicy.MaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultUploadRetryPolicy.Multiplier
+	}
+	if p.MaxElapsed <= 0 {
+		p.MaxElapsed = DefaultUploadRetryPolicy.MaxElapsed
+	}
+	return p
+}
+
+// backoff returns the delay before retrying a file that failed `attempts` times, jittered by up
+// to 20% so files failing together are not retried in lockstep
+func (p UploadRetryPolicy) backoff(attempts int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempts && backoff < float64(p.MaxBackoff); i++ {
+		backoff *= p.Multiplier
+	}
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	return time.Duration(backoff * (0.8 + 0.4*rand.Float64()))
+}
+
+// FileUploaderRetryPolicy replaces `DefaultUploadRetryPolicy`
+func FileUploaderRetryPolicy(policy UploadRetryPolicy) FileUploaderOption {
+	return func(fu *FileUploader) {
+		fu.retryPolicy = policy.normalized()
+	}
+}
+
+// FileUploaderPollInterval sets how often failed uploads are retried, 500ms by default
+func FileUploaderPollInterval(interval time.Duration) FileUploaderOption {
+	return func(fu *FileUploader) {
+		if interval > 0 {
+			fu.pollInterval = interval
+		}
+	}
+}
+
+// FileUploaderScanInterval sets how often the whole local store is scanned for files to upload,
+// 5s by default. Files notified as stored are uploaded right away, the scan only catches the
+// other ones, like the files left by a crash.
+func FileUploaderScanInterval(interval time.Duration) FileUploaderOption {
+	return func(fu *FileUploader) {
+		if interval > 0 {
+			fu.scanInterval = interval
+		}
+	}
+}
+
+// WithUploadRetryPolicy sets the retry policy of both the one block and merged blocks uploaders
+func WithUploadRetryPolicy(policy UploadRetryPolicy) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadRetryPolicy = policy
+	}
+}
+
+// WithUploadPollInterval sets how often both uploaders retry failed uploads, 500ms by default
+func WithUploadPollInterval(interval time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadPollInterval = interval
+	}
+}
+
+// WithUploadScanInterval sets how often both uploaders scan the working directory for files to
+// upload, 5s by default. The files written by the archiver are uploaded as soon as they are
+// written, see `FileUploaderScanInterval`.
+func WithUploadScanInterval(interval time.Duration) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.uploadScanInterval = interval
+	}
+}
+
+type uploadRetryState struct {
+	firstFailure time.Time
+	attempts     int
+	nextAttempt  time.Time
+	exhausted    bool // failing for longer than the policy's MaxElapsed
+}
+
+// dueFiles returns the files which are not waiting for their backoff to elapse, forgetting the
+// retry state of files no longer in the local store
+func (fu *FileUploader) dueFiles(filenames []string, now time.Time) (due []string) {
+	fu.retryLock.Lock()
+	defer fu.retryLock.Unlock()
+
+	states := make(map[string]*uploadRetryState, len(fu.retryStates))
+	for _, filename := range filenames {
+		state, found := fu.retryStates[filename]
+		if found {
+			states[filename] = state
+		}
+		if !found || !now.Before(state.nextAttempt) {
+			due = append(due, filename)
+		}
+	}
+	fu.retryStates = states
+	return
+}
+
+// recordFailedAttempt returns the backoff before the next attempt of the file, and its retry state
+func (fu *FileUploader) recordFailedAttempt(filename string, err error, now time.Time) (time.Duration, uploadRetryState) {
+	fu.retryLock.Lock()
+	defer fu.retryLock.Unlock()
+
+	state, found := fu.retryStates[filename]
+	if !found {
+		state = &uploadRetryState{firstFailure: now}
+		fu.retryStates[filename] = state
+	}
+
+	state.attempts++
+	backoff := fu.retryPolicy.backoff(state.attempts)
+	state.nextAttempt = now.Add(backoff)
+
+	if !state.exhausted && now.Sub(state.firstFailure) > fu.retryPolicy.MaxElapsed {
+		state.exhausted = true
+		fu.logger.Error("file still not uploaded after the maximum retry time, reporting mindreader as unready until it is",
+			zap.String("local_file", filename),
+			zap.Time("first_failure", state.firstFailure),
+			zap.Int("attempts", state.attempts),
+			zap.Duration("max_elapsed", fu.retryPolicy.MaxElapsed),
+			zap.Error(err),
+		)
+	}
+	return backoff, *state
+}
+
+func (fu *FileUploader) clearRetryState(filename string) {
+	fu.retryLock.Lock()
+	defer fu.retryLock.Unlock()
+
+	delete(fu.retryStates, filename)
+}
+
+// retriesExhausted reports if a file has been failing to upload for longer than the policy's MaxElapsed
+func (fu *FileUploader) retriesExhausted() bool {
+	fu.retryLock.Lock()
+	defer fu.retryLock.Unlock()
+
+	for _, state := range fu.retryStates {
+		if state.exhausted {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadRetryPolicy_Backoff(t *testing.T) {
+	policy := UploadRetryPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 3}.normalized()
+	assert.Equal(t, DefaultUploadRetryPolicy.MaxElapsed, policy.MaxElapsed)
+
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 3 * time.Second, 3: 9 * time.Second, 4: 10 * time.Second, 50: 10 * time.Second} {
+		backoff := policy.backoff(attempts)
+		assert.GreaterOrEqual(t, int64(backoff), int64(expected*8/10), "attempts %d", attempts)
+		assert.LessOrEqual(t, int64(backoff), int64(expected*12/10), "attempts %d", attempts)
+	}
+}
+
+func TestFileUploader_FailedFileWaitsForBackoff(t *testing.T) {
+	local, destination := newSlowUploadTestStores(2, 0)
+
+	var lock sync.Mutex
+	attempts := map[string]int{}
+	destination.PushLocalFileFunc = func(_ context.Context, _, toBaseName string) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		attempts[toBaseName]++
+		if toBaseName == "0000000001" {
+			return fmt.Errorf("rate limited")
+		}
+		return nil
+	}
+
+	uploader := NewFileUploader(local, destination, testLogger, FileUploaderRetryPolicy(UploadRetryPolicy{InitialBackoff: time.Hour}))
+	uploader.retries = 0
+
+	_, err := uploader.uploadAllFiles(context.Background())
+	require.Error(t, err)
+
+	_, err = uploader.uploadAllFiles(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 file(s) waiting to be retried")
+	assert.Equal(t, 1, attempts["0000000001"], "not retried before its backoff elapsed")
+
+	uploader.retryStates["0000000001"].nextAttempt = time.Now()
+	_, err = uploader.uploadAllFiles(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 2, attempts["0000000001"])
+}
+
+func TestFileUploader_RetriesExhausted(t *testing.T) {
+	uploader := NewFileUploader(dstore.NewMockStore(nil), dstore.NewMockStore(nil), testLogger, FileUploaderRetryPolicy(UploadRetryPolicy{MaxElapsed: time.Minute}))
+
+	now := time.Now()
+	uploader.recordFailedAttempt("0000000001", errors.New("rate limited"), now.Add(-2*time.Minute))
+	assert.False(t, uploader.retriesExhausted())
+
+	uploader.recordFailedAttempt("0000000001", errors.New("rate limited"), now)
+	assert.True(t, uploader.retriesExhausted())
+
+	p := newReadinessTestPlugin()
+	p.stats.blocksArchived.Inc()
+	p.oneBlockFileUploader = uploader
+	p.mergedBlocksFileUploader.recordUploadResult(nil, now)
+	assert.False(t, p.Ready(), "a file failed for longer than the retry policy's max elapsed")
+
+	uploader.clearRetryState("0000000001")
+	assert.True(t, p.Ready())
+
+	uploader.recordFailedAttempt("0000000002", errors.New("rate limited"), now.Add(-2*time.Minute))
+	uploader.recordFailedAttempt("0000000002", errors.New("rate limited"), now)
+	uploader.dueFiles(nil, now)
+	assert.False(t, uploader.retriesExhausted(), "retry state forgotten once the file is gone from the local store")
+}
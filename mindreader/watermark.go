@@ -0,0 +1,266 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger/bundle"
+	"go.uber.org/zap"
+)
+
+// WatermarkDirName is the directory, in the one block files store, of the watermark objects
+const WatermarkDirName = "_watermark"
+
+const (
+	defaultWatermarkInterval   = 30 * time.Second
+	watermarkWriteTimeout      = 10 * time.Second
+	maxWatermarkArchivedBlocks = 10000
+)
+
+// Watermark tells how far a mindreader has archived, it is written as JSON to
+// `_watermark/<oneblock_suffix>.json`, see `WithWatermark` and `ReadWatermark`
+type Watermark struct {
+	BlockNum  uint64    `json:"block_num"`            // highest block uploaded, in a one block file or a merged bundle
+	BlockID   string    `json:"block_id,omitempty"`   // full ID, or the ID suffix of the one block file name, when known
+	BlockTime time.Time `json:"block_time,omitempty"` // when known
+	Suffix    string    `json:"suffix"`               // one block suffix of the mindreader writing it
+	StartedAt time.Time `json:"started_at"`           // when the mindreader plugin was launched
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// WatermarkOptions configures when the watermark is written, on every `Interval` and every
+// `EveryBlocks` blocks since the last written one, when it changed. `Interval` is 30 seconds
+// when both are 0. The watermark objects are written to the one block files store unless `Store`
+// is set, consumers walking the one block files store must then skip the `_watermark` directory.
+type WatermarkOptions struct {
+	Interval    time.Duration
+	EveryBlocks uint64
+	Store       dstore.Store // root store of the watermark objects, `<one block store>/_watermark` when nil
+}
+
+// WithWatermark makes the mindreader write its `Watermark` to the destination store, best-effort:
+// failures are logged at debug level and never affect block archiving
+func WithWatermark(options WatermarkOptions) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		if options.Interval == 0 && options.EveryBlocks == 0 {
+			options.Interval = defaultWatermarkInterval
+		}
+		p.watermarkOptions = &options
+	}
+}
+
+// WatermarkStore returns the store of the watermark objects of the mindreaders archiving to
+// `oneBlockStore`
+func WatermarkStore(oneBlockStore dstore.Store) (dstore.Store, error) {
+	base := oneBlockStore.BaseURL()
+	if base == nil {
+		return nil, fmt.Errorf("one block store has no base URL")
+	}
+
+	watermarkURL := *base
+	watermarkURL.Path = path.Join(watermarkURL.Path, WatermarkDirName)
+	return dstore.NewStore(watermarkURL.String(), "", "", true)
+}
+
+func watermarkObjectName(suffix string) string {
+	return suffix + ".json"
+}
+
+// ReadWatermark reads the watermark of the mindreader with the one block `suffix` from `store`,
+// the store of the watermark objects, see `WatermarkStore`
+func ReadWatermark(ctx context.Context, store dstore.Store, suffix string) (*Watermark, error) {
+	reader, err := store.OpenObject(ctx, watermarkObjectName(suffix))
+	if err != nil {
+		return nil, fmt.Errorf("open watermark %q: %w", suffix, err)
+	}
+	defer reader.Close()
+
+	watermark := &Watermark{}
+	if err := json.NewDecoder(reader).Decode(watermark); err != nil {
+		return nil, fmt.Errorf("decode watermark %q: %w", suffix, err)
+	}
+	return watermark, nil
+}
+
+type watermarkBlock struct {
+	num  uint64
+	id   string
+	time time.Time
+}
+
+// watermarkWriter writes the highest block uploaded, on the upload of one block files and merged
+// bundles. The blocks archived are kept until uploaded to know the last block of a merged bundle.
+type watermarkWriter struct {
+	store      dstore.Store
+	suffix     string
+	bundleSize uint64
+	options    WatermarkOptions
+	logger     *zap.Logger
+	now        func() time.Time
+
+	lock      sync.Mutex
+	archived  []watermarkBlock // not uploaded yet, by block number
+	current   *Watermark
+	written   bool
+	writtenAt uint64 // block number of the last watermark written
+	startedAt time.Time
+
+	writeLock sync.Mutex
+	trigger   chan struct{}
+}
+
+func newWatermarkWriter(store dstore.Store, suffix string, bundleSize uint64, options WatermarkOptions, logger *zap.Logger) *watermarkWriter {
+	return &watermarkWriter{
+		store:      store,
+		suffix:     suffix,
+		bundleSize: bundleSize,
+		options:    options,
+		logger:     logger,
+		now:        time.Now,
+		trigger:    make(chan struct{}, 1),
+	}
+}
+
+func (w *watermarkWriter) archivedBlock(block *bstream.Block) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.archived) > 0 && block.Number <= w.archived[len(w.archived)-1].num {
+		return // forked or replayed block, the watermark only moves forward
+	}
+	if len(w.archived) >= maxWatermarkArchivedBlocks {
+		w.archived = w.archived[1:]
+	}
+	w.archived = append(w.archived, watermarkBlock{num: block.Number, id: block.Id, time: block.Time()})
+}
+
+// uploaded is the `FileUploaderOnUploaded` of both uploaders, `filename` is a one block file name
+// or the base block number of a merged bundle
+func (w *watermarkWriter) uploaded(filename string) {
+	if baseNum, err := parseMergedBundleName(filename); err == nil {
+		w.advance(watermarkBlock{num: baseNum + w.bundleSize - 1}, baseNum)
+		return
+	}
+
+	num, blockTime, idSuffix, _, _, _, err := bundle.ParseFilename(path.Base(filename))
+	if err != nil {
+		return
+	}
+	w.advance(watermarkBlock{num: num, id: idSuffix, time: blockTime}, num)
+}
+
+func parseMergedBundleName(filename string) (uint64, error) {
+	if len(filename) != blockFileNumDigits {
+		return 0, fmt.Errorf("not a merged bundle name")
+	}
+	return blockFileNum(filename)
+}
+
+// advance moves the watermark to the highest block archived between `lowest` and `upTo`, to
+// `upTo` itself when none was, the archived blocks up to it no longer being needed
+func (w *watermarkWriter) advance(upTo watermarkBlock, lowest uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	block := upTo
+	kept := w.archived[:0]
+	for _, archived := range w.archived {
+		switch {
+		case archived.num > upTo.num:
+			kept = append(kept, archived)
+		case archived.num >= lowest:
+			block = archived
+		}
+	}
+	w.archived = kept
+
+	if w.current != nil && block.num <= w.current.BlockNum {
+		return
+	}
+	w.current = &Watermark{BlockNum: block.num, BlockID: block.id, BlockTime: block.time, Suffix: w.suffix}
+
+	if w.options.EveryBlocks > 0 && (!w.written || block.num >= w.writtenAt+w.options.EveryBlocks) {
+		select {
+		case w.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *watermarkWriter) run(ctx context.Context, terminating <-chan struct{}) {
+	w.lock.Lock()
+	w.startedAt = w.now()
+	w.lock.Unlock()
+
+	var tick <-chan time.Time
+	if w.options.Interval > 0 {
+		ticker := time.NewTicker(w.options.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-terminating:
+			return
+		case <-tick:
+		case <-w.trigger:
+		}
+		w.write(ctx)
+	}
+}
+
+// write writes the watermark when it moved since the last one written, failures are only logged
+func (w *watermarkWriter) write(ctx context.Context) {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	w.lock.Lock()
+	if w.current == nil || (w.written && w.current.BlockNum == w.writtenAt) {
+		w.lock.Unlock()
+		return
+	}
+	watermark := *w.current
+	watermark.StartedAt = w.startedAt
+	watermark.WrittenAt = w.now()
+	w.lock.Unlock()
+
+	content, err := json.Marshal(watermark)
+	if err != nil {
+		w.logger.Debug("unable to encode watermark", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, watermarkWriteTimeout)
+	defer cancel()
+	if err := w.store.WriteObject(ctx, watermarkObjectName(w.suffix), bytes.NewReader(content)); err != nil {
+		w.logger.Debug("unable to write watermark", zap.Uint64("block_num", watermark.BlockNum), zap.Error(err))
+		return
+	}
+
+	w.lock.Lock()
+	w.written = true
+	w.writtenAt = watermark.BlockNum
+	w.lock.Unlock()
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger/bundle"
+	"github.com/streamingfast/node-manager/dstorefault"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWatermarkTestStores(t *testing.T) (oneBlocks, watermarks dstore.Store) {
+	t.Helper()
+
+	oneBlocks, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+	require.NoError(t, err)
+	watermarks, err = WatermarkStore(oneBlocks)
+	require.NoError(t, err)
+	return oneBlocks, watermarks
+}
+
+func TestWatermarkWriter_ConcurrentSuffixes(t *testing.T) {
+	_, store := newWatermarkTestStores(t)
+	generator := mindreadertest.NewBlockGenerator("watermark", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	suffixes := []string{"mindreader_a", "mindreader_b", "mindreader_c"}
+	wg := sync.WaitGroup{}
+	for i, suffix := range suffixes {
+		wg.Add(1)
+		go func(lastBlock uint64, suffix string) {
+			defer wg.Done()
+
+			w := newWatermarkWriter(store, suffix, 100, WatermarkOptions{EveryBlocks: 1}, testLogger)
+			ctx, cancel := context.WithCancel(context.Background())
+			terminating := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				w.run(ctx, terminating)
+				close(done)
+			}()
+
+			for _, block := range generator.Blocks(1, int(lastBlock)) {
+				w.uploaded(bundle.BlockFileNameWithSuffix(block, suffix))
+			}
+			close(terminating)
+			<-done
+			cancel()
+			w.write(context.Background())
+		}(uint64(100+i*10), suffix)
+	}
+	wg.Wait()
+
+	for i, suffix := range suffixes {
+		watermark, err := ReadWatermark(context.Background(), store, suffix)
+		require.NoError(t, err)
+
+		lastBlock := generator.Block(uint64(100+i*10), 0)
+		assert.Equal(t, uint64(100+i*10), watermark.BlockNum)
+		assert.Contains(t, lastBlock.Id, watermark.BlockID, "ID suffix of the one block file name")
+		assert.Equal(t, lastBlock.Time().Truncate(time.Second), watermark.BlockTime.UTC())
+		assert.Equal(t, suffix, watermark.Suffix)
+	}
+
+	_, err := ReadWatermark(context.Background(), store, "unknown")
+	assert.ErrorIs(t, err, dstore.ErrNotFound)
+}
+
+func TestWatermarkWriter_MergedBundle(t *testing.T) {
+	_, store := newWatermarkTestStores(t)
+	generator := mindreadertest.NewBlockGenerator("merged", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	now := time.Date(2021, 7, 28, 11, 0, 0, 0, time.UTC)
+	w := newWatermarkWriter(store, "suffix", 100, WatermarkOptions{Interval: time.Minute}, testLogger)
+	w.now = func() time.Time { return now }
+	w.startedAt = now.Add(-time.Hour)
+
+	for _, block := range generator.Blocks(100, 110) {
+		if block.Number != 199 { // the bundle's last block may be before its upper boundary
+			w.archivedBlock(block)
+		}
+	}
+	w.uploaded("0000000100")
+	w.write(context.Background())
+
+	watermark, err := ReadWatermark(context.Background(), store, "suffix")
+	require.NoError(t, err)
+	assert.Equal(t, &Watermark{
+		BlockNum:  198,
+		BlockID:   generator.ID(198),
+		BlockTime: generator.Block(198, 0).Time(),
+		Suffix:    "suffix",
+		StartedAt: now.Add(-time.Hour),
+		WrittenAt: now,
+	}, normalizedWatermark(watermark))
+	assert.Len(t, w.archived, 10, "blocks of the next bundle kept")
+
+	w.uploaded(bundle.BlockFileNameWithSuffix(generator.Block(150, 0), "suffix"))
+	assert.Equal(t, uint64(198), w.current.BlockNum, "never moves back")
+
+	w.uploaded("0000000200")
+	assert.Equal(t, uint64(209), w.current.BlockNum)
+	assert.Equal(t, generator.ID(209), w.current.BlockID)
+	assert.Empty(t, w.archived)
+}
+
+func normalizedWatermark(w *Watermark) *Watermark {
+	w.BlockTime = w.BlockTime.UTC()
+	w.StartedAt = w.StartedAt.UTC()
+	w.WrittenAt = w.WrittenAt.UTC()
+	return w
+}
+
+func TestWatermarkWriter_WriteFailures(t *testing.T) {
+	_, store := newWatermarkTestStores(t)
+	failing := dstorefault.Wrap(store, dstorefault.FaultPolicy{Faults: []dstorefault.Fault{{Operations: []dstorefault.Operation{dstorefault.WriteObject}, Times: 1}}})
+	generator := mindreadertest.NewBlockGenerator("failing", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+
+	w := newWatermarkWriter(failing, "suffix", 100, WatermarkOptions{Interval: time.Minute}, testLogger)
+	w.uploaded(bundle.BlockFileNameWithSuffix(generator.Block(10, 0), "suffix"))
+
+	w.write(context.Background())
+	_, err := ReadWatermark(context.Background(), store, "suffix")
+	assert.ErrorIs(t, err, dstore.ErrNotFound, "failed write only logged")
+
+	w.write(context.Background())
+	watermark, err := ReadWatermark(context.Background(), store, "suffix")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), watermark.BlockNum, "written again on next attempt")
+
+	w.write(context.Background())
+	assert.Equal(t, 2, failing.Calls(dstorefault.WriteObject), "unchanged watermark not written again")
+}
+
+func TestMindReaderPlugin_Watermark(t *testing.T) {
+	defer func(factory bstream.BlockWriterFactory) { bstream.GetBlockWriterFactory = factory }(bstream.GetBlockWriterFactory)
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(writer io.Writer) (bstream.BlockWriter, error) {
+		return bstream.NewDBinBlockWriter(writer, "TST", 1)
+	})
+
+	for _, mergeThreshold := range []string{"always", "never"} {
+		t.Run(fmt.Sprintf("merge %s", mergeThreshold), func(t *testing.T) {
+			oneBlocks, watermarks := newWatermarkTestStores(t)
+			mergeArchiveStore, err := dstore.NewStore(t.TempDir(), "dbin.zst", "", false)
+			require.NoError(t, err)
+
+			consoleReaderFactory := func(ctx ConsoleReaderContext) (ConsolerReader, error) {
+				return mindreadertest.NewConsoleReader(ctx.Lines), nil
+			}
+			p, err := NewMindReaderPluginWithStores(oneBlocks, mergeArchiveStore, mergeThreshold, t.TempDir(), consoleReaderFactory, 0, 250, 10, nil, func(error) {}, 5*time.Second, "suffix", nil, testLogger, testTracer,
+				WithWatermark(WatermarkOptions{EveryBlocks: 10}),
+				WithUploadPollInterval(10*time.Millisecond),
+			)
+			require.NoError(t, err)
+
+			p.Launch()
+			generator := mindreadertest.NewBlockGenerator("plugin", time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC))
+			for _, line := range mindreadertest.FormatLines(generator.Blocks(100, 151)) {
+				p.LogLine(line)
+			}
+
+			select {
+			case <-p.Terminating():
+			case <-time.After(5 * time.Second):
+				t.Fatal("plugin not shut down after stop block")
+			}
+			p.Stop()
+
+			watermark, err := ReadWatermark(context.Background(), watermarks, "suffix")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(250), watermark.BlockNum)
+			assert.Equal(t, "suffix", watermark.Suffix)
+			assert.False(t, watermark.StartedAt.IsZero())
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/streamingfast/dmetrics"
+	"github.com/streamingfast/node-manager/metrics"
 	"go.uber.org/atomic"
 )
 
@@ -15,6 +16,7 @@ type MetricsAndReadinessManager struct {
 	headBlockChan      chan *headBlock
 	headBlockTimeDrift *dmetrics.HeadTimeDrift
 	headBlockNumber    *dmetrics.HeadBlockNum
+	headBlockLibNumber *metrics.HeadBlockLibNum
 	readinessProbe     *atomic.Bool
 
 	// ReadinessMaxLatency is the max delta between head block time and
@@ -22,12 +24,13 @@ type MetricsAndReadinessManager struct {
 	readinessMaxLatency time.Duration
 }
 
-func NewMetricsAndReadinessManager(headBlockTimeDrift *dmetrics.HeadTimeDrift, headBlockNumber *dmetrics.HeadBlockNum, readinessMaxLatency time.Duration) *MetricsAndReadinessManager {
+func NewMetricsAndReadinessManager(headBlockTimeDrift *dmetrics.HeadTimeDrift, headBlockNumber *dmetrics.HeadBlockNum, headBlockLibNumber *metrics.HeadBlockLibNum, readinessMaxLatency time.Duration) *MetricsAndReadinessManager {
 	return &MetricsAndReadinessManager{
 		headBlockChan:       make(chan *headBlock, 1), // just for non-blocking, saving a few nanoseconds here
 		readinessProbe:      atomic.NewBool(false),
 		headBlockTimeDrift:  headBlockTimeDrift,
 		headBlockNumber:     headBlockNumber,
+		headBlockLibNumber:  headBlockLibNumber,
 		readinessMaxLatency: readinessMaxLatency,
 	}
 }
@@ -65,6 +68,9 @@ func (m *MetricsAndReadinessManager) Launch() {
 		if m.headBlockNumber != nil {
 			m.headBlockNumber.SetUint64(lastSeenBlock.Num)
 		}
+		if m.headBlockLibNumber != nil && lastSeenBlock.LibNum != 0 { // LIB is unknown to some chains, 0 means not available
+			m.headBlockLibNumber.SetUint64(lastSeenBlock.LibNum)
+		}
 
 		if lastSeenBlock.Time.IsZero() { // never act upon zero timestamps
 			continue
@@ -82,18 +88,22 @@ func (m *MetricsAndReadinessManager) Launch() {
 	}
 }
 
-func (m *MetricsAndReadinessManager) UpdateHeadBlock(num uint64, ID string, t time.Time) {
+func (m *MetricsAndReadinessManager) UpdateHeadBlock(num uint64, ID string, t time.Time, libNum uint64) {
 	m.headBlockChan <- &headBlock{
-		ID:   ID,
-		Num:  num,
-		Time: t,
+		ID:     ID,
+		Num:    num,
+		Time:   t,
+		LibNum: libNum,
 	}
 }
 
 type headBlock struct {
-	ID   string
-	Num  uint64
-	Time time.Time
+	ID     string
+	Num    uint64
+	Time   time.Time
+	LibNum uint64
 }
 
-type HeadBlockUpdater func(uint64, string, time.Time)
+// HeadBlockUpdater receives the head block number, ID and time along with its last
+// irreversible block number, which is 0 when it is not known.
+type HeadBlockUpdater func(num uint64, id string, t time.Time, libNum uint64)
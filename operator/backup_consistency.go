@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"fmt"
+
+	nodeManager "github.com/streamingfast/node-manager"
+)
+
+// BackupConsistency is how the operator keeps the data consistent while a backup module that does
+// not require the node to be stopped runs, see `BackupSchedule.Consistency`. The node is always
+// stopped for the modules requiring it.
+type BackupConsistency string
+
+const (
+	// BackupConsistencyNone runs the backup with the node and the mindreader untouched, the default
+	BackupConsistencyNone BackupConsistency = "none"
+
+	// BackupConsistencyQuiesce pauses the uploads of the mindreader while the backup runs, the
+	// in-flight ones are completed first, so its working directory is stable while it is
+	// snapshotted. The node keeps running and blocks keep flowing to the live block stream.
+	BackupConsistencyQuiesce BackupConsistency = "quiesce"
+
+	// BackupConsistencyMaintenance stops the node while the backup runs, as for the modules
+	// requiring it
+	BackupConsistencyMaintenance BackupConsistency = "maintenance"
+)
+
+func parseBackupConsistency(in string) (BackupConsistency, error) {
+	switch consistency := BackupConsistency(in); consistency {
+	case "":
+		return BackupConsistencyNone, nil
+	case BackupConsistencyNone, BackupConsistencyQuiesce, BackupConsistencyMaintenance:
+		return consistency, nil
+	}
+	return "", fmt.Errorf("invalid backup consistency %q, must be one of none, quiesce or maintenance", in)
+}
+
+// RegisterUploadPauser makes the backups with the `quiesce` consistency pause the uploads of
+// `pauser`, usually the mindreader plugin, see `BackupConsistencyQuiesce`
+func (o *Operator) RegisterUploadPauser(pauser nodeManager.UploadPauser) {
+	o.uploadPauser = pauser
+}
+
+// backupConsistency returns the consistency of the backup of `mod` requested by `params`: the one
+// of its schedule, or of the `consistency` param when not scheduled. It is always
+// `BackupConsistencyMaintenance` when `mod` requires the node to be stopped.
+func (o *Operator) backupConsistency(mod BackupModule, params map[string]string) (BackupConsistency, error) {
+	if mod.RequiresStop() {
+		return BackupConsistencyMaintenance, nil
+	}
+	if sched := o.scheduleFromParams(params); sched != nil {
+		return parseBackupConsistency(string(sched.Consistency))
+	}
+	return parseBackupConsistency(params["consistency"])
+}
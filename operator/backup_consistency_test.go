@@ -0,0 +1,131 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type liveBackupModule struct {
+	log *eventLog
+}
+
+func (m *liveBackupModule) Backup(_ uint32) (string, error) {
+	m.log.add("backup")
+	return "backup", nil
+}
+
+func (m *liveBackupModule) RequiresStop() bool { return false }
+
+type fakeUploadPauser struct {
+	log *eventLog
+}
+
+func (p *fakeUploadPauser) PauseUploads()  { p.log.add("pause uploads") }
+func (p *fakeUploadPauser) ResumeUploads() { p.log.add("resume uploads") }
+
+func TestOperator_BackupConsistency(t *testing.T) {
+	cases := []struct {
+		name        string
+		consistency BackupConsistency
+		requireStop bool
+		expected    []string
+	}{
+		{"default runs alongside the node", "", false, []string{"backup"}},
+		{"none", BackupConsistencyNone, false, []string{"backup"}},
+		{"quiesce pauses uploads only", BackupConsistencyQuiesce, false, []string{"pause uploads", "backup", "resume uploads"}},
+		{"maintenance stops the node", BackupConsistencyMaintenance, false, []string{"stop node", "backup", "start node"}},
+		{"module requiring stop always stops the node", BackupConsistencyQuiesce, true, []string{"stop node", "backup", "start node"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			log := &eventLog{}
+			node := newFakeSuperviser("node", log)
+			o, err := New(zap.NewNop(), node, nil, &Options{})
+			require.NoError(t, err)
+			o.RegisterUploadPauser(&fakeUploadPauser{log: log})
+			require.NoError(t, node.Start())
+			log.reset()
+
+			var mod BackupModule = &liveBackupModule{log: log}
+			if tc.requireStop {
+				mod = &fakeBackupModule{log: log}
+			}
+			require.NoError(t, o.RegisterBackupModule("mod", mod))
+			o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "mod", Consistency: tc.consistency})
+
+			require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "mod", "schedule": "0"}}))
+			assert.Equal(t, tc.expected, log.reset())
+		})
+	}
+}
+
+func TestOperator_ManualBackupConsistency(t *testing.T) {
+	log := &eventLog{}
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", log), nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule("mod", &liveBackupModule{log: log}))
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"consistency": "quiesce"}}))
+	assert.Equal(t, []string{"backup"}, log.reset(), "backup run without pausing when no pauser is registered")
+
+	o.RegisterUploadPauser(&fakeUploadPauser{log: log})
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"consistency": "quiesce"}}))
+	assert.Equal(t, []string{"pause uploads", "backup", "resume uploads"}, log.reset())
+
+	cmd := &Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"consistency": "snapshot"}}
+	require.NoError(t, o.runCommand(cmd))
+	assert.EqualError(t, cmd.err, `invalid backup consistency "snapshot", must be one of none, quiesce or maintenance`)
+	assert.Empty(t, log.reset())
+}
+
+func TestOperator_ScheduledBackupRecordsMaintenance(t *testing.T) {
+	log := &eventLog{}
+	node := newFakeSuperviser("node", log)
+	o, err := New(zap.NewNop(), node, nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, node.Start())
+	require.NoError(t, o.RegisterBackupModule("mod", &fakeBackupModule{log: log}))
+	o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "mod", BlocksBetweenRuns: 1000})
+
+	// Metrics are global, only their change during the test is checked
+	requests := func() float64 {
+		return testutil.ToFloat64(metrics.MaintenanceRequests.Native().WithLabelValues(nodeManager.MaintenanceSourceBackupSchedule))
+	}
+	requestsBefore := requests()
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "mod"}}))
+	assert.Empty(t, o.MaintenanceHistory(), "manual backups are not recorded as maintenance")
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "mod", "schedule": "0"}}))
+	history := o.MaintenanceHistory()
+	require.Len(t, history, 2)
+	assert.True(t, history[0].InMaintenance)
+	assert.Equal(t, "scheduled backup mod (every-1000-blocks)", history[0].Reason)
+	assert.False(t, history[1].InMaintenance)
+	for _, transition := range history {
+		assert.Equal(t, nodeManager.MaintenanceSourceBackupSchedule, transition.Source)
+	}
+	assert.Equal(t, requestsBefore+1, requests())
+	assert.True(t, node.IsRunning())
+}
+
+func TestParseBackupConfigs_Consistency(t *testing.T) {
+	factories := map[string]BackupModuleFactory{
+		"live": func(conf BackupModuleConfig) (BackupModule, error) { return &liveBackupModule{}, nil },
+	}
+
+	_, scheds, err := ParseBackupConfigs(zap.NewNop(), []string{"type=live freq-blocks=1000 consistency=quiesce"}, factories)
+	require.NoError(t, err)
+	require.Len(t, scheds, 1)
+	assert.Equal(t, BackupConsistencyQuiesce, scheds[0].Consistency)
+
+	_, _, err = ParseBackupConfigs(zap.NewNop(), []string{"type=live freq-blocks=1000 consistency=always"}, factories)
+	assert.EqualError(t, err, `error setting up backup schedule for "live": invalid backup consistency "always", must be one of none, quiesce or maintenance`)
+}
@@ -0,0 +1,114 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+const defaultBackupHookTimeout = 5 * time.Minute
+
+// PreBackupHook runs before a backup, before the node is stopped or its uploads paused, an
+// error aborting the backup
+type PreBackupHook func(ctx context.Context) error
+
+// PostBackupHook runs once a backup completed, or failed, with the name of the backup and its
+// error
+type PostBackupHook func(ctx context.Context, backupName string, backupErr error) error
+
+type backupHook struct {
+	name string
+	pre  PreBackupHook
+	post PostBackupHook
+}
+
+// BackupHookError is a failure of the `Phase` (pre or post) hook of `Hook`
+type BackupHookError struct {
+	Hook  string
+	Phase string
+	Err   error
+}
+
+func (e *BackupHookError) Error() string {
+	return fmt.Sprintf("%s-backup hook %q failed: %s", e.Phase, e.Hook, e.Err)
+}
+
+func (e *BackupHookError) Unwrap() error {
+	return e.Err
+}
+
+// RegisterBackupHook registers chain specific actions around every backup, like flushing a
+// database or notifying a peer, `pre` or `post` may be nil. Pre-backup hooks run in
+// registration order before the node is stopped, the first failing one aborts the backup.
+// Post-backup hooks run in the reverse order once the backup completed or failed, and after the
+// node was restarted, for every hook whose pre-backup hook ran, even when the backup was
+// aborted. A failing post-backup hook fails the backup command, the other hooks still run.
+// Each hook is bounded by `Options.BackupHookTimeout`.
+func (o *Operator) RegisterBackupHook(name string, pre PreBackupHook, post PostBackupHook) error {
+	for _, hook := range o.backupHooks {
+		if hook.name == name {
+			return fmt.Errorf("backup hook %q is already registered", name)
+		}
+	}
+
+	o.backupHooks = append(o.backupHooks, &backupHook{name: name, pre: pre, post: post})
+	return nil
+}
+
+func (o *Operator) backupHookContext() (context.Context, context.CancelFunc) {
+	timeout := o.options.BackupHookTimeout
+	if timeout == 0 {
+		timeout = defaultBackupHookTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (o *Operator) backupHookFailed(hook *backupHook, phase string, err error) *BackupHookError {
+	metrics.BackupHookFailures.Inc(hook.name, phase)
+	o.zlogger.Error("backup hook failed", zap.String("hook", hook.name), zap.String("phase", phase), zap.Error(err))
+	return &BackupHookError{Hook: hook.name, Phase: phase, Err: err}
+}
+
+// runPreBackupHooks runs the pre-backup hooks until one fails, returning the hooks that ran
+// successfully, whose post-backup hooks must run
+func (o *Operator) runPreBackupHooks() ([]*backupHook, error) {
+	var ran []*backupHook
+	for _, hook := range o.backupHooks {
+		if hook.pre != nil {
+			ctx, cancel := o.backupHookContext()
+			err := hook.pre(ctx)
+			cancel()
+			if err != nil {
+				return ran, o.backupHookFailed(hook, "pre", err)
+			}
+		}
+		ran = append(ran, hook)
+	}
+	return ran, nil
+}
+
+// runPostBackupHooks runs the post-backup hooks of `hooks`, in reverse order, returning the first
+// failure
+func (o *Operator) runPostBackupHooks(hooks []*backupHook, backupName string, backupErr error) error {
+	var firstErr error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if hook.post == nil {
+			continue
+		}
+
+		ctx, cancel := o.backupHookContext()
+		err := hook.post(ctx, backupName, backupErr)
+		cancel()
+		if err != nil {
+			hookErr := o.backupHookFailed(hook, "post", err)
+			if firstErr == nil {
+				firstErr = hookErr
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,106 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func registerLoggingBackupHook(t *testing.T, o *Operator, log *eventLog, name string, preErr, postErr error) {
+	t.Helper()
+
+	require.NoError(t, o.RegisterBackupHook(name,
+		func(ctx context.Context) error {
+			log.add("pre " + name)
+			return preErr
+		},
+		func(ctx context.Context, backupName string, backupErr error) error {
+			log.add(fmt.Sprintf("post %s %q %v", name, backupName, backupErr))
+			return postErr
+		},
+	))
+}
+
+func newBackupHooksTestOperator(t *testing.T, mod func(log *eventLog) BackupModule) (*Operator, *eventLog) {
+	t.Helper()
+
+	log := &eventLog{}
+	node := newFakeSuperviser("node", log)
+	o := newTestOperator(t, node, &Options{})
+	require.NoError(t, o.RegisterBackupModule("mod", mod(log)))
+	startTestOperator(t, o, log)
+	return o, log
+}
+
+func TestOperator_BackupHooks(t *testing.T) {
+	o, log := newBackupHooksTestOperator(t, func(log *eventLog) BackupModule { return &fakeBackupModule{log: log} })
+	registerLoggingBackupHook(t, o, log, "db", nil, nil)
+	registerLoggingBackupHook(t, o, log, "peer", nil, nil)
+	assert.EqualError(t, o.RegisterBackupHook("db", nil, nil), `backup hook "db" is already registered`)
+
+	cmd := &Command{cmd: "backup", logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd))
+	assert.NoError(t, cmd.err)
+	assert.Equal(t, []string{
+		"pre db",
+		"pre peer",
+		"stop node",
+		"backup",
+		"start node",
+		`post peer "backup" <nil>`,
+		`post db "backup" <nil>`,
+	}, log.reset())
+}
+
+func TestOperator_BackupHooksOnBackupFailure(t *testing.T) {
+	o, log := newBackupHooksTestOperator(t, func(log *eventLog) BackupModule { return failingBackupModule{} })
+	registerLoggingBackupHook(t, o, log, "db", nil, nil)
+
+	assert.EqualError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger}), "disk full")
+	assert.Equal(t, []string{"pre db", `post db "" disk full`}, log.reset())
+}
+
+func TestOperator_PreBackupHookAbortsBackup(t *testing.T) {
+	o, log := newBackupHooksTestOperator(t, func(log *eventLog) BackupModule { return &fakeBackupModule{log: log} })
+	registerLoggingBackupHook(t, o, log, "db", nil, nil)
+	registerLoggingBackupHook(t, o, log, "scheduler", errors.New("scheduler busy"), nil)
+	registerLoggingBackupHook(t, o, log, "peer", nil, nil)
+
+	cmd := &Command{cmd: "backup", logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd), "aborted backup is returned to the caller only")
+	assert.EqualError(t, cmd.err, `backup aborted: pre-backup hook "scheduler" failed: scheduler busy`)
+
+	var hookErr *BackupHookError
+	require.True(t, errors.As(cmd.err, &hookErr))
+	assert.Equal(t, "scheduler", hookErr.Hook)
+	assert.Equal(t, "pre", hookErr.Phase)
+
+	assert.Equal(t, []string{
+		"pre db",
+		"pre scheduler",
+		`post db "" pre-backup hook "scheduler" failed: scheduler busy`,
+	}, log.reset(), "node not stopped, only the hooks that ran are unwound")
+}
+
+func TestOperator_PostBackupHookFailure(t *testing.T) {
+	o, log := newBackupHooksTestOperator(t, func(log *eventLog) BackupModule { return &fakeBackupModule{log: log} })
+	registerLoggingBackupHook(t, o, log, "db", nil, errors.New("db not resumed"))
+	registerLoggingBackupHook(t, o, log, "peer", nil, errors.New("peer unreachable"))
+
+	cmd := &Command{cmd: "backup", logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd))
+	assert.EqualError(t, cmd.err, `backup "backup" completed: post-backup hook "peer" failed: peer unreachable`)
+	assert.Equal(t, []string{
+		"pre db",
+		"pre peer",
+		"stop node",
+		"backup",
+		"start node",
+		`post peer "backup" <nil>`,
+		`post db "backup" <nil>`,
+	}, log.reset(), "every post-backup hook runs")
+}
@@ -0,0 +1,134 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// BackupNameLatest restores the most recently created backup
+	BackupNameLatest = "latest"
+
+	// BackupNameBeforeBlockPrefix, followed by a block number, restores the backup with the
+	// highest block number below it (e.g. `before-block:1000`)
+	BackupNameBeforeBlockPrefix = "before-block:"
+)
+
+// resolveBackupName returns the name of the backup of `mod` to restore for `name`, resolving
+// `latest` and `before-block:<num>` through the list of backups of a `ListableBackupModule`.
+// Other names are returned as is, and so is `latest` for modules that cannot list their backups,
+// leaving its resolution to the module.
+func (o *Operator) resolveBackupName(mod BackupModule, name string) (string, error) {
+	if name != BackupNameLatest && !strings.HasPrefix(name, BackupNameBeforeBlockPrefix) {
+		return name, nil
+	}
+
+	listable, ok := mod.(ListableBackupModule)
+	if !ok {
+		if name == BackupNameLatest {
+			return name, nil
+		}
+		return "", fmt.Errorf("backup module cannot list backups, unable to resolve backup %q", name)
+	}
+
+	backups, err := listable.List()
+	if err != nil {
+		return "", fmt.Errorf("listing backups to resolve backup %q: %w", name, err)
+	}
+
+	resolved, err := selectBackup(backups, name)
+	if err != nil {
+		return "", err
+	}
+
+	o.zlogger.Info("resolved backup to restore", zap.String("requested", name), zap.String("backup_name", resolved.Name), zap.Time("created_at", resolved.CreatedAt), zap.Uint64("block_num", resolved.BlockNum))
+	return resolved.Name, nil
+}
+
+// selectBackup returns the most recently created backup for `latest`, and the backup with the
+// highest block number below `num` for `before-block:<num>`, the most recently created one among
+// backups at the same block. Backups of unknown block number never qualify for the latter.
+func selectBackup(backups []BackupInfo, name string) (BackupInfo, error) {
+	if name == BackupNameLatest {
+		if len(backups) == 0 {
+			return BackupInfo{}, fmt.Errorf("no backup to restore")
+		}
+
+		latest := backups[0]
+		for _, backup := range backups[1:] {
+			if backup.CreatedAt.After(latest.CreatedAt) {
+				latest = backup
+			}
+		}
+		return latest, nil
+	}
+
+	beforeBlock, err := strconv.ParseUint(strings.TrimPrefix(name, BackupNameBeforeBlockPrefix), 10, 64)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("invalid block number in backup name %q: %w", name, err)
+	}
+
+	var found *BackupInfo
+	for i, backup := range backups {
+		if backup.BlockNum == 0 || backup.BlockNum >= beforeBlock {
+			continue
+		}
+		if found == nil || backup.BlockNum > found.BlockNum || (backup.BlockNum == found.BlockNum && backup.CreatedAt.After(found.CreatedAt)) {
+			found = &backups[i]
+		}
+	}
+	if found == nil {
+		return BackupInfo{}, fmt.Errorf("no backup with a known block number before block %d", beforeBlock)
+	}
+	return *found, nil
+}
+
+// ListBackups returns the backups of the module named `optionalName`, or of the only registered
+// one, most recently created first
+func (o *Operator) ListBackups(optionalName string) (string, []BackupInfo, error) {
+	modName, mod, err := selectBackupModule(o.backupModules, optionalName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	listable, ok := mod.(ListableBackupModule)
+	if !ok {
+		return modName, nil, fmt.Errorf("backup module %q cannot list backups", modName)
+	}
+
+	backups, err := listable.List()
+	if err != nil {
+		return modName, nil, fmt.Errorf("listing backups of module %q: %w", modName, err)
+	}
+
+	sorted := make([]BackupInfo, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.After(sorted[j].CreatedAt) })
+	return modName, sorted, nil
+}
+
+type listBackupsResponse struct {
+	Module  string       `json:"module"`
+	Backups []BackupInfo `json:"backups"`
+}
+
+func (o *Operator) backupsHandler(w http.ResponseWriter, r *http.Request) {
+	modName, backups, err := o.ListBackups(r.FormValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out, err := json.Marshal(listBackupsResponse{Module: modName, Backups: backups})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,102 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeListableRestorableBackupModule struct {
+	fakeRestorableBackupModule
+	backups []BackupInfo
+}
+
+func (m *fakeListableRestorableBackupModule) List() ([]BackupInfo, error) { return m.backups, nil }
+
+var listedBackups = []BackupInfo{
+	{Name: "b-1000", CreatedAt: time.Date(2021, 7, 1, 3, 0, 0, 0, time.UTC), BlockNum: 1000},
+	{Name: "b-3000", CreatedAt: time.Date(2021, 7, 3, 3, 0, 0, 0, time.UTC), BlockNum: 3000},
+	{Name: "b-unknown", CreatedAt: time.Date(2021, 7, 4, 3, 0, 0, 0, time.UTC)},
+	{Name: "b-2000", CreatedAt: time.Date(2021, 7, 2, 3, 0, 0, 0, time.UTC), BlockNum: 2000},
+}
+
+func TestOperator_RestoreResolvesBackupName(t *testing.T) {
+	cases := []struct {
+		name        string
+		backupName  string
+		listable    bool
+		expected    string
+		expectedErr string
+	}{
+		{"latest", "latest", true, "restore b-unknown", ""},
+		{"before block", "before-block:3000", true, "restore b-2000", ""},
+		{"before block above every backup", "before-block:10000", true, "restore b-3000", ""},
+		{"before block without qualifying backup", "before-block:1000", true, "", "no backup with a known block number before block 1000"},
+		{"invalid before block", "before-block:abc", true, "", `invalid block number in backup name "before-block:abc"`},
+		{"explicit name", "b-1000", true, "restore b-1000", ""},
+		{"latest not listable", "latest", false, "restore latest", ""},
+		{"explicit name not listable", "b-1000", false, "restore b-1000", ""},
+		{"before block not listable", "before-block:3000", false, "", `backup module cannot list backups, unable to resolve backup "before-block:3000"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			log := &eventLog{}
+			o, err := New(zap.NewNop(), newFakeSuperviser("node", log), nil, &Options{})
+			require.NoError(t, err)
+
+			var mod BackupModule = &fakeRestorableBackupModule{fakeBackupModule{log: log}}
+			if c.listable {
+				mod = &fakeListableRestorableBackupModule{fakeRestorableBackupModule{fakeBackupModule{log: log}}, listedBackups}
+			}
+			require.NoError(t, o.RegisterBackupModule("fake", mod))
+
+			cmd := &Command{cmd: "restore", logger: o.zlogger, params: map[string]string{"backupName": c.backupName}}
+			require.NoError(t, o.runCommand(cmd))
+
+			if c.expectedErr != "" {
+				require.Error(t, cmd.err)
+				assert.Contains(t, cmd.err.Error(), c.expectedErr)
+				assert.Empty(t, log.reset(), "nothing is restored")
+				return
+			}
+
+			require.NoError(t, cmd.err)
+			assert.Contains(t, log.reset(), c.expected)
+		})
+	}
+}
+
+func TestOperator_BackupsHandler(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule("listable", &fakeListableRestorableBackupModule{backups: listedBackups}))
+	require.NoError(t, o.RegisterBackupModule("plain", &fakeBackupModule{log: &eventLog{}}))
+
+	recorder := httptest.NewRecorder()
+	o.backupsHandler(recorder, httptest.NewRequest("GET", "/v1/backups?name=listable", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, map[string]interface{}{
+		"module": "listable",
+		"backups": []interface{}{
+			map[string]interface{}{"name": "b-unknown", "created_at": "2021-07-04T03:00:00Z", "block_num": float64(0)},
+			map[string]interface{}{"name": "b-3000", "created_at": "2021-07-03T03:00:00Z", "block_num": float64(3000)},
+			map[string]interface{}{"name": "b-2000", "created_at": "2021-07-02T03:00:00Z", "block_num": float64(2000)},
+			map[string]interface{}{"name": "b-1000", "created_at": "2021-07-01T03:00:00Z", "block_num": float64(1000)},
+		},
+	}, response)
+
+	recorder = httptest.NewRecorder()
+	o.backupsHandler(recorder, httptest.NewRequest("GET", "/v1/backups?name=plain", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "backup module \"plain\" cannot list backups\n", recorder.Body.String())
+}
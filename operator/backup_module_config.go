@@ -0,0 +1,133 @@
+package operator
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupModuleConfigError is returned by the `BackupModuleConfig` accessors and `Validate`, it
+// names the backup module (the config `type`) and the offending key
+type BackupModuleConfigError struct {
+	Module string
+	Key    string
+	Err    error
+}
+
+func (e *BackupModuleConfigError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("backup module %q config: %s", e.Module, e.Err)
+	}
+	return fmt.Sprintf("backup module %q config key %q: %s", e.Module, e.Key, e.Err)
+}
+
+func (e *BackupModuleConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Module returns the name of the backup module configured by `c`, its `type` key
+func (c BackupModuleConfig) Module() string {
+	return c["type"]
+}
+
+func (c BackupModuleConfig) errorf(key string, format string, args ...interface{}) error {
+	return &BackupModuleConfigError{Module: c.Module(), Key: key, Err: fmt.Errorf(format, args...)}
+}
+
+// Validate returns an error listing every `required` key missing, or empty, from `c`
+func (c BackupModuleConfig) Validate(required []string) error {
+	var missing []string
+	for _, key := range required {
+		if c[key] == "" {
+			missing = append(missing, strconv.Quote(key))
+		}
+	}
+
+	if len(missing) > 0 {
+		return c.errorf("", "missing required key(s) %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// GetString returns the value of `key`, `defaultValue` when missing or empty
+func (c BackupModuleConfig) GetString(key string, defaultValue string) string {
+	if value := c[key]; value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GetInt returns the value of `key` parsed as an integer, `defaultValue` when missing or empty
+func (c BackupModuleConfig) GetInt(key string, defaultValue int) (int, error) {
+	value := c[key]
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	out, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, c.errorf(key, "invalid integer %q", value)
+	}
+	return out, nil
+}
+
+// GetDuration returns the value of `key` parsed with `time.ParseDuration`, `defaultValue` when
+// missing or empty
+func (c BackupModuleConfig) GetDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := c[key]
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	out, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, c.errorf(key, "invalid duration %q (e.g. 30s, 5m or 12h)", value)
+	}
+	return out, nil
+}
+
+// GetBool returns the value of `key` parsed with `strconv.ParseBool`, `defaultValue` when missing
+// or empty
+func (c BackupModuleConfig) GetBool(key string, defaultValue bool) (bool, error) {
+	value := c[key]
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	out, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, c.errorf(key, "invalid boolean %q, must be true or false", value)
+	}
+	return out, nil
+}
+
+// GetURL returns the value of `key`, or `defaultValue` when missing or empty, parsed as an
+// absolute URL. It returns nil when both are empty.
+func (c BackupModuleConfig) GetURL(key string, defaultValue string) (*url.URL, error) {
+	value := c.GetString(key, defaultValue)
+	if value == "" {
+		return nil, nil
+	}
+
+	out, err := url.Parse(value)
+	if err != nil {
+		return nil, c.errorf(key, "invalid URL %q: %w", value, err)
+	}
+	if out.Scheme == "" {
+		return nil, c.errorf(key, "invalid URL %q, missing scheme (e.g. file:// or gs://)", value)
+	}
+	return out, nil
+}
+
+// ValidatedBackupModuleFactory returns a factory validating that `requiredKeys` are set before
+// calling `f`
+func ValidatedBackupModuleFactory(requiredKeys []string, f BackupModuleFactory) BackupModuleFactory {
+	return func(conf BackupModuleConfig) (BackupModule, error) {
+		if err := conf.Validate(requiredKeys); err != nil {
+			return nil, err
+		}
+		return f(conf)
+	}
+}
@@ -0,0 +1,97 @@
+package operator
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBackupModuleConfig_Accessors(t *testing.T) {
+	conf := BackupModuleConfig{
+		"type":     "fake",
+		"count":    "12",
+		"interval": "5m",
+		"enabled":  "false",
+		"store":    "gs://bucket/backups",
+		"bad":      "abc",
+	}
+
+	assert.Equal(t, "fake", conf.GetString("type", ""))
+	assert.Equal(t, "default", conf.GetString("missing", "default"))
+
+	count, err := conf.GetInt("count", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 12, count)
+	count, err = conf.GetInt("missing", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	interval, err := conf.GetDuration("interval", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, interval)
+
+	enabled, err := conf.GetBool("enabled", true)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	store, err := conf.GetURL("store", "")
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", store.Host)
+	store, err = conf.GetURL("missing", "")
+	require.NoError(t, err)
+	assert.Nil(t, store)
+
+	_, err = conf.GetInt("bad", 1)
+	assert.EqualError(t, err, `backup module "fake" config key "bad": invalid integer "abc"`)
+	_, err = conf.GetDuration("bad", time.Second)
+	assert.Error(t, err)
+	_, err = conf.GetBool("bad", true)
+	assert.Error(t, err)
+	_, err = conf.GetURL("bad", "")
+	assert.EqualError(t, err, `backup module "fake" config key "bad": invalid URL "abc", missing scheme (e.g. file:// or gs://)`)
+}
+
+func TestBackupModuleConfig_Validate(t *testing.T) {
+	conf := BackupModuleConfig{"type": "fake", "store": "file:///tmp", "empty": ""}
+
+	assert.NoError(t, conf.Validate([]string{"type", "store"}))
+	assert.EqualError(t, conf.Validate([]string{"store", "empty", "missing"}), `backup module "fake" config: missing required key(s) "empty", "missing"`)
+}
+
+func TestOperator_RegisterBackupModuleFactory(t *testing.T) {
+	o := &Operator{zlogger: zap.NewNop()}
+
+	var created []BackupModuleConfig
+	require.NoError(t, o.RegisterBackupModuleFactory("fake", []string{"store"}, func(conf BackupModuleConfig) (BackupModule, error) {
+		if _, err := conf.GetDuration("timeout", 0); err != nil {
+			return nil, err
+		}
+		if conf["fail"] != "" {
+			return nil, errors.New("unable to reach store")
+		}
+
+		created = append(created, conf)
+		return &fakeBackupModule{}, nil
+	}))
+	assert.Error(t, o.RegisterBackupModuleFactory("fake", nil, nil), "already registered")
+
+	err := o.ConfigureBackups([]string{"type=fake freq-blocks=1000"})
+	assert.EqualError(t, err, `backup module "fake" config: missing required key(s) "store"`)
+	assert.Empty(t, created, "factory not called on invalid config")
+
+	err = o.ConfigureBackups([]string{"type=fake store=file:///tmp timeout=abc"})
+	assert.EqualError(t, err, `backup module "fake" config key "timeout": invalid duration "abc" (e.g. 30s, 5m or 12h)`)
+
+	err = o.ConfigureBackups([]string{"type=fake store=file:///tmp fail=true"})
+	assert.EqualError(t, err, `backup module "fake" factory: unable to reach store`)
+
+	require.NoError(t, o.ConfigureBackups([]string{"type=fake store=file:///tmp freq-blocks=1000"}))
+	assert.Len(t, created, 1)
+	assert.Contains(t, o.backupModules, "fake")
+	require.Len(t, o.backupSchedules, 1)
+	assert.Equal(t, uint64(1000), o.backupSchedules[0].BlocksBetweenRuns)
+}
@@ -0,0 +1,221 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const backupSchedulesStateFilename = "backup-schedules.json"
+
+// backupScheduleState is the last successful backup of a block-based schedule, persisted in the
+// operator's working directory so the schedule survives restarts. A zero `LastBackupTime` means
+// `LastBackupBlockNum` is the baseline taken when the schedule first ran without a known backup.
+type backupScheduleState struct {
+	LastBackupBlockNum uint64    `json:"last_backup_block_num"`
+	LastBackupTime     time.Time `json:"last_backup_time"`
+}
+
+// blockSchedule is an enabled block-based backup schedule, evaluated on every head block update
+type blockSchedule struct {
+	index     int
+	key       string
+	sched     *BackupSchedule
+	reference uint64      // block num of the last backup, zero until the first evaluation
+	postponed atomic.Bool // set when a backup failed or was dropped, waits another full range before retrying
+}
+
+func newBlockSchedule(index int, sched *BackupSchedule) *blockSchedule {
+	return &blockSchedule{
+		index: index,
+		key:   fmt.Sprintf("%s/every-%d-blocks", sched.BackuperName, sched.BlocksBetweenRuns),
+		sched: sched,
+	}
+}
+
+// UpdateHeadBlock evaluates the block-based backup schedules against the node's head block, and
+// feeds the head block drift of the readiness check, it is a `nodeManager.HeadBlockUpdater` to
+// chain with the one given to the mindreader plugin. Until it is called, block-based schedules fall
+// back to polling the superviser's last seen block every second.
+func (o *Operator) UpdateHeadBlock(num uint64, id string, t time.Time, libNum uint64) {
+	if o.readinessChecker != nil {
+		o.readinessChecker.updateHeadBlock(num, t)
+	}
+	o.headBlockUpdated.Store(true)
+	o.evaluateBlockSchedules(num)
+}
+
+func (o *Operator) pollBlockSchedules() {
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-time.After(time.Second):
+		}
+
+		if o.headBlockUpdated.Load() {
+			return
+		}
+		o.evaluateBlockSchedules(o.Superviser.LastSeenBlockNum())
+	}
+}
+
+// evaluateBlockSchedules queues a backup for each block-based schedule with at least `BlocksBetweenRuns`
+// blocks since its last backup and no backup already pending or running
+func (o *Operator) evaluateBlockSchedules(headBlockNum uint64) {
+	if headBlockNum == 0 {
+		return
+	}
+
+	o.blockSchedulesLock.Lock()
+	defer o.blockSchedulesLock.Unlock()
+
+	for _, bs := range o.blockSchedules {
+		if bs.reference == 0 {
+			o.initBlockScheduleReference(bs, headBlockNum)
+		}
+
+		if bs.postponed.CAS(true, false) {
+			bs.reference = headBlockNum
+			continue
+		}
+
+		if headBlockNum < bs.reference || headBlockNum-bs.reference < bs.sched.BlocksBetweenRuns {
+			continue
+		}
+
+		params := map[string]string{"name": bs.sched.BackuperName, "schedule": strconv.Itoa(bs.index)}
+		if o.hasQueuedCommand("backup", params) {
+			continue
+		}
+
+		o.zlogger.Info("block-based backup schedule reached",
+			zap.String("backuper_name", bs.sched.BackuperName),
+			zap.Uint64("head_block_num", headBlockNum),
+			zap.Uint64("last_backup_block_num", bs.reference),
+			zap.Uint64("blocks_between_runs", bs.sched.BlocksBetweenRuns),
+		)
+
+		// Queued right away so the next head block sees it pending, the head block update path must not block on the command channel
+		cmd := &Command{cmd: "backup", logger: o.zlogger, params: params, initiator: CommandInitiatorSchedule}
+		o.queueCommand(cmd)
+		go o.sendQueuedCommand(cmd)
+	}
+}
+
+// initBlockScheduleReference must be called with the block schedules lock held
+func (o *Operator) initBlockScheduleReference(bs *blockSchedule, headBlockNum uint64) {
+	if state, found := o.backupSchedulesState[bs.key]; found {
+		bs.reference = state.LastBackupBlockNum
+		return
+	}
+
+	o.zlogger.Info("no previous backup known for block-based schedule, using head block as baseline",
+		zap.String("schedule", bs.key),
+		zap.Uint64("head_block_num", headBlockNum),
+	)
+	bs.reference = headBlockNum
+	o.backupSchedulesState[bs.key] = &backupScheduleState{LastBackupBlockNum: headBlockNum}
+	o.saveBackupSchedulesState()
+}
+
+// recordScheduledBackup persists a successful backup at `blockNum` of the schedule in `params`, if block-based
+func (o *Operator) recordScheduledBackup(params map[string]string, blockNum uint64) {
+	o.blockSchedulesLock.Lock()
+	defer o.blockSchedulesLock.Unlock()
+
+	bs := o.blockScheduleFromParams(params)
+	if bs == nil {
+		return
+	}
+
+	bs.reference = blockNum
+	o.backupSchedulesState[bs.key] = &backupScheduleState{LastBackupBlockNum: blockNum, LastBackupTime: time.Now()}
+	o.saveBackupSchedulesState()
+}
+
+// postponeScheduledBackup is called with the commands lock held, it must not take the block schedules lock
+func (o *Operator) postponeScheduledBackup(params map[string]string) {
+	if bs := o.blockScheduleFromParams(params); bs != nil {
+		bs.postponed.Store(true)
+	}
+}
+
+func (o *Operator) blockScheduleFromParams(params map[string]string) *blockSchedule {
+	index, err := strconv.Atoi(params["schedule"])
+	if err != nil {
+		return nil
+	}
+
+	for _, bs := range o.blockSchedules {
+		if bs.index == index {
+			return bs
+		}
+	}
+	return nil
+}
+
+func (o *Operator) backupSchedulesStateFile() string {
+	if o.options.WorkingDirectory == "" {
+		return ""
+	}
+	return filepath.Join(o.options.WorkingDirectory, backupSchedulesStateFilename)
+}
+
+func (o *Operator) loadBackupSchedulesState() {
+	o.backupSchedulesState = map[string]*backupScheduleState{}
+
+	file := o.backupSchedulesStateFile()
+	if file == "" {
+		return
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			o.zlogger.Warn("unable to read backup schedules state, block-based schedules start from the head block", zap.String("file", file), zap.Error(err))
+		}
+		return
+	}
+
+	if err := json.Unmarshal(content, &o.backupSchedulesState); err != nil {
+		o.zlogger.Warn("invalid backup schedules state, block-based schedules start from the head block", zap.String("file", file), zap.Error(err))
+		o.backupSchedulesState = map[string]*backupScheduleState{}
+	}
+}
+
+// saveBackupSchedulesState must be called with the block schedules lock held, failures are logged
+func (o *Operator) saveBackupSchedulesState() {
+	file := o.backupSchedulesStateFile()
+	if file == "" {
+		return
+	}
+
+	if err := writeFileAtomically(file, o.backupSchedulesState); err != nil {
+		o.zlogger.Warn("unable to save backup schedules state", zap.String("file", file), zap.Error(err))
+	}
+}
+
+func writeFileAtomically(file string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	tempFile := file + ".tmp"
+	if err := ioutil.WriteFile(tempFile, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, file)
+}
@@ -0,0 +1,83 @@
+package operator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingBackupModule struct{}
+
+func (failingBackupModule) Backup(_ uint32) (string, error) { return "", fmt.Errorf("disk full") }
+func (failingBackupModule) RequiresStop() bool              { return false }
+
+func newBlockScheduleTestOperator(t *testing.T, workingDir string, mod BackupModule) (*Operator, *fakeSuperviser) {
+	t.Helper()
+
+	node := newFakeSuperviser("node", &eventLog{})
+	o := newTestOperator(t, node, &Options{WorkingDirectory: workingDir})
+	require.NoError(t, o.RegisterBackupModule("fake", mod))
+	o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "fake", BlocksBetweenRuns: 100})
+	o.LaunchBackupSchedules()
+	return o, node
+}
+
+func pendingCommandNames(o *Operator) (out []string) {
+	for _, c := range o.PendingCommands() {
+		out = append(out, c.Name)
+	}
+	return
+}
+
+func runNextCommand(t *testing.T, o *Operator, node *fakeSuperviser, headBlockNum uint64) {
+	t.Helper()
+	node.lastSeenBlockNum = headBlockNum
+	o.executeCommand(<-o.commandChan)
+}
+
+func TestOperator_BlockScheduleFollowsHeadBlock(t *testing.T) {
+	workingDir := t.TempDir()
+	o, node := newBlockScheduleTestOperator(t, workingDir, &fakeBackupModule{log: &eventLog{}})
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+
+	o.UpdateHeadBlock(1000, "", time.Time{}, 0)
+	assert.Empty(t, pendingCommandNames(o), "no state file, head block is the baseline")
+
+	o.UpdateHeadBlock(1099, "", time.Time{}, 0)
+	assert.Empty(t, pendingCommandNames(o))
+
+	o.UpdateHeadBlock(1100, "", time.Time{}, 0)
+	o.UpdateHeadBlock(1150, "", time.Time{}, 0)
+	assert.Equal(t, []string{"backup"}, pendingCommandNames(o), "a single backup is queued")
+
+	runNextCommand(t, o, node, 1150)
+	o.UpdateHeadBlock(1200, "", time.Time{}, 0)
+	assert.Empty(t, pendingCommandNames(o), "counted from the backed up block")
+
+	// Restarting the operator resumes from the persisted backup
+	o, node = newBlockScheduleTestOperator(t, workingDir, &fakeBackupModule{log: &eventLog{}})
+	o.UpdateHeadBlock(1249, "", time.Time{}, 0)
+	assert.Empty(t, pendingCommandNames(o))
+
+	o.UpdateHeadBlock(1250, "", time.Time{}, 0)
+	assert.Equal(t, []string{"backup"}, pendingCommandNames(o))
+}
+
+func TestOperator_BlockScheduleFailedBackupWaitsFullRange(t *testing.T) {
+	o, node := newBlockScheduleTestOperator(t, "", failingBackupModule{})
+
+	o.UpdateHeadBlock(1000, "", time.Time{}, 0)
+	o.UpdateHeadBlock(1100, "", time.Time{}, 0)
+	require.Equal(t, []string{"backup"}, pendingCommandNames(o))
+
+	runNextCommand(t, o, node, 1100)
+	o.UpdateHeadBlock(1101, "", time.Time{}, 0)
+	o.UpdateHeadBlock(1199, "", time.Time{}, 0)
+	assert.Empty(t, pendingCommandNames(o))
+
+	o.UpdateHeadBlock(1201, "", time.Time{}, 0)
+	assert.Equal(t, []string{"backup"}, pendingCommandNames(o))
+}
@@ -0,0 +1,131 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrScheduleHostnameMismatch is wrapped by the `ScheduleValidationError` of a schedule whose
+// required hostname does not match the current hostname. The schedule is disabled on this host,
+// it does not fail the operator's launch, replicas usually leave backups to one of them.
+var ErrScheduleHostnameMismatch = errors.New("hostname does not match the required hostname, schedule disabled on this host")
+
+// ScheduleValidationError is a misconfiguration of the backup schedule at `Index` in the
+// registration order, see `Operator.ValidateSchedules`
+type ScheduleValidationError struct {
+	Index        int
+	BackuperName string
+	Err          error
+}
+
+func (e *ScheduleValidationError) Error() string {
+	return fmt.Sprintf("backup schedule #%d (backuper %q): %s", e.Index, e.BackuperName, e.Err)
+}
+
+func (e *ScheduleValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateSchedules checks every registered backup schedule against the registered backup
+// modules: its backuper name must resolve to a module (an empty one only when a single module is
+// registered), it must run (`BlocksBetweenRuns`, `TimeBetweenRuns` above one second or a valid
+// `CronExpression`) and its required hostname must be a valid pattern matching the current
+// hostname, a mismatch being reported as `ErrScheduleHostnameMismatch`. It is called by `Launch`,
+// which fails on any error other than a hostname mismatch.
+func (o *Operator) ValidateSchedules() []error {
+	hostname, hostnameErr := os.Hostname()
+
+	var errs []error
+	for i, sched := range o.backupSchedules {
+		invalid := func(err error) {
+			errs = append(errs, &ScheduleValidationError{Index: i, BackuperName: sched.BackuperName, Err: err})
+		}
+
+		if _, _, err := selectBackupModule(o.backupModules, sched.BackuperName); err != nil {
+			invalid(err)
+		}
+
+		if sched.CronExpression != "" {
+			if _, err := parseCronExpression(sched.CronExpression); err != nil {
+				invalid(err)
+			}
+		}
+		switch {
+		case sched.TimeBetweenRuns > 0 && sched.TimeBetweenRuns <= time.Second:
+			invalid(fmt.Errorf("time between runs %s must be above 1s", sched.TimeBetweenRuns))
+		case sched.TimeBetweenRuns == 0 && sched.BlocksBetweenRuns == 0 && sched.CronExpression == "":
+			invalid(fmt.Errorf("schedule never runs, set blocks between runs, time between runs or a cron expression"))
+		}
+
+		if sched.RequiredHostnameMatch != "" {
+			if hostnameErr != nil {
+				invalid(fmt.Errorf("unable to check required hostname: %w", hostnameErr))
+				continue
+			}
+			matched, err := MatchHostname(sched.RequiredHostnameMatch, hostname)
+			switch {
+			case err != nil:
+				invalid(err)
+			case !matched:
+				invalid(fmt.Errorf("hostname %q, required %q: %w", hostname, sched.RequiredHostnameMatch, ErrScheduleHostnameMismatch))
+			}
+		}
+	}
+	return errs
+}
+
+// validateSchedulesOnLaunch logs the schedules disabled on this host and fails on the
+// misconfigured ones
+func (o *Operator) validateSchedulesOnLaunch() error {
+	var failures []error
+	for _, err := range o.ValidateSchedules() {
+		if errors.Is(err, ErrScheduleHostnameMismatch) {
+			o.zlogger.Info("backup schedule disabled on this host", zap.Error(err))
+			continue
+		}
+		o.zlogger.Error("invalid backup schedule", zap.Error(err))
+		failures = append(failures, err)
+	}
+
+	switch len(failures) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("invalid backup schedule: %w", failures[0])
+	}
+	return fmt.Errorf("%d invalid backup schedules, first one: %w", len(failures), failures[0])
+}
+
+// isDryRunBackup reports if the backup of `params` only logs what it would do, requested with
+// the `dry-run=true` param or by a schedule with `DryRun` set
+func (o *Operator) isDryRunBackup(params map[string]string) bool {
+	if params["dry-run"] == "true" {
+		return true
+	}
+	sched := o.scheduleFromParams(params)
+	return sched != nil && sched.DryRun
+}
+
+// dryRunBackup logs the backup `cmd` would run, without stopping the node, running the backup
+// hooks or calling the backup module. A block-based schedule still moves to its next run.
+func (o *Operator) dryRunBackup(cmd *Command, modName string, mod BackupModule, consistency BackupConsistency) {
+	lastSeenBlockNum := o.Superviser.LastSeenBlockNum()
+	cmd.logger.Info("dry run, not running backup",
+		zap.String("backup_module", modName),
+		zap.Bool("requires_stop", mod.RequiresStop()),
+		zap.String("consistency", string(consistency)),
+		zap.Uint64("last_seen_block_num", lastSeenBlockNum),
+		zap.String("schedule", o.backupScheduleLabel(cmd.params)),
+		zap.Int("pre_backup_hooks", len(o.backupHooks)),
+	)
+
+	o.blockSchedulesLock.Lock()
+	defer o.blockSchedulesLock.Unlock()
+	if bs := o.blockScheduleFromParams(cmd.params); bs != nil {
+		bs.reference = lastSeenBlockNum
+	}
+}
@@ -0,0 +1,132 @@
+package operator
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_ValidateSchedules(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	newOperator := func(t *testing.T, modNames []string, scheds ...*BackupSchedule) *Operator {
+		t.Helper()
+
+		o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+		require.NoError(t, err)
+		for _, name := range modNames {
+			require.NoError(t, o.RegisterBackupModule(name, &fakeBackupModule{log: &eventLog{}}))
+		}
+		for _, sched := range scheds {
+			o.RegisterBackupSchedule(sched)
+		}
+		return o
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		o := newOperator(t, []string{"fake", "other"},
+			&BackupSchedule{BackuperName: "fake", BlocksBetweenRuns: 1000},
+			&BackupSchedule{BackuperName: "other", TimeBetweenRuns: time.Hour, RequiredHostnameMatch: hostname},
+			&BackupSchedule{BackuperName: "other", CronExpression: "0 3 * * *", RequiredHostnameMatch: "~.*"},
+		)
+		assert.Empty(t, o.ValidateSchedules())
+		assert.NoError(t, o.validateSchedulesOnLaunch())
+	})
+
+	t.Run("single module default", func(t *testing.T) {
+		o := newOperator(t, []string{"fake"}, &BackupSchedule{BlocksBetweenRuns: 1000})
+		assert.Empty(t, o.ValidateSchedules())
+
+		o = newOperator(t, []string{"fake", "other"}, &BackupSchedule{BlocksBetweenRuns: 1000})
+		errs := o.ValidateSchedules()
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "more than one module registered, and none specified")
+	})
+
+	t.Run("missing module", func(t *testing.T) {
+		o := newOperator(t, []string{"fake"},
+			&BackupSchedule{BackuperName: "fake", BlocksBetweenRuns: 1000},
+			&BackupSchedule{BackuperName: "fkae", BlocksBetweenRuns: 1000},
+		)
+		errs := o.ValidateSchedules()
+		require.Len(t, errs, 1)
+
+		var validationErr *ScheduleValidationError
+		require.True(t, errors.As(errs[0], &validationErr))
+		assert.Equal(t, 1, validationErr.Index)
+		assert.Equal(t, "fkae", validationErr.BackuperName)
+		assert.EqualError(t, errs[0], `backup schedule #1 (backuper "fkae"): invalid backup module: fkae`)
+
+		err := o.validateSchedulesOnLaunch()
+		require.Error(t, err)
+		assert.True(t, errors.As(err, &validationErr))
+	})
+
+	t.Run("hostname mismatch", func(t *testing.T) {
+		o := newOperator(t, []string{"fake"}, &BackupSchedule{BackuperName: "fake", BlocksBetweenRuns: 1000, RequiredHostnameMatch: "not-" + hostname})
+		errs := o.ValidateSchedules()
+		require.Len(t, errs, 1)
+		assert.True(t, errors.Is(errs[0], ErrScheduleHostnameMismatch))
+		assert.Contains(t, errs[0].Error(), hostname)
+
+		assert.NoError(t, o.validateSchedulesOnLaunch(), "schedule disabled on this host, not failing the launch")
+	})
+
+	t.Run("invalid hostname pattern", func(t *testing.T) {
+		o := newOperator(t, []string{"fake"}, &BackupSchedule{BackuperName: "fake", BlocksBetweenRuns: 1000, RequiredHostnameMatch: "~("})
+		errs := o.ValidateSchedules()
+		require.Len(t, errs, 1)
+		assert.False(t, errors.Is(errs[0], ErrScheduleHostnameMismatch))
+		assert.Error(t, o.validateSchedulesOnLaunch())
+	})
+
+	t.Run("never runs", func(t *testing.T) {
+		o := newOperator(t, []string{"fake"},
+			&BackupSchedule{BackuperName: "fake"},
+			&BackupSchedule{BackuperName: "fake", TimeBetweenRuns: time.Second},
+			&BackupSchedule{BackuperName: "fake", CronExpression: "0 3 * *"},
+		)
+		errs := o.ValidateSchedules()
+		require.Len(t, errs, 3)
+		assert.Contains(t, errs[0].Error(), "schedule never runs")
+		assert.Contains(t, errs[1].Error(), "must be above 1s")
+		assert.Contains(t, errs[2].Error(), "must have 5 fields")
+		assert.EqualError(t, o.validateSchedulesOnLaunch(), "3 invalid backup schedules, first one: "+errs[0].Error())
+	})
+}
+
+func TestOperator_DryRunBackup(t *testing.T) {
+	log := &eventLog{}
+	o, node := newBlockScheduleTestOperator(t, t.TempDir(), &fakeBackupModule{log: log})
+	o.backupSchedules[0].DryRun = true
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+
+	o.UpdateHeadBlock(1000, "", time.Time{}, 0)
+	o.UpdateHeadBlock(1100, "", time.Time{}, 0)
+	require.Equal(t, []string{"backup"}, pendingCommandNames(o))
+	runNextCommand(t, o, node, 1100)
+
+	assert.Empty(t, log.reset(), "backup module not called")
+	assert.True(t, node.IsRunning(), "node not stopped")
+
+	o.UpdateHeadBlock(1150, "", time.Time{}, 0)
+	assert.Empty(t, pendingCommandNames(o), "schedule moved to its next run")
+	o.UpdateHeadBlock(1200, "", time.Time{}, 0)
+	assert.Equal(t, []string{"backup"}, pendingCommandNames(o))
+
+	t.Run("dry-run param", func(t *testing.T) {
+		o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+		require.NoError(t, err)
+		log := &eventLog{}
+		require.NoError(t, o.RegisterBackupModule("fake", &fakeBackupModule{log: log}))
+
+		require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "fake", "dry-run": "true"}}))
+		assert.Empty(t, log.reset())
+	})
+}
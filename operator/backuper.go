@@ -1,12 +1,18 @@
 package operator
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"path"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/streamingfast/node-manager/metrics"
 	"go.uber.org/zap"
 )
 
@@ -19,16 +25,145 @@ type BackupModule interface {
 	RequiresStop() bool
 }
 
+// BackupModuleV2 is preferred by the operator over `BackupModule.Backup` when implemented,
+// it receives the full last seen block number instead of one saturated at `math.MaxUint32`.
+type BackupModuleV2 interface {
+	BackupModule
+	BackupV2(lastSeenBlockNum uint64) (string, error)
+}
+
+// runBackup calls `BackupV2` when implemented, `Backup` otherwise with the block number
+// saturated at `math.MaxUint32`
+func runBackup(mod BackupModule, lastSeenBlockNum uint64) (string, error) {
+	if v2, ok := mod.(BackupModuleV2); ok {
+		return v2.BackupV2(lastSeenBlockNum)
+	}
+
+	return mod.Backup(saturateUint32(lastSeenBlockNum))
+}
+
+// runRecordedBackup runs a backup of `mod`, registered as `modName`, recording its outcome and
+// duration, labeled by `schedule`, see `metrics.ObserveBackup`
+func (o *Operator) runRecordedBackup(modName string, mod BackupModule, schedule string, lastSeenBlockNum uint64) (string, error) {
+	start := time.Now()
+	o.setRunningBackup(mod)
+	backupName, err := runBackup(mod, lastSeenBlockNum)
+	o.setRunningBackup(nil)
+	completedAt := time.Now()
+	metrics.ObserveBackup(modName, schedule, completedAt.Sub(start), completedAt, err)
+	return backupName, err
+}
+
+func saturateUint32(in uint64) uint32 {
+	if in > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(in)
+}
+
 type RestorableBackupModule interface {
 	BackupModule
 	Restore(name string) error
 }
 
+// ForcibleRestoreModule is implemented by backup modules refusing some restores unless forced,
+// the operator restores through `RestoreForced` with the `force` restore param
+type ForcibleRestoreModule interface {
+	RestorableBackupModule
+	RestoreForced(name string, force bool) error
+}
+
+// restoreBackup calls `RestoreForced` when implemented, `Restore` otherwise
+func restoreBackup(mod RestorableBackupModule, name string, force bool) error {
+	if forcible, ok := mod.(ForcibleRestoreModule); ok {
+		return forcible.RestoreForced(name, force)
+	}
+	return mod.Restore(name)
+}
+
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	BlockNum  uint64    `json:"block_num"` // last seen block number when the backup was taken, 0 when unknown
+}
+
+// DescribableBackupModule is implemented by backup modules exposing the metadata of a backup,
+// the block number of a backup is checked against the continuity checker before restoring it.
+type DescribableBackupModule interface {
+	BackupModule
+	Info(name string) (BackupInfo, error)
+}
+
+// ListableBackupModule is implemented by backup modules able to list their backups, they are
+// served by the `/v1/backups` endpoint and can restore `latest` or `before-block:<num>`, see
+// `resolveBackupName`.
+type ListableBackupModule interface {
+	BackupModule
+	List() ([]BackupInfo, error)
+}
+
+// PrunableBackupModule is implemented by backup modules able to list and delete their
+// backups, the retention policy of a schedule is only applied to those modules.
+type PrunableBackupModule interface {
+	ListableBackupModule
+	Delete(name string) error
+}
+
+// RetentionPolicy retains the `KeepLast` most recent backups as well as all backups created
+// within `KeepWithin`, other backups are deleted after each successful scheduled backup.
+// The zero value (the default) retains everything.
+type RetentionPolicy struct {
+	KeepLast   int
+	KeepWithin time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.KeepWithin > 0
+}
+
+// toPrune returns the backups that are not retained by the policy, `protected` is never part of it
+func (p RetentionPolicy) toPrune(backups []BackupInfo, protected string, now time.Time) (out []BackupInfo) {
+	if !p.enabled() {
+		return nil
+	}
+
+	sorted := make([]BackupInfo, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.After(sorted[j].CreatedAt) })
+
+	for i, backup := range sorted {
+		if backup.Name == protected || i < p.KeepLast {
+			continue
+		}
+
+		if p.KeepWithin > 0 && now.Sub(backup.CreatedAt) <= p.KeepWithin {
+			continue
+		}
+
+		out = append(out, backup)
+	}
+	return
+}
+
 type BackupSchedule struct {
-	BlocksBetweenRuns     int
+	BlocksBetweenRuns     uint64
 	TimeBetweenRuns       time.Duration
-	RequiredHostnameMatch string // will not run backup if !empty env.Hostname != HostnameMatch
+	CronExpression        string // 5-field cron expression evaluated in UTC (e.g. `0 3 * * *`), see `parseCronExpression`
+	RequiredHostnameMatch string // will not run backup if !empty and env.Hostname does not match it, see `MatchHostname`
 	BackuperName          string // must match id of backupModule
+	RetentionPolicy       RetentionPolicy
+
+	// DisruptiveOnlyInWindow defers the backups of this schedule to the maintenance windows when
+	// the backup module requires the node to be stopped, see `Operator.SetMaintenanceWindows`
+	DisruptiveOnlyInWindow bool
+
+	// Consistency applies to backup modules that do not require the node to be stopped, see
+	// `BackupConsistency`, `BackupConsistencyNone` when empty
+	Consistency BackupConsistency
+
+	// DryRun makes the scheduled backups log the module and the last seen block number they
+	// would back up, without running them, to validate the schedule
+	DryRun bool
 }
 
 func (o *Operator) RegisterBackupModule(name string, mod BackupModule) error {
@@ -44,21 +179,129 @@ func (o *Operator) RegisterBackupModule(name string, mod BackupModule) error {
 	return nil
 }
 
+// RegisterBackupModuleFactory registers the factory of the `name` backup module type, used by
+// `ConfigureBackups`. The config keys in `requiredKeys` are validated before `f` is called, so a
+// misconfigured module fails the operator's startup instead of its first backup.
+func (o *Operator) RegisterBackupModuleFactory(name string, requiredKeys []string, f BackupModuleFactory) error {
+	if o.backupModuleFactories == nil {
+		o.backupModuleFactories = make(map[string]BackupModuleFactory)
+	}
+
+	if _, found := o.backupModuleFactories[name]; found {
+		return fmt.Errorf("backup module factory %q is already registered", name)
+	}
+
+	o.backupModuleFactories[name] = ValidatedBackupModuleFactory(requiredKeys, f)
+	return nil
+}
+
+// ConfigureBackups instantiates, with the factories registered through `RegisterBackupModuleFactory`,
+// the backup modules and schedules of `backupConfigs` (see `ParseBackupConfigs`) and registers them
+func (o *Operator) ConfigureBackups(backupConfigs []string) error {
+	mods, scheds, err := ParseBackupConfigs(o.zlogger, backupConfigs, o.backupModuleFactories)
+	if err != nil {
+		return err
+	}
+
+	for name, mod := range mods {
+		if err := o.RegisterBackupModule(name, mod); err != nil {
+			return err
+		}
+	}
+	for _, sched := range scheds {
+		o.RegisterBackupSchedule(sched)
+	}
+	return nil
+}
+
+// backupModuleFactoryError attaches the module name to `err`, unless it is a `BackupModuleConfigError`
+// already naming it
+func backupModuleFactoryError(module string, err error) error {
+	var configErr *BackupModuleConfigError
+	if errors.As(err, &configErr) && configErr.Module == module {
+		return err
+	}
+	return fmt.Errorf("backup module %q factory: %w", module, err)
+}
+
 func (o *Operator) RegisterBackupSchedule(sched *BackupSchedule) {
 	o.backupSchedules = append(o.backupSchedules, sched)
 }
 
-func selectBackupModule(mods map[string]BackupModule, optionalName string) (BackupModule, error) {
+// applyRetentionPolicy deletes the backups of `mod` not retained by `policy`, never deleting
+// `justCreated`. Failures are logged, they do not fail the backup.
+func (o *Operator) applyRetentionPolicy(mod BackupModule, policy RetentionPolicy, justCreated string) {
+	if !policy.enabled() {
+		return
+	}
+
+	prunable, ok := mod.(PrunableBackupModule)
+	if !ok {
+		o.zlogger.Debug("backup module cannot list and delete backups, not applying retention policy")
+		return
+	}
+
+	backups, err := prunable.List()
+	if err != nil {
+		o.zlogger.Warn("unable to list backups, not applying retention policy", zap.Error(err))
+		return
+	}
+
+	for _, backup := range policy.toPrune(backups, justCreated, time.Now()) {
+		if err := prunable.Delete(backup.Name); err != nil {
+			o.zlogger.Warn("unable to delete backup", zap.String("backup_name", backup.Name), zap.Error(err))
+			continue
+		}
+
+		o.zlogger.Info("pruned backup", zap.String("backup_name", backup.Name), zap.Time("created_at", backup.CreatedAt), zap.Int("keep_last", policy.KeepLast), zap.Duration("keep_within", policy.KeepWithin))
+	}
+}
+
+func (o *Operator) scheduleFromParams(params map[string]string) *BackupSchedule {
+	index, err := strconv.Atoi(params["schedule"])
+	if err != nil || index < 0 || index >= len(o.backupSchedules) {
+		return nil
+	}
+	return o.backupSchedules[index]
+}
+
+// backupScheduleLabel is the schedule metrics label of the backup of `params`, a description of
+// its schedule (e.g. `every-1000-blocks`), `metrics.BackupScheduleManual` when not scheduled
+func (o *Operator) backupScheduleLabel(params map[string]string) string {
+	sched := o.scheduleFromParams(params)
+	if sched == nil {
+		return metrics.BackupScheduleManual
+	}
+
+	var parts []string
+	if sched.BlocksBetweenRuns > 0 {
+		parts = append(parts, fmt.Sprintf("every-%d-blocks", sched.BlocksBetweenRuns))
+	}
+	if sched.TimeBetweenRuns > 0 {
+		parts = append(parts, fmt.Sprintf("every-%s", sched.TimeBetweenRuns))
+	}
+	if sched.CronExpression != "" {
+		parts = append(parts, "cron-"+strings.Join(strings.Fields(sched.CronExpression), "_"))
+	}
+	if len(parts) == 0 {
+		return "schedule-" + params["schedule"]
+	}
+	return strings.Join(parts, "-or-")
+}
+
+// selectBackupModule returns the module named `optionalName`, or the only registered one, with
+// its name
+func selectBackupModule(mods map[string]BackupModule, optionalName string) (string, BackupModule, error) {
 	if len(mods) == 0 {
-		return nil, fmt.Errorf("no registered backup modules")
+		return "", nil, fmt.Errorf("no registered backup modules")
 	}
 
 	if optionalName != "" {
 		chosen, ok := mods[optionalName]
 		if !ok {
-			return nil, fmt.Errorf("invalid backup module: %s", optionalName)
+			return "", nil, fmt.Errorf("invalid backup module: %s", optionalName)
 		}
-		return chosen, nil
+		return optionalName, chosen, nil
 	}
 
 	if len(mods) > 1 {
@@ -66,13 +309,13 @@ func selectBackupModule(mods map[string]BackupModule, optionalName string) (Back
 		for k := range mods {
 			modNames = append(modNames, k)
 		}
-		return nil, fmt.Errorf("more than one module registered, and none specified (%s)", strings.Join(modNames, ","))
+		return "", nil, fmt.Errorf("more than one module registered, and none specified (%s)", strings.Join(modNames, ","))
 	}
 
-	for _, mod := range mods { // single element in map
-		return mod, nil
+	for name, mod := range mods { // single element in map
+		return name, mod, nil
 	}
-	return nil, fmt.Errorf("impossible path")
+	return "", nil, fmt.Errorf("impossible path")
 
 }
 
@@ -115,7 +358,64 @@ func restorable(in map[string]BackupModule) map[string]RestorableBackupModule {
 	return out
 }
 
-func NewBackupSchedule(freqBlocks, freqTime, requiredHostname, backuperName string) (*BackupSchedule, error) {
+func parseRetentionPolicy(keepLast, keepWithin string) (policy RetentionPolicy, err error) {
+	if keepLast != "" {
+		policy.KeepLast, err = strconv.Atoi(keepLast)
+		if err != nil || policy.KeepLast < 0 {
+			return policy, fmt.Errorf("invalid value for keep-last in backup schedule (err: %v)", err)
+		}
+	}
+
+	if keepWithin != "" {
+		policy.KeepWithin, err = time.ParseDuration(keepWithin)
+		if err != nil || policy.KeepWithin < 0 {
+			return policy, fmt.Errorf("invalid value for keep-within in backup schedule (err: %v)", err)
+		}
+	}
+
+	return policy, nil
+}
+
+// MatchHostname reports if `hostname` matches `pattern`: a pattern starting with `~` is a regular
+// expression matching the whole hostname (e.g. `~backup-.*`), a pattern containing any of `*?[`
+// is a glob (e.g. `mindreader-0*`, see `path.Match`), any other pattern matches exactly.
+func MatchHostname(pattern, hostname string) (bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile("^(?:" + pattern[1:] + ")$")
+		if err != nil {
+			return false, fmt.Errorf("invalid hostname regexp %q: %w", pattern[1:], err)
+		}
+		return re.MatchString(hostname), nil
+	}
+
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, err := path.Match(pattern, hostname)
+		if err != nil {
+			return false, fmt.Errorf("invalid hostname glob %q: %w", pattern, err)
+		}
+		return matched, nil
+	}
+
+	return pattern == hostname, nil
+}
+
+// NewBackupSchedule creates a schedule running every `freqBlocks` blocks, every `freqTime` or at
+// every time matching the `freqCron` cron expression, exactly one of them must be set.
+func NewBackupSchedule(freqBlocks, freqTime, freqCron, requiredHostname, backuperName string) (*BackupSchedule, error) {
+	if _, err := MatchHostname(requiredHostname, ""); err != nil {
+		return nil, fmt.Errorf("invalid value for required-hostname in backup schedule: %w", err)
+	}
+
+	var frequencies int
+	for _, freq := range []string{freqBlocks, freqTime, freqCron} {
+		if freq != "" {
+			frequencies++
+		}
+	}
+	if frequencies > 1 {
+		return nil, fmt.Errorf("backup schedule must define only one of freq-blocks, freq-time and freq-cron")
+	}
+
 	switch {
 	case freqBlocks != "":
 		freqUint, err := strconv.ParseUint(freqBlocks, 10, 64)
@@ -124,7 +424,7 @@ func NewBackupSchedule(freqBlocks, freqTime, requiredHostname, backuperName stri
 		}
 
 		return &BackupSchedule{
-			BlocksBetweenRuns:     int(freqUint),
+			BlocksBetweenRuns:     freqUint,
 			RequiredHostnameMatch: requiredHostname,
 			BackuperName:          backuperName,
 		}, nil
@@ -141,6 +441,17 @@ func NewBackupSchedule(freqBlocks, freqTime, requiredHostname, backuperName stri
 			BackuperName:          backuperName,
 		}, nil
 
+	case freqCron != "":
+		if _, err := parseCronExpression(freqCron); err != nil {
+			return nil, fmt.Errorf("invalid value for freq-cron in backup schedule: %w", err)
+		}
+
+		return &BackupSchedule{
+			CronExpression:        freqCron,
+			RequiredHostnameMatch: requiredHostname,
+			BackuperName:          backuperName,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("schedule created without any frequency value")
 	}
@@ -175,11 +486,33 @@ func ParseBackupConfigs(
 
 		mods[t], err = factory(conf)
 		if err != nil {
-			return nil, nil, fmt.Errorf("backup module %q factory: %w", t, err)
+			return nil, nil, backupModuleFactoryError(t, err)
 		}
 
-		if conf["freq-blocks"] != "" || conf["freq-time"] != "" {
-			newSched, err := NewBackupSchedule(conf["freq-blocks"], conf["freq-time"], conf["required-hostname"], t)
+		// spaces separate the keys, the fields of `freq-cron` are separated by `_` (e.g. `freq-cron=0_3_*_*_*`)
+		freqCron := strings.ReplaceAll(conf["freq-cron"], "_", " ")
+		if conf["freq-blocks"] != "" || conf["freq-time"] != "" || freqCron != "" {
+			newSched, err := NewBackupSchedule(conf["freq-blocks"], conf["freq-time"], freqCron, conf["required-hostname"], t)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error setting up backup schedule for %q: %w", t, err)
+			}
+
+			newSched.RetentionPolicy, err = parseRetentionPolicy(conf["keep-last"], conf["keep-within"])
+			if err != nil {
+				return nil, nil, fmt.Errorf("error setting up backup schedule for %q: %w", t, err)
+			}
+
+			newSched.DisruptiveOnlyInWindow, err = BackupModuleConfig(conf).GetBool("disruptive-only-in-window", false)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			newSched.DryRun, err = BackupModuleConfig(conf).GetBool("dry-run", false)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			newSched.Consistency, err = parseBackupConsistency(conf["consistency"])
 			if err != nil {
 				return nil, nil, fmt.Errorf("error setting up backup schedule for %q: %w", t, err)
 			}
@@ -1,10 +1,17 @@
 package operator
 
 import (
+	"math"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/streamingfast/node-manager/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestParseKVConfigString(t *testing.T) {
@@ -71,3 +78,293 @@ func TestParseKVConfigString(t *testing.T) {
 		})
 	}
 }
+
+func TestRetentionPolicy_ToPrune(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	backups := []BackupInfo{
+		{Name: "d", CreatedAt: now.Add(-4 * time.Hour)},
+		{Name: "a", CreatedAt: now.Add(-1 * time.Hour)},
+		{Name: "c", CreatedAt: now.Add(-3 * time.Hour)},
+		{Name: "b", CreatedAt: now.Add(-2 * time.Hour)},
+	}
+
+	names := func(in []BackupInfo) (out []string) {
+		for _, backup := range in {
+			out = append(out, backup.Name)
+		}
+		return
+	}
+
+	cases := []struct {
+		name      string
+		policy    RetentionPolicy
+		protected string
+		expected  []string
+	}{
+		{"zero keeps everything", RetentionPolicy{}, "", nil},
+		{"keep last", RetentionPolicy{KeepLast: 2}, "", []string{"c", "d"}},
+		{"keep within", RetentionPolicy{KeepWithin: 150 * time.Minute}, "", []string{"c", "d"}},
+		{"keep last or within", RetentionPolicy{KeepLast: 1, KeepWithin: 150 * time.Minute}, "", []string{"c", "d"}},
+		{"keep within more than last", RetentionPolicy{KeepLast: 3, KeepWithin: time.Minute}, "", []string{"d"}},
+		{"protected never pruned", RetentionPolicy{KeepLast: 1}, "d", []string{"b", "c"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, names(tc.policy.toPrune(backups, tc.protected, now)))
+		})
+	}
+}
+
+type fakeListableBackupModule struct {
+	fakeBackupModule
+	backups []BackupInfo
+	deleted []string
+}
+
+func (m *fakeListableBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	// The new backup reports an older creation time than existing ones, it must still be kept
+	m.backups = append(m.backups, BackupInfo{Name: "new", CreatedAt: time.Now().Add(-time.Hour)})
+	return "new", nil
+}
+
+func (m *fakeListableBackupModule) List() ([]BackupInfo, error) { return m.backups, nil }
+func (m *fakeListableBackupModule) Delete(name string) error {
+	m.deleted = append(m.deleted, name)
+	return nil
+}
+
+func TestOperator_ScheduledBackupAppliesRetentionPolicy(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+
+	mod := &fakeListableBackupModule{fakeBackupModule: fakeBackupModule{log: &eventLog{}}, backups: []BackupInfo{
+		{Name: "old", CreatedAt: time.Now().Add(-10 * time.Minute)},
+		{Name: "recent", CreatedAt: time.Now().Add(-time.Minute)},
+	}}
+	require.NoError(t, o.RegisterBackupModule("listable", mod))
+	o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "listable", RetentionPolicy: RetentionPolicy{KeepLast: 1}})
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "listable"}}))
+	assert.Empty(t, mod.deleted, "manual backups do not apply retention")
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "listable", "schedule": "0"}}))
+	assert.Equal(t, []string{"old"}, mod.deleted)
+}
+
+func TestOperator_BackupRecordsMetrics(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, o.RegisterBackupModule("metered", &fakeBackupModule{log: &eventLog{}}))
+	require.NoError(t, o.RegisterBackupModule("failing", failingBackupModule{}))
+	o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "metered", BlocksBetweenRuns: 1000})
+
+	// Metrics are global, only their change during the test is checked
+	runs := metrics.BackupRuns.Native()
+	runsCount := func(labels ...string) float64 { return testutil.ToFloat64(runs.WithLabelValues(labels...)) }
+	failureDuration := func() uint64 {
+		duration := &dto.Metric{}
+		require.NoError(t, metrics.BackupDuration.WithLabelValues("failing", "manual").(prometheus.Metric).Write(duration))
+		return duration.Histogram.GetSampleCount()
+	}
+	manualBefore := runsCount("metered", "manual", "success")
+	scheduledBefore := runsCount("metered", "every-1000-blocks", "success")
+	failureBefore := runsCount("failing", "manual", "failure")
+	failureSuccessBefore := runsCount("failing", "manual", "success")
+	failureDurationBefore := failureDuration()
+	successfulBefore := testutil.ToFloat64(metrics.SuccessfulBackups.Native())
+	before := time.Now()
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "metered"}}))
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "metered", "schedule": "0"}}))
+	assert.Error(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "failing"}}))
+
+	assert.Equal(t, manualBefore+1, runsCount("metered", "manual", "success"))
+	assert.Equal(t, scheduledBefore+1, runsCount("metered", "every-1000-blocks", "success"))
+	assert.Equal(t, failureBefore+1, runsCount("failing", "manual", "failure"))
+	assert.Equal(t, failureSuccessBefore, runsCount("failing", "manual", "success"))
+	assert.Equal(t, successfulBefore+2, testutil.ToFloat64(metrics.SuccessfulBackups.Native()), "deprecated counter still incremented")
+
+	lastSuccess := testutil.ToFloat64(metrics.LastSuccessfulBackupTimestamp.Native().WithLabelValues("metered", "every-1000-blocks"))
+	assert.GreaterOrEqual(t, lastSuccess, float64(before.Unix()))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.LastSuccessfulBackupTimestamp.Native().WithLabelValues("failing", "manual")))
+
+	assert.Equal(t, failureDurationBefore+1, failureDuration(), "failures are timed too")
+}
+
+func TestParseBackupConfigs_RetentionPolicy(t *testing.T) {
+	factories := map[string]BackupModuleFactory{
+		"fake": func(conf BackupModuleConfig) (BackupModule, error) { return &fakeBackupModule{}, nil },
+	}
+
+	_, scheds, err := ParseBackupConfigs(zap.NewNop(), []string{"type=fake freq-blocks=1000 keep-last=3 keep-within=48h"}, factories)
+	require.NoError(t, err)
+	require.Len(t, scheds, 1)
+	assert.Equal(t, RetentionPolicy{KeepLast: 3, KeepWithin: 48 * time.Hour}, scheds[0].RetentionPolicy)
+
+	_, _, err = ParseBackupConfigs(zap.NewNop(), []string{"type=fake freq-blocks=1000 keep-last=abc"}, factories)
+	require.Error(t, err)
+}
+
+type recordingBackupModule struct {
+	seen uint32
+}
+
+func (m *recordingBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	m.seen = lastSeenBlockNum
+	return "v1", nil
+}
+func (m *recordingBackupModule) RequiresStop() bool { return false }
+
+type recordingBackupModuleV2 struct {
+	recordingBackupModule
+	seenV2 uint64
+}
+
+func (m *recordingBackupModuleV2) BackupV2(lastSeenBlockNum uint64) (string, error) {
+	m.seenV2 = lastSeenBlockNum
+	return "v2", nil
+}
+
+func TestRunBackup_WrapBoundary(t *testing.T) {
+	cases := []struct {
+		name       string
+		blockNum   uint64
+		expectedV1 uint32
+	}{
+		{"below", math.MaxUint32 - 1, math.MaxUint32 - 1},
+		{"at", math.MaxUint32, math.MaxUint32},
+		{"above", math.MaxUint32 + 1, math.MaxUint32},
+		{"far above", math.MaxUint64, math.MaxUint32},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v1 := &recordingBackupModule{}
+			name, err := runBackup(v1, tc.blockNum)
+			require.NoError(t, err)
+			assert.Equal(t, "v1", name)
+			assert.Equal(t, tc.expectedV1, v1.seen, "saturated at MaxUint32")
+
+			v2 := &recordingBackupModuleV2{}
+			name, err = runBackup(v2, tc.blockNum)
+			require.NoError(t, err)
+			assert.Equal(t, "v2", name)
+			assert.Equal(t, tc.blockNum, v2.seenV2)
+			assert.Zero(t, v2.seen, "v1 method not called when v2 is implemented")
+		})
+	}
+}
+
+func TestBlocksElapsed_WrapBoundary(t *testing.T) {
+	cases := []struct {
+		name      string
+		reference uint64
+		lastSeen  uint64
+		freq      uint64
+		expected  bool
+	}{
+		{"not elapsed", 100, 150, 100, false},
+		{"elapsed", 100, 201, 100, true},
+		{"across uint32 limit", math.MaxUint32 - 10, math.MaxUint32 + 91, 100, true},
+		{"across uint32 limit not elapsed", math.MaxUint32 - 10, math.MaxUint32 + 90, 100, false},
+		{"freq above uint32", 0, math.MaxUint32 + 2, math.MaxUint32 + 1, true},
+		{"overflowing uint64", math.MaxUint64 - 10, math.MaxUint64, 100, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, blocksElapsed(tc.reference, tc.lastSeen, tc.freq))
+		})
+	}
+}
+
+func TestMatchHostname(t *testing.T) {
+	cases := []struct {
+		name        string
+		pattern     string
+		hostname    string
+		expected    bool
+		expectError bool
+	}{
+		{"exact", "mindreader-0", "mindreader-0", true, false},
+		{"exact mismatch", "mindreader-0", "mindreader-01", false, false},
+		{"exact is not a regexp", "mindreader.0", "mindreader-0", false, false},
+		{"glob", "mindreader-0*", "mindreader-0.eu-cluster", true, false},
+		{"glob mismatch", "mindreader-0*", "mindreader-1", false, false},
+		{"glob class", "backup-[0-2]", "backup-1", true, false},
+		{"regexp", "~backup-.*", "backup-eu-1", true, false},
+		{"regexp is anchored", "~backup-.*", "my-backup-eu-1", false, false},
+		{"regexp alternation is anchored", "~a|b", "ab", false, false},
+		{"invalid glob", "backup-[", "backup-1", false, true},
+		{"invalid regexp", "~backup-(", "backup-1", false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			matched, err := MatchHostname(tc.pattern, tc.hostname)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, matched)
+		})
+	}
+}
+
+func TestNewBackupSchedule_InvalidHostnamePattern(t *testing.T) {
+	_, err := NewBackupSchedule("1000", "", "", "~backup-(", "pitreos")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid value for required-hostname")
+
+	_, err = NewBackupSchedule("", "1h", "", "backup-[", "pitreos")
+	require.Error(t, err)
+
+	sched, err := NewBackupSchedule("1000", "", "", "mindreader-0*", "pitreos")
+	require.NoError(t, err)
+	assert.Equal(t, "mindreader-0*", sched.RequiredHostnameMatch)
+}
+
+func TestNewBackupSchedule_Frequencies(t *testing.T) {
+	sched, err := NewBackupSchedule("", "", "0 3 * * *", "", "pitreos")
+	require.NoError(t, err)
+	assert.Equal(t, "0 3 * * *", sched.CronExpression)
+	assert.Zero(t, sched.BlocksBetweenRuns)
+	assert.Zero(t, sched.TimeBetweenRuns)
+
+	_, err = NewBackupSchedule("", "", "0 25 * * *", "", "pitreos")
+	assert.EqualError(t, err, `invalid value for freq-cron in backup schedule: invalid cron expression "0 25 * * *": invalid value "25" in hour field, must be between 0 and 23`)
+
+	for _, freqs := range [][3]string{
+		{"1000", "1h", ""},
+		{"1000", "", "0 3 * * *"},
+		{"", "1h", "0 3 * * *"},
+		{"1000", "1h", "0 3 * * *"},
+	} {
+		_, err := NewBackupSchedule(freqs[0], freqs[1], freqs[2], "", "pitreos")
+		assert.EqualError(t, err, "backup schedule must define only one of freq-blocks, freq-time and freq-cron", "frequencies %q", freqs)
+	}
+
+	_, err = NewBackupSchedule("", "", "", "", "pitreos")
+	assert.EqualError(t, err, "schedule created without any frequency value")
+}
+
+func TestParseBackupConfigs_Cron(t *testing.T) {
+	factories := map[string]BackupModuleFactory{
+		"fake": func(conf BackupModuleConfig) (BackupModule, error) { return &fakeBackupModule{}, nil },
+	}
+
+	_, scheds, err := ParseBackupConfigs(zap.NewNop(), []string{"type=fake freq-cron=30_3_*_*_1-5"}, factories)
+	require.NoError(t, err)
+	require.Len(t, scheds, 1)
+	assert.Equal(t, "30 3 * * 1-5", scheds[0].CronExpression)
+
+	o := &Operator{backupSchedules: scheds}
+	assert.Equal(t, "cron-30_3_*_*_1-5", o.backupScheduleLabel(map[string]string{"schedule": "0"}))
+
+	_, _, err = ParseBackupConfigs(zap.NewNop(), []string{"type=fake freq-time=1h freq-cron=0_3_*_*_*"}, factories)
+	assert.Error(t, err)
+}
@@ -0,0 +1,26 @@
+package operator
+
+import (
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/journal"
+	"go.uber.org/zap"
+)
+
+// RegisterBlocksDroppedNotifier makes the operator learn about the blocks `notifier` drops on
+// shutdown, see `BlocksDroppedError`
+func (o *Operator) RegisterBlocksDroppedNotifier(notifier nodeManager.BlocksDroppedNotifier) {
+	notifier.OnBlocksDropped(o.blocksDropped)
+}
+
+// BlocksDroppedError returns the error of the last blocks dropped on shutdown by the notifier
+// registered with `RegisterBlocksDroppedNotifier`, nil if none were. The range it reports must be
+// reprocessed.
+func (o *Operator) BlocksDroppedError() error {
+	return o.blocksDroppedErr.Load()
+}
+
+func (o *Operator) blocksDropped(err error) {
+	o.blocksDroppedErr.Store(err)
+	o.zlogger.Error("blocks were dropped on shutdown without being archived", zap.Error(err))
+	o.journal.Record(journal.Event{Type: journal.EventBlocksDropped, Reason: err.Error()})
+}
@@ -0,0 +1,30 @@
+package operator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeBlocksDroppedNotifier struct {
+	callbacks []func(err error)
+}
+
+func (n *fakeBlocksDroppedNotifier) OnBlocksDropped(f func(err error)) {
+	n.callbacks = append(n.callbacks, f)
+}
+
+func TestOperator_RegisterBlocksDroppedNotifier(t *testing.T) {
+	o := &Operator{zlogger: zap.NewNop()}
+	notifier := &fakeBlocksDroppedNotifier{}
+	o.RegisterBlocksDroppedNotifier(notifier)
+	assert.NoError(t, o.BlocksDroppedError())
+
+	dropped := fmt.Errorf("4 blocks dropped")
+	for _, f := range notifier.callbacks {
+		f(dropped)
+	}
+	assert.Equal(t, dropped, o.BlocksDroppedError())
+}
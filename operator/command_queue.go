@@ -0,0 +1,273 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const commandHistorySize = 100
+
+const (
+	CommandInitiatorOperator = "operator"
+	CommandInitiatorHTTP     = "http"
+	CommandInitiatorAPI      = "api"
+	CommandInitiatorSchedule = "schedule"
+	CommandInitiatorSidecar  = "sidecar"
+)
+
+type CommandInfo struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Params     map[string]string `json:"params,omitempty"`
+	Initiator  string            `json:"initiator"`
+	EnqueuedAt time.Time         `json:"enqueued_at"`
+	StartedAt  time.Time         `json:"started_at,omitempty"` // zero while the command is pending
+	Progress   string            `json:"progress,omitempty"`
+
+	// DeferredUntil is when the maintenance window the command waits for opens, zero when it
+	// is not deferred, see `Operator.SetMaintenanceWindows`
+	DeferredUntil time.Time `json:"deferred_until,omitempty"`
+}
+
+type CommandResult struct {
+	CommandInfo
+	EndedAt   time.Time `json:"ended_at"`
+	Error     string    `json:"error,omitempty"`
+	Cancelled bool      `json:"cancelled"`
+}
+
+// enqueueCommand assigns an ID to the command and queues it, commands are executed serially
+// in the order they were queued
+func (o *Operator) enqueueCommand(c *Command) {
+	o.queueCommand(c)
+	o.sendQueuedCommand(c)
+}
+
+// queueCommand assigns an ID to the command and adds it to the pending commands, it is only
+// executed once sent with `sendQueuedCommand`
+func (o *Operator) queueCommand(c *Command) {
+	o.commandsLock.Lock()
+	o.nextCommandID++
+	c.id = fmt.Sprintf("cmd-%d", o.nextCommandID)
+	c.enqueuedAt = time.Now()
+	if c.initiator == "" {
+		c.initiator = CommandInitiatorOperator
+	}
+	o.pendingCommands = append(o.pendingCommands, c)
+	o.commandsLock.Unlock()
+}
+
+func (o *Operator) sendQueuedCommand(c *Command) {
+	o.commandChan <- c
+}
+
+// hasQueuedCommand returns whether a command named `name` with `params` is pending or running
+func (o *Operator) hasQueuedCommand(name string, params map[string]string) bool {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	matches := func(c *Command) bool {
+		if c.cmd != name || len(c.params) != len(params) {
+			return false
+		}
+		for k, v := range params {
+			if c.params[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+
+	if o.runningCommand != nil && matches(o.runningCommand) {
+		return true
+	}
+	for _, c := range o.pendingCommands {
+		if matches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasQueuedCommandNamed returns whether a command named `name` is pending or running, whatever
+// its params
+func (o *Operator) hasQueuedCommandNamed(name string) bool {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	if o.runningCommand != nil && o.runningCommand.cmd == name {
+		return true
+	}
+	for _, c := range o.pendingCommands {
+		if c.cmd == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingCommands returns the command being executed, if any, followed by the commands
+// waiting to be executed in order
+func (o *Operator) PendingCommands() (out []CommandInfo) {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	if o.runningCommand != nil {
+		out = append(out, o.runningCommand.info())
+	}
+	for _, c := range o.pendingCommands {
+		out = append(out, c.info())
+	}
+	return
+}
+
+// CancelCommand cancels a command that did not start yet, the command returns
+// `ErrCommandCancelled` to whoever is waiting on it.
+func (o *Operator) CancelCommand(id string) error {
+	o.commandsLock.Lock()
+	var cancelled *Command
+	for i, c := range o.pendingCommands {
+		if c.id == id {
+			cancelled = c
+			c.cancelled = true
+			o.pendingCommands = append(o.pendingCommands[:i:i], o.pendingCommands[i+1:]...)
+			o.recordCommandResult(c, ErrCommandCancelled)
+			break
+		}
+	}
+	running := o.runningCommand != nil && o.runningCommand.id == id
+	o.commandsLock.Unlock()
+
+	if cancelled == nil {
+		if running {
+			return fmt.Errorf("command %q already started", id)
+		}
+		return fmt.Errorf("command %q is not pending", id)
+	}
+
+	o.zlogger.Info("cancelled command", zap.String("id", id), zap.Object("command", cancelled))
+	go cancelled.Return(ErrCommandCancelled)
+	return nil
+}
+
+// CommandHistory returns the last `limit` completed or cancelled commands, oldest first (at most 100)
+func (o *Operator) CommandHistory(limit int) []CommandResult {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	history := o.commandHistory
+	if limit > 0 && limit < len(history) {
+		history = history[len(history)-limit:]
+	}
+
+	out := make([]CommandResult, len(history))
+	copy(out, history)
+	return out
+}
+
+// startCommand marks a dequeued command as running, returning false if it was cancelled
+func (o *Operator) startCommand(c *Command) bool {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	if c.cancelled {
+		return false
+	}
+
+	o.removePendingCommand(c)
+	c.startedAt = time.Now()
+	o.runningCommand = c
+	return true
+}
+
+func (o *Operator) finishCommand(c *Command, err error) {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	if o.runningCommand == c {
+		o.runningCommand = nil
+	}
+	o.recordCommandResult(c, err)
+}
+
+// dropCommand records a dequeued command that will never be executed
+func (o *Operator) dropCommand(c *Command, err error) {
+	o.commandsLock.Lock()
+	o.removePendingCommand(c)
+	o.recordCommandResult(c, err)
+	o.commandsLock.Unlock()
+
+	go c.Return(err)
+}
+
+// setCommandProgress updates the progress of a running command, visible in `PendingCommands`
+func (o *Operator) setCommandProgress(c *Command, progress string) {
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	c.progress = progress
+}
+
+func (o *Operator) removePendingCommand(c *Command) {
+	for i, pending := range o.pendingCommands {
+		if pending == c {
+			o.pendingCommands = append(o.pendingCommands[:i:i], o.pendingCommands[i+1:]...)
+			return
+		}
+	}
+}
+
+func (o *Operator) recordCommandResult(c *Command, err error) {
+	if c.id == "" {
+		// Not queued through `enqueueCommand` (sub commands)
+		return
+	}
+
+	result := CommandResult{
+		CommandInfo: c.info(),
+		EndedAt:     time.Now(),
+		Cancelled:   err == ErrCommandCancelled,
+	}
+	if err != nil && err != ErrCleanExit {
+		result.Error = err.Error()
+	}
+	if c.cmd == "backup" && err != nil {
+		o.postponeScheduledBackup(c.params)
+	}
+
+	o.commandHistory = append(o.commandHistory, result)
+	if len(o.commandHistory) > commandHistorySize {
+		o.commandHistory = o.commandHistory[len(o.commandHistory)-commandHistorySize:]
+	}
+}
+
+// info must be called with the commands lock held
+func (c *Command) info() CommandInfo {
+	return CommandInfo{
+		ID:         c.id,
+		Name:       c.cmd,
+		Params:     c.params,
+		Initiator:  c.initiator,
+		EnqueuedAt: c.enqueuedAt,
+		StartedAt:  c.startedAt,
+		Progress:   c.progress,
+
+		DeferredUntil: c.deferredUntil,
+	}
+}
@@ -0,0 +1,77 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_CommandQueue(t *testing.T) {
+	log := &eventLog{}
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", log), nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeBackupModule{log: log}))
+
+	o.enqueueCommand(&Command{cmd: "start", logger: o.zlogger})
+	o.enqueueCommand(&Command{cmd: "restore", logger: o.zlogger, initiator: CommandInitiatorHTTP})
+	o.enqueueCommand(&Command{cmd: "backup", logger: o.zlogger, initiator: CommandInitiatorSchedule, params: map[string]string{"name": "fake"}})
+
+	pending := o.PendingCommands()
+	require.Len(t, pending, 3)
+	assert.Equal(t, []string{"cmd-1", "cmd-2", "cmd-3"}, []string{pending[0].ID, pending[1].ID, pending[2].ID})
+	assert.Equal(t, CommandInitiatorOperator, pending[0].Initiator)
+	assert.True(t, pending[0].StartedAt.IsZero())
+
+	require.NoError(t, o.CancelCommand("cmd-2"))
+	assert.Error(t, o.CancelCommand("cmd-2"), "already cancelled")
+	assert.Len(t, o.PendingCommands(), 2)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, o.executeCommand(<-o.commandChan))
+	}
+
+	assert.Empty(t, o.PendingCommands())
+	assert.Error(t, o.CancelCommand("cmd-3"), "already executed")
+
+	history := o.CommandHistory(0)
+	require.Len(t, history, 3)
+	assert.Equal(t, "cmd-2", history[0].ID)
+	assert.True(t, history[0].Cancelled)
+	assert.Equal(t, "cmd-1", history[1].ID)
+	assert.False(t, history[1].StartedAt.IsZero())
+	assert.Equal(t, "cmd-3", history[2].ID)
+	assert.Equal(t, CommandInitiatorSchedule, history[2].Initiator)
+	assert.Equal(t, "restarting node", history[2].Progress, "last progress is kept")
+	assert.Empty(t, history[2].Error)
+	assert.Equal(t, []string{"start node", "stop node", "backup", "start node"}, log.reset())
+
+	assert.Equal(t, []string{"cmd-3"}, []string{o.CommandHistory(1)[0].ID})
+}
+
+func TestOperator_CommandHistoryRecordsReturnedErrors(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+
+	o.enqueueCommand(&Command{cmd: "backup", logger: o.zlogger})
+	require.NoError(t, o.executeCommand(<-o.commandChan), "recoverable errors are only returned to the caller")
+
+	history := o.CommandHistory(0)
+	require.Len(t, history, 1)
+	assert.Equal(t, "no registered backup modules", history[0].Error)
+	assert.False(t, history[0].Cancelled)
+}
+
+func TestOperator_CommandHistorySize(t *testing.T) {
+	o := &Operator{zlogger: zap.NewNop(), commandChan: make(chan *Command, 1)}
+
+	for i := 0; i < commandHistorySize+10; i++ {
+		o.enqueueCommand(&Command{cmd: "unknown", logger: o.zlogger})
+		require.NoError(t, o.executeCommand(<-o.commandChan))
+	}
+
+	history := o.CommandHistory(0)
+	require.Len(t, history, commandHistorySize)
+	assert.Equal(t, "cmd-11", history[0].ID)
+}
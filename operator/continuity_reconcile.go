@@ -0,0 +1,48 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+// continuityReconcileHandler reconciles the continuity checker of the registered resetter with
+// the one block files of the archive store, see `nodeManager.ContinuityCheckerReconciler`,
+// logging the holes found. It is refused unless the node is in maintenance.
+func (o *Operator) continuityReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	reconciler, ok := o.continuityCheckerResetter.(nodeManager.ContinuityCheckerReconciler)
+	if !ok {
+		http.Error(w, "no continuity checker able to reconcile registered", http.StatusNotFound)
+		return
+	}
+	if !o.inMaintenance() {
+		http.Error(w, "continuity checker can only be reconciled while in maintenance", http.StatusConflict)
+		return
+	}
+
+	report, err := reconciler.ReconcileContinuityChecker(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, hole := range report.Holes {
+		o.zlogger.Warn("hole found in one block files while reconciling continuity checker", zap.Uint64("start_block", hole.Start), zap.Uint64("stop_block", hole.Stop))
+	}
+	o.zlogger.Info("reconciled continuity checker",
+		zap.Uint64("previous_highest_block", report.PreviousHighestBlock),
+		zap.Uint64("highest_contiguous_block", report.HighestContiguousBlock),
+		zap.Uint64("lowest_block", report.LowestBlock),
+		zap.Uint64("highest_block", report.HighestBlock),
+		zap.Int("holes", len(report.Holes)),
+	)
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
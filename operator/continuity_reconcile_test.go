@@ -0,0 +1,59 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeContinuityReconciler struct {
+	reconciled int
+}
+
+func (r *fakeContinuityReconciler) ResetContinuityChecker() {}
+func (r *fakeContinuityReconciler) ReconcileContinuityChecker(_ context.Context) (*nodeManager.ContinuityReconciliation, error) {
+	r.reconciled++
+	return &nodeManager.ContinuityReconciliation{
+		Suffix:                 "suffix",
+		HighestContiguousBlock: 11,
+		LowestBlock:            10,
+		HighestBlock:           15,
+		BlockCount:             4,
+		Holes:                  []nodeManager.BlockHole{{Start: 12, Stop: 13}},
+	}, nil
+}
+
+func TestOperator_ContinuityReconcileHandler(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+
+	post := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		o.continuityReconcileHandler(recorder, httptest.NewRequest("POST", "/v1/mindreader/reconcile", nil))
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusNotFound, post().Code)
+
+	reconciler := &fakeContinuityReconciler{}
+	o.RegisterContinuityCheckerResetter(reconciler)
+	assert.Equal(t, http.StatusConflict, post().Code)
+	assert.Equal(t, 0, reconciler.reconciled)
+
+	require.NoError(t, o.runCommand(&Command{cmd: "maintenance", logger: o.zlogger}))
+	recorder := post()
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 1, reconciler.reconciled)
+
+	var report nodeManager.ContinuityReconciliation
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.EqualValues(t, 11, report.HighestContiguousBlock)
+	assert.Equal(t, []nodeManager.BlockHole{{Start: 12, Stop: 13}}, report.Holes)
+}
@@ -0,0 +1,204 @@
+package operator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cronSearchLimit bounds the search of the next fire time, a spec matching no date (e.g.
+// `0 0 31 2 *`) never fires
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are both sunday
+}
+
+// cronSchedule is a parsed 5-field cron expression (minute, hour, day of month, month and day
+// of week), evaluated in UTC. Each field is `*`, a value, a range (`1-5`), a step (`*/15`,
+// `0-30/10`) or a comma separated list of them. Like cron, when both the day of month and the
+// day of week are restricted, a day matching either of them matches. A field starting with `*`,
+// steps like `*/2` included, is not restricted.
+type cronSchedule struct {
+	spec string
+
+	minutes, hours, daysOfMonth, months, daysOfWeek uint64 // bit `n` set when value `n` matches
+
+	daysOfMonthRestricted, daysOfWeekRestricted bool
+}
+
+func parseCronExpression(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, must have 5 fields (minute hour day-of-month month day-of-week), got %d", spec, len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+
+	// sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cronSchedule{
+		spec:                  spec,
+		minutes:               sets[0],
+		hours:                 sets[1],
+		daysOfMonth:           sets[2],
+		months:                sets[3],
+		daysOfWeek:            sets[4],
+		daysOfMonthRestricted: !strings.HasPrefix(fields[2], "*"),
+		daysOfWeekRestricted:  !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, def cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", part[i+1:], def.name)
+			}
+			rangePart = part[:i]
+		}
+
+		low, high := def.min, def.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], def); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(bounds[1], def); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s field, start is after end", rangePart, def.name)
+			}
+		default:
+			value, err := parseCronValue(rangePart, def)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+func parseCronValue(value string, def cronField) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < def.min || n > def.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be between %d and %d", value, def.name, def.min, def.max)
+	}
+	return n, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.daysOfMonthRestricted && s.daysOfWeekRestricted {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+// next returns the first time matching the schedule strictly after `after`, in UTC, the zero time
+// if none matches within 5 years
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// runOnCron queues the command at every time matching `sched`, see `parseCronExpression`. A run
+// is skipped while the node is in maintenance or while another backup is pending or running.
+func (o *Operator) runOnCron(sched *cronSchedule, commandName string, params map[string]string) {
+	for {
+		next := sched.next(o.now())
+		if next.IsZero() {
+			o.zlogger.Error("cron schedule never fires again, stopping it", zap.String("cron_expression", sched.spec))
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(o.now()))
+		select {
+		case <-o.Terminating():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		o.fireCronSchedule(sched, commandName, params)
+	}
+}
+
+// fireCronSchedule queues the command of a cron schedule unless the run is skipped, returning
+// whether it was queued
+func (o *Operator) fireCronSchedule(sched *cronSchedule, commandName string, params map[string]string) bool {
+	fields := []zap.Field{zap.String("cron_expression", sched.spec), zap.String("command", commandName), zap.Reflect("params", params)}
+
+	if o.inMaintenance() {
+		o.zlogger.Info("skipping cron scheduled run, node is in maintenance", fields...)
+		return false
+	}
+	if o.hasQueuedCommandNamed("backup") {
+		o.zlogger.Info("skipping cron scheduled run, another backup is in progress", fields...)
+		return false
+	}
+
+	o.enqueueCommand(&Command{cmd: commandName, logger: o.zlogger, params: params, initiator: CommandInitiatorSchedule})
+	return true
+}
@@ -0,0 +1,101 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseCronExpression_Invalid(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expectedErr string
+	}{
+		{"0 3 * *", `invalid cron expression "0 3 * *", must have 5 fields (minute hour day-of-month month day-of-week), got 4`},
+		{"60 * * * *", `invalid cron expression "60 * * * *": invalid value "60" in minute field, must be between 0 and 59`},
+		{"* * 0 * *", `invalid cron expression "* * 0 * *": invalid value "0" in day of month field, must be between 1 and 31`},
+		{"* * * 13 *", `invalid cron expression "* * * 13 *": invalid value "13" in month field, must be between 1 and 12`},
+		{"* * * * 8", `invalid cron expression "* * * * 8": invalid value "8" in day of week field, must be between 0 and 7`},
+		{"*/0 * * * *", `invalid cron expression "*/0 * * * *": invalid step "0" in minute field`},
+		{"* 5-2 * * *", `invalid cron expression "* 5-2 * * *": invalid range "5-2" in hour field, start is after end`},
+		{"a * * * *", `invalid cron expression "a * * * *": invalid value "a" in minute field, must be between 0 and 59`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			_, err := parseCronExpression(test.spec)
+			assert.EqualError(t, err, test.expectedErr)
+		})
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	tests := []struct {
+		name     string
+		spec     string
+		after    time.Time
+		expected time.Time
+	}{
+		{"daily later today", "0 3 * * *", utc("2021-03-10 01:15:00"), utc("2021-03-10 03:00:00")},
+		{"daily strictly after", "0 3 * * *", utc("2021-03-10 03:00:00"), utc("2021-03-11 03:00:00")},
+		{"daily seconds truncated", "0 3 * * *", utc("2021-03-10 02:59:59"), utc("2021-03-10 03:00:00")},
+		{"daily across midnight", "0 3 * * *", utc("2021-03-10 23:59:00"), utc("2021-03-11 03:00:00")},
+		{"across month end", "0 0 * * *", utc("2021-04-30 12:00:00"), utc("2021-05-01 00:00:00")},
+		{"across year end", "30 0 1 1 *", utc("2021-12-31 23:59:30"), utc("2022-01-01 00:30:00")},
+		{"leap day", "0 0 29 2 *", utc("2021-03-01 00:00:00"), utc("2024-02-29 00:00:00")},
+		{"steps", "*/15 * * * *", utc("2021-03-10 10:31:00"), utc("2021-03-10 10:45:00")},
+		{"step across hour", "*/15 * * * *", utc("2021-03-10 10:45:00"), utc("2021-03-10 11:00:00")},
+		{"list and range", "0 2,14 * * 1-5", utc("2021-03-12 15:00:00"), utc("2021-03-15 02:00:00")}, // friday to monday
+		{"sunday as 7", "0 0 * * 7", utc("2021-03-10 00:00:00"), utc("2021-03-14 00:00:00")},
+		{"day of month or day of week", "0 0 15 * 1", utc("2021-03-10 00:00:00"), utc("2021-03-15 00:00:00")},
+		{"day of month or day of week, week first", "0 0 20 * 5", utc("2021-03-10 00:00:00"), utc("2021-03-12 00:00:00")},
+		{"day of month step and day of week", "0 0 */2 * 1", utc("2021-03-10 00:00:00"), utc("2021-03-15 00:00:00")}, // odd day and monday
+		{"31st skips short months", "0 0 31 * *", utc("2021-03-31 00:00:00"), utc("2021-05-31 00:00:00")},
+		{"non UTC input", "0 3 * * *", time.Date(2021, 3, 10, 22, 0, 0, 0, time.FixedZone("UTC-5", -5*3600)), utc("2021-03-12 03:00:00")},
+		{"never", "0 0 31 2 *", utc("2021-03-10 00:00:00"), time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sched, err := parseCronExpression(test.spec)
+			require.NoError(t, err)
+
+			next := sched.next(test.after)
+			assert.True(t, test.expected.Equal(next), "expected %s, got %s", test.expected, next)
+			if !next.IsZero() {
+				assert.Equal(t, time.UTC, next.Location())
+			}
+		})
+	}
+}
+
+func TestOperator_FireCronSchedule(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+	sched, err := parseCronExpression("0 3 * * *")
+	require.NoError(t, err)
+	params := map[string]string{"name": "fake", "schedule": "0"}
+
+	assert.True(t, o.fireCronSchedule(sched, "backup", params))
+	pending := o.PendingCommands()
+	require.Len(t, pending, 1)
+	require.NoError(t, o.CancelCommand(pending[0].ID))
+
+	o.recordMaintenanceTransition(true, map[string]string{"reason": "test"})
+	assert.False(t, o.fireCronSchedule(sched, "backup", params), "skipped in maintenance")
+	assert.Empty(t, o.PendingCommands())
+
+	o.recordMaintenanceTransition(false, map[string]string{"reason": "test"})
+	o.queueCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": "other"}})
+	assert.False(t, o.fireCronSchedule(sched, "backup", params), "skipped while another backup is pending")
+	assert.Len(t, o.PendingCommands(), 1)
+}
@@ -0,0 +1,183 @@
+package operator
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// DirtyStartPolicy enables restoring the latest backup when the node stops on its own and its
+// start is detected as dirty (a database flagged dirty after a crash), then starting it again.
+// A start is dirty when the superviser implements `nodeManager.DirtyStartChainSuperviser` and
+// reports it, or when one of the first `StartupLogLines` lines logged by the node matches
+// `StartupLogLinePattern`. The backup is restored even if older than the highest block written to the
+// continuity checker.
+type DirtyStartPolicy struct {
+	StartupLogLinePattern *regexp.Regexp
+	StartupLogLines       int // defaults to 100
+
+	// MaxAttempts bounds the number of automatic restores during the operator's lifetime, the node
+	// stopping for any other reason shuts the operator down anyway. Defaults to 1.
+	MaxAttempts int
+
+	BackupModuleName string // can be empty when a single restorable backup module is registered
+	BackupName       string // defaults to "latest"
+}
+
+// RegisterContinuityCheckerResetter makes the operator reset the continuity checker of
+// `resetter` once a backup was restored, before starting the node again. When `resetter` also
+// implements `nodeManager.ContinuityCheckerState`, restoring a backup older than its highest
+// block is refused unless forced (`force=true` restore param). When it implements
+// `nodeManager.ContinuityCheckerReconciler`, the checker can be reconciled with the archive store
+// in maintenance through the `/v1/mindreader/reconcile` HTTP endpoint.
+func (o *Operator) RegisterContinuityCheckerResetter(resetter nodeManager.ContinuityCheckerResetter) {
+	o.continuityCheckerResetter = resetter
+}
+
+func (o *Operator) setupDirtyStartPolicy() {
+	policy := o.options.AutoRestoreOnDirtyStart
+	if policy == nil {
+		return
+	}
+
+	if policy.StartupLogLines == 0 {
+		policy.StartupLogLines = 100
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.BackupName == "" {
+		policy.BackupName = "latest"
+	}
+
+	if policy.StartupLogLinePattern != nil {
+		o.startupLines = &startupLinesLogPlugin{max: policy.StartupLogLines}
+		o.Superviser.RegisterLogPlugin(o.startupLines)
+	}
+}
+
+// isDirtyStart reports if the node stopped because its data is dirty, according to the superviser or the startup log lines
+func (o *Operator) isDirtyStart() (bool, error) {
+	if dirtyStartSuperviser, ok := o.Superviser.(nodeManager.DirtyStartChainSuperviser); ok {
+		dirty, err := dirtyStartSuperviser.IsDirty()
+		if err != nil {
+			return false, fmt.Errorf("checking if node is dirty: %w", err)
+		}
+		if dirty {
+			o.zlogger.Info("superviser reports node data as dirty")
+			return true, nil
+		}
+	}
+
+	if o.startupLines != nil {
+		if line, found := o.startupLines.match(o.options.AutoRestoreOnDirtyStart.StartupLogLinePattern); found {
+			o.zlogger.Info("startup log line matches dirty start pattern", zap.String("line", line))
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// autoRestoreOnDirtyStart queues a restore of the latest backup, the node being restarted once
+// restored, when the policy is enabled and the node stopped after a dirty start. It returns false
+// when the operator should shut down because the node stopped.
+func (o *Operator) autoRestoreOnDirtyStart() bool {
+	policy := o.options.AutoRestoreOnDirtyStart
+	if policy == nil {
+		return false
+	}
+
+	dirty, err := o.isDirtyStart()
+	if err != nil {
+		o.zlogger.Error("unable to detect dirty start, not restoring", zap.Error(err))
+		return false
+	}
+	if !dirty {
+		return false
+	}
+	metrics.AutoRestoreSteps.Inc("dirty_start_detected")
+
+	if o.autoRestoreAttempts >= policy.MaxAttempts {
+		o.zlogger.Error("dirty start detected but automatic restore attempts exhausted, not restoring", zap.Int("attempts", o.autoRestoreAttempts), zap.Int("max_attempts", policy.MaxAttempts))
+		metrics.AutoRestoreSteps.Inc("max_attempts_reached")
+		return false
+	}
+
+	if _, err := selectRestoreModule(o.backupModules, policy.BackupModuleName); err != nil {
+		o.zlogger.Error("dirty start detected but no backup can be restored", zap.Error(err))
+		metrics.AutoRestoreSteps.Inc("no_restore_module")
+		return false
+	}
+
+	o.autoRestoreAttempts++
+	o.zlogger.Warn("dirty start detected, restoring backup and restarting node",
+		zap.String("backup_module", policy.BackupModuleName),
+		zap.String("backup_name", policy.BackupName),
+		zap.Int("attempt", o.autoRestoreAttempts),
+		zap.Int("max_attempts", policy.MaxAttempts),
+	)
+	metrics.AutoRestoreSteps.Inc("restore_requested")
+
+	// The backup is necessarily older than the blocks produced before the dirty start, it is
+	// restored even if older than the highest block written to the continuity checker
+	o.enqueueCommand(&Command{cmd: "restore", logger: o.zlogger, params: map[string]string{
+		"name":         policy.BackupModuleName,
+		"backupName":   policy.BackupName,
+		"auto_restore": "true",
+		"force":        "true",
+	}})
+	return true
+}
+
+func (o *Operator) resetContinuityChecker() {
+	if o.continuityCheckerResetter == nil {
+		return
+	}
+
+	o.zlogger.Info("resetting continuity checker after restore")
+	o.continuityCheckerResetter.ResetContinuityChecker()
+}
+
+// startupLinesLogPlugin keeps the first lines logged by the node since it was last started
+type startupLinesLogPlugin struct {
+	lock  sync.Mutex
+	max   int
+	lines []string
+}
+
+func (p *startupLinesLogPlugin) Name() string { return "startup lines" }
+
+// Launch is called by the superviser on every start of the node
+func (p *startupLinesLogPlugin) Launch() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lines = nil
+}
+
+func (p *startupLinesLogPlugin) LogLine(in string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.lines) < p.max {
+		p.lines = append(p.lines, in)
+	}
+}
+
+func (p *startupLinesLogPlugin) Shutdown(_ error)    {}
+func (p *startupLinesLogPlugin) IsTerminating() bool { return false }
+func (p *startupLinesLogPlugin) Stop()               {}
+
+func (p *startupLinesLogPlugin) match(pattern *regexp.Regexp) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, line := range p.lines {
+		if pattern.MatchString(line) {
+			return line, true
+		}
+	}
+	return "", false
+}
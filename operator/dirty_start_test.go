@@ -0,0 +1,105 @@
+package operator
+
+import (
+	"regexp"
+	"testing"
+
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeRestorableBackupModule struct {
+	fakeBackupModule
+}
+
+func (m *fakeRestorableBackupModule) Restore(name string) error {
+	m.log.add("restore " + name)
+	return nil
+}
+
+type dirtyFakeSuperviser struct {
+	*fakeSuperviser
+	dirty bool
+}
+
+func (s *dirtyFakeSuperviser) IsDirty() (bool, error) { return s.dirty, nil }
+
+type logLinesFakeSuperviser struct {
+	*fakeSuperviser
+	plugins []logplugin.LogPlugin
+}
+
+func (s *logLinesFakeSuperviser) RegisterLogPlugin(plugin logplugin.LogPlugin) {
+	s.plugins = append(s.plugins, plugin)
+}
+
+type fakeContinuityCheckerResetter struct {
+	log *eventLog
+}
+
+func (r *fakeContinuityCheckerResetter) ResetContinuityChecker() { r.log.add("reset continuity") }
+
+func TestOperator_AutoRestoreOnDirtyStart(t *testing.T) {
+	log := &eventLog{}
+	node := &dirtyFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", log), dirty: true}
+
+	o, err := New(zap.NewNop(), node, nil, &Options{AutoRestoreOnDirtyStart: &DirtyStartPolicy{}})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeRestorableBackupModule{fakeBackupModule{log: log}}))
+	o.RegisterContinuityCheckerResetter(&fakeContinuityCheckerResetter{log: log})
+
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+	log.reset()
+
+	node.crash()
+	require.True(t, o.autoRestoreOnDirtyStart())
+
+	cmd := <-o.commandChan
+	require.NoError(t, o.executeCommand(cmd))
+	assert.Equal(t, []string{"restore latest", "reset continuity", "start node"}, log.reset())
+	assert.Equal(t, CommandInitiatorOperator, o.CommandHistory(1)[0].Initiator)
+
+	node.crash()
+	assert.False(t, o.autoRestoreOnDirtyStart(), "max attempts reached")
+	assert.Empty(t, o.commandChan)
+}
+
+func TestOperator_AutoRestoreOnDirtyStart_StartupLogLines(t *testing.T) {
+	log := &eventLog{}
+	node := &logLinesFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", log)}
+
+	o, err := New(zap.NewNop(), node, nil, &Options{AutoRestoreOnDirtyStart: &DirtyStartPolicy{
+		StartupLogLinePattern: regexp.MustCompile(`database is (dirty|corrupted)`),
+		StartupLogLines:       2,
+		MaxAttempts:           5,
+	}})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeRestorableBackupModule{fakeBackupModule{log: log}}))
+	require.Len(t, node.plugins, 1)
+	startupLines := node.plugins[0]
+
+	startupLines.Launch()
+	startupLines.LogLine("starting node")
+	startupLines.LogLine("opening database")
+	startupLines.LogLine("database is dirty, refusing to start") // past the startup lines
+	assert.False(t, o.autoRestoreOnDirtyStart())
+
+	startupLines.Launch()
+	startupLines.LogLine("starting node")
+	startupLines.LogLine("error: database is dirty, refusing to start")
+	assert.True(t, o.autoRestoreOnDirtyStart())
+	assert.Len(t, o.commandChan, 1)
+}
+
+func TestOperator_AutoRestoreOnDirtyStart_Disabled(t *testing.T) {
+	log := &eventLog{}
+	node := &dirtyFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", log), dirty: true}
+
+	o, err := New(zap.NewNop(), node, nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeRestorableBackupModule{fakeBackupModule{log: log}}))
+
+	assert.False(t, o.autoRestoreOnDirtyStart())
+}
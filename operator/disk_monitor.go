@@ -0,0 +1,257 @@
+package operator
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	DiskRoleData    = "data"
+	DiskRoleWorking = "working"
+)
+
+const defaultDiskMonitorPollInterval = time.Minute
+
+// DiskMonitorOptions configures the monitoring of the space available on the filesystems of the
+// node data directory and of the mindreader working directory, see `Options.DiskMonitor`.
+// Thresholds are percentages of free space, the action of a threshold is taken once when the
+// free space of a filesystem goes below it, and again only after it went back above it. A zero
+// threshold disables its action. Directories on the same filesystem share their alerts.
+type DiskMonitorOptions struct {
+	DataDirectory    string
+	WorkingDirectory string
+
+	// PollInterval between two checks of the free space, defaults to 1 minute
+	PollInterval time.Duration
+
+	// WarningFreePercent logs a warning
+	WarningFreePercent float64
+
+	// MaintenanceFreePercent puts the node in maintenance
+	MaintenanceFreePercent float64
+
+	// EmergencyFreePercent stops the node by putting it in maintenance, after running a backup
+	// with the `EmergencyBackupModule` when `EmergencyBackup` is set. The backup ignores the
+	// maintenance windows.
+	EmergencyFreePercent  float64
+	EmergencyBackup       bool
+	EmergencyBackupModule string // the only registered backup module when empty
+}
+
+func (o *DiskMonitorOptions) validate() error {
+	if o.DataDirectory == "" && o.WorkingDirectory == "" {
+		return fmt.Errorf("no directory to monitor")
+	}
+
+	thresholds := []struct {
+		name    string
+		percent float64
+	}{
+		{"warning", o.WarningFreePercent},
+		{"maintenance", o.MaintenanceFreePercent},
+		{"emergency", o.EmergencyFreePercent},
+	}
+
+	var previous string
+	var previousPercent float64
+	for _, threshold := range thresholds {
+		if threshold.percent < 0 || threshold.percent > 100 {
+			return fmt.Errorf("invalid %s free percent %v, must be between 0 and 100", threshold.name, threshold.percent)
+		}
+		if threshold.percent == 0 {
+			continue
+		}
+		if previous != "" && threshold.percent > previousPercent {
+			return fmt.Errorf("%s free percent %v cannot be above %s free percent %v", threshold.name, threshold.percent, previous, previousPercent)
+		}
+		previous, previousPercent = threshold.name, threshold.percent
+	}
+
+	if o.EmergencyBackup && o.EmergencyFreePercent == 0 {
+		return fmt.Errorf("emergency backup requires an emergency free percent")
+	}
+	return nil
+}
+
+type diskLevel int
+
+const (
+	diskLevelOK diskLevel = iota
+	diskLevelWarning
+	diskLevelMaintenance
+	diskLevelEmergency
+)
+
+func (l diskLevel) String() string {
+	switch l {
+	case diskLevelOK:
+		return "ok"
+	case diskLevelWarning:
+		return "warning"
+	case diskLevelMaintenance:
+		return "maintenance"
+	case diskLevelEmergency:
+		return "emergency"
+	default:
+		return "unknown"
+	}
+}
+
+func (o *DiskMonitorOptions) levelOf(freePercent float64) diskLevel {
+	switch {
+	case freePercent < o.EmergencyFreePercent:
+		return diskLevelEmergency
+	case freePercent < o.MaintenanceFreePercent:
+		return diskLevelMaintenance
+	case freePercent < o.WarningFreePercent:
+		return diskLevelWarning
+	}
+	return diskLevelOK
+}
+
+type filesystemUsage struct {
+	device     uint64 // identifies the filesystem, shared by every directory on it
+	freeBytes  uint64 // available to unprivileged users
+	totalBytes uint64
+}
+
+func (u filesystemUsage) freePercent() float64 {
+	if u.totalBytes == 0 {
+		return 0
+	}
+	return float64(u.freeBytes) * 100 / float64(u.totalBytes)
+}
+
+func statFilesystem(directory string) (filesystemUsage, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(directory, &stat); err != nil {
+		return filesystemUsage{}, fmt.Errorf("stat %q: %w", directory, err)
+	}
+
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(directory, &statfs); err != nil {
+		return filesystemUsage{}, fmt.Errorf("statfs %q: %w", directory, err)
+	}
+
+	return filesystemUsage{
+		device:     uint64(stat.Dev),
+		freeBytes:  statfs.Bavail * uint64(statfs.Bsize),
+		totalBytes: statfs.Blocks * uint64(statfs.Bsize),
+	}, nil
+}
+
+// monitoredFilesystem groups the directories living on the same filesystem
+type monitoredFilesystem struct {
+	usage       filesystemUsage
+	roles       []string
+	directories []string
+}
+
+func (o *Operator) monitorDiskSpace(options *DiskMonitorOptions) {
+	interval := options.PollInterval
+	if interval == 0 {
+		interval = defaultDiskMonitorPollInterval
+	}
+
+	o.zlogger.Info("monitoring disk space",
+		zap.String("data_directory", options.DataDirectory),
+		zap.String("working_directory", options.WorkingDirectory),
+		zap.Duration("poll_interval", interval),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		o.checkDiskSpace(options)
+
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDiskSpace updates the free space metrics of the monitored directories and takes the action
+// of the threshold crossed by each filesystem, if any
+func (o *Operator) checkDiskSpace(options *DiskMonitorOptions) {
+	var filesystems []*monitoredFilesystem
+	byDevice := map[uint64]*monitoredFilesystem{}
+
+	for _, monitored := range []struct{ role, directory string }{
+		{DiskRoleData, options.DataDirectory},
+		{DiskRoleWorking, options.WorkingDirectory},
+	} {
+		if monitored.directory == "" {
+			continue
+		}
+
+		usage, err := o.statFilesystem(monitored.directory)
+		if err != nil {
+			o.zlogger.Warn("unable to get disk space", zap.String("role", monitored.role), zap.Error(err))
+			continue
+		}
+		metrics.FreeBytes.SetUint64(usage.freeBytes, monitored.role)
+		metrics.FreePercent.SetFloat64(usage.freePercent(), monitored.role)
+
+		filesystem, found := byDevice[usage.device]
+		if !found {
+			filesystem = &monitoredFilesystem{usage: usage}
+			byDevice[usage.device] = filesystem
+			filesystems = append(filesystems, filesystem)
+		}
+		filesystem.roles = append(filesystem.roles, monitored.role)
+		filesystem.directories = append(filesystem.directories, monitored.directory)
+	}
+
+	for _, filesystem := range filesystems {
+		o.applyDiskLevel(options, filesystem)
+	}
+}
+
+func (o *Operator) applyDiskLevel(options *DiskMonitorOptions, filesystem *monitoredFilesystem) {
+	freePercent := filesystem.usage.freePercent()
+	level := options.levelOf(freePercent)
+	previous := o.diskLevels[filesystem.usage.device]
+	o.diskLevels[filesystem.usage.device] = level
+
+	fields := []zap.Field{
+		zap.Strings("roles", filesystem.roles),
+		zap.Strings("directories", filesystem.directories),
+		zap.Uint64("free_bytes", filesystem.usage.freeBytes),
+		zap.Float64("free_percent", freePercent),
+		zap.Stringer("level", level),
+	}
+
+	if level < previous {
+		o.zlogger.Info("free disk space back above threshold", append(fields, zap.Stringer("previous_level", previous))...)
+		return
+	}
+	if level == previous {
+		return
+	}
+
+	reason := fmt.Sprintf("free disk space at %.1f%% on the filesystem of the %s directory", freePercent, strings.Join(filesystem.roles, " and "))
+	switch level {
+	case diskLevelWarning:
+		o.zlogger.Warn("free disk space below warning threshold", append(fields, zap.Float64("threshold", options.WarningFreePercent))...)
+
+	case diskLevelMaintenance:
+		o.zlogger.Error("free disk space below maintenance threshold, putting node in maintenance", append(fields, zap.Float64("threshold", options.MaintenanceFreePercent))...)
+		o.enqueueCommand(&Command{cmd: "maintenance", logger: o.zlogger, params: map[string]string{"reason": reason, "source": nodeManager.MaintenanceSourceDiskSpace}})
+
+	case diskLevelEmergency:
+		o.zlogger.Error("free disk space below emergency threshold, stopping node", append(fields, zap.Float64("threshold", options.EmergencyFreePercent), zap.Bool("backup", options.EmergencyBackup))...)
+		if options.EmergencyBackup {
+			o.enqueueCommand(&Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": options.EmergencyBackupModule, "override-window": "true"}})
+		}
+		o.enqueueCommand(&Command{cmd: "maintenance", logger: o.zlogger, params: map[string]string{"reason": reason, "source": nodeManager.MaintenanceSourceDiskSpace}})
+	}
+}
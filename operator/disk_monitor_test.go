@@ -0,0 +1,126 @@
+package operator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newDiskMonitorTestOperator(t *testing.T, usages map[string]filesystemUsage) (*Operator, *observer.ObservedLogs) {
+	t.Helper()
+
+	core, logs := observer.New(zap.InfoLevel)
+	o := newTestOperator(t, newFakeSuperviser("node", &eventLog{}), &Options{})
+	o.zlogger = zap.New(core)
+	o.statFilesystem = func(directory string) (filesystemUsage, error) {
+		usage, found := usages[directory]
+		if !found {
+			return filesystemUsage{}, fmt.Errorf("no such directory %q", directory)
+		}
+		return usage, nil
+	}
+	logs.TakeAll()
+
+	return o, logs
+}
+
+func queuedCommands(o *Operator) (out []string) {
+	for len(o.commandChan) > 0 {
+		cmd := <-o.commandChan
+		out = append(out, cmd.cmd)
+	}
+	return
+}
+
+func TestOperator_DiskMonitorSharedFilesystem(t *testing.T) {
+	usages := map[string]filesystemUsage{}
+	setFree := func(percent uint64) {
+		usages["/data"] = filesystemUsage{device: 1, freeBytes: percent, totalBytes: 100}
+		usages["/data/mindreader"] = filesystemUsage{device: 1, freeBytes: percent, totalBytes: 100}
+	}
+	o, logs := newDiskMonitorTestOperator(t, usages)
+	options := &DiskMonitorOptions{
+		DataDirectory:          "/data",
+		WorkingDirectory:       "/data/mindreader",
+		WarningFreePercent:     10,
+		MaintenanceFreePercent: 5,
+		EmergencyFreePercent:   2,
+		EmergencyBackup:        true,
+	}
+	require.NoError(t, options.validate())
+
+	setFree(50)
+	o.checkDiskSpace(options)
+	assert.Equal(t, 0, logs.Len())
+
+	setFree(8)
+	o.checkDiskSpace(options)
+	o.checkDiskSpace(options)
+	warnings := logs.FilterMessage("free disk space below warning threshold").All()
+	require.Len(t, warnings, 1, "one alert for both directories")
+	assert.Equal(t, []interface{}{"data", "working"}, warnings[0].ContextMap()["roles"])
+	assert.Empty(t, queuedCommands(o))
+
+	setFree(4)
+	o.checkDiskSpace(options)
+	o.checkDiskSpace(options)
+	assert.Equal(t, []string{"maintenance"}, queuedCommands(o))
+
+	setFree(1)
+	o.checkDiskSpace(options)
+	assert.Equal(t, []string{"backup", "maintenance"}, queuedCommands(o))
+
+	setFree(50)
+	o.checkDiskSpace(options)
+	assert.Equal(t, 1, logs.FilterMessage("free disk space back above threshold").Len())
+
+	setFree(4)
+	o.checkDiskSpace(options)
+	assert.Equal(t, []string{"maintenance"}, queuedCommands(o), "threshold crossed again")
+}
+
+func TestOperator_DiskMonitorSeparateFilesystems(t *testing.T) {
+	usages := map[string]filesystemUsage{
+		"/data":       {device: 1, freeBytes: 4, totalBytes: 100},
+		"/mindreader": {device: 2, freeBytes: 40, totalBytes: 100},
+	}
+	o, logs := newDiskMonitorTestOperator(t, usages)
+	options := &DiskMonitorOptions{DataDirectory: "/data", WorkingDirectory: "/mindreader", WarningFreePercent: 50, MaintenanceFreePercent: 5}
+
+	o.checkDiskSpace(options)
+	assert.Equal(t, []string{"maintenance"}, queuedCommands(o))
+
+	warnings := logs.FilterMessage("free disk space below warning threshold").All()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, []interface{}{"working"}, warnings[0].ContextMap()["roles"])
+
+	delete(usages, "/mindreader")
+	o.checkDiskSpace(options)
+	assert.Equal(t, 1, logs.FilterMessage("unable to get disk space").Len())
+	assert.Empty(t, queuedCommands(o))
+}
+
+func TestDiskMonitorOptions_Validate(t *testing.T) {
+	assert.EqualError(t, (&DiskMonitorOptions{}).validate(), "no directory to monitor")
+	assert.EqualError(t, (&DiskMonitorOptions{DataDirectory: "/data", WarningFreePercent: 101}).validate(), "invalid warning free percent 101, must be between 0 and 100")
+	assert.EqualError(t, (&DiskMonitorOptions{DataDirectory: "/data", WarningFreePercent: 5, EmergencyFreePercent: 10}).validate(), "emergency free percent 10 cannot be above warning free percent 5")
+	assert.Error(t, (&DiskMonitorOptions{DataDirectory: "/data", EmergencyBackup: true}).validate())
+	assert.NoError(t, (&DiskMonitorOptions{DataDirectory: "/data", MaintenanceFreePercent: 5}).validate())
+
+	_, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{DiskMonitor: &DiskMonitorOptions{}})
+	assert.Error(t, err)
+}
+
+func TestStatFilesystem(t *testing.T) {
+	usage, err := statFilesystem(t.TempDir())
+	require.NoError(t, err)
+	assert.NotZero(t, usage.totalBytes)
+	assert.LessOrEqual(t, usage.freeBytes, usage.totalBytes)
+
+	_, err = statFilesystem("/does/not/exist")
+	assert.Error(t, err)
+}
@@ -17,3 +17,10 @@ package operator
 import "errors"
 
 var ErrCleanExit = errors.New("clean exit")
+
+// ErrCommandCancelled is returned by commands cancelled through `Operator.CancelCommand`
+var ErrCommandCancelled = errors.New("command cancelled")
+
+// ErrReprocessInProgress is returned when reprocessing is requested while another reprocessing
+// window is pending or running, see `Operator.Reprocess`
+var ErrReprocessInProgress = errors.New("reprocessing already in progress")
@@ -0,0 +1,492 @@
+package operator
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/streamingfast/dstore"
+)
+
+// FilesystemBackupModuleType is the backup module type of `NewFilesystemBackupModule`, register
+// it with `Operator.RegisterBackupModuleFactory(FilesystemBackupModuleType, nil, NewFilesystemBackupModule)`
+const FilesystemBackupModuleType = "filesystem"
+
+const (
+	filesystemBackupTimeLayout = "20060102T150405Z"
+	filesystemBackupNamePrefix = "backup-"
+)
+
+var filesystemBackupExtensions = map[string]string{
+	"gzip": ".tar.gz",
+	"zstd": ".tar.zst",
+	"none": ".tar",
+}
+
+// filesystemBackupKeyAliases are accepted in place of the config keys they map to
+var filesystemBackupKeyAliases = map[string]string{
+	"data_dir":      "data-dir",
+	"store_url":     "store-url",
+	"requires_stop": "requires-stop",
+}
+
+// FilesystemBackupModule archives a data directory as a tarball in a dstore, it implements
+// `BackupModuleV2`, `ForcibleRestoreModule`, `DescribableBackupModule` and `ListableBackupModule`.
+// Backups are named `backup-<UTC time>-<last seen block num>.<tar|tar.gz|tar.zst>`, e.g.
+// `backup-20210728T105016Z-0000012345.tar.zst`, names without the `backup-` prefix, of backups
+// taken by previous versions, are still understood.
+type FilesystemBackupModule struct {
+	dataDir      string
+	store        dstore.Store
+	compression  string
+	excludes     []string
+	requiresStop bool
+
+	now func() time.Time
+}
+
+// NewFilesystemBackupModule is a `BackupModuleFactory`, config keys are:
+//
+//   - `data-dir` (required): directory to back up and restore
+//   - `store-url` (required): dstore URL where backups are written
+//   - `compression`: `gzip` (default), `zstd` or `none`
+//   - `exclude`: comma separated globs (see `path.Match`) of paths, relative to the data directory,
+//     not backed up, an excluded directory is skipped entirely (e.g. `*.log,tmp,state/*.lock`)
+//   - `requires-stop`: `false` to back up while the node runs, `true` by default
+//
+// `data_dir`, `store_url` and `requires_stop` are accepted as well.
+func NewFilesystemBackupModule(conf BackupModuleConfig) (BackupModule, error) {
+	conf = withFilesystemBackupKeyAliases(conf)
+	if err := conf.Validate([]string{"data-dir", "store-url"}); err != nil {
+		return nil, err
+	}
+	dataDir := conf.GetString("data-dir", "")
+	storeURL := conf.GetString("store-url", "")
+
+	compression := conf.GetString("compression", "gzip")
+	if _, ok := filesystemBackupExtensions[compression]; !ok {
+		return nil, conf.errorf("compression", "invalid compression %q, must be one of gzip, zstd or none", compression)
+	}
+
+	var excludes []string
+	for _, pattern := range strings.Split(conf["exclude"], ",") {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, conf.errorf("exclude", "invalid glob %q: %w", pattern, err)
+		}
+		excludes = append(excludes, pattern)
+	}
+
+	requiresStop, err := conf.GetBool("requires-stop", true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := dstore.NewStore(storeURL, "", "", false)
+	if err != nil {
+		return nil, fmt.Errorf("creating filesystem backup store %q: %w", storeURL, err)
+	}
+
+	return &FilesystemBackupModule{
+		dataDir:      filepath.Clean(dataDir),
+		store:        store,
+		compression:  compression,
+		excludes:     excludes,
+		requiresStop: requiresStop,
+		now:          time.Now,
+	}, nil
+}
+
+func withFilesystemBackupKeyAliases(conf BackupModuleConfig) BackupModuleConfig {
+	out := make(BackupModuleConfig, len(conf))
+	for key, value := range conf {
+		out[key] = value
+	}
+	for alias, key := range filesystemBackupKeyAliases {
+		if value, found := conf[alias]; found && out[key] == "" {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+func (m *FilesystemBackupModule) RequiresStop() bool {
+	return m.requiresStop
+}
+
+func (m *FilesystemBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	return m.BackupV2(uint64(lastSeenBlockNum))
+}
+
+func (m *FilesystemBackupModule) BackupV2(lastSeenBlockNum uint64) (string, error) {
+	name := fmt.Sprintf("%s%s-%010d%s", filesystemBackupNamePrefix, m.now().UTC().Format(filesystemBackupTimeLayout), lastSeenBlockNum, filesystemBackupExtensions[m.compression])
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(m.writeArchive(writer))
+	}()
+
+	err := m.store.WriteObject(context.Background(), name, reader)
+	reader.CloseWithError(err) // unblocks the archive writer if the store stopped reading
+	if err != nil {
+		return "", fmt.Errorf("writing backup %q: %w", name, err)
+	}
+
+	return name, nil
+}
+
+func (m *FilesystemBackupModule) writeArchive(out io.Writer) error {
+	compressed, err := compressWriter(out, m.compression)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(compressed)
+	err = filepath.Walk(m.dataDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(m.dataDir, file)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if m.excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		return addToArchive(tw, file, rel, info)
+	})
+	if err != nil {
+		return fmt.Errorf("archiving %q: %w", m.dataDir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+func (m *FilesystemBackupModule) excluded(rel string) bool {
+	for _, pattern := range m.excludes {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func addToArchive(tw *tar.Writer, file, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("%q: %w", file, err)
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Info returns the creation time and block number of a backup, parsed from its name
+func (m *FilesystemBackupModule) Info(name string) (BackupInfo, error) {
+	base := name
+	for _, extension := range filesystemBackupExtensions {
+		base = strings.TrimSuffix(base, extension)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(base, filesystemBackupNamePrefix), "-", 2)
+	if base == name || len(parts) != 2 {
+		return BackupInfo{}, fmt.Errorf("invalid filesystem backup name %q", name)
+	}
+
+	createdAt, err := time.Parse(filesystemBackupTimeLayout, parts[0])
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("invalid time in filesystem backup name %q: %w", name, err)
+	}
+
+	blockNum, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("invalid block number in filesystem backup name %q: %w", name, err)
+	}
+
+	return BackupInfo{Name: name, CreatedAt: createdAt, BlockNum: blockNum}, nil
+}
+
+// List returns the backups of the store, with the creation time and block number parsed from
+// their name, other files of the store are skipped
+func (m *FilesystemBackupModule) List() ([]BackupInfo, error) {
+	var backups []BackupInfo
+	err := m.store.Walk(context.Background(), "", func(filename string) error {
+		if info, err := m.Info(filename); err == nil {
+			backups = append(backups, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing filesystem backups: %w", err)
+	}
+	return backups, nil
+}
+
+// Restore restores the backup into an empty, or missing, data directory, see `RestoreForced`
+func (m *FilesystemBackupModule) Restore(name string) error {
+	return m.RestoreForced(name, false)
+}
+
+// RestoreForced unpacks the backup in a temporary directory next to the data directory, then
+// swaps it with the existing data directory, which is deleted. A data directory which is not
+// empty is only replaced when `force` is set. The existing data is left untouched if the
+// backup cannot be downloaded or unpacked.
+func (m *FilesystemBackupModule) RestoreForced(name string, force bool) error {
+	compression := ""
+	for candidate, extension := range filesystemBackupExtensions {
+		if strings.HasSuffix(name, extension) {
+			compression = candidate
+		}
+	}
+	if compression == "" {
+		return fmt.Errorf("unknown archive format for backup %q", name)
+	}
+
+	if !force {
+		entries, err := os.ReadDir(m.dataDir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("reading data dir %q: %w", m.dataDir, err)
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("data dir %q is not empty, not restoring backup %q over it (set force=true to replace its content)", m.dataDir, name)
+		}
+	}
+
+	parentDir := filepath.Dir(m.dataDir)
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return fmt.Errorf("creating parent of data dir %q: %w", m.dataDir, err)
+	}
+
+	tempDir, err := ioutil.TempDir(parentDir, filepath.Base(m.dataDir)+".restore-")
+	if err != nil {
+		return fmt.Errorf("creating restore directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := m.extract(name, compression, tempDir); err != nil {
+		return fmt.Errorf("restoring backup %q: %w", name, err)
+	}
+
+	// `ioutil.TempDir` creates the directory with 0700
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		return fmt.Errorf("restoring backup %q: %w", name, err)
+	}
+
+	oldDir := tempDir + ".old"
+	if err := os.Rename(m.dataDir, oldDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("moving away existing data dir %q: %w", m.dataDir, err)
+	}
+
+	if err := os.Rename(tempDir, m.dataDir); err != nil {
+		os.Rename(oldDir, m.dataDir)
+		return fmt.Errorf("moving restored data to %q: %w", m.dataDir, err)
+	}
+
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("deleting previous data dir: %w", err)
+	}
+	return nil
+}
+
+func (m *FilesystemBackupModule) extract(name, compression, destDir string) error {
+	object, err := m.store.OpenObject(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	decompressed, err := decompressReader(object, compression)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	realDestDir, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(decompressed)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, destDir+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q is outside of the data directory", header.Name)
+		}
+
+		if header.Typeflag == tar.TypeSymlink && !symlinkInside(destDir, target, header.Linkname) {
+			return fmt.Errorf("archive entry %q links to %q, outside of the data directory", header.Name, header.Linkname)
+		}
+
+		inside, err := resolvesInside(realDestDir, target)
+		if err != nil {
+			return fmt.Errorf("resolving %q: %w", header.Name, err)
+		}
+		if !inside {
+			return fmt.Errorf("archive entry %q goes through links outside of the data directory", header.Name)
+		}
+
+		if err := extractEntry(tr, header, target); err != nil {
+			return fmt.Errorf("extracting %q: %w", header.Name, err)
+		}
+	}
+}
+
+// symlinkInside returns whether the symlink `target` pointing to `linkname` stays within
+// `destDir`, so the entries extracted through it later cannot be written outside of it
+func symlinkInside(destDir, target, linkname string) bool {
+	if filepath.IsAbs(linkname) {
+		return false
+	}
+
+	resolved := filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname))
+	return resolved == destDir || strings.HasPrefix(resolved, destDir+string(filepath.Separator))
+}
+
+// resolvesInside returns whether `target`, once the links already extracted along its path are
+// resolved, stays within `realDestDir`, the data directory with its own links resolved. Dangling
+// links are never considered inside, creating a file through them would write wherever they point.
+func resolvesInside(realDestDir, target string) (bool, error) {
+	existing, missing := target, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			resolved = filepath.Join(resolved, missing)
+			return resolved == realDestDir || strings.HasPrefix(resolved, realDestDir+string(filepath.Separator)), nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			return false, nil
+		}
+
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = filepath.Dir(existing)
+	}
+}
+
+func extractEntry(tr *tar.Reader, header *tar.Header, target string) error {
+	mode := os.FileMode(header.Mode).Perm()
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode)
+
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(header.Linkname, target)
+
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+
+	default:
+		// Devices, fifos and hard links have no place in a node data directory
+		return nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+func decompressReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(r), nil
+	}
+}
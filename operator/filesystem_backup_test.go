@@ -0,0 +1,303 @@
+package operator
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+}
+
+func readTestFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	require.NoError(t, filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		require.NoError(t, err)
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		require.NoError(t, err)
+		content, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	}))
+	return files
+}
+
+func newTestFilesystemBackupModule(t *testing.T, conf BackupModuleConfig) (*FilesystemBackupModule, string) {
+	t.Helper()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	conf["data-dir"] = dataDir
+	conf["store-url"] = t.TempDir()
+
+	mod, err := NewFilesystemBackupModule(conf)
+	require.NoError(t, err)
+
+	fsMod := mod.(*FilesystemBackupModule)
+	fsMod.now = func() time.Time { return time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC) }
+	return fsMod, dataDir
+}
+
+func TestFilesystemBackupModule_BackupRestore(t *testing.T) {
+	for compression, extension := range map[string]string{"gzip": ".tar.gz", "zstd": ".tar.zst", "none": ".tar"} {
+		t.Run(compression, func(t *testing.T) {
+			mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{
+				"compression": compression,
+				"exclude":     "*.log,tmp,state/*.lock",
+			})
+
+			writeTestFiles(t, dataDir, map[string]string{
+				"blocks.db":        "blocks",
+				"state/state.db":   "state",
+				"state/state.lock": "lock",
+				"node.log":         "log",
+				"tmp/scratch":      "scratch",
+			})
+
+			name, err := mod.BackupV2(12345)
+			require.NoError(t, err)
+			assert.Equal(t, "backup-20210728T105016Z-0000012345"+extension, name)
+
+			// Data changed after the backup, restoring must bring back the backed up files only
+			writeTestFiles(t, dataDir, map[string]string{
+				"blocks.db": "changed",
+				"extra.db":  "extra",
+			})
+
+			assert.Error(t, mod.Restore(name), "data dir not empty")
+			require.NoError(t, mod.RestoreForced(name, true))
+			assert.Equal(t, map[string]string{
+				"blocks.db":      "blocks",
+				"state/state.db": "state",
+			}, readTestFiles(t, dataDir))
+
+			siblings, err := ioutil.ReadDir(filepath.Dir(dataDir))
+			require.NoError(t, err)
+			var names []string
+			for _, sibling := range siblings {
+				names = append(names, sibling.Name())
+			}
+			sort.Strings(names)
+			assert.Equal(t, []string{"data"}, names, "no restore leftovers")
+		})
+	}
+}
+
+func TestFilesystemBackupModule_RestoreFailureKeepsData(t *testing.T) {
+	mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+	writeTestFiles(t, dataDir, map[string]string{"blocks.db": "blocks"})
+
+	assert.Error(t, mod.RestoreForced("backup-20210728T105016Z-0000012345.tar.gz", true))
+	assert.Error(t, mod.RestoreForced("unknown-format.zip", true))
+	assert.Equal(t, map[string]string{"blocks.db": "blocks"}, readTestFiles(t, dataDir))
+}
+
+func TestNewFilesystemBackupModule(t *testing.T) {
+	mod, _ := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+	assert.True(t, mod.RequiresStop())
+	assert.Equal(t, "gzip", mod.compression)
+
+	mod, _ = newTestFilesystemBackupModule(t, BackupModuleConfig{"requires-stop": "false"})
+	assert.False(t, mod.RequiresStop())
+
+	dataDir := filepath.Join(t.TempDir(), "data")
+	aliased, err := NewFilesystemBackupModule(BackupModuleConfig{"data_dir": dataDir, "store_url": t.TempDir(), "compression": "zstd", "requires_stop": "false"})
+	require.NoError(t, err)
+	assert.Equal(t, dataDir, aliased.(*FilesystemBackupModule).dataDir)
+	assert.Equal(t, "zstd", aliased.(*FilesystemBackupModule).compression)
+	assert.False(t, aliased.RequiresStop())
+
+	for _, conf := range []BackupModuleConfig{
+		{"store-url": "/tmp/backups"},
+		{"data-dir": "/tmp/data"},
+		{"data-dir": "/tmp/data", "store-url": "/tmp/backups", "compression": "bzip2"},
+		{"data-dir": "/tmp/data", "store-url": "/tmp/backups", "exclude": "[a"},
+		{"data-dir": "/tmp/data", "store-url": "/tmp/backups", "requires-stop": "maybe"},
+	} {
+		_, err := NewFilesystemBackupModule(conf)
+		assert.Error(t, err, "config %v", conf)
+	}
+}
+
+func TestFilesystemBackupModule_Info(t *testing.T) {
+	mod, _ := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+
+	for _, name := range []string{"backup-20210728T105016Z-0000012345.tar.zst", "20210728T105016Z-0000012345.tar.zst"} {
+		info, err := mod.Info(name)
+		require.NoError(t, err)
+		assert.Equal(t, BackupInfo{
+			Name:      name,
+			CreatedAt: time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC),
+			BlockNum:  12345,
+		}, info)
+	}
+
+	for _, name := range []string{"latest", "backup-20210728T105016Z-0000012345.zip", "backup-yesterday-0000012345.tar", "backup-20210728T105016Z-abc.tar.gz"} {
+		_, err := mod.Info(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFilesystemBackupModule_List(t *testing.T) {
+	mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{"compression": "zstd"})
+	writeTestFiles(t, dataDir, map[string]string{"blocks.db": "blocks"})
+
+	_, err := mod.BackupV2(100)
+	require.NoError(t, err)
+	mod.now = func() time.Time { return time.Date(2021, 7, 29, 10, 50, 16, 0, time.UTC) }
+	_, err = mod.BackupV2(200)
+	require.NoError(t, err)
+	require.NoError(t, mod.store.WriteObject(context.Background(), "notes.txt", strings.NewReader("not a backup")))
+
+	backups, err := mod.List()
+	require.NoError(t, err)
+	assert.Equal(t, []BackupInfo{
+		{Name: "backup-20210728T105016Z-0000000100.tar.zst", CreatedAt: time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC), BlockNum: 100},
+		{Name: "backup-20210729T105016Z-0000000200.tar.zst", CreatedAt: time.Date(2021, 7, 29, 10, 50, 16, 0, time.UTC), BlockNum: 200},
+	}, backups)
+
+	name, err := selectBackup(backups, BackupNameBeforeBlockPrefix+"150")
+	require.NoError(t, err)
+	assert.Equal(t, "backup-20210728T105016Z-0000000100.tar.zst", name.Name)
+}
+
+// writeTarBackup writes a backup of the `entries`, the regular files containing `data`,
+// returning its name
+func writeTarBackup(t *testing.T, mod *FilesystemBackupModule, entries ...*tar.Header) string {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		if entry.Typeflag == tar.TypeReg {
+			entry.Mode, entry.Size = 0644, 4
+		}
+		require.NoError(t, tw.WriteHeader(entry))
+		if entry.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("data"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	name := "backup-20210728T105016Z-0000012345.tar"
+	require.NoError(t, mod.store.WriteObject(context.Background(), name, buf))
+	return name
+}
+
+// writeSymlinkBackup writes a backup with a `link` symlink to `linkname` then a file written
+// through it, returning its name
+func writeSymlinkBackup(t *testing.T, mod *FilesystemBackupModule, linkname string) string {
+	t.Helper()
+
+	return writeTarBackup(t, mod,
+		&tar.Header{Name: "state/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: linkname},
+		&tar.Header{Name: "link/file", Typeflag: tar.TypeReg},
+	)
+}
+
+func TestFilesystemBackupModule_RestoreSymlinks(t *testing.T) {
+	mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+	require.NoError(t, restoreBackup(mod, writeSymlinkBackup(t, mod, "state"), false))
+
+	content, err := ioutil.ReadFile(filepath.Join(dataDir, "state", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(content), "written through the link within the data directory")
+
+	for _, linkname := range []string{"/tmp", "..", "state/../../outside"} {
+		t.Run(linkname, func(t *testing.T) {
+			mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+
+			err := restoreBackup(mod, writeSymlinkBackup(t, mod, linkname), false)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "outside of the data directory")
+
+			_, err = os.Stat(filepath.Join(filepath.Dir(dataDir), "file"))
+			assert.True(t, os.IsNotExist(err), "nothing written outside of the data directory")
+		})
+	}
+}
+
+func TestFilesystemBackupModule_RestoreChainedSymlinks(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []*tar.Header
+		outside string
+	}{
+		{
+			"link through an extracted link",
+			[]*tar.Header{
+				{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "x/y", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				{Name: "y/evil", Typeflag: tar.TypeReg},
+			},
+			"evil",
+		},
+		{
+			"file through a link dangling once resolved",
+			[]*tar.Header{
+				{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "c/../evil"},
+				{Name: "c", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "a", Typeflag: tar.TypeReg},
+			},
+			"evil",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+
+			err := restoreBackup(mod, writeTarBackup(t, mod, tc.entries...), false)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "outside of the data directory")
+
+			_, err = os.Lstat(filepath.Join(filepath.Dir(dataDir), tc.outside))
+			assert.True(t, os.IsNotExist(err), "nothing written outside of the data directory")
+		})
+	}
+}
+
+func TestFilesystemBackupModule_RestoreIntoEmptyDataDir(t *testing.T) {
+	mod, dataDir := newTestFilesystemBackupModule(t, BackupModuleConfig{})
+	writeTestFiles(t, dataDir, map[string]string{"blocks.db": "blocks", "state/state.db": "state"})
+
+	name, err := mod.BackupV2(12345)
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(dataDir))
+	require.NoError(t, restoreBackup(mod, name, false), "missing data dir")
+	assert.Equal(t, map[string]string{"blocks.db": "blocks", "state/state.db": "state"}, readTestFiles(t, dataDir))
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dataDir, "state")))
+	require.NoError(t, os.Remove(filepath.Join(dataDir, "blocks.db")))
+	require.NoError(t, restoreBackup(mod, name, false), "empty data dir")
+	assert.Equal(t, map[string]string{"blocks.db": "blocks", "state/state.db": "state"}, readTestFiles(t, dataDir))
+
+	writeTestFiles(t, dataDir, map[string]string{"blocks.db": "changed"})
+	err = restoreBackup(mod, name, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not empty")
+	assert.Equal(t, "changed", readTestFiles(t, dataDir)["blocks.db"], "data dir left untouched")
+}
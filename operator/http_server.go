@@ -15,12 +15,15 @@
 package operator
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/streamingfast/derr"
+	nodeManager "github.com/streamingfast/node-manager"
 	"go.uber.org/zap"
 )
 
@@ -34,15 +37,23 @@ func (o *Operator) RunHTTPServer(httpListenAddr string, options ...HTTPOption) *
 	r.HandleFunc("/v1/server_id", o.serverIDHandler).Methods("GET")
 	r.HandleFunc("/v1/is_running", o.isRunningHandler).Methods("GET")
 	r.HandleFunc("/v1/start_command", o.startcommandHandler).Methods("GET")
+	r.HandleFunc("/v1/start_args", o.startArgsHandler).Methods("GET")
+	r.HandleFunc("/v1/process", o.processHandler).Methods("GET")
 	r.HandleFunc("/v1/maintenance", o.maintenanceHandler).Methods("POST")
 	r.HandleFunc("/v1/resume", o.resumeHandler).Methods("POST")
 	r.HandleFunc("/v1/backup", o.backupHandler).Methods("POST")
 	r.HandleFunc("/v1/restore", o.restoreHandler).Methods("POST")
 	r.HandleFunc("/v1/list_backups", o.listBackupsHandler).Methods("GET")
+	r.HandleFunc("/v1/backups", o.backupsHandler).Methods("GET")
 	r.HandleFunc("/v1/reload", o.reloadHandler).Methods("POST")
 	r.HandleFunc("/v1/safely_reload", o.safelyReloadHandler).Methods("POST")
 	r.HandleFunc("/v1/safely_pause_production", o.safelyPauseProdHandler).Methods("POST")
 	r.HandleFunc("/v1/safely_resume_production", o.safelyResumeProdHandler).Methods("POST")
+	r.HandleFunc("/v1/push_rate_limit", o.pushRateLimitHandler).Methods("POST")
+	r.HandleFunc("/v1/reprocess", o.reprocessHandler).Methods("POST")
+	r.HandleFunc("/v1/mindreader/status", o.mindreaderStatusHandler).Methods("GET")
+	r.HandleFunc("/v1/mindreader/reconcile", o.continuityReconcileHandler).Methods("POST")
+	r.HandleFunc("/v1/mindreader/stop_block", o.stopBlockHandler).Methods("POST")
 
 	for _, opt := range options {
 		opt(r)
@@ -90,7 +101,25 @@ func (o *Operator) startcommandHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (o *Operator) isRunningHandler(w http.ResponseWriter, _ *http.Request) {
-	_, _ = w.Write([]byte(fmt.Sprintf(`{"is_running":%t}`, o.Superviser.IsRunning())))
+	if len(o.sidecars) == 0 {
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"is_running":%t}`, o.Superviser.IsRunning())))
+		return
+	}
+
+	sidecars := make(map[string]bool, len(o.sidecars))
+	for _, sidecar := range o.sidecars {
+		sidecars[sidecar.Name] = sidecar.Superviser.IsRunning()
+	}
+
+	out, err := json.Marshal(map[string]interface{}{
+		"is_running": o.Superviser.IsRunning(),
+		"sidecars":   sidecars,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
 }
 
 func (o *Operator) serverIDHandler(w http.ResponseWriter, _ *http.Request) {
@@ -103,12 +132,27 @@ func (o *Operator) serverIDHandler(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(id))
 }
 
-func (o *Operator) healthzHandler(w http.ResponseWriter, _ *http.Request) {
+func (o *Operator) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if o.readinessChecker != nil {
+		o.readinessHandler(w, r)
+		return
+	}
+
+	if last, found := o.lastMaintenanceTransition(); found && last.InMaintenance {
+		http.Error(w, fmt.Sprintf("not ready: in maintenance since %s (source %s): %s", last.Time.UTC().Format(time.RFC3339), last.Source, last.Reason), http.StatusServiceUnavailable)
+		return
+	}
+
 	if !o.Superviser.IsRunning() {
 		http.Error(w, "not ready: chain is not running", http.StatusServiceUnavailable)
 		return
 	}
 
+	if sidecar := o.notRunningSidecar(); sidecar != nil {
+		http.Error(w, fmt.Sprintf("not ready: sidecar %q is not running", sidecar.Name), http.StatusServiceUnavailable)
+		return
+	}
+
 	if !o.chainReadiness.IsReady() {
 		http.Error(w, "not ready: chain is not ready", http.StatusServiceUnavailable)
 		return
@@ -139,7 +183,7 @@ func (o *Operator) safelyPauseProdHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (o *Operator) restoreHandler(w http.ResponseWriter, r *http.Request) {
-	params := getRequestParams(r, "backupName", "backupTag", "forceVerify")
+	params := getRequestParams(r, "backupName", "backupTag", "forceVerify", "force")
 	o.triggerWebCommand("restore", params, w, r)
 }
 
@@ -160,16 +204,20 @@ func getRequestParams(r *http.Request, terms ...string) map[string]string {
 }
 
 func (o *Operator) backupHandler(w http.ResponseWriter, r *http.Request) {
-	o.triggerWebCommand("backup", nil, w, r)
+	o.triggerWebCommand("backup", getRequestParams(r, "consistency", "dry-run"), w, r)
 }
 
 func (o *Operator) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
-	o.triggerWebCommand("maintenance", nil, w, r)
+	params := getRequestParams(r, "reason")
+	params["source"] = nodeManager.MaintenanceSourceManual
+	o.triggerWebCommand("maintenance", params, w, r)
 }
 
 func (o *Operator) resumeHandler(w http.ResponseWriter, r *http.Request) {
 	params := map[string]string{
 		"debug-deep-mind": r.FormValue("debug-deep-mind"),
+		"reason":          r.FormValue("reason"),
+		"source":          r.FormValue("source"),
 	}
 
 	if params["debug-deep-mind"] == "" {
@@ -180,8 +228,15 @@ func (o *Operator) resumeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (o *Operator) triggerWebCommand(cmdName string, params map[string]string, w http.ResponseWriter, r *http.Request) {
-	c := &Command{cmd: cmdName, logger: o.zlogger}
+	c := &Command{cmd: cmdName, logger: o.zlogger, initiator: CommandInitiatorHTTP}
 	c.params = params
+	if r.FormValue("override-window") == "true" {
+		if c.params == nil {
+			c.params = map[string]string{}
+		}
+		c.params["override-window"] = "true"
+	}
+
 	sync := r.FormValue("sync")
 	if sync == "true" {
 		o.sendCommandSync(c, w)
@@ -192,7 +247,7 @@ func (o *Operator) triggerWebCommand(cmdName string, params map[string]string, w
 
 func (o *Operator) sendCommandAsync(c *Command, w http.ResponseWriter) {
 	o.zlogger.Info("sending async command to operator through channel", zap.Object("command", c))
-	o.commandChan <- c
+	o.enqueueCommand(c)
 	w.WriteHeader(http.StatusCreated)
 	_, _ = w.Write([]byte(fmt.Sprintf("%s command submitted\n", c.cmd)))
 }
@@ -200,7 +255,7 @@ func (o *Operator) sendCommandAsync(c *Command, w http.ResponseWriter) {
 func (o *Operator) sendCommandSync(c *Command, w http.ResponseWriter) {
 	o.zlogger.Info("sending sync command to operator through channel", zap.Object("command", c))
 	c.returnch = make(chan error)
-	o.commandChan <- c
+	o.enqueueCommand(c)
 	err := <-c.returnch
 	if err == nil {
 		w.Write([]byte(fmt.Sprintf("Success: %s completed\n", c.cmd)))
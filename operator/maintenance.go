@@ -0,0 +1,134 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/journal"
+	"github.com/streamingfast/node-manager/metrics"
+)
+
+const maintenanceHistorySize = 20
+
+type MaintenanceTransition struct {
+	Time          time.Time `json:"time"`
+	InMaintenance bool      `json:"in_maintenance"` // true when entering maintenance, false when resuming from it
+	Reason        string    `json:"reason"`
+	Source        string    `json:"source"`
+}
+
+// RequestMaintenance puts the chain in maintenance, blocking until the operator
+// processed the command. It is meant to be used as a `nodeManager.MaintenanceRequester`.
+func (o *Operator) RequestMaintenance(reason string, source string) error {
+	return o.sendCommand(&Command{
+		cmd:    "maintenance",
+		logger: o.zlogger,
+		params: map[string]string{"reason": reason, "source": source},
+	})
+}
+
+// ResumeFromMaintenance restarts the chain after a maintenance, blocking until the
+// operator processed the command. The resume is recorded as coming from `nodeManager.MaintenanceSourceManual`.
+func (o *Operator) ResumeFromMaintenance(reason string) error {
+	return o.ResumeFromMaintenanceBy(reason, nodeManager.MaintenanceSourceManual)
+}
+
+// ResumeFromMaintenanceBy is `ResumeFromMaintenance` recording `source` as what cleared the
+// maintenance in the `MaintenanceHistory`.
+func (o *Operator) ResumeFromMaintenanceBy(reason string, source string) error {
+	return o.sendCommand(&Command{
+		cmd:    "resume",
+		logger: o.zlogger,
+		params: map[string]string{"reason": reason, "source": source},
+	})
+}
+
+// MaintenanceHistory returns the last maintenance transitions, oldest first
+func (o *Operator) MaintenanceHistory() []MaintenanceTransition {
+	o.maintenanceHistoryLock.Lock()
+	defer o.maintenanceHistoryLock.Unlock()
+
+	out := make([]MaintenanceTransition, len(o.maintenanceHistory))
+	copy(out, o.maintenanceHistory)
+	return out
+}
+
+// MaintenanceStatus reports if the node is in maintenance and, when it is, the reason it was
+// put in maintenance for and since when
+func (o *Operator) MaintenanceStatus() (active bool, reason string, since time.Time) {
+	last, found := o.lastMaintenanceTransition()
+	if !found || !last.InMaintenance {
+		return false, "", time.Time{}
+	}
+	return true, last.Reason, last.Time
+}
+
+func (o *Operator) lastMaintenanceTransition() (MaintenanceTransition, bool) {
+	o.maintenanceHistoryLock.Lock()
+	defer o.maintenanceHistoryLock.Unlock()
+
+	if len(o.maintenanceHistory) == 0 {
+		return MaintenanceTransition{}, false
+	}
+	return o.maintenanceHistory[len(o.maintenanceHistory)-1], true
+}
+
+// inMaintenance reports if the last maintenance transition put the node in maintenance
+func (o *Operator) inMaintenance() bool {
+	active, _, _ := o.MaintenanceStatus()
+	return active
+}
+
+func (o *Operator) sendCommand(c *Command) error {
+	if c.initiator == "" {
+		c.initiator = CommandInitiatorAPI
+	}
+
+	c.returnch = make(chan error)
+	o.enqueueCommand(c)
+	return <-c.returnch
+}
+
+func (o *Operator) recordMaintenanceTransition(inMaintenance bool, params map[string]string) {
+	source := params["source"]
+	if source == "" {
+		source = nodeManager.MaintenanceSourceManual
+	}
+
+	if inMaintenance {
+		metrics.MaintenanceRequests.Inc(source)
+		metrics.InMaintenanceMode.SetUint64(1)
+		o.journal.Record(journal.Event{Type: journal.EventMaintenanceEntered, Reason: params["reason"], Fields: map[string]interface{}{"source": source}})
+	} else {
+		metrics.InMaintenanceMode.SetUint64(0)
+		o.journal.Record(journal.Event{Type: journal.EventMaintenanceResumed, Reason: params["reason"], Fields: map[string]interface{}{"source": source}})
+	}
+
+	o.maintenanceHistoryLock.Lock()
+	defer o.maintenanceHistoryLock.Unlock()
+
+	o.maintenanceHistory = append(o.maintenanceHistory, MaintenanceTransition{
+		Time:          time.Now(),
+		InMaintenance: inMaintenance,
+		Reason:        params["reason"],
+		Source:        source,
+	})
+
+	if len(o.maintenanceHistory) > maintenanceHistorySize {
+		o.maintenanceHistory = o.maintenanceHistory[len(o.maintenanceHistory)-maintenanceHistorySize:]
+	}
+}
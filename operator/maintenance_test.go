@@ -0,0 +1,110 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/node-manager/mindreader"
+	"github.com/streamingfast/node-manager/mindreader/mindreadertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_MaintenanceHistory(t *testing.T) {
+	o := &Operator{zlogger: zap.NewNop()}
+
+	o.recordMaintenanceTransition(true, map[string]string{"reason": "first"})
+	history := o.MaintenanceHistory()
+	require.Len(t, history, 1)
+	assert.True(t, history[0].InMaintenance)
+	assert.Equal(t, "first", history[0].Reason)
+	assert.Equal(t, "manual", history[0].Source)
+
+	for i := 0; i < 2*maintenanceHistorySize; i++ {
+		o.recordMaintenanceTransition(i%2 == 0, map[string]string{"reason": fmt.Sprintf("reason %d", i), "source": "continuity_check"})
+	}
+
+	history = o.MaintenanceHistory()
+	require.Len(t, history, maintenanceHistorySize)
+	assert.Equal(t, "reason 20", history[0].Reason)
+	assert.Equal(t, "reason 39", history[maintenanceHistorySize-1].Reason)
+	assert.False(t, history[maintenanceHistorySize-1].InMaintenance)
+}
+
+func TestOperator_MaintenanceStatus(t *testing.T) {
+	o := &Operator{zlogger: zap.NewNop()}
+
+	active, reason, since := o.MaintenanceStatus()
+	assert.False(t, active)
+	assert.Equal(t, "", reason)
+	assert.True(t, since.IsZero())
+
+	o.recordMaintenanceTransition(true, map[string]string{"reason": "disk full", "source": "disk_space"})
+	active, reason, since = o.MaintenanceStatus()
+	assert.True(t, active)
+	assert.Equal(t, "disk full", reason)
+	assert.False(t, since.IsZero())
+
+	o.recordMaintenanceTransition(false, map[string]string{"reason": "disk cleaned", "source": "ops-team"})
+	active, _, _ = o.MaintenanceStatus()
+	assert.False(t, active)
+
+	history := o.MaintenanceHistory()
+	assert.Equal(t, "ops-team", history[len(history)-1].Source, "records what cleared the maintenance")
+}
+
+func TestOperator_MaintenanceFromMindreaderReadError(t *testing.T) {
+	log := &eventLog{}
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", log), nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, o.startGroup())
+
+	go func() {
+		for {
+			select {
+			case <-o.Terminating():
+				return
+			case cmd := <-o.commandChan:
+				_ = o.executeCommand(cmd)
+			}
+		}
+	}()
+	defer o.Shutdown(nil)
+
+	consoleReaderFactory := func(ctx mindreader.ConsoleReaderContext) (mindreader.ConsolerReader, error) {
+		return mindreadertest.NewScriptedConsoleReader(ctx.Lines, mindreadertest.Step{Err: errors.New("corrupted deep mind line")}), nil
+	}
+	p, err := mindreader.NewMindReaderPluginWithStores(dstore.NewMockStore(nil), dstore.NewMockStore(nil), "never", t.TempDir(), consoleReaderFactory, 0, 0, 10, nil, func(error) {}, 0, "suffix", nil, testLogger, testTracer,
+		mindreader.WithMaintenanceRequester(o.RequestMaintenance),
+	)
+	require.NoError(t, err)
+	p.Launch()
+	defer p.Stop()
+
+	require.Eventually(t, func() bool { return o.inMaintenance() }, 5*time.Second, 10*time.Millisecond)
+
+	active, reason, since := o.MaintenanceStatus()
+	assert.True(t, active)
+	assert.Equal(t, "reading from console logs: reading block from console logs: corrupted deep mind line", reason)
+	assert.False(t, since.IsZero())
+
+	recorder := httptest.NewRecorder()
+	o.healthzHandler(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "in maintenance since")
+	assert.Contains(t, recorder.Body.String(), "(source mindreader_read_error): reading from console logs: reading block from console logs: corrupted deep mind line")
+
+	require.NoError(t, o.ResumeFromMaintenanceBy("node repaired", "ops-team"))
+	active, _, _ = o.MaintenanceStatus()
+	assert.False(t, active)
+
+	history := o.MaintenanceHistory()
+	require.Len(t, history, 2)
+	assert.Equal(t, MaintenanceTransition{Time: history[1].Time, Reason: "node repaired", Source: "ops-team"}, history[1])
+}
@@ -0,0 +1,205 @@
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maintenanceWindowPollInterval is how often deferred commands are checked for an open window
+const maintenanceWindowPollInterval = 30 * time.Second
+
+// MaintenanceWindow is a recurring period during which disruptive commands (restores, reloads
+// and backups of modules that require the node to be stopped) are allowed, see
+// `Operator.SetMaintenanceWindows`. A window may extend past midnight.
+type MaintenanceWindow struct {
+	Start    time.Duration  // time of day the window opens, from midnight (e.g. `2*time.Hour` for 02:00)
+	Duration time.Duration  // at most 24 hours
+	Weekdays []time.Weekday // days the window opens, every day when empty
+	Location *time.Location // time zone of `Start` and `Weekdays`, UTC when nil
+}
+
+func (w MaintenanceWindow) validate() error {
+	if w.Start < 0 || w.Start >= 24*time.Hour {
+		return fmt.Errorf("invalid maintenance window start %s, must be a time of day between 0s and 24h", w.Start)
+	}
+	if w.Duration <= 0 || w.Duration > 24*time.Hour {
+		return fmt.Errorf("invalid maintenance window duration %s, must be between 0s and 24h", w.Duration)
+	}
+	return nil
+}
+
+func (w MaintenanceWindow) location() *time.Location {
+	if w.Location == nil {
+		return time.UTC
+	}
+	return w.Location
+}
+
+func (w MaintenanceWindow) opensOn(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range w.Weekdays {
+		if weekday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// openingOf returns when the window opens on the day of `t`, in the window's location, which
+// may be after `t`
+func (w MaintenanceWindow) openingOf(t time.Time) time.Time {
+	year, month, day := t.In(w.location()).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, w.location()).Add(w.Start)
+}
+
+// Contains reports if the window is open at `t`
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	// A window opened the day before may still be open
+	for _, opening := range []time.Time{w.openingOf(t), w.openingOf(t.AddDate(0, 0, -1))} {
+		if w.opensOn(opening.Weekday()) && !t.Before(opening) && t.Before(opening.Add(w.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns when the window opens next after `t`, the zero time if it never opens
+func (w MaintenanceWindow) NextOpening(t time.Time) time.Time {
+	for days := 0; days <= 7; days++ {
+		opening := w.openingOf(t.AddDate(0, 0, days))
+		if w.opensOn(opening.Weekday()) && opening.After(t) {
+			return opening
+		}
+	}
+	return time.Time{}
+}
+
+// SetMaintenanceWindows restricts disruptive commands to `windows`: restores (automatic
+// ones included), reloads and backups of modules that require the node to be stopped,
+// requested outside of them, are deferred until the next window opens. Deferred commands stay
+// in `PendingCommands` with their `DeferredUntil` time and can be cancelled, callers waiting on
+// them wait until they ran. A command with the `override-window=true` param is executed right
+// away. Backups of a schedule are only deferred when the schedule has `DisruptiveOnlyInWindow`
+// set. No window, the default, defers nothing.
+func (o *Operator) SetMaintenanceWindows(windows []MaintenanceWindow) error {
+	for i, window := range windows {
+		if err := window.validate(); err != nil {
+			return fmt.Errorf("maintenance window #%d: %w", i, err)
+		}
+	}
+
+	o.maintenanceWindowsLock.Lock()
+	defer o.maintenanceWindowsLock.Unlock()
+
+	o.maintenanceWindows = windows
+	return nil
+}
+
+// inMaintenanceWindow reports if one of the windows is open at `t`, and when not, when the next
+// one opens. It is always true without windows.
+func (o *Operator) inMaintenanceWindow(t time.Time) (bool, time.Time) {
+	o.maintenanceWindowsLock.Lock()
+	defer o.maintenanceWindowsLock.Unlock()
+
+	if len(o.maintenanceWindows) == 0 {
+		return true, time.Time{}
+	}
+
+	var next time.Time
+	for _, window := range o.maintenanceWindows {
+		if window.Contains(t) {
+			return true, time.Time{}
+		}
+		if opening := window.NextOpening(t); !opening.IsZero() && (next.IsZero() || opening.Before(next)) {
+			next = opening
+		}
+	}
+	return false, next
+}
+
+// isDisruptive reports if running `cmd` stops or replaces the node's data
+func (o *Operator) isDisruptive(cmd *Command) bool {
+	switch cmd.cmd {
+	case "restore", "reload", "safely_reload":
+		return true
+
+	case "backup":
+		_, mod, err := selectBackupModule(o.backupModules, cmd.params["name"])
+		if err != nil {
+			return false
+		}
+		if consistency, err := o.backupConsistency(mod, cmd.params); err != nil || consistency != BackupConsistencyMaintenance {
+			return false
+		}
+		if sched := o.scheduleFromParams(cmd.params); sched != nil {
+			return sched.DisruptiveOnlyInWindow
+		}
+		return true
+	}
+	return false
+}
+
+// deferToMaintenanceWindow keeps a disruptive command requested outside of the maintenance
+// windows in the pending commands, returning true, until the next window opens
+func (o *Operator) deferToMaintenanceWindow(cmd *Command) bool {
+	if cmd.params["override-window"] == "true" || !o.isDisruptive(cmd) {
+		return false
+	}
+
+	inWindow, next := o.inMaintenanceWindow(o.now())
+	if inWindow {
+		return false
+	}
+
+	o.commandsLock.Lock()
+	defer o.commandsLock.Unlock()
+
+	if cmd.cancelled {
+		return false
+	}
+
+	cmd.deferredUntil = next
+	o.zlogger.Info("deferring disruptive command until next maintenance window", zap.String("id", cmd.id), zap.Object("command", cmd), zap.Time("deferred_until", next))
+	return true
+}
+
+// releaseDeferredCommands sends the deferred commands to be executed when a maintenance window
+// is open, in the order they were queued
+func (o *Operator) releaseDeferredCommands() {
+	if inWindow, _ := o.inMaintenanceWindow(o.now()); !inWindow {
+		return
+	}
+
+	o.commandsLock.Lock()
+	var released []*Command
+	for _, c := range o.pendingCommands {
+		if !c.deferredUntil.IsZero() {
+			c.deferredUntil = time.Time{}
+			released = append(released, c)
+		}
+	}
+	o.commandsLock.Unlock()
+
+	for _, c := range released {
+		o.zlogger.Info("maintenance window open, releasing deferred command", zap.String("id", c.id), zap.Object("command", c))
+		o.sendQueuedCommand(c)
+	}
+}
+
+func (o *Operator) releaseDeferredCommandsEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+			o.releaseDeferredCommands()
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	nightly := MaintenanceWindow{Start: 23 * time.Hour, Duration: 3 * time.Hour, Weekdays: []time.Weekday{time.Monday}}
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	// 2021-08-02 is a Monday
+	assert.False(t, nightly.Contains(utc("2021-08-02T22:59:59Z")))
+	assert.True(t, nightly.Contains(utc("2021-08-02T23:00:00Z")))
+	assert.True(t, nightly.Contains(utc("2021-08-03T01:59:59Z")), "window extends past midnight")
+	assert.False(t, nightly.Contains(utc("2021-08-03T02:00:00Z")))
+	assert.False(t, nightly.Contains(utc("2021-08-03T23:30:00Z")), "only opens on mondays")
+
+	assert.Equal(t, utc("2021-08-02T23:00:00Z"), nightly.NextOpening(utc("2021-08-02T12:00:00Z")).UTC())
+	assert.Equal(t, utc("2021-08-09T23:00:00Z"), nightly.NextOpening(utc("2021-08-02T23:30:00Z")).UTC())
+
+	everyDay := MaintenanceWindow{Start: 2 * time.Hour, Duration: time.Hour, Location: paris}
+	assert.True(t, everyDay.Contains(utc("2021-08-05T00:30:00Z")), "02:30 in Paris")
+	assert.False(t, everyDay.Contains(utc("2021-08-05T02:30:00Z")))
+	assert.Equal(t, utc("2021-08-06T00:00:00Z"), everyDay.NextOpening(utc("2021-08-05T02:30:00Z")).UTC())
+
+	assert.Error(t, MaintenanceWindow{Start: 25 * time.Hour, Duration: time.Hour}.validate())
+	assert.Error(t, MaintenanceWindow{Start: time.Hour}.validate())
+}
+
+func TestOperator_MaintenanceWindowDefersDisruptiveCommands(t *testing.T) {
+	o, log, _, _ := newSidecarTestOperator(t, SidecarRestartNever)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeBackupModule{log: log}))
+	o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "fake"})
+	o.RegisterBackupSchedule(&BackupSchedule{BackuperName: "fake", DisruptiveOnlyInWindow: true})
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+	log.reset()
+
+	now := time.Date(2021, 8, 2, 12, 0, 0, 0, time.UTC)
+	o.now = func() time.Time { return now }
+	require.NoError(t, o.SetMaintenanceWindows([]MaintenanceWindow{{Start: 2 * time.Hour, Duration: 2 * time.Hour}}))
+
+	run := func(name string, params map[string]string) *Command {
+		cmd := &Command{cmd: name, logger: o.zlogger, params: params}
+		o.queueCommand(cmd)
+		require.NoError(t, o.executeCommand(cmd))
+		return cmd
+	}
+
+	deferred := run("backup", nil)
+	assert.Empty(t, log.reset(), "backup requiring a stop deferred")
+	pending := o.PendingCommands()
+	require.Len(t, pending, 1)
+	assert.Equal(t, deferred.id, pending[0].ID)
+	assert.Equal(t, time.Date(2021, 8, 3, 2, 0, 0, 0, time.UTC), pending[0].DeferredUntil)
+
+	run("backup", map[string]string{"override-window": "true"})
+	assert.Equal(t, []string{"stop sidecar", "stop node", "backup", "start node", "start sidecar"}, log.reset(), "override runs right away")
+
+	run("backup", map[string]string{"name": "fake", "schedule": "0"})
+	assert.Len(t, log.reset(), 5, "schedule without DisruptiveOnlyInWindow runs right away")
+
+	run("backup", map[string]string{"name": "fake", "schedule": "1"})
+	assert.Empty(t, log.reset())
+	require.Len(t, o.PendingCommands(), 2)
+
+	o.releaseDeferredCommands()
+	assert.Len(t, o.commandChan, 0, "window not open yet")
+
+	now = time.Date(2021, 8, 3, 2, 30, 0, 0, time.UTC)
+	o.releaseDeferredCommands()
+	require.Len(t, o.commandChan, 2)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, o.executeCommand(<-o.commandChan))
+	}
+	assert.Equal(t, []string{
+		"stop sidecar", "stop node", "backup", "start node", "start sidecar",
+		"stop sidecar", "stop node", "backup", "start node", "start sidecar",
+	}, log.reset(), "deferred commands run in the window, in order")
+	assert.Empty(t, o.PendingCommands())
+}
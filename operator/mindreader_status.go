@@ -0,0 +1,28 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	nodeManager "github.com/streamingfast/node-manager"
+)
+
+// RegisterMindreaderStatusProvider serves the status of `provider` on the `/v1/mindreader/status`
+// HTTP endpoint.
+func (o *Operator) RegisterMindreaderStatusProvider(provider nodeManager.MindreaderStatusProvider) {
+	o.mindreaderStatusProvider = provider
+}
+
+func (o *Operator) mindreaderStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	if o.mindreaderStatusProvider == nil {
+		http.Error(w, "no mindreader registered", http.StatusNotFound)
+		return
+	}
+
+	out, err := json.Marshal(o.mindreaderStatusProvider.Status())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
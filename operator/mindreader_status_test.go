@@ -0,0 +1,70 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeMindreaderStatusProvider struct {
+	status nodeManager.MindreaderStatus
+}
+
+func (p *fakeMindreaderStatusProvider) Status() nodeManager.MindreaderStatus { return p.status }
+
+func TestOperator_MindreaderStatusHandler(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	o.mindreaderStatusHandler(recorder, httptest.NewRequest("GET", "/v1/mindreader/status", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	o.RegisterMindreaderStatusProvider(&fakeMindreaderStatusProvider{nodeManager.MindreaderStatus{
+		LastStoredBlockNum:      104,
+		LastStoredBlockID:       "00000104a",
+		LastStoredBlockTime:     time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC),
+		BufferedBlocks:          8,
+		ChannelCapacity:         10,
+		BufferedBytes:           2048,
+		MaxBufferedBytes:        4096,
+		PendingUploads:          3,
+		PendingMergedUploads:    1,
+		QuarantinedUploads:      2,
+		ContinuityCheckerActive: true,
+		ContinuityHighWatermark: 104,
+		LastBlockReadTime:       time.Date(2021, 7, 28, 10, 50, 17, 0, time.UTC),
+		SinceLastBlockRead:      2 * time.Second,
+	}})
+
+	recorder = httptest.NewRecorder()
+	o.mindreaderStatusHandler(recorder, httptest.NewRequest("GET", "/v1/mindreader/status", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var status map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, map[string]interface{}{
+		"last_stored_block_num":     float64(104),
+		"last_stored_block_id":      "00000104a",
+		"last_stored_block_time":    "2021-07-28T10:50:16Z",
+		"buffered_blocks":           float64(8),
+		"channel_capacity":          float64(10),
+		"buffered_bytes":            float64(2048),
+		"max_buffered_bytes":        float64(4096),
+		"pending_uploads":           float64(3),
+		"pending_merged_uploads":    float64(1),
+		"quarantined_uploads":       float64(2),
+		"continuity_checker_active": true,
+		"continuity_high_watermark": float64(104),
+		"last_block_read_time":      "2021-07-28T10:50:17Z",
+		"since_last_block_read":     float64(2 * time.Second),
+		"dropped_lines":             float64(0),
+	}, status)
+}
@@ -17,8 +17,10 @@ package operator
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +28,8 @@ import (
 	"github.com/streamingfast/derr"
 	"github.com/streamingfast/dstore"
 	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/journal"
+	"github.com/streamingfast/node-manager/metrics"
 	"github.com/streamingfast/shutter"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
@@ -37,9 +41,35 @@ type Operator struct {
 	options          *Options
 	lastStartCommand time.Time
 
-	bootstrapper    Bootstrapper
-	backupModules   map[string]BackupModule
-	backupSchedules []*BackupSchedule
+	bootstrapper          Bootstrapper
+	backupModules         map[string]BackupModule
+	backupModuleFactories map[string]BackupModuleFactory
+	backupSchedules       []*BackupSchedule
+	backupHooks           []*backupHook
+	sidecars              []*Sidecar
+
+	blockSchedules       []*blockSchedule
+	blockSchedulesLock   sync.Mutex
+	backupSchedulesState map[string]*backupScheduleState // keyed by `blockSchedule.key`
+	headBlockUpdated     atomic.Bool
+
+	pushRateLimitSetter       nodeManager.PushRateLimitSetter
+	stopBlockSetter           nodeManager.StopBlockSetter
+	continuityCheckerResetter nodeManager.ContinuityCheckerResetter
+	uploadPauser              nodeManager.UploadPauser
+	mindreaderStatusProvider  nodeManager.MindreaderStatusProvider
+	reprocessing              *reprocessing // only set once `RegisterReprocessing` is called
+
+	startupLines        *startupLinesLogPlugin // only set when auto restoring on dirty start matches log lines
+	autoRestoreAttempts int
+
+	restartRetries int
+	lastRestart    time.Time
+	recentFailures []time.Time // failures of the node within the crash loop window
+
+	safeShutdownOnce   sync.Once
+	safeShuttingDown   atomic.Bool
+	safeShutdownResult *SafeShutdownResult
 
 	commandChan    chan *Command
 	httpServer     *http.Server
@@ -48,6 +78,31 @@ type Operator struct {
 	aboutToStop    *atomic.Bool
 	snapshotStore  dstore.Store
 	zlogger        *zap.Logger
+
+	maintenanceHistory     []MaintenanceTransition
+	maintenanceHistoryLock sync.Mutex
+
+	maintenanceWindows     []MaintenanceWindow
+	maintenanceWindowsLock sync.Mutex
+	now                    func() time.Time
+
+	statFilesystem func(directory string) (filesystemUsage, error)
+	diskLevels     map[uint64]diskLevel // by filesystem, only accessed by the disk monitor
+
+	commandsLock    sync.Mutex
+	nextCommandID   uint64
+	pendingCommands []*Command
+	runningCommand  *Command
+	commandHistory  []CommandResult
+
+	shutdownEscalationLock sync.Mutex
+	lastShutdownEscalation *ShutdownEscalation
+	runningBackup          BackupModule // cancelled when the node process is killed, see `CancellableBackupModule`
+
+	blocksDroppedErr atomic.Error // see `RegisterBlocksDroppedNotifier`
+
+	readinessChecker *ReadinessChecker // see `Options.ReadinessCheck`
+	journal          *journal.Journal  // nil without `Options.EventJournalDirectory`, recording nothing
 }
 
 type Bootstrapper interface {
@@ -61,6 +116,52 @@ type Options struct {
 
 	// Delay before sending Stop() to superviser, during which we return NotReady
 	ShutdownDelay time.Duration
+
+	// AutoRestoreOnDirtyStart, when set, restores a backup and restarts the node when it stops
+	// after a dirty start instead of shutting down, see `DirtyStartPolicy`
+	AutoRestoreOnDirtyStart *DirtyStartPolicy
+
+	// RestartPolicy governs whether the node is relaunched when it stops on its own, the operator
+	// shuts down when it is not set, see `RestartPolicy`
+	RestartPolicy RestartPolicy
+
+	// CrashLoopMaxFailures failures of the node within CrashLoopWindow put it in maintenance
+	// instead of restarting it, defaults to 5 failures within 10 minutes
+	CrashLoopMaxFailures int
+	CrashLoopWindow      time.Duration
+
+	// SafeShutdown customizes the shutdown sequence run when the operator is shut down, see
+	// `Operator.SafeShutdown`
+	SafeShutdown SafeShutdownOptions
+
+	// WorkingDirectory holds the operator's state, like the last backup of block-based backup
+	// schedules, nothing is persisted when empty
+	WorkingDirectory string
+
+	// DiskMonitor, when set, monitors the free space of the node data directory and of the
+	// mindreader working directory, see `DiskMonitorOptions`
+	DiskMonitor *DiskMonitorOptions
+
+	// ReadinessTimeout bounds the wait for the node to be ready when resuming, the node stays
+	// in maintenance when not ready in time, defaults to 5 minutes. Only supervisers implementing
+	// `nodeManager.ReadinessChainSuperviser` are waited for.
+	ReadinessTimeout time.Duration
+
+	// BackupHookTimeout bounds each pre-backup and post-backup hook, defaults to 5 minutes, see
+	// `Operator.RegisterBackupHook`
+	BackupHookTimeout time.Duration
+
+	// ReadinessCheck, when set, makes `/healthz` report, in JSON, the readiness computed from the
+	// node process, maintenance and head block drift, see `ReadinessCheckOptions`
+	ReadinessCheck *ReadinessCheckOptions
+
+	// EventJournalDirectory, when set, records the maintenance transitions in the `operator`
+	// journal of the directory, for post-mortems, see `journal.Journal`
+	EventJournalDirectory string
+
+	// ShutdownEscalation, when set, escalates the signals sent to the node process when it does
+	// not exit once stopped, up to SIGKILL, see `ShutdownEscalationPolicy`
+	ShutdownEscalation *ShutdownEscalationPolicy
 }
 
 type Command struct {
@@ -69,6 +170,16 @@ type Command struct {
 	returnch chan error
 	closer   sync.Once
 	logger   *zap.Logger
+	err      error // error returned to the caller, set by `Return`
+
+	// set by the operator when queued, see `enqueueCommand`
+	id            string
+	initiator     string
+	enqueuedAt    time.Time
+	startedAt     time.Time
+	progress      string
+	cancelled     bool
+	deferredUntil time.Time // set while deferred to the next maintenance window
 }
 
 func (c *Command) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
@@ -80,6 +191,18 @@ func (c *Command) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
 func New(zlogger *zap.Logger, chainSuperviser nodeManager.ChainSuperviser, chainReadiness nodeManager.Readiness, options *Options) (*Operator, error) {
 	zlogger.Info("creating operator", zap.Reflect("options", options))
 
+	if options.DiskMonitor != nil {
+		if err := options.DiskMonitor.validate(); err != nil {
+			return nil, fmt.Errorf("invalid disk monitor options: %w", err)
+		}
+	}
+
+	if options.ReadinessCheck != nil {
+		if err := options.ReadinessCheck.validate(); err != nil {
+			return nil, fmt.Errorf("invalid readiness check options: %w", err)
+		}
+	}
+
 	o := &Operator{
 		Shutter:        shutter.New(),
 		chainReadiness: chainReadiness,
@@ -88,31 +211,46 @@ func New(zlogger *zap.Logger, chainSuperviser nodeManager.ChainSuperviser, chain
 		Superviser:     chainSuperviser,
 		aboutToStop:    atomic.NewBool(false),
 		zlogger:        zlogger,
+		now:            time.Now,
+		statFilesystem: statFilesystem,
+		diskLevels:     map[uint64]diskLevel{},
 	}
 
+	if options.ReadinessCheck != nil {
+		o.readinessChecker = newReadinessChecker(*options.ReadinessCheck)
+	}
+
+	if options.EventJournalDirectory != "" {
+		var err error
+		if o.journal, err = journal.New(options.EventJournalDirectory, "operator", zlogger); err != nil {
+			return nil, fmt.Errorf("event journal: %w", err)
+		}
+		o.OnTerminated(func(_ error) { o.journal.Close() })
+	}
+
+	o.setupDirtyStartPolicy()
+	o.setupRestartPolicy()
+
 	chainSuperviser.OnTerminated(func(err error) {
-		if !o.IsTerminating() {
+		if !o.IsTerminating() && !o.safeShuttingDown.Load() {
 			zlogger.Info("chain superviser is shutting down operator")
 			o.Shutdown(err)
 		}
 	})
 
 	o.OnTerminating(func(err error) {
-		//wait for supervisor to terminate, supervisor will wait for plugins to terminate
-		if !chainSuperviser.IsTerminating() {
-			zlogger.Info("operator is terminating", zap.Error(err))
-			chainSuperviser.Shutdown(err)
-		}
-
-		zlogger.Info("operator is waiting for superviser to shutdown", zap.Error(err))
-		<-o.Superviser.Terminated()
-		zlogger.Info("operator done waiting for superviser to shutdown", zap.Error(err))
+		zlogger.Info("operator is terminating", zap.Error(err))
+		o.safeShutdown(context.Background(), o.options.SafeShutdown, err)
 	})
 
 	return o, nil
 }
 
 func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
+	if err := o.validateSchedulesOnLaunch(); err != nil {
+		return err
+	}
+
 	o.zlogger.Info("launching operator HTTP server", zap.String("http_listen_addr", httpListenAddr))
 	o.httpServer = o.RunHTTPServer(httpListenAddr, options...)
 
@@ -125,6 +263,20 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 
 	o.LaunchBackupSchedules()
 
+	for _, sidecar := range o.sidecars {
+		go o.watchSidecar(sidecar)
+	}
+
+	go o.releaseDeferredCommandsEvery(maintenanceWindowPollInterval)
+
+	if o.options.DiskMonitor != nil {
+		go o.monitorDiskSpace(o.options.DiskMonitor)
+	}
+
+	if o.readinessChecker != nil {
+		go o.evaluateReadinessEvery(o.readinessChecker.interval)
+	}
+
 	if o.options.Bootstrapper != nil {
 		o.zlogger.Info("Operator calling bootstrap function")
 		err := o.options.Bootstrapper.Bootstrap()
@@ -132,39 +284,50 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 			return fmt.Errorf("unable to bootstrap chain: %w", err)
 		}
 	}
-	o.commandChan <- &Command{cmd: "start", logger: o.zlogger}
+	o.enqueueCommand(&Command{cmd: "start", logger: o.zlogger})
 
+	// The stopped channel of a node process stays closed until the node is started again, it is
+	// only handled once
+	var handledStopped <-chan struct{}
 	for {
 		o.zlogger.Info("operator ready to receive commands")
+		stopped := o.Superviser.Stopped()
+		if stopped == handledStopped {
+			stopped = nil
+		}
+
 		select {
-		case <-o.Superviser.Stopped(): // the chain stopped outside of a command that was expecting it.
-			if o.Superviser.IsTerminating() {
+		case <-stopped: // the chain stopped outside of a command that was expecting it.
+			handledStopped = stopped
+			if o.Superviser.IsTerminating() || o.safeShuttingDown.Load() {
 				o.zlogger.Info("superviser terminating, waiting for operator...")
 				<-o.Terminating()
 				return o.Err()
 			}
-			// FIXME call a restore handler if passed...
+			if o.autoRestoreOnDirtyStart() {
+				continue
+			}
+			if o.restartOnUnexpectedStop() {
+				continue
+			}
+
 			lastLogLines := o.Superviser.LastLogLines()
 
 			// FIXME: Actually, we should create a custom error type that contains the required data, the catching
 			//        code can thus perform the required formatting!
-			baseFormat := "instance %q stopped (exit code: %d), shutting down"
+			baseFormat := "instance %q stopped (%s), shutting down"
 			var shutdownErr error
 			if len(lastLogLines) > 0 {
-				shutdownErr = fmt.Errorf(baseFormat+": last log lines:\n%s", o.Superviser.GetName(), o.Superviser.LastExitCode(), formatLogLines(lastLogLines))
+				shutdownErr = fmt.Errorf(baseFormat+": last log lines:\n%s", o.Superviser.GetName(), o.LastExitStatus(), formatLogLines(lastLogLines))
 			} else {
-				shutdownErr = fmt.Errorf(baseFormat, o.Superviser.GetName(), o.Superviser.LastExitCode())
+				shutdownErr = fmt.Errorf(baseFormat, o.Superviser.GetName(), o.LastExitStatus())
 			}
 
 			o.Shutdown(shutdownErr)
 			break
 
 		case cmd := <-o.commandChan:
-			if cmd.cmd == "start" { // start 'sub' commands after a restore do NOT come through here
-				o.lastStartCommand = time.Now()
-			}
-			err := o.runCommand(cmd)
-			cmd.Return(err)
+			err := o.executeCommand(cmd)
 			if err != nil {
 				if err == ErrCleanExit {
 					return nil
@@ -175,6 +338,26 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 	}
 }
 
+// executeCommand runs a queued command unless it was cancelled, returning an error for irrecoverable states
+func (o *Operator) executeCommand(cmd *Command) error {
+	if o.deferToMaintenanceWindow(cmd) {
+		return nil
+	}
+
+	if !o.startCommand(cmd) {
+		o.zlogger.Info("skipping cancelled command", zap.String("id", cmd.id), zap.Object("command", cmd))
+		return nil
+	}
+
+	if cmd.cmd == "start" { // start 'sub' commands after a restore do NOT come through here
+		o.lastStartCommand = time.Now()
+	}
+	err := o.runCommand(cmd)
+	cmd.Return(err)
+	o.finishCommand(cmd, cmd.err)
+	return err
+}
+
 func formatLogLines(lines []string) string {
 	formattedLines := make([]string, len(lines))
 	for i, line := range lines {
@@ -205,7 +388,7 @@ func (o *Operator) waitForReadFlowToComplete() {
 	}()
 
 	go func() {
-		err := o.Superviser.Stop()
+		err := o.stopGroup()
 		if err != nil {
 			o.zlogger.Error("unable to close Superviser gracefully", zap.Error(err))
 		}
@@ -231,7 +414,7 @@ func (o *Operator) cleanSuperviserStop() error {
 		time.Sleep(o.options.ShutdownDelay)
 	}
 
-	err := o.Superviser.Stop()
+	err := o.stopGroup()
 	return err
 }
 
@@ -247,7 +430,8 @@ func (o *Operator) runCommand(cmd *Command) error {
 		}
 
 		// Careful, we are now "stopped". Every other case can handle that state.
-		o.zlogger.Info("successfully put in maintenance")
+		o.recordMaintenanceTransition(true, cmd.params)
+		o.zlogger.Info("successfully put in maintenance", zap.String("reason", cmd.params["reason"]), zap.String("source", cmd.params["source"]))
 
 	case "restore":
 		restoreMod, err := selectRestoreModule(o.backupModules, cmd.params["name"])
@@ -256,51 +440,97 @@ func (o *Operator) runCommand(cmd *Command) error {
 			return nil
 		}
 
-		o.zlogger.Info("Stopping to restore a backup")
-		if restoreMod.RequiresStop() {
-			if err := o.cleanSuperviserStop(); err != nil {
+		// an automatic restore happens after the node stopped, sidecars may still be running
+		autoRestore := cmd.params["auto_restore"] == "true"
+
+		backupName := BackupNameLatest
+		if b, ok := cmd.params["backupName"]; ok {
+			backupName = b
+		}
+
+		backupName, err = o.resolveBackupName(restoreMod, backupName)
+		if err != nil {
+			if autoRestore {
+				metrics.AutoRestoreSteps.Inc("restore_failed")
 				return err
 			}
+			cmd.Return(err)
+			return nil
 		}
 
-		backupName := "latest"
-		if b, ok := cmd.params["backupName"]; ok {
-			backupName = b
+		if err := o.checkRestoreBlockHeight(restoreMod, backupName, cmd.params["force"] == "true"); err != nil {
+			if autoRestore {
+				metrics.AutoRestoreSteps.Inc("restore_failed")
+				return err
+			}
+			cmd.Return(err)
+			return nil
+		}
+
+		o.zlogger.Info("Stopping to restore a backup")
+		if restoreMod.RequiresStop() || autoRestore {
+			o.setCommandProgress(cmd, "stopping node")
+			if err := o.cleanSuperviserStop(); err != nil {
+				return err
+			}
 		}
 
-		if err := restoreMod.Restore(backupName); err != nil {
+		o.setCommandProgress(cmd, fmt.Sprintf("restoring backup %q", backupName))
+		if err := restoreBackup(restoreMod, backupName, cmd.params["force"] == "true"); err != nil {
+			if autoRestore {
+				metrics.AutoRestoreSteps.Inc("restore_failed")
+			}
 			return err
 		}
+		if autoRestore {
+			metrics.AutoRestoreSteps.Inc("restored")
+		}
+		o.resetContinuityChecker()
 
 		o.zlogger.Info("Restarting after restore")
-		if restoreMod.RequiresStop() {
-			return o.runSubCommand("start", cmd)
+		if restoreMod.RequiresStop() || autoRestore {
+			o.setCommandProgress(cmd, "restarting node")
+			if err := o.runSubCommand("start", cmd); err != nil {
+				return err
+			}
+			if autoRestore {
+				metrics.AutoRestoreSteps.Inc("restarted")
+			}
 		}
 		return nil
 
 	case "backup":
-		backupMod, err := selectBackupModule(o.backupModules, cmd.params["name"])
+		backupModName, backupMod, err := selectBackupModule(o.backupModules, cmd.params["name"])
 		if err != nil {
 			cmd.Return(err)
 			return nil
 		}
 
-		o.zlogger.Info("Stopping to perform a backup")
-		if backupMod.RequiresStop() {
-			if err := o.cleanSuperviserStop(); err != nil {
-				return err
-			}
+		consistency, err := o.backupConsistency(backupMod, cmd.params)
+		if err != nil {
+			cmd.Return(err)
+			return nil
 		}
 
-		backupName, err := backupMod.Backup(uint32(o.Superviser.LastSeenBlockNum()))
+		if o.isDryRunBackup(cmd.params) {
+			o.dryRunBackup(cmd, backupModName, backupMod, consistency)
+			return nil
+		}
+
+		hooks, err := o.runPreBackupHooks()
 		if err != nil {
-			return err
+			_ = o.runPostBackupHooks(hooks, "", err)
+			cmd.Return(fmt.Errorf("backup aborted: %w", err))
+			return nil
 		}
-		cmd.logger.Info("Completed backup", zap.String("backup_name", backupName))
 
-		o.zlogger.Info("Restarting after backup")
-		if backupMod.RequiresStop() {
-			return o.runSubCommand("start", cmd)
+		backupName, err := o.backup(cmd, backupModName, backupMod, consistency)
+		postErr := o.runPostBackupHooks(hooks, backupName, err)
+		if err != nil {
+			return err
+		}
+		if postErr != nil {
+			cmd.Return(fmt.Errorf("backup %q completed: %w", backupName, postErr))
 		}
 		return nil
 
@@ -395,6 +625,7 @@ func (o *Operator) runCommand(cmd *Command) error {
 			select {
 			case interimCmd := <-o.commandChan:
 				o.zlogger.Info("emptying command queue while safely_reload was running, dropped", zap.Any("interim_cmd", interimCmd))
+				o.dropCommand(interimCmd, fmt.Errorf("dropped by safely_reload command"))
 			default:
 				emptied = true
 			}
@@ -402,9 +633,21 @@ func (o *Operator) runCommand(cmd *Command) error {
 
 		return o.runSubCommand("reload", cmd)
 
+	case "sidecar_stopped":
+		return o.handleSidecarStopped(cmd)
+
+	case "set_push_rate_limit":
+		return o.handleSetPushRateLimit(cmd)
+
+	case "reprocess":
+		return o.handleReprocess(cmd)
+
+	case "reprocess_done":
+		return o.handleReprocessDone(cmd)
+
 	case "start", "resume":
 		o.zlogger.Info("preparing for start")
-		if o.Superviser.IsRunning() {
+		if o.Superviser.IsRunning() && o.notRunningSidecar() == nil {
 			o.zlogger.Info("chain is already running")
 			return nil
 		}
@@ -420,8 +663,16 @@ func (o *Operator) runCommand(cmd *Command) error {
 			}
 		}
 
-		if err := o.Superviser.Start(options...); err != nil {
-			return fmt.Errorf("error starting chain superviser: %w", err)
+		if err := o.startGroup(options...); err != nil {
+			return err
+		}
+
+		if cmd.cmd == "resume" {
+			if err := o.waitUntilNodeReady(); err != nil {
+				return err
+			}
+			o.recordMaintenanceTransition(false, cmd.params)
+			o.zlogger.Info("resumed from maintenance", zap.String("reason", cmd.params["reason"]), zap.String("source", cmd.params["source"]))
 		}
 
 		o.zlogger.Info("successfully start service")
@@ -431,12 +682,77 @@ func (o *Operator) runCommand(cmd *Command) error {
 	return nil
 }
 
+// backup stops the node or pauses its uploads, as required by `consistency`, runs the backup of
+// `mod` and restarts the node
+func (o *Operator) backup(cmd *Command, modName string, mod BackupModule, consistency BackupConsistency) (backupName string, err error) {
+	sched := o.scheduleFromParams(cmd.params)
+
+	switch consistency {
+	case BackupConsistencyMaintenance:
+		o.zlogger.Info("Stopping to perform a backup")
+		o.setCommandProgress(cmd, "stopping node")
+		if err := o.cleanSuperviserStop(); err != nil {
+			return "", err
+		}
+		if sched != nil {
+			o.recordMaintenanceTransition(true, map[string]string{
+				"reason": fmt.Sprintf("scheduled backup %s (%s)", modName, o.backupScheduleLabel(cmd.params)),
+				"source": nodeManager.MaintenanceSourceBackupSchedule,
+			})
+		}
+
+	case BackupConsistencyQuiesce:
+		if o.uploadPauser == nil {
+			o.zlogger.Warn("no upload pauser registered, performing backup without pausing uploads")
+			break
+		}
+		o.zlogger.Info("Pausing uploads to perform a backup")
+		o.setCommandProgress(cmd, "pausing uploads")
+		o.uploadPauser.PauseUploads()
+	}
+
+	o.setCommandProgress(cmd, "running backup")
+	backupBlockNum := o.Superviser.LastSeenBlockNum()
+	backupName, err = o.runRecordedBackup(modName, mod, o.backupScheduleLabel(cmd.params), backupBlockNum)
+	if consistency == BackupConsistencyQuiesce && o.uploadPauser != nil {
+		o.zlogger.Info("Resuming uploads after backup")
+		o.uploadPauser.ResumeUploads()
+	}
+	if err != nil {
+		return backupName, err
+	}
+	cmd.logger.Info("Completed backup", zap.String("backup_name", backupName))
+
+	if consistency == BackupConsistencyMaintenance {
+		o.zlogger.Info("Restarting after backup")
+		o.setCommandProgress(cmd, "restarting node")
+		if err := o.runSubCommand("start", cmd); err != nil {
+			return backupName, err
+		}
+		if sched != nil {
+			o.recordMaintenanceTransition(false, map[string]string{
+				"reason": fmt.Sprintf("scheduled backup %s completed", modName),
+				"source": nodeManager.MaintenanceSourceBackupSchedule,
+			})
+		}
+	}
+
+	if sched != nil {
+		o.recordScheduledBackup(cmd.params, backupBlockNum)
+
+		o.setCommandProgress(cmd, "applying retention policy")
+		o.applyRetentionPolicy(mod, sched.RetentionPolicy, backupName)
+	}
+	return backupName, nil
+}
+
 func (c *Command) Return(err error) {
 	c.closer.Do(func() {
 		if err != nil && err != ErrCleanExit {
 			c.logger.Error("command failed", zap.String("cmd", c.cmd), zap.Error(err))
 		}
 
+		c.err = err
 		if c.returnch != nil {
 			c.returnch <- err
 		}
@@ -444,14 +760,23 @@ func (c *Command) Return(err error) {
 }
 
 func (o *Operator) LaunchBackupSchedules() {
-	for _, sched := range o.backupSchedules {
+	o.blockSchedulesLock.Lock()
+	defer o.blockSchedulesLock.Unlock()
+	o.loadBackupSchedulesState()
+
+	for i, sched := range o.backupSchedules {
 		if sched.RequiredHostnameMatch != "" {
 			hostname, err := os.Hostname()
 			if err != nil {
 				o.zlogger.Error("Disabling automatic backup schedule because requiredHostname is set and cannot retrieve hostname", zap.Error(err))
 				continue
 			}
-			if sched.RequiredHostnameMatch != hostname {
+			matched, err := MatchHostname(sched.RequiredHostnameMatch, hostname)
+			if err != nil {
+				o.zlogger.Error("Disabling automatic backup schedule because requiredHostname is invalid", zap.Error(err))
+				continue
+			}
+			if !matched {
 				o.zlogger.Info("Disabling automatic backup schedule because hostname does not match required value",
 					zap.String("hostname", hostname),
 					zap.String("required_hostname", sched.RequiredHostnameMatch),
@@ -460,7 +785,7 @@ func (o *Operator) LaunchBackupSchedules() {
 			}
 		}
 
-		cmdParams := map[string]string{"name": sched.BackuperName}
+		cmdParams := map[string]string{"name": sched.BackuperName, "schedule": strconv.Itoa(i)}
 
 		if sched.TimeBetweenRuns > time.Second { //loose validation of not-zero (I've seen issues with .IsZero())
 			o.zlogger.Info("starting time-based schedule for backup",
@@ -469,14 +794,31 @@ func (o *Operator) LaunchBackupSchedules() {
 			)
 			go o.RunEveryPeriod(sched.TimeBetweenRuns, "backup", cmdParams)
 		}
+		if sched.CronExpression != "" {
+			cron, err := parseCronExpression(sched.CronExpression)
+			if err != nil {
+				o.zlogger.Error("Disabling automatic backup schedule because its cron expression is invalid", zap.String("backuper_name", sched.BackuperName), zap.Error(err))
+				continue
+			}
+			o.zlogger.Info("starting cron-based schedule for backup",
+				zap.String("cron_expression", sched.CronExpression),
+				zap.Time("next_run", cron.next(o.now())),
+				zap.String("backuper_name", sched.BackuperName),
+			)
+			go o.runOnCron(cron, "backup", cmdParams)
+		}
 		if sched.BlocksBetweenRuns > 0 {
 			o.zlogger.Info("starting block-based schedule for backup",
-				zap.Int("blocks_between_runs", sched.BlocksBetweenRuns),
+				zap.Uint64("blocks_between_runs", sched.BlocksBetweenRuns),
 				zap.String("backuper_name", sched.BackuperName),
 			)
-			go o.RunEveryXBlock(uint32(sched.BlocksBetweenRuns), "backup", cmdParams)
+			o.blockSchedules = append(o.blockSchedules, newBlockSchedule(i, sched))
 		}
 	}
+
+	if len(o.blockSchedules) > 0 {
+		go o.pollBlockSchedules()
+	}
 }
 
 func (o *Operator) RunEveryPeriod(period time.Duration, commandName string, params map[string]string) {
@@ -492,13 +834,15 @@ func (o *Operator) RunEveryPeriod(period time.Duration, commandName string, para
 		select {
 		case <-ticker.C:
 			if o.Superviser.IsRunning() {
-				o.commandChan <- &Command{cmd: commandName, logger: o.zlogger, params: params}
+				o.enqueueCommand(&Command{cmd: commandName, logger: o.zlogger, params: params, initiator: CommandInitiatorSchedule})
 			}
 		}
 	}
 }
 
-func (o *Operator) RunEveryXBlock(freq uint32, commandName string, params map[string]string) {
+// RunEveryXBlock queues the command every `freq` blocks seen by the superviser, polled every second.
+// Block-based backup schedules are evaluated on head block updates instead, see `UpdateHeadBlock`.
+func (o *Operator) RunEveryXBlock(freq uint64, commandName string, params map[string]string) {
 	var lastHeadReference uint64
 	for {
 		time.Sleep(1 * time.Second)
@@ -511,9 +855,18 @@ func (o *Operator) RunEveryXBlock(freq uint32, commandName string, params map[st
 			lastHeadReference = lastSeenBlockNum
 		}
 
-		if lastSeenBlockNum > lastHeadReference+uint64(freq) {
-			o.commandChan <- &Command{cmd: commandName, logger: o.zlogger, params: params}
+		if blocksElapsed(lastHeadReference, lastSeenBlockNum, freq) {
+			o.enqueueCommand(&Command{cmd: commandName, logger: o.zlogger, params: params, initiator: CommandInitiatorSchedule})
 			lastHeadReference = lastSeenBlockNum
 		}
 	}
 }
+
+// blocksElapsed returns whether more than `freq` blocks were seen since `reference`, a
+// `freq` going over the uint64 range never elapses
+func blocksElapsed(reference, lastSeenBlockNum, freq uint64) bool {
+	if reference > math.MaxUint64-freq {
+		return false
+	}
+	return lastSeenBlockNum > reference+freq
+}
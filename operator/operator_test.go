@@ -0,0 +1,27 @@
+package operator
+
+import (
+	"testing"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestOperator returns an operator supervising `node` with `options`, logging nowhere
+func newTestOperator(t *testing.T, node nodeManager.ChainSuperviser, options *Options) *Operator {
+	t.Helper()
+
+	o, err := New(zap.NewNop(), node, nil, options)
+	require.NoError(t, err)
+	return o
+}
+
+// startTestOperator starts the node of `o` through the start command, then forgets the events
+// logged in `log` doing so
+func startTestOperator(t *testing.T, o *Operator, log *eventLog) {
+	t.Helper()
+
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+	log.reset()
+}
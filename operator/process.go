@@ -0,0 +1,69 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+)
+
+const defaultReadinessTimeout = 5 * time.Minute
+
+// ProcessStatus describes the node process, for the `/v1/process` endpoint
+type ProcessStatus struct {
+	IsRunning bool `json:"is_running"`
+	nodeManager.ProcessStats
+	LastExit *nodeManager.ExitStatus `json:"last_exit,omitempty"`
+
+	LastShutdownEscalation *ShutdownEscalation `json:"last_shutdown_escalation,omitempty"` // see `ShutdownEscalationPolicy`
+}
+
+// ProcessStatus returns the launches and the last exit of the node process, both known only
+// when the superviser implements `nodeManager.ProcessStatsChainSuperviser` and
+// `nodeManager.ExitStatusChainSuperviser`, and the last shutdown escalation
+func (o *Operator) ProcessStatus() ProcessStatus {
+	status := ProcessStatus{IsRunning: o.Superviser.IsRunning()}
+	if statsSuperviser, ok := o.Superviser.(nodeManager.ProcessStatsChainSuperviser); ok {
+		status.ProcessStats = statsSuperviser.ProcessStats()
+	}
+	if exitStatus := o.LastExitStatus(); !exitStatus.Time.IsZero() {
+		status.LastExit = &exitStatus
+	}
+	status.LastShutdownEscalation = o.LastShutdownEscalation()
+	return status
+}
+
+func (o *Operator) processHandler(w http.ResponseWriter, _ *http.Request) {
+	out, err := json.Marshal(o.ProcessStatus())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
+
+// waitUntilNodeReady waits, up to the readiness timeout, for the node to be ready when the
+// superviser implements `nodeManager.ReadinessChainSuperviser`
+func (o *Operator) waitUntilNodeReady() error {
+	readinessSuperviser, ok := o.Superviser.(nodeManager.ReadinessChainSuperviser)
+	if !ok {
+		return nil
+	}
+
+	timeout := o.options.ReadinessTimeout
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	o.zlogger.Info("waiting for node to be ready before leaving maintenance")
+	if err := readinessSuperviser.WaitUntilReady(ctx); err != nil {
+		return fmt.Errorf("node started but staying in maintenance: %w", err)
+	}
+	return nil
+}
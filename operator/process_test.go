@@ -0,0 +1,73 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type readinessFakeSuperviser struct {
+	*fakeSuperviser
+	ready   chan struct{}
+	started time.Time
+}
+
+func (s *readinessFakeSuperviser) WaitUntilReady(ctx context.Context) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-ctx.Done():
+		return errors.New("no log line matching \"ready\"")
+	}
+}
+
+func (s *readinessFakeSuperviser) ProcessStats() nodeManager.ProcessStats {
+	return nodeManager.ProcessStats{StartCount: 3, LastStartTime: s.started}
+}
+
+func TestOperator_ResumeWaitsUntilReady(t *testing.T) {
+	log := &eventLog{}
+	node := &readinessFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", log), ready: make(chan struct{})}
+	o, err := New(zap.NewNop(), node, nil, &Options{ReadinessTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	err = o.runCommand(&Command{cmd: "resume", logger: o.zlogger})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "staying in maintenance")
+	assert.Equal(t, []string{"start node"}, log.reset())
+	assert.Empty(t, o.MaintenanceHistory(), "still in maintenance")
+
+	require.NoError(t, node.Stop())
+	log.reset()
+	close(node.ready)
+	require.NoError(t, o.runCommand(&Command{cmd: "resume", logger: o.zlogger}))
+	history := o.MaintenanceHistory()
+	require.Len(t, history, 1)
+	assert.False(t, history[0].InMaintenance)
+}
+
+func TestOperator_ProcessHandler(t *testing.T) {
+	node := &readinessFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", &eventLog{}), started: time.Date(2021, 7, 28, 10, 50, 16, 0, time.UTC)}
+	o, err := New(zap.NewNop(), node, nil, &Options{})
+	require.NoError(t, err)
+	require.NoError(t, node.Start())
+
+	recorder := httptest.NewRecorder()
+	o.processHandler(recorder, httptest.NewRequest("GET", "/v1/process", nil))
+
+	var status map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, map[string]interface{}{
+		"is_running":      true,
+		"start_count":     float64(3),
+		"last_start_time": "2021-07-28T10:50:16Z",
+	}, status)
+}
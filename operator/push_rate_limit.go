@@ -0,0 +1,84 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+// RegisterPushRateLimitSetter makes the `set_push_rate_limit` command (and its
+// `/v1/push_rate_limit` HTTP endpoint) adjust the limits of `setter` at runtime.
+func (o *Operator) RegisterPushRateLimitSetter(setter nodeManager.PushRateLimitSetter) {
+	o.pushRateLimitSetter = setter
+}
+
+// SetPushRateLimit changes the push rate limits through the operator command loop,
+// a limit of 0 means unlimited.
+func (o *Operator) SetPushRateLimit(blocksPerSecond, bytesPerSecond float64) error {
+	return o.sendCommand(&Command{cmd: "set_push_rate_limit", logger: o.zlogger, params: map[string]string{
+		"blocks_per_second": strconv.FormatFloat(blocksPerSecond, 'f', -1, 64),
+		"bytes_per_second":  strconv.FormatFloat(bytesPerSecond, 'f', -1, 64),
+	}})
+}
+
+func (o *Operator) handleSetPushRateLimit(cmd *Command) error {
+	if o.pushRateLimitSetter == nil {
+		cmd.Return(fmt.Errorf("no push rate limiter registered"))
+		return nil
+	}
+
+	blocksPerSecond, err := parseRateParam(cmd.params, "blocks_per_second")
+	if err != nil {
+		cmd.Return(err)
+		return nil
+	}
+
+	bytesPerSecond, err := parseRateParam(cmd.params, "bytes_per_second")
+	if err != nil {
+		cmd.Return(err)
+		return nil
+	}
+
+	if err := o.pushRateLimitSetter.SetPushRateLimit(blocksPerSecond, bytesPerSecond); err != nil {
+		cmd.Return(fmt.Errorf("setting push rate limit: %w", err))
+		return nil
+	}
+
+	o.zlogger.Info("push rate limit changed", zap.Float64("blocks_per_second", blocksPerSecond), zap.Float64("bytes_per_second", bytesPerSecond))
+	return nil
+}
+
+func (o *Operator) pushRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	params := getRequestParams(r, "blocks_per_second", "bytes_per_second")
+	o.triggerWebCommand("set_push_rate_limit", params, w, r)
+}
+
+func parseRateParam(params map[string]string, name string) (float64, error) {
+	value, found := params[name]
+	if !found {
+		return 0, nil
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return rate, nil
+}
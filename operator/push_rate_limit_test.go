@@ -0,0 +1,54 @@
+package operator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakePushRateLimitSetter struct {
+	blocksPerSecond float64
+	bytesPerSecond  float64
+}
+
+func (s *fakePushRateLimitSetter) SetPushRateLimit(blocksPerSecond, bytesPerSecond float64) error {
+	if blocksPerSecond < 0 || bytesPerSecond < 0 {
+		return fmt.Errorf("negative")
+	}
+
+	s.blocksPerSecond = blocksPerSecond
+	s.bytesPerSecond = bytesPerSecond
+	return nil
+}
+
+func TestOperator_SetPushRateLimit(t *testing.T) {
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", &eventLog{}), nil, &Options{})
+	require.NoError(t, err)
+
+	runSetPushRateLimit := func(params map[string]string) error {
+		cmd := &Command{cmd: "set_push_rate_limit", logger: o.zlogger, params: params, returnch: make(chan error, 1)}
+		require.NoError(t, o.runCommand(cmd))
+		cmd.Return(nil)
+		return <-cmd.returnch
+	}
+
+	assert.EqualError(t, runSetPushRateLimit(nil), "no push rate limiter registered")
+
+	setter := &fakePushRateLimitSetter{}
+	o.RegisterPushRateLimitSetter(setter)
+
+	require.NoError(t, runSetPushRateLimit(map[string]string{"blocks_per_second": "250", "bytes_per_second": "1e6"}))
+	assert.Equal(t, float64(250), setter.blocksPerSecond)
+	assert.Equal(t, float64(1000000), setter.bytesPerSecond)
+
+	require.NoError(t, runSetPushRateLimit(map[string]string{"blocks_per_second": "10"}))
+	assert.Equal(t, float64(10), setter.blocksPerSecond)
+	assert.Equal(t, float64(0), setter.bytesPerSecond, "missing limit is unlimited")
+
+	assert.Error(t, runSetPushRateLimit(map[string]string{"blocks_per_second": "abc"}))
+	assert.Error(t, runSetPushRateLimit(map[string]string{"blocks_per_second": "-1"}))
+	assert.Equal(t, float64(10), setter.blocksPerSecond)
+}
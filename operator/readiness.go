@@ -0,0 +1,247 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/derr"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReadinessEvaluationInterval     = 5 * time.Second
+	defaultReadinessConsecutiveEvaluations = 3
+)
+
+// Conditions of the readiness, see `ReadinessReport`
+const (
+	ReadinessConditionNodeRunning      = "node_running"
+	ReadinessConditionNotInMaintenance = "not_in_maintenance"
+	ReadinessConditionHeadBlockDrift   = "head_block_drift"
+)
+
+// ReadinessCheckOptions configures the readiness reported on `/healthz`, see
+// `Options.ReadinessCheck`. The node is ready when its process, and sidecars, are running, the
+// operator is not in maintenance and the drift between now and the time of the head block, fed
+// through `Operator.UpdateHeadBlock`, is below `MaxHeadBlockDrift`.
+type ReadinessCheckOptions struct {
+	// MaxHeadBlockDrift is the drift of the head block time above which the node is not ready
+	MaxHeadBlockDrift time.Duration
+
+	// EvaluationInterval between two evaluations of the head block drift, defaults to 5 seconds
+	EvaluationInterval time.Duration
+
+	// ConsecutiveEvaluations on the other side of `MaxHeadBlockDrift` needed for the head block
+	// drift condition to change, so readiness does not flap when the drift oscillates around
+	// it, defaults to 3. The node process and maintenance conditions apply right away.
+	ConsecutiveEvaluations int
+}
+
+func (o *ReadinessCheckOptions) validate() error {
+	if o.MaxHeadBlockDrift <= 0 {
+		return fmt.Errorf("invalid max head block drift %s, must be positive", o.MaxHeadBlockDrift)
+	}
+	if o.EvaluationInterval < 0 {
+		return fmt.Errorf("invalid evaluation interval %s, cannot be negative", o.EvaluationInterval)
+	}
+	if o.ConsecutiveEvaluations < 0 {
+		return fmt.Errorf("invalid consecutive evaluations %d, cannot be negative", o.ConsecutiveEvaluations)
+	}
+	return nil
+}
+
+// ReadinessCondition is one of the conditions of the readiness, with the reason it failed
+type ReadinessCondition struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReadinessReport is the readiness of the node, ready when all of its conditions are
+type ReadinessReport struct {
+	Ready      bool                 `json:"ready"`
+	Conditions []ReadinessCondition `json:"conditions"`
+
+	HeadBlockNum   uint64 `json:"head_block_num,omitempty"`
+	HeadBlockTime  string `json:"head_block_time,omitempty"`
+	HeadBlockDrift string `json:"head_block_drift,omitempty"` // as of the last evaluation
+}
+
+// ReadinessChecker evaluates the head block drift condition of the readiness, with hysteresis:
+// it changes after `ConsecutiveEvaluations` evaluations in a row disagreeing with it. It starts
+// failing, until the head block drift was below the max for as many evaluations.
+type ReadinessChecker struct {
+	maxDrift    time.Duration
+	interval    time.Duration
+	consecutive int
+
+	lock          sync.Mutex
+	headBlockNum  uint64
+	headBlockTime time.Time
+	evaluated     bool
+	drift         time.Duration // of the last evaluation
+	driftOK       bool
+	streak        int // consecutive evaluations disagreeing with driftOK
+}
+
+func newReadinessChecker(options ReadinessCheckOptions) *ReadinessChecker {
+	c := &ReadinessChecker{
+		maxDrift:    options.MaxHeadBlockDrift,
+		interval:    options.EvaluationInterval,
+		consecutive: options.ConsecutiveEvaluations,
+	}
+	if c.interval == 0 {
+		c.interval = defaultReadinessEvaluationInterval
+	}
+	if c.consecutive == 0 {
+		c.consecutive = defaultReadinessConsecutiveEvaluations
+	}
+	return c
+}
+
+func (c *ReadinessChecker) updateHeadBlock(num uint64, t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.headBlockNum = num
+	c.headBlockTime = t
+}
+
+// evaluate computes the head block drift at `now`, returning whether the condition changed
+// and its state
+func (c *ReadinessChecker) evaluate(now time.Time) (changed, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.evaluated = true
+	current := false
+	if !c.headBlockTime.IsZero() {
+		c.drift = now.Sub(c.headBlockTime)
+		current = c.drift < c.maxDrift
+	}
+
+	if current == c.driftOK {
+		c.streak = 0
+		return false, c.driftOK
+	}
+
+	c.streak++
+	if c.streak < c.consecutive {
+		return false, c.driftOK
+	}
+
+	c.driftOK = current
+	c.streak = 0
+	return true, c.driftOK
+}
+
+func (c *ReadinessChecker) report(report *ReadinessReport) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	condition := ReadinessCondition{Name: ReadinessConditionHeadBlockDrift, OK: c.driftOK}
+	if !c.headBlockTime.IsZero() {
+		report.HeadBlockNum = c.headBlockNum
+		report.HeadBlockTime = c.headBlockTime.UTC().Format(time.RFC3339)
+		if c.evaluated {
+			report.HeadBlockDrift = c.drift.String()
+		}
+	}
+
+	if !c.driftOK {
+		switch {
+		case c.headBlockTime.IsZero():
+			condition.Reason = "no head block received"
+		case !c.evaluated:
+			condition.Reason = "head block drift not evaluated yet"
+		case c.drift >= c.maxDrift:
+			condition.Reason = fmt.Sprintf("head block drift %s is above max %s", c.drift, c.maxDrift)
+		default:
+			condition.Reason = fmt.Sprintf("head block drift %s below max %s for %d of %d evaluations", c.drift, c.maxDrift, c.streak, c.consecutive)
+		}
+	}
+	report.Conditions = append(report.Conditions, condition)
+}
+
+func (o *Operator) evaluateReadinessEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+			o.evaluateReadiness()
+		}
+	}
+}
+
+func (o *Operator) evaluateReadiness() {
+	changed, ok := o.readinessChecker.evaluate(o.now())
+	if !changed {
+		return
+	}
+
+	report := o.Readiness()
+	if ok {
+		o.zlogger.Info("head block drift back below max", zap.Uint64("head_block_num", report.HeadBlockNum), zap.String("head_block_drift", report.HeadBlockDrift))
+		return
+	}
+	o.zlogger.Warn("head block drift above max, node not ready", zap.Uint64("head_block_num", report.HeadBlockNum), zap.String("head_block_drift", report.HeadBlockDrift))
+}
+
+// Readiness returns the readiness of the node computed from the conditions of
+// `Options.ReadinessCheck`, it is never ready without them
+func (o *Operator) Readiness() ReadinessReport {
+	var report ReadinessReport
+	if o.readinessChecker == nil {
+		return report
+	}
+
+	node := ReadinessCondition{Name: ReadinessConditionNodeRunning, OK: true}
+	sidecar := o.notRunningSidecar()
+	switch {
+	case !o.Superviser.IsRunning():
+		node.OK, node.Reason = false, "chain is not running"
+	case sidecar != nil:
+		node.OK, node.Reason = false, fmt.Sprintf("sidecar %q is not running", sidecar.Name)
+	case o.aboutToStop.Load() || derr.IsShuttingDown():
+		node.OK, node.Reason = false, "chain about to stop"
+	}
+	report.Conditions = append(report.Conditions, node)
+
+	maintenance := ReadinessCondition{Name: ReadinessConditionNotInMaintenance, OK: true}
+	if last, found := o.lastMaintenanceTransition(); found && last.InMaintenance {
+		maintenance.OK = false
+		maintenance.Reason = fmt.Sprintf("in maintenance since %s (source %s): %s", last.Time.UTC().Format(time.RFC3339), last.Source, last.Reason)
+	}
+	report.Conditions = append(report.Conditions, maintenance)
+
+	o.readinessChecker.report(&report)
+
+	report.Ready = true
+	for _, condition := range report.Conditions {
+		report.Ready = report.Ready && condition.OK
+	}
+	return report
+}
+
+func (o *Operator) readinessHandler(w http.ResponseWriter, _ *http.Request) {
+	report := o.Readiness()
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,139 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReadinessTestOperator(t *testing.T, now *time.Time) *Operator {
+	t.Helper()
+
+	superviser := newFakeSuperviser("node", &eventLog{})
+	superviser.running = true
+
+	o := newTestOperator(t, superviser, &Options{ReadinessCheck: &ReadinessCheckOptions{MaxHeadBlockDrift: time.Minute, ConsecutiveEvaluations: 3}})
+	o.now = func() time.Time { return *now }
+	return o
+}
+
+func getHealthz(t *testing.T, o *Operator) (int, ReadinessReport) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	o.healthzHandler(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var report ReadinessReport
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	return recorder.Code, report
+}
+
+func readinessCondition(t *testing.T, report ReadinessReport, name string) ReadinessCondition {
+	t.Helper()
+
+	for _, condition := range report.Conditions {
+		if condition.Name == name {
+			return condition
+		}
+	}
+	t.Fatalf("condition %q not in report", name)
+	return ReadinessCondition{}
+}
+
+func TestOperator_ReadinessHeadBlockDriftHysteresis(t *testing.T) {
+	headBlockTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := headBlockTime
+	o := newReadinessTestOperator(t, &now)
+
+	code, report := getHealthz(t, o)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, report.Ready)
+	assert.Equal(t, ReadinessCondition{Name: ReadinessConditionHeadBlockDrift, Reason: "no head block received"}, readinessCondition(t, report, ReadinessConditionHeadBlockDrift))
+
+	o.UpdateHeadBlock(100, "00000100a", headBlockTime, 99)
+
+	evaluateAt := func(drift time.Duration) bool {
+		now = headBlockTime.Add(drift)
+		o.evaluateReadiness()
+		return o.Readiness().Ready
+	}
+
+	assert.False(t, evaluateAt(10*time.Second))
+	assert.False(t, evaluateAt(10*time.Second))
+	assert.True(t, evaluateAt(10*time.Second), "ready after 3 good evaluations")
+
+	// Drift oscillating around the max does not flap
+	assert.True(t, evaluateAt(2*time.Minute))
+	assert.True(t, evaluateAt(30*time.Second))
+	assert.True(t, evaluateAt(2*time.Minute))
+	assert.True(t, evaluateAt(2*time.Minute))
+	assert.True(t, evaluateAt(30*time.Second))
+
+	assert.True(t, evaluateAt(2*time.Minute))
+	assert.True(t, evaluateAt(2*time.Minute))
+	assert.False(t, evaluateAt(2*time.Minute), "not ready after 3 bad evaluations")
+
+	code, report = getHealthz(t, o)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, ReadinessReport{
+		Conditions: []ReadinessCondition{
+			{Name: ReadinessConditionNodeRunning, OK: true},
+			{Name: ReadinessConditionNotInMaintenance, OK: true},
+			{Name: ReadinessConditionHeadBlockDrift, Reason: "head block drift 2m0s is above max 1m0s"},
+		},
+		HeadBlockNum:   100,
+		HeadBlockTime:  "2021-01-01T00:00:00Z",
+		HeadBlockDrift: "2m0s",
+	}, report)
+
+	// A new head block brings the drift back below the max
+	o.UpdateHeadBlock(200, "00000200a", headBlockTime.Add(2*time.Minute), 199)
+	headBlockTime = headBlockTime.Add(2 * time.Minute)
+	assert.False(t, evaluateAt(time.Second))
+
+	code, report = getHealthz(t, o)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "head block drift 1s below max 1m0s for 1 of 3 evaluations", readinessCondition(t, report, ReadinessConditionHeadBlockDrift).Reason)
+
+	assert.False(t, evaluateAt(time.Second))
+	assert.True(t, evaluateAt(time.Second))
+
+	code, report = getHealthz(t, o)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, report.Ready)
+	assert.Equal(t, uint64(200), report.HeadBlockNum)
+}
+
+func TestOperator_ReadinessNodeAndMaintenance(t *testing.T) {
+	headBlockTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := headBlockTime.Add(time.Second)
+	o := newReadinessTestOperator(t, &now)
+
+	o.UpdateHeadBlock(100, "00000100a", headBlockTime, 99)
+	for i := 0; i < 3; i++ {
+		o.evaluateReadiness()
+	}
+	code, _ := getHealthz(t, o)
+	require.Equal(t, http.StatusOK, code)
+
+	o.maintenanceHistory = []MaintenanceTransition{{Time: headBlockTime, InMaintenance: true, Reason: "disk full", Source: "disk_monitor"}}
+	code, report := getHealthz(t, o)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, ReadinessCondition{
+		Name:   ReadinessConditionNotInMaintenance,
+		Reason: "in maintenance since 2021-01-01T00:00:00Z (source disk_monitor): disk full",
+	}, readinessCondition(t, report, ReadinessConditionNotInMaintenance))
+
+	o.maintenanceHistory = nil
+	o.Superviser.(*fakeSuperviser).running = false
+	code, report = getHealthz(t, o)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, ReadinessCondition{Name: ReadinessConditionNodeRunning, Reason: "chain is not running"}, readinessCondition(t, report, ReadinessConditionNodeRunning))
+	assert.True(t, readinessCondition(t, report, ReadinessConditionHeadBlockDrift).OK)
+}
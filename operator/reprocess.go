@@ -0,0 +1,288 @@
+package operator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"go.uber.org/zap"
+)
+
+// ReprocessArgsProvider returns the chain specific arguments added to the node process to replay
+// blocks `startBlock` to `stopBlock`
+type ReprocessArgsProvider func(startBlock, stopBlock uint64) []string
+
+// ReprocessPluginFactory returns a fresh mindreader plugin reading blocks `startBlock` to
+// `stopBlock`, in place of the registered one while reprocessing. It must call `stopBlockReached`
+// once the stop block is reached, typically through `mindreader.WithStopBlockReachFunc`.
+type ReprocessPluginFactory func(startBlock, stopBlock uint64, stopBlockReached func()) (logplugin.LogPlugin, error)
+
+type reprocessing struct {
+	mindreader    logplugin.LogPlugin
+	argsProvider  ReprocessArgsProvider
+	pluginFactory ReprocessPluginFactory
+
+	lock   sync.Mutex
+	window *reprocessWindow // nil when not reprocessing
+	nextID int
+}
+
+type reprocessWindow struct {
+	id         int
+	startBlock uint64
+	stopBlock  uint64
+	plugin     logplugin.LogPlugin
+}
+
+// RegisterReprocessing makes the `reprocess` command (and its `/v1/reprocess` HTTP endpoint)
+// replay a window of blocks: the node is restarted with the arguments of `argsProvider` and
+// its log lines are read by a plugin of `pluginFactory` in place of `mindreader`, the plugin
+// registered on the superviser. The superviser must implement
+// `nodeManager.LogPluginReplacingChainSuperviser` and `nodeManager.StartArgsChainSuperviser`.
+func (o *Operator) RegisterReprocessing(mindreader logplugin.LogPlugin, argsProvider ReprocessArgsProvider, pluginFactory ReprocessPluginFactory) {
+	o.reprocessing = &reprocessing{
+		mindreader:    mindreader,
+		argsProvider:  argsProvider,
+		pluginFactory: pluginFactory,
+	}
+}
+
+// Reprocess replays blocks `startBlock` to `stopBlock`, returning once the node restarted to
+// do so. The node stays in maintenance while reprocessing and resumes normal operation once the
+// stop block is reached, it stays in maintenance when the reprocessing plugin fails. It returns
+// `ErrReprocessInProgress` while another reprocessing is pending or running.
+func (o *Operator) Reprocess(startBlock, stopBlock uint64) error {
+	c := &Command{cmd: "reprocess", logger: o.zlogger, initiator: CommandInitiatorAPI, params: reprocessParams(startBlock, stopBlock)}
+	c.returnch = make(chan error)
+	if err := o.queueReprocess(c); err != nil {
+		return err
+	}
+
+	o.sendQueuedCommand(c)
+	return <-c.returnch
+}
+
+func reprocessParams(startBlock, stopBlock uint64) map[string]string {
+	return map[string]string{
+		"start_block": strconv.FormatUint(startBlock, 10),
+		"stop_block":  strconv.FormatUint(stopBlock, 10),
+	}
+}
+
+// queueReprocess adds the `reprocess` command `c` to the pending commands, unless another
+// reprocessing is pending or running
+func (o *Operator) queueReprocess(c *Command) error {
+	if o.reprocessing == nil {
+		return fmt.Errorf("no reprocessing registered")
+	}
+
+	o.reprocessing.lock.Lock()
+	defer o.reprocessing.lock.Unlock()
+
+	if o.reprocessing.window != nil || o.hasQueuedCommandNamed("reprocess") {
+		return ErrReprocessInProgress
+	}
+
+	o.queueCommand(c)
+	return nil
+}
+
+func (o *Operator) activeReprocessWindow() *reprocessWindow {
+	o.reprocessing.lock.Lock()
+	defer o.reprocessing.lock.Unlock()
+
+	return o.reprocessing.window
+}
+
+func (o *Operator) setReprocessWindow(window *reprocessWindow) {
+	o.reprocessing.lock.Lock()
+	defer o.reprocessing.lock.Unlock()
+
+	o.reprocessing.window = window
+}
+
+func (o *Operator) handleReprocess(cmd *Command) error {
+	if o.reprocessing == nil {
+		cmd.Return(fmt.Errorf("no reprocessing registered"))
+		return nil
+	}
+	if o.activeReprocessWindow() != nil {
+		cmd.Return(ErrReprocessInProgress)
+		return nil
+	}
+
+	startBlock, err := strconv.ParseUint(cmd.params["start_block"], 10, 64)
+	if err != nil {
+		cmd.Return(fmt.Errorf("invalid start_block %q: %w", cmd.params["start_block"], err))
+		return nil
+	}
+	stopBlock, err := strconv.ParseUint(cmd.params["stop_block"], 10, 64)
+	if err != nil {
+		cmd.Return(fmt.Errorf("invalid stop_block %q: %w", cmd.params["stop_block"], err))
+		return nil
+	}
+	if stopBlock < startBlock {
+		cmd.Return(fmt.Errorf("invalid reprocessing window, stop block %d is before start block %d", stopBlock, startBlock))
+		return nil
+	}
+
+	replacer, ok := o.Superviser.(nodeManager.LogPluginReplacingChainSuperviser)
+	if !ok {
+		cmd.Return(fmt.Errorf("superviser %q does not support replacing log plugins", o.Superviser.GetName()))
+		return nil
+	}
+	if _, err := o.startArgsSuperviser(); err != nil {
+		cmd.Return(err)
+		return nil
+	}
+
+	o.reprocessing.nextID++
+	window := &reprocessWindow{id: o.reprocessing.nextID, startBlock: startBlock, stopBlock: stopBlock}
+	window.plugin, err = o.reprocessing.pluginFactory(startBlock, stopBlock, func() {
+		o.enqueueCommand(&Command{cmd: "reprocess_done", logger: o.zlogger, params: map[string]string{"window": strconv.Itoa(window.id), "result": "completed"}})
+	})
+	if err != nil {
+		cmd.Return(fmt.Errorf("creating reprocessing plugin: %w", err))
+		return nil
+	}
+
+	if shut, ok := window.plugin.(logplugin.Shutter); ok {
+		shut.OnTerminating(func(err error) {
+			// Reaching the stop block is a clean stop, completion is signaled by `stopBlockReached`
+			if err == nil || errors.Is(err, nodeManager.ErrCleanStop) {
+				return
+			}
+			go o.enqueueCommand(&Command{cmd: "reprocess_done", logger: o.zlogger, params: map[string]string{"window": strconv.Itoa(window.id), "result": "failed", "error": err.Error()}})
+		})
+	}
+
+	o.zlogger.Info("stopping node to reprocess blocks", zap.Uint64("start_block", startBlock), zap.Uint64("stop_block", stopBlock))
+	if err := o.cleanSuperviserStop(); err != nil {
+		discardReprocessPlugin(window.plugin)
+		return err
+	}
+	o.recordMaintenanceTransition(true, map[string]string{
+		"reason": fmt.Sprintf("reprocessing blocks %d to %d", startBlock, stopBlock),
+		"source": nodeManager.MaintenanceSourceReprocess,
+	})
+
+	if !replacer.ReplaceLogPlugin(o.reprocessing.mindreader, window.plugin) {
+		discardReprocessPlugin(window.plugin)
+		cmd.Return(fmt.Errorf("mindreader plugin %q is not registered on the superviser, staying in maintenance", o.reprocessing.mindreader.Name()))
+		return nil
+	}
+
+	extraArgs := o.reprocessing.argsProvider(startBlock, stopBlock)
+	if err := o.SetNextStartArgs(func(args []string) []string {
+		return append(append([]string{}, args...), extraArgs...)
+	}); err != nil {
+		replacer.ReplaceLogPlugin(window.plugin, o.reprocessing.mindreader)
+		discardReprocessPlugin(window.plugin)
+		return err
+	}
+
+	o.setReprocessWindow(window)
+	o.zlogger.Info("restarting node to reprocess blocks", zap.Uint64("start_block", startBlock), zap.Uint64("stop_block", stopBlock), zap.Strings("extra_args", extraArgs))
+	return o.runSubCommand("start", cmd)
+}
+
+// discardReprocessPlugin stops the plugin of a reprocessing window that never started, shutting
+// it down without error so it does not report the window as failed
+func discardReprocessPlugin(plugin logplugin.LogPlugin) {
+	if shut, ok := plugin.(logplugin.Shutter); ok {
+		shut.Shutdown(nil)
+	}
+	plugin.Stop()
+}
+
+// handleReprocessDone stops the node once the reprocessing plugin reached its stop block or
+// failed, and restores the registered mindreader plugin. The node resumes normal operation after
+// a completed reprocessing, it stays in maintenance after a failed one.
+func (o *Operator) handleReprocessDone(cmd *Command) error {
+	window := o.activeReprocessWindow()
+	if window == nil || strconv.Itoa(window.id) != cmd.params["window"] {
+		o.zlogger.Info("ignoring end of reprocessing window no longer active", zap.String("window", cmd.params["window"]))
+		return nil
+	}
+
+	fields := []zap.Field{zap.Uint64("start_block", window.startBlock), zap.Uint64("stop_block", window.stopBlock)}
+	o.zlogger.Info("reprocessing window ended, stopping node", append(fields, zap.String("result", cmd.params["result"]))...)
+	if err := o.cleanSuperviserStop(); err != nil {
+		return err
+	}
+
+	window.plugin.Stop()
+	o.Superviser.(nodeManager.LogPluginReplacingChainSuperviser).ReplaceLogPlugin(window.plugin, o.reprocessing.mindreader)
+	o.setReprocessWindow(nil)
+
+	if cmd.params["result"] != "completed" {
+		o.zlogger.Error("reprocessing failed, staying in maintenance", append(fields, zap.String("error", cmd.params["error"]))...)
+		return nil
+	}
+
+	o.zlogger.Info("reprocessing completed, resuming normal operation", fields...)
+	return o.runCommand(&Command{cmd: "resume", returnch: cmd.returnch, logger: o.zlogger, params: map[string]string{
+		"reason": fmt.Sprintf("reprocessed blocks %d to %d", window.startBlock, window.stopBlock),
+		"source": nodeManager.MaintenanceSourceReprocess,
+	}})
+}
+
+type reprocessRequest struct {
+	StartBlock *uint64 `json:"start_block"`
+	StopBlock  *uint64 `json:"stop_block"`
+}
+
+func (o *Operator) reprocessHandler(w http.ResponseWriter, r *http.Request) {
+	var request reprocessRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if request.StartBlock == nil || request.StopBlock == nil {
+		http.Error(w, "both start_block and stop_block are required", http.StatusBadRequest)
+		return
+	}
+	if *request.StopBlock < *request.StartBlock {
+		http.Error(w, fmt.Sprintf("stop_block %d is before start_block %d", *request.StopBlock, *request.StartBlock), http.StatusBadRequest)
+		return
+	}
+
+	c := &Command{cmd: "reprocess", logger: o.zlogger, initiator: CommandInitiatorHTTP, params: reprocessParams(*request.StartBlock, *request.StopBlock)}
+	wait := r.FormValue("sync") == "true"
+	if wait {
+		c.returnch = make(chan error)
+	}
+
+	if err := o.queueReprocess(c); err != nil {
+		status := http.StatusNotImplemented
+		if err == ErrReprocessInProgress {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	o.zlogger.Info("sending reprocess command to operator through channel", zap.Object("command", c), zap.Bool("sync", wait))
+	o.sendQueuedCommand(c)
+	if !wait {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(fmt.Sprintf("%s command submitted\n", c.cmd)))
+		return
+	}
+
+	if err := <-c.returnch; err != nil {
+		status := http.StatusInternalServerError
+		if err == ErrReprocessInProgress {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(fmt.Sprintf("ERROR: %s failed: %s \n", c.cmd, err)))
+		return
+	}
+	_, _ = w.Write([]byte(fmt.Sprintf("Success: %s completed\n", c.cmd)))
+}
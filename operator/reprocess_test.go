@@ -0,0 +1,195 @@
+package operator
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// reprocessFakeSuperviser records the arguments of each launch and swaps its log plugins
+type reprocessFakeSuperviser struct {
+	*fakeSuperviser
+	plugins  []logplugin.LogPlugin
+	mutators []nodeManager.StartArgsMutator
+}
+
+func (s *reprocessFakeSuperviser) Start(_ ...nodeManager.StartOption) error {
+	var args []string
+	for _, mutator := range s.mutators {
+		args = mutator(args)
+	}
+	s.mutators = nil
+
+	s.log.add(strings.TrimSpace("start node " + strings.Join(args, " ")))
+	s.running = true
+	s.stopped = make(chan struct{})
+	return nil
+}
+
+func (s *reprocessFakeSuperviser) ReplaceLogPlugin(old, replacement logplugin.LogPlugin) bool {
+	for i, plugin := range s.plugins {
+		if plugin == old {
+			s.plugins[i] = replacement
+			return true
+		}
+	}
+	return false
+}
+
+func (s *reprocessFakeSuperviser) SetNextStartArgs(mutator nodeManager.StartArgsMutator) {
+	s.mutators = append(s.mutators, mutator)
+}
+func (s *reprocessFakeSuperviser) SetPersistentStartArgs(_ string, _ nodeManager.StartArgsMutator) {}
+func (s *reprocessFakeSuperviser) RemovePersistentStartArgs(_ string) bool                         { return false }
+func (s *reprocessFakeSuperviser) StartArgs() nodeManager.StartArgsStatus {
+	return nodeManager.StartArgsStatus{}
+}
+
+type fakeReprocessPlugin struct {
+	*shutter.Shutter
+	name             string
+	stopBlockReached func()
+	stopped          bool
+}
+
+func (p *fakeReprocessPlugin) Name() string        { return p.name }
+func (p *fakeReprocessPlugin) Launch()             {}
+func (p *fakeReprocessPlugin) LogLine(line string) {}
+func (p *fakeReprocessPlugin) Stop()               { p.stopped = true }
+
+type fakeReprocessPluginFactory struct {
+	windows [][2]uint64
+	plugin  *fakeReprocessPlugin
+}
+
+func (f *fakeReprocessPluginFactory) create(startBlock, stopBlock uint64, stopBlockReached func()) (logplugin.LogPlugin, error) {
+	f.windows = append(f.windows, [2]uint64{startBlock, stopBlock})
+	f.plugin = &fakeReprocessPlugin{Shutter: shutter.New(), name: "reprocess", stopBlockReached: stopBlockReached}
+	return f.plugin, nil
+}
+
+func newReprocessTestOperator(t *testing.T) (*Operator, *eventLog, *reprocessFakeSuperviser, *fakeReprocessPluginFactory, logplugin.LogPlugin) {
+	t.Helper()
+
+	log := &eventLog{}
+	mindreader := &fakeReprocessPlugin{Shutter: shutter.New(), name: "mindreader"}
+	superviser := &reprocessFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", log), plugins: []logplugin.LogPlugin{mindreader}}
+	factory := &fakeReprocessPluginFactory{}
+
+	o := newTestOperator(t, superviser, &Options{})
+	o.RegisterReprocessing(mindreader, func(startBlock, stopBlock uint64) []string {
+		return []string{"--replay-from=" + strconv.FormatUint(startBlock, 10), "--replay-to=" + strconv.FormatUint(stopBlock, 10)}
+	}, factory.create)
+	startTestOperator(t, o, log)
+
+	return o, log, superviser, factory, mindreader
+}
+
+func TestOperator_Reprocess(t *testing.T) {
+	o, log, superviser, factory, mindreader := newReprocessTestOperator(t)
+
+	require.NoError(t, o.runCommand(&Command{cmd: "reprocess", logger: o.zlogger, params: reprocessParams(10, 20)}))
+	assert.Equal(t, []string{"stop node", "start node --replay-from=10 --replay-to=20"}, log.reset())
+	assert.Equal(t, [][2]uint64{{10, 20}}, factory.windows)
+	assert.Equal(t, []logplugin.LogPlugin{factory.plugin}, superviser.plugins)
+
+	active, reason, _ := o.MaintenanceStatus()
+	assert.True(t, active)
+	assert.Equal(t, "reprocessing blocks 10 to 20", reason)
+
+	factory.plugin.stopBlockReached()
+	require.NoError(t, o.executeCommand(<-o.commandChan))
+	assert.Equal(t, []string{"stop node", "start node"}, log.reset())
+	assert.True(t, factory.plugin.stopped)
+	assert.Equal(t, []logplugin.LogPlugin{mindreader}, superviser.plugins)
+	assert.False(t, o.inMaintenance())
+	assert.Nil(t, o.activeReprocessWindow())
+}
+
+func TestOperator_ReprocessPluginFailure(t *testing.T) {
+	o, log, superviser, factory, mindreader := newReprocessTestOperator(t)
+
+	require.NoError(t, o.runCommand(&Command{cmd: "reprocess", logger: o.zlogger, params: reprocessParams(10, 20)}))
+	log.reset()
+
+	factory.plugin.Shutdown(errors.New("boom"))
+	require.NoError(t, o.executeCommand(<-o.commandChan))
+	assert.Equal(t, []string{"stop node"}, log.reset())
+	assert.Equal(t, []logplugin.LogPlugin{mindreader}, superviser.plugins)
+	assert.True(t, o.inMaintenance())
+	assert.Nil(t, o.activeReprocessWindow())
+
+	// The stop block of a window no longer active is ignored
+	factory.plugin.stopBlockReached()
+	require.NoError(t, o.executeCommand(<-o.commandChan))
+	assert.Empty(t, log.reset())
+	assert.True(t, o.inMaintenance())
+}
+
+func TestOperator_ReprocessMindreaderNotRegistered(t *testing.T) {
+	o, log, superviser, factory, _ := newReprocessTestOperator(t)
+	superviser.plugins = nil
+
+	cmd := &Command{cmd: "reprocess", logger: o.zlogger, params: reprocessParams(10, 20)}
+	require.NoError(t, o.runCommand(cmd))
+	assert.Error(t, cmd.err)
+	assert.Equal(t, []string{"stop node"}, log.reset())
+	assert.True(t, o.inMaintenance())
+	assert.Nil(t, o.activeReprocessWindow())
+
+	assert.True(t, factory.plugin.stopped, "plugin of the window not started is stopped")
+	assert.True(t, factory.plugin.IsTerminated())
+	select {
+	case cmd := <-o.commandChan:
+		t.Fatalf("unexpected command %q", cmd.cmd)
+	default:
+	}
+}
+
+func TestOperator_ReprocessRequiresSuperviserSupport(t *testing.T) {
+	log := &eventLog{}
+	mindreader := &fakeReprocessPlugin{Shutter: shutter.New(), name: "mindreader"}
+	factory := &fakeReprocessPluginFactory{}
+
+	o, err := New(zap.NewNop(), newFakeSuperviser("node", log), nil, &Options{})
+	require.NoError(t, err)
+	o.RegisterReprocessing(mindreader, func(_, _ uint64) []string { return nil }, factory.create)
+
+	cmd := &Command{cmd: "reprocess", logger: o.zlogger, params: reprocessParams(10, 20)}
+	require.NoError(t, o.runCommand(cmd))
+	assert.Error(t, cmd.err)
+	assert.Empty(t, log.reset())
+	assert.Empty(t, factory.windows)
+}
+
+func TestOperator_ReprocessHandler(t *testing.T) {
+	o, _, _, _, _ := newReprocessTestOperator(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		o.reprocessHandler(w, httptest.NewRequest("POST", "/v1/reprocess", strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"start_block":10`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"start_block":10}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"start_block":20,"stop_block":10}`).Code)
+
+	assert.Equal(t, http.StatusCreated, post(`{"start_block":10,"stop_block":20}`).Code)
+	// Pending
+	assert.Equal(t, http.StatusConflict, post(`{"start_block":30,"stop_block":40}`).Code)
+
+	require.NoError(t, o.executeCommand(<-o.commandChan))
+	// Running
+	assert.Equal(t, http.StatusConflict, post(`{"start_block":30,"stop_block":40}`).Code)
+}
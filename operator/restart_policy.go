@@ -0,0 +1,135 @@
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// RestartPolicy governs whether the operator relaunches the node when it stops on its own, one
+// of `RestartAlways`, `RestartOnFailure` or `RestartNever`. A node failing
+// `Options.CrashLoopMaxFailures` times within `Options.CrashLoopWindow` is put in maintenance
+// instead of being restarted again, it is restarted by resuming from the maintenance.
+type RestartPolicy interface {
+	// shouldRestart returns if the node should be relaunched, and after which delay, given how it
+	// exited and the number of restarts since it last ran for longer than the crash loop window
+	shouldRestart(status nodeManager.ExitStatus, retries int) (bool, time.Duration)
+}
+
+// RestartAlways relaunches the node after `Backoff` whenever it stops, even cleanly
+type RestartAlways struct {
+	Backoff time.Duration
+}
+
+func (p RestartAlways) shouldRestart(_ nodeManager.ExitStatus, _ int) (bool, time.Duration) {
+	return true, p.Backoff
+}
+
+// RestartOnFailure relaunches the node after `Backoff` when it crashed or exited with an error,
+// at most `MaxRetries` times in a row (0 for no limit), a clean exit shuts the operator down
+type RestartOnFailure struct {
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+func (p RestartOnFailure) shouldRestart(status nodeManager.ExitStatus, retries int) (bool, time.Duration) {
+	if !status.Failed() {
+		return false, 0
+	}
+	if p.MaxRetries != 0 && retries >= p.MaxRetries {
+		return false, 0
+	}
+	return true, p.Backoff
+}
+
+// RestartNever shuts the operator down when the node stops, the behavior without a restart policy
+type RestartNever struct{}
+
+func (RestartNever) shouldRestart(_ nodeManager.ExitStatus, _ int) (bool, time.Duration) {
+	return false, 0
+}
+
+// LastExitStatus returns how the node last exited. Only the exit code is known when the
+// superviser does not implement `nodeManager.ExitStatusChainSuperviser`.
+func (o *Operator) LastExitStatus() nodeManager.ExitStatus {
+	if exitStatusSuperviser, ok := o.Superviser.(nodeManager.ExitStatusChainSuperviser); ok {
+		return exitStatusSuperviser.LastExitStatus()
+	}
+	return nodeManager.ExitStatus{Code: o.Superviser.LastExitCode()}
+}
+
+func (o *Operator) setupRestartPolicy() {
+	if o.options.CrashLoopMaxFailures == 0 {
+		o.options.CrashLoopMaxFailures = 5
+	}
+	if o.options.CrashLoopWindow == 0 {
+		o.options.CrashLoopWindow = 10 * time.Minute
+	}
+}
+
+// restartOnUnexpectedStop applies the restart policy once the node stopped on its own, putting
+// it in maintenance when crash looping. It returns false when the operator should shut down.
+func (o *Operator) restartOnUnexpectedStop() bool {
+	policy := o.options.RestartPolicy
+	if policy == nil {
+		return false
+	}
+
+	status := o.LastExitStatus()
+	now := time.Now()
+
+	if status.Failed() && o.recordFailure(now) {
+		reason := fmt.Sprintf("node crash looping, %d failures within %s (last %s)", len(o.recentFailures), o.options.CrashLoopWindow, status)
+		o.zlogger.Error("node is crash looping, putting it in maintenance instead of restarting it", zap.Stringer("exit_status", status), zap.Int("failures", len(o.recentFailures)), zap.Duration("window", o.options.CrashLoopWindow))
+		o.recentFailures = nil
+
+		if err := o.runCommand(&Command{cmd: "maintenance", logger: o.zlogger, params: map[string]string{"reason": reason, "source": nodeManager.MaintenanceSourceCrashLoop}}); err != nil {
+			o.zlogger.Error("unable to put crash looping node in maintenance", zap.Error(err))
+			return false
+		}
+		return true
+	}
+
+	if now.Sub(o.lastRestart) > o.options.CrashLoopWindow {
+		o.restartRetries = 0
+	}
+
+	restart, backoff := policy.shouldRestart(status, o.restartRetries)
+	if !restart {
+		o.zlogger.Info("restart policy does not relaunch the node", zap.Stringer("exit_status", status), zap.Int("retries", o.restartRetries))
+		return false
+	}
+
+	o.restartRetries++
+	metrics.NodeRestarts.Inc(status.Class())
+	o.zlogger.Warn("node stopped on its own, relaunching it", zap.Stringer("exit_status", status), zap.Duration("backoff", backoff), zap.Int("retry", o.restartRetries))
+
+	select {
+	case <-time.After(backoff):
+	case <-o.Terminating():
+		return false
+	}
+
+	o.lastRestart = time.Now()
+	if err := o.runCommand(&Command{cmd: "start", logger: o.zlogger}); err != nil {
+		o.zlogger.Error("unable to relaunch node", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// recordFailure keeps the failures within the crash loop window, reporting if they exceed the maximum
+func (o *Operator) recordFailure(now time.Time) bool {
+	recent := o.recentFailures[:0]
+	for _, failure := range o.recentFailures {
+		if now.Sub(failure) <= o.options.CrashLoopWindow {
+			recent = append(recent, failure)
+		}
+	}
+	o.recentFailures = append(recent, now)
+
+	return len(o.recentFailures) >= o.options.CrashLoopMaxFailures
+}
@@ -0,0 +1,146 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type exitStatusFakeSuperviser struct {
+	*fakeSuperviser
+	status nodeManager.ExitStatus
+}
+
+func (s *exitStatusFakeSuperviser) LastExitStatus() nodeManager.ExitStatus { return s.status }
+
+func TestRestartPolicy_ShouldRestart(t *testing.T) {
+	clean := nodeManager.ExitStatus{Code: 0}
+	failure := nodeManager.ExitStatus{Code: 1}
+	killed := nodeManager.ExitStatus{Code: -1, Signal: "killed"}
+
+	tests := []struct {
+		name            string
+		policy          RestartPolicy
+		status          nodeManager.ExitStatus
+		retries         int
+		expectedRestart bool
+	}{
+		{"always clean", RestartAlways{}, clean, 10, true},
+		{"always failure", RestartAlways{}, failure, 0, true},
+		{"on failure clean", RestartOnFailure{}, clean, 0, false},
+		{"on failure failure", RestartOnFailure{}, failure, 0, true},
+		{"on failure killed", RestartOnFailure{}, killed, 0, true},
+		{"on failure unlimited retries", RestartOnFailure{}, failure, 100, true},
+		{"on failure below max retries", RestartOnFailure{MaxRetries: 2}, failure, 1, true},
+		{"on failure max retries reached", RestartOnFailure{MaxRetries: 2}, failure, 2, false},
+		{"never", RestartNever{}, failure, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restart, _ := test.policy.shouldRestart(test.status, test.retries)
+			assert.Equal(t, test.expectedRestart, restart)
+		})
+	}
+}
+
+func TestExitStatus_Class(t *testing.T) {
+	assert.Equal(t, nodeManager.ExitClassRequested, nodeManager.ExitStatus{Code: -1, Signal: "terminated", Requested: true}.Class())
+	assert.Equal(t, nodeManager.ExitClassClean, nodeManager.ExitStatus{Code: 0}.Class())
+	assert.Equal(t, nodeManager.ExitClassKilled, nodeManager.ExitStatus{Code: -1, Signal: "killed"}.Class())
+	assert.Equal(t, nodeManager.ExitClassKilled, nodeManager.ExitStatus{Code: 137}.Class())
+	assert.Equal(t, nodeManager.ExitClassSignaled, nodeManager.ExitStatus{Code: -1, Signal: "segmentation fault"}.Class())
+	assert.Equal(t, nodeManager.ExitClassSignaled, nodeManager.ExitStatus{Code: 139}.Class())
+	assert.Equal(t, nodeManager.ExitClassFailure, nodeManager.ExitStatus{Code: 2}.Class())
+}
+
+func newRestartPolicyTestOperator(t *testing.T, options *Options) (*Operator, *exitStatusFakeSuperviser, *eventLog) {
+	t.Helper()
+
+	log := &eventLog{}
+	node := &exitStatusFakeSuperviser{fakeSuperviser: newFakeSuperviser("node", log)}
+
+	o := newTestOperator(t, node, options)
+	startTestOperator(t, o, log)
+	return o, node, log
+}
+
+func TestOperator_RestartOnUnexpectedStop(t *testing.T) {
+	o, node, log := newRestartPolicyTestOperator(t, &Options{RestartPolicy: RestartOnFailure{MaxRetries: 2}})
+
+	node.status = nodeManager.ExitStatus{Code: -1, Signal: "killed"}
+	for i := 0; i < 2; i++ {
+		node.crash()
+		require.True(t, o.restartOnUnexpectedStop())
+		assert.Equal(t, []string{"start node"}, log.reset())
+		assert.Equal(t, nodeManager.ExitClassKilled, o.LastExitStatus().Class())
+	}
+
+	node.crash()
+	assert.False(t, o.restartOnUnexpectedStop(), "max retries reached")
+	assert.Empty(t, log.reset())
+}
+
+func TestOperator_RestartOnUnexpectedStop_CleanExit(t *testing.T) {
+	o, node, log := newRestartPolicyTestOperator(t, &Options{RestartPolicy: RestartOnFailure{}})
+
+	node.crash()
+	assert.False(t, o.restartOnUnexpectedStop())
+	assert.Empty(t, log.reset())
+}
+
+func TestOperator_RestartOnUnexpectedStop_NoPolicy(t *testing.T) {
+	o, node, log := newRestartPolicyTestOperator(t, &Options{})
+
+	node.status = nodeManager.ExitStatus{Code: 1}
+	node.crash()
+	assert.False(t, o.restartOnUnexpectedStop())
+	assert.Empty(t, log.reset())
+}
+
+func TestOperator_RestartOnUnexpectedStop_CrashLoop(t *testing.T) {
+	o, node, log := newRestartPolicyTestOperator(t, &Options{
+		RestartPolicy:        RestartAlways{},
+		CrashLoopMaxFailures: 3,
+		CrashLoopWindow:      time.Minute,
+	})
+
+	node.status = nodeManager.ExitStatus{Code: 1}
+	for i := 0; i < 2; i++ {
+		node.crash()
+		require.True(t, o.restartOnUnexpectedStop())
+		assert.Equal(t, []string{"start node"}, log.reset())
+	}
+
+	node.crash()
+	require.True(t, o.restartOnUnexpectedStop())
+	assert.Empty(t, log.reset(), "node not restarted")
+	assert.False(t, node.IsRunning())
+
+	history := o.MaintenanceHistory()
+	require.Len(t, history, 1)
+	assert.True(t, history[0].InMaintenance)
+	assert.Equal(t, nodeManager.MaintenanceSourceCrashLoop, history[0].Source)
+
+	// Failures before the maintenance are forgotten once resumed
+	require.NoError(t, o.runCommand(&Command{cmd: "resume", logger: o.zlogger}))
+	log.reset()
+
+	node.crash()
+	require.True(t, o.restartOnUnexpectedStop())
+	assert.Equal(t, []string{"start node"}, log.reset())
+}
+
+func TestOperator_RecordFailure_Window(t *testing.T) {
+	o := &Operator{options: &Options{CrashLoopMaxFailures: 3, CrashLoopWindow: time.Minute}}
+
+	now := time.Now()
+	assert.False(t, o.recordFailure(now.Add(-3*time.Minute)))
+	assert.False(t, o.recordFailure(now.Add(-2*time.Minute)))
+	assert.False(t, o.recordFailure(now.Add(-30*time.Second)))
+	assert.False(t, o.recordFailure(now), "older failures are outside of the window")
+	assert.True(t, o.recordFailure(now.Add(time.Second)))
+}
@@ -0,0 +1,60 @@
+package operator
+
+import (
+	"fmt"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+// checkRestoreBlockHeight refuses to restore a backup older than the highest block written to the
+// continuity checker, the node would produce blocks overlapping the ones already archived. The
+// check is skipped when the backup or the continuity checker do not report their block number.
+// When `force` is set, restoring an older backup is only logged, the continuity checker being reset
+// after every restore.
+func (o *Operator) checkRestoreBlockHeight(mod RestorableBackupModule, backupName string, force bool) error {
+	state, ok := o.continuityCheckerResetter.(nodeManager.ContinuityCheckerState)
+	if !ok {
+		return nil
+	}
+
+	highestBlockNum := state.HighestContinuousBlockNum()
+	if highestBlockNum == 0 {
+		return nil
+	}
+
+	describable, ok := mod.(DescribableBackupModule)
+	if !ok {
+		o.zlogger.Info("backup module does not expose backup metadata, not checking backup block height", zap.String("backup_name", backupName))
+		return nil
+	}
+
+	info, err := describable.Info(backupName)
+	if err != nil {
+		if force {
+			o.zlogger.Warn("unable to get backup metadata, restoring anyway as forced", zap.String("backup_name", backupName), zap.Error(err))
+			return nil
+		}
+		return fmt.Errorf("getting info of backup %q to check its block height (set force=true to restore anyway): %w", backupName, err)
+	}
+
+	if info.BlockNum == 0 {
+		o.zlogger.Info("backup block height unknown, not checking it", zap.String("backup_name", backupName))
+		return nil
+	}
+
+	if info.BlockNum >= highestBlockNum {
+		return nil
+	}
+
+	if !force {
+		return fmt.Errorf("backup %q is at block %d, older than the highest block %d written to the continuity checker, restoring it would produce overlapping blocks (set force=true to restore anyway)", backupName, info.BlockNum, highestBlockNum)
+	}
+
+	o.zlogger.Warn("forcing restore of a backup older than the highest block written to the continuity checker, the continuity checker will be reset",
+		zap.String("backup_name", backupName),
+		zap.Uint64("backup_block_num", info.BlockNum),
+		zap.Uint64("highest_continuous_block_num", highestBlockNum),
+	)
+	return nil
+}
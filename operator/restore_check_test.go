@@ -0,0 +1,80 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeDescribableBackupModule struct {
+	fakeRestorableBackupModule
+	blockNum uint64
+}
+
+func (m *fakeDescribableBackupModule) Info(name string) (BackupInfo, error) {
+	return BackupInfo{Name: name, BlockNum: m.blockNum}, nil
+}
+
+type fakeContinuityCheckerState struct {
+	fakeContinuityCheckerResetter
+	highestBlockNum uint64
+}
+
+func (s *fakeContinuityCheckerState) HighestContinuousBlockNum() uint64 { return s.highestBlockNum }
+
+func TestOperator_RestoreBlockHeightCheck(t *testing.T) {
+	cases := []struct {
+		name           string
+		module         func(log *eventLog) BackupModule
+		force          bool
+		expectRestored bool
+	}{
+		{"newer backup", func(log *eventLog) BackupModule {
+			return &fakeDescribableBackupModule{fakeRestorableBackupModule{fakeBackupModule{log: log}}, 2000}
+		}, false, true},
+		{"older backup refused", func(log *eventLog) BackupModule {
+			return &fakeDescribableBackupModule{fakeRestorableBackupModule{fakeBackupModule{log: log}}, 500}
+		}, false, false},
+		{"older backup forced", func(log *eventLog) BackupModule {
+			return &fakeDescribableBackupModule{fakeRestorableBackupModule{fakeBackupModule{log: log}}, 500}
+		}, true, true},
+		{"unknown backup block", func(log *eventLog) BackupModule {
+			return &fakeDescribableBackupModule{fakeRestorableBackupModule{fakeBackupModule{log: log}}, 0}
+		}, false, true},
+		{"no metadata", func(log *eventLog) BackupModule {
+			return &fakeRestorableBackupModule{fakeBackupModule{log: log}}
+		}, false, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			log := &eventLog{}
+			o, err := New(zap.NewNop(), newFakeSuperviser("node", log), nil, &Options{})
+			require.NoError(t, err)
+			require.NoError(t, o.RegisterBackupModule("fake", c.module(log)))
+			o.RegisterContinuityCheckerResetter(&fakeContinuityCheckerState{fakeContinuityCheckerResetter{log: log}, 1000})
+
+			require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+			log.reset()
+
+			params := map[string]string{"backupName": "b1"}
+			if c.force {
+				params["force"] = "true"
+			}
+			cmd := &Command{cmd: "restore", logger: o.zlogger, params: params}
+			require.NoError(t, o.runCommand(cmd))
+
+			if c.expectRestored {
+				assert.NoError(t, cmd.err)
+				assert.Equal(t, []string{"stop node", "restore b1", "reset continuity", "start node"}, log.reset())
+				return
+			}
+
+			require.Error(t, cmd.err)
+			assert.Contains(t, cmd.err.Error(), "at block 500, older than the highest block 1000")
+			assert.Empty(t, log.reset(), "node is not stopped and nothing is restored")
+		})
+	}
+}
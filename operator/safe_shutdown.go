@@ -0,0 +1,207 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+type SafeShutdownPhase string
+
+const (
+	// PhaseStopAccepting reports the operator as not ready, load balancers stop sending it traffic
+	PhaseStopAccepting SafeShutdownPhase = "stop_accepting"
+	// PhaseStopSidecars shuts the sidecars down, they depend on the node
+	PhaseStopSidecars SafeShutdownPhase = "stop_sidecars"
+	// PhaseStopNode stops the node process
+	PhaseStopNode SafeShutdownPhase = "stop_node"
+	// PhaseDrainMindreader shuts the superviser down, waiting for its log plugins, like the
+	// mindreader, to process the node's last output lines
+	PhaseDrainMindreader SafeShutdownPhase = "drain_mindreader"
+	// PhaseFinalBackup takes a last backup of the stopped node, see `SafeShutdownOptions.FinalBackup`
+	PhaseFinalBackup SafeShutdownPhase = "final_backup"
+)
+
+// SafeShutdownOptions customizes the sequence run by `Operator.SafeShutdown`. The zero value runs
+// the sequence of a signal-driven shutdown, without timeouts and without a final backup.
+type SafeShutdownOptions struct {
+	// NodeStopTimeout, DrainTimeout and FinalBackupTimeout bound their phase, 0 for no timeout. A
+	// phase timing out is reported as failed and the sequence moves on to the next phase.
+	NodeStopTimeout    time.Duration
+	DrainTimeout       time.Duration
+	FinalBackupTimeout time.Duration
+
+	// FinalBackup takes a backup once the node stopped and the mindreader drained, with the
+	// `FinalBackupModule` backup module (the only registered one when empty)
+	FinalBackup       bool
+	FinalBackupModule string
+
+	// Hooks are called at their point of the sequence, an error is reported on the phase they
+	// precede (or follow for `AfterMindreaderDrain`) and does not interrupt the sequence
+	BeforeNodeStop       func(ctx context.Context) error
+	AfterMindreaderDrain func(ctx context.Context) error
+	BeforeFinalBackup    func(ctx context.Context) error
+}
+
+type SafeShutdownPhaseResult struct {
+	Phase     SafeShutdownPhase `json:"phase"`
+	Completed bool              `json:"completed"`
+	Skipped   bool              `json:"skipped,omitempty"`
+	Duration  time.Duration     `json:"duration"`
+	Err       error             `json:"-"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// SafeShutdownResult describes, in order, how each phase of the shutdown sequence went
+type SafeShutdownResult struct {
+	Phases []SafeShutdownPhaseResult `json:"phases"`
+}
+
+// Completed reports if `phase` ran to completion
+func (r *SafeShutdownResult) Completed(phase SafeShutdownPhase) bool {
+	for _, result := range r.Phases {
+		if result.Phase == phase {
+			return result.Completed
+		}
+	}
+	return false
+}
+
+// Err returns the error of the first failed phase, nil when every phase completed or was skipped
+func (r *SafeShutdownResult) Err() error {
+	for _, result := range r.Phases {
+		if result.Err != nil {
+			return fmt.Errorf("shutdown phase %s: %w", result.Phase, result.Err)
+		}
+	}
+	return nil
+}
+
+// SafeShutdown stops accepting traffic, shuts the sidecars down, stops the node, waits for the
+// mindreader to archive the node's last blocks, optionally takes a final backup, then shuts the
+// operator down. The mindreader reads the node's output, so it is drained once the node stopped.
+// Signal-driven shutdowns run the same sequence with `Options.SafeShutdown`. Concurrent calls, or
+// a call while the operator shuts down, wait for the sequence already running and return its result.
+func (o *Operator) SafeShutdown(ctx context.Context, opts SafeShutdownOptions) (*SafeShutdownResult, error) {
+	result := o.safeShutdown(ctx, opts, nil)
+	o.Shutdown(nil)
+
+	return result, result.Err()
+}
+
+// safeShutdown runs the sequence once, `err` being the reason of the shutdown given to the superviser
+func (o *Operator) safeShutdown(ctx context.Context, opts SafeShutdownOptions, err error) *SafeShutdownResult {
+	o.safeShutdownOnce.Do(func() {
+		o.safeShuttingDown.Store(true)
+		o.zlogger.Info("running safe shutdown sequence", zap.Error(err))
+
+		result := &SafeShutdownResult{}
+		run := func(phase SafeShutdownPhase, timeout time.Duration, f func(ctx context.Context) error) {
+			phaseResult := o.runShutdownPhase(ctx, phase, timeout, f)
+			result.Phases = append(result.Phases, phaseResult)
+		}
+
+		run(PhaseStopAccepting, 0, func(_ context.Context) error {
+			o.aboutToStop.Store(true)
+			return nil
+		})
+
+		run(PhaseStopSidecars, 0, func(_ context.Context) error {
+			o.shutdownSidecars(err)
+			return nil
+		})
+
+		run(PhaseStopNode, opts.NodeStopTimeout, func(ctx context.Context) error {
+			hookErr := runShutdownHook(ctx, "before node stop", opts.BeforeNodeStop)
+
+			if err := o.stopNode(); err != nil {
+				return fmt.Errorf("stopping node: %w", err)
+			}
+			metrics.SupervisedProcessRunning.SetUint64(0, o.Superviser.GetName())
+			return hookErr
+		})
+
+		run(PhaseDrainMindreader, opts.DrainTimeout, func(ctx context.Context) error {
+			// wait for superviser to terminate, superviser will wait for plugins to terminate
+			if !o.Superviser.IsTerminating() {
+				o.Superviser.Shutdown(err)
+			}
+
+			o.zlogger.Info("operator is waiting for superviser to shutdown", zap.Error(err))
+			<-o.Superviser.Terminated()
+			o.zlogger.Info("operator done waiting for superviser to shutdown", zap.Error(err))
+
+			return runShutdownHook(ctx, "after mindreader drain", opts.AfterMindreaderDrain)
+		})
+
+		if !opts.FinalBackup {
+			result.Phases = append(result.Phases, SafeShutdownPhaseResult{Phase: PhaseFinalBackup, Skipped: true})
+		} else {
+			run(PhaseFinalBackup, opts.FinalBackupTimeout, func(ctx context.Context) error {
+				hookErr := runShutdownHook(ctx, "before final backup", opts.BeforeFinalBackup)
+
+				backupModName, backupMod, err := selectBackupModule(o.backupModules, opts.FinalBackupModule)
+				if err != nil {
+					return err
+				}
+
+				backupName, err := o.runRecordedBackup(backupModName, backupMod, metrics.BackupScheduleManual, o.Superviser.LastSeenBlockNum())
+				if err != nil {
+					return fmt.Errorf("final backup: %w", err)
+				}
+				o.zlogger.Info("completed final backup", zap.String("backup_name", backupName))
+				return hookErr
+			})
+		}
+
+		o.safeShutdownResult = result
+		o.zlogger.Info("safe shutdown sequence done", zap.Error(result.Err()))
+	})
+
+	return o.safeShutdownResult
+}
+
+// runShutdownPhase runs `f`, giving up on it once `timeout` (when non-zero) elapsed or `ctx` is done
+func (o *Operator) runShutdownPhase(ctx context.Context, phase SafeShutdownPhase, timeout time.Duration, f func(ctx context.Context) error) SafeShutdownPhaseResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	o.zlogger.Info("running shutdown phase", zap.String("phase", string(phase)), zap.Duration("timeout", timeout))
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("phase not completed: %w", ctx.Err())
+	}
+
+	result := SafeShutdownPhaseResult{Phase: phase, Completed: err == nil, Duration: time.Since(start), Err: err}
+	if err != nil {
+		result.Error = err.Error()
+		o.zlogger.Error("shutdown phase failed, moving on to the next one", zap.String("phase", string(phase)), zap.Duration("duration", result.Duration), zap.Error(err))
+	}
+	return result
+}
+
+func runShutdownHook(ctx context.Context, name string, hook func(ctx context.Context) error) error {
+	if hook == nil {
+		return nil
+	}
+
+	if err := hook(ctx); err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSafeShutdownTestOperator(t *testing.T) (*Operator, *eventLog, *fakeSuperviser) {
+	t.Helper()
+
+	o, log, node, sidecar := newSidecarTestOperator(t, SidecarRestartNever)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeBackupModule{log: log}))
+	node.OnTerminating(func(_ error) { log.add("drain node") })
+	sidecar.OnTerminating(func(_ error) { log.add("shutdown sidecar") })
+
+	startTestOperator(t, o, log)
+	return o, log, node
+}
+
+func loggingHook(log *eventLog, event string) func(ctx context.Context) error {
+	return func(_ context.Context) error {
+		log.add(event)
+		return nil
+	}
+}
+
+func TestOperator_SafeShutdown(t *testing.T) {
+	o, log, node := newSafeShutdownTestOperator(t)
+
+	result, err := o.SafeShutdown(context.Background(), SafeShutdownOptions{
+		FinalBackup:          true,
+		BeforeNodeStop:       loggingHook(log, "before node stop"),
+		AfterMindreaderDrain: loggingHook(log, "after mindreader drain"),
+		BeforeFinalBackup:    loggingHook(log, "before final backup"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"shutdown sidecar",
+		"before node stop",
+		"stop node",
+		"drain node",
+		"after mindreader drain",
+		"before final backup",
+		"backup",
+	}, log.reset())
+
+	for _, phase := range []SafeShutdownPhase{PhaseStopAccepting, PhaseStopSidecars, PhaseStopNode, PhaseDrainMindreader, PhaseFinalBackup} {
+		assert.True(t, result.Completed(phase), "phase %s", phase)
+	}
+	assert.True(t, o.IsTerminating())
+	assert.True(t, node.IsTerminating())
+
+	again, err := o.SafeShutdown(context.Background(), SafeShutdownOptions{})
+	require.NoError(t, err)
+	assert.Same(t, result, again, "sequence only runs once")
+	assert.Empty(t, log.reset())
+}
+
+func TestOperator_ShutdownRunsSafeShutdown(t *testing.T) {
+	o, log, node := newSafeShutdownTestOperator(t)
+
+	o.Shutdown(errors.New("terminated"))
+	<-o.Terminated()
+
+	assert.Equal(t, []string{"shutdown sidecar", "stop node", "drain node"}, log.reset())
+	assert.True(t, node.IsTerminating())
+	assert.Equal(t, SafeShutdownPhaseResult{Phase: PhaseFinalBackup, Skipped: true}, o.safeShutdownResult.Phases[4], "no final backup by default")
+	assert.NoError(t, o.safeShutdownResult.Err())
+}
+
+func TestOperator_SafeShutdown_FailedPhases(t *testing.T) {
+	o, log, node := newSafeShutdownTestOperator(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	node.OnTerminating(func(_ error) { <-release })
+
+	result, err := o.SafeShutdown(context.Background(), SafeShutdownOptions{
+		DrainTimeout:   20 * time.Millisecond,
+		FinalBackup:    true,
+		BeforeNodeStop: func(_ context.Context) error { return errors.New("unable to deregister") },
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "stop_node")
+	assert.Contains(t, err.Error(), "unable to deregister")
+
+	assert.False(t, result.Completed(PhaseStopNode))
+	assert.False(t, result.Completed(PhaseDrainMindreader), "drain timed out")
+	assert.True(t, result.Completed(PhaseFinalBackup), "sequence carries on after failed phases")
+	assert.Equal(t, []string{"shutdown sidecar", "stop node", "drain node", "backup"}, log.reset())
+}
@@ -0,0 +1,165 @@
+package operator
+
+import (
+	"syscall"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// The levels of a shutdown escalation, the signal the node process exited after
+const (
+	ShutdownEscalationTerm         = "sigterm"       // exited on the SIGTERM of the superviser's `Stop`
+	ShutdownEscalationSecondSignal = "second_signal" // exited on the second signal, after the grace period
+	ShutdownEscalationKill         = "sigkill"       // killed after the kill grace period
+)
+
+// ShutdownEscalationPolicy escalates the signals sent to a node process not exiting when stopped:
+// the superviser's `Stop` terminates it (SIGTERM), `SecondSignal` is sent when it is still
+// running after `GracePeriod`, then SIGKILL after `KillGracePeriod`. Only supervisers
+// implementing `nodeManager.SignalingChainSuperviser` are escalated.
+type ShutdownEscalationPolicy struct {
+	GracePeriod     time.Duration  // defaults to 30 seconds
+	SecondSignal    syscall.Signal // defaults to SIGINT, SIGTERM sends a second SIGTERM
+	KillGracePeriod time.Duration  // defaults to `GracePeriod`
+}
+
+func (p ShutdownEscalationPolicy) gracePeriod() time.Duration {
+	if p.GracePeriod == 0 {
+		return defaultShutdownGracePeriod
+	}
+	return p.GracePeriod
+}
+
+func (p ShutdownEscalationPolicy) secondSignal() syscall.Signal {
+	if p.SecondSignal == 0 {
+		return syscall.SIGINT
+	}
+	return p.SecondSignal
+}
+
+func (p ShutdownEscalationPolicy) killGracePeriod() time.Duration {
+	if p.KillGracePeriod == 0 {
+		return p.gracePeriod()
+	}
+	return p.KillGracePeriod
+}
+
+// ShutdownEscalation describes the last stop of the node process, see `ShutdownEscalationPolicy`
+type ShutdownEscalation struct {
+	Level    string        `json:"level"` // one of the `ShutdownEscalation*` constants
+	Signals  []string      `json:"signals"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"` // when the node process exited
+}
+
+// CancellableBackupModule is implemented by backup modules able to abort a backup in progress,
+// it is cancelled when the node process is killed by a shutdown escalation, its data being left
+// in an unknown state. The backup is expected to fail once cancelled.
+type CancellableBackupModule interface {
+	BackupModule
+	CancelBackup()
+}
+
+// LastShutdownEscalation returns how the node process was last stopped with the shutdown
+// escalation policy, nil until then
+func (o *Operator) LastShutdownEscalation() *ShutdownEscalation {
+	o.shutdownEscalationLock.Lock()
+	defer o.shutdownEscalationLock.Unlock()
+
+	if o.lastShutdownEscalation == nil {
+		return nil
+	}
+	escalation := *o.lastShutdownEscalation
+	return &escalation
+}
+
+func (o *Operator) setRunningBackup(mod BackupModule) {
+	o.shutdownEscalationLock.Lock()
+	defer o.shutdownEscalationLock.Unlock()
+
+	o.runningBackup = mod
+}
+
+func (o *Operator) cancelRunningBackup() {
+	o.shutdownEscalationLock.Lock()
+	mod := o.runningBackup
+	o.shutdownEscalationLock.Unlock()
+
+	if mod == nil {
+		return
+	}
+
+	cancellable, ok := mod.(CancellableBackupModule)
+	if !ok {
+		o.zlogger.Warn("node process killed while a backup is in progress, the backup module cannot cancel it, the backup may be inconsistent")
+		return
+	}
+
+	o.zlogger.Warn("node process killed while a backup is in progress, cancelling it")
+	cancellable.CancelBackup()
+}
+
+// stopNode stops the node process, escalating its signals with the shutdown escalation policy
+func (o *Operator) stopNode() error {
+	policy := o.options.ShutdownEscalation
+	signaler, ok := o.Superviser.(nodeManager.SignalingChainSuperviser)
+	if policy == nil || !ok || !o.Superviser.IsRunning() {
+		return o.Superviser.Stop()
+	}
+
+	start := o.now()
+	done := make(chan error, 1)
+	go func() {
+		done <- o.Superviser.Stop()
+	}()
+
+	escalation := &ShutdownEscalation{Level: ShutdownEscalationTerm, Signals: []string{syscall.SIGTERM.String()}}
+	steps := []struct {
+		level  string
+		signal syscall.Signal
+		after  time.Duration
+	}{
+		{ShutdownEscalationSecondSignal, policy.secondSignal(), policy.gracePeriod()},
+		{ShutdownEscalationKill, syscall.SIGKILL, policy.killGracePeriod()},
+	}
+
+	var err error
+wait:
+	for _, step := range steps {
+		timer := time.NewTimer(step.after)
+		select {
+		case err = <-done:
+			timer.Stop()
+			break wait
+		case <-timer.C:
+		}
+
+		o.zlogger.Warn("node process still running after grace period, escalating", zap.String("level", step.level), zap.Stringer("signal", step.signal), zap.Duration("grace_period", step.after))
+		escalation.Level = step.level
+		escalation.Signals = append(escalation.Signals, step.signal.String())
+		if step.signal == syscall.SIGKILL {
+			o.cancelRunningBackup()
+		}
+		if err := signaler.Signal(step.signal); err != nil {
+			o.zlogger.Error("failed to signal node process", zap.Stringer("signal", step.signal), zap.Error(err))
+		}
+	}
+	if escalation.Level == ShutdownEscalationKill {
+		err = <-done
+	}
+
+	escalation.Time = o.now()
+	escalation.Duration = escalation.Time.Sub(start)
+	o.shutdownEscalationLock.Lock()
+	o.lastShutdownEscalation = escalation
+	o.shutdownEscalationLock.Unlock()
+
+	metrics.NodeShutdownEscalations.Inc(escalation.Level)
+	o.zlogger.Info("node process stopped", zap.String("escalation_level", escalation.Level), zap.Duration("duration", escalation.Duration))
+	return err
+}
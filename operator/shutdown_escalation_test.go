@@ -0,0 +1,162 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// slowExitSuperviser is a node process only exiting on its `exitOn`th signal, the SIGTERM of
+// `Stop` being the first one, it always exits on SIGKILL
+type slowExitSuperviser struct {
+	*fakeSuperviser
+	exitOn int
+
+	lock    sync.Mutex
+	signals []syscall.Signal
+	exited  chan struct{}
+}
+
+func newSlowExitSuperviser(exitOn int) *slowExitSuperviser {
+	s := &slowExitSuperviser{fakeSuperviser: newFakeSuperviser("node", &eventLog{}), exitOn: exitOn}
+	s.running = true
+	s.stopped = make(chan struct{})
+	s.exited = make(chan struct{})
+	return s
+}
+
+func (s *slowExitSuperviser) Stop() error {
+	s.signal(syscall.SIGTERM)
+	<-s.exited
+	return s.fakeSuperviser.Stop()
+}
+
+func (s *slowExitSuperviser) Signal(sig syscall.Signal) error {
+	s.signal(sig)
+	return nil
+}
+
+func (s *slowExitSuperviser) signal(sig syscall.Signal) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.signals = append(s.signals, sig)
+	if len(s.signals) == s.exitOn || sig == syscall.SIGKILL {
+		close(s.exited)
+	}
+}
+
+func (s *slowExitSuperviser) receivedSignals() []syscall.Signal {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.signals
+}
+
+type cancellableBackupModule struct {
+	fakeBackupModule
+	cancelled bool
+}
+
+func (m *cancellableBackupModule) CancelBackup() { m.cancelled = true }
+
+func TestOperator_ShutdownEscalation(t *testing.T) {
+	policy := &ShutdownEscalationPolicy{GracePeriod: 20 * time.Millisecond, KillGracePeriod: 40 * time.Millisecond}
+
+	tests := []struct {
+		name            string
+		exitOn          int
+		secondSignal    syscall.Signal
+		expectedLevel   string
+		expectedSignals []syscall.Signal
+		minDuration     time.Duration
+	}{
+		{"exits on sigterm", 1, 0, ShutdownEscalationTerm, []syscall.Signal{syscall.SIGTERM}, 0},
+		{"exits on sigint", 2, 0, ShutdownEscalationSecondSignal, []syscall.Signal{syscall.SIGTERM, syscall.SIGINT}, 20 * time.Millisecond},
+		{"exits on second sigterm", 2, syscall.SIGTERM, ShutdownEscalationSecondSignal, []syscall.Signal{syscall.SIGTERM, syscall.SIGTERM}, 20 * time.Millisecond},
+		{"killed", 0, 0, ShutdownEscalationKill, []syscall.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL}, 60 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newSlowExitSuperviser(test.exitOn)
+			escalation := *policy
+			escalation.SecondSignal = test.secondSignal
+			o, err := New(zap.NewNop(), node, nil, &Options{ShutdownEscalation: &escalation})
+			require.NoError(t, err)
+
+			before := testutil.ToFloat64(metrics.NodeShutdownEscalations.Native().WithLabelValues(test.expectedLevel))
+			require.NoError(t, o.stopGroup())
+
+			assert.Equal(t, test.expectedSignals, node.receivedSignals())
+			assert.False(t, node.IsRunning())
+			assert.Equal(t, before+1, testutil.ToFloat64(metrics.NodeShutdownEscalations.Native().WithLabelValues(test.expectedLevel)))
+
+			last := o.LastShutdownEscalation()
+			require.NotNil(t, last)
+			assert.Equal(t, test.expectedLevel, last.Level)
+			assert.Len(t, last.Signals, len(test.expectedSignals))
+			assert.GreaterOrEqual(t, int64(last.Duration), int64(test.minDuration))
+		})
+	}
+
+	t.Run("backup cancelled when killed", func(t *testing.T) {
+		node := newSlowExitSuperviser(0)
+		o, err := New(zap.NewNop(), node, nil, &Options{ShutdownEscalation: policy})
+		require.NoError(t, err)
+
+		mod := &cancellableBackupModule{fakeBackupModule: fakeBackupModule{log: &eventLog{}}}
+		o.setRunningBackup(mod)
+		require.NoError(t, o.stopGroup())
+		assert.True(t, mod.cancelled)
+	})
+
+	t.Run("backup not cancelled when exited", func(t *testing.T) {
+		node := newSlowExitSuperviser(2)
+		o, err := New(zap.NewNop(), node, nil, &Options{ShutdownEscalation: policy})
+		require.NoError(t, err)
+
+		mod := &cancellableBackupModule{fakeBackupModule: fakeBackupModule{log: &eventLog{}}}
+		o.setRunningBackup(mod)
+		require.NoError(t, o.stopGroup())
+		assert.False(t, mod.cancelled)
+	})
+
+	t.Run("without policy", func(t *testing.T) {
+		node := newSlowExitSuperviser(1)
+		o, err := New(zap.NewNop(), node, nil, &Options{})
+		require.NoError(t, err)
+
+		require.NoError(t, o.stopGroup())
+		assert.Nil(t, o.LastShutdownEscalation())
+	})
+}
+
+func TestOperator_ProcessStatusShutdownEscalation(t *testing.T) {
+	node := newSlowExitSuperviser(2)
+	o, err := New(zap.NewNop(), node, nil, &Options{ShutdownEscalation: &ShutdownEscalationPolicy{GracePeriod: time.Millisecond}})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	o.processHandler(rec, httptest.NewRequest("GET", "/v1/process", nil))
+	assert.NotContains(t, rec.Body.String(), "last_shutdown_escalation")
+
+	require.NoError(t, o.stopGroup())
+
+	rec = httptest.NewRecorder()
+	o.processHandler(rec, httptest.NewRequest("GET", "/v1/process", nil))
+	status := &ProcessStatus{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), status))
+	require.NotNil(t, status.LastShutdownEscalation)
+	assert.Equal(t, ShutdownEscalationSecondSignal, status.LastShutdownEscalation.Level)
+	assert.Equal(t, []string{"terminated", "interrupt"}, status.LastShutdownEscalation.Signals)
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/metrics"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+type SidecarRestartPolicy int
+
+const (
+	// SidecarRestartNever shuts down the operator when the sidecar stops unexpectedly, like
+	// it's done for the main node
+	SidecarRestartNever SidecarRestartPolicy = iota
+	// SidecarRestartProcess restarts only the sidecar that stopped unexpectedly
+	SidecarRestartProcess
+	// SidecarRestartGroup restarts the whole group (main node and all sidecars) in order
+	SidecarRestartGroup
+)
+
+func (p SidecarRestartPolicy) String() string {
+	switch p {
+	case SidecarRestartNever:
+		return "never"
+	case SidecarRestartProcess:
+		return "process"
+	case SidecarRestartGroup:
+		return "group"
+	default:
+		return "unknown"
+	}
+}
+
+// Sidecar is a secondary process supervised by the operator whose lifecycle follows the
+// main node: sidecars are started after the main node (in registration order) and stopped
+// before it (in reverse registration order).
+type Sidecar struct {
+	Name          string
+	Superviser    nodeManager.ChainSuperviser
+	RestartPolicy SidecarRestartPolicy
+
+	expectingStop *atomic.Bool
+}
+
+// RegisterSidecar adds a secondary supervised process to the group managed by the
+// operator, it must be called before `Launch`. A sidecar depends on the main node
+// and on every sidecar registered before it.
+func (o *Operator) RegisterSidecar(name string, superviser nodeManager.ChainSuperviser, restartPolicy SidecarRestartPolicy) error {
+	if name == "" {
+		return fmt.Errorf("sidecar name cannot be empty")
+	}
+
+	for _, existing := range o.sidecars {
+		if existing.Name == name {
+			return fmt.Errorf("sidecar %q is already registered", name)
+		}
+	}
+
+	o.sidecars = append(o.sidecars, &Sidecar{
+		Name:          name,
+		Superviser:    superviser,
+		RestartPolicy: restartPolicy,
+		expectingStop: atomic.NewBool(false),
+	})
+	return nil
+}
+
+func (o *Operator) startGroup(options ...nodeManager.StartOption) error {
+	if !o.Superviser.IsRunning() {
+		if err := o.Superviser.Start(options...); err != nil {
+			return fmt.Errorf("error starting chain superviser: %w", err)
+		}
+	}
+	metrics.SupervisedProcessRunning.SetUint64(1, o.Superviser.GetName())
+
+	for _, sidecar := range o.sidecars {
+		if err := o.startSidecar(sidecar); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Operator) startSidecar(sidecar *Sidecar) error {
+	if !sidecar.Superviser.IsRunning() {
+		o.zlogger.Info("starting sidecar", zap.String("sidecar", sidecar.Name))
+		if err := sidecar.Superviser.Start(); err != nil {
+			return fmt.Errorf("error starting sidecar %q: %w", sidecar.Name, err)
+		}
+	}
+
+	sidecar.expectingStop.Store(false)
+	metrics.SupervisedProcessRunning.SetUint64(1, sidecar.Name)
+	return nil
+}
+
+func (o *Operator) stopGroup() error {
+	for i := len(o.sidecars) - 1; i >= 0; i-- {
+		if err := o.stopSidecar(o.sidecars[i]); err != nil {
+			return err
+		}
+	}
+
+	err := o.stopNode()
+	if err == nil {
+		metrics.SupervisedProcessRunning.SetUint64(0, o.Superviser.GetName())
+	}
+	return err
+}
+
+func (o *Operator) stopSidecar(sidecar *Sidecar) error {
+	o.zlogger.Info("stopping sidecar", zap.String("sidecar", sidecar.Name))
+	sidecar.expectingStop.Store(true)
+	if err := sidecar.Superviser.Stop(); err != nil {
+		return fmt.Errorf("error stopping sidecar %q: %w", sidecar.Name, err)
+	}
+
+	metrics.SupervisedProcessRunning.SetUint64(0, sidecar.Name)
+	return nil
+}
+
+func (o *Operator) shutdownSidecars(err error) {
+	for i := len(o.sidecars) - 1; i >= 0; i-- {
+		sidecar := o.sidecars[i]
+		sidecar.expectingStop.Store(true)
+		if !sidecar.Superviser.IsTerminating() {
+			o.zlogger.Info("shutting down sidecar", zap.String("sidecar", sidecar.Name))
+			sidecar.Superviser.Shutdown(err)
+		}
+		<-sidecar.Superviser.Terminated()
+	}
+}
+
+func (o *Operator) notRunningSidecar() *Sidecar {
+	for _, sidecar := range o.sidecars {
+		if !sidecar.Superviser.IsRunning() {
+			return sidecar
+		}
+	}
+	return nil
+}
+
+func (o *Operator) sidecar(name string) *Sidecar {
+	for _, sidecar := range o.sidecars {
+		if sidecar.Name == name {
+			return sidecar
+		}
+	}
+	return nil
+}
+
+// watchSidecar sends a `sidecar_stopped` command to the operator each time the sidecar
+// process ends without the operator having asked for it.
+func (o *Operator) watchSidecar(sidecar *Sidecar) {
+	for {
+		stopped := sidecar.Superviser.Stopped()
+		if stopped == nil {
+			select {
+			case <-o.Terminating():
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+
+		select {
+		case <-o.Terminating():
+			return
+		case <-stopped:
+		}
+
+		if !sidecar.expectingStop.Load() && !o.IsTerminating() {
+			o.zlogger.Warn("sidecar stopped unexpectedly",
+				zap.String("sidecar", sidecar.Name),
+				zap.Int("exit_code", sidecar.Superviser.LastExitCode()),
+				zap.Stringer("restart_policy", sidecar.RestartPolicy),
+			)
+			metrics.SupervisedProcessRunning.SetUint64(0, sidecar.Name)
+			o.enqueueCommand(&Command{cmd: "sidecar_stopped", logger: o.zlogger, params: map[string]string{"name": sidecar.Name}, initiator: CommandInitiatorSidecar})
+		}
+
+		// Wait for the process to be restarted (or stopped by the operator) before watching again
+		for sidecar.Superviser.Stopped() == stopped {
+			select {
+			case <-o.Terminating():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (o *Operator) handleSidecarStopped(cmd *Command) error {
+	sidecar := o.sidecar(cmd.params["name"])
+	if sidecar == nil {
+		cmd.Return(fmt.Errorf("unknown sidecar %q", cmd.params["name"]))
+		return nil
+	}
+
+	if sidecar.Superviser.IsRunning() {
+		o.zlogger.Info("sidecar is running again, nothing to do", zap.String("sidecar", sidecar.Name))
+		return nil
+	}
+
+	switch sidecar.RestartPolicy {
+	case SidecarRestartProcess:
+		o.zlogger.Info("restarting sidecar", zap.String("sidecar", sidecar.Name))
+		return o.startSidecar(sidecar)
+
+	case SidecarRestartGroup:
+		o.zlogger.Info("restarting whole group because of sidecar", zap.String("sidecar", sidecar.Name))
+		if err := o.cleanSuperviserStop(); err != nil {
+			return err
+		}
+		return o.startGroup()
+
+	default:
+		return fmt.Errorf("sidecar %q stopped (exit code: %d)", sidecar.Name, sidecar.Superviser.LastExitCode())
+	}
+}
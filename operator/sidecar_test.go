@@ -0,0 +1,167 @@
+package operator
+
+import (
+	"sync"
+	"testing"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventLog struct {
+	sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) reset() []string {
+	l.Lock()
+	defer l.Unlock()
+	out := l.events
+	l.events = nil
+	return out
+}
+
+type fakeSuperviser struct {
+	*shutter.Shutter
+	name    string
+	log     *eventLog
+	running bool
+	stopped chan struct{}
+
+	lastSeenBlockNum uint64
+}
+
+func newFakeSuperviser(name string, log *eventLog) *fakeSuperviser {
+	return &fakeSuperviser{Shutter: shutter.New(), name: name, log: log}
+}
+
+func (s *fakeSuperviser) GetCommand() string                      { return s.name }
+func (s *fakeSuperviser) GetName() string                         { return s.name }
+func (s *fakeSuperviser) RegisterLogPlugin(_ logplugin.LogPlugin) {}
+func (s *fakeSuperviser) IsRunning() bool                         { return s.running }
+func (s *fakeSuperviser) Stopped() <-chan struct{}                { return s.stopped }
+func (s *fakeSuperviser) ServerID() (string, error)               { return s.name, nil }
+func (s *fakeSuperviser) LastExitCode() int                       { return 0 }
+func (s *fakeSuperviser) LastLogLines() []string                  { return nil }
+func (s *fakeSuperviser) LastSeenBlockNum() uint64                { return s.lastSeenBlockNum }
+func (s *fakeSuperviser) Start(_ ...nodeManager.StartOption) error {
+	s.log.add("start " + s.name)
+	s.running = true
+	s.stopped = make(chan struct{})
+	return nil
+}
+
+func (s *fakeSuperviser) Stop() error {
+	if !s.running {
+		return nil
+	}
+	s.log.add("stop " + s.name)
+	s.crash()
+	return nil
+}
+
+func (s *fakeSuperviser) crash() {
+	s.running = false
+	close(s.stopped)
+}
+
+type fakeBackupModule struct {
+	log *eventLog
+}
+
+func (m *fakeBackupModule) Backup(_ uint32) (string, error) {
+	m.log.add("backup")
+	return "backup", nil
+}
+
+func (m *fakeBackupModule) RequiresStop() bool { return true }
+
+func newSidecarTestOperator(t *testing.T, policy SidecarRestartPolicy) (*Operator, *eventLog, *fakeSuperviser, *fakeSuperviser) {
+	t.Helper()
+
+	log := &eventLog{}
+	node := newFakeSuperviser("node", log)
+	sidecar := newFakeSuperviser("sidecar", log)
+
+	o := newTestOperator(t, node, &Options{})
+	require.NoError(t, o.RegisterSidecar("sidecar", sidecar, policy))
+
+	return o, log, node, sidecar
+}
+
+func TestOperator_SidecarStartStopOrdering(t *testing.T) {
+	o, log, _, _ := newSidecarTestOperator(t, SidecarRestartNever)
+
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+	assert.Equal(t, []string{"start node", "start sidecar"}, log.reset())
+
+	require.NoError(t, o.runCommand(&Command{cmd: "maintenance", logger: o.zlogger}))
+	assert.Equal(t, []string{"stop sidecar", "stop node"}, log.reset())
+}
+
+func TestOperator_SidecarBackupStopsGroup(t *testing.T) {
+	o, log, _, _ := newSidecarTestOperator(t, SidecarRestartNever)
+	require.NoError(t, o.RegisterBackupModule("fake", &fakeBackupModule{log: log}))
+
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+	log.reset()
+
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", logger: o.zlogger}))
+	assert.Equal(t, []string{"stop sidecar", "stop node", "backup", "start node", "start sidecar"}, log.reset())
+}
+
+func TestOperator_SidecarCrash(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      SidecarRestartPolicy
+		expectError bool
+		expected    []string
+	}{
+		{"never", SidecarRestartNever, true, nil},
+		{"process", SidecarRestartProcess, false, []string{"start sidecar"}},
+		{"group", SidecarRestartGroup, false, []string{"stop node", "start node", "start sidecar"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, log, _, sidecar := newSidecarTestOperator(t, test.policy)
+
+			require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+			log.reset()
+
+			sidecar.crash()
+			err := o.runCommand(&Command{cmd: "sidecar_stopped", logger: o.zlogger, params: map[string]string{"name": "sidecar"}})
+			if test.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, log.reset())
+		})
+	}
+}
+
+func TestOperator_NodeCrashShutsDownSidecarFirst(t *testing.T) {
+	o, log, node, sidecar := newSidecarTestOperator(t, SidecarRestartProcess)
+
+	node.OnTerminating(func(_ error) { log.add("shutdown node") })
+	sidecar.OnTerminating(func(_ error) { log.add("shutdown sidecar") })
+
+	require.NoError(t, o.runCommand(&Command{cmd: "start", logger: o.zlogger}))
+	log.reset()
+
+	node.crash()
+	o.Shutdown(nil)
+	<-o.Terminated()
+
+	assert.Equal(t, []string{"shutdown sidecar", "shutdown node"}, log.reset())
+}
@@ -0,0 +1,79 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	nodeManager "github.com/streamingfast/node-manager"
+)
+
+func (o *Operator) startArgsSuperviser() (nodeManager.StartArgsChainSuperviser, error) {
+	superviser, ok := o.Superviser.(nodeManager.StartArgsChainSuperviser)
+	if !ok {
+		return nil, fmt.Errorf("superviser %q does not support changing start arguments", o.Superviser.GetName())
+	}
+	return superviser, nil
+}
+
+// SetNextStartArgs makes `mutator` change the arguments of the node process on its next launch
+// only, like a restart after a backup or a crash, for recovery flags like `--hard-replay`. It
+// applies until a launch succeeds.
+func (o *Operator) SetNextStartArgs(mutator nodeManager.StartArgsMutator) error {
+	superviser, err := o.startArgsSuperviser()
+	if err != nil {
+		return err
+	}
+
+	superviser.SetNextStartArgs(mutator)
+	return nil
+}
+
+// SetPersistentStartArgs makes `mutator` change the arguments of the node process on every
+// launch, until `RemovePersistentStartArgs` is called with `name`
+func (o *Operator) SetPersistentStartArgs(name string, mutator nodeManager.StartArgsMutator) error {
+	superviser, err := o.startArgsSuperviser()
+	if err != nil {
+		return err
+	}
+
+	superviser.SetPersistentStartArgs(name, mutator)
+	return nil
+}
+
+// RemovePersistentStartArgs removes the persistent mutator registered under `name`
+func (o *Operator) RemovePersistentStartArgs(name string) error {
+	superviser, err := o.startArgsSuperviser()
+	if err != nil {
+		return err
+	}
+
+	if !superviser.RemovePersistentStartArgs(name) {
+		return fmt.Errorf("no persistent start arguments %q", name)
+	}
+	return nil
+}
+
+// StartArgs returns the arguments of the node process, see `nodeManager.StartArgsStatus`
+func (o *Operator) StartArgs() (nodeManager.StartArgsStatus, error) {
+	superviser, err := o.startArgsSuperviser()
+	if err != nil {
+		return nodeManager.StartArgsStatus{}, err
+	}
+	return superviser.StartArgs(), nil
+}
+
+func (o *Operator) startArgsHandler(w http.ResponseWriter, _ *http.Request) {
+	status, err := o.StartArgs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	out, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
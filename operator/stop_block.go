@@ -0,0 +1,51 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+// RegisterStopBlockSetter makes the `/v1/mindreader/stop_block` HTTP endpoint change the stop
+// block of `setter` at runtime
+func (o *Operator) RegisterStopBlockSetter(setter nodeManager.StopBlockSetter) {
+	o.stopBlockSetter = setter
+}
+
+type stopBlockRequest struct {
+	StopBlock *uint64 `json:"stop_block"`
+}
+
+// stopBlockHandler sets the stop block to the `stop_block` of the JSON body, 0 clearing it
+func (o *Operator) stopBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if o.stopBlockSetter == nil {
+		http.Error(w, "no mindreader registered", http.StatusNotFound)
+		return
+	}
+
+	var request stopBlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if request.StopBlock == nil {
+		http.Error(w, "stop_block is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := o.stopBlockSetter.SetStopBlock(*request.StopBlock); err != nil {
+		http.Error(w, fmt.Sprintf("unable to set stop block: %s", err), http.StatusConflict)
+		return
+	}
+
+	o.zlogger.Info("mindreader stop block changed", zap.Uint64("stop_block", *request.StopBlock))
+	out, err := json.Marshal(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeStopBlockSetter struct {
+	stopBlocks []uint64
+	err        error
+}
+
+func (s *fakeStopBlockSetter) SetStopBlock(num uint64) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.stopBlocks = append(s.stopBlocks, num)
+	return nil
+}
+
+func postStopBlock(o *Operator, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	o.stopBlockHandler(recorder, httptest.NewRequest(http.MethodPost, "/v1/mindreader/stop_block", strings.NewReader(body)))
+	return recorder
+}
+
+func TestOperator_StopBlockHandler(t *testing.T) {
+	o := &Operator{zlogger: zap.NewNop()}
+	assert.Equal(t, http.StatusNotFound, postStopBlock(o, `{"stop_block":100}`).Code)
+
+	setter := &fakeStopBlockSetter{}
+	o.RegisterStopBlockSetter(setter)
+
+	recorder := postStopBlock(o, `{"stop_block":100}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"stop_block":100}`, recorder.Body.String())
+
+	assert.Equal(t, http.StatusOK, postStopBlock(o, `{"stop_block":0}`).Code)
+	assert.Equal(t, []uint64{100, 0}, setter.stopBlocks)
+
+	assert.Equal(t, http.StatusBadRequest, postStopBlock(o, `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postStopBlock(o, `not json`).Code)
+
+	setter.err = fmt.Errorf("stop block already reached at block 90")
+	recorder = postStopBlock(o, `{"stop_block":200}`)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "stop block already reached at block 90")
+	assert.Equal(t, []uint64{100, 0}, setter.stopBlocks)
+}
@@ -15,6 +15,9 @@
 package node_manager
 
 import (
+	"context"
+	"fmt"
+	"syscall"
 	"time"
 
 	logplugin "github.com/streamingfast/node-manager/log_plugin"
@@ -53,6 +56,127 @@ type ChainSuperviser interface {
 	LastSeenBlockNum() uint64
 }
 
+// LogPluginReplacingChainSuperviser is implemented by supervisers able to swap a registered log
+// plugin for another one between two launches of the process, see `operator.Operator.Reprocess`.
+type LogPluginReplacingChainSuperviser interface {
+	// ReplaceLogPlugin swaps `old` for `replacement`, returning false when `old` is not registered
+	ReplaceLogPlugin(old, replacement logplugin.LogPlugin) bool
+}
+
+// ExitStatusChainSuperviser is implemented by supervisers recording how the node process last
+// exited, see `ExitStatus`.
+type ExitStatusChainSuperviser interface {
+	LastExitStatus() ExitStatus
+}
+
+const (
+	ExitClassRequested = "requested" // stopped through the superviser's `Stop()`
+	ExitClassClean     = "clean"     // exited on its own with a zero exit code
+	ExitClassKilled    = "killed"    // killed by SIGKILL (or exit code 137), most often the OOM killer
+	ExitClassSignaled  = "signaled"  // killed by another signal (or exit code above 128)
+	ExitClassFailure   = "failure"   // exited on its own with a non-zero exit code
+)
+
+// ExitStatus describes how the node process last exited
+type ExitStatus struct {
+	Code      int       `json:"code"`             // -1 when killed by a signal
+	Signal    string    `json:"signal,omitempty"` // signal that killed the process, e.g. "killed", empty otherwise
+	Requested bool      `json:"requested"`        // the process was stopped through the superviser's `Stop()`
+	Time      time.Time `json:"time"`
+}
+
+// Class returns one of the `ExitClass*` constants
+func (s ExitStatus) Class() string {
+	switch {
+	case s.Requested:
+		return ExitClassRequested
+	case s.Signal == "killed" || s.Code == 137:
+		return ExitClassKilled
+	case s.Signal != "" || s.Code > 128:
+		return ExitClassSignaled
+	case s.Code == 0:
+		return ExitClassClean
+	default:
+		return ExitClassFailure
+	}
+}
+
+// Failed reports if the process crashed or exited with an error, neither asked to stop nor exited cleanly
+func (s ExitStatus) Failed() bool {
+	class := s.Class()
+	return class != ExitClassRequested && class != ExitClassClean
+}
+
+func (s ExitStatus) String() string {
+	if s.Signal != "" {
+		return fmt.Sprintf("exit code: %d, signal: %s, %s", s.Code, s.Signal, s.Class())
+	}
+	return fmt.Sprintf("exit code: %d, %s", s.Code, s.Class())
+}
+
+// SignalingChainSuperviser is implemented by supervisers able to send a signal to the running node
+// process, while `Stop` waits for it to exit, see `operator.ShutdownEscalationPolicy`
+type SignalingChainSuperviser interface {
+	// Signal sends `sig` to the node process, it does nothing when the process is not running
+	Signal(sig syscall.Signal) error
+}
+
+// DirtyStartChainSuperviser is implemented by supervisers able to tell if the node's data was
+// left in a state preventing it from starting, for example a database flagged dirty after a
+// crash, see `operator.DirtyStartPolicy`.
+type DirtyStartChainSuperviser interface {
+	IsDirty() (bool, error)
+}
+
+// StartArgsMutator returns the arguments of the node process from `args`, which it may modify
+type StartArgsMutator func(args []string) []string
+
+// StartArgsChainSuperviser is implemented by supervisers able to change the arguments of the
+// node process on its next launches, without restarting the operator, see
+// `operator.Operator.SetNextStartArgs`.
+type StartArgsChainSuperviser interface {
+	// SetNextStartArgs applies `mutator` on the next launch only. It is kept until a launch
+	// succeeds, so it applies again when the process fails to launch.
+	SetNextStartArgs(mutator StartArgsMutator)
+
+	// SetPersistentStartArgs applies `mutator` on every launch until removed, replacing the
+	// mutator already registered under `name`
+	SetPersistentStartArgs(name string, mutator StartArgsMutator)
+	RemovePersistentStartArgs(name string) bool
+
+	StartArgs() StartArgsStatus
+}
+
+// StartArgsStatus describes the arguments of the node process
+type StartArgsStatus struct {
+	Base        []string `json:"base"`              // configured arguments
+	Current     []string `json:"current,omitempty"` // arguments of the process last launched
+	Next        []string `json:"next"`              // arguments of the next launch
+	Persistent  []string `json:"persistent"`        // names of the persistent mutators, in order
+	PendingNext int      `json:"pending_next"`      // one-shot mutators waiting for a successful launch
+}
+
+// ReadinessChainSuperviser is implemented by supervisers able to tell when the node process is
+// ready after a start, the operator waits for it before leaving maintenance, see
+// `operator.Options.ReadinessTimeout`.
+type ReadinessChainSuperviser interface {
+	// WaitUntilReady returns once the node is ready, or an error once `ctx` is done or the
+	// node process stopped before being ready
+	WaitUntilReady(ctx context.Context) error
+}
+
+// ProcessStatsChainSuperviser is implemented by supervisers counting the launches of the node
+// process, see `ProcessStats`.
+type ProcessStatsChainSuperviser interface {
+	ProcessStats() ProcessStats
+}
+
+// ProcessStats describes the launches of the node process
+type ProcessStats struct {
+	StartCount    uint64    `json:"start_count"`
+	LastStartTime time.Time `json:"last_start_time"` // zero until the process is launched once
+}
+
 type MonitorableChainSuperviser interface {
 	Monitor()
 }
@@ -0,0 +1,187 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package superviser
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const (
+	readinessPollInterval = 250 * time.Millisecond
+	readinessProbeTimeout = 2 * time.Second // of a single TCP or HTTP check
+)
+
+// ReadinessProbe tells if the node process is ready, see `Superviser.AddReadinessProbe`
+type ReadinessProbe interface {
+	// Check returns nil when the node is ready, an error describing why it is not otherwise
+	Check(ctx context.Context) error
+}
+
+// AddReadinessProbe makes `WaitUntilReady` wait for `probe` to pass. Probes reading the log
+// lines, like `LogLineReadinessProbe`, are registered as log plugins.
+func (s *Superviser) AddReadinessProbe(probe ReadinessProbe) {
+	if plugin, ok := probe.(logplugin.LogPlugin); ok {
+		s.RegisterLogPlugin(plugin)
+	}
+
+	s.readinessProbesLock.Lock()
+	defer s.readinessProbesLock.Unlock()
+
+	s.readinessProbes = append(s.readinessProbes, probe)
+}
+
+func (s *Superviser) getReadinessProbes() []ReadinessProbe {
+	s.readinessProbesLock.Lock()
+	defer s.readinessProbesLock.Unlock()
+
+	return s.readinessProbes
+}
+
+// resetReadinessProbes makes the probes keeping state forget what they saw of the previous launch
+func (s *Superviser) resetReadinessProbes() {
+	for _, probe := range s.getReadinessProbes() {
+		if resetter, ok := probe.(interface{ reset() }); ok {
+			resetter.reset()
+		}
+	}
+}
+
+// WaitUntilReady returns once every readiness probe passes, checking them every 250ms. Once
+// `ctx` is done, or when the node process stops, it returns the error of the first probe not
+// passing. It returns right away without probes.
+func (s *Superviser) WaitUntilReady(ctx context.Context) error {
+	probes := s.getReadinessProbes()
+	if len(probes) == 0 {
+		return nil
+	}
+
+	stopped := s.Stopped()
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := checkReadinessProbes(ctx, probes)
+		if err == nil {
+			s.Logger.Info("node process is ready", zap.Int("probe_count", len(probes)))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node not ready (%s): %w", err, ctx.Err())
+		case <-stopped:
+			return fmt.Errorf("node process stopped before being ready: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkReadinessProbes(ctx context.Context, probes []ReadinessProbe) error {
+	for _, probe := range probes {
+		if err := probe.Check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogLineReadinessProbe passes once the node process wrote a line matching `pattern` since its
+// last launch
+func LogLineReadinessProbe(pattern *regexp.Regexp) ReadinessProbe {
+	return &logLineProbe{pattern: pattern}
+}
+
+type logLineProbe struct {
+	pattern *regexp.Regexp
+	matched atomic.Bool
+}
+
+func (p *logLineProbe) Check(_ context.Context) error {
+	if !p.matched.Load() {
+		return fmt.Errorf("no log line matching %q", p.pattern)
+	}
+	return nil
+}
+
+func (p *logLineProbe) reset() {
+	p.matched.Store(false)
+}
+
+func (p *logLineProbe) Name() string        { return "log line readiness probe" }
+func (p *logLineProbe) Launch()             {}
+func (p *logLineProbe) Stop()               {}
+func (p *logLineProbe) Shutdown(_ error)    {}
+func (p *logLineProbe) IsTerminating() bool { return false }
+func (p *logLineProbe) LogLine(line string) {
+	if !p.matched.Load() && p.pattern.MatchString(line) {
+		p.matched.Store(true)
+	}
+}
+
+// TCPReadinessProbe passes when a TCP connection to `address` (e.g. `localhost:8545`) can be
+// opened
+func TCPReadinessProbe(address string) ReadinessProbe {
+	return tcpProbe(address)
+}
+
+type tcpProbe string
+
+func (p tcpProbe) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessProbeTimeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", string(p))
+	if err != nil {
+		return fmt.Errorf("tcp port not open: %w", err)
+	}
+	return conn.Close()
+}
+
+// HTTPReadinessProbe passes when a GET of `url` answers with a 200 status
+func HTTPReadinessProbe(url string) ReadinessProbe {
+	return httpProbe(url)
+}
+
+type httpProbe string
+
+func (p httpProbe) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessProbeTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, string(p), nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("get %q: %w", string(p), err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %q: status %s", string(p), response.Status)
+	}
+	return nil
+}
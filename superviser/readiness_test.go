@@ -0,0 +1,92 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package superviser
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuperviser_WaitUntilReady_LogLine(t *testing.T) {
+	superviser := testSuperviserSh(`echo "Starting"; sleep 0.3; echo "Node is ready"; ` + infiniteScript)
+	defer superviser.Stop()
+	superviser.AddReadinessProbe(LogLineReadinessProbe(regexp.MustCompile(`is ready$`)))
+
+	require.NoError(t, superviser.Start())
+	start := time.Now()
+	require.NoError(t, superviser.WaitUntilReady(context.Background()))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(250*time.Millisecond), "not ready before the line")
+
+	require.NoError(t, superviser.Stop())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	superviser.resetReadinessProbes()
+	assert.True(t, errors.Is(superviser.WaitUntilReady(ctx), context.DeadlineExceeded), "line of the previous launch forgotten")
+}
+
+func TestSuperviser_WaitUntilReady_ProcessStopped(t *testing.T) {
+	superviser := testSuperviserSh(`echo "Starting"; sleep 0.1; exit 1`)
+	defer superviser.Stop()
+	superviser.AddReadinessProbe(LogLineReadinessProbe(regexp.MustCompile(`ready`)))
+
+	require.NoError(t, superviser.Start())
+	require.Eventually(t, func() bool { return superviser.Stopped() != nil }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := superviser.WaitUntilReady(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node process stopped before being ready")
+}
+
+func TestSuperviser_WaitUntilReady_NoProbe(t *testing.T) {
+	assert.NoError(t, testSuperviserInfinite().WaitUntilReady(context.Background()))
+}
+
+func TestTCPReadinessProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	probe := TCPReadinessProbe(listener.Addr().String())
+	assert.NoError(t, probe.Check(context.Background()))
+
+	require.NoError(t, listener.Close())
+	assert.Error(t, probe.Check(context.Background()))
+}
+
+func TestHTTPReadinessProbe(t *testing.T) {
+	ready := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	probe := HTTPReadinessProbe(server.URL + "/health")
+	assert.EqualError(t, probe.Check(context.Background()), `get "`+server.URL+`/health": status 503 Service Unavailable`)
+
+	ready = true
+	assert.NoError(t, probe.Check(context.Background()))
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package superviser
+
+import (
+	"github.com/ShinyTrinkets/overseer"
+	nodeManager "github.com/streamingfast/node-manager"
+	"go.uber.org/zap"
+)
+
+var _ nodeManager.StartArgsChainSuperviser = (*Superviser)(nil)
+
+type namedStartArgsMutator struct {
+	name    string
+	mutator nodeManager.StartArgsMutator
+}
+
+// SetNextStartArgs applies `mutator` to the arguments of the next launch of the node process,
+// after the persistent mutators. It is cleared once a launch succeeds, the process having been
+// spawned, and applies again on the next launch otherwise.
+func (s *Superviser) SetNextStartArgs(mutator nodeManager.StartArgsMutator) {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	s.nextStartArgs = append(s.nextStartArgs, mutator)
+	s.Logger.Info("one-shot start arguments registered for next launch", zap.Int("pending", len(s.nextStartArgs)))
+}
+
+// SetPersistentStartArgs applies `mutator` to the arguments of every launch of the node process
+// until `RemovePersistentStartArgs` is called with `name`. Mutators apply in the order they were
+// first registered.
+func (s *Superviser) SetPersistentStartArgs(name string, mutator nodeManager.StartArgsMutator) {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	s.Logger.Info("persistent start arguments registered", zap.String("name", name))
+	for i, persistent := range s.persistentStartArgs {
+		if persistent.name == name {
+			s.persistentStartArgs[i].mutator = mutator
+			return
+		}
+	}
+	s.persistentStartArgs = append(s.persistentStartArgs, namedStartArgsMutator{name, mutator})
+}
+
+// RemovePersistentStartArgs removes the persistent mutator `name`, returning false if there is none
+func (s *Superviser) RemovePersistentStartArgs(name string) bool {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	for i, persistent := range s.persistentStartArgs {
+		if persistent.name == name {
+			s.persistentStartArgs = append(s.persistentStartArgs[:i], s.persistentStartArgs[i+1:]...)
+			s.Logger.Info("persistent start arguments removed", zap.String("name", name))
+			return true
+		}
+	}
+	return false
+}
+
+// StartArgs returns the configured arguments of the node process, the ones of its last launch
+// and the ones its next launch will use
+func (s *Superviser) StartArgs() nodeManager.StartArgsStatus {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	s.settleNextStartArgs(s.nextStartArgsCmd)
+
+	next, _ := s.mutatedStartArgs()
+	status := nodeManager.StartArgsStatus{
+		Base:        append([]string{}, s.Arguments...),
+		Current:     s.currentStartArgs,
+		Next:        next,
+		Persistent:  []string{},
+		PendingNext: len(s.nextStartArgs),
+	}
+	for _, persistent := range s.persistentStartArgs {
+		status.Persistent = append(status.Persistent, persistent.name)
+	}
+	return status
+}
+
+// mutatedStartArgs returns the arguments of a launch and the number of one-shot mutators applied
+func (s *Superviser) mutatedStartArgs() ([]string, int) {
+	args := append([]string{}, s.Arguments...)
+	for _, persistent := range s.persistentStartArgs {
+		args = persistent.mutator(args)
+	}
+	for _, mutator := range s.nextStartArgs {
+		args = mutator(args)
+	}
+	return args, len(s.nextStartArgs)
+}
+
+// launchStartArgs returns the arguments of the next launch, the one-shot mutators they include
+// being cleared once `launched` tells the launch succeeded
+func (s *Superviser) launchStartArgs() []string {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	args, applied := s.mutatedStartArgs()
+	s.currentStartArgs = args
+	s.nextStartArgsApplied = applied
+	return args
+}
+
+// launched records `cmd` as launched with the arguments of the last `launchStartArgs` call
+func (s *Superviser) launched(cmd *overseer.Cmd) {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	s.nextStartArgsCmd = cmd
+}
+
+// settleNextStartArgs clears the one-shot mutators applied to `cmd` if it was spawned. It must be
+// called with `startArgsLock` held, it does nothing if `cmd` is not the last launched command.
+func (s *Superviser) settleNextStartArgs(cmd *overseer.Cmd) {
+	if cmd == nil || cmd != s.nextStartArgsCmd {
+		return
+	}
+
+	status := cmd.Status()
+	if status.PID == 0 {
+		if status.StopTs != 0 {
+			// Never spawned, the one-shot mutators apply again on the next launch
+			s.nextStartArgsCmd = nil
+			s.nextStartArgsApplied = 0
+		}
+		return
+	}
+
+	if s.nextStartArgsApplied > 0 {
+		s.Logger.Info("node process launched, clearing one-shot start arguments", zap.Int("cleared", s.nextStartArgsApplied))
+		s.nextStartArgs = s.nextStartArgs[s.nextStartArgsApplied:]
+	}
+	s.nextStartArgsCmd = nil
+	s.nextStartArgsApplied = 0
+}
+
+func (s *Superviser) settleNextStartArgsOf(cmd *overseer.Cmd) {
+	s.startArgsLock.Lock()
+	defer s.startArgsLock.Unlock()
+
+	s.settleNextStartArgs(cmd)
+}
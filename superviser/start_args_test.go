@@ -0,0 +1,85 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package superviser
+
+import (
+	"testing"
+	"time"
+
+	nodeManager "github.com/streamingfast/node-manager"
+	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendArgs(extra ...string) nodeManager.StartArgsMutator {
+	return func(args []string) []string {
+		return append(args, extra...)
+	}
+}
+
+func TestSuperviser_StartArgs(t *testing.T) {
+	// The arguments after the script are printed by it (the first one being its `$0`)
+	superviser := New(zlog, "sh", []string{"-c", `echo "$@"`, "sh"})
+	defer superviser.Stop()
+
+	lineChan := make(chan string, 1)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		lineChan <- line
+	}))
+
+	run := func() string {
+		t.Helper()
+
+		require.NoError(t, superviser.Start())
+		line := waitForOutput(t, lineChan, time.Second)
+		select {
+		case <-superviser.Stopped():
+		case <-time.After(5 * time.Second):
+			t.Fatal("process not stopped")
+		}
+		return line
+	}
+
+	superviser.SetPersistentStartArgs("archive", appendArgs("--archive"))
+	superviser.SetNextStartArgs(appendArgs("--hard-replay"))
+
+	status := superviser.StartArgs()
+	assert.Equal(t, []string{"-c", `echo "$@"`, "sh"}, status.Base)
+	assert.Equal(t, []string{"-c", `echo "$@"`, "sh", "--archive", "--hard-replay"}, status.Next)
+	assert.Equal(t, []string{"archive"}, status.Persistent)
+	assert.Equal(t, 1, status.PendingNext)
+
+	// A failed launch keeps the one-shot mutator
+	superviser.Binary = "/non/existent/binary"
+	require.NoError(t, superviser.Start())
+	select {
+	case <-superviser.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process not stopped")
+	}
+	assert.Equal(t, 1, superviser.StartArgs().PendingNext)
+
+	superviser.Binary = "sh"
+	assert.Equal(t, "--archive --hard-replay", run())
+	assert.Equal(t, 0, superviser.StartArgs().PendingNext)
+	assert.Equal(t, []string{"-c", `echo "$@"`, "sh", "--archive", "--hard-replay"}, superviser.StartArgs().Current)
+
+	assert.Equal(t, "--archive", run(), "one-shot mutator applied once")
+
+	assert.True(t, superviser.RemovePersistentStartArgs("archive"))
+	assert.False(t, superviser.RemovePersistentStartArgs("archive"))
+	assert.Equal(t, "", run())
+}
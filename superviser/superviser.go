@@ -15,33 +15,72 @@
 package superviser
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ShinyTrinkets/overseer"
 	nodeManager "github.com/streamingfast/node-manager"
 	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/streamingfast/node-manager/metrics"
 	"github.com/streamingfast/shutter"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
+// Superviser runs the node process, sending the lines it writes to the registered log plugins,
+// chain specific supervisers embed it.
 type Superviser struct {
 	*shutter.Shutter
 	Binary    string
 	Arguments []string
 	Logger    *zap.Logger
 
+	// Env of the node process, the environment of the current process when nil
+	Env []string
+
+	// IgnoreStderr does not send the lines the node process writes to stderr to the log plugins
+	IgnoreStderr bool
+
+	// StopTimeout kills the node process (SIGKILL) when it is still running this long after
+	// `Stop` terminated it (SIGTERM), `Stop` waits for it to exit when zero
+	StopTimeout time.Duration
+
 	cmd     *overseer.Cmd
 	cmdLock sync.Mutex
 
+	signaledCmd     *overseer.Cmd // the launched command, for `Signal`, `cmdLock` being held by `Stop` while it waits
+	signaledCmdLock sync.Mutex
+
+	stopRequested  atomic.Bool // set by `Stop()`, the next exit is classified as requested
+	lastExitStatus nodeManager.ExitStatus
+	exitStatusLock sync.Mutex
+
 	logPlugins     []logplugin.LogPlugin
 	logPluginsLock sync.RWMutex
 
 	enableDeepMind bool
+
+	startArgsLock        sync.Mutex
+	persistentStartArgs  []namedStartArgsMutator
+	nextStartArgs        []nodeManager.StartArgsMutator
+	nextStartArgsCmd     *overseer.Cmd // last launched command, until known to be spawned or not
+	nextStartArgsApplied int           // one-shot mutators applied to `nextStartArgsCmd`
+	currentStartArgs     []string
+
+	startCount    atomic.Uint64
+	lastStartTime atomic.Int64 // unix nanoseconds, 0 until the first launch
+
+	readinessProbes     []ReadinessProbe
+	readinessProbesLock sync.Mutex
 }
 
+// BaseSuperviser is the name chain specific supervisers know `Superviser` by when embedding it
+type BaseSuperviser = Superviser
+
 func New(logger *zap.Logger, binary string, arguments []string) *Superviser {
 	s := &Superviser{
 		Shutter:   shutter.New(),
@@ -74,8 +113,19 @@ func (s *Superviser) RegisterLogPlugin(plugin logplugin.LogPlugin) {
 		s.Logger.Info("adding superviser shutdown to plugins", zap.String("plugin_name", plugin.Name()))
 		shut.OnTerminating(func(err error) {
 			if !s.IsTerminating() {
-				s.Logger.Info("superviser shutting down because of a plugin", zap.String("plugin_name", plugin.Name()))
-				go s.Shutdown(err)
+				// Checked asynchronously, the plugin may terminate while the plugins lock is held
+				go func() {
+					if !s.hasLogPlugin(plugin) {
+						s.Logger.Info("ignoring termination of a replaced plugin", zap.String("plugin_name", plugin.Name()), zap.Error(err))
+						return
+					}
+
+					s.Logger.Info("superviser shutting down because of a plugin", zap.String("plugin_name", plugin.Name()), zap.Error(err))
+					if errors.Is(err, nodeManager.ErrCleanStop) {
+						err = nil
+					}
+					s.Shutdown(err)
+				}()
 			}
 		})
 	}
@@ -83,6 +133,35 @@ func (s *Superviser) RegisterLogPlugin(plugin logplugin.LogPlugin) {
 	s.Logger.Info("registered log plugin", zap.Int("plugin count", len(s.logPlugins)))
 }
 
+// ReplaceLogPlugin swaps the registered plugin `old` for `replacement`, which is launched on the
+// next start of the process. Unlike registered ones, `replacement` terminating does not shut down the
+// superviser. It returns false when `old` is not registered.
+func (s *Superviser) ReplaceLogPlugin(old, replacement logplugin.LogPlugin) bool {
+	s.logPluginsLock.Lock()
+	defer s.logPluginsLock.Unlock()
+
+	for i, plugin := range s.logPlugins {
+		if plugin == old {
+			s.logPlugins[i] = replacement
+			s.Logger.Info("replaced log plugin", zap.String("old_plugin_name", old.Name()), zap.String("new_plugin_name", replacement.Name()))
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Superviser) hasLogPlugin(plugin logplugin.LogPlugin) bool {
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
+	for _, registered := range s.logPlugins {
+		if registered == plugin {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Superviser) GetLogPlugins() []logplugin.LogPlugin {
 	s.logPluginsLock.RLock()
 	defer s.logPluginsLock.RUnlock()
@@ -91,6 +170,9 @@ func (s *Superviser) GetLogPlugins() []logplugin.LogPlugin {
 }
 
 func (s *Superviser) setDeepMindDebug(enabled bool) {
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
 	s.Logger.Info("setting deep mind debug mode", zap.Bool("enabled", enabled))
 	for _, logPlugin := range s.logPlugins {
 		if v, ok := logPlugin.(nodeManager.DeepMindDebuggable); ok {
@@ -113,7 +195,35 @@ func (s *Superviser) LastExitCode() int {
 	return 0
 }
 
+// LastExitStatus returns how the node process last exited, it is zero until the process exited once
+func (s *Superviser) LastExitStatus() nodeManager.ExitStatus {
+	s.exitStatusLock.Lock()
+	defer s.exitStatusLock.Unlock()
+
+	return s.lastExitStatus
+}
+
+func (s *Superviser) recordExitStatus(status overseer.Status) {
+	exitStatus := nodeManager.ExitStatus{
+		Code:      status.Exit,
+		Requested: s.stopRequested.Load(),
+		Time:      time.Now(),
+	}
+	if status.Error != nil && strings.HasPrefix(status.Error.Error(), "signal: ") {
+		exitStatus.Signal = strings.TrimPrefix(status.Error.Error(), "signal: ")
+	}
+
+	s.exitStatusLock.Lock()
+	s.lastExitStatus = exitStatus
+	s.exitStatusLock.Unlock()
+
+	metrics.NodeExits.Inc(exitStatus.Class())
+}
+
 func (s *Superviser) LastLogLines() []string {
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
 	if s.hasToConsolePlugin() {
 		// There is no point in showing the last log lines when the user already saw it through the to console log plugin
 		return nil
@@ -138,32 +248,42 @@ func (s *Superviser) Start(options ...nodeManager.StartOption) error {
 		}
 	}
 
+	s.logPluginsLock.RLock()
 	for _, plugin := range s.logPlugins {
 		plugin.Launch()
 	}
+	s.logPluginsLock.RUnlock()
 
 	s.cmdLock.Lock()
 	defer s.cmdLock.Unlock()
 
 	if s.cmd != nil {
-		if s.cmd.State == overseer.STARTING || s.cmd.State == overseer.RUNNING {
+		if s.cmd.IsRunningState() {
 			s.Logger.Info("underlying process already running, nothing to do")
 			return nil
 		}
 
-		if s.cmd.State == overseer.STOPPING {
+		if cmdRunning(s.cmd) {
 			s.Logger.Info("underlying process is currently stopping, waiting for it to finish")
 			<-s.cmd.Done()
 		}
 	}
 
-	s.Logger.Info("creating new command instance and launch read loop", zap.String("binary", s.Binary), zap.Strings("arguments", s.Arguments))
-	var args []interface{}
-	for _, a := range s.Arguments {
-		args = append(args, a)
+	if s.cmd != nil {
+		// The previous process exited on its own, its exit may not have been processed yet
+		s.settleNextStartArgsOf(s.cmd)
 	}
 
-	s.cmd = overseer.NewCmd(s.Binary, s.Arguments, overseer.Options{Streaming: true})
+	arguments := s.launchStartArgs()
+	s.Logger.Info("creating new command instance and launch read loop", zap.String("binary", s.Binary), zap.Strings("arguments", arguments))
+
+	s.stopRequested.Store(false)
+	s.resetReadinessProbes()
+	s.cmd = overseer.NewCmd(s.Binary, arguments, overseer.Options{Streaming: true, Env: s.Env})
+	s.setSignaledCmd(s.cmd)
+	s.launched(s.cmd)
+	s.startCount.Inc()
+	s.lastStartTime.Store(time.Now().UnixNano())
 
 	go s.start(s.cmd)
 
@@ -181,8 +301,9 @@ func (s *Superviser) Stop() error {
 		return nil
 	}
 
-	if s.cmd.State == overseer.STARTING || s.cmd.State == overseer.RUNNING {
+	if s.cmd.IsRunningState() {
 		s.Logger.Info("stopping underlying process")
+		s.stopRequested.Store(true)
 		err := s.cmd.Stop()
 		if err != nil {
 			s.Logger.Error("failed to stop overseer cmd", zap.Error(err))
@@ -190,6 +311,13 @@ func (s *Superviser) Stop() error {
 		}
 	}
 
+	var kill <-chan time.Time
+	if s.StopTimeout > 0 {
+		timer := time.NewTimer(s.StopTimeout)
+		defer timer.Stop()
+		kill = timer.C
+	}
+
 	// Blocks until command finished completely
 	s.Logger.Debug("blocking until command actually ends")
 nodeProcessDone:
@@ -197,13 +325,21 @@ nodeProcessDone:
 		select {
 		case <-s.cmd.Done():
 			break nodeProcessDone
+		case <-kill:
+			kill = nil
+			s.Logger.Warn("node process still running after stop timeout, killing it", zap.Duration("stop_timeout", s.StopTimeout))
+			if err := s.cmd.Signal(syscall.SIGKILL); err != nil {
+				s.Logger.Error("failed to kill node process", zap.Error(err))
+			}
 		case <-time.After(500 * time.Millisecond):
 			s.Logger.Debug("still blocking until command actually ends")
 		}
 	}
 
 	s.Logger.Info("node process has been terminated")
+	s.settleNextStartArgsOf(s.cmd)
 	s.cmd = nil
+	s.setSignaledCmd(nil)
 
 	s.Logger.Info("waiting for std out and err to drain")
 	sleepTime := time.Duration(0)
@@ -222,6 +358,39 @@ nodeProcessDone:
 	return nil
 }
 
+func (s *Superviser) setSignaledCmd(cmd *overseer.Cmd) {
+	s.signaledCmdLock.Lock()
+	defer s.signaledCmdLock.Unlock()
+
+	s.signaledCmd = cmd
+}
+
+var _ nodeManager.SignalingChainSuperviser = (*Superviser)(nil)
+
+// Signal sends `sig` to the process group of the node process, it does nothing when the process
+// is not running. It can be called while `Stop` waits for the process to exit.
+func (s *Superviser) Signal(sig syscall.Signal) error {
+	s.signaledCmdLock.Lock()
+	cmd := s.signaledCmd
+	s.signaledCmdLock.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	s.Logger.Info("sending signal to node process", zap.Stringer("signal", sig))
+	return cmd.Signal(sig)
+}
+
+// ProcessStats returns how many times the node process was launched, and when last
+func (s *Superviser) ProcessStats() nodeManager.ProcessStats {
+	stats := nodeManager.ProcessStats{StartCount: s.startCount.Load()}
+	if lastStart := s.lastStartTime.Load(); lastStart != 0 {
+		stats.LastStartTime = time.Unix(0, lastStart)
+	}
+	return stats
+}
+
 func (s *Superviser) IsRunning() bool {
 	s.cmdLock.Lock()
 	defer s.cmdLock.Unlock()
@@ -234,7 +403,13 @@ func (s *Superviser) isRunning() bool {
 	if s.cmd == nil {
 		return false
 	}
-	return s.cmd.State == overseer.STARTING || s.cmd.State == overseer.RUNNING || s.cmd.State == overseer.STOPPING
+	return cmdRunning(s.cmd)
+}
+
+// cmdRunning reports if `cmd` is starting, running or stopping. Its state is written by the
+// overseer goroutines, it must only be read through the accessors taking its lock.
+func cmdRunning(cmd *overseer.Cmd) bool {
+	return !cmd.IsInitialState() && !cmd.IsFinalState()
 }
 
 func (s *Superviser) isBufferEmpty() bool {
@@ -252,6 +427,8 @@ func (s *Superviser) start(cmd *overseer.Cmd) {
 		select {
 		case status := <-statusChan:
 			processTerminated = true
+			s.recordExitStatus(status)
+			s.settleNextStartArgsOf(cmd)
 			if status.Exit == 0 {
 				s.Logger.Info("command terminated with zero status", zap.Int("stdout_len", len(cmd.Stdout)), zap.Int("stderr_len", len(cmd.Stderr)))
 			} else {
@@ -261,11 +438,15 @@ func (s *Superviser) start(cmd *overseer.Cmd) {
 		case line := <-cmd.Stdout:
 			s.processLogLine(line)
 		case line := <-cmd.Stderr:
-			s.processLogLine(line)
+			if !s.IgnoreStderr {
+				s.processStderrLogLine(line)
+			}
 		}
 		if processTerminated {
-			s.Logger.Info("node process terminated", zap.Bool("buffer_empty", s.isBufferEmpty()))
-			if s.isBufferEmpty() {
+			// Checking `cmd` and not `s.cmd`, it may already be replaced by a new launch
+			bufferEmpty := len(cmd.Stdout) == 0 && len(cmd.Stderr) == 0
+			s.Logger.Info("node process terminated", zap.Bool("buffer_empty", bufferEmpty))
+			if bufferEmpty {
 				return
 			}
 		}
@@ -305,6 +486,20 @@ func (s *Superviser) processLogLine(line string) {
 	}
 }
 
+func (s *Superviser) processStderrLogLine(line string) {
+	s.logPluginsLock.Lock()
+	defer s.logPluginsLock.Unlock()
+
+	for _, plugin := range s.logPlugins {
+		if v, ok := plugin.(logplugin.StderrLogPlugin); ok {
+			v.LogLineStderr(line)
+			continue
+		}
+		plugin.LogLine(line)
+	}
+}
+
+// hasToConsolePlugin must be called with the log plugins lock held
 func (s *Superviser) hasToConsolePlugin() bool {
 	for _, plugin := range s.logPlugins {
 		if _, ok := plugin.(*logplugin.ToConsoleLogPlugin); ok {
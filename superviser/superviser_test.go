@@ -15,13 +15,19 @@
 package superviser
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/streamingfast/logging"
+	nodeManager "github.com/streamingfast/node-manager"
 	logplugin "github.com/streamingfast/node-manager/log_plugin"
+	"github.com/streamingfast/shutter"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -107,6 +113,78 @@ func TestSuperviser_CapturesStdoutCorrectly(t *testing.T) {
 	assert.Equal(t, []string{"first", "second"}, lines)
 }
 
+type stderrLogPluginFunc struct {
+	logplugin.LogPluginFunc
+	stderr func(line string)
+}
+
+func (f stderrLogPluginFunc) LogLineStderr(line string) { f.stderr(line) }
+
+func TestSuperviser_CapturesStderrSeparately(t *testing.T) {
+	superviser := testSuperviserSh("echo out; sleep 0.1; echo err >&2")
+	defer superviser.Stop()
+
+	lineChan := make(chan string, 2)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		lineChan <- line
+	}))
+
+	classifiedChan := make(chan string, 2)
+	superviser.RegisterLogPlugin(stderrLogPluginFunc{
+		LogPluginFunc: func(line string) { classifiedChan <- "stdout " + line },
+		stderr:        func(line string) { classifiedChan <- "stderr " + line },
+	})
+
+	go superviser.Start()
+	waitForSuperviserTaskCompletion(superviser)
+
+	assert.Equal(t, []string{"out", "err"}, []string{waitForOutput(t, lineChan, waitDefaultTimeout), waitForOutput(t, lineChan, waitDefaultTimeout)})
+	assert.Equal(t, []string{"stdout out", "stderr err"}, []string{waitForOutput(t, classifiedChan, waitDefaultTimeout), waitForOutput(t, classifiedChan, waitDefaultTimeout)})
+}
+
+func TestSuperviser_LastExitStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		script         string
+		stop           bool
+		expectedCode   int
+		expectedSignal string
+		expectedClass  string
+	}{
+		{"clean", "exit 0", false, 0, "", nodeManager.ExitClassClean},
+		{"failure", "exit 3", false, 3, "", nodeManager.ExitClassFailure},
+		{"killed", "kill -9 $$", false, -1, "killed", nodeManager.ExitClassKilled},
+		{"signaled", "kill -11 $$", false, -1, "segmentation fault", nodeManager.ExitClassSignaled},
+		{"requested", infiniteScript, true, -1, "terminated", nodeManager.ExitClassRequested},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			superviser := testSuperviserSh(test.script)
+			defer superviser.Stop()
+
+			require.NoError(t, superviser.Start())
+			stopped := superviser.Stopped()
+			if test.stop {
+				require.Eventually(t, superviser.IsRunning, time.Second, 10*time.Millisecond)
+				require.NoError(t, superviser.Stop())
+			}
+
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("process not stopped")
+			}
+
+			require.Eventually(t, func() bool { return !superviser.LastExitStatus().Time.IsZero() }, time.Second, 10*time.Millisecond)
+			status := superviser.LastExitStatus()
+			assert.Equal(t, test.expectedCode, status.Code)
+			assert.Equal(t, test.expectedSignal, status.Signal)
+			assert.Equal(t, test.expectedClass, status.Class())
+		})
+	}
+}
+
 func testSuperviserBash(script string) *Superviser {
 	return New(zlog, "bash", []string{"-c", script})
 }
@@ -135,3 +213,152 @@ func waitForOutput(t *testing.T, lineChan chan string, timeout time.Duration) (l
 	// Will fail before reaching this line
 	return ""
 }
+
+type shutterLogPlugin struct {
+	*shutter.Shutter
+}
+
+func (p *shutterLogPlugin) Name() string        { return "shutter log plugin" }
+func (p *shutterLogPlugin) Launch()             {}
+func (p *shutterLogPlugin) LogLine(line string) {}
+func (p *shutterLogPlugin) Stop()               {}
+
+func TestSuperviser_PluginShutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		pluginErr   error
+		expectedErr error
+	}{
+		{"clean stop", fmt.Errorf("stop block reached: %w", nodeManager.ErrCleanStop), nil},
+		{"failure", errors.New("boom"), errors.New("boom")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			superviser := testSuperviserInfinite()
+			plugin := &shutterLogPlugin{Shutter: shutter.New()}
+			superviser.RegisterLogPlugin(plugin)
+
+			plugin.Shutdown(test.pluginErr)
+			select {
+			case <-superviser.Terminated():
+			case <-time.After(waitDefaultTimeout):
+				t.Fatal("superviser not shut down")
+			}
+
+			assert.Equal(t, test.expectedErr, superviser.Err())
+		})
+	}
+}
+
+func TestSuperviser_ReplaceLogPlugin(t *testing.T) {
+	superviser := testSuperviserInfinite()
+	original := &shutterLogPlugin{Shutter: shutter.New()}
+	replacement := &shutterLogPlugin{Shutter: shutter.New()}
+	superviser.RegisterLogPlugin(original)
+
+	assert.False(t, superviser.ReplaceLogPlugin(replacement, original))
+	require.True(t, superviser.ReplaceLogPlugin(original, replacement))
+	assert.Equal(t, []logplugin.LogPlugin{replacement}, superviser.GetLogPlugins())
+
+	// Neither the replaced plugin nor its replacement shut down the superviser
+	original.Shutdown(errors.New("boom"))
+	replacement.Shutdown(errors.New("boom"))
+	select {
+	case <-superviser.Terminating():
+		t.Fatal("superviser shut down by a plugin no longer registered")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSuperviser_Env(t *testing.T) {
+	superviser := testSuperviserSh(`echo "value=$NODE_MANAGER_TEST"`)
+	superviser.Env = []string{"NODE_MANAGER_TEST=from env"}
+	defer superviser.Stop()
+
+	lineChan := make(chan string, 1)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		lineChan <- line
+	}))
+
+	require.NoError(t, superviser.Start())
+	assert.Equal(t, "value=from env", waitForOutput(t, lineChan, waitDefaultTimeout))
+}
+
+func TestSuperviser_StopTimeout(t *testing.T) {
+	superviser := testSuperviserSh(`trap '' TERM; echo "Starting"; while true; do sleep 0.05; done`)
+	superviser.StopTimeout = 200 * time.Millisecond
+
+	lineChan := make(chan string, 1)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		select {
+		case lineChan <- line:
+		default:
+		}
+	}))
+
+	require.NoError(t, superviser.Start())
+	waitForOutput(t, lineChan, waitDefaultTimeout)
+
+	start := time.Now()
+	require.NoError(t, superviser.Stop())
+	assert.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	assert.False(t, superviser.IsRunning())
+
+	require.Eventually(t, func() bool { return !superviser.LastExitStatus().Time.IsZero() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "killed", superviser.LastExitStatus().Signal)
+}
+
+func TestSuperviser_SignalWhileStopping(t *testing.T) {
+	superviser := testSuperviserSh(`trap '' TERM; trap 'echo "Interrupted"; exit 3' INT; echo "Starting"; while true; do sleep 0.05; done`)
+
+	lineChan := make(chan string, 2)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		select {
+		case lineChan <- line:
+		default:
+		}
+	}))
+
+	require.NoError(t, superviser.Signal(syscall.SIGINT), "nothing to signal before start")
+	require.NoError(t, superviser.Start())
+	assert.Equal(t, "Starting", waitForOutput(t, lineChan, waitDefaultTimeout))
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- superviser.Stop()
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("node process exited on SIGTERM")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, superviser.Signal(syscall.SIGINT))
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(waitDefaultTimeout):
+		t.Fatal("node process not stopped after SIGINT")
+	}
+	assert.Equal(t, "Interrupted", waitForOutput(t, lineChan, waitDefaultTimeout))
+	assert.False(t, superviser.IsRunning())
+}
+
+func TestSuperviser_ProcessStats(t *testing.T) {
+	superviser := testSuperviserInfinite()
+	defer superviser.Stop()
+	assert.Equal(t, nodeManager.ProcessStats{}, superviser.ProcessStats())
+
+	before := time.Now()
+	for i := 0; i < 2; i++ {
+		require.NoError(t, superviser.Start())
+		require.Eventually(t, superviser.IsRunning, time.Second, 10*time.Millisecond)
+		require.NoError(t, superviser.Stop())
+	}
+
+	stats := superviser.ProcessStats()
+	assert.Equal(t, uint64(2), stats.StartCount)
+	assert.False(t, stats.LastStartTime.Before(before))
+}
@@ -14,6 +14,132 @@
 
 package node_manager
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCleanStop is wrapped by the errors of log plugins shutting down on purpose, like the
+// mindreader reaching its stop block. The superviser then shuts down with a nil error.
+var ErrCleanStop = errors.New("clean stop")
+
 type DeepMindDebuggable interface {
 	DebugDeepMind(enabled bool)
 }
+
+// PushRateLimitSetter is implemented by components limiting the rate at which blocks are
+// pushed downstream, a limit of 0 means unlimited.
+type PushRateLimitSetter interface {
+	SetPushRateLimit(blocksPerSecond, bytesPerSecond float64) error
+}
+
+// BlocksDroppedNotifier is implemented by components dropping the blocks they could not archive
+// in time on shutdown, `f` is called with the error describing the blocks dropped.
+type BlocksDroppedNotifier interface {
+	OnBlocksDropped(f func(err error))
+}
+
+// StopBlockSetter is implemented by components stopping at a block that can be changed at
+// runtime, a stop block of 0 means no stop block.
+type StopBlockSetter interface {
+	SetStopBlock(num uint64) error
+}
+
+// UploadPauser is implemented by components uploading the files of a working directory, uploads
+// are paused while the directory is backed up so it stays stable. `PauseUploads` returns once
+// the in-flight uploads completed.
+type UploadPauser interface {
+	PauseUploads()
+	ResumeUploads()
+}
+
+// ContinuityCheckerResetter is implemented by components checking the continuity of the blocks
+// produced by the node, the checker needs to be reset when the node data is restored.
+type ContinuityCheckerResetter interface {
+	ResetContinuityChecker()
+}
+
+// ContinuityCheckerState is implemented by components checking the continuity of the blocks
+// produced by the node, it reports the highest block number written through the checker, 0 if none.
+type ContinuityCheckerState interface {
+	HighestContinuousBlockNum() uint64
+}
+
+// ContinuityCheckerReconciler is implemented by components checking the continuity of the blocks
+// produced by the node, it rewrites the state of the checker to the highest contiguous block
+// found in the one block files of the archive store, see `ContinuityReconciliation`.
+type ContinuityCheckerReconciler interface {
+	ReconcileContinuityChecker(ctx context.Context) (*ContinuityReconciliation, error)
+}
+
+// ContinuityReconciliation describes the one block files found in the archive store when
+// reconciling the continuity checker. Only the blocks not merged yet have one block files, the
+// lowest one found is the start of the contiguous range.
+type ContinuityReconciliation struct {
+	Time   time.Time `json:"time"`
+	Suffix string    `json:"suffix"` // suffix of the one block files considered
+
+	PreviousHighestBlock   uint64 `json:"previous_highest_block"`   // highest block of the checker before reconciling
+	HighestContiguousBlock uint64 `json:"highest_contiguous_block"` // new highest block of the checker, 0 without one block file
+	LowestBlock            uint64 `json:"lowest_block"`             // 0 without one block file
+	HighestBlock           uint64 `json:"highest_block"`            // 0 without one block file
+	BlockCount             int    `json:"block_count"`              // distinct block numbers found
+
+	Holes []BlockHole `json:"holes,omitempty"`
+}
+
+// BlockHole is a range of missing blocks, `Start` to `Stop` inclusively
+type BlockHole struct {
+	Start uint64 `json:"start"`
+	Stop  uint64 `json:"stop"`
+}
+
+// MindreaderStatus is a snapshot of the state of the mindreader, alerting on `BufferedBlocks`
+// approaching `ChannelCapacity` catches an archiving side not keeping up with the node.
+type MindreaderStatus struct {
+	LastStoredBlockNum  uint64    `json:"last_stored_block_num"`  // last block stored by the archiver, 0 if none
+	LastStoredBlockID   string    `json:"last_stored_block_id"`   // empty if none
+	LastStoredBlockTime time.Time `json:"last_stored_block_time"` // zero if none or unknown
+
+	BufferedBlocks  int `json:"buffered_blocks"`  // blocks waiting in the blocks channel to be stored
+	ChannelCapacity int `json:"channel_capacity"` // capacity of the blocks channel
+
+	BufferedBytes    int64 `json:"buffered_bytes"`     // payload bytes of the blocks read and not yet stored, 0 without `MaxBufferedBytes`
+	MaxBufferedBytes int64 `json:"max_buffered_bytes"` // see `mindreader.WithMaxBufferedBytes`, 0 if not set
+
+	PendingUploads       int `json:"pending_uploads"`        // one block files in the working directory waiting to be uploaded
+	PendingMergedUploads int `json:"pending_merged_uploads"` // merged blocks files in the working directory waiting to be uploaded
+	QuarantinedUploads   int `json:"quarantined_uploads"`    // files that failed to upload too many times, see `mindreader.WithUploadQuarantine`
+
+	ContinuityCheckerActive bool   `json:"continuity_checker_active"` // false without a checker or once it failed
+	ContinuityHighWatermark uint64 `json:"continuity_high_watermark"` // highest block written to the checker, 0 if none
+
+	LastBlockReadTime  time.Time     `json:"last_block_read_time"`  // when the last block was read from the node, zero if none
+	SinceLastBlockRead time.Duration `json:"since_last_block_read"` // 0 if no block was read
+
+	DroppedLines uint64 `json:"dropped_lines"` // node output lines dropped by the async log line queue when full
+}
+
+// MindreaderStatusProvider is implemented by the mindreader, its status is served by the operator
+// on `/v1/mindreader/status`. `Status` is safe to call concurrently with the read flow.
+type MindreaderStatusProvider interface {
+	Status() MindreaderStatus
+}
+
+// MaintenanceRequester is the callback used by components that need the managed node
+// to be put in maintenance. The `reason` is kept in the operator's maintenance history
+// while `source` identifies the requesting component (see `MaintenanceSource*` constants).
+type MaintenanceRequester func(reason string, source string) error
+
+const (
+	MaintenanceSourceMindreaderReadError = "mindreader_read_error"
+	MaintenanceSourceContinuityCheck     = "continuity_check"
+	MaintenanceSourceBackupSchedule      = "backup_schedule"
+	MaintenanceSourceManual              = "manual"
+	MaintenanceSourceStderrClassifier    = "stderr_classifier"
+	MaintenanceSourceCrashLoop           = "crash_loop"
+	MaintenanceSourceDiskSpace           = "disk_space"
+	MaintenanceSourceResumePointCheck    = "resume_point_check"
+	MaintenanceSourceReprocess           = "reprocess"
+)